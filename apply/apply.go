@@ -0,0 +1,359 @@
+package apply
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/magnickolas/gitok/attr"
+	"github.com/magnickolas/gitok/convert"
+	"github.com/magnickolas/gitok/diff"
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/index"
+	"github.com/magnickolas/gitok/index/parser"
+	"github.com/magnickolas/gitok/quote"
+)
+
+var (
+	ErrorRejectWithThreeWay         = errors.New("options '--reject' and '--3way' cannot be used together")
+	ErrorNoValidPatches             = errors.New("No valid patches in input (allow with \"--allow-empty\")")
+	ErrorUnrecognizedWSOption       = errors.New("unrecognized whitespace option")
+	formatErrorUnrecognizedWSOption = func(option string) error {
+		return fmt.Errorf("%w '%v'", ErrorUnrecognizedWSOption, option)
+	}
+	ErrorWhitespace       = errors.New("whitespace errors")
+	formatErrorWhitespace = func(n int) error {
+		return fmt.Errorf("%d %v %w.", n, diff.Plural(n, "line adds", "lines add"), ErrorWhitespace)
+	}
+	// reported to errW already, failing the patch rather than the command
+	errPatchFailed = errors.New("patch failed")
+)
+
+// What to do about whitespace errors the patch adds
+type WSAction int
+
+const (
+	// warn when applying and stay silent when only checking
+	WSDefault WSAction = iota
+	WSNoWarn
+	WSWarn
+	// refuse to apply
+	WSError
+	// the same without limiting the errors shown
+	WSErrorAll
+	WSFix
+)
+
+// Whitespace errors shown before the rest are only counted
+const squelchWSErrors = 5
+
+// Parses the value of --whitespace or apply.whitespace
+func ParseWSAction(option string) (WSAction, error) {
+	switch option {
+	case "warn":
+		return WSWarn, nil
+	case "nowarn":
+		return WSNoWarn, nil
+	case "error":
+		return WSError, nil
+	case "error-all":
+		return WSErrorAll, nil
+	case "fix", "strip":
+		return WSFix, nil
+	}
+	return WSDefault, formatErrorUnrecognizedWSOption(option)
+}
+
+type Verbosity int
+
+const (
+	VerbosityQuiet Verbosity = iota - 1
+	VerbosityNormal
+	VerbosityVerbose
+)
+
+type Options struct {
+	// apply to the index only (--cached) or to both the index and the
+	// worktree, which must match it (--index)
+	Cached bool
+	Index  bool
+	// only see whether the patches apply
+	Check bool
+	// fall back to a 3-way merge with the blobs the patches name
+	ThreeWay bool
+	// labels of the sides of conflicts, "ours" and "theirs" if empty
+	Ours    string
+	Theirs  string
+	Reverse bool
+	// apply the hunks that do and leave the rest in .rej files
+	Reject bool
+	// leading path components to remove, -1 to guess for traditional
+	// patches and remove one from git ones
+	Strip int
+	// lines of context that must match at least, -1 for all of them
+	Context           int
+	Whitespace        WSAction
+	IgnoreSpaceChange bool
+	Verbosity         Verbosity
+	// succeed on input without patches
+	AllowEmpty bool
+	// the patches were made without context, so hunks at the start or
+	// end of the file need not match there
+	UnidiffZero bool
+	// core.whitespace
+	WSRule        WSRule
+	TrustFileMode bool
+	// filters for worktree files and the attributes giving whitespace
+	// rules of paths; either may be nil
+	Converter *convert.Converter
+	Attrs     *attr.Matcher
+}
+
+// A patch file, named as in messages
+type Input struct {
+	Name    string
+	Content []byte
+}
+
+// Markers of files the fn table tracks besides the patches leaving them
+var (
+	wasDeleted  = &patch{}
+	toBeDeleted = &patch{}
+)
+
+type state struct {
+	errW io.Writer
+	db   *fs.ObjectDB
+	opts Options
+
+	strip      int
+	stripKnown bool
+	// line of the input being parsed
+	linenr    int
+	inputName string
+
+	apply       bool
+	checkIndex  bool
+	updateIndex bool
+	idx         *parser.Index
+
+	wsAction WSAction
+	// whitespace errors found and lines added after fixing them
+	wsErrors int
+	fixedWS  int
+	squelch  int
+
+	// results of the patches by the names they leave, or the markers
+	fnTable map[string]*patch
+}
+
+// Applies the patches of the inputs to the worktree and/or the index as
+// the options ask. Problems with the patches go to errW and make the
+// result false, leaving the files they touch alone unless rejected hunks
+// are asked for
+func Apply(errW io.Writer, db *fs.ObjectDB, inputs []Input, opts Options) (bool, error) {
+	if opts.Reject && opts.ThreeWay {
+		return false, ErrorRejectWithThreeWay
+	}
+	s := &state{
+		errW:       errW,
+		db:         db,
+		opts:       opts,
+		strip:      1,
+		stripKnown: opts.Strip >= 0,
+		linenr:     1,
+		apply:      true,
+		checkIndex: opts.Index || opts.ThreeWay || opts.Cached,
+		wsAction:   opts.Whitespace,
+		squelch:    squelchWSErrors,
+	}
+	if s.stripKnown {
+		s.strip = opts.Strip
+	}
+	if opts.Reject && opts.Verbosity == VerbosityNormal {
+		s.opts.Verbosity = VerbosityVerbose
+	}
+	if opts.Check {
+		s.apply = false
+	}
+	if s.wsAction == WSDefault {
+		s.wsAction = WSNoWarn
+		if s.apply {
+			s.wsAction = WSWarn
+		}
+	}
+	if s.wsAction == WSErrorAll {
+		s.squelch = 0
+	}
+	ok := true
+	for _, input := range inputs {
+		res, err := s.applyPatch(input)
+		if err != nil {
+			return false, err
+		}
+		if res < 0 {
+			return false, nil
+		}
+		if res > 0 {
+			ok = false
+		}
+	}
+	if s.wsErrors > 0 {
+		if s.squelch > 0 && s.squelch < s.wsErrors {
+			n := s.wsErrors - s.squelch
+			s.warnf("squelched %d whitespace %v", n, diff.Plural(n, "error", "errors"))
+		}
+		switch {
+		case s.wsAction == WSError || s.wsAction == WSErrorAll:
+			return false, formatErrorWhitespace(s.wsErrors)
+		case s.fixedWS > 0 && s.apply:
+			s.warnf("%d %v applied after fixing whitespace errors.", s.fixedWS, diff.Plural(s.fixedWS, "line", "lines"))
+		default:
+			s.warnf("%d %v whitespace errors.", s.wsErrors, diff.Plural(s.wsErrors, "line adds", "lines add"))
+		}
+	}
+	if s.updateIndex {
+		if err := index.Write(s.idx); err != nil {
+			return false, err
+		}
+	}
+	return ok, nil
+}
+
+// Parses and applies the patches of one input. The result is negative if
+// they failed in a way that stops the rest and positive if the others may
+// still be applied
+func (s *state) applyPatch(input Input) (int, error) {
+	s.inputName = input.Name
+	buf := string(input.Content)
+	var patches []*patch
+	for offset := 0; offset < len(buf); {
+		p := &patch{}
+		n, err := s.parseChunk(buf[offset:], p)
+		if err != nil {
+			return 0, err
+		}
+		if n < 0 {
+			break
+		}
+		// reversed patches undo the changes in the opposite order
+		if s.opts.Reverse {
+			p.reverse()
+			patches = append([]*patch{p}, patches...)
+		} else {
+			patches = append(patches, p)
+		}
+		offset += n
+	}
+	if len(patches) == 0 {
+		if !s.opts.AllowEmpty {
+			return 0, ErrorNoValidPatches
+		}
+		return 0, nil
+	}
+	if s.wsErrors > 0 && (s.wsAction == WSError || s.wsAction == WSErrorAll) {
+		s.apply = false
+	}
+	s.updateIndex = s.checkIndex && s.apply
+	if s.checkIndex && s.idx == nil {
+		var err error
+		if s.idx, err = index.Read(); err != nil {
+			return 0, err
+		}
+	}
+	if s.opts.Check || s.apply {
+		ok, err := s.checkPatchList(patches)
+		if err != nil {
+			return 0, err
+		}
+		if !ok && !s.opts.Reject {
+			return -1, nil
+		}
+	}
+	if s.apply {
+		errs, err := s.writeOutResults(patches)
+		if err != nil {
+			return 0, err
+		}
+		// the index is still written for conflicts of --3way
+		if errs {
+			if s.opts.Reject {
+				return -1, nil
+			}
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// Turns the patch into one undoing it
+func (p *patch) reverse() {
+	p.oldName, p.newName = p.newName, p.oldName
+	p.oldMode, p.newMode = p.newMode, p.oldMode
+	p.isNew, p.isDelete = p.isDelete, p.isNew
+	p.linesAdded, p.linesDeleted = p.linesDeleted, p.linesAdded
+	p.oldDigest, p.newDigest = p.newDigest, p.oldDigest
+	for _, f := range p.fragments {
+		f.oldPos, f.newPos = f.newPos, f.oldPos
+		f.oldLines, f.newLines = f.newLines, f.oldLines
+	}
+}
+
+// Whitespace rules for the path as its attributes tell
+func (s *state) wsRuleFor(name string) (WSRule, error) {
+	if s.opts.Attrs == nil {
+		return s.opts.WSRule, nil
+	}
+	value, err := s.opts.Attrs.Get(name, "whitespace")
+	if err != nil {
+		return 0, err
+	}
+	return wsRuleFor(s.opts.WSRule, value, func(msg string) { s.warnf("%v", msg) })
+}
+
+// Looks for whitespace errors in a line of a hunk
+func (s *state) checkWhitespace(line string, rule WSRule) {
+	line = line[1:]
+	s.recordWSError(rule.check(line), line[:len(line)-1], s.linenr)
+}
+
+func (s *state) recordWSError(result WSRule, line string, linenr int) {
+	if result == 0 {
+		return
+	}
+	s.wsErrors += 1
+	if s.squelch > 0 && s.squelch < s.wsErrors {
+		return
+	}
+	s.say("%v:%d: %v.\n%v\n", s.inputName, linenr, result, line)
+}
+
+// Prints an informational message unless quiet
+func (s *state) say(format string, args ...any) {
+	if s.opts.Verbosity > VerbosityQuiet {
+		fmt.Fprintf(s.errW, format, args...)
+	}
+}
+
+// Reports a problem failing the patch
+func (s *state) errorf(format string, args ...any) error {
+	s.say("error: "+format+"\n", args...)
+	return errPatchFailed
+}
+
+func (s *state) warnf(format string, args ...any) {
+	s.say("warning: "+format+"\n", args...)
+}
+
+// Prints the format with the name of the patch, or both of them if it
+// renames or copies
+func (s *state) sayPatchName(format string, p *patch) {
+	name := quote.CQuote(p.newName)
+	switch {
+	case p.oldName != "" && p.newName != "" && p.oldName != p.newName:
+		name = quote.CQuote(p.oldName) + " => " + quote.CQuote(p.newName)
+	case p.newName == "":
+		name = quote.CQuote(p.oldName)
+	}
+	s.say(format+"\n", name)
+}
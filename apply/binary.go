@@ -0,0 +1,182 @@
+package apply
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/magnickolas/gitok/base85"
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/repr"
+)
+
+var (
+	ErrorUnrecognizedBinaryPatch       = errors.New("unrecognized binary patch")
+	formatErrorUnrecognizedBinaryPatch = func(linenr int) error {
+		return fmt.Errorf("%w at line %d", ErrorUnrecognizedBinaryPatch, linenr)
+	}
+	ErrorCorruptBinaryPatch       = errors.New("corrupt binary patch")
+	formatErrorCorruptBinaryPatch = func(linenr int, line string) error {
+		return fmt.Errorf("%w at line %d: %v", ErrorCorruptBinaryPatch, linenr, line)
+	}
+)
+
+// Parses a binary hunk: a "literal <size>" or "delta <size>" line, lines
+// of base-85 deflated data and an empty line. Returns nil if buf does not
+// start with one
+func (s *state) parseBinaryHunk(buf string) (*fragment, int, error) {
+	n := lineLen(buf)
+	used := n
+	f := &fragment{}
+	var size string
+	if rest, found := strings.CutPrefix(buf, "delta "); found {
+		f.delta, size = true, rest
+	} else if rest, found := strings.CutPrefix(buf, "literal "); found {
+		size = rest
+	} else {
+		return nil, 0, nil
+	}
+	digits := 0
+	for digits < len(size) && isDigit(size[digits]) {
+		digits += 1
+	}
+	origLen, _ := strconv.Atoi(size[:digits])
+	s.linenr += 1
+	buf = buf[n:]
+	var data []byte
+	for {
+		n = lineLen(buf)
+		used += n
+		s.linenr += 1
+		if n == 1 {
+			break
+		}
+		corrupt := func() (*fragment, int, error) {
+			return nil, 0, formatErrorCorruptBinaryPatch(s.linenr-1, strings.TrimSuffix(buf[:n], "\n"))
+		}
+		// "A00000\n" at least, five characters per four bytes
+		if n < 7 || (n-2)%5 != 0 {
+			return corrupt()
+		}
+		maxLen := (n - 2) / 5 * 4
+		length := 0
+		switch c := buf[0]; {
+		case 'A' <= c && c <= 'Z':
+			length = int(c-'A') + 1
+		case 'a' <= c && c <= 'z':
+			length = int(c-'a') + 27
+		default:
+			return corrupt()
+		}
+		// the filler at the end is less than four bytes
+		if maxLen < length || length <= maxLen-4 {
+			return corrupt()
+		}
+		decoded, ok := base85.Decode(buf[1:n-1], length)
+		if !ok {
+			return corrupt()
+		}
+		data = append(data, decoded...)
+		buf = buf[n:]
+	}
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err == nil {
+		f.data, err = io.ReadAll(r)
+	}
+	if err != nil || len(f.data) != origLen {
+		return nil, 0, formatErrorCorruptBinaryPatch(s.linenr-1, strings.TrimSuffix(buf[:n], "\n"))
+	}
+	return f, used, nil
+}
+
+// Parses the hunks after "GIT binary patch": the one to apply and maybe
+// one to reverse it. Returns their length
+func (s *state) parseBinary(buf string, p *patch) (int, error) {
+	forward, used, err := s.parseBinaryHunk(buf)
+	if err != nil {
+		return 0, err
+	}
+	if forward == nil {
+		return 0, formatErrorUnrecognizedBinaryPatch(s.linenr - 1)
+	}
+	p.fragments = []*fragment{forward}
+	reverse, n, err := s.parseBinaryHunk(buf[used:])
+	if err != nil {
+		return 0, err
+	}
+	if reverse != nil {
+		p.fragments = append(p.fragments, reverse)
+		used += n
+	}
+	p.binary = true
+	return used, nil
+}
+
+// Applies a binary patch to the content, which must be the blob the
+// "index" line names; the result is taken from the object database if it
+// is there
+func (s *state) applyBinary(content []byte, p *patch) ([]byte, error) {
+	name := p.oldName
+	if name == "" {
+		name = p.newName
+	}
+	if !repr.IsValidDigest(p.oldDigest) || !repr.IsValidDigest(p.newDigest) {
+		return nil, s.errorf("cannot apply binary patch to '%v' without full index line", name)
+	}
+	if p.oldName != "" {
+		if digest := blobDigest(content); digest != p.oldDigest {
+			return nil, s.errorf("the patch applies to '%v' (%v), which does not match the current contents.", name, digest)
+		}
+	} else if len(content) > 0 {
+		return nil, s.errorf("the patch applies to an empty '%v' but it is not empty", name)
+	}
+	if p.newDigest == repr.ZeroDigest() {
+		return nil, nil
+	}
+	if s.db.HasObject(p.newDigest) {
+		_, res, err := s.db.ReadRawObject(p.newDigest)
+		if err != nil {
+			return nil, s.errorf("the necessary postimage %v for '%v' cannot be read", p.newDigest, name)
+		}
+		return res, nil
+	}
+	res, err := s.applyBinaryFragment(content, p)
+	if err != nil {
+		return nil, s.errorf("binary patch does not apply to '%v'", name)
+	}
+	if digest := blobDigest(res); digest != p.newDigest {
+		return nil, s.errorf("binary patch to '%v' creates incorrect result (expecting %v, got %v)", name, p.newDigest, digest)
+	}
+	return res, nil
+}
+
+func (s *state) applyBinaryFragment(content []byte, p *patch) ([]byte, error) {
+	name := p.newName
+	if name == "" {
+		name = p.oldName
+	}
+	if len(p.fragments) == 0 {
+		return nil, s.errorf("missing binary patch data for '%v'", name)
+	}
+	f := p.fragments[0]
+	// only patches with the reverse hunk can be reversed
+	if s.opts.Reverse {
+		if len(p.fragments) < 2 {
+			return nil, s.errorf("cannot reverse-apply a binary patch without the reverse hunk to '%v'", name)
+		}
+		f = p.fragments[1]
+	}
+	if f.delta {
+		return fs.ApplyDelta(content, f.data)
+	}
+	return f.data, nil
+}
+
+func blobDigest(content []byte) string {
+	digest, _ := repr.HashStream("blob", int64(len(content)), bytes.NewReader(content))
+	return digest
+}
@@ -0,0 +1,320 @@
+package apply
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/magnickolas/gitok/index/parser"
+	"github.com/magnickolas/gitok/repr"
+	"github.com/magnickolas/gitok/strerror"
+	"github.com/magnickolas/gitok/worktree"
+)
+
+var (
+	ErrorInvalidPath       = errors.New("invalid path")
+	formatErrorInvalidPath = func(name string) error {
+		return fmt.Errorf("%w '%v'", ErrorInvalidPath, name)
+	}
+)
+
+// Modes as in the index, the type in the bits of modeType
+const (
+	modeType       = 0170000
+	modeRegular    = 0100644
+	modeExecutable = 0100755
+	modeSymlink    = 0120000
+	modeGitlink    = 0160000
+)
+
+// Ways a file to be created already exists
+const (
+	existsInIndex = 1 + iota
+	existsInWorktree
+)
+
+// Checks that all the patches apply, computing their results. Those that
+// do not are reported and marked rejected
+func (s *state) checkPatchList(patches []*patch) (bool, error) {
+	s.fnTable = make(map[string]*patch)
+	for _, p := range patches {
+		if p.newName == "" || p.isRename {
+			s.fnTable[p.oldName] = toBeDeleted
+		}
+	}
+	ok := true
+	for _, p := range patches {
+		if s.opts.Verbosity == VerbosityVerbose {
+			s.sayPatchName("Checking patch %v...", p)
+		}
+		if err := s.checkPatch(p); errors.Is(err, errPatchFailed) {
+			ok = false
+		} else if err != nil {
+			return false, err
+		}
+	}
+	return ok, nil
+}
+
+func (s *state) checkPatch(p *patch) error {
+	name := p.oldName
+	if name == "" {
+		name = p.newName
+	}
+	p.rejected = true
+	entry, info, err := s.checkPreimage(p)
+	if err != nil {
+		return err
+	}
+	// a file deleted or renamed away by another patch may be replaced
+	okIfExists := false
+	if other := s.fnTable[p.newName]; p.newName != "" && (other == wasDeleted || other == toBeDeleted) {
+		okIfExists = true
+	}
+	if p.newName != "" && (p.isNew > 0 || p.isRename || p.isCopy) {
+		exists, err := s.checkToCreate(p.newName, okIfExists)
+		if err != nil {
+			return err
+		}
+		switch {
+		case exists != 0 && s.opts.ThreeWay:
+			p.directToThreeWay = true
+		case exists == existsInIndex:
+			return s.errorf("%v: already exists in index", p.newName)
+		case exists == existsInWorktree:
+			return s.errorf("%v: already exists in working directory", p.newName)
+		}
+		if p.newMode == 0 {
+			p.newMode = p.oldMode
+			if p.isNew > 0 {
+				p.newMode = modeRegular
+			}
+		}
+	}
+	if p.newName != "" && p.oldName != "" {
+		if p.newMode == 0 {
+			p.newMode = p.oldMode
+		}
+		if (p.oldMode^p.newMode)&modeType != 0 {
+			if p.oldName == p.newName {
+				return s.errorf("new mode (%o) of %v does not match old mode (%o)", p.newMode, p.newName, p.oldMode)
+			}
+			return s.errorf("new mode (%o) of %v does not match old mode (%o) of %v", p.newMode, p.newName, p.oldMode, p.oldName)
+		}
+	}
+	if err := checkUnsafePath(p); err != nil {
+		return err
+	}
+	if err := s.applyData(p, entry, info); errors.Is(err, errPatchFailed) {
+		return s.errorf("%v: patch does not apply", name)
+	} else if err != nil {
+		return err
+	}
+	p.rejected = false
+	return nil
+}
+
+// Checks the file the patch applies to, filling in what the patch leaves
+// unknown about it. Returns its index entry if the index is used and its
+// stat data if the worktree is
+func (s *state) checkPreimage(p *patch) (*parser.Entry, os.FileInfo, error) {
+	if p.oldName == "" {
+		return nil, nil, nil
+	}
+	previous, gone := s.previousPatch(p)
+	if gone {
+		return nil, nil, s.errorf("path %v has been renamed/deleted", p.oldName)
+	}
+	var entry *parser.Entry
+	var info os.FileInfo
+	var statErr error
+	mode := 0
+	if previous != nil {
+		mode = previous.newMode
+	} else if !s.opts.Cached {
+		info, statErr = os.Lstat(filepath.FromSlash(p.oldName))
+		if statErr != nil && !isMissing(statErr) {
+			return nil, nil, s.errorf("%v: %v", p.oldName, strerror.Describe(statErr))
+		}
+	}
+	if s.checkIndex && previous == nil {
+		if entry = s.indexEntry(p.oldName); entry == nil {
+			if p.isNew < 0 {
+				p.becomeNew()
+				return nil, nil, nil
+			}
+			return nil, nil, s.errorf("%v: does not exist in index", p.oldName)
+		}
+		if statErr != nil {
+			var err error
+			if info, err = s.checkoutTarget(entry); err != nil {
+				return nil, nil, err
+			}
+		}
+		if !s.opts.Cached && !s.matchesIndex(entry, info) {
+			return nil, nil, s.errorf("%v: does not match index", p.oldName)
+		}
+		if s.opts.Cached {
+			mode = int(entry.Mode)
+		}
+	} else if statErr != nil {
+		if p.isNew < 0 {
+			p.becomeNew()
+			return nil, nil, nil
+		}
+		return nil, nil, s.errorf("%v: %v", p.oldName, strerror.Describe(statErr))
+	}
+	if !s.opts.Cached && previous == nil {
+		switch {
+		case s.opts.TrustFileMode:
+			mode = statMode(info)
+		case entry != nil:
+			mode = int(entry.Mode)
+		default:
+			mode = p.oldMode
+		}
+	}
+	if p.isNew < 0 {
+		p.isNew = 0
+	}
+	if p.oldMode == 0 {
+		p.oldMode = mode
+	}
+	if (mode^p.oldMode)&modeType != 0 {
+		return nil, nil, s.errorf("%v: wrong type", p.oldName)
+	}
+	if mode != p.oldMode {
+		s.warnf("%v has type %o, expected %o", p.oldName, mode, p.oldMode)
+	}
+	if p.newMode == 0 && p.isDelete == 0 {
+		p.newMode = mode
+	}
+	return entry, info, nil
+}
+
+// Turns a patch that was not known to create its file into one that does,
+// as the file is missing
+func (p *patch) becomeNew() {
+	p.isNew, p.isDelete = 1, 0
+	p.oldName = ""
+}
+
+// The earlier patch whose result this one applies to, if any, and whether
+// the file was renamed or deleted by one
+func (s *state) previousPatch(p *patch) (*patch, bool) {
+	// git patches do not depend on the order
+	if p.isCopy || p.isRename {
+		return nil, false
+	}
+	previous := s.fnTable[p.oldName]
+	switch previous {
+	case nil, toBeDeleted:
+		return nil, false
+	case wasDeleted:
+		return nil, true
+	}
+	return previous, false
+}
+
+// Records the result of the patch for the patches after it
+func (s *state) addToFnTable(p *patch) {
+	if p.newName != "" {
+		s.fnTable[p.newName] = p
+	}
+	if p.newName == "" || p.isRename {
+		s.fnTable[p.oldName] = wasDeleted
+	}
+}
+
+// Whether the file to create exists in the index or the worktree
+func (s *state) checkToCreate(name string, okIfExists bool) (int, error) {
+	if s.checkIndex && !okIfExists && s.indexEntry(name) != nil {
+		return existsInIndex, nil
+	}
+	if s.opts.Cached {
+		return 0, nil
+	}
+	info, err := os.Lstat(filepath.FromSlash(name))
+	if err == nil {
+		if info.IsDir() || okIfExists {
+			return 0, nil
+		}
+		return existsInWorktree, nil
+	} else if !isMissing(err) {
+		return 0, s.errorf("%v: %v", name, strerror.Describe(err))
+	}
+	return 0, nil
+}
+
+// Stage 0 entry of the path in the index
+func (s *state) indexEntry(name string) *parser.Entry {
+	for i := range s.idx.Entries {
+		if entry := &s.idx.Entries[i]; entry.Name == name && entry.Stage() == 0 {
+			return entry
+		}
+	}
+	return nil
+}
+
+// Whether the worktree file is what the index entry records
+func (s *state) matchesIndex(entry *parser.Entry, info os.FileInfo) bool {
+	if entry.ObjectMode() == repr.ModeGitlink {
+		return info.IsDir()
+	}
+	status, err := worktree.CheckEntry(entry, s.opts.TrustFileMode, s.opts.Converter)
+	return err == nil && status == worktree.Unchanged
+}
+
+// Writes the file of the index entry missing from the worktree
+func (s *state) checkoutTarget(entry *parser.Entry) (os.FileInfo, error) {
+	content, err := s.readEntry(entry)
+	if err == nil {
+		err = s.writeFile(entry.Name, int(entry.Mode), []byte(content))
+	}
+	if err != nil {
+		return nil, s.errorf("cannot checkout %v", entry.Name)
+	}
+	return os.Lstat(filepath.FromSlash(entry.Name))
+}
+
+// Rejects patches touching paths outside the worktree or in .git
+func checkUnsafePath(p *patch) error {
+	var names []string
+	if p.isNew <= 0 {
+		names = append(names, p.oldName)
+	}
+	if p.isDelete <= 0 {
+		names = append(names, p.newName)
+	}
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		for _, component := range strings.Split(name, "/") {
+			if component == "" || component == "." || component == ".." || strings.EqualFold(component, ".git") {
+				return formatErrorInvalidPath(name)
+			}
+		}
+	}
+	return nil
+}
+
+// Mode the index would record for the file
+func statMode(info os.FileInfo) int {
+	switch worktree.FileMode(info) {
+	case repr.ModeSymbolicLink:
+		return modeSymlink
+	case repr.ModeTree:
+		return modeGitlink
+	case repr.ModeExecutable:
+		return modeExecutable
+	}
+	return modeRegular
+}
+
+func isMissing(err error) bool {
+	return errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ENOTDIR)
+}
@@ -0,0 +1,63 @@
+package apply
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/magnickolas/gitok/attr"
+	"github.com/magnickolas/gitok/config"
+	"github.com/magnickolas/gitok/convert"
+)
+
+var (
+	ErrorUnrecognizedIgnoreOption       = errors.New("unrecognized whitespace ignore option")
+	formatErrorUnrecognizedIgnoreOption = func(option string) error {
+		return fmt.Errorf("%w '%v'", ErrorUnrecognizedIgnoreOption, option)
+	}
+)
+
+// Fills in the options the configuration gives; the whitespace action is
+// that of apply.whitespace unless given. Warnings go to errW
+func Configure(errW io.Writer, cfg *config.Config, opts *Options, whitespace string) error {
+	if whitespace == "" {
+		whitespace, _ = cfg.Get("apply.whitespace")
+	}
+	if whitespace != "" {
+		action, err := ParseWSAction(whitespace)
+		if err != nil {
+			return err
+		}
+		opts.Whitespace = action
+	}
+	if value, ok := cfg.Get("apply.ignoreWhitespace"); ok && !opts.IgnoreSpaceChange {
+		switch value {
+		case "change", "true":
+			opts.IgnoreSpaceChange = true
+		case "no", "none", "never", "false":
+		default:
+			return formatErrorUnrecognizedIgnoreOption(value)
+		}
+	}
+	rule := DefaultWSRule
+	if value, ok := cfg.Get("core.whitespace"); ok {
+		var err error
+		rule, err = ParseWSRule(value, func(msg string) {
+			fmt.Fprintf(errW, "warning: %v\n", msg)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	opts.WSRule = rule
+	trustFileMode, err := cfg.GetBool("core.fileMode", true)
+	if err != nil {
+		return err
+	}
+	opts.TrustFileMode = trustFileMode
+	if opts.Converter, err = convert.NewConverter(cfg); err != nil {
+		return err
+	}
+	opts.Attrs, err = attr.NewMatcher(cfg)
+	return err
+}
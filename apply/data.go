@@ -0,0 +1,221 @@
+package apply
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/magnickolas/gitok/index/parser"
+	"github.com/magnickolas/gitok/merge"
+	"github.com/magnickolas/gitok/repr"
+	"github.com/magnickolas/gitok/strerror"
+	"github.com/magnickolas/gitok/worktree"
+)
+
+// A 3-way merge is not attempted for the patch
+var errNoThreeWay = errors.New("no 3-way merge")
+
+// Computes the result of the patch from the content it applies to, with a
+// 3-way merge first if asked for
+func (s *state) applyData(p *patch, entry *parser.Entry, info os.FileInfo) error {
+	content, err := s.loadPreimage(p, entry, info)
+	if err != nil {
+		return err
+	}
+	img := newImage(content)
+	if !s.opts.ThreeWay || s.tryThreeWay(img, p, entry, info) != nil {
+		if s.opts.ThreeWay && !p.directToThreeWay {
+			s.say("Falling back to direct application...\n")
+		}
+		if p.directToThreeWay {
+			return errPatchFailed
+		}
+		if err := s.applyFragments(img, p); err != nil {
+			return err
+		}
+	}
+	p.result = []byte(img.String())
+	s.addToFnTable(p)
+	if p.isDelete > 0 && len(p.result) > 0 {
+		return s.errorf("removal patch leaves file contents")
+	}
+	return nil
+}
+
+// Content the patch applies to: the result of an earlier patch to the
+// file or what the index or the worktree has
+func (s *state) loadPreimage(p *patch, entry *parser.Entry, info os.FileInfo) (string, error) {
+	previous, gone := s.previousPatch(p)
+	if gone {
+		return "", s.errorf("path %v has been renamed/deleted", p.oldName)
+	}
+	if previous != nil {
+		return string(previous.result), nil
+	}
+	// submodules can only be patched with the commit in the index
+	if !s.checkIndex && p.oldName != "" && p.oldMode == modeGitlink {
+		p.fragments = nil
+		return "", nil
+	}
+	return s.loadPatchTarget(entry, info, p.oldName)
+}
+
+func (s *state) loadPatchTarget(entry *parser.Entry, info os.FileInfo, name string) (string, error) {
+	if s.checkIndex {
+		content, err := s.readEntry(entry)
+		if err != nil {
+			return "", s.errorf("failed to read %v", name)
+		}
+		return content, nil
+	}
+	if name == "" {
+		return "", nil
+	}
+	if !info.Mode().IsRegular() && info.Mode()&os.ModeSymlink == 0 {
+		return "", s.errorf("failed to read %v", name)
+	}
+	blob, err := worktree.ReadBlob(filepath.FromSlash(name), info, s.opts.Converter)
+	if err != nil {
+		return "", s.errorf("failed to read %v", name)
+	}
+	return string(blob.Content()), nil
+}
+
+// Content of the index entry, empty if there is none; submodules have
+// the line "git diff" shows for them
+func (s *state) readEntry(entry *parser.Entry) (string, error) {
+	if entry == nil {
+		return "", nil
+	}
+	if entry.ObjectMode() == repr.ModeGitlink {
+		return "Subproject commit " + entry.Digest + "\n", nil
+	}
+	_, content, err := s.db.ReadRawObject(entry.Digest)
+	return string(content), err
+}
+
+// Applies the patch to the blob it was made for and merges the result
+// with the current content into the image
+func (s *state) tryThreeWay(img *image, p *patch, entry *parser.Entry, info os.FileInfo) error {
+	if p.isDelete != 0 || p.oldMode == modeGitlink || p.newMode == modeGitlink ||
+		p.isNew != 0 && !p.directToThreeWay ||
+		p.isRename && p.linesAdded == 0 && p.linesDeleted == 0 {
+		return errNoThreeWay
+	}
+	base := ""
+	if p.isNew == 0 {
+		var err error
+		if base, err = s.readBlobByPrefix(p.oldDigest); err != nil {
+			return s.errorf("repository lacks the necessary blob to perform 3-way merge.")
+		}
+	}
+	if p.directToThreeWay {
+		s.say("Performing three-way merge...\n")
+	}
+	theirs := newImage(base)
+	if err := s.applyFragments(theirs, p); err != nil {
+		return err
+	}
+	var ours string
+	var err error
+	if p.isNew != 0 {
+		ours, err = s.loadCurrent(p)
+		if err != nil {
+			return s.errorf("cannot read the current contents of '%v'", p.newName)
+		}
+	} else if ours, err = s.loadPreimage(p, entry, info); err != nil {
+		return s.errorf("cannot read the current contents of '%v'", p.oldName)
+	}
+	var stages [3]string
+	for i, content := range []string{base, ours, theirs.String()} {
+		blob, err := repr.NewBlob(bytes.NewReader([]byte(content)))
+		if err != nil {
+			return err
+		}
+		if err := s.db.WriteObject(blob); err != nil {
+			return err
+		}
+		stages[i] = blob.Digest()
+	}
+	result, conflicted := s.threeWayMerge(p.newName, stages, base, ours, theirs.String())
+	*img = *newImage(result)
+	if conflicted {
+		p.conflicted = true
+		p.stages = stages
+		if p.isNew != 0 {
+			p.stages[0] = ""
+		}
+		s.say("Applied patch to '%v' with conflicts.\n", p.newName)
+	} else {
+		s.say("Applied patch to '%v' cleanly.\n", p.newName)
+	}
+	return nil
+}
+
+// Merges the sides by their blobs, the trivial cases first, returning
+// the result and whether it has conflicts
+func (s *state) threeWayMerge(name string, digests [3]string, base string, ours string, theirs string) (string, bool) {
+	switch {
+	case digests[0] == digests[1]:
+		return theirs, false
+	case digests[0] == digests[2] || digests[1] == digests[2]:
+		return ours, false
+	}
+	opts := merge.Options{Ours: s.opts.Ours, Theirs: s.opts.Theirs}
+	if opts.Ours == "" {
+		opts.Ours = "ours"
+	}
+	if opts.Theirs == "" {
+		opts.Theirs = "theirs"
+	}
+	res, conflicts, err := merge.Merge([]byte(base), []byte(ours), []byte(theirs), opts)
+	if err != nil {
+		s.warnf("Cannot merge binary files: %v (%v vs. %v)", name, opts.Ours, opts.Theirs)
+		return ours, true
+	}
+	return string(res), conflicts > 0
+}
+
+// Content of the blob the abbreviated digest names unambiguously
+func (s *state) readBlobByPrefix(prefix string) (string, error) {
+	if prefix == "" {
+		return "", errNoThreeWay
+	}
+	digests, err := s.db.FindObjectsByPrefix(prefix)
+	if err != nil {
+		return "", err
+	}
+	if len(digests) != 1 {
+		return "", errNoThreeWay
+	}
+	objType, content, err := s.db.ReadRawObject(digests[0])
+	if err != nil {
+		return "", err
+	}
+	if objType != "blob" {
+		return "", errNoThreeWay
+	}
+	return string(content), nil
+}
+
+// Content of the file a creation patch finds in the index, which the
+// worktree must match
+func (s *state) loadCurrent(p *patch) (string, error) {
+	entry := s.indexEntry(p.newName)
+	if entry == nil {
+		return "", s.errorf("%v: does not exist in index", p.newName)
+	}
+	info, err := os.Lstat(filepath.FromSlash(p.newName))
+	if isMissing(err) {
+		if info, err = s.checkoutTarget(entry); err != nil {
+			return "", err
+		}
+	} else if err != nil {
+		return "", s.errorf("%v: %v", p.newName, strerror.Describe(err))
+	}
+	if !s.matchesIndex(entry, info) {
+		return "", s.errorf("%v: does not match index", p.newName)
+	}
+	return s.loadPatchTarget(entry, info, p.newName)
+}
@@ -0,0 +1,420 @@
+package apply
+
+import (
+	"strings"
+
+	"github.com/magnickolas/gitok/diff"
+)
+
+// A line of an image: a file being patched or the two sides of a hunk
+type imageLine struct {
+	text string
+	// of the non-whitespace characters, to rule out positions quickly
+	hash uint32
+	// a context line of a hunk
+	common bool
+	// put in place by a hunk, which later ones must not match
+	patched bool
+}
+
+type image struct {
+	lines []imageLine
+}
+
+func hashLine(line string) uint32 {
+	var h uint32
+	for i := 0; i < len(line); i += 1 {
+		if !isSpace(line[i]) {
+			h = h*3 + uint32(line[i])
+		}
+	}
+	return h
+}
+
+func newImage(content string) *image {
+	img := &image{}
+	for content != "" {
+		n := lineLen(content)
+		img.add(content[:n], false)
+		content = content[n:]
+	}
+	return img
+}
+
+func (img *image) add(line string, common bool) {
+	img.lines = append(img.lines, imageLine{text: line, hash: hashLine(line), common: common})
+}
+
+func (img *image) String() string {
+	var b strings.Builder
+	for _, line := range img.lines {
+		b.WriteString(line.text)
+	}
+	return b.String()
+}
+
+// Applies the hunks of the patch to the image of the file. Hunks that do
+// not apply are marked rejected with --reject and fail the patch otherwise
+func (s *state) applyFragments(img *image, p *patch) error {
+	name := p.oldName
+	if name == "" {
+		name = p.newName
+	}
+	if p.binary {
+		content, err := s.applyBinary([]byte(img.String()), p)
+		if err != nil {
+			return err
+		}
+		*img = image{lines: []imageLine{{text: string(content)}}}
+		return nil
+	}
+	for i, f := range p.fragments {
+		if s.applyOneFragment(img, f, p.wsRule, i+1) {
+			continue
+		}
+		err := s.errorf("patch failed: %v:%d", name, f.oldPos)
+		if !s.opts.Reject {
+			return err
+		}
+		f.rejected = true
+	}
+	return nil
+}
+
+// Finds where the hunk applies, reducing its context if allowed, and
+// replaces its preimage with the postimage there
+func (s *state) applyOneFragment(img *image, f *fragment, rule WSRule, nth int) bool {
+	pre, post := &image{}, &image{}
+	var oldText strings.Builder
+	newBlankLinesAtEnd, foundNewBlankLinesAtEnd := 0, 0
+	linenr := f.linenr
+	for text := f.text; text != ""; linenr += 1 {
+		n := lineLen(text)
+		line := text[:n]
+		text = text[n:]
+		// the line break of a line before "\ No newline at end of file"
+		// is not part of the file
+		end := n
+		if strings.HasPrefix(text, `\`) {
+			end -= 1
+		}
+		first := line[0]
+		if s.opts.Reverse {
+			switch first {
+			case '-':
+				first = '+'
+			case '+':
+				first = '-'
+			}
+		}
+		addedBlank, blankContext := false, false
+		switch first {
+		case '\n':
+			// an empty context line
+			if end < 1 {
+				break
+			}
+			oldText.WriteString("\n")
+			pre.add("\n", true)
+			post.add("\n", true)
+			blankContext = true
+		case ' ', '-':
+			data := line[1:end]
+			if first == ' ' && data != "" && rule&WSBlankAtEOF != 0 && isBlank(data) {
+				blankContext = true
+			}
+			oldText.WriteString(data)
+			pre.add(data, first == ' ')
+			if first == ' ' {
+				post.add(data, true)
+			}
+		case '+':
+			data := line[1:end]
+			if s.wsErrors > 0 && s.wsAction == WSFix {
+				fixed, changed := rule.fix(data)
+				if changed {
+					s.fixedWS += 1
+				}
+				post.add(fixed, false)
+			} else {
+				post.add(data, false)
+			}
+			addedBlank = rule&WSBlankAtEOF != 0 && isBlank(data)
+		}
+		switch {
+		case addedBlank:
+			if newBlankLinesAtEnd == 0 {
+				foundNewBlankLinesAtEnd = linenr
+			}
+			newBlankLinesAtEnd += 1
+		case blankContext:
+		default:
+			newBlankLinesAtEnd = 0
+		}
+	}
+
+	leading, trailing := f.leading, f.trailing
+	// a hunk at the start of the file must match there, unless it may be
+	// an insertion after the first line of a patch without context
+	matchBeginning := f.oldPos == 0 || f.oldPos == 1 && !s.opts.UnidiffZero
+	// and one without trailing context at its end
+	matchEnd := !s.opts.UnidiffZero && trailing == 0
+	pos := 0
+	if f.newPos > 0 {
+		pos = f.newPos - 1
+	}
+	appliedPos := -1
+	for {
+		appliedPos = s.findPos(img, pre, post, pos, rule, matchBeginning, matchEnd)
+		if appliedPos >= 0 {
+			break
+		}
+		if s.opts.Context < 0 || leading <= s.opts.Context && trailing <= s.opts.Context {
+			break
+		}
+		if matchBeginning || matchEnd {
+			matchBeginning, matchEnd = false, false
+			continue
+		}
+		// drop a line of context from the side with more of it
+		if leading >= trailing {
+			pre.lines, post.lines = pre.lines[1:], post.lines[1:]
+			pos -= 1
+			leading -= 1
+		}
+		if trailing > leading {
+			pre.lines, post.lines = pre.lines[:len(pre.lines)-1], post.lines[:len(post.lines)-1]
+			trailing -= 1
+		}
+	}
+	if appliedPos < 0 {
+		if s.opts.Verbosity == VerbosityVerbose {
+			s.errorf("while searching for:\n%v", oldText.String())
+		}
+		return false
+	}
+	if newBlankLinesAtEnd > 0 && len(pre.lines)+appliedPos >= len(img.lines) &&
+		rule&WSBlankAtEOF != 0 && s.wsAction != WSNoWarn {
+		s.recordWSError(WSBlankAtEOF, "+", foundNewBlankLinesAtEnd)
+		if s.wsAction == WSFix {
+			post.lines = post.lines[:len(post.lines)-newBlankLinesAtEnd]
+		}
+		// nothing is written out once whitespace errors are fatal
+		if s.wsAction == WSError || s.wsAction == WSErrorAll {
+			s.apply = false
+		}
+	}
+	if s.opts.Verbosity == VerbosityVerbose && appliedPos != pos {
+		offset := appliedPos - pos
+		if s.opts.Reverse {
+			offset = -offset
+		}
+		s.say("Hunk #%d succeeded at %d (offset %d %v).\n", nth, appliedPos+1, offset, diff.Plural(offset, "line", "lines"))
+	}
+	if leading != f.leading || trailing != f.trailing {
+		s.say("Context reduced to (%d/%d) to apply fragment at %d\n", leading, trailing, appliedPos+1)
+	}
+	updateImage(img, appliedPos, pre, post)
+	return true
+}
+
+// Line of the image the preimage matches at, looking around the line it
+// is expected at first; -1 if it matches nowhere
+func (s *state) findPos(img *image, pre *image, post *image, line int, rule WSRule, matchBeginning bool, matchEnd bool) int {
+	// nowhere else can match anyway
+	if matchBeginning {
+		line = 0
+	} else if matchEnd {
+		line = len(img.lines) - len(pre.lines)
+	}
+	if line < 0 || line > len(img.lines) {
+		line = len(img.lines)
+	}
+	backwards, forwards := line, line
+	current := line
+	for i := 0; ; i += 1 {
+		if s.matchFragment(img, pre, post, current, rule, matchBeginning, matchEnd) {
+			return current
+		}
+		// alternate after and before the expected line as long as either
+		// way is left
+		for {
+			if backwards == 0 && forwards == len(img.lines) {
+				return -1
+			}
+			if i&1 == 1 {
+				if backwards == 0 {
+					i += 1
+					continue
+				}
+				backwards -= 1
+				current = backwards
+			} else {
+				if forwards == len(img.lines) {
+					i += 1
+					continue
+				}
+				forwards += 1
+				current = forwards
+			}
+			break
+		}
+	}
+}
+
+// Whether the preimage matches the image at the line; whitespace
+// differences are forgiven when ignored or fixed, updating the images to
+// what the file has
+func (s *state) matchFragment(img *image, pre *image, post *image, current int, rule WSRule, matchBeginning bool, matchEnd bool) bool {
+	limit := len(pre.lines)
+	if len(pre.lines)+current <= len(img.lines) {
+		if matchEnd && len(pre.lines)+current != len(img.lines) {
+			return false
+		}
+	} else if s.wsAction == WSFix && rule&WSBlankAtEOF != 0 {
+		// blank lines at the end of the preimage may be missing from the
+		// file having been removed before
+		limit = len(img.lines) - current
+	} else {
+		return false
+	}
+	if matchBeginning && current != 0 {
+		return false
+	}
+	for i := 0; i < limit; i += 1 {
+		if line := img.lines[current+i]; line.patched || line.hash != pre.lines[i].hash {
+			return false
+		}
+	}
+	if limit == len(pre.lines) {
+		exact := true
+		for i := 0; i < limit && exact; i += 1 {
+			exact = img.lines[current+i].text == pre.lines[i].text
+		}
+		if exact {
+			return true
+		}
+	} else {
+		// the lines before the end of the file must not be all blank
+		blank := true
+		for i := 0; i < limit && blank; i += 1 {
+			blank = isBlank(pre.lines[i].text)
+		}
+		if blank {
+			return false
+		}
+	}
+	if s.opts.IgnoreSpaceChange {
+		return lineByLineFuzzyMatch(img, pre, post, current, limit)
+	}
+	if s.wsAction != WSFix {
+		return false
+	}
+	// maybe the file has had the whitespace fixed already, or the patch
+	// did and the file did not
+	var fixed []string
+	for i := 0; i < limit; i += 1 {
+		fixedPre, _ := rule.fix(pre.lines[i].text)
+		fixedImg, _ := rule.fix(img.lines[current+i].text)
+		if fixedPre != fixedImg {
+			return false
+		}
+		fixed = append(fixed, fixedPre)
+	}
+	for i := limit; i < len(pre.lines); i += 1 {
+		fixedPre, _ := rule.fix(pre.lines[i].text)
+		if !isBlank(fixedPre) {
+			return false
+		}
+		fixed = append(fixed, fixedPre)
+	}
+	updatePrePostImages(pre, post, fixed)
+	return true
+}
+
+// Matches the preimage with the image line by line, ignoring the amount
+// of whitespace
+func lineByLineFuzzyMatch(img *image, pre *image, post *image, current int, limit int) bool {
+	var fixed []string
+	for i := 0; i < limit; i += 1 {
+		if !fuzzyMatchLines(img.lines[current+i].text, pre.lines[i].text) {
+			return false
+		}
+		fixed = append(fixed, img.lines[current+i].text)
+	}
+	// beyond the end of the file only blank lines are left out
+	for i := limit; i < len(pre.lines); i += 1 {
+		if !isBlank(pre.lines[i].text) {
+			return false
+		}
+		fixed = append(fixed, pre.lines[i].text)
+	}
+	updatePrePostImages(pre, post, fixed)
+	return true
+}
+
+// Whether the lines are the same but for line breaks and the amount of
+// whitespace where there is any
+func fuzzyMatchLines(a string, b string) bool {
+	a, b = strings.TrimRight(a, "\r\n"), strings.TrimRight(b, "\r\n")
+	for a != "" && b != "" {
+		if !isSpace(a[0]) {
+			if a[0] != b[0] {
+				return false
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		// whitespace must be on both sides for "a b" not to match "ab"
+		if !isSpace(b[0]) {
+			return false
+		}
+		a = strings.TrimLeft(a, " \t\n\r")
+		b = strings.TrimLeft(b, " \t\n\r")
+	}
+	return a == "" && b == ""
+}
+
+// Replaces the preimage lines with the ones matched and the context lines
+// of the postimage with their counterparts, so that they keep what the
+// file has
+func updatePrePostImages(pre *image, post *image, fixed []string) {
+	for i, line := range fixed {
+		pre.lines[i].text = line
+		pre.lines[i].hash = hashLine(line)
+	}
+	pre.lines = pre.lines[:len(fixed)]
+	var res []imageLine
+	ctx := 0
+	for _, line := range post.lines {
+		if !line.common {
+			res = append(res, line)
+			continue
+		}
+		for ctx < len(pre.lines) && !pre.lines[ctx].common {
+			ctx += 1
+		}
+		// blank lines at the end may have been dropped
+		if ctx == len(pre.lines) {
+			continue
+		}
+		line.text, line.hash = pre.lines[ctx].text, pre.lines[ctx].hash
+		res = append(res, line)
+		ctx += 1
+	}
+	post.lines = res
+}
+
+// Replaces the preimage at the line with the postimage, marking its lines
+// patched
+func updateImage(img *image, pos int, pre *image, post *image) {
+	limit := min(len(pre.lines), len(img.lines)-pos)
+	lines := make([]imageLine, 0, len(img.lines)-limit+len(post.lines))
+	lines = append(lines, img.lines[:pos]...)
+	for _, line := range post.lines {
+		line.patched = true
+		lines = append(lines, line)
+	}
+	lines = append(lines, img.lines[pos+limit:]...)
+	img.lines = lines
+}
@@ -0,0 +1,808 @@
+package apply
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/magnickolas/gitok/diff"
+	"github.com/magnickolas/gitok/quote"
+	"github.com/magnickolas/gitok/repr"
+)
+
+var (
+	ErrorFragmentWithoutHeader       = errors.New("patch fragment without header")
+	formatErrorFragmentWithoutHeader = func(linenr int, line string) error {
+		return fmt.Errorf("%w at line %d: %v", ErrorFragmentWithoutHeader, linenr, line)
+	}
+	ErrorNoFilename       = errors.New("unable to find filename in patch")
+	formatErrorNoFilename = func(linenr int) error {
+		return fmt.Errorf("%w at line %d", ErrorNoFilename, linenr)
+	}
+	ErrorGitHeaderNoFilename       = errors.New("git diff header lacks filename information")
+	formatErrorGitHeaderNoFilename = func(linenr int) error {
+		return fmt.Errorf("%w (line %d)", ErrorGitHeaderNoFilename, linenr)
+	}
+	formatErrorGitHeaderNoFilenameStrip = func(strip int, linenr int) error {
+		return fmt.Errorf("%w when removing %d leading pathname %v (line %d)",
+			ErrorGitHeaderNoFilename, strip, diff.Plural(strip, "component", "components"), linenr)
+	}
+	ErrorBadGitDiff       = errors.New("git apply: bad git-diff")
+	formatErrorBadGitDiff = func(linenr int, what string) error {
+		return fmt.Errorf("%w - %v on line %d", ErrorBadGitDiff, what, linenr)
+	}
+	ErrorInconsistentHeader       = errors.New("inconsistent header lines")
+	formatErrorInconsistentHeader = func(a int, b int) error {
+		return fmt.Errorf("%w %d and %d", ErrorInconsistentHeader, a, b)
+	}
+	ErrorInvalidMode       = errors.New("invalid mode")
+	formatErrorInvalidMode = func(linenr int, line string) error {
+		return fmt.Errorf("%w on line %d: %v", ErrorInvalidMode, linenr, line)
+	}
+	ErrorCorruptPatch       = errors.New("corrupt patch")
+	formatErrorCorruptPatch = func(linenr int) error {
+		return fmt.Errorf("%w at line %d", ErrorCorruptPatch, linenr)
+	}
+	ErrorNewFileDependsOnOld       = errors.New("depends on old contents")
+	formatErrorNewFileDependsOnOld = func(name string) error {
+		return fmt.Errorf("new file %v %w", name, ErrorNewFileDependsOnOld)
+	}
+	ErrorDeletedFileHasContents       = errors.New("still has contents")
+	formatErrorDeletedFileHasContents = func(name string) error {
+		return fmt.Errorf("deleted file %v %w", name, ErrorDeletedFileHasContents)
+	}
+	ErrorOnlyGarbage       = errors.New("patch with only garbage")
+	formatErrorOnlyGarbage = func(linenr int) error {
+		return fmt.Errorf("%w at line %d", ErrorOnlyGarbage, linenr)
+	}
+)
+
+// How names in patch lines end: at a tab and/or a space
+const (
+	termSpace = 1 << iota
+	termTab
+)
+
+// A hunk of a patch: oldLines lines from line oldPos replaced by newLines
+// lines from line newPos, with leading and trailing lines of context
+type fragment struct {
+	oldPos, oldLines  int
+	newPos, newLines  int
+	leading, trailing int
+	// the hunk as in the patch, its header line included
+	text string
+	// line of the patch input the header is on
+	linenr   int
+	rejected bool
+	// data of binary hunks: the new content or a delta to get it
+	data  []byte
+	delta bool
+}
+
+// The changes of a patch to one file. Names are empty for sides that do
+// not exist and modes zero if not known
+type patch struct {
+	oldName, newName string
+	// the name from the "diff --git" line
+	defName          string
+	oldMode, newMode int
+	// 1 if true, 0 if false and -1 if not known
+	isNew, isDelete  int
+	isRename, isCopy bool
+	// line of the first header line telling what the patch does
+	extensionLinenr int
+	binary          bool
+	fragments       []*fragment
+	linesAdded      int
+	linesDeleted    int
+	// digest prefixes from the "index" line
+	oldDigest, newDigest string
+	wsRule               WSRule
+
+	// failed to apply as a whole
+	rejected bool
+	// merged with conflicts by --3way
+	conflicted bool
+	// goes straight to the 3-way merge as the file to create exists
+	directToThreeWay bool
+	result           []byte
+	// blobs of the conflict stages, empty for missing ones
+	stages [3]string
+}
+
+// Length of the first line of buf with its line break
+func lineLen(buf string) int {
+	if i := strings.IndexByte(buf, '\n'); i != -1 {
+		return i + 1
+	}
+	return len(buf)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isDevNull(line string) bool {
+	rest, found := strings.CutPrefix(line, "/dev/null")
+	return found && rest != "" && isSpace(rest[0])
+}
+
+// Collapses runs of slashes
+func squashSlash(name string) string {
+	for strings.Contains(name, "//") {
+		name = strings.ReplaceAll(name, "//", "/")
+	}
+	return name
+}
+
+// Name of a quoted file name in a patch line with strip leading components
+// removed, empty if it has fewer
+func findNameGNU(line string, strip int) string {
+	name, _, err := quote.CUnquote(line)
+	if err != nil {
+		return ""
+	}
+	for ; strip > 0; strip -= 1 {
+		i := strings.IndexByte(name, '/')
+		if i == -1 {
+			return ""
+		}
+		name = name[i+1:]
+	}
+	return squashSlash(name)
+}
+
+// Name at the start of the line up to its end or the terminating
+// whitespace, with strip leading components removed. def is returned if
+// none is found or the name is def with something tacked on, as in
+// "file.orig". end limits the name to the bytes before it if not -1
+func findNameCommon(line string, def string, strip int, end int, terminate int) string {
+	start := -1
+	if strip == 0 {
+		start = 0
+	}
+	limit := len(line)
+	if end != -1 {
+		limit = end
+	}
+	i := 0
+	for ; i < limit; i += 1 {
+		c := line[i]
+		if end == -1 && isSpace(c) {
+			if c == '\n' || !(c == ' ' && terminate&termSpace == 0 || c == '\t' && terminate&termTab == 0) {
+				break
+			}
+		}
+		if c == '/' {
+			strip -= 1
+			if strip == 0 {
+				start = i + 1
+			}
+		}
+	}
+	if start == -1 || i == start {
+		return squashSlash(def)
+	}
+	name := line[start:i]
+	if def != "" && len(def) < len(name) && strings.HasPrefix(name, def) {
+		return squashSlash(def)
+	}
+	return squashSlash(name)
+}
+
+func findName(line string, def string, strip int, terminate int) string {
+	if strings.HasPrefix(line, `"`) {
+		if name := findNameGNU(line, strip); name != "" {
+			return name
+		}
+	}
+	return findNameCommon(line, def, strip, -1, terminate)
+}
+
+// Timestamps after names in "---" and "+++" lines, as GNU diff writes them
+var diffTimestamp = regexp.MustCompile(`(\t| +)\d{4}-\d\d-\d\d( \d\d:\d\d:\d\d(\.\d+)?)?( [-+]\d\d:?\d\d)?$`)
+
+// Name of a "---" or "+++" line, which may be followed by a timestamp
+func findNameTraditional(line string, def string, strip int) string {
+	if strings.HasPrefix(line, `"`) {
+		if name := findNameGNU(line, strip); name != "" {
+			return name
+		}
+	}
+	line = line[:lineLen(line)]
+	loc := diffTimestamp.FindStringIndex(strings.TrimSuffix(line, "\n"))
+	if loc == nil {
+		return findNameCommon(line, def, strip, -1, termTab)
+	}
+	return findNameCommon(line, def, strip, loc[0], 0)
+}
+
+var epochTimestamp = regexp.MustCompile(`^[0-2][0-9]:([0-5][0-9]):00(\.0+)? ([-+][0-2][0-9]:?[0-5][0-9])\n`)
+
+// Whether the timestamp after the tab of the "---" or "+++" line is the
+// epoch, which GNU diff shows for missing files
+func hasEpochTimestamp(line string) bool {
+	line = line[:lineLen(line)]
+	i := strings.LastIndexByte(line, '\t')
+	if i == -1 {
+		return false
+	}
+	timestamp := line[i+1:]
+	epochHour := 0
+	if rest, found := strings.CutPrefix(timestamp, "1969-12-31 "); found {
+		timestamp, epochHour = rest, 24
+	} else if rest, found := strings.CutPrefix(timestamp, "1970-01-01 "); found {
+		timestamp = rest
+	} else {
+		return false
+	}
+	m := epochTimestamp.FindStringSubmatch(timestamp)
+	if m == nil {
+		return false
+	}
+	hour, _ := strconv.Atoi(timestamp[:2])
+	minute, _ := strconv.Atoi(m[1])
+	zone := strings.ReplaceAll(m[3][1:], ":", "")
+	zoneHours, _ := strconv.Atoi(zone[:2])
+	zoneMinutes, _ := strconv.Atoi(zone[2:])
+	offset := zoneHours*60 + zoneMinutes
+	if m[3][0] == '-' {
+		offset = -offset
+	}
+	return hour*60+minute-offset == epochHour*60
+}
+
+// Number of leading components of the name in the "---" or "+++" line,
+// -1 if it cannot be told
+func guessStrip(line string) int {
+	if isDevNull(line) {
+		return -1
+	}
+	name := findNameTraditional(line, "", 0)
+	if name == "" {
+		return -1
+	}
+	if !strings.Contains(name, "/") {
+		return 0
+	}
+	return -1
+}
+
+// The rest of the line after strip leading components, nil if it has fewer
+// or starts with a slash
+func skipTreePrefix(strip int, line string) (string, bool) {
+	if strip == 0 {
+		return line, line == "" || line[0] != '/'
+	}
+	for i := 0; i < len(line); i += 1 {
+		if line[i] != '/' {
+			continue
+		}
+		strip -= 1
+		if strip <= 0 {
+			return line[i+1:], i != 0
+		}
+	}
+	return "", false
+}
+
+// Name of the file in the "diff --git" line if both sides name the same
+// file, which is all it can be told for without other header lines
+func gitHeaderName(strip int, line string) string {
+	line = strings.TrimPrefix(line, "diff --git ")
+	if strings.HasPrefix(line, `"`) {
+		first, second, err := quote.CUnquote(line)
+		if err != nil {
+			return ""
+		}
+		first, ok := skipTreePrefix(strip, first)
+		if !ok {
+			return ""
+		}
+		second = strings.TrimLeft(second, " \t\n\r")
+		if second == "" {
+			return ""
+		}
+		if strings.HasPrefix(second, `"`) {
+			other, _, err := quote.CUnquote(second)
+			if err != nil {
+				return ""
+			}
+			if other, ok = skipTreePrefix(strip, other); !ok || other != first {
+				return ""
+			}
+			return first
+		}
+		if second, ok = skipTreePrefix(strip, second); !ok || second != first {
+			return ""
+		}
+		return first
+	}
+	name, ok := skipTreePrefix(strip, line)
+	if !ok {
+		return ""
+	}
+	// with the first name unquoted, a quote starts the second one
+	if i := strings.IndexByte(name, '"'); i != -1 {
+		second, _, err := quote.CUnquote(name[i:])
+		if err != nil {
+			return ""
+		}
+		if second, ok = skipTreePrefix(strip, second); !ok {
+			return ""
+		}
+		if len(second) < i && strings.HasPrefix(name, second) && isSpace(name[len(second)]) {
+			return second
+		}
+		return ""
+	}
+	// otherwise the name must show up twice the same
+	eol := strings.IndexByte(name, '\n')
+	if eol == -1 {
+		return ""
+	}
+	for n := 0; n < eol; n += 1 {
+		if name[n] != ' ' && name[n] != '\t' {
+			continue
+		}
+		second, ok := skipTreePrefix(strip, name[n+1:eol])
+		if !ok {
+			return ""
+		}
+		if second == name[:n] {
+			return second
+		}
+	}
+	return ""
+}
+
+// Parses the "--- " and "+++ " lines of a traditional patch
+func (s *state) parseTraditional(first string, second string, p *patch) error {
+	first, second = first[4:], second[4:]
+	if !s.stripKnown {
+		a, b := guessStrip(first), guessStrip(second)
+		if a < 0 {
+			a = b
+		}
+		if 0 <= a && a == b {
+			s.strip, s.stripKnown = a, true
+		}
+	}
+	var name string
+	switch {
+	case isDevNull(first):
+		p.isNew, p.isDelete = 1, 0
+		name = findNameTraditional(second, "", s.strip)
+		p.newName = name
+	case isDevNull(second):
+		p.isNew, p.isDelete = 0, 1
+		name = findNameTraditional(first, "", s.strip)
+		p.oldName = name
+	default:
+		firstName := findNameTraditional(first, "", s.strip)
+		name = findNameTraditional(second, firstName, s.strip)
+		switch {
+		case hasEpochTimestamp(first):
+			p.isNew, p.isDelete = 1, 0
+			p.newName = name
+		case hasEpochTimestamp(second):
+			p.isNew, p.isDelete = 0, 1
+			p.oldName = name
+		default:
+			p.oldName, p.newName = name, name
+		}
+	}
+	if name == "" {
+		return formatErrorNoFilename(s.linenr)
+	}
+	return nil
+}
+
+func parseMode(line string, linenr int) (int, error) {
+	n := 0
+	for n < len(line) && '0' <= line[n] && line[n] <= '7' {
+		n += 1
+	}
+	if n == 0 || n == len(line) || !isSpace(line[n]) {
+		return 0, formatErrorInvalidMode(linenr, line)
+	}
+	mode, _ := strconv.ParseUint(line[:n], 8, 32)
+	return int(mode), nil
+}
+
+// Checks a name of a "---" or "+++" line of a git patch against what the
+// other header lines told
+func (s *state) verifyGitName(line string, isNull bool, name *string, side string, linenr int) error {
+	if *name == "" && !isNull {
+		*name = findName(line, "", s.strip, termTab)
+		return nil
+	}
+	if *name != "" {
+		if isNull {
+			return formatErrorBadGitDiff(linenr, fmt.Sprintf("expected /dev/null, got %v", *name))
+		}
+		if another := findName(line, "", s.strip, termTab); another != *name {
+			return formatErrorBadGitDiff(linenr, fmt.Sprintf("inconsistent %v filename", side))
+		}
+		return nil
+	}
+	if !isDevNull(line) {
+		return formatErrorBadGitDiff(linenr, "expected /dev/null")
+	}
+	return nil
+}
+
+// Parses the header line of a git patch after its prefix, returning true
+// if the header ends there
+func (s *state) parseGitHeaderLine(prefix string, line string, p *patch, linenr int) (bool, error) {
+	var err error
+	// copies and renames name the files without their "a/" and "b/"
+	strip := max(s.strip-1, 0)
+	switch prefix {
+	case "@@ -", "":
+		return true, nil
+	case "--- ":
+		err = s.verifyGitName(line, p.isNew == 1, &p.oldName, "old", linenr)
+	case "+++ ":
+		err = s.verifyGitName(line, p.isDelete == 1, &p.newName, "new", linenr)
+	case "old mode ":
+		p.oldMode, err = parseMode(line, linenr)
+	case "new mode ":
+		p.newMode, err = parseMode(line, linenr)
+	case "deleted file mode ":
+		p.isDelete, p.oldName = 1, p.defName
+		p.oldMode, err = parseMode(line, linenr)
+	case "new file mode ":
+		p.isNew, p.newName = 1, p.defName
+		p.newMode, err = parseMode(line, linenr)
+	case "copy from ":
+		p.isCopy, p.oldName = true, findName(line, "", strip, 0)
+	case "copy to ":
+		p.isCopy, p.newName = true, findName(line, "", strip, 0)
+	case "rename old ", "rename from ":
+		p.isRename, p.oldName = true, findName(line, "", strip, 0)
+	case "rename new ", "rename to ":
+		p.isRename, p.newName = true, findName(line, "", strip, 0)
+	case "index ":
+		err = parseIndexLine(line, p, linenr)
+	}
+	return false, err
+}
+
+// Parses "<old>..<new>[ <mode>]" of an "index" line
+func parseIndexLine(line string, p *patch, linenr int) error {
+	old, rest, found := strings.Cut(line, "..")
+	if !found || strings.Contains(old, ".") || len(old) > 2*repr.HashSize() {
+		return nil
+	}
+	eol := strings.IndexByte(rest, '\n')
+	if eol == -1 {
+		eol = len(rest)
+	}
+	end := strings.IndexByte(rest[:eol], ' ')
+	if end == -1 {
+		end = eol
+	}
+	if end > 2*repr.HashSize() {
+		return nil
+	}
+	p.oldDigest, p.newDigest = old, rest[:end]
+	if end < len(rest) && rest[end] == ' ' {
+		var err error
+		p.oldMode, err = parseMode(rest[end+1:], linenr)
+		return err
+	}
+	return nil
+}
+
+var gitHeaderPrefixes = []string{
+	"@@ -", "--- ", "+++ ", "old mode ", "new mode ", "deleted file mode ", "new file mode ",
+	"copy from ", "copy to ", "rename old ", "rename new ", "rename from ", "rename to ",
+	"similarity index ", "dissimilarity index ", "index ", "",
+}
+
+// Parses the header of a git patch starting with the "diff --git" line of
+// the given length, returning the length of the header
+func (s *state) parseGitHeader(buf string, n int, p *patch) (int, error) {
+	p.isNew, p.isDelete = 0, 0
+	p.defName = squashSlash(gitHeaderName(s.strip, buf[:n]))
+	linenr := s.linenr + 1
+	s.linenr += 1
+	offset := n
+	for offset < len(buf) {
+		line := buf[offset:]
+		n = lineLen(line)
+		if line[n-1] != '\n' {
+			break
+		}
+		done := false
+		for _, prefix := range gitHeaderPrefixes {
+			if !strings.HasPrefix(line[:n], prefix) {
+				continue
+			}
+			var err error
+			if done, err = s.parseGitHeaderLine(prefix, line[len(prefix):n], p, linenr); err != nil {
+				return 0, err
+			}
+			extensions := 0
+			for _, is := range []bool{p.isDelete == 1, p.isNew == 1, p.isRename, p.isCopy} {
+				if is {
+					extensions += 1
+				}
+			}
+			if extensions > 1 {
+				return 0, formatErrorInconsistentHeader(p.extensionLinenr, s.linenr)
+			}
+			if extensions > 0 && p.extensionLinenr == 0 {
+				p.extensionLinenr = s.linenr
+			}
+			break
+		}
+		if done {
+			break
+		}
+		offset += n
+		s.linenr += 1
+	}
+	if p.oldName == "" && p.newName == "" {
+		if p.defName == "" {
+			return 0, formatErrorGitHeaderNoFilenameStrip(s.strip, s.linenr)
+		}
+		p.oldName, p.newName = p.defName, p.defName
+	}
+	if p.newName == "" && p.isDelete != 1 || p.oldName == "" && p.isNew != 1 {
+		return 0, formatErrorGitHeaderNoFilename(s.linenr)
+	}
+	return offset, nil
+}
+
+// Parses "@@ -<pos>[,<lines>] +<pos>[,<lines>] @@" at the start of the
+// line into the fragment, returning the length parsed
+func parseFragmentHeader(line string, f *fragment) (int, error) {
+	if !strings.HasSuffix(line, "\n") {
+		return 0, ErrorCorruptPatch
+	}
+	offset, err := parseRange(line, 4, " +", &f.oldPos, &f.oldLines)
+	if err != nil {
+		return 0, err
+	}
+	return parseRange(line, offset, " @@", &f.newPos, &f.newLines)
+}
+
+func parseRange(line string, offset int, expect string, pos *int, lines *int) (int, error) {
+	digits := func(s string) int {
+		n := 0
+		for n < len(s) && isDigit(s[n]) {
+			n += 1
+		}
+		return n
+	}
+	rest := line[offset:]
+	n := digits(rest)
+	if n == 0 {
+		return 0, ErrorCorruptPatch
+	}
+	*pos, _ = strconv.Atoi(rest[:n])
+	*lines = 1
+	rest = rest[n:]
+	if strings.HasPrefix(rest, ",") {
+		n = digits(rest[1:])
+		if n == 0 {
+			return 0, ErrorCorruptPatch
+		}
+		*lines, _ = strconv.Atoi(rest[1 : n+1])
+		rest = rest[n+1:]
+	}
+	if !strings.HasPrefix(rest, expect) {
+		return 0, ErrorCorruptPatch
+	}
+	return len(line) - len(rest) + len(expect), nil
+}
+
+// Finds the header of the next patch in buf, returning its offset and
+// length, or -1 if there is none
+func (s *state) findHeader(buf string, p *patch) (int, int, error) {
+	p.isNew, p.isDelete = -1, -1
+	for offset := 0; offset < len(buf); offset, s.linenr = offset+lineLen(buf[offset:]), s.linenr+1 {
+		line := buf[offset:]
+		n := lineLen(line)
+		if n < 6 {
+			continue
+		}
+		// a hunk before any header means the patch is broken
+		if strings.HasPrefix(line, "@@ -") {
+			if _, err := parseFragmentHeader(line[:n], &fragment{}); err != nil {
+				continue
+			}
+			return 0, 0, formatErrorFragmentWithoutHeader(s.linenr, line[:n-1])
+		}
+		if len(line) < n+6 {
+			break
+		}
+		// git patches may have no hunks for renames or mode changes
+		if strings.HasPrefix(line, "diff --git ") {
+			size, err := s.parseGitHeader(line, n, p)
+			if err != nil {
+				return 0, 0, err
+			}
+			if size <= n {
+				continue
+			}
+			return offset, size, nil
+		}
+		if !strings.HasPrefix(line, "--- ") || !strings.HasPrefix(line[n:], "+++ ") {
+			continue
+		}
+		// at least "@@ -0,0 +1 @@\n" must follow
+		next := lineLen(line[n:])
+		if len(line) < n+next+14 || !strings.HasPrefix(line[n+next:], "@@ -") {
+			continue
+		}
+		if err := s.parseTraditional(line[:n], line[n:n+next], p); err != nil {
+			return 0, 0, err
+		}
+		s.linenr += 2
+		return offset, n + next, nil
+	}
+	return -1, 0, nil
+}
+
+// Parses a hunk starting at the header line, returning its length with a
+// trailing "\ No newline at end of file"
+func (s *state) parseFragment(buf string, p *patch, f *fragment) (int, bool) {
+	n := lineLen(buf)
+	if _, err := parseFragmentHeader(buf[:n], f); err != nil {
+		return 0, false
+	}
+	oldLines, newLines := f.oldLines, f.newLines
+	added, deleted := 0, 0
+	offset := n
+	s.linenr += 1
+	for ; offset < len(buf) && (oldLines > 0 || newLines > 0); offset, s.linenr = offset+n, s.linenr+1 {
+		line := buf[offset:]
+		n = lineLen(line)
+		if line[n-1] != '\n' {
+			return 0, false
+		}
+		switch line[0] {
+		case '\n', ' ':
+			// an empty line is empty context, as newer GNU diff writes it
+			oldLines -= 1
+			newLines -= 1
+			if deleted == 0 && added == 0 {
+				f.leading += 1
+			}
+			f.trailing += 1
+			if !s.opts.Reverse && s.wsAction == WSFix {
+				s.checkWhitespace(line[:n], p.wsRule)
+			}
+		case '-':
+			if s.opts.Reverse && s.wsAction != WSNoWarn {
+				s.checkWhitespace(line[:n], p.wsRule)
+			}
+			deleted += 1
+			oldLines -= 1
+			f.trailing = 0
+		case '+':
+			if !s.opts.Reverse && s.wsAction != WSNoWarn {
+				s.checkWhitespace(line[:n], p.wsRule)
+			}
+			added += 1
+			newLines -= 1
+			f.trailing = 0
+		case '\\':
+			// "\ No newline at end of file", whatever the language
+			if n < 12 || !strings.HasPrefix(line, `\ `) {
+				return 0, false
+			}
+		default:
+			return 0, false
+		}
+	}
+	if oldLines != 0 || newLines != 0 || deleted == 0 && added == 0 {
+		return 0, false
+	}
+	// the hunk ends with an incomplete line
+	if rest := buf[offset:]; len(rest) > 12 && strings.HasPrefix(rest, `\ `) {
+		offset += lineLen(rest)
+	}
+	p.linesAdded += added
+	p.linesDeleted += deleted
+	return offset, true
+}
+
+// Parses the hunks of the patch, returning their length
+func (s *state) parseSinglePatch(buf string, p *patch) (int, error) {
+	offset := 0
+	oldLines, newLines, context := 0, 0, 0
+	for len(buf)-offset > 4 && strings.HasPrefix(buf[offset:], "@@ -") {
+		f := &fragment{linenr: s.linenr}
+		n, ok := s.parseFragment(buf[offset:], p, f)
+		if !ok {
+			return 0, formatErrorCorruptPatch(s.linenr)
+		}
+		f.text = buf[offset : offset+n]
+		oldLines += f.oldLines
+		newLines += f.newLines
+		context += f.leading + f.trailing
+		p.fragments = append(p.fragments, f)
+		offset += n
+	}
+	// with old lines it cannot be a creation and with new ones not a
+	// deletion; a patch of more than one hunk is neither
+	if p.isNew < 0 && (oldLines > 0 || len(p.fragments) > 1) {
+		p.isNew = 0
+	}
+	if p.isDelete < 0 && (newLines > 0 || len(p.fragments) > 1) {
+		p.isDelete = 0
+	}
+	if p.isNew > 0 && oldLines > 0 {
+		return 0, formatErrorNewFileDependsOnOld(p.newName)
+	}
+	if p.isDelete > 0 && newLines > 0 {
+		return 0, formatErrorDeletedFileHasContents(p.oldName)
+	}
+	if p.isDelete == 0 && newLines == 0 && context > 0 {
+		s.say("** warning: file %v becomes empty but is not deleted\n", p.newName)
+	}
+	return offset, nil
+}
+
+// Whether the patch changes anything besides the content
+func (p *patch) metadataChanges() bool {
+	return p.isRename || p.isCopy || p.isNew > 0 || p.isDelete != 0 ||
+		p.oldMode != 0 && p.newMode != 0 && p.oldMode != p.newMode
+}
+
+// Parses the next patch in buf, returning the length it takes with
+// anything before it, or -1 if there are no more
+func (s *state) parseChunk(buf string, p *patch) (int, error) {
+	offset, size, err := s.findHeader(buf, p)
+	if err != nil || offset < 0 {
+		return offset, err
+	}
+	name := p.newName
+	if name == "" {
+		name = p.oldName
+	}
+	if p.wsRule, err = s.wsRuleFor(name); err != nil {
+		return 0, err
+	}
+	start := offset + size
+	patchSize, err := s.parseSinglePatch(buf[start:], p)
+	if err != nil {
+		return 0, err
+	}
+	if patchSize > 0 {
+		return start + patchSize, nil
+	}
+	rest := buf[start:]
+	n := lineLen(rest)
+	if rest[:n] == "GIT binary patch\n" {
+		s.linenr += 1
+		used, err := s.parseBinary(rest[n:], p)
+		if err != nil {
+			s.errorf("%v", err)
+			return -1, nil
+		}
+		if used > 0 {
+			patchSize = used + n
+		}
+	} else if strings.HasSuffix(rest[:n], " differ\n") {
+		for _, prefix := range []string{"Binary files ", "Files "} {
+			if len(prefix) < len(rest) && strings.HasPrefix(rest, prefix) {
+				s.linenr += 1
+				p.binary = true
+				patchSize = n
+				break
+			}
+		}
+	}
+	// a text patch without hunks must change something else
+	if (s.apply || s.opts.Check) && !p.binary && !p.metadataChanges() {
+		return 0, formatErrorOnlyGarbage(s.linenr)
+	}
+	return start + patchSize, nil
+}
@@ -0,0 +1,276 @@
+package apply
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/magnickolas/gitok/diff"
+	"github.com/magnickolas/gitok/index"
+	"github.com/magnickolas/gitok/index/parser"
+	"github.com/magnickolas/gitok/repr"
+	"github.com/magnickolas/gitok/strerror"
+)
+
+var (
+	ErrorWriteFile       = errors.New("unable to write file")
+	formatErrorWriteFile = func(name string, mode int, err error) error {
+		return fmt.Errorf("%w '%v' mode %o: %v", ErrorWriteFile, name, mode, err)
+	}
+	ErrorCorruptSubmodulePatch       = errors.New("corrupt patch for submodule")
+	formatErrorCorruptSubmodulePatch = func(name string) error {
+		return fmt.Errorf("%w %v", ErrorCorruptSubmodulePatch, name)
+	}
+)
+
+// Writes the results of the patches that apply, removing the old files
+// first so that renames and type changes find the way clear. Returns
+// whether any patch was rejected in part or as a whole or conflicted
+func (s *state) writeOutResults(patches []*patch) (bool, error) {
+	errs := false
+	var conflicted []string
+	for phase := 0; phase < 2; phase += 1 {
+		for _, p := range patches {
+			if p.rejected {
+				errs = true
+				continue
+			}
+			if err := s.writeOutOneResult(p, phase); err != nil {
+				return false, err
+			}
+			if phase == 0 {
+				continue
+			}
+			if s.writeOutOneReject(p) {
+				errs = true
+			}
+			if p.conflicted {
+				conflicted = append(conflicted, p.newName)
+				errs = true
+			}
+		}
+	}
+	slices.Sort(conflicted)
+	for _, name := range conflicted {
+		s.say("U %v\n", name)
+	}
+	return errs, nil
+}
+
+func (s *state) writeOutOneResult(p *patch, phase int) error {
+	switch {
+	case p.isDelete > 0:
+		if phase == 0 {
+			return s.removeFile(p, true)
+		}
+	case p.isNew > 0 || p.isCopy:
+		if phase == 1 {
+			return s.createFile(p)
+		}
+	case phase == 0:
+		// a rename or a modification: the old file goes and the new comes
+		return s.removeFile(p, p.isRename)
+	default:
+		return s.createFile(p)
+	}
+	return nil
+}
+
+func (s *state) removeFile(p *patch, removeEmptyDirs bool) error {
+	if s.updateIndex {
+		s.removeFromIndex(p.oldName)
+	}
+	if s.opts.Cached {
+		return nil
+	}
+	path := filepath.FromSlash(p.oldName)
+	if err := os.Remove(path); err != nil && !isMissing(err) {
+		what := "unlink"
+		if p.oldMode == modeGitlink {
+			what = "rmdir"
+		}
+		s.warnf("unable to %v '%v': %v", what, p.oldName, strerror.Describe(err))
+		return nil
+	}
+	if removeEmptyDirs {
+		for dir := filepath.Dir(path); dir != "."; dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (s *state) createFile(p *patch) error {
+	mode := p.newMode
+	if mode == 0 {
+		mode = modeRegular
+	}
+	if !s.opts.Cached {
+		if err := s.createOneFile(p.newName, mode, p.result); err != nil {
+			return err
+		}
+	}
+	if p.conflicted {
+		return s.addConflictedStages(p, mode)
+	}
+	if s.updateIndex {
+		return s.addIndexFile(p.newName, mode, p.result)
+	}
+	return nil
+}
+
+// Creates the file with its leading directories, replacing whatever is in
+// the way unless it is a directory with files
+func (s *state) createOneFile(name string, mode int, content []byte) error {
+	path := filepath.FromSlash(name)
+	if mode == modeGitlink {
+		if info, err := os.Lstat(path); err == nil && info.IsDir() {
+			return nil
+		}
+		if err := os.MkdirAll(path, 0777); err != nil {
+			return formatErrorWriteFile(name, mode, err)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return formatErrorWriteFile(name, mode, err)
+	}
+	if err := s.writeFile(name, mode, content); err != nil {
+		return formatErrorWriteFile(name, mode, err)
+	}
+	return nil
+}
+
+// Writes repository content to the worktree file of the mode
+func (s *state) writeFile(name string, mode int, content []byte) error {
+	path := filepath.FromSlash(name)
+	if err := os.Remove(path); err != nil && !isMissing(err) {
+		return err
+	}
+	if mode == modeSymlink {
+		return os.Symlink(string(content), path)
+	}
+	if s.opts.Converter != nil {
+		var err error
+		if content, err = s.opts.Converter.ToWorktree(name, content); err != nil {
+			return err
+		}
+	}
+	perm := os.FileMode(0666)
+	if mode&0100 != 0 {
+		perm = 0777
+	}
+	return os.WriteFile(path, content, perm)
+}
+
+// Puts the result of the patch in the index at stage 0, with the stat data
+// of the file written unless only the index is patched
+func (s *state) addIndexFile(name string, mode int, content []byte) error {
+	var info os.FileInfo
+	var digest string
+	if mode == modeGitlink {
+		hex, found := strings.CutPrefix(string(content), "Subproject commit ")
+		hex = strings.TrimSuffix(hex, "\n")
+		if !found || !repr.IsValidDigest(hex) {
+			return formatErrorCorruptSubmodulePatch(name)
+		}
+		digest = hex
+	} else {
+		if !s.opts.Cached {
+			var err error
+			if info, err = os.Lstat(filepath.FromSlash(name)); err != nil {
+				return err
+			}
+		}
+		blob, err := repr.NewBlob(bytes.NewReader(content))
+		if err != nil {
+			return err
+		}
+		if err := s.db.WriteObject(blob); err != nil {
+			return err
+		}
+		digest = blob.Digest()
+	}
+	s.removeFromIndex(name)
+	s.idx.Entries = append(s.idx.Entries, index.NewEntry(name, info, indexMode(mode), digest))
+	return nil
+}
+
+// Records the sides of the conflicted merge in the index stages
+func (s *state) addConflictedStages(p *patch, mode int) error {
+	if !s.updateIndex {
+		return nil
+	}
+	s.removeFromIndex(p.newName)
+	for i, digest := range p.stages {
+		if digest == "" {
+			continue
+		}
+		entry := index.NewEntry(p.newName, nil, indexMode(mode), digest)
+		entry.Flags = uint32(i+1) << 12
+		s.idx.Entries = append(s.idx.Entries, entry)
+	}
+	return nil
+}
+
+// Removes all the stages of the path from the index
+func (s *state) removeFromIndex(name string) {
+	s.idx.Entries = slices.DeleteFunc(s.idx.Entries, func(entry parser.Entry) bool {
+		return entry.Name == name
+	})
+}
+
+func indexMode(mode int) repr.ObjectModeType {
+	switch mode & modeType {
+	case modeSymlink:
+		return repr.ModeSymbolicLink
+	case modeGitlink:
+		return repr.ModeGitlink
+	}
+	if mode&0100 != 0 {
+		return repr.ModeExecutable
+	}
+	return repr.ModeNormal
+}
+
+// Writes the rejected hunks of the patch next to its file, telling which
+// ones applied. Returns whether there were any
+func (s *state) writeOutOneReject(p *patch) bool {
+	rejected := 0
+	for _, f := range p.fragments {
+		if f.rejected {
+			rejected += 1
+		}
+	}
+	if rejected == 0 {
+		if s.opts.Verbosity == VerbosityVerbose {
+			s.sayPatchName("Applied patch %v cleanly.", p)
+		}
+		return false
+	}
+	s.sayPatchName(fmt.Sprintf("Applying patch %%v with %d %v...", rejected, diff.Plural(rejected, "reject", "rejects")), p)
+	var b strings.Builder
+	fmt.Fprintf(&b, "diff a/%v b/%v\t(rejected hunks)\n", p.newName, p.newName)
+	for i, f := range p.fragments {
+		if !f.rejected {
+			s.say("Hunk #%d applied cleanly.\n", i+1)
+			continue
+		}
+		s.say("Rejected hunk #%d.\n", i+1)
+		b.WriteString(f.text)
+		if !strings.HasSuffix(f.text, "\n") {
+			b.WriteString("\n")
+		}
+	}
+	name := p.newName + ".rej"
+	if err := os.WriteFile(filepath.FromSlash(name), []byte(b.String()), 0666); err != nil {
+		s.errorf("cannot open %v: %v", name, strerror.Describe(err))
+	}
+	return true
+}
@@ -0,0 +1,260 @@
+package apply
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/magnickolas/gitok/attr"
+)
+
+var (
+	ErrorTabInIndentConflict = errors.New("cannot enforce both tab-in-indent and indent-with-non-tab")
+)
+
+// Whitespace problems looked for, as set by core.whitespace and the
+// whitespace attribute; the low bits hold the tab width
+type WSRule uint
+
+const (
+	WSBlankAtEOL WSRule = 0100 << iota
+	WSSpaceBeforeTab
+	WSIndentWithNonTab
+	// a CR before the line break is not trailing whitespace
+	WSCRAtEOL
+	WSBlankAtEOF
+	WSTabInIndent
+
+	wsTrailingSpace = WSBlankAtEOL | WSBlankAtEOF
+	wsTabWidthMask  = 077
+	DefaultWSRule   = wsTrailingSpace | WSSpaceBeforeTab | 8
+)
+
+var wsRuleNames = []struct {
+	name string
+	rule WSRule
+	// left out of the rules the whitespace attribute turns on
+	loosens, notDefault bool
+}{
+	{"trailing-space", wsTrailingSpace, false, false},
+	{"space-before-tab", WSSpaceBeforeTab, false, false},
+	{"indent-with-non-tab", WSIndentWithNonTab, false, false},
+	{"cr-at-eol", WSCRAtEOL, true, false},
+	{"blank-at-eol", WSBlankAtEOL, false, false},
+	{"blank-at-eof", WSBlankAtEOF, false, false},
+	{"tab-in-indent", WSTabInIndent, false, true},
+}
+
+// Parses a comma-separated list of rules on top of the default ones, as
+// core.whitespace; a leading "-" turns a rule off and names may be
+// abbreviated. Warnings go to warn
+func ParseWSRule(value string, warn func(string)) (WSRule, error) {
+	rule := DefaultWSRule
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimLeft(item, " \t\n\r")
+		negated := false
+		if rest, found := strings.CutPrefix(item, "-"); found {
+			item, negated = rest, true
+		}
+		if item == "" {
+			continue
+		}
+		for _, r := range wsRuleNames {
+			if !strings.HasPrefix(r.name, item) {
+				continue
+			}
+			if negated {
+				rule &^= r.rule
+			} else {
+				rule |= r.rule
+			}
+			break
+		}
+		if arg, found := strings.CutPrefix(item, "tabwidth="); found {
+			if width, err := strconv.Atoi(arg); err == nil && 0 < width && width < 0100 {
+				rule = rule&^wsTabWidthMask | WSRule(width)
+			} else {
+				warn(fmt.Sprintf("tabwidth %v out of range", arg))
+			}
+		}
+	}
+	if rule&WSTabInIndent != 0 && rule&WSIndentWithNonTab != 0 {
+		return 0, ErrorTabInIndentConflict
+	}
+	return rule, nil
+}
+
+// Rules for the path given the whitespace attribute: all of them if set,
+// none if unset and the listed ones if valued
+func wsRuleFor(base WSRule, value attr.Value, warn func(string)) (WSRule, error) {
+	switch value.State {
+	case attr.Set:
+		rule := base.tabWidthBits()
+		for _, r := range wsRuleNames {
+			if !r.loosens && !r.notDefault {
+				rule |= r.rule
+			}
+		}
+		return rule, nil
+	case attr.Unset:
+		return base.tabWidthBits(), nil
+	case attr.Valued:
+		return ParseWSRule(value.Value, warn)
+	}
+	return base, nil
+}
+
+func (r WSRule) tabWidthBits() WSRule {
+	return r & wsTabWidthMask
+}
+
+func (r WSRule) tabWidth() int {
+	return int(r & wsTabWidthMask)
+}
+
+// Whitespace characters as git counts them
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isBlank(line string) bool {
+	for i := 0; i < len(line); i += 1 {
+		if !isSpace(line[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Whitespace problems of the line the rules look for
+func (r WSRule) check(line string) WSRule {
+	var res WSRule
+	line = strings.TrimSuffix(line, "\n")
+	if r&WSCRAtEOL != 0 {
+		line = strings.TrimSuffix(line, "\r")
+	}
+	trailing := len(line)
+	if r&WSBlankAtEOL != 0 {
+		for ; trailing > 0 && isSpace(line[trailing-1]); trailing -= 1 {
+			res |= WSBlankAtEOL
+		}
+	}
+	written, i := 0, 0
+	for ; i < trailing; i += 1 {
+		if line[i] == ' ' {
+			continue
+		}
+		if line[i] != '\t' {
+			break
+		}
+		if r&WSSpaceBeforeTab != 0 && written < i {
+			res |= WSSpaceBeforeTab
+		} else if r&WSTabInIndent != 0 {
+			res |= WSTabInIndent
+		}
+		written = i + 1
+	}
+	if r&WSIndentWithNonTab != 0 && i-written >= r.tabWidth() {
+		res |= WSIndentWithNonTab
+	}
+	return res
+}
+
+// Describes the problems, as in "trailing whitespace, indent with spaces"
+func (r WSRule) String() string {
+	var res []string
+	if r&wsTrailingSpace == wsTrailingSpace || r&WSBlankAtEOL != 0 {
+		res = append(res, "trailing whitespace")
+	}
+	if r&wsTrailingSpace != wsTrailingSpace && r&WSBlankAtEOF != 0 {
+		res = append(res, "new blank line at EOF")
+	}
+	if r&WSSpaceBeforeTab != 0 {
+		res = append(res, "space before tab in indent")
+	}
+	if r&WSIndentWithNonTab != 0 {
+		res = append(res, "indent with spaces")
+	}
+	if r&WSTabInIndent != 0 {
+		res = append(res, "tab in indent")
+	}
+	return strings.Join(res, ", ")
+}
+
+// The line with the problems the rules look for fixed: trailing whitespace
+// stripped and the indentation rewritten. Returns whether anything changed
+func (r WSRule) fix(line string) (string, bool) {
+	var b strings.Builder
+	fixed := false
+	eol := ""
+	if r&WSBlankAtEOL != 0 {
+		if rest, found := strings.CutSuffix(line, "\n"); found {
+			line, eol = rest, "\n"
+			if rest, found := strings.CutSuffix(line, "\r"); found {
+				line = rest
+				if r&WSCRAtEOL != 0 {
+					eol = "\r\n"
+				}
+			}
+		}
+		if trimmed := strings.TrimRight(line, " \t\n\r"); len(trimmed) != len(line) {
+			line, fixed = trimmed, true
+		}
+	}
+	lastTab, lastSpace := -1, -1
+	fixIndent := false
+	for i := 0; i < len(line); i += 1 {
+		if line[i] == '\t' {
+			lastTab = i
+			if r&WSSpaceBeforeTab != 0 && lastSpace >= 0 {
+				fixIndent = true
+			}
+		} else if line[i] == ' ' {
+			lastSpace = i
+			if r&WSIndentWithNonTab != 0 && r.tabWidth() <= i-lastTab {
+				fixIndent = true
+			}
+		} else {
+			break
+		}
+	}
+	switch {
+	case fixIndent:
+		// spaces in the indentation become tabs where they fill one
+		last := lastTab + 1
+		if r&WSIndentWithNonTab != 0 {
+			last = max(lastTab, lastSpace) + 1
+		}
+		spaces := 0
+		for i := 0; i < last; i += 1 {
+			if line[i] != ' ' {
+				spaces = 0
+				b.WriteByte(line[i])
+				continue
+			}
+			spaces += 1
+			if spaces == r.tabWidth() {
+				b.WriteByte('\t')
+				spaces = 0
+			}
+		}
+		b.WriteString(strings.Repeat(" ", spaces))
+		line, fixed = line[last:], true
+	case r&WSTabInIndent != 0 && lastTab >= 0:
+		// tabs in the indentation become spaces
+		for i := 0; i <= lastTab; i += 1 {
+			if line[i] != '\t' {
+				b.WriteByte(line[i])
+				continue
+			}
+			for b.WriteByte(' '); b.Len()%r.tabWidth() != 0; {
+				b.WriteByte(' ')
+			}
+		}
+		line, fixed = line[lastTab+1:], true
+	}
+	b.WriteString(line)
+	b.WriteString(eol)
+	return b.String(), fixed
+}
@@ -0,0 +1,32 @@
+package apply_test
+
+import (
+	"testing"
+
+	"github.com/magnickolas/gitok/apply"
+)
+
+func TestParseWSRule(t *testing.T) {
+	tests := []struct {
+		value string
+		want  apply.WSRule
+	}{
+		{value: "", want: apply.DefaultWSRule},
+		{value: "-trailing", want: apply.WSSpaceBeforeTab | 8},
+		{value: "cr-at-eol,tabwidth=4", want: apply.DefaultWSRule&^8 | apply.WSCRAtEOL | 4},
+		{value: "-blank-at-eof, tab-in-indent", want: apply.DefaultWSRule&^apply.WSBlankAtEOF | apply.WSTabInIndent},
+	}
+	for _, test := range tests {
+		got, err := apply.ParseWSRule(test.value, func(string) {})
+		if err != nil {
+			t.Errorf("failed to parse %#v: %v", test.value, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("incorrect result for %#v: wanted %#v, got %#v", test.value, test.want, got)
+		}
+	}
+	if _, err := apply.ParseWSRule("tab-in-indent,indent-with-non-tab", func(string) {}); err == nil {
+		t.Errorf("expected an error for conflicting rules")
+	}
+}
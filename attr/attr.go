@@ -0,0 +1,255 @@
+package attr
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/magnickolas/gitok/config"
+	"github.com/magnickolas/gitok/constants"
+	"github.com/magnickolas/gitok/wildmatch"
+)
+
+const GitAttributes = ".gitattributes"
+
+type State int
+
+const (
+	Unspecified State = iota
+	// "attr"
+	Set
+	// "-attr"
+	Unset
+	// "attr=value"
+	Valued
+)
+
+type Value struct {
+	State State
+	Value string
+}
+
+func (v Value) IsSet() bool {
+	return v.State == Set
+}
+
+func (v Value) IsUnset() bool {
+	return v.State == Unset
+}
+
+type assignment struct {
+	name  string
+	value Value
+}
+
+type rule struct {
+	pattern string
+	// directory of the .gitattributes the rule comes from, "" for the root
+	base     string
+	basename bool
+	attrs    []assignment
+}
+
+// Built-in macro attributes
+var builtinMacros = map[string][]assignment{
+	"binary": {
+		{name: "diff", value: Value{State: Unset}},
+		{name: "merge", value: Value{State: Unset}},
+		{name: "text", value: Value{State: Unset}},
+	},
+}
+
+// Resolves gitattributes for worktree paths. Rules of deeper .gitattributes
+// files override shallower ones; info/attributes overrides them all and
+// core.attributesFile is consulted last
+type Matcher struct {
+	global []rule
+	info   []rule
+	perDir map[string][]rule
+	macros map[string][]assignment
+}
+
+func NewMatcher(cfg *config.Config) (*Matcher, error) {
+	m := &Matcher{
+		perDir: make(map[string][]rule),
+		macros: make(map[string][]assignment),
+	}
+	for name, attrs := range builtinMacros {
+		m.macros[name] = attrs
+	}
+	attributesFile, ok := cfg.Get("core.attributesFile")
+	if !ok {
+		if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+			attributesFile = filepath.Join(xdg, "git", "attributes")
+		} else if home, err := os.UserHomeDir(); err == nil {
+			attributesFile = filepath.Join(home, ".config", "git", "attributes")
+		}
+	} else if rest, found := strings.CutPrefix(attributesFile, "~/"); found {
+		if home, err := os.UserHomeDir(); err == nil {
+			attributesFile = filepath.Join(home, rest)
+		}
+	}
+	var err error
+	if attributesFile != "" {
+		if m.global, err = m.readRules(attributesFile, "", true); err != nil {
+			return nil, err
+		}
+	}
+	m.info, err = m.readRules(filepath.Join(constants.Git, "info", "attributes"), "", true)
+	if err != nil {
+		return nil, err
+	}
+	// macros may only be defined at the top level
+	if _, err := m.loadDir(""); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Value of a single attribute for the slash-separated path
+func (m *Matcher) Get(p string, name string) (Value, error) {
+	attrs, err := m.GetAll(p)
+	if err != nil {
+		return Value{}, err
+	}
+	return attrs[name], nil
+}
+
+// All attributes that are specified for the path
+func (m *Matcher) GetAll(p string) (map[string]Value, error) {
+	var dirs []string
+	dir := path.Dir(p)
+	for dir != "." && dir != "/" {
+		dirs = append(dirs, dir)
+		dir = path.Dir(dir)
+	}
+	dirs = append(dirs, "")
+	res := make(map[string]Value)
+	m.apply(res, m.global, p)
+	for i := len(dirs) - 1; i >= 0; i -= 1 {
+		rules, err := m.loadDir(dirs[i])
+		if err != nil {
+			return nil, err
+		}
+		m.apply(res, rules, p)
+	}
+	m.apply(res, m.info, p)
+	for name, value := range res {
+		if value.State == Unspecified {
+			delete(res, name)
+		}
+	}
+	return res, nil
+}
+
+func (m *Matcher) apply(res map[string]Value, rules []rule, p string) {
+	for _, r := range rules {
+		if !r.matches(p) {
+			continue
+		}
+		for _, a := range r.attrs {
+			m.assign(res, a, 0)
+		}
+	}
+}
+
+func (m *Matcher) assign(res map[string]Value, a assignment, depth int) {
+	res[a.name] = a.value
+	if expansion, ok := m.macros[a.name]; ok && a.value.State == Set && depth < 8 {
+		for _, sub := range expansion {
+			m.assign(res, sub, depth+1)
+		}
+	}
+}
+
+func (r *rule) matches(p string) bool {
+	if r.basename {
+		return wildmatch.Match(r.pattern, path.Base(p), wildmatch.Pathname)
+	}
+	rel := p
+	if r.base != "" {
+		var found bool
+		rel, found = strings.CutPrefix(p, r.base+"/")
+		if !found {
+			return false
+		}
+	}
+	return wildmatch.Match(r.pattern, rel, wildmatch.Pathname)
+}
+
+func (m *Matcher) loadDir(dir string) ([]rule, error) {
+	if rules, ok := m.perDir[dir]; ok {
+		return rules, nil
+	}
+	file := GitAttributes
+	if dir != "" {
+		file = filepath.Join(filepath.FromSlash(dir), GitAttributes)
+	}
+	rules, err := m.readRules(file, dir, dir == "")
+	if err != nil {
+		return nil, err
+	}
+	m.perDir[dir] = rules
+	return rules, nil
+}
+
+func (m *Matcher) readRules(file string, base string, allowMacros bool) ([]rule, error) {
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rules []rule
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		attrs := parseAssignments(fields[1:])
+		if name, found := strings.CutPrefix(fields[0], "[attr]"); found {
+			if allowMacros {
+				m.macros[name] = attrs
+			}
+			continue
+		}
+		pattern := fields[0]
+		// directory patterns are not supported by gitattributes
+		if strings.HasSuffix(pattern, "/") {
+			continue
+		}
+		rules = append(rules, rule{
+			pattern:  strings.TrimPrefix(pattern, "/"),
+			base:     base,
+			basename: !strings.Contains(pattern, "/"),
+			attrs:    attrs,
+		})
+	}
+	return rules, sc.Err()
+}
+
+func parseAssignments(fields []string) []assignment {
+	var res []assignment
+	for _, field := range fields {
+		switch {
+		case strings.HasPrefix(field, "-"):
+			res = append(res, assignment{name: field[1:], value: Value{State: Unset}})
+		case strings.HasPrefix(field, "!"):
+			res = append(res, assignment{name: field[1:], value: Value{State: Unspecified}})
+		default:
+			name, value, found := strings.Cut(field, "=")
+			if found {
+				res = append(res, assignment{name: name, value: Value{State: Valued, Value: value}})
+			} else {
+				res = append(res, assignment{name: name, value: Value{State: Set}})
+			}
+		}
+	}
+	return res
+}
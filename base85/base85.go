@@ -0,0 +1,61 @@
+package base85
+
+import (
+	"strings"
+)
+
+// Characters of the encoding git uses for binary patches, by value
+const alphabet = "0123456789" +
+	"ABCDEFGHIJKLMNOPQRSTUVWXYZ" +
+	"abcdefghijklmnopqrstuvwxyz" +
+	"!#$%&()*+-;<=>?@^_`{|}~"
+
+// Encodes the data, five characters for every four bytes; a short last
+// group is padded with zeros
+func Encode(data []byte) string {
+	var b strings.Builder
+	for len(data) > 0 {
+		var acc uint32
+		for i := 0; i < 4; i += 1 {
+			acc <<= 8
+			if i < len(data) {
+				acc |= uint32(data[i])
+			}
+		}
+		data = data[min(len(data), 4):]
+		var group [5]byte
+		for i := 4; i >= 0; i -= 1 {
+			group[i] = alphabet[acc%85]
+			acc /= 85
+		}
+		b.Write(group[:])
+	}
+	return b.String()
+}
+
+// Decodes n bytes of data, failing on characters outside the alphabet or
+// groups out of range
+func Decode(s string, n int) ([]byte, bool) {
+	res := make([]byte, 0, n)
+	for n > 0 {
+		var acc uint64
+		for i := 0; i < 5; i += 1 {
+			if s == "" {
+				return nil, false
+			}
+			c := strings.IndexByte(alphabet, s[0])
+			if c == -1 {
+				return nil, false
+			}
+			acc = acc*85 + uint64(c)
+			s = s[1:]
+		}
+		if acc > 0xffffffff {
+			return nil, false
+		}
+		for i := 3; i >= 0 && n > 0; i, n = i-1, n-1 {
+			res = append(res, byte(acc>>(8*i)))
+		}
+	}
+	return res, true
+}
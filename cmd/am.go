@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+
+	"github.com/magnickolas/gitok/gitok_am"
+	"github.com/spf13/cobra"
+)
+
+var (
+	amCmd = &cobra.Command{
+		Use:   "am [<mbox>...]",
+		Short: "Apply a series of patches from a mailbox",
+		Run: func(cmd *cobra.Command, args []string) {
+			var ok bool
+			var err error
+			switch {
+			case amContinue:
+				ok, err = gitok_am.Continue(os.Stdout, os.Stderr)
+			case amSkip:
+				ok, err = gitok_am.Skip(os.Stdout, os.Stderr)
+			case amAbort:
+				ok, err = true, gitok_am.Abort(os.Stdout, os.Stderr)
+			case amQuit:
+				ok, err = true, gitok_am.Quit()
+			case amShowCurrentPatch != "":
+				ok, err = true, gitok_am.ShowCurrentPatch(os.Stdout, amShowCurrentPatch)
+			default:
+				// patches piped in are a mailbox as much as files given
+				if info, statErr := os.Stdin.Stat(); len(args) == 0 && statErr == nil && info.Mode()&os.ModeCharDevice == 0 {
+					args = []string{"-"}
+				}
+				ok, err = gitok_am.Am(os.Stdin, os.Stdout, os.Stderr, args, amOpts)
+			}
+			if errors.Is(err, gitok_am.ErrorCannotOpenMbox) {
+				fatalf("error: %v\n", err)
+			} else if err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			if !ok {
+				os.Exit(1)
+			}
+		},
+	}
+	amOpts             gitok_am.Options
+	amContinue         bool
+	amSkip             bool
+	amAbort            bool
+	amQuit             bool
+	amShowCurrentPatch string
+)
+
+func init() {
+	amCmd.Flags().
+		BoolVarP(&amOpts.ThreeWay, "3way", "3", false, "allow fall back on 3way merging if needed")
+	amCmd.Flags().
+		BoolVarP(&amOpts.Quiet, "quiet", "q", false, "be quiet")
+	amCmd.Flags().
+		BoolVarP(&amOpts.KeepSubject, "keep", "k", false, "pass -k flag to mailinfo")
+	amCmd.Flags().
+		BoolVar(&amContinue, "continue", false, "continue applying patches after resolving a conflict")
+	amCmd.Flags().
+		BoolVarP(&amContinue, "resolved", "r", false, "synonyms for --continue")
+	amCmd.Flags().
+		BoolVar(&amSkip, "skip", false, "skip the current patch")
+	amCmd.Flags().
+		BoolVar(&amAbort, "abort", false, "restore the original branch and abort the patching operation")
+	amCmd.Flags().
+		BoolVar(&amQuit, "quit", false, "abort the patching operation but keep HEAD where it is")
+	amCmd.Flags().
+		StringVar(&amShowCurrentPatch, "show-current-patch", "", "show the patch being applied: raw or diff")
+	amCmd.Flags().Lookup("show-current-patch").NoOptDefVal = "raw"
+	amCmd.MarkFlagsMutuallyExclusive("continue", "resolved", "skip", "abort", "quit", "show-current-patch")
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/magnickolas/gitok/apply"
+	"github.com/magnickolas/gitok/gitok_apply"
+	"github.com/spf13/cobra"
+)
+
+var (
+	applyCmd = &cobra.Command{
+		Use:   "apply [<patch>...]",
+		Short: "Apply a patch to files and/or to the index",
+		Run: func(cmd *cobra.Command, args []string) {
+			applyOpts.Apply.Strip = -1
+			if cmd.Flags().Changed("strip") {
+				applyOpts.Apply.Strip = applyStrip
+			}
+			applyOpts.Apply.Context = -1
+			if cmd.Flags().Changed("context") {
+				applyOpts.Apply.Context = applyContext
+			}
+			if applyIgnoreWhitespace {
+				applyOpts.Apply.IgnoreSpaceChange = true
+			}
+			switch {
+			case applyQuiet:
+				applyOpts.Apply.Verbosity = apply.VerbosityQuiet
+			case applyVerbose:
+				applyOpts.Apply.Verbosity = apply.VerbosityVerbose
+			}
+			ok, err := gitok_apply.Apply(os.Stdin, os.Stderr, args, applyOpts)
+			if errors.Is(err, apply.ErrorWhitespace) || errors.Is(err, gitok_apply.ErrorCannotOpenPatch) {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				// the status git dies with, told apart from patches that
+				// failed to apply
+				os.Exit(128)
+			} else if err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			if !ok {
+				os.Exit(1)
+			}
+		},
+	}
+	applyOpts             gitok_apply.Options
+	applyStrip            int
+	applyContext          int
+	applyIgnoreWhitespace bool
+	applyVerbose          bool
+	applyQuiet            bool
+)
+
+func init() {
+	applyCmd.Flags().
+		BoolVar(&applyOpts.Apply.Cached, "cached", false, "apply the patch to the index without touching the working tree")
+	applyCmd.Flags().
+		BoolVar(&applyOpts.Apply.Index, "index", false, "apply the patch to both the index and the working tree")
+	applyCmd.Flags().
+		BoolVar(&applyOpts.Apply.Check, "check", false, "see if the patch is applicable without applying it")
+	applyCmd.Flags().
+		BoolVarP(&applyOpts.Apply.ThreeWay, "3way", "3", false, "attempt a three-way merge, falling back to direct application")
+	applyCmd.Flags().
+		BoolVarP(&applyOpts.Apply.Reverse, "reverse", "R", false, "apply the patch in reverse")
+	applyCmd.Flags().
+		BoolVar(&applyOpts.Apply.Reject, "reject", false, "apply the hunks that do and leave the rejected ones in .rej files")
+	applyCmd.Flags().
+		IntVarP(&applyStrip, "strip", "p", 1, "remove <num> leading slashes from traditional diff paths")
+	applyCmd.Flags().
+		IntVarP(&applyContext, "context", "C", 0, "ensure at least <n> lines of context match")
+	applyCmd.Flags().
+		StringVar(&applyOpts.Whitespace, "whitespace", "", "detect new or modified lines that have whitespace errors: nowarn, warn, fix, error or error-all")
+	applyCmd.Flags().
+		BoolVar(&applyOpts.Apply.IgnoreSpaceChange, "ignore-space-change", false, "ignore changes in whitespace when finding context")
+	applyCmd.Flags().
+		BoolVar(&applyIgnoreWhitespace, "ignore-whitespace", false, "ignore changes in whitespace when finding context")
+	applyCmd.Flags().
+		BoolVarP(&applyVerbose, "verbose", "v", false, "be verbose")
+	applyCmd.Flags().
+		BoolVarP(&applyQuiet, "quiet", "q", false, "be quiet")
+	applyCmd.Flags().
+		BoolVar(&applyOpts.Apply.AllowEmpty, "allow-empty", false, "don't return error for empty patches")
+	applyCmd.Flags().
+		BoolVar(&applyOpts.Apply.UnidiffZero, "unidiff-zero", false, "don't expect at least one line of context")
+}
@@ -2,8 +2,10 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/magnickolas/gitok/gitok_cat"
+	"github.com/magnickolas/gitok/revparse"
 	"github.com/spf13/cobra"
 )
 
@@ -11,31 +13,105 @@ var (
 	catFileCmd = &cobra.Command{
 		Use:   "cat-file",
 		Short: "Print object info",
-		Args:  cobra.ExactArgs(1),
+		Args: func(cmd *cobra.Command, args []string) error {
+			if isBatchRequested(cmd) {
+				return cobra.NoArgs(cmd, args)
+			}
+			return cobra.ExactArgs(1)(cmd, args)
+		},
 		Run: func(cmd *cobra.Command, args []string) {
-			var str string
-			var err error
-			if askType {
-				str, err = gitok_cat.GetObjectType(args[0])
-			} else if prettyPrint {
-				str, err = gitok_cat.PrettyCatObject(args[0])
-			} else {
-				str, err = gitok_cat.CatObject(args[0])
+			if isBatchRequested(cmd) {
+				opts := gitok_cat.BatchOptions{Buffer: batchBuffer, AllObjects: batchAllObjects}
+				switch {
+				case cmd.Flags().Changed("batch"):
+					opts.Mode, opts.Format = gitok_cat.BatchContents, batchFormat
+				case cmd.Flags().Changed("batch-command"):
+					opts.Mode, opts.Format = gitok_cat.BatchCommand, batchCommandFormat
+				default:
+					opts.Mode, opts.Format = gitok_cat.BatchCheck, batchCheckFormat
+				}
+				if err := gitok_cat.Batch(os.Stdin, os.Stdout, opts); err != nil {
+					fatalf("fatal: %v\n", err)
+				}
+				return
 			}
+			digest, err := revparse.Resolve(args[0])
 			if err != nil {
-				panic(err)
+				if askExists {
+					os.Exit(1)
+				}
+				fatalf("fatal: Not a valid object name %v\n", args[0])
+			}
+			switch {
+			case askExists:
+				if !gitok_cat.ObjectExists(digest) {
+					os.Exit(1)
+				}
+			case askType:
+				objType, err := gitok_cat.GetObjectType(digest)
+				if err != nil {
+					fatalf("fatal: git cat-file: could not get object info\n")
+				}
+				fmt.Println(objType)
+			case askSize:
+				size, err := gitok_cat.GetObjectSize(digest)
+				if err != nil {
+					fatalf("fatal: git cat-file: could not get object info\n")
+				}
+				fmt.Println(size)
+			case prettyPrint:
+				str, err := gitok_cat.PrettyCatObject(digest)
+				if err != nil {
+					fatalf("fatal: %v\n", err)
+				}
+				fmt.Print(str)
+			default:
+				if err := gitok_cat.CatObject(os.Stdout, digest); err != nil {
+					fatalf("fatal: %v\n", err)
+				}
 			}
-			fmt.Print(str)
 		},
 	}
-	prettyPrint bool
-	askType     bool
+	prettyPrint        bool
+	askType            bool
+	askSize            bool
+	askExists          bool
+	batchFormat        string
+	batchCheckFormat   string
+	batchCommandFormat string
+	batchBuffer        bool
+	batchAllObjects    bool
 )
 
+func isBatchRequested(cmd *cobra.Command) bool {
+	return cmd.Flags().Changed("batch") ||
+		cmd.Flags().Changed("batch-check") ||
+		cmd.Flags().Changed("batch-command") ||
+		batchAllObjects
+}
+
 func init() {
 	catFileCmd.Flags().
 		BoolVarP(&prettyPrint, "pretty-print", "p", false, "Pretty-print the contents based on the type of the object")
 	catFileCmd.Flags().
 		BoolVarP(&askType, "type", "t", false, "Print the type of the object")
-	catFileCmd.MarkFlagsMutuallyExclusive("pretty-print", "type")
+	catFileCmd.Flags().
+		BoolVarP(&askSize, "size", "s", false, "Print the size of the object")
+	catFileCmd.Flags().
+		BoolVarP(&askExists, "exists", "e", false, "Exit with zero status if the object exists and is valid")
+	catFileCmd.Flags().
+		StringVar(&batchFormat, "batch", "", "Print info and contents of objects named on stdin")
+	catFileCmd.Flags().Lookup("batch").NoOptDefVal = gitok_cat.DefaultBatchFormat
+	catFileCmd.Flags().
+		StringVar(&batchCheckFormat, "batch-check", "", "Print info of objects named on stdin")
+	catFileCmd.Flags().Lookup("batch-check").NoOptDefVal = gitok_cat.DefaultBatchFormat
+	catFileCmd.Flags().
+		StringVar(&batchCommandFormat, "batch-command", "", "Read info/contents/flush commands from stdin")
+	catFileCmd.Flags().Lookup("batch-command").NoOptDefVal = gitok_cat.DefaultBatchFormat
+	catFileCmd.Flags().
+		BoolVar(&batchBuffer, "buffer", false, "Buffer the batch output until it is flushed")
+	catFileCmd.Flags().
+		BoolVar(&batchAllObjects, "batch-all-objects", false, "Show info for all objects in the repository")
+	catFileCmd.MarkFlagsMutuallyExclusive("pretty-print", "type", "size", "exists")
+	catFileCmd.MarkFlagsMutuallyExclusive("batch", "batch-check", "batch-command")
 }
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/magnickolas/gitok/gitok_commit"
+	"github.com/spf13/cobra"
+)
+
+var (
+	commitCmd = &cobra.Command{
+		Use:   "commit",
+		Short: "Record changes to the repository",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			res, err := gitok_commit.Commit(gitok_commit.JoinParagraphs(commitMessages), allowEmpty)
+			if err != nil {
+				fatalf("%v\n", err)
+			}
+			fmt.Println(res)
+		},
+	}
+	commitMessages []string
+	allowEmpty     bool
+)
+
+func init() {
+	commitCmd.Flags().
+		StringArrayVarP(&commitMessages, "message", "m", nil, "commit message paragraph")
+	commitCmd.Flags().
+		BoolVar(&allowEmpty, "allow-empty", false, "allow recording a commit with the same tree as its parent")
+	_ = commitCmd.MarkFlagRequired("message")
+}
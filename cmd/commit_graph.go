@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"github.com/magnickolas/gitok/commitgraph"
+	"github.com/magnickolas/gitok/gitok_commit_graph"
+	"github.com/spf13/cobra"
+)
+
+var (
+	commitGraphCmd = &cobra.Command{
+		Use:   "commit-graph",
+		Short: "Write the commit-graph file speeding up revision walks",
+	}
+	commitGraphWriteCmd = &cobra.Command{
+		Use:   "write",
+		Short: "Write a commit-graph file of the commits in the repository",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := gitok_commit_graph.Write(commitGraphWriteOpts); err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+		},
+	}
+	commitGraphWriteOpts gitok_commit_graph.WriteOptions
+)
+
+func init() {
+	commitGraphWriteCmd.Flags().
+		BoolVar(&commitGraphWriteOpts.Reachable, "reachable", false, "walk the commits starting at all refs")
+	commitGraphWriteCmd.Flags().
+		BoolVar(&commitGraphWriteOpts.Graph.Split, "split", false, "write the commits not yet in the graph as a new layer of a split graph")
+	commitGraphWriteCmd.Flags().
+		IntVar(&commitGraphWriteOpts.Graph.SizeMultiple, "size-multiple", commitgraph.DefaultSizeMultiple, "merge layers of at most this many times the commits of the new one")
+	commitGraphCmd.AddCommand(commitGraphWriteCmd)
+}
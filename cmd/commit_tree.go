@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/magnickolas/gitok/gitok_commit"
+	"github.com/spf13/cobra"
+)
+
+var (
+	commitTreeCmd = &cobra.Command{
+		Use:   "commit-tree <tree>",
+		Short: "Create a new commit object",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			var message string
+			if len(commitTreeMessages) > 0 {
+				message = gitok_commit.JoinParagraphs(commitTreeMessages)
+			} else {
+				buf := new(bytes.Buffer)
+				if _, err := buf.ReadFrom(os.Stdin); err != nil {
+					fatalf("cannot read commit message: %v\n", err)
+				}
+				message = buf.String()
+			}
+			digest, err := gitok_commit.CommitTree(args[0], commitTreeParents, message)
+			if err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			fmt.Println(digest)
+		},
+	}
+	commitTreeParents  []string
+	commitTreeMessages []string
+)
+
+func init() {
+	commitTreeCmd.Flags().
+		StringArrayVarP(&commitTreeParents, "parent", "p", nil, "id of a parent commit object")
+	commitTreeCmd.Flags().
+		StringArrayVarP(&commitTreeMessages, "message", "m", nil, "commit message paragraph")
+}
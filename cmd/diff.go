@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"bufio"
+	"os"
+
+	"github.com/magnickolas/gitok/gitok_diff"
+	"github.com/spf13/cobra"
+)
+
+var (
+	diffCmd = &cobra.Command{
+		Use:   "diff [--cached] [<commit> [<commit>]] [[--] <path>...]",
+		Short: "Show changes between commits, commit and working tree, etc",
+		Run: func(cmd *cobra.Command, args []string) {
+			opts := diffOpts
+			revs, paths := splitRevisionsAndPaths(args, cmd.ArgsLenAtDash())
+			if err := diffLines.apply(cmd, &opts.Diff); err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			if err := diffFormat.apply(cmd, &opts.Diff); err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			if err := diffRenames.apply(cmd, &opts.Diff, true); err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			if err := diffColors.apply(cmd, &opts.Diff, true); err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			w := bufio.NewWriter(os.Stdout)
+			err := gitok_diff.Diff(w, os.Stderr, revs, paths, opts)
+			if flushErr := w.Flush(); err == nil {
+				err = flushErr
+			}
+			if err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+		},
+	}
+	diffOpts    gitok_diff.Options
+	diffLines   diffLineFlags
+	diffFormat  diffFormatFlags
+	diffRenames diffRenameFlags
+	diffColors  diffColorFlags
+)
+
+func init() {
+	diffCmd.Flags().
+		BoolVar(&diffOpts.Cached, "cached", false, "compare the index rather than the working tree")
+	diffCmd.Flags().
+		BoolVar(&diffOpts.Cached, "staged", false, "synonym for --cached")
+	diffFormat.register(diffCmd, &diffOpts.Diff)
+	diffLines.register(diffCmd, &diffOpts.Diff)
+	diffRenames.register(diffCmd, &diffOpts.Diff)
+	diffColors.register(diffCmd)
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"bufio"
+	"os"
+
+	"github.com/magnickolas/gitok/diff"
+	"github.com/magnickolas/gitok/gitok_diff"
+	"github.com/spf13/cobra"
+)
+
+var (
+	diffFilesCmd = &cobra.Command{
+		Use:   "diff-files [<path>...]",
+		Short: "Compares files in the working tree and the index",
+		Run: func(cmd *cobra.Command, args []string) {
+			opts := diffFilesOpts
+			if err := diffFilesLines.apply(cmd, &opts); err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			if err := diffFilesFormat.apply(cmd, &opts); err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			if err := diffFilesRenames.apply(cmd, &opts, false); err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			if err := diffFilesColors.apply(cmd, &opts, false); err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			w := bufio.NewWriter(os.Stdout)
+			err := gitok_diff.DiffFiles(w, os.Stderr, args, opts)
+			if flushErr := w.Flush(); err == nil {
+				err = flushErr
+			}
+			if err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+		},
+	}
+	diffFilesOpts    diff.Options
+	diffFilesLines   diffLineFlags
+	diffFilesFormat  diffFormatFlags
+	diffFilesRenames diffRenameFlags
+	diffFilesColors  diffColorFlags
+)
+
+func init() {
+	diffFilesFormat.register(diffFilesCmd, &diffFilesOpts)
+	diffFilesLines.register(diffFilesCmd, &diffFilesOpts)
+	diffFilesRenames.register(diffFilesCmd, &diffFilesOpts)
+	diffFilesColors.register(diffFilesCmd)
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"bufio"
+	"os"
+
+	"github.com/magnickolas/gitok/gitok_diff"
+	"github.com/spf13/cobra"
+)
+
+var (
+	diffIndexCmd = &cobra.Command{
+		Use:   "diff-index [--cached] <tree-ish> [<path>...]",
+		Short: "Compare a tree to the working tree or index",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			opts := diffIndexOpts
+			if err := diffIndexLines.apply(cmd, &opts.Diff); err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			if err := diffIndexFormat.apply(cmd, &opts.Diff); err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			if err := diffIndexRenames.apply(cmd, &opts.Diff, false); err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			if err := diffIndexColors.apply(cmd, &opts.Diff, false); err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			w := bufio.NewWriter(os.Stdout)
+			err := gitok_diff.DiffIndex(w, os.Stderr, args[0], args[1:], opts)
+			if flushErr := w.Flush(); err == nil {
+				err = flushErr
+			}
+			if err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+		},
+	}
+	diffIndexOpts    gitok_diff.Options
+	diffIndexLines   diffLineFlags
+	diffIndexFormat  diffFormatFlags
+	diffIndexRenames diffRenameFlags
+	diffIndexColors  diffColorFlags
+)
+
+func init() {
+	diffIndexCmd.Flags().
+		BoolVar(&diffIndexOpts.Cached, "cached", false, "compare the tree with the index only")
+	diffIndexFormat.register(diffIndexCmd, &diffIndexOpts.Diff)
+	diffIndexLines.register(diffIndexCmd, &diffIndexOpts.Diff)
+	diffIndexRenames.register(diffIndexCmd, &diffIndexOpts.Diff)
+	diffIndexColors.register(diffIndexCmd)
+}
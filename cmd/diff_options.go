@@ -0,0 +1,465 @@
+package cmd
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/magnickolas/gitok/color"
+	"github.com/magnickolas/gitok/config"
+	"github.com/magnickolas/gitok/diff"
+	"github.com/spf13/cobra"
+)
+
+// Flags choosing how the lines of files are diffed, shared by the commands
+// showing diffs
+type diffLineFlags struct {
+	algorithm         string
+	minimal           bool
+	patience          bool
+	histogram         bool
+	indentHeuristic   bool
+	noIndentHeuristic bool
+}
+
+func (f *diffLineFlags) register(cmd *cobra.Command, opts *diff.Options) {
+	cmd.Flags().
+		StringVar(&f.algorithm, "diff-algorithm", "", "choose a diff algorithm (myers, minimal, patience or histogram)")
+	cmd.Flags().
+		BoolVar(&f.minimal, "minimal", false, "spend extra time to make sure the smallest possible diff is produced")
+	cmd.Flags().
+		BoolVar(&f.patience, "patience", false, "generate a diff using the patience diff algorithm")
+	cmd.Flags().
+		BoolVar(&f.histogram, "histogram", false, "generate a diff using the histogram diff algorithm")
+	cmd.Flags().
+		BoolVar(&f.indentHeuristic, "indent-heuristic", true, "shift the boundaries of changes to make patches easier to read")
+	cmd.Flags().
+		BoolVar(&f.noIndentHeuristic, "no-indent-heuristic", false, "disable the indent heuristic")
+	cmd.Flags().
+		BoolVarP(&opts.IgnoreAllSpace, "ignore-all-space", "w", false, "ignore whitespace when comparing lines")
+	cmd.Flags().
+		BoolVarP(&opts.IgnoreSpaceChange, "ignore-space-change", "b", false, "ignore changes in amount of whitespace")
+	cmd.Flags().
+		BoolVar(&opts.IgnoreBlankLines, "ignore-blank-lines", false, "ignore changes whose lines are all blank")
+	cmd.MarkFlagsMutuallyExclusive("diff-algorithm", "minimal", "patience", "histogram")
+	cmd.MarkFlagsMutuallyExclusive("indent-heuristic", "no-indent-heuristic")
+}
+
+// Sets the algorithm and the indent heuristic from the flags, or from
+// diff.algorithm and diff.indentHeuristic when not given
+func (f *diffLineFlags) apply(cmd *cobra.Command, opts *diff.Options) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	if opts.IndentHeuristic, err = cfg.GetBool("diff.indentHeuristic", true); err != nil {
+		return err
+	}
+	if cmd.Flags().Changed("indent-heuristic") {
+		opts.IndentHeuristic = f.indentHeuristic
+	}
+	if f.noIndentHeuristic {
+		opts.IndentHeuristic = false
+	}
+	algorithm, _ := cfg.Get("diff.algorithm")
+	switch {
+	case f.minimal:
+		algorithm = "minimal"
+	case f.patience:
+		algorithm = "patience"
+	case f.histogram:
+		algorithm = "histogram"
+	case f.algorithm != "":
+		algorithm = f.algorithm
+	}
+	if algorithm != "" {
+		if opts.Algorithm, err = diff.ParseAlgorithm(algorithm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flags choosing what the diff commands show of the changes
+type diffFormatFlags struct {
+	patch      bool
+	raw        bool
+	nameOnly   bool
+	nameStatus bool
+	statWidth  int
+	numStat    bool
+	shortStat  bool
+	dirStat    string
+	dirStatBy  string
+	cumulative bool
+	summary    bool
+	binary     bool
+	fullIndex  bool
+}
+
+func (f *diffFormatFlags) register(cmd *cobra.Command, opts *diff.Options) {
+	cmd.Flags().
+		BoolVarP(&f.patch, "patch", "p", false, "generate a patch")
+	cmd.Flags().
+		BoolVar(&f.raw, "raw", false, "show the modes, digests and status of the changed files")
+	cmd.Flags().
+		BoolVar(&f.nameOnly, "name-only", false, "show only the names of the changed files")
+	cmd.Flags().
+		BoolVar(&f.nameStatus, "name-status", false, "show only the names and status of the changed files")
+	cmd.Flags().
+		IntVar(&f.statWidth, "stat", 0, "generate a diffstat, optionally in the given width")
+	cmd.Flags().Lookup("stat").NoOptDefVal = "0"
+	cmd.Flags().
+		BoolVar(&f.numStat, "numstat", false, "show the numbers of added and deleted lines of each file")
+	cmd.Flags().
+		BoolVar(&f.shortStat, "shortstat", false, "show only the last line of the diffstat")
+	// an empty parameter keeps those of diff.dirstat
+	cmd.Flags().
+		StringVar(&f.dirStat, "dirstat", "", "show the share of the changes of each directory, optionally with the given parameters")
+	cmd.Flags().Lookup("dirstat").NoOptDefVal = ","
+	cmd.Flags().
+		StringVar(&f.dirStatBy, "dirstat-by-file", "", "show the share of the changed files of each directory")
+	cmd.Flags().Lookup("dirstat-by-file").NoOptDefVal = ","
+	cmd.Flags().
+		BoolVar(&f.cumulative, "cumulative", false, "count the changes of subdirectories for their parents too in the dirstat")
+	cmd.Flags().
+		BoolVar(&f.summary, "summary", false, "show the created, deleted, renamed and copied files and the mode changes")
+	cmd.Flags().
+		BoolVar(&f.binary, "binary", false, "output a binary diff that can be applied")
+	cmd.Flags().
+		BoolVar(&f.fullIndex, "full-index", false, "show the full object names on the index lines of patches")
+	cmd.Flags().
+		IntVarP(&opts.Context, "unified", "U", diff.DefaultContext, "generate patches with the given lines of context")
+	cmd.MarkFlagsMutuallyExclusive("name-only", "name-status")
+}
+
+// Sets the formats from the flags, leaving none if no flag is given; the
+// diffstat takes the width of the terminal unless given one and the
+// dirstat the parameters of diff.dirstat before its own
+func (f *diffFormatFlags) apply(cmd *cobra.Command, opts *diff.Options) error {
+	opts.Format = 0
+	if f.patch || f.binary || cmd.Flags().Changed("unified") {
+		opts.Format |= diff.FormatPatch
+	}
+	opts.Binary, opts.FullIndex = f.binary, f.fullIndex
+	if f.raw {
+		opts.Format |= diff.FormatRaw
+	}
+	if f.nameOnly {
+		opts.Format |= diff.FormatNameOnly
+	}
+	if f.nameStatus {
+		opts.Format |= diff.FormatNameStatus
+	}
+	if cmd.Flags().Changed("stat") {
+		opts.Format |= diff.FormatStat
+		opts.StatWidth = f.statWidth
+		if f.statWidth <= 0 {
+			if width, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil {
+				opts.StatWidth = width
+			}
+		}
+	}
+	if f.numStat {
+		opts.Format |= diff.FormatNumStat
+	}
+	if f.shortStat {
+		opts.Format |= diff.FormatShortStat
+	}
+	if f.summary {
+		opts.Format |= diff.FormatSummary
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	params, _ := cfg.Get("diff.dirstat")
+	if opts.DirStat, err = diff.ParseDirStat(params, diff.DefaultDirStat); err != nil {
+		return err
+	}
+	if cmd.Flags().Changed("dirstat") {
+		opts.Format |= diff.FormatDirStat
+		if opts.DirStat, err = diff.ParseDirStat(f.dirStat, opts.DirStat); err != nil {
+			return err
+		}
+	}
+	if cmd.Flags().Changed("dirstat-by-file") {
+		opts.Format |= diff.FormatDirStat
+		opts.DirStat.By = diff.DirStatFiles
+		if opts.DirStat, err = diff.ParseDirStat(f.dirStatBy, opts.DirStat); err != nil {
+			return err
+		}
+	}
+	if f.cumulative {
+		opts.Format |= diff.FormatDirStat
+		opts.DirStat.Cumulative = true
+	}
+	return nil
+}
+
+// Flags choosing the colors of patches and how changes are highlighted
+type diffColorFlags struct {
+	color          string
+	noColor        bool
+	wordDiff       string
+	wordDiffRegex  string
+	colorWords     string
+	colorMoved     string
+	noColorMoved   bool
+	colorMovedWS   string
+	noColorMovedWS bool
+}
+
+// Argument of --color-words given without a regex, which pflag needs to
+// be something no regex is written as
+const noWordRegex = "\x00"
+
+func (f *diffColorFlags) register(cmd *cobra.Command) {
+	cmd.Flags().
+		StringVar(&f.color, "color", "", "show colored diffs: always, never or auto")
+	cmd.Flags().Lookup("color").NoOptDefVal = "always"
+	cmd.Flags().
+		BoolVar(&f.noColor, "no-color", false, "turn off colored diffs")
+	cmd.Flags().
+		StringVar(&f.wordDiff, "word-diff", "", "show changed words: plain, color, porcelain or none")
+	cmd.Flags().Lookup("word-diff").NoOptDefVal = "plain"
+	cmd.Flags().
+		StringVar(&f.wordDiffRegex, "word-diff-regex", "", "use the regex to decide what a word is")
+	cmd.Flags().
+		StringVar(&f.colorWords, "color-words", "", "equivalent to --word-diff=color plus --word-diff-regex if given")
+	cmd.Flags().Lookup("color-words").NoOptDefVal = noWordRegex
+	cmd.Flags().
+		StringVar(&f.colorMoved, "color-moved", "", "color moved lines differently: no, default, plain, blocks, zebra or dimmed-zebra")
+	cmd.Flags().Lookup("color-moved").NoOptDefVal = "default"
+	cmd.Flags().
+		BoolVar(&f.noColorMoved, "no-color-moved", false, "turn off moved line detection")
+	cmd.Flags().
+		StringVar(&f.colorMovedWS, "color-moved-ws", "", "whitespace ignored when detecting moved lines")
+	cmd.Flags().
+		BoolVar(&f.noColorMovedWS, "no-color-moved-ws", false, "do not ignore whitespace when detecting moved lines")
+	cmd.MarkFlagsMutuallyExclusive("color", "no-color")
+}
+
+// Sets the colors and word diff from the flags and the config: the
+// palette from color.diff.<slot>, and for porcelain commands whether to
+// color at all from color.diff or color.ui and moved lines from
+// diff.colorMoved and diff.colorMovedWS
+func (f *diffColorFlags) apply(cmd *cobra.Command, opts *diff.Options, porcelain bool) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	when := color.WhenNever
+	if porcelain {
+		key := "color.diff"
+		if _, ok := cfg.Get(key); !ok {
+			key = "color.ui"
+		}
+		if when, err = colorSetting(cfg, key); err != nil {
+			return err
+		}
+		if value, ok := cfg.Get("diff.colorMoved"); ok {
+			if opts.ColorMoved, err = diff.ParseColorMoved(value); err != nil {
+				return err
+			}
+		}
+		if value, ok := cfg.Get("diff.colorMovedWS"); ok {
+			if opts.ColorMovedWS, err = diff.ParseColorMovedWS(value); err != nil {
+				return err
+			}
+		}
+	}
+	if cmd.Flags().Changed("color") {
+		if when, err = color.ParseWhen(f.color); err != nil {
+			return err
+		}
+	}
+	if f.noColor {
+		when = color.WhenNever
+	}
+	wordRegex, _ := cfg.Get("diff.wordRegex")
+	opts.WordDiff = diff.WordDiffNone
+	if cmd.Flags().Changed("word-diff") {
+		if opts.WordDiff, err = diff.ParseWordDiff(f.wordDiff); err != nil {
+			return err
+		}
+	}
+	if cmd.Flags().Changed("word-diff-regex") {
+		wordRegex = f.wordDiffRegex
+		if opts.WordDiff == diff.WordDiffNone {
+			opts.WordDiff = diff.WordDiffPlain
+		}
+	}
+	if cmd.Flags().Changed("color-words") {
+		opts.WordDiff = diff.WordDiffColor
+		if f.colorWords != noWordRegex {
+			wordRegex = f.colorWords
+		}
+	}
+	if opts.WordDiff == diff.WordDiffColor {
+		when = color.WhenAlways
+	}
+	opts.WordRegex = nil
+	if wordRegex != "" && opts.WordDiff != diff.WordDiffNone {
+		if opts.WordRegex, err = regexp.CompilePOSIX(wordRegex); err != nil {
+			return err
+		}
+	}
+	if cmd.Flags().Changed("color-moved") {
+		if opts.ColorMoved, err = diff.ParseColorMoved(f.colorMoved); err != nil {
+			return err
+		}
+	}
+	if f.noColorMoved {
+		opts.ColorMoved = diff.MovedNo
+	}
+	if cmd.Flags().Changed("color-moved-ws") {
+		if opts.ColorMovedWS, err = diff.ParseColorMovedWS(f.colorMovedWS); err != nil {
+			return err
+		}
+	}
+	if f.noColorMovedWS {
+		opts.ColorMovedWS = 0
+	}
+	opts.Colors = nil
+	if when == color.WhenNever || when == color.WhenAuto && !isTerminal(os.Stdout) {
+		return nil
+	}
+	palette := diff.DefaultPalette
+	for _, slot := range diff.PaletteSlots {
+		spec, ok := cfg.Get("color.diff." + slot)
+		if !ok {
+			continue
+		}
+		if *palette.Slot(slot), err = color.Parse(spec); err != nil {
+			return err
+		}
+	}
+	opts.Colors = &palette
+	return nil
+}
+
+// When to color by a color.* setting, auto if not set; booleans are
+// accepted too, true meaning auto
+func colorSetting(cfg *config.Config, key string) (color.When, error) {
+	value, ok := cfg.Get(key)
+	if !ok {
+		return color.WhenAuto, nil
+	}
+	if when, err := color.ParseWhen(value); err == nil {
+		return when, nil
+	}
+	on, err := cfg.GetBool(key, false)
+	if err != nil || !on {
+		return color.WhenNever, err
+	}
+	return color.WhenAuto, nil
+}
+
+// Whether the file is a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Flags choosing whether renames and copies are looked for
+type diffRenameFlags struct {
+	findRenames string
+	findCopies  []string
+	noRenames   bool
+	renameLimit int
+}
+
+// Commands with -M and -C, whose scores git takes right after the flag
+var renameFlagCommands = make(map[*cobra.Command]bool)
+
+func (f *diffRenameFlags) register(cmd *cobra.Command, opts *diff.Options) {
+	renameFlagCommands[cmd] = true
+	cmd.Flags().
+		StringVarP(&f.findRenames, "find-renames", "M", "", "detect renames, optionally of the given least similarity")
+	cmd.Flags().Lookup("find-renames").NoOptDefVal = "50%"
+	cmd.Flags().
+		StringArrayVarP(&f.findCopies, "find-copies", "C", nil, "detect copies as well as renames, optionally of the given least similarity")
+	cmd.Flags().Lookup("find-copies").NoOptDefVal = "50%"
+	cmd.Flags().
+		BoolVar(&opts.FindCopiesHarder, "find-copies-harder", false, "look for copies of unmodified files too")
+	cmd.Flags().
+		BoolVar(&f.noRenames, "no-renames", false, "turn off rename detection")
+	cmd.Flags().
+		IntVarP(&f.renameLimit, "rename-limit", "l", 0, "look for inexact renames only among this many files")
+}
+
+// Sets the detection from the flags; without them porcelain commands
+// detect what diff.renames says, renames by default. The limit comes from
+// diff.renameLimit unless given
+func (f *diffRenameFlags) apply(cmd *cobra.Command, opts *diff.Options, porcelain bool) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	limit, err := cfg.GetInt("diff.renameLimit", diff.DefaultRenameLimit)
+	if err != nil {
+		return err
+	}
+	opts.RenameLimit = int(limit)
+	if cmd.Flags().Changed("rename-limit") {
+		opts.RenameLimit = f.renameLimit
+	}
+	opts.Detect = diff.DetectNone
+	if porcelain {
+		value, _ := cfg.Get("diff.renames")
+		switch strings.ToLower(value) {
+		case "copies", "copy":
+			opts.Detect = diff.DetectCopies
+		default:
+			renames, err := cfg.GetBool("diff.renames", true)
+			if err != nil {
+				return err
+			}
+			if renames {
+				opts.Detect = diff.DetectRenames
+			}
+		}
+	}
+	if f.noRenames {
+		opts.Detect = diff.DetectNone
+	}
+	if cmd.Flags().Changed("find-renames") {
+		opts.Detect = diff.DetectRenames
+		if opts.RenameScore, err = diff.ParseScore(f.findRenames); err != nil {
+			return err
+		}
+	}
+	if len(f.findCopies) > 0 {
+		opts.Detect = diff.DetectCopies
+		if opts.RenameScore, err = diff.ParseScore(f.findCopies[len(f.findCopies)-1]); err != nil {
+			return err
+		}
+		// given twice, -C looks for copies of unmodified files too
+		if len(f.findCopies) > 1 {
+			opts.FindCopiesHarder = true
+		}
+	}
+	if opts.FindCopiesHarder {
+		opts.Detect = diff.DetectCopies
+	}
+	return nil
+}
+
+// Arguments with the scores given as in "-M50%" attached by "=" for the
+// flags to take them
+func attachScores(args []string) []string {
+	res := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			copy(res[i:], args[i:])
+			break
+		}
+		if len(arg) > 2 && (strings.HasPrefix(arg, "-M") || strings.HasPrefix(arg, "-C")) && arg[2] != '=' {
+			arg = arg[:2] + "=" + arg[2:]
+		}
+		res[i] = arg
+	}
+	return res
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"bufio"
+	"os"
+
+	"github.com/magnickolas/gitok/gitok_diff"
+	"github.com/spf13/cobra"
+)
+
+var (
+	diffTreeCmd = &cobra.Command{
+		Use:   "diff-tree [-r] [--root] <tree-ish> [<tree-ish>] [[--] <path>...]",
+		Short: "Compares the content and mode of blobs found via two tree objects",
+		Run: func(cmd *cobra.Command, args []string) {
+			opts := diffTreeOpts
+			revs, paths := splitRevisionsAndPaths(args, cmd.ArgsLenAtDash())
+			if err := diffTreeLines.apply(cmd, &opts.Diff); err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			if err := diffTreeFormat.apply(cmd, &opts.Diff); err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			if err := diffTreeRenames.apply(cmd, &opts.Diff, false); err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			if err := diffTreeColors.apply(cmd, &opts.Diff, false); err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			w := bufio.NewWriter(os.Stdout)
+			err := gitok_diff.DiffTree(w, os.Stderr, revs, paths, opts)
+			if flushErr := w.Flush(); err == nil {
+				err = flushErr
+			}
+			if err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+		},
+	}
+	diffTreeOpts    gitok_diff.TreeOptions
+	diffTreeLines   diffLineFlags
+	diffTreeFormat  diffFormatFlags
+	diffTreeRenames diffRenameFlags
+	diffTreeColors  diffColorFlags
+)
+
+func init() {
+	diffTreeCmd.Flags().
+		BoolVarP(&diffTreeOpts.Recursive, "recursive", "r", false, "recurse into sub-trees")
+	diffTreeCmd.Flags().
+		BoolVar(&diffTreeOpts.Root, "root", false, "show the initial commit as a big creation event")
+	diffTreeFormat.register(diffTreeCmd, &diffTreeOpts.Diff)
+	diffTreeLines.register(diffTreeCmd, &diffTreeOpts.Diff)
+	diffTreeRenames.register(diffTreeCmd, &diffTreeOpts.Diff)
+	diffTreeColors.register(diffTreeCmd)
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+
+	"github.com/magnickolas/gitok/diff"
+	"github.com/magnickolas/gitok/gitok_format_patch"
+	"github.com/spf13/cobra"
+)
+
+var (
+	formatPatchCmd = &cobra.Command{
+		Use:   "format-patch [<since> | <revision-range>]",
+		Short: "Prepare patches for e-mail submission",
+		Run: func(cmd *cobra.Command, args []string) {
+			opts := formatPatchOpts
+			if cmd.Flags().Changed("subject-prefix") {
+				opts.SubjectPrefix = &formatPatchSubjectPrefix
+			}
+			if cmd.Flags().Changed("signature") {
+				opts.Signature = &formatPatchSignature
+			}
+			if formatPatchNoSignature {
+				empty := ""
+				opts.Signature = &empty
+			}
+			if err := formatPatchLines.apply(cmd, &opts.Diff); err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			if err := formatPatchRenames.apply(cmd, &opts.Diff, true); err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			w := bufio.NewWriter(os.Stdout)
+			err := gitok_format_patch.FormatPatch(w, args, opts)
+			if flushErr := w.Flush(); err == nil {
+				err = flushErr
+			}
+			if err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+		},
+	}
+	formatPatchOpts          gitok_format_patch.Options
+	formatPatchSubjectPrefix string
+	formatPatchSignature     string
+	formatPatchNoSignature   bool
+	formatPatchLines         diffLineFlags
+	formatPatchRenames       diffRenameFlags
+)
+
+var countFlag = regexp.MustCompile(`^-[0-9]+$`)
+
+// Turns "-<n>" into "--max-count=<n>", which pflag cannot parse itself
+func expandCounts(args []string) []string {
+	res := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			copy(res[i:], args[i:])
+			break
+		}
+		if countFlag.MatchString(arg) {
+			arg = "--max-count=" + arg[1:]
+		}
+		res[i] = arg
+	}
+	return res
+}
+
+func init() {
+	formatPatchCmd.Flags().
+		IntVar(&formatPatchOpts.MaxCount, "max-count", -1, "prepare patches from the topmost <n> commits")
+	formatPatchCmd.Flags().
+		BoolVar(&formatPatchOpts.Root, "root", false, "treat the revision as a range from the root commit")
+	formatPatchCmd.Flags().
+		StringVarP(&formatPatchOpts.OutputDir, "output-directory", "o", "", "store resulting files in <dir>")
+	formatPatchCmd.Flags().
+		BoolVar(&formatPatchOpts.Stdout, "stdout", false, "print patches to standard out")
+	formatPatchCmd.Flags().
+		BoolVarP(&formatPatchOpts.Numbered, "numbered", "n", false, "use [PATCH n/m] even with a single patch")
+	formatPatchCmd.Flags().
+		BoolVarP(&formatPatchOpts.NoNumbered, "no-numbered", "N", false, "use [PATCH] even with multiple patches")
+	formatPatchCmd.Flags().
+		IntVar(&formatPatchOpts.StartNumber, "start-number", 1, "start numbering patches at <n> instead of 1")
+	formatPatchCmd.Flags().
+		StringVar(&formatPatchSubjectPrefix, "subject-prefix", "PATCH", "use [<prefix>] instead of [PATCH]")
+	formatPatchCmd.Flags().
+		BoolVar(&formatPatchOpts.RFC, "rfc", false, "use [RFC PATCH] instead of [PATCH]")
+	formatPatchCmd.Flags().
+		StringVarP(&formatPatchOpts.RerollCount, "reroll-count", "v", "", "mark the series as Nth re-roll")
+	formatPatchCmd.Flags().
+		BoolVar(&formatPatchOpts.CoverLetter, "cover-letter", false, "generate a cover letter")
+	formatPatchCmd.Flags().
+		StringVar(&formatPatchOpts.Base, "base", "", "add prerequisite tree info to the patch series, from a commit or auto")
+	formatPatchCmd.Flags().
+		BoolVarP(&formatPatchOpts.KeepSubject, "keep-subject", "k", false, "don't strip/add [PATCH]")
+	formatPatchCmd.Flags().
+		BoolVar(&formatPatchOpts.NumberedFiles, "numbered-files", false, "use simple number sequence for output file names")
+	formatPatchCmd.Flags().
+		StringVar(&formatPatchOpts.Suffix, "suffix", ".patch", "use <sfx> instead of '.patch'")
+	formatPatchCmd.Flags().
+		BoolVar(&formatPatchOpts.ZeroCommit, "zero-commit", false, "output all-zero hash in From header")
+	formatPatchCmd.Flags().
+		BoolVar(&formatPatchOpts.NoStat, "no-stat", false, "don't generate diffstats")
+	formatPatchCmd.Flags().
+		StringVar(&formatPatchSignature, "signature", "", "add a signature")
+	formatPatchCmd.Flags().
+		BoolVar(&formatPatchNoSignature, "no-signature", false, "don't add a signature")
+	formatPatchCmd.Flags().
+		IntVarP(&formatPatchOpts.Diff.Context, "unified", "U", diff.DefaultContext, "generate diffs with <n> lines of context")
+	formatPatchLines.register(formatPatchCmd, &formatPatchOpts.Diff)
+	formatPatchRenames.register(formatPatchCmd, &formatPatchOpts.Diff)
+	formatPatchCmd.MarkFlagsMutuallyExclusive("stdout", "output-directory")
+	formatPatchCmd.MarkFlagsMutuallyExclusive("numbered", "no-numbered")
+	formatPatchCmd.MarkFlagsMutuallyExclusive("signature", "no-signature")
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"bufio"
+	"os"
+
+	"github.com/magnickolas/gitok/gitok_fsck"
+	"github.com/spf13/cobra"
+)
+
+var (
+	fsckCmd = &cobra.Command{
+		Use:   "fsck",
+		Short: "Verify the connectivity and validity of the objects in the database",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			w := bufio.NewWriter(os.Stdout)
+			ok, err := gitok_fsck.Fsck(w, os.Stderr, fsckOpts)
+			if flushErr := w.Flush(); err == nil {
+				err = flushErr
+			}
+			if err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			if !ok {
+				os.Exit(1)
+			}
+		},
+	}
+	fsckOpts gitok_fsck.Options
+)
+
+func init() {
+	fsckCmd.Flags().
+		BoolVar(&fsckOpts.Unreachable, "unreachable", false, "show unreachable objects")
+	fsckCmd.Flags().
+		BoolVar(&fsckOpts.NoDangling, "no-dangling", false, "don't show dangling objects")
+	fsckCmd.Flags().
+		BoolVar(&fsckOpts.NoReflogs, "no-reflogs", false, "don't consider commits referenced only by reflog entries reachable")
+	fsckCmd.Flags().
+		BoolVar(&fsckOpts.ConnectivityOnly, "connectivity-only", false, "check only the connectivity, not the content of objects")
+	fsckCmd.Flags().
+		BoolVar(&fsckOpts.Strict, "strict", false, "enable more strict checking")
+}
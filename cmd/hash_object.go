@@ -1,9 +1,12 @@
 package cmd
 
 import (
+	"bufio"
 	"fmt"
 	"os"
 
+	"github.com/magnickolas/gitok/config"
+	"github.com/magnickolas/gitok/convert"
 	"github.com/magnickolas/gitok/gitok_hash"
 	"github.com/spf13/cobra"
 )
@@ -12,30 +15,83 @@ var (
 	hashObjectCmd = &cobra.Command{
 		Use:   "hash-object",
 		Short: "Hash an object",
-		Args:  cobra.MaximumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			if !readFromStdin && len(args) == 0 {
+			if !readFromStdin && !readPathsFromStdin && len(args) == 0 {
 				fatalln("expected filename")
 			}
-			var r *os.File
+			if readPathsFromStdin && (readFromStdin || len(args) > 0) {
+				fatalln("--stdin-paths is incompatible with --stdin and file arguments")
+			}
+			if noFilters && hashPath != "" {
+				fatalln("--no-filters is incompatible with --path")
+			}
+			cfg, err := config.Load()
+			if err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			threshold, err := cfg.GetInt("core.bigFileThreshold", gitok_hash.DefaultBigFileThreshold)
+			if err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			opts := gitok_hash.Options{
+				Type:             objectType,
+				Write:            write,
+				Literally:        literally,
+				BigFileThreshold: threshold,
+			}
+			var converter *convert.Converter
+			if !noFilters {
+				converter, err = convert.NewConverter(cfg)
+				if err != nil {
+					fatalf("fatal: %v\n", err)
+				}
+			}
+			optsFor := func(path string) gitok_hash.Options {
+				fileOpts := opts
+				if !noFilters {
+					fileOpts.Converter = converter
+					fileOpts.Path = path
+					if hashPath != "" {
+						fileOpts.Path = hashPath
+					}
+				}
+				return fileOpts
+			}
+			hashFile := func(path string) {
+				key, err := gitok_hash.HashFile(path, optsFor(path))
+				if err != nil {
+					fatalf("fatal: %v: %v\n", path, err)
+				}
+				fmt.Println(key)
+			}
 			if readFromStdin {
-				r = os.Stdin
-			} else {
-				var err error
-				r, err = os.Open(args[0])
+				key, err := gitok_hash.HashObject(os.Stdin, optsFor(""))
 				if err != nil {
-					fatalf("cannot open %v: %v", args[0], err)
+					fatalf("fatal: %v\n", err)
 				}
+				fmt.Println(key)
 			}
-			key, err := gitok_hash.ProcessBlob(r, write)
-			if err != nil {
-				panic(err)
+			for _, path := range args {
+				hashFile(path)
+			}
+			if readPathsFromStdin {
+				sc := bufio.NewScanner(os.Stdin)
+				for sc.Scan() {
+					hashFile(sc.Text())
+				}
+				if err := sc.Err(); err != nil {
+					fatalf("fatal: %v\n", err)
+				}
 			}
-			fmt.Println(key)
 		},
 	}
-	write         bool
-	readFromStdin bool
+	write              bool
+	readFromStdin      bool
+	readPathsFromStdin bool
+	objectType         string
+	literally          bool
+	hashPath           string
+	noFilters          bool
 )
 
 func init() {
@@ -43,4 +99,14 @@ func init() {
 		BoolVarP(&write, "write", "w", false, "whether to write an object to storage")
 	hashObjectCmd.Flags().
 		BoolVar(&readFromStdin, "stdin", false, "read from stdin instead of file")
+	hashObjectCmd.Flags().
+		BoolVar(&readPathsFromStdin, "stdin-paths", false, "read file names from stdin, one per line")
+	hashObjectCmd.Flags().
+		StringVarP(&objectType, "type", "t", "blob", "type of the object to create")
+	hashObjectCmd.Flags().
+		BoolVar(&literally, "literally", false, "skip validation of the object content and type")
+	hashObjectCmd.Flags().
+		StringVar(&hashPath, "path", "", "hash the object as if it were located at the given path")
+	hashObjectCmd.Flags().
+		BoolVar(&noFilters, "no-filters", false, "hash the contents as is, ignoring any input filter")
 }
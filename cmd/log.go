@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/magnickolas/gitok/date"
+	"github.com/magnickolas/gitok/gitok_log"
+	"github.com/magnickolas/gitok/revparse"
+	"github.com/magnickolas/gitok/revwalk"
+	"github.com/spf13/cobra"
+)
+
+var (
+	logCmd = &cobra.Command{
+		Use:   "log [<revision-range>] [[--] <path>...]",
+		Short: "Show commit logs",
+		Run: func(cmd *cobra.Command, args []string) {
+			opts := logOpts
+			revs, paths := splitRevisionsAndPaths(args, cmd.ArgsLenAtDash())
+			switch {
+			case logTopoOrder:
+				opts.Walk.Sort = revwalk.SortTopo
+			case logDateOrder:
+				opts.Walk.Sort = revwalk.SortDate
+			case logAuthorDateOrder:
+				opts.Walk.Sort = revwalk.SortAuthorDate
+			}
+			now := time.Now()
+			var err error
+			if logSince != "" {
+				if opts.Walk.Since, err = date.Approx(logSince, now); err != nil {
+					fatalf("fatal: %v\n", err)
+				}
+			}
+			if logUntil != "" {
+				if opts.Walk.Until, err = date.Approx(logUntil, now); err != nil {
+					fatalf("fatal: %v\n", err)
+				}
+			}
+			if logOneline {
+				opts.Pretty.AbbrevCommit = true
+				logPretty = "oneline"
+			}
+			if cmd.Flags().Changed("pretty") || cmd.Flags().Changed("format") || logOneline {
+				if err := opts.Pretty.SetFormat(logPretty); err != nil {
+					fatalf("fatal: %v\n", err)
+				}
+			}
+			if logDate != "" {
+				if opts.Pretty.Date, err = date.ParseFormat(logDate); err != nil {
+					fatalf("fatal: %v\n", err)
+				}
+			}
+			if opts.Walk.Authors, err = compilePatterns(logAuthors); err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			if opts.Walk.Committers, err = compilePatterns(logCommitters); err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			if opts.Walk.Greps, err = compilePatterns(logGreps); err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			if err := logDiffLines.apply(cmd, &opts.Diff); err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			if err := logRenames.apply(cmd, &opts.Diff, true); err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			if err := logFormat.apply(cmd, &opts.Diff); err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			switch {
+			case logNoDecorate:
+				opts.Decorate = gitok_log.DecorateNo
+			case logDecorate == "auto":
+				opts.Decorate = autoDecorate()
+			default:
+				if opts.Decorate, err = gitok_log.ParseDecorate(logDecorate); err != nil {
+					fatalf("fatal: %v\n", err)
+				}
+			}
+			w := bufio.NewWriter(os.Stdout)
+			err = gitok_log.Log(w, revs, paths, opts)
+			if flushErr := w.Flush(); err == nil {
+				err = flushErr
+			}
+			if err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+		},
+	}
+	logOpts            gitok_log.Options
+	logTopoOrder       bool
+	logDateOrder       bool
+	logAuthorDateOrder bool
+	logSince           string
+	logUntil           string
+	logPretty          string
+	logOneline         bool
+	logDate            string
+	logAuthors         []string
+	logCommitters      []string
+	logGreps           []string
+	logIgnoreCase      bool
+	logFixedStrings    bool
+	logDecorate        string
+	logNoDecorate      bool
+	logDiffLines       diffLineFlags
+	logFormat          diffFormatFlags
+	logRenames         diffRenameFlags
+)
+
+// Decorations of --decorate=auto: only on a terminal
+func autoDecorate() gitok_log.Decorate {
+	if isTerminal(os.Stdout) {
+		return gitok_log.DecorateShort
+	}
+	return gitok_log.DecorateNo
+}
+
+// Splits the arguments into revisions and paths at "--" or, without it,
+// at the first argument that is not a revision but names a file
+func splitRevisionsAndPaths(args []string, dash int) ([]string, []string) {
+	if dash != -1 {
+		return args[:dash], args[dash:]
+	}
+	for i, arg := range args {
+		if strings.Contains(arg, "..") || strings.HasPrefix(arg, "^") {
+			continue
+		}
+		if _, err := revparse.Resolve(strings.TrimSuffix(strings.TrimSuffix(arg, "^@"), "^!")); err == nil {
+			continue
+		}
+		if _, err := os.Lstat(arg); err == nil {
+			return args[:i], args[i:]
+		}
+	}
+	return args, nil
+}
+
+// Compiles the patterns given to --author, --committer or --grep
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, pattern := range patterns {
+		if logFixedStrings {
+			pattern = regexp.QuoteMeta(pattern)
+		}
+		if logIgnoreCase {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+func init() {
+	logCmd.Flags().
+		IntVarP(&logOpts.Walk.MaxCount, "max-count", "n", -1, "limit the number of commits to output")
+	logCmd.Flags().
+		IntVar(&logOpts.Walk.Skip, "skip", 0, "skip number of commits before starting to show the output")
+	logCmd.Flags().
+		BoolVar(&logOpts.Walk.Reverse, "reverse", false, "output the commits in reverse order")
+	logCmd.Flags().
+		BoolVar(&logOpts.Walk.FirstParent, "first-parent", false, "follow only the first parent of merge commits")
+	logCmd.Flags().
+		BoolVar(&logOpts.Walk.AncestryPath, "ancestry-path", false, "show only commits that are descendants of an excluded commit")
+	logCmd.Flags().
+		BoolVar(&logTopoOrder, "topo-order", false, "show no parents before all of their children, avoiding intermixed lines of history")
+	logCmd.Flags().
+		BoolVar(&logDateOrder, "date-order", false, "show no parents before all of their children, otherwise in commit timestamp order")
+	logCmd.Flags().
+		BoolVar(&logAuthorDateOrder, "author-date-order", false, "show no parents before all of their children, otherwise in author timestamp order")
+	logCmd.Flags().
+		StringVar(&logSince, "since", "", "show commits more recent than a specific date")
+	logCmd.Flags().
+		StringVar(&logSince, "after", "", "show commits more recent than a specific date")
+	logCmd.Flags().
+		StringVar(&logUntil, "until", "", "show commits older than a specific date")
+	logCmd.Flags().
+		StringVar(&logUntil, "before", "", "show commits older than a specific date")
+	logCmd.Flags().
+		BoolVar(&logOpts.All, "all", false, "start from all refs and HEAD")
+	logCmd.Flags().
+		StringVar(&logPretty, "pretty", "", "pretty-print the commits in the given format")
+	logCmd.Flags().
+		StringVar(&logPretty, "format", "", "pretty-print the commits in the given format")
+	logCmd.Flags().
+		BoolVar(&logOneline, "oneline", false, "shorthand for --pretty=oneline --abbrev-commit")
+	logCmd.Flags().
+		BoolVar(&logOpts.Pretty.AbbrevCommit, "abbrev-commit", false, "show abbreviated commit object names")
+	logCmd.Flags().
+		StringVar(&logDate, "date", "", "format of the dates shown (relative, iso, rfc, short, raw, format:...)")
+	logCmd.Flags().
+		StringArrayVar(&logAuthors, "author", nil, "show only commits whose author matches the pattern")
+	logCmd.Flags().
+		StringArrayVar(&logCommitters, "committer", nil, "show only commits whose committer matches the pattern")
+	logCmd.Flags().
+		StringArrayVar(&logGreps, "grep", nil, "show only commits with a message line matching the pattern")
+	logCmd.Flags().
+		BoolVar(&logOpts.Walk.AllMatch, "all-match", false, "show only commits matching all the --grep patterns")
+	logCmd.Flags().
+		BoolVar(&logOpts.Walk.InvertGrep, "invert-grep", false, "show only commits not matching the patterns")
+	logCmd.Flags().
+		BoolVarP(&logIgnoreCase, "regexp-ignore-case", "i", false, "match the patterns regardless of case")
+	logCmd.Flags().
+		BoolVarP(&logFixedStrings, "fixed-strings", "F", false, "take the patterns as fixed strings")
+	logCmd.Flags().
+		BoolVar(&logOpts.Follow, "follow", false, "continue listing the history of a file beyond renames")
+	logFormat.register(logCmd, &logOpts.Diff)
+	logDiffLines.register(logCmd, &logOpts.Diff)
+	logRenames.register(logCmd, &logOpts.Diff)
+	logCmd.Flags().
+		BoolVar(&logOpts.Graph, "graph", false, "draw a text-based graph of the history on the left side of the output")
+	logCmd.Flags().
+		StringVar(&logDecorate, "decorate", "auto", "print the ref names of the commits shown (short, full, auto or no)")
+	logCmd.Flags().Lookup("decorate").NoOptDefVal = "short"
+	logCmd.Flags().
+		BoolVar(&logNoDecorate, "no-decorate", false, "do not print the ref names of the commits shown")
+	logCmd.MarkFlagsMutuallyExclusive("topo-order", "date-order", "author-date-order")
+	logCmd.MarkFlagsMutuallyExclusive("graph", "reverse")
+}
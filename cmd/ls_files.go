@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"bufio"
+	"os"
+
+	"github.com/magnickolas/gitok/gitok_ls_files"
+	"github.com/spf13/cobra"
+)
+
+var (
+	lsFilesCmd = &cobra.Command{
+		Use:   "ls-files [<path>...]",
+		Short: "Show information about files in the index and the working tree",
+		Run: func(cmd *cobra.Command, args []string) {
+			w := bufio.NewWriter(os.Stdout)
+			err := gitok_ls_files.LsFiles(w, args, lsFilesOpts)
+			if flushErr := w.Flush(); err == nil {
+				err = flushErr
+			}
+			if err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+		},
+	}
+	lsFilesOpts gitok_ls_files.Options
+)
+
+func init() {
+	lsFilesCmd.Flags().
+		BoolVarP(&lsFilesOpts.Cached, "cached", "c", false, "show cached files in the output (default)")
+	lsFilesCmd.Flags().
+		BoolVarP(&lsFilesOpts.Deleted, "deleted", "d", false, "show deleted files in the output")
+	lsFilesCmd.Flags().
+		BoolVarP(&lsFilesOpts.Modified, "modified", "m", false, "show modified files in the output")
+	lsFilesCmd.Flags().
+		BoolVarP(&lsFilesOpts.Others, "others", "o", false, "show other (i.e. untracked) files in the output")
+	lsFilesCmd.Flags().
+		BoolVarP(&lsFilesOpts.Unmerged, "unmerged", "u", false, "show unmerged files in the output")
+	lsFilesCmd.Flags().
+		BoolVarP(&lsFilesOpts.Stage, "stage", "s", false, "show staged contents' mode bits, object name and stage number")
+	lsFilesCmd.Flags().
+		BoolVar(&lsFilesOpts.Debug, "debug", false, "show stat data after each file")
+	lsFilesCmd.Flags().
+		BoolVar(&lsFilesOpts.ExcludeStandard, "exclude-standard", false, "add the standard git exclusions")
+	lsFilesCmd.Flags().
+		BoolVarP(&lsFilesOpts.NulTerminated, "null", "z", false, "terminate entries with NUL byte")
+}
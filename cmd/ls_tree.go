@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"bufio"
+	"os"
+
+	"github.com/magnickolas/gitok/gitok_ls_tree"
+	"github.com/spf13/cobra"
+)
+
+var (
+	lsTreeCmd = &cobra.Command{
+		Use:   "ls-tree <tree-ish> [<path>...]",
+		Short: "List the contents of a tree object",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			opts := lsTreeOpts
+			switch {
+			case lsTreeFormat != "":
+				opts.Format = lsTreeFormat
+			case lsTreeNameOnly:
+				opts.Format = gitok_ls_tree.NameOnlyFormat
+			case lsTreeObjectOnly:
+				opts.Format = gitok_ls_tree.ObjectFormat
+			case lsTreeLong:
+				opts.Format = gitok_ls_tree.LongFormat
+			}
+			w := bufio.NewWriter(os.Stdout)
+			err := gitok_ls_tree.LsTree(w, args[0], args[1:], opts)
+			if flushErr := w.Flush(); err == nil {
+				err = flushErr
+			}
+			if err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+		},
+	}
+	lsTreeOpts       gitok_ls_tree.Options
+	lsTreeLong       bool
+	lsTreeNameOnly   bool
+	lsTreeObjectOnly bool
+	lsTreeFormat     string
+)
+
+func init() {
+	lsTreeCmd.Flags().
+		BoolVarP(&lsTreeOpts.Recursive, "recursive", "r", false, "recurse into subtrees")
+	lsTreeCmd.Flags().
+		BoolVarP(&lsTreeOpts.ShowTrees, "show-trees", "t", false, "show tree entries even when going to recurse them")
+	lsTreeCmd.Flags().
+		BoolVarP(&lsTreeOpts.TreesOnly, "dirs-only", "d", false, "show only the named tree entry itself, not its children")
+	lsTreeCmd.Flags().
+		BoolVarP(&lsTreeOpts.NulTerminated, "null", "z", false, "terminate entries with NUL byte")
+	lsTreeCmd.Flags().
+		BoolVarP(&lsTreeLong, "long", "l", false, "show object size of blob entries")
+	lsTreeCmd.Flags().
+		BoolVar(&lsTreeNameOnly, "name-only", false, "list only filenames")
+	lsTreeCmd.Flags().
+		BoolVar(&lsTreeNameOnly, "name-status", false, "list only filenames")
+	lsTreeCmd.Flags().
+		BoolVar(&lsTreeObjectOnly, "object-only", false, "list only object names")
+	lsTreeCmd.Flags().
+		StringVar(&lsTreeFormat, "format", "", "format to use for the output")
+	lsTreeCmd.MarkFlagsMutuallyExclusive("long", "name-only", "name-status", "object-only", "format")
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+
+	"github.com/magnickolas/gitok/gitok_merge"
+	"github.com/spf13/cobra"
+)
+
+var (
+	mergeCmd = &cobra.Command{
+		Use:   "merge [<options>] <commit> | --abort | --continue",
+		Short: "Join two development histories together",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ok := true
+			var err error
+			switch {
+			case mergeAbort:
+				err = gitok_merge.Abort()
+			case mergeContinue:
+				err = gitok_merge.Continue(os.Stdout, os.Stderr)
+			case len(args) == 0:
+				_ = cmd.Usage()
+				os.Exit(1)
+			default:
+				ok, err = gitok_merge.Merge(os.Stdout, os.Stderr, args[0], mergeOpts)
+			}
+			switch {
+			case errors.Is(err, gitok_merge.ErrorNotSomethingWeCanMerge):
+				fatalf("%v\n", err)
+			case errors.Is(err, gitok_merge.ErrorLocalChanges), errors.Is(err, gitok_merge.ErrorUntrackedFiles):
+				fatalf("error: %v\n", err)
+			case err != nil:
+				fatalf("fatal: %v\n", err)
+			}
+			if !ok {
+				os.Exit(1)
+			}
+		},
+	}
+	mergeOpts     gitok_merge.Options
+	mergeAbort    bool
+	mergeContinue bool
+)
+
+func init() {
+	mergeCmd.Flags().
+		BoolVar(&mergeOpts.NoFF, "no-ff", false, "create a merge commit even when the merge resolves as a fast-forward")
+	mergeCmd.Flags().
+		BoolVar(&mergeOpts.Squash, "squash", false, "create a single commit instead of doing a merge")
+	mergeCmd.Flags().
+		StringVarP(&mergeOpts.Message, "message", "m", "", "merge commit message")
+	mergeCmd.Flags().
+		BoolVar(&mergeAbort, "abort", false, "abort the current in-progress merge")
+	mergeCmd.Flags().
+		BoolVar(&mergeContinue, "continue", false, "continue the current in-progress merge")
+	mergeCmd.MarkFlagsMutuallyExclusive("no-ff", "squash")
+	mergeCmd.MarkFlagsMutuallyExclusive("abort", "continue")
+}
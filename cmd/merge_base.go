@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"bufio"
+	"os"
+
+	"github.com/magnickolas/gitok/gitok_merge_base"
+	"github.com/spf13/cobra"
+)
+
+var (
+	mergeBaseCmd = &cobra.Command{
+		Use:   "merge-base [--all] <commit> <commit>...",
+		Short: "Find as good common ancestors as possible for a merge",
+		Run: func(cmd *cobra.Command, args []string) {
+			var ok bool
+			var err error
+			w := bufio.NewWriter(os.Stdout)
+			switch {
+			case mergeBaseIsAncestor:
+				ok, err = gitok_merge_base.IsAncestor(args)
+			case mergeBaseOctopus:
+				ok, err = gitok_merge_base.Octopus(w, args, mergeBaseOpts)
+			case mergeBaseForkPoint:
+				ok, err = gitok_merge_base.ForkPoint(w, args)
+			default:
+				ok, err = gitok_merge_base.MergeBase(w, args, mergeBaseOpts)
+			}
+			if flushErr := w.Flush(); err == nil {
+				err = flushErr
+			}
+			if err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			if !ok {
+				os.Exit(1)
+			}
+		},
+	}
+	mergeBaseOpts       gitok_merge_base.Options
+	mergeBaseIsAncestor bool
+	mergeBaseOctopus    bool
+	mergeBaseForkPoint  bool
+)
+
+func init() {
+	mergeBaseCmd.Flags().
+		BoolVarP(&mergeBaseOpts.All, "all", "a", false, "output all common ancestors")
+	mergeBaseCmd.Flags().
+		BoolVar(&mergeBaseOctopus, "octopus", false, "find ancestors for a single n-way merge")
+	mergeBaseCmd.Flags().
+		BoolVar(&mergeBaseIsAncestor, "is-ancestor", false, "is the first one ancestor of the other?")
+	mergeBaseCmd.Flags().
+		BoolVar(&mergeBaseForkPoint, "fork-point", false, "find where <commit> forked from reflog of <ref>")
+	mergeBaseCmd.MarkFlagsMutuallyExclusive("octopus", "is-ancestor", "fork-point")
+	mergeBaseCmd.MarkFlagsMutuallyExclusive("all", "is-ancestor")
+}
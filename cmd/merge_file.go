@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/magnickolas/gitok/gitok_merge_file"
+	"github.com/magnickolas/gitok/merge"
+	"github.com/spf13/cobra"
+)
+
+// Highest exit status telling the number of conflicts
+const maxConflictsStatus = 127
+
+var (
+	mergeFileCmd = &cobra.Command{
+		Use:   "merge-file [-L <name1> [-L <orig> [-L <name2>]]] <file1> <orig-file> <file2>",
+		Short: "Run a three-way file merge",
+		Args:  cobra.ExactArgs(3),
+		Run: func(cmd *cobra.Command, args []string) {
+			opts := mergeFileOpts
+			switch {
+			case mergeFileDiff3:
+				style := merge.StyleDiff3
+				opts.Style = &style
+			case mergeFileZealousDiff3:
+				style := merge.StyleZealousDiff3
+				opts.Style = &style
+			}
+			switch {
+			case mergeFileOurs:
+				opts.Favor = merge.FavorOurs
+			case mergeFileTheirs:
+				opts.Favor = merge.FavorTheirs
+			case mergeFileUnion:
+				opts.Favor = merge.FavorUnion
+			}
+			conflicts, err := gitok_merge_file.MergeFile(os.Stdout, args[0], args[1], args[2], opts)
+			if err != nil {
+				if !mergeFileQuiet {
+					fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				}
+				// the status of a negative result, told apart from
+				// numbers of conflicts
+				os.Exit(255)
+			}
+			os.Exit(min(conflicts, maxConflictsStatus))
+		},
+	}
+	mergeFileOpts         gitok_merge_file.Options
+	mergeFileDiff3        bool
+	mergeFileZealousDiff3 bool
+	mergeFileOurs         bool
+	mergeFileTheirs       bool
+	mergeFileUnion        bool
+	mergeFileQuiet        bool
+)
+
+func init() {
+	mergeFileCmd.Flags().
+		BoolVarP(&mergeFileOpts.Stdout, "stdout", "p", false, "send results to standard output")
+	mergeFileCmd.Flags().
+		BoolVar(&mergeFileDiff3, "diff3", false, "use a diff3 based merge")
+	mergeFileCmd.Flags().
+		BoolVar(&mergeFileZealousDiff3, "zdiff3", false, "use a zealous diff3 based merge")
+	mergeFileCmd.Flags().
+		BoolVar(&mergeFileOurs, "ours", false, "for conflicts, use our version")
+	mergeFileCmd.Flags().
+		BoolVar(&mergeFileTheirs, "theirs", false, "for conflicts, use their version")
+	mergeFileCmd.Flags().
+		BoolVar(&mergeFileUnion, "union", false, "for conflicts, use a union version")
+	mergeFileCmd.Flags().
+		IntVar(&mergeFileOpts.MarkerSize, "marker-size", 0, "for conflicts, use this marker size")
+	mergeFileCmd.Flags().
+		BoolVarP(&mergeFileQuiet, "quiet", "q", false, "do not warn about conflicts")
+	mergeFileCmd.Flags().
+		StringArrayVarP(&mergeFileOpts.Labels, "label", "L", nil, "set labels for file1/orig-file/file2")
+	mergeFileCmd.MarkFlagsMutuallyExclusive("diff3", "zdiff3")
+	mergeFileCmd.MarkFlagsMutuallyExclusive("ours", "theirs", "union")
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"bufio"
+	"os"
+
+	"github.com/magnickolas/gitok/gitok_merge_tree"
+	"github.com/spf13/cobra"
+)
+
+var (
+	mergeTreeCmd = &cobra.Command{
+		Use:   "merge-tree [--write-tree] [<options>] <branch1> <branch2>",
+		Short: "Perform merge without touching index or working tree",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			opts := mergeTreeOpts
+			if cmd.Flags().Changed("messages") {
+				opts.Messages = &mergeTreeMessages
+			}
+			if cmd.Flags().Changed("no-messages") {
+				messages := !mergeTreeNoMessages
+				opts.Messages = &messages
+			}
+			w := bufio.NewWriter(os.Stdout)
+			clean, err := gitok_merge_tree.MergeTree(w, args[0], args[1], opts)
+			if flushErr := w.Flush(); err == nil {
+				err = flushErr
+			}
+			if err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+			if !clean {
+				os.Exit(1)
+			}
+		},
+	}
+	mergeTreeOpts gitok_merge_tree.Options
+	// accepted for compatibility, writing the tree is the only mode
+	mergeTreeWriteTree  bool
+	mergeTreeMessages   bool
+	mergeTreeNoMessages bool
+)
+
+func init() {
+	mergeTreeCmd.Flags().
+		BoolVar(&mergeTreeWriteTree, "write-tree", true, "do a real merge instead of a trivial merge")
+	mergeTreeCmd.Flags().
+		BoolVar(&mergeTreeMessages, "messages", false, "also show informational/conflict messages")
+	mergeTreeCmd.Flags().
+		BoolVar(&mergeTreeNoMessages, "no-messages", false, "do not show informational/conflict messages")
+	mergeTreeCmd.Flags().
+		BoolVar(&mergeTreeOpts.NameOnly, "name-only", false, "list filenames without modes/oids/stages")
+	mergeTreeCmd.Flags().
+		BoolVarP(&mergeTreeOpts.NulTerminated, "null", "z", false, "separate paths with the NUL character")
+	mergeTreeCmd.Flags().
+		BoolVar(&mergeTreeOpts.AllowUnrelatedHistories, "allow-unrelated-histories", false, "allow merging unrelated histories")
+	mergeTreeCmd.MarkFlagsMutuallyExclusive("messages", "no-messages")
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"bufio"
+	"os"
+	"time"
+
+	"github.com/magnickolas/gitok/date"
+	"github.com/magnickolas/gitok/gitok_rev_list"
+	"github.com/magnickolas/gitok/revwalk"
+	"github.com/spf13/cobra"
+)
+
+var (
+	revListCmd = &cobra.Command{
+		Use:   "rev-list <commit>...",
+		Short: "List commit objects in reverse chronological order",
+		Run: func(cmd *cobra.Command, args []string) {
+			opts := revListOpts
+			switch {
+			case revListTopoOrder:
+				opts.Walk.Sort = revwalk.SortTopo
+			case revListDateOrder:
+				opts.Walk.Sort = revwalk.SortDate
+			case revListAuthorDateOrder:
+				opts.Walk.Sort = revwalk.SortAuthorDate
+			}
+			now := time.Now()
+			var err error
+			if revListSince != "" {
+				if opts.Walk.Since, err = date.Approx(revListSince, now); err != nil {
+					fatalf("fatal: %v\n", err)
+				}
+			}
+			if revListUntil != "" {
+				if opts.Walk.Until, err = date.Approx(revListUntil, now); err != nil {
+					fatalf("fatal: %v\n", err)
+				}
+			}
+			if len(args) == 0 && !opts.All {
+				fatalln("usage: gitok rev-list [<options>] <commit>...")
+			}
+			w := bufio.NewWriter(os.Stdout)
+			err = gitok_rev_list.RevList(w, args, opts)
+			if flushErr := w.Flush(); err == nil {
+				err = flushErr
+			}
+			if err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+		},
+	}
+	revListOpts            gitok_rev_list.Options
+	revListTopoOrder       bool
+	revListDateOrder       bool
+	revListAuthorDateOrder bool
+	revListSince           string
+	revListUntil           string
+)
+
+func init() {
+	revListCmd.Flags().
+		IntVarP(&revListOpts.Walk.MaxCount, "max-count", "n", -1, "limit the number of commits to output")
+	revListCmd.Flags().
+		IntVar(&revListOpts.Walk.Skip, "skip", 0, "skip number of commits before starting to show the output")
+	revListCmd.Flags().
+		BoolVar(&revListOpts.Walk.Reverse, "reverse", false, "output the commits in reverse order")
+	revListCmd.Flags().
+		BoolVar(&revListOpts.Walk.FirstParent, "first-parent", false, "follow only the first parent of merge commits")
+	revListCmd.Flags().
+		BoolVar(&revListOpts.Walk.AncestryPath, "ancestry-path", false, "show only commits that are descendants of an excluded commit")
+	revListCmd.Flags().
+		BoolVar(&revListTopoOrder, "topo-order", false, "show no parents before all of their children, avoiding intermixed lines of history")
+	revListCmd.Flags().
+		BoolVar(&revListDateOrder, "date-order", false, "show no parents before all of their children, otherwise in commit timestamp order")
+	revListCmd.Flags().
+		BoolVar(&revListAuthorDateOrder, "author-date-order", false, "show no parents before all of their children, otherwise in author timestamp order")
+	revListCmd.Flags().
+		StringVar(&revListSince, "since", "", "show commits more recent than a specific date")
+	revListCmd.Flags().
+		StringVar(&revListSince, "after", "", "show commits more recent than a specific date")
+	revListCmd.Flags().
+		StringVar(&revListUntil, "until", "", "show commits older than a specific date")
+	revListCmd.Flags().
+		StringVar(&revListUntil, "before", "", "show commits older than a specific date")
+	revListCmd.Flags().
+		BoolVar(&revListOpts.All, "all", false, "start from all refs and HEAD")
+	revListCmd.Flags().
+		BoolVar(&revListOpts.Count, "count", false, "print the number of commits that would be listed")
+	revListCmd.Flags().
+		BoolVar(&revListOpts.Parents, "parents", false, "print also the parents of the commit")
+	revListCmd.MarkFlagsMutuallyExclusive("topo-order", "date-order", "author-date-order")
+}
@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"os"
+
 	"github.com/spf13/cobra"
 )
 
@@ -13,6 +15,16 @@ var rootCmd = &cobra.Command{
 }
 
 func Execute() error {
+	if cmd, _, err := rootCmd.Find(os.Args[1:]); err == nil {
+		args := os.Args[1:]
+		if renameFlagCommands[cmd] {
+			args = attachScores(args)
+		}
+		if cmd == formatPatchCmd {
+			args = expandCounts(args)
+		}
+		rootCmd.SetArgs(args)
+	}
 	return rootCmd.Execute()
 }
 
@@ -22,4 +34,24 @@ func init() {
 	rootCmd.AddCommand(catFileCmd)
 	rootCmd.AddCommand(configCmd)
 	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(commitTreeCmd)
+	rootCmd.AddCommand(commitCmd)
+	rootCmd.AddCommand(lsTreeCmd)
+	rootCmd.AddCommand(lsFilesCmd)
+	rootCmd.AddCommand(fsckCmd)
+	rootCmd.AddCommand(submoduleCmd)
+	rootCmd.AddCommand(revListCmd)
+	rootCmd.AddCommand(logCmd)
+	rootCmd.AddCommand(commitGraphCmd)
+	rootCmd.AddCommand(mergeBaseCmd)
+	rootCmd.AddCommand(diffCmd)
+	rootCmd.AddCommand(diffTreeCmd)
+	rootCmd.AddCommand(diffIndexCmd)
+	rootCmd.AddCommand(diffFilesCmd)
+	rootCmd.AddCommand(applyCmd)
+	rootCmd.AddCommand(formatPatchCmd)
+	rootCmd.AddCommand(amCmd)
+	rootCmd.AddCommand(mergeFileCmd)
+	rootCmd.AddCommand(mergeTreeCmd)
+	rootCmd.AddCommand(mergeCmd)
 }
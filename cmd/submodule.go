@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/magnickolas/gitok/gitok_submodule"
+	"github.com/spf13/cobra"
+)
+
+var (
+	submoduleCmd = &cobra.Command{
+		Use:   "submodule",
+		Short: "Inspect, initialize and update submodules",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := gitok_submodule.Status(os.Stdout, args); err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+		},
+	}
+	submoduleStatusCmd = &cobra.Command{
+		Use:   "status [<path>...]",
+		Short: "Show the status of the submodules",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := gitok_submodule.Status(os.Stdout, args); err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+		},
+	}
+	submoduleInitCmd = &cobra.Command{
+		Use:   "init [<path>...]",
+		Short: "Register the submodules' URLs in the repository config",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := gitok_submodule.Init(os.Stdout, args); err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+		},
+	}
+	submoduleUpdateCmd = &cobra.Command{
+		Use:   "update [<path>...]",
+		Short: "Check out the recorded commits in the submodules",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := gitok_submodule.Update(os.Stdout, os.Stderr, args, submoduleUpdateOpts); err != nil {
+				fatalf("fatal: %v\n", err)
+			}
+		},
+	}
+	submoduleUpdateOpts gitok_submodule.UpdateOptions
+)
+
+func init() {
+	submoduleUpdateCmd.Flags().
+		BoolVar(&submoduleUpdateOpts.Init, "init", false, "initialize the submodules that are not yet")
+	submoduleCmd.AddCommand(submoduleStatusCmd)
+	submoduleCmd.AddCommand(submoduleInitCmd)
+	submoduleCmd.AddCommand(submoduleUpdateCmd)
+}
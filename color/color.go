@@ -0,0 +1,132 @@
+package color
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrorInvalidColor       = errors.New("invalid color value")
+	formatErrorInvalidColor = func(spec string) error {
+		return fmt.Errorf("%w: %v", ErrorInvalidColor, spec)
+	}
+)
+
+const Reset = "\x1b[m"
+
+var names = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+var attributes = map[string]int{
+	"bold":    1,
+	"dim":     2,
+	"italic":  3,
+	"ul":      4,
+	"blink":   5,
+	"reverse": 7,
+	"strike":  9,
+}
+
+// Parses a color as written in git config, e.g. "bold red", "brightblue
+// black", "#ff8000 ul" or "reset", into the ANSI escape sequence setting it.
+// The first color is the foreground, the second the background
+func Parse(spec string) (string, error) {
+	var codes []int
+	var colors []string
+	for _, word := range strings.Fields(spec) {
+		if word == "reset" {
+			codes = append(codes, 0)
+			continue
+		}
+		if c, ok := parseColor(word); ok {
+			if len(colors) == 2 {
+				return "", formatErrorInvalidColor(spec)
+			}
+			colors = append(colors, c)
+			continue
+		}
+		negated := false
+		if rest, found := strings.CutPrefix(word, "no"); found {
+			negated = true
+			word = strings.TrimPrefix(rest, "-")
+		}
+		code, ok := attributes[word]
+		if !ok {
+			return "", formatErrorInvalidColor(spec)
+		}
+		if negated {
+			// bold and dim are both turned off by 22
+			code = 20 + max(code, 2)
+		}
+		codes = append(codes, code)
+	}
+	slices.Sort(codes)
+	var parts []string
+	for _, code := range slices.Compact(codes) {
+		parts = append(parts, strconv.Itoa(code))
+	}
+	for i, c := range colors {
+		if c == "" {
+			continue
+		}
+		if i == 1 {
+			c = background(c)
+		}
+		parts = append(parts, c)
+	}
+	switch {
+	case len(parts) == 1 && parts[0] == "0":
+		return Reset, nil
+	case len(parts) == 0:
+		return "", nil
+	}
+	return "\x1b[" + strings.Join(parts, ";") + "m", nil
+}
+
+// Foreground code of a color word, empty for "normal"
+func parseColor(word string) (string, bool) {
+	switch word {
+	case "normal":
+		return "", true
+	case "default":
+		return "39", true
+	}
+	if i := slices.Index(names, word); i != -1 {
+		return strconv.Itoa(30 + i), true
+	}
+	if rest, found := strings.CutPrefix(word, "bright"); found {
+		if i := slices.Index(names, rest); i != -1 {
+			return strconv.Itoa(90 + i), true
+		}
+	}
+	if strings.HasPrefix(word, "#") && len(word) == 7 {
+		rgb, err := strconv.ParseUint(word[1:], 16, 32)
+		if err != nil {
+			return "", false
+		}
+		return fmt.Sprintf("38;2;%d;%d;%d", rgb>>16, rgb>>8&0xff, rgb&0xff), true
+	}
+	if n, err := strconv.Atoi(word); err == nil && -1 <= n && n <= 255 {
+		switch {
+		case n == -1:
+			return "", true
+		case n < 8:
+			return strconv.Itoa(30 + n), true
+		case n < 16:
+			return strconv.Itoa(90 + n - 8), true
+		}
+		return "38;5;" + strconv.Itoa(n), true
+	}
+	return "", false
+}
+
+// Background code of a foreground one
+func background(fg string) string {
+	if rest, found := strings.CutPrefix(fg, "38;"); found {
+		return "48;" + rest
+	}
+	n, _ := strconv.Atoi(fg)
+	return strconv.Itoa(n + 10)
+}
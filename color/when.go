@@ -0,0 +1,36 @@
+package color
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrorInvalidWhen       = errors.New("expected \"always\", \"auto\", or \"never\"")
+	formatErrorInvalidWhen = func(when string) error {
+		return fmt.Errorf("%w, not '%v'", ErrorInvalidWhen, when)
+	}
+)
+
+// When to color output, as given to --color and color.* settings
+type When int
+
+const (
+	WhenNever When = iota
+	WhenAlways
+	// only when writing to a terminal
+	WhenAuto
+)
+
+func ParseWhen(s string) (When, error) {
+	switch strings.ToLower(s) {
+	case "never":
+		return WhenNever, nil
+	case "always":
+		return WhenAlways, nil
+	case "auto":
+		return WhenAuto, nil
+	}
+	return WhenNever, formatErrorInvalidWhen(s)
+}
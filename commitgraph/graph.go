@@ -0,0 +1,393 @@
+package commitgraph
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/magnickolas/gitok/repr"
+)
+
+var (
+	ErrorCorruptedGraph       = errors.New("corrupted commit-graph")
+	formatErrorCorruptedGraph = func(path string, reason string) error {
+		return fmt.Errorf("%w %v: %v", ErrorCorruptedGraph, path, reason)
+	}
+)
+
+const signature = "CGPH"
+const version = 1
+
+// Sizes of the header and of a chunk table entry
+const (
+	headerSize     = 8
+	chunkEntrySize = 12
+)
+
+const (
+	chunkFanout             = "OIDF"
+	chunkLookup             = "OIDL"
+	chunkCommitData         = "CDAT"
+	chunkGenerationData     = "GDA2"
+	chunkGenerationOverflow = "GDO2"
+	chunkExtraEdges         = "EDGE"
+	chunkBase               = "BASE"
+)
+
+const (
+	// parent position of commits with fewer parents
+	parentNone = 0x70000000
+	// set on the second parent position of octopus merges, which points
+	// into the extra edges instead, and on their last extra edge
+	parentOctopus = 0x80000000
+	// set on generation data offsets stored in the overflow chunk
+	offsetOverflow = 0x80000000
+	// largest topological level that fits
+	maxLevel = 0x3fffffff
+)
+
+// Generation of the commits that are not in the graph
+const Infinity = math.MaxUint64
+
+// Path of the graph of a single file
+func filePath(objectsDir string) string {
+	return filepath.Join(objectsDir, "info", "commit-graph")
+}
+
+// Directory of the layers of a split graph and of the file chaining them
+func chainDir(objectsDir string) string {
+	return filepath.Join(objectsDir, "info", "commit-graphs")
+}
+
+func chainPath(objectsDir string) string {
+	return filepath.Join(chainDir(objectsDir), "commit-graph-chain")
+}
+
+func layerPath(objectsDir string, hash string) string {
+	return filepath.Join(chainDir(objectsDir), "graph-"+hash+".graph")
+}
+
+// Version of the object hash in the header
+func hashVersion() byte {
+	if repr.HashSize() == 20 {
+		return 1
+	}
+	return 2
+}
+
+// A commit as recorded in the graph
+type Commit struct {
+	Tree    string
+	Parents []string
+	// commit time in seconds since the epoch
+	Date int64
+	// corrected commit date, or the topological level in graphs written
+	// without generation data: always greater than the parents' ones
+	Generation uint64
+	// one more than the parents' greatest level, 1 for root commits
+	level uint64
+}
+
+// Commit graph of an object database: a single file or a chain of layers,
+// each holding commits whose parents are in it or in the layers below
+type Graph struct {
+	// base layer first
+	layers []*layer
+	// whether every layer has generation data, else topological levels
+	// are the generation numbers
+	generationData bool
+}
+
+type layer struct {
+	path string
+	data []byte
+	// checksum of the file, naming the layers of a chain
+	hash       string
+	numCommits int
+	// commits in the layers below
+	base               int
+	fanout             []byte
+	lookup             []byte
+	commitData         []byte
+	generations        []byte
+	generationOverflow []byte
+	extraEdges         []byte
+	baseHashes         []byte
+}
+
+// Reads the commit graph of the object database: the single file if
+// present, otherwise the chain of split graphs. A database without one
+// has a nil graph
+func Open(objectsDir string) (*Graph, error) {
+	data, err := os.ReadFile(filePath(objectsDir))
+	if err == nil {
+		l, err := parseLayer(filePath(objectsDir), data)
+		if err != nil {
+			return nil, err
+		}
+		return newGraph([]*layer{l}), nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	hashes, err := readChain(objectsDir)
+	if err != nil || len(hashes) == 0 {
+		return nil, err
+	}
+	layers := make([]*layer, len(hashes))
+	for i, hash := range hashes {
+		path := layerPath(objectsDir, hash)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if layers[i], err = parseLayer(path, data); err != nil {
+			return nil, err
+		}
+		if layers[i].hash != hash {
+			return nil, formatErrorCorruptedGraph(path, "checksum does not match the file name")
+		}
+		if len(layers[i].baseHashes) != i*repr.HashSize() {
+			return nil, formatErrorCorruptedGraph(path, "wrong number of base graphs")
+		}
+		for j := 0; j < i; j += 1 {
+			if hashAt(layers[i].baseHashes, j) != hashes[j] {
+				return nil, formatErrorCorruptedGraph(path, "base graphs do not match the chain")
+			}
+		}
+		if i > 0 {
+			layers[i].base = layers[i-1].base + layers[i-1].numCommits
+		}
+	}
+	return newGraph(layers), nil
+}
+
+func newGraph(layers []*layer) *Graph {
+	g := &Graph{layers: layers, generationData: true}
+	for _, l := range layers {
+		if l.generations == nil {
+			g.generationData = false
+		}
+	}
+	return g
+}
+
+// Hashes of the layers listed in the chain file, base first
+func readChain(objectsDir string) ([]string, error) {
+	f, err := os.Open(chainPath(objectsDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var res []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if !repr.IsValidDigest(line) {
+			return nil, formatErrorCorruptedGraph(chainPath(objectsDir), "invalid layer "+line)
+		}
+		res = append(res, line)
+	}
+	return res, sc.Err()
+}
+
+func hashAt(b []byte, i int) string {
+	size := repr.HashSize()
+	return hex.EncodeToString(b[i*size : (i+1)*size])
+}
+
+func parseLayer(path string, data []byte) (*layer, error) {
+	hashSize := repr.HashSize()
+	if len(data) < headerSize+chunkEntrySize+hashSize {
+		return nil, formatErrorCorruptedGraph(path, "file too small")
+	}
+	if string(data[:4]) != signature {
+		return nil, formatErrorCorruptedGraph(path, "bad signature")
+	}
+	if data[4] != version {
+		return nil, formatErrorCorruptedGraph(path, fmt.Sprintf("unsupported version %v", data[4]))
+	}
+	if data[5] != hashVersion() {
+		return nil, formatErrorCorruptedGraph(path, fmt.Sprintf("unsupported hash version %v", data[5]))
+	}
+	numChunks := int(data[6])
+	end := len(data) - hashSize
+	if headerSize+(numChunks+1)*chunkEntrySize > end {
+		return nil, formatErrorCorruptedGraph(path, "chunk table out of bounds")
+	}
+	l := &layer{path: path, data: data, hash: hex.EncodeToString(data[end:])}
+	chunks := make(map[string][]byte)
+	for i := 0; i < numChunks; i += 1 {
+		entry := data[headerSize+i*chunkEntrySize:]
+		id := string(entry[:4])
+		start := binary.BigEndian.Uint64(entry[4:12])
+		stop := binary.BigEndian.Uint64(entry[chunkEntrySize+4 : chunkEntrySize+12])
+		if start > stop || stop > uint64(end) {
+			return nil, formatErrorCorruptedGraph(path, "chunk "+id+" out of bounds")
+		}
+		chunks[id] = data[start:stop]
+	}
+	l.fanout, l.lookup, l.commitData = chunks[chunkFanout], chunks[chunkLookup], chunks[chunkCommitData]
+	if len(l.fanout) != 256*4 || l.lookup == nil || l.commitData == nil {
+		return nil, formatErrorCorruptedGraph(path, "missing required chunks")
+	}
+	l.numCommits = int(binary.BigEndian.Uint32(l.fanout[255*4:]))
+	if len(l.lookup) != l.numCommits*hashSize || len(l.commitData) != l.numCommits*(hashSize+16) {
+		return nil, formatErrorCorruptedGraph(path, "wrong chunk sizes")
+	}
+	if gens, ok := chunks[chunkGenerationData]; ok {
+		if len(gens) != l.numCommits*4 {
+			return nil, formatErrorCorruptedGraph(path, "wrong generation data size")
+		}
+		l.generations = gens
+		l.generationOverflow = chunks[chunkGenerationOverflow]
+	}
+	l.extraEdges = chunks[chunkExtraEdges]
+	l.baseHashes = chunks[chunkBase]
+	if int(data[7])*hashSize != len(l.baseHashes) {
+		return nil, formatErrorCorruptedGraph(path, "wrong number of base graphs")
+	}
+	return l, nil
+}
+
+// Position of the digest among the commits of the layer
+func (l *layer) find(digest string) (int, bool) {
+	raw, err := hex.DecodeString(digest)
+	if err != nil || len(raw) != repr.HashSize() {
+		return 0, false
+	}
+	lo := 0
+	if raw[0] > 0 {
+		lo = int(binary.BigEndian.Uint32(l.fanout[(int(raw[0])-1)*4:]))
+	}
+	hi := int(binary.BigEndian.Uint32(l.fanout[int(raw[0])*4:]))
+	size := len(raw)
+	i := lo + sort.Search(hi-lo, func(i int) bool {
+		return bytes.Compare(l.lookup[(lo+i)*size:(lo+i+1)*size], raw) >= 0
+	})
+	if i < hi && bytes.Equal(l.lookup[i*size:(i+1)*size], raw) {
+		return i, true
+	}
+	return 0, false
+}
+
+// Position of the digest among all commits of the graph, base layer first
+func (g *Graph) find(digest string) (int, bool) {
+	for i := len(g.layers) - 1; i >= 0; i -= 1 {
+		if pos, ok := g.layers[i].find(digest); ok {
+			return g.layers[i].base + pos, true
+		}
+	}
+	return 0, false
+}
+
+// Layer holding the commit at the position and its position there
+func (g *Graph) layerOf(pos int) (*layer, int) {
+	for _, l := range g.layers {
+		if pos < l.base+l.numCommits {
+			return l, pos - l.base
+		}
+	}
+	return nil, 0
+}
+
+// Whether the generations are corrected commit dates rather than
+// topological levels
+func (g *Graph) CorrectedDates() bool {
+	return g.generationData
+}
+
+// Number of commits in the graph
+func (g *Graph) Len() int {
+	last := g.layers[len(g.layers)-1]
+	return last.base + last.numCommits
+}
+
+// Whether the commit is in the graph
+func (g *Graph) Contains(digest string) bool {
+	_, ok := g.find(digest)
+	return ok
+}
+
+// The commit as recorded in the graph, if it is there
+func (g *Graph) Lookup(digest string) (*Commit, bool, error) {
+	pos, ok := g.find(digest)
+	if !ok {
+		return nil, false, nil
+	}
+	c, err := g.commitAt(pos)
+	if err != nil {
+		return nil, false, err
+	}
+	return c, true, nil
+}
+
+func (g *Graph) digestAt(pos int) (string, error) {
+	l, i := g.layerOf(pos)
+	if l == nil {
+		return "", formatErrorCorruptedGraph(g.layers[len(g.layers)-1].path, fmt.Sprintf("invalid commit position %v", pos))
+	}
+	return hashAt(l.lookup, i), nil
+}
+
+func (g *Graph) commitAt(pos int) (*Commit, error) {
+	l, i := g.layerOf(pos)
+	hashSize := repr.HashSize()
+	data := l.commitData[i*(hashSize+16) : (i+1)*(hashSize+16)]
+	c := &Commit{Tree: hex.EncodeToString(data[:hashSize])}
+	data = data[hashSize:]
+	var parents []uint32
+	if p := binary.BigEndian.Uint32(data[0:4]); p != parentNone {
+		parents = append(parents, p)
+	}
+	if p := binary.BigEndian.Uint32(data[4:8]); p&parentOctopus != 0 {
+		edges := l.extraEdges[min(len(l.extraEdges), int(p&^parentOctopus)*4):]
+		for {
+			if len(edges) < 4 {
+				return nil, formatErrorCorruptedGraph(l.path, "extra edges out of bounds")
+			}
+			edge := binary.BigEndian.Uint32(edges)
+			parents = append(parents, edge&^parentOctopus)
+			if edge&parentOctopus != 0 {
+				break
+			}
+			edges = edges[4:]
+		}
+	} else if p != parentNone {
+		parents = append(parents, p)
+	}
+	for _, p := range parents {
+		digest, err := g.digestAt(int(p))
+		if err != nil {
+			return nil, err
+		}
+		c.Parents = append(c.Parents, digest)
+	}
+	levelAndTime := binary.BigEndian.Uint32(data[8:12])
+	c.Date = int64(uint64(levelAndTime&3)<<32 | uint64(binary.BigEndian.Uint32(data[12:16])))
+	c.level = uint64(levelAndTime >> 2)
+	c.Generation = c.level
+	if g.generationData {
+		offset := binary.BigEndian.Uint32(l.generations[i*4:])
+		if offset&offsetOverflow != 0 {
+			at := int(offset&^offsetOverflow) * 8
+			if at+8 > len(l.generationOverflow) {
+				return nil, formatErrorCorruptedGraph(l.path, "generation overflow out of bounds")
+			}
+			c.Generation = uint64(c.Date) + binary.BigEndian.Uint64(l.generationOverflow[at:])
+		} else {
+			c.Generation = uint64(c.Date) + uint64(offset)
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,134 @@
+package commitgraph_test
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/magnickolas/gitok/commitgraph"
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/repr"
+)
+
+const epoch = 1700000000
+
+type commitSpec struct {
+	name    string
+	date    int64
+	parents []string
+}
+
+// b is dated before its parent a, and d is an octopus merge of b, c and
+// the root e
+var history = []commitSpec{
+	{name: "a", date: 100},
+	{name: "b", date: 50, parents: []string{"a"}},
+	{name: "c", date: 200, parents: []string{"a"}},
+	{name: "e", date: 150},
+	{name: "d", date: 300, parents: []string{"b", "c", "e"}},
+}
+
+// Corrected commit dates of the history: past the parents' ones
+var wantGenerations = map[string]uint64{
+	"a": epoch + 100,
+	"b": epoch + 101,
+	"c": epoch + 200,
+	"e": epoch + 150,
+	"d": epoch + 300,
+}
+
+// Writes the commits of the history, each with a tree of its own, and
+// returns them by name
+func writeCommits(t *testing.T, db *fs.ObjectDB) map[string]*repr.Commit {
+	commits := map[string]*repr.Commit{}
+	for _, spec := range history {
+		tree := repr.NewTreeFromEntries([]repr.TreeEntry{
+			{Mode: repr.ModeNormal, Name: spec.name, Digest: repr.ZeroDigest()},
+		})
+		var parents []string
+		for _, p := range spec.parents {
+			parents = append(parents, commits[p].Digest())
+		}
+		sig := repr.Signature{Name: "a", Email: "a@b", When: time.Unix(epoch+spec.date, 0).UTC()}
+		commit := repr.NewCommitFromFields(tree.Digest(), parents, sig, sig, spec.name+"\n")
+		if err := db.WriteObject(commit); err != nil {
+			t.Fatal(err)
+		}
+		commits[spec.name] = commit
+	}
+	return commits
+}
+
+// Checks that the graph of the database records every commit of the
+// history as written
+func checkGraph(t *testing.T, db *fs.ObjectDB, commits map[string]*repr.Commit) {
+	g, err := commitgraph.Open(db.Dir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g == nil {
+		t.Fatal("no commit graph written")
+	}
+	if g.Len() != len(commits) {
+		t.Errorf("incorrect result for %#v: wanted %#v, got %#v", "Len", len(commits), g.Len())
+	}
+	if g.Contains(repr.ZeroDigest()) {
+		t.Errorf("graph contains %v", repr.ZeroDigest())
+	}
+	for name, commit := range commits {
+		if !g.Contains(commit.Digest()) {
+			t.Errorf("graph does not contain %v", name)
+		}
+		got, ok, err := g.Lookup(commit.Digest())
+		if err != nil || !ok {
+			t.Errorf("failed to look up %v: %v, %v", name, ok, err)
+			continue
+		}
+		want := commitgraph.Commit{
+			Tree:       commit.TreeDigest(),
+			Parents:    commit.Parents(),
+			Date:       commit.Committer().When.Unix(),
+			Generation: wantGenerations[name],
+		}
+		if got.Tree != want.Tree || !slices.Equal(got.Parents, want.Parents) ||
+			got.Date != want.Date || got.Generation != want.Generation {
+			t.Errorf("incorrect result for %#v: wanted %#v, got %#v", name, want, *got)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	db := fs.OpenObjectDB(t.TempDir())
+	commits := writeCommits(t, db)
+	err := commitgraph.Write(db, []string{commits["d"].Digest()}, commitgraph.WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGraph(t, db, commits)
+}
+
+func TestWriteSplit(t *testing.T) {
+	db := fs.OpenObjectDB(t.TempDir())
+	commits := writeCommits(t, db)
+	// the base layer is too large for the one on top to absorb it
+	layers := [][]string{
+		{commits["b"].Digest(), commits["c"].Digest(), commits["e"].Digest()},
+		{commits["d"].Digest()},
+	}
+	for _, tips := range layers {
+		if err := commitgraph.Write(db, tips, commitgraph.WriteOptions{Split: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	chain, err := os.ReadFile(filepath.Join(db.Dir(), "info", "commit-graphs", "commit-graph-chain"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(chain), "\n"); got != len(layers) {
+		t.Errorf("incorrect result for %#v: wanted %#v, got %#v", "layers", len(layers), got)
+	}
+	checkGraph(t, db, commits)
+}
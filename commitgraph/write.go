@@ -0,0 +1,395 @@
+package commitgraph
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/repr"
+)
+
+// Commits in a new layer of a split graph, times this factor, absorb the
+// layers below with no more commits
+const DefaultSizeMultiple = 2
+
+// Largest offset of a corrected commit date kept out of the overflow chunk
+const maxOffset = 0x7fffffff
+
+type WriteOptions struct {
+	// add a layer to the chain of split graphs instead of rewriting the
+	// single file
+	Split        bool
+	SizeMultiple int
+}
+
+// A commit to write with what the graph records of it
+type entry struct {
+	digest  string
+	tree    string
+	parents []string
+	date    int64
+	// zero until computed
+	level     uint64
+	corrected uint64
+}
+
+type writer struct {
+	db *fs.ObjectDB
+	// layers kept below the one written
+	base *Graph
+	// commits to write by digest
+	entries map[string]*entry
+}
+
+// Writes the graph of the commits and all their ancestors. A single file
+// replaces any existing graph; with Split, a new layer holding the commits
+// not yet in the graph goes on top of the existing ones, absorbing the
+// layers that are not much larger than it
+func Write(db *fs.ObjectDB, commits []string, opts WriteOptions) error {
+	existing, err := Open(db.Dir())
+	if err != nil {
+		return err
+	}
+	w := &writer{db: db, entries: make(map[string]*entry)}
+	var kept []*layer
+	if opts.Split && existing != nil {
+		kept = existing.layers
+		w.base = existing
+	}
+	if err := w.add(commits); err != nil {
+		return err
+	}
+	if opts.Split {
+		if len(w.entries) == 0 {
+			return nil
+		}
+		multiple := opts.SizeMultiple
+		if multiple <= 0 {
+			multiple = DefaultSizeMultiple
+		}
+		count := len(w.entries)
+		for len(kept) > 0 && kept[len(kept)-1].numCommits <= multiple*count {
+			top := kept[len(kept)-1]
+			count += top.numCommits
+			kept = kept[:len(kept)-1]
+			if err := w.absorb(top, existing); err != nil {
+				return err
+			}
+		}
+		w.base = nil
+		if len(kept) > 0 {
+			w.base = newGraph(kept)
+		}
+	}
+	data, err := w.encode()
+	if err != nil {
+		return err
+	}
+	dir := db.Dir()
+	if !opts.Split {
+		if err := writeFile(filePath(dir), data); err != nil {
+			return err
+		}
+		return removeChain(dir, nil)
+	}
+	// a single file below the new layer becomes the base of the chain
+	if len(kept) == 1 && kept[0].path == filePath(dir) {
+		if err := os.MkdirAll(chainDir(dir), os.ModePerm); err != nil {
+			return err
+		}
+		if err := os.Rename(kept[0].path, layerPath(dir, kept[0].hash)); err != nil {
+			return err
+		}
+	}
+	hash := hex.EncodeToString(data[len(data)-repr.HashSize():])
+	if err := writeFile(layerPath(dir, hash), data); err != nil {
+		return err
+	}
+	var chain strings.Builder
+	hashes := make([]string, 0, len(kept)+1)
+	for _, l := range kept {
+		hashes = append(hashes, l.hash)
+	}
+	hashes = append(hashes, hash)
+	for _, h := range hashes {
+		chain.WriteString(h)
+		chain.WriteString("\n")
+	}
+	if err := writeFile(chainPath(dir), []byte(chain.String())); err != nil {
+		return err
+	}
+	if err := os.Remove(filePath(dir)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return removeChain(dir, hashes)
+}
+
+// Adds the commits and their ancestors that are not in the base layers
+func (w *writer) add(commits []string) error {
+	stack := slices.Clone(commits)
+	for len(stack) > 0 {
+		digest := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := w.entries[digest]; ok || w.base != nil && w.base.Contains(digest) {
+			continue
+		}
+		commit, err := w.db.ReadCommit(digest)
+		if err != nil {
+			return err
+		}
+		w.entries[digest] = &entry{
+			digest:  digest,
+			tree:    commit.TreeDigest(),
+			parents: commit.Parents(),
+			date:    commit.Committer().When.Unix(),
+		}
+		stack = append(stack, commit.Parents()...)
+	}
+	return nil
+}
+
+// Adds the commits of a layer that the new one replaces
+func (w *writer) absorb(l *layer, g *Graph) error {
+	for i := 0; i < l.numCommits; i += 1 {
+		c, err := g.commitAt(l.base + i)
+		if err != nil {
+			return err
+		}
+		digest := hashAt(l.lookup, i)
+		w.entries[digest] = &entry{digest: digest, tree: c.Tree, parents: c.Parents, date: c.Date}
+	}
+	return nil
+}
+
+// Level and corrected commit date of a parent of a commit being written
+func (w *writer) generations(digest string) (uint64, uint64, error) {
+	if e, ok := w.entries[digest]; ok {
+		return e.level, e.corrected, nil
+	}
+	c, ok, err := w.base.Lookup(digest)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !ok {
+		return 0, 0, formatErrorCorruptedGraph(w.base.layers[len(w.base.layers)-1].path, "missing commit "+digest)
+	}
+	return c.level, c.Generation, nil
+}
+
+// Computes the levels and corrected commit dates, parents first
+func (w *writer) computeGenerations(sorted []*entry) error {
+	for _, e := range sorted {
+		stack := []*entry{e}
+		for len(stack) > 0 {
+			top := stack[len(stack)-1]
+			if top.level != 0 {
+				stack = stack[:len(stack)-1]
+				continue
+			}
+			pending := false
+			for _, parent := range top.parents {
+				if p, ok := w.entries[parent]; ok && p.level == 0 {
+					stack = append(stack, p)
+					pending = true
+				}
+			}
+			if pending {
+				continue
+			}
+			level, corrected := uint64(1), uint64(max(top.date, 0))
+			for _, parent := range top.parents {
+				parentLevel, parentCorrected, err := w.generations(parent)
+				if err != nil {
+					return err
+				}
+				level = max(level, parentLevel+1)
+				corrected = max(corrected, parentCorrected+1)
+			}
+			top.level, top.corrected = min(level, maxLevel), corrected
+			stack = stack[:len(stack)-1]
+		}
+	}
+	return nil
+}
+
+type chunk struct {
+	id   string
+	data []byte
+}
+
+// Content of the file holding the commits to write above the base layers
+func (w *writer) encode() ([]byte, error) {
+	sorted := make([]*entry, 0, len(w.entries))
+	for _, e := range w.entries {
+		sorted = append(sorted, e)
+	}
+	slices.SortFunc(sorted, func(a, b *entry) int {
+		return strings.Compare(a.digest, b.digest)
+	})
+	if err := w.computeGenerations(sorted); err != nil {
+		return nil, err
+	}
+	baseCount := 0
+	if w.base != nil {
+		baseCount = w.base.Len()
+	}
+	positions := make(map[string]int, len(sorted))
+	for i, e := range sorted {
+		positions[e.digest] = baseCount + i
+	}
+	position := func(digest string) uint32 {
+		if pos, ok := positions[digest]; ok {
+			return uint32(pos)
+		}
+		pos, _ := w.base.find(digest)
+		return uint32(pos)
+	}
+	var fanout, lookup, commitData, generations, overflow, edges bytes.Buffer
+	counts := make([]uint32, 256)
+	for _, e := range sorted {
+		raw, err := hex.DecodeString(e.digest)
+		if err != nil {
+			return nil, err
+		}
+		counts[raw[0]] += 1
+		lookup.Write(raw)
+		tree, err := hex.DecodeString(e.tree)
+		if err != nil {
+			return nil, err
+		}
+		commitData.Write(tree)
+		var parents []uint32
+		for _, parent := range e.parents {
+			parents = append(parents, position(parent))
+		}
+		first, second := uint32(parentNone), uint32(parentNone)
+		if len(parents) > 0 {
+			first = parents[0]
+		}
+		switch {
+		case len(parents) == 2:
+			second = parents[1]
+		case len(parents) > 2:
+			second = parentOctopus | uint32(edges.Len()/4)
+			for i, pos := range parents[1:] {
+				if i == len(parents)-2 {
+					pos |= parentOctopus
+				}
+				edges.Write(binary.BigEndian.AppendUint32(nil, pos))
+			}
+		}
+		b := binary.BigEndian.AppendUint32(nil, first)
+		b = binary.BigEndian.AppendUint32(b, second)
+		b = binary.BigEndian.AppendUint32(b, uint32(e.level<<2)|uint32(uint64(e.date)>>32&3))
+		b = binary.BigEndian.AppendUint32(b, uint32(e.date))
+		commitData.Write(b)
+		offset := e.corrected - uint64(max(e.date, 0))
+		if offset > maxOffset {
+			generations.Write(binary.BigEndian.AppendUint32(nil, offsetOverflow|uint32(overflow.Len()/8)))
+			overflow.Write(binary.BigEndian.AppendUint64(nil, offset))
+		} else {
+			generations.Write(binary.BigEndian.AppendUint32(nil, uint32(offset)))
+		}
+	}
+	total := uint32(0)
+	for _, count := range counts {
+		total += count
+		fanout.Write(binary.BigEndian.AppendUint32(nil, total))
+	}
+	chunks := []chunk{
+		{chunkFanout, fanout.Bytes()},
+		{chunkLookup, lookup.Bytes()},
+		{chunkCommitData, commitData.Bytes()},
+	}
+	// corrected commit dates are of no use unless every layer has them
+	if w.base == nil || w.base.generationData {
+		chunks = append(chunks, chunk{chunkGenerationData, generations.Bytes()})
+		if overflow.Len() > 0 {
+			chunks = append(chunks, chunk{chunkGenerationOverflow, overflow.Bytes()})
+		}
+	}
+	if edges.Len() > 0 {
+		chunks = append(chunks, chunk{chunkExtraEdges, edges.Bytes()})
+	}
+	numBase := 0
+	if w.base != nil {
+		var hashes bytes.Buffer
+		for _, l := range w.base.layers {
+			raw, err := hex.DecodeString(l.hash)
+			if err != nil {
+				return nil, err
+			}
+			hashes.Write(raw)
+		}
+		numBase = len(w.base.layers)
+		chunks = append(chunks, chunk{chunkBase, hashes.Bytes()})
+	}
+	var buf bytes.Buffer
+	buf.WriteString(signature)
+	buf.Write([]byte{version, hashVersion(), byte(len(chunks)), byte(numBase)})
+	offset := uint64(headerSize + (len(chunks)+1)*chunkEntrySize)
+	for _, c := range chunks {
+		buf.WriteString(c.id)
+		buf.Write(binary.BigEndian.AppendUint64(nil, offset))
+		offset += uint64(len(c.data))
+	}
+	buf.Write(make([]byte, 4))
+	buf.Write(binary.BigEndian.AppendUint64(nil, offset))
+	for _, c := range chunks {
+		buf.Write(c.data)
+	}
+	h := repr.NewHasher()
+	h.Write(buf.Bytes())
+	return h.Sum(buf.Bytes()), nil
+}
+
+// Atomically replaces the read-only file at path
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	tmp := path + ".lock"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0444)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Removes the layers of split graphs not among the ones kept, and the
+// chain itself when none are kept
+func removeChain(objectsDir string, kept []string) error {
+	if len(kept) == 0 {
+		if err := os.Remove(chainPath(objectsDir)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	layers, err := filepath.Glob(filepath.Join(chainDir(objectsDir), "graph-*.graph"))
+	if err != nil {
+		return err
+	}
+	for _, path := range layers {
+		hash := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "graph-"), ".graph")
+		if slices.Contains(kept, hash) {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/magnickolas/gitok/config/parser"
+	"github.com/magnickolas/gitok/constants"
+)
+
+// Merged view of the global and repository config files, later files
+// overriding earlier ones
+type Config struct {
+	kvs []parser.KeyValue
+}
+
+// Loads the user's global config followed by the repository config,
+// missing files are skipped
+func Load() (*Config, error) {
+	var paths []string
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		paths = append(paths, filepath.Join(xdg, "git", "config"))
+	} else if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".config", "git", "config"))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".gitconfig"))
+	}
+	paths = append(paths, filepath.Join(constants.Git, constants.Config))
+	return LoadFiles(paths...)
+}
+
+func LoadFiles(paths ...string) (*Config, error) {
+	c := new(Config)
+	for _, path := range paths {
+		r, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		p, err := parser.NewParser(r)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		kvs, err := p.Parse()
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", path, err)
+		}
+		c.kvs = append(c.kvs, kvs...)
+	}
+	return c, nil
+}
+
+// Last value of the key
+func (c *Config) Get(key string) (string, bool) {
+	values := c.GetAll(key)
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[len(values)-1], true
+}
+
+// All values of a multi-valued key in the order of appearance
+func (c *Config) GetAll(key string) []string {
+	key = normalizeKey(key)
+	var values []string
+	for _, kv := range c.kvs {
+		if normalizeKey(kv.Key) == key {
+			values = append(values, kv.Value)
+		}
+	}
+	return values
+}
+
+func (c *Config) GetBool(key string, def bool) (bool, error) {
+	value, ok := c.Get(key)
+	if !ok {
+		return def, nil
+	}
+	switch strings.ToLower(value) {
+	case "", "true", "yes", "on", "1":
+		return true, nil
+	case "false", "no", "off", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("bad boolean config value '%s' for '%s'", value, key)
+}
+
+// Integer value with an optional k/m/g unit suffix
+func (c *Config) GetInt(key string, def int64) (int64, error) {
+	value, ok := c.Get(key)
+	if !ok {
+		return def, nil
+	}
+	res, err := ParseInt(value)
+	if err != nil {
+		return 0, fmt.Errorf("bad numeric config value '%s' for '%s'", value, key)
+	}
+	return res, nil
+}
+
+func ParseInt(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	factor := int64(1)
+	if len(value) > 0 {
+		switch value[len(value)-1] {
+		case 'k', 'K':
+			factor = 1 << 10
+		case 'm', 'M':
+			factor = 1 << 20
+		case 'g', 'G':
+			factor = 1 << 30
+		}
+		if factor != 1 {
+			value = value[:len(value)-1]
+		}
+	}
+	res, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return res * factor, nil
+}
+
+// Section and variable names are case-insensitive, subsections are not
+func normalizeKey(key string) string {
+	first, last := strings.Index(key, "."), strings.LastIndex(key, ".")
+	if first == -1 {
+		return strings.ToLower(key)
+	}
+	return strings.ToLower(key[:first]) + key[first:last] + strings.ToLower(key[last:])
+}
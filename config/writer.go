@@ -0,0 +1,110 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/magnickolas/gitok/constants"
+)
+
+// Sets the key in the repository config
+func Set(key string, value string) error {
+	return SetInFile(filepath.Join(constants.Git, constants.Config), key, value)
+}
+
+// Sets the key in the given config file, replacing its last value or adding
+// it at the end of the last matching section, which is created if needed
+func SetInFile(path string, key string, value string) error {
+	section, subsection, name, err := splitKey(key)
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	var lines []string
+	if len(b) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	}
+	line := "\t" + name + " = " + quoteValue(value)
+	inSection := false
+	sectionEnd, keyLine := -1, -1
+	for i, l := range lines {
+		trimmed := strings.TrimSpace(l)
+		if strings.HasPrefix(trimmed, "[") {
+			inSection = isSectionHeader(trimmed, section, subsection)
+			if inSection {
+				sectionEnd = i
+			}
+			continue
+		}
+		if !inSection {
+			continue
+		}
+		if trimmed != "" && trimmed[0] != '#' && trimmed[0] != ';' {
+			sectionEnd = i
+		}
+		k, _, _ := strings.Cut(trimmed, "=")
+		if strings.EqualFold(strings.TrimSpace(k), name) {
+			keyLine = i
+		}
+	}
+	switch {
+	case keyLine != -1:
+		lines[keyLine] = line
+	case sectionEnd != -1:
+		lines = append(lines[:sectionEnd+1], append([]string{line}, lines[sectionEnd+1:]...)...)
+	default:
+		header := "[" + section + "]"
+		if subsection != "" {
+			header = "[" + section + " \"" + escapeSubsectionName(subsection) + "\"]"
+		}
+		lines = append(lines, header, line)
+	}
+	return os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+// Splits "section[.subsection].name", lowercasing the case-insensitive parts
+func splitKey(key string) (string, string, string, error) {
+	first, last := strings.Index(key, "."), strings.LastIndex(key, ".")
+	if first == -1 || last == len(key)-1 {
+		return "", "", "", errors.New("key does not contain a section: " + key)
+	}
+	section, name := strings.ToLower(key[:first]), strings.ToLower(key[last+1:])
+	subsection := ""
+	if first != last {
+		subsection = key[first+1 : last]
+	}
+	return section, subsection, name, nil
+}
+
+func isSectionHeader(line string, section string, subsection string) bool {
+	if !strings.HasPrefix(line, "[") || !strings.HasSuffix(line, "]") {
+		return false
+	}
+	line = line[1 : len(line)-1]
+	name, rest, found := strings.Cut(line, " ")
+	if !found {
+		// [section] or the old [section.subsection] syntax
+		name, sub, _ := strings.Cut(line, ".")
+		return strings.EqualFold(name, section) && strings.EqualFold(sub, subsection)
+	}
+	rest = strings.TrimSpace(rest)
+	return strings.EqualFold(name, section) &&
+		rest == "\""+escapeSubsectionName(subsection)+"\""
+}
+
+func escapeSubsectionName(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
+
+func quoteValue(value string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`).Replace(value)
+	if value != strings.TrimSpace(value) || strings.ContainsAny(value, ";#") {
+		return `"` + escaped + `"`
+	}
+	return escaped
+}
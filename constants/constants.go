@@ -4,5 +4,15 @@ const Git = ".git"
 const Head = "HEAD"
 const Objects = "objects"
 const Refs = "refs"
+const Config = "config"
+const Index = "index"
+const Logs = "logs"
+const PackedRefs = "packed-refs"
+
+// State of a merge waiting to be committed
+const MergeHead = "MERGE_HEAD"
+const MergeMsg = "MERGE_MSG"
+const MergeMode = "MERGE_MODE"
+const SquashMsg = "SQUASH_MSG"
 
 const RefFormat = "ref: refs/heads/%v"
@@ -0,0 +1,196 @@
+package convert
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/magnickolas/gitok/attr"
+	"github.com/magnickolas/gitok/config"
+)
+
+// Number of leading bytes inspected to tell binary content from text
+const binarySniffLen = 8000
+
+type eolAction int
+
+const (
+	eolNone eolAction = iota
+	// convert when the content looks like text
+	eolAuto
+	eolText
+)
+
+// Applies the content filters git runs when moving files between the
+// worktree and the repository: filter.<driver>.clean/smudge commands and
+// end-of-line conversion driven by the text, eol and crlf attributes and
+// the core.autocrlf and core.eol settings
+type Converter struct {
+	cfg      *config.Config
+	attrs    *attr.Matcher
+	autocrlf string
+	eol      string
+}
+
+func NewConverter(cfg *config.Config) (*Converter, error) {
+	attrs, err := attr.NewMatcher(cfg)
+	if err != nil {
+		return nil, err
+	}
+	c := &Converter{cfg: cfg, attrs: attrs, autocrlf: "false", eol: "lf"}
+	if value, ok := cfg.Get("core.autocrlf"); ok {
+		switch strings.ToLower(value) {
+		case "input":
+			c.autocrlf = "input"
+		case "", "true", "yes", "on", "1":
+			c.autocrlf = "true"
+		}
+	}
+	if value, ok := cfg.Get("core.eol"); ok && strings.ToLower(value) == "crlf" {
+		c.eol = "crlf"
+	}
+	return c, nil
+}
+
+// Converts worktree content of the path to its repository form
+func (c *Converter) ToGit(path string, content []byte) ([]byte, error) {
+	attrs, err := c.attrs.GetAll(path)
+	if err != nil {
+		return nil, err
+	}
+	content, err = c.runDriver(attrs, "clean", path, content)
+	if err != nil {
+		return nil, err
+	}
+	action, _ := c.eolFor(attrs)
+	if action == eolNone || !bytes.Contains(content, []byte("\r\n")) {
+		return content, nil
+	}
+	if action == eolAuto && (isBinary(content) || hasLoneCR(content)) {
+		return content, nil
+	}
+	return bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n")), nil
+}
+
+// Whether ToGit may change content of the path, in which case the content
+// has to be read into memory instead of being streamed
+func (c *Converter) WouldConvertToGit(path string) (bool, error) {
+	attrs, err := c.attrs.GetAll(path)
+	if err != nil {
+		return false, err
+	}
+	if filter, ok := attrs["filter"]; ok && filter.State == attr.Valued {
+		if command, ok := c.cfg.Get(fmt.Sprintf("filter.%s.clean", filter.Value)); ok && command != "" {
+			return true, nil
+		}
+	}
+	action, _ := c.eolFor(attrs)
+	return action != eolNone, nil
+}
+
+// Converts repository content of the path to its worktree form
+func (c *Converter) ToWorktree(path string, content []byte) ([]byte, error) {
+	attrs, err := c.attrs.GetAll(path)
+	if err != nil {
+		return nil, err
+	}
+	action, crlf := c.eolFor(attrs)
+	convert := action != eolNone && crlf && bytes.IndexByte(content, '\n') != -1
+	if convert && action == eolAuto && (isBinary(content) || bytes.IndexByte(content, '\r') != -1) {
+		convert = false
+	}
+	if convert {
+		content = bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+		content = bytes.ReplaceAll(content, []byte("\n"), []byte("\r\n"))
+	}
+	return c.runDriver(attrs, "smudge", path, content)
+}
+
+// Decides whether end-of-line conversion applies and whether the worktree
+// uses CRLF line endings
+func (c *Converter) eolFor(attrs map[string]attr.Value) (eolAction, bool) {
+	text, ok := attrs["text"]
+	eol := attrs["eol"]
+	if !ok {
+		// legacy spelling of the text attribute
+		if crlf, ok := attrs["crlf"]; ok {
+			text = crlf
+			if crlf.State == attr.Valued && crlf.Value == "input" {
+				text = attr.Value{State: attr.Set}
+				eol = attr.Value{State: attr.Valued, Value: "lf"}
+			}
+		}
+	}
+	action := eolNone
+	switch {
+	case text.IsUnset():
+		return eolNone, false
+	case text.IsSet():
+		action = eolText
+	case text.State == attr.Valued && text.Value == "auto":
+		action = eolAuto
+	case eol.State == attr.Valued:
+		action = eolText
+	case c.autocrlf != "false":
+		action = eolAuto
+	}
+	switch {
+	case eol.State == attr.Valued:
+		return action, eol.Value == "crlf"
+	case c.autocrlf == "true":
+		return action, true
+	case c.autocrlf == "input":
+		return action, false
+	}
+	return action, c.eol == "crlf"
+}
+
+func (c *Converter) runDriver(attrs map[string]attr.Value, kind string, path string, content []byte) ([]byte, error) {
+	filter, ok := attrs["filter"]
+	if !ok || filter.State != attr.Valued {
+		return content, nil
+	}
+	command, ok := c.cfg.Get(fmt.Sprintf("filter.%s.%s", filter.Value, kind))
+	required, err := c.cfg.GetBool(fmt.Sprintf("filter.%s.required", filter.Value), false)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || command == "" {
+		if required {
+			return nil, fmt.Errorf("%v: %v filter '%v' is required but not configured", path, kind, filter.Value)
+		}
+		return content, nil
+	}
+	command = strings.ReplaceAll(command, "%f", shellQuote(path))
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(content)
+	out, err := cmd.Output()
+	if err != nil {
+		if required {
+			return nil, fmt.Errorf("%v: %v filter '%v' failed: %w", path, kind, filter.Value, err)
+		}
+		return content, nil
+	}
+	return out, nil
+}
+
+func isBinary(content []byte) bool {
+	if len(content) > binarySniffLen {
+		content = content[:binarySniffLen]
+	}
+	return bytes.IndexByte(content, 0) != -1
+}
+
+func hasLoneCR(content []byte) bool {
+	for i, c := range content {
+		if c == '\r' && (i+1 == len(content) || content[i+1] != '\n') {
+			return true
+		}
+	}
+	return false
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
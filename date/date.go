@@ -0,0 +1,134 @@
+package date
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/magnickolas/gitok/repr"
+)
+
+var ErrorInvalidDate = errors.New("invalid date format")
+
+var zonedLayouts = []string{
+	// RFC 2822
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04 -0700",
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04 -0700",
+	"Mon Jan 2 15:04:05 2006 -0700",
+	// ISO 8601
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05 -07:00",
+	"2006-01-02T15:04:05 -0700",
+	"2006.01.02 15:04:05 -0700",
+}
+
+var localLayouts = []string{
+	"Mon, 2 Jan 2006 15:04:05",
+	"2 Jan 2006 15:04:05",
+	"Mon Jan 2 15:04:05 2006",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006.01.02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006.01.02",
+}
+
+// Parses a date in one of the formats git accepts in GIT_AUTHOR_DATE and
+// GIT_COMMITTER_DATE: the internal "<epoch> <tz>" form (optionally prefixed
+// with '@'), RFC 2822 and ISO 8601
+func Parse(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, ok := parseRaw(s); ok {
+		return t, nil
+	}
+	for _, layout := range zonedLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	for _, layout := range localLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: %v", ErrorInvalidDate, s)
+}
+
+func parseRaw(s string) (time.Time, bool) {
+	explicit := strings.HasPrefix(s, "@")
+	s = strings.TrimPrefix(s, "@")
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return time.Time{}, false
+	}
+	// like git, a bare number is only taken for an epoch when it is large
+	// enough not to be mistaken for a part of a date
+	if !explicit && len(fields) == 1 && len(fields[0]) < 9 {
+		return time.Time{}, false
+	}
+	ts, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	loc := time.UTC
+	if len(fields) == 2 {
+		loc, err = repr.ParseTimezone(fields[1])
+		if err != nil {
+			return time.Time{}, false
+		}
+	}
+	return time.Unix(ts, 0).In(loc), true
+}
+
+// Parses a date given to --since and --until: besides the formats of Parse,
+// "now", "yesterday" and relative dates such as "2 weeks ago" or
+// "1.year.3.months.ago" are accepted, relative to now
+func Approx(s string, now time.Time) (time.Time, error) {
+	if t, err := Parse(s); err == nil {
+		return t, nil
+	}
+	fields := strings.Fields(strings.ReplaceAll(strings.ToLower(s), ".", " "))
+	switch {
+	case len(fields) == 1 && fields[0] == "now":
+		return now, nil
+	case len(fields) == 1 && fields[0] == "yesterday":
+		return now.AddDate(0, 0, -1), nil
+	case len(fields) < 3 || len(fields)%2 == 0 || fields[len(fields)-1] != "ago":
+		return time.Time{}, fmt.Errorf("%w: %v", ErrorInvalidDate, s)
+	}
+	t := now
+	for i := 0; i+1 < len(fields); i += 2 {
+		n, err := strconv.Atoi(fields[i])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%w: %v", ErrorInvalidDate, s)
+		}
+		switch strings.TrimSuffix(fields[i+1], "s") {
+		case "second":
+			t = t.Add(-time.Duration(n) * time.Second)
+		case "minute":
+			t = t.Add(-time.Duration(n) * time.Minute)
+		case "hour":
+			t = t.Add(-time.Duration(n) * time.Hour)
+		case "day":
+			t = t.AddDate(0, 0, -n)
+		case "week":
+			t = t.AddDate(0, 0, -7*n)
+		case "month":
+			t = t.AddDate(0, -n, 0)
+		case "year":
+			t = t.AddDate(-n, 0, 0)
+		default:
+			return time.Time{}, fmt.Errorf("%w: %v", ErrorInvalidDate, s)
+		}
+	}
+	return t, nil
+}
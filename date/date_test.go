@@ -0,0 +1,78 @@
+package date_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/magnickolas/gitok/date"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{input: "@1700000000", want: "1700000000 +0000"},
+		{input: "@1700000000 +0130", want: "1700000000 +0130"},
+		{input: "1700000000 -0500", want: "1700000000 -0500"},
+		{input: "Tue, 14 Nov 2023 22:13:20 +0100", want: "1699996400 +0100"},
+		{input: "14 Nov 2023 22:13:20 +0000", want: "1700000000 +0000"},
+		{input: "2023-11-14T22:13:20+01:00", want: "1699996400 +0100"},
+		{input: "2023-11-14T22:13:20Z", want: "1700000000 +0000"},
+		{input: "2023-11-14 22:13:20 -0500", want: "1700018000 -0500"},
+		{input: "yesterday-ish", wantErr: true},
+		{input: "2023-13-45", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := date.Parse(test.input)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("wanted error for %#v", test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("failed to parse %#v: %v", test.input, err)
+			continue
+		}
+		if s := fmt.Sprintf("%d %s", got.Unix(), got.Format("-0700")); s != test.want {
+			t.Errorf("incorrect result for %#v: wanted %#v, got %#v", test.input, test.want, s)
+		}
+	}
+}
+
+func TestApprox(t *testing.T) {
+	now := time.Unix(1700000000, 0).UTC()
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{input: "now", want: 1700000000},
+		{input: "yesterday", want: 1700000000 - 86400},
+		{input: "2 weeks ago", want: 1700000000 - 14*86400},
+		{input: "3.hours.ago", want: 1700000000 - 3*3600},
+		{input: "1 day 1 minute ago", want: 1700000000 - 86400 - 60},
+		{input: "@1600000000", want: 1600000000},
+		{input: "2 weeks", wantErr: true},
+		{input: "2 fortnights ago", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := date.Approx(test.input, now)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("wanted error for %#v", test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("failed to parse %#v: %v", test.input, err)
+			continue
+		}
+		if got.Unix() != test.want {
+			t.Errorf("incorrect result for %#v: wanted %v, got %v", test.input, test.want, got.Unix())
+		}
+	}
+}
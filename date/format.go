@@ -0,0 +1,225 @@
+package date
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Mode int
+
+const (
+	ModeDefault Mode = iota
+	ModeRelative
+	ModeISO
+	ModeISOStrict
+	ModeRFC
+	ModeShort
+	ModeRaw
+	ModeUnix
+	ModeStrftime
+)
+
+// How dates are shown, as selected by --date
+type Format struct {
+	Mode Mode
+	// show the date in the local time zone instead of the original one
+	Local bool
+	// strftime(3) layout for ModeStrftime
+	Layout string
+}
+
+var modeNames = map[string]Mode{
+	"default":        ModeDefault,
+	"relative":       ModeRelative,
+	"iso":            ModeISO,
+	"iso8601":        ModeISO,
+	"iso-strict":     ModeISOStrict,
+	"iso8601-strict": ModeISOStrict,
+	"rfc":            ModeRFC,
+	"rfc2822":        ModeRFC,
+	"short":          ModeShort,
+	"raw":            ModeRaw,
+	"unix":           ModeUnix,
+}
+
+// Parses the value of --date: a mode name, optionally suffixed with
+// "-local", "local" or "format:<strftime layout>"
+func ParseFormat(s string) (Format, error) {
+	if layout, found := strings.CutPrefix(s, "format-local:"); found {
+		return Format{Mode: ModeStrftime, Local: true, Layout: layout}, nil
+	}
+	if layout, found := strings.CutPrefix(s, "format:"); found {
+		return Format{Mode: ModeStrftime, Layout: layout}, nil
+	}
+	if s == "local" {
+		return Format{Mode: ModeDefault, Local: true}, nil
+	}
+	name, local := strings.CutSuffix(s, "-local")
+	mode, ok := modeNames[name]
+	if !ok {
+		return Format{}, fmt.Errorf("unknown date format %v", s)
+	}
+	return Format{Mode: mode, Local: local}, nil
+}
+
+// Shows the time in the format; now is the reference for relative dates
+func (f Format) Format(t time.Time, now time.Time) string {
+	if f.Local {
+		t = t.In(time.Local)
+	}
+	switch f.Mode {
+	case ModeRelative:
+		return relative(t, now)
+	case ModeISO:
+		return t.Format("2006-01-02 15:04:05 -0700")
+	case ModeISOStrict:
+		return t.Format("2006-01-02T15:04:05-07:00")
+	case ModeRFC:
+		return t.Format("Mon, 2 Jan 2006 15:04:05 -0700")
+	case ModeShort:
+		return t.Format("2006-01-02")
+	case ModeRaw:
+		return fmt.Sprintf("%d %v", t.Unix(), t.Format("-0700"))
+	case ModeUnix:
+		return strconv.FormatInt(t.Unix(), 10)
+	case ModeStrftime:
+		return strftime(f.Layout, t)
+	}
+	if f.Local {
+		return t.Format("Mon Jan 2 15:04:05 2006")
+	}
+	return t.Format("Mon Jan 2 15:04:05 2006 -0700")
+}
+
+func plural(n int64, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %v", n, unit)
+	}
+	return fmt.Sprintf("%d %vs", n, unit)
+}
+
+// "N units ago", rounded the way git does
+func relative(t time.Time, now time.Time) string {
+	diff := now.Unix() - t.Unix()
+	if diff < 0 {
+		return "in the future"
+	}
+	if diff < 90 {
+		return plural(diff, "second") + " ago"
+	}
+	diff = (diff + 30) / 60
+	if diff < 90 {
+		return plural(diff, "minute") + " ago"
+	}
+	diff = (diff + 30) / 60
+	if diff < 36 {
+		return plural(diff, "hour") + " ago"
+	}
+	diff = (diff + 12) / 24
+	if diff < 14 {
+		return plural(diff, "day") + " ago"
+	}
+	if diff < 70 {
+		return plural((diff+3)/7, "week") + " ago"
+	}
+	if diff < 365 {
+		return plural((diff+15)/30, "month") + " ago"
+	}
+	if diff < 1825 {
+		years := diff / 365
+		months := (diff%365 + 15) / 30
+		if months != 0 {
+			return plural(years, "year") + ", " + plural(months, "month") + " ago"
+		}
+		return plural(years, "year") + " ago"
+	}
+	return plural((diff+183)/365, "year") + " ago"
+}
+
+var strftimeLayouts = map[byte]string{
+	'a': "Mon",
+	'A': "Monday",
+	'b': "Jan",
+	'h': "Jan",
+	'B': "January",
+	'c': "Mon Jan _2 15:04:05 2006",
+	'd': "02",
+	'D': "01/02/06",
+	'F': "2006-01-02",
+	'H': "15",
+	'I': "03",
+	'm': "01",
+	'M': "04",
+	'p': "PM",
+	'r': "03:04:05 PM",
+	'R': "15:04",
+	'S': "05",
+	'T': "15:04:05",
+	'x': "01/02/06",
+	'X': "15:04:05",
+	'y': "06",
+	'Y': "2006",
+	'z': "-0700",
+}
+
+// Formats the time with the conversions of strftime(3) git passes through
+func strftime(layout string, t time.Time) string {
+	var b strings.Builder
+	for i := 0; i < len(layout); i += 1 {
+		if layout[i] != '%' || i+1 == len(layout) {
+			b.WriteByte(layout[i])
+			continue
+		}
+		i += 1
+		c := layout[i]
+		if goLayout, ok := strftimeLayouts[c]; ok {
+			b.WriteString(t.Format(goLayout))
+			continue
+		}
+		switch c {
+		case '%':
+			b.WriteByte('%')
+		case 'e':
+			fmt.Fprintf(&b, "%2d", t.Day())
+		case 'k':
+			fmt.Fprintf(&b, "%2d", t.Hour())
+		case 'l':
+			fmt.Fprintf(&b, "%2d", (t.Hour()+11)%12+1)
+		case 'j':
+			fmt.Fprintf(&b, "%03d", t.YearDay())
+		case 'C':
+			fmt.Fprintf(&b, "%02d", t.Year()/100)
+		case 'u':
+			fmt.Fprintf(&b, "%d", (int(t.Weekday())+6)%7+1)
+		case 'w':
+			fmt.Fprintf(&b, "%d", t.Weekday())
+		case 'U':
+			fmt.Fprintf(&b, "%02d", (t.YearDay()+6-int(t.Weekday()))/7)
+		case 'W':
+			fmt.Fprintf(&b, "%02d", (t.YearDay()+6-(int(t.Weekday())+6)%7)/7)
+		case 'G', 'V':
+			year, week := t.ISOWeek()
+			if c == 'G' {
+				fmt.Fprintf(&b, "%d", year)
+			} else {
+				fmt.Fprintf(&b, "%02d", week)
+			}
+		case 's':
+			fmt.Fprintf(&b, "%d", t.Unix())
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'Z':
+			// git has no time zone names for commit dates
+		case 'P':
+			b.WriteString(strings.ToLower(t.Format("PM")))
+		default:
+			b.WriteByte('%')
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,46 @@
+package diff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+
+	"github.com/magnickolas/gitok/base85"
+)
+
+// Bytes of data per line of binary patches
+const binaryLineLen = 52
+
+// Writes a binary patch turning the old content into the new one and the
+// new into the old, for the patch to apply both ways. The data is always
+// given whole, never as a delta against the other side
+func writeBinaryPatch(e *emitter, oldContent []byte, newContent []byte) {
+	var b strings.Builder
+	b.WriteString("GIT binary patch\n")
+	writeBinaryHunk(&b, newContent)
+	writeBinaryHunk(&b, oldContent)
+	e.emit(symbol{kind: symbolPlain, text: b.String()})
+}
+
+func writeBinaryHunk(b *strings.Builder, content []byte) {
+	var deflated bytes.Buffer
+	// at the level git uses for binary patches
+	zw, _ := zlib.NewWriterLevel(&deflated, zlib.BestSpeed)
+	zw.Write(content)
+	zw.Close()
+	fmt.Fprintf(b, "literal %d\n", len(content))
+	for data := deflated.Bytes(); len(data) > 0; {
+		n := min(len(data), binaryLineLen)
+		// the length of the line's data as a letter
+		if n <= 26 {
+			b.WriteByte(byte('A' + n - 1))
+		} else {
+			b.WriteByte(byte('a' + n - 27))
+		}
+		b.WriteString(base85.Encode(data[:n]))
+		b.WriteString("\n")
+		data = data[n:]
+	}
+	b.WriteString("\n")
+}
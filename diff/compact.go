@@ -0,0 +1,275 @@
+package diff
+
+// Indentation above which lines count as this indented
+const maxIndent = 200
+
+// Blank lines around a split counted at most
+const maxBlanks = 20
+
+// How far a group of changes is slid at most by the indent heuristic
+const maxSliding = 100
+
+// Weights of the indent heuristic, as found by git on a corpus of diffs:
+// the larger, the worse a place to split
+const (
+	startOfFilePenalty              = 1
+	endOfFilePenalty                = 21
+	totalBlankWeight                = -30
+	postBlankWeight                 = 6
+	relativeIndentPenalty           = -4
+	relativeIndentWithBlankPenalty  = 10
+	relativeOutdentPenalty          = 24
+	relativeOutdentWithBlankPenalty = 17
+	relativeDedentPenalty           = 23
+	relativeDedentWithBlankPenalty  = 17
+	indentWeight                    = 60
+)
+
+// Group of consecutive changed lines of a side: lines start to end,
+// exclusive, possibly none
+type group struct {
+	start, end int
+}
+
+func firstGroup(s *side) group {
+	g := group{}
+	for s.isChanged(g.end) {
+		g.end += 1
+	}
+	return g
+}
+
+func (g *group) next(s *side) bool {
+	if g.end == len(s.ids) {
+		return false
+	}
+	g.start = g.end + 1
+	g.end = g.start
+	for s.isChanged(g.end) {
+		g.end += 1
+	}
+	return true
+}
+
+func (g *group) previous(s *side) bool {
+	if g.start == 0 {
+		return false
+	}
+	g.end = g.start - 1
+	g.start = g.end
+	for s.isChanged(g.start - 1) {
+		g.start -= 1
+	}
+	return true
+}
+
+// Slides the group a line down if the line after it is the same as its
+// first, joining the group that follows
+func (g *group) slideDown(s *side) bool {
+	if g.end >= len(s.ids) || s.ids[g.start] != s.ids[g.end] {
+		return false
+	}
+	s.changed[g.start] = false
+	s.changed[g.end] = true
+	g.start, g.end = g.start+1, g.end+1
+	for s.isChanged(g.end) {
+		g.end += 1
+	}
+	return true
+}
+
+// Slides the group a line up if the line before it is the same as its
+// last, joining the group that precedes
+func (g *group) slideUp(s *side) bool {
+	if g.start == 0 || s.ids[g.start-1] != s.ids[g.end-1] {
+		return false
+	}
+	g.start, g.end = g.start-1, g.end-1
+	s.changed[g.start] = true
+	s.changed[g.end] = false
+	for s.isChanged(g.start - 1) {
+		g.start -= 1
+	}
+	return true
+}
+
+// Slides the groups of changes of s, joining the ones that touch, to line
+// up with changes of the other side where they can, otherwise to the
+// place the indent heuristic finds best or as far down as they go
+func (d *differ) compact(s *side, other *side) {
+	g, o := firstGroup(s), firstGroup(other)
+	for {
+		if g.end != g.start {
+			var earliestEnd int
+			// the last end lining up with changes of the other side
+			endMatchingOther := -1
+			for {
+				size := g.end - g.start
+				endMatchingOther = -1
+				for g.slideUp(s) {
+					o.previous(other)
+				}
+				earliestEnd = g.end
+				if o.end > o.start {
+					endMatchingOther = g.end
+				}
+				for g.slideDown(s) {
+					o.next(other)
+					if o.end > o.start {
+						endMatchingOther = g.end
+					}
+				}
+				if size == g.end-g.start {
+					break
+				}
+			}
+			switch {
+			case g.end == earliestEnd:
+			case endMatchingOther != -1:
+				for o.end == o.start {
+					g.slideUp(s)
+					o.previous(other)
+				}
+			case d.indentHeuristic:
+				size := g.end - g.start
+				shift := max(earliestEnd, g.end-size-1, g.end-maxSliding)
+				bestShift := -1
+				var best splitScore
+				for ; shift <= g.end; shift += 1 {
+					var score splitScore
+					score.add(measureSplit(s, shift))
+					score.add(measureSplit(s, shift-size))
+					if bestShift == -1 || score.cmp(best) <= 0 {
+						best, bestShift = score, shift
+					}
+				}
+				for g.end > bestShift {
+					g.slideUp(s)
+					o.previous(other)
+				}
+			}
+		}
+		if !g.next(s) {
+			break
+		}
+		o.next(other)
+	}
+}
+
+// Indentation of the line with tabs to multiples of 8, -1 if it is blank
+func indent(line string) int {
+	res := 0
+	for i := 0; i < len(line); i += 1 {
+		c := line[i]
+		switch {
+		case !isSpace(c):
+			return res
+		case c == ' ':
+			res += 1
+		case c == '\t':
+			res += 8 - res%8
+		}
+		if res >= maxIndent {
+			return maxIndent
+		}
+	}
+	return -1
+}
+
+// What surrounds a split of the lines above a line
+type splitMeasurement struct {
+	endOfFile bool
+	// of the line after the split
+	indent int
+	// blank lines right above the split and the indentation of the line
+	// above them, -1 if none
+	preBlank, preIndent int
+	// blank lines right after the line after the split and the indentation
+	// of the line after them, -1 if none
+	postBlank, postIndent int
+}
+
+func measureSplit(s *side, split int) splitMeasurement {
+	m := splitMeasurement{indent: -1, preIndent: -1, postIndent: -1}
+	if split >= len(s.lines) {
+		m.endOfFile = true
+	} else {
+		m.indent = indent(s.lines[split])
+	}
+	for i := split - 1; i >= 0; i -= 1 {
+		if m.preIndent = indent(s.lines[i]); m.preIndent != -1 {
+			break
+		}
+		m.preBlank += 1
+		if m.preBlank == maxBlanks {
+			m.preIndent = 0
+			break
+		}
+	}
+	for i := split + 1; i < len(s.lines); i += 1 {
+		if m.postIndent = indent(s.lines[i]); m.postIndent != -1 {
+			break
+		}
+		m.postBlank += 1
+		if m.postBlank == maxBlanks {
+			m.postIndent = 0
+			break
+		}
+	}
+	return m
+}
+
+// Badness of the splits around a group of changes, smaller is better
+type splitScore struct {
+	effectiveIndent int
+	penalty         int
+}
+
+func (score *splitScore) add(m splitMeasurement) {
+	if m.preIndent == -1 && m.preBlank == 0 {
+		score.penalty += startOfFilePenalty
+	}
+	if m.endOfFile {
+		score.penalty += endOfFilePenalty
+	}
+	postBlank := 0
+	if m.indent == -1 {
+		postBlank = 1 + m.postBlank
+	}
+	totalBlank := m.preBlank + postBlank
+	score.penalty += totalBlankWeight*totalBlank + postBlankWeight*postBlank
+	indent := m.indent
+	if indent == -1 {
+		indent = m.postIndent
+	}
+	anyBlanks := totalBlank != 0
+	score.effectiveIndent += indent
+	pick := func(withBlank int, without int) int {
+		if anyBlanks {
+			return withBlank
+		}
+		return without
+	}
+	switch {
+	case indent == -1 || m.preIndent == -1 || indent == m.preIndent:
+	case indent > m.preIndent:
+		score.penalty += pick(relativeIndentWithBlankPenalty, relativeIndentPenalty)
+	case m.postIndent != -1 && m.postIndent > indent:
+		// likely the start of a block
+		score.penalty += pick(relativeOutdentWithBlankPenalty, relativeOutdentPenalty)
+	default:
+		// likely the end of a block
+		score.penalty += pick(relativeDedentWithBlankPenalty, relativeDedentPenalty)
+	}
+}
+
+func (score splitScore) cmp(other splitScore) int {
+	indents := 0
+	switch {
+	case score.effectiveIndent > other.effectiveIndent:
+		indents = 1
+	case score.effectiveIndent < other.effectiveIndent:
+		indents = -1
+	}
+	return indentWeight*indents + score.penalty - other.penalty
+}
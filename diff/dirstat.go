@@ -0,0 +1,209 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/magnickolas/gitok/fs"
+)
+
+var (
+	ErrorDirStatParam       = errors.New("unknown dirstat parameter")
+	formatErrorDirStatParam = func(param string) error {
+		return fmt.Errorf("%w '%v'", ErrorDirStatParam, param)
+	}
+	ErrorDirStatCutoff       = errors.New("failed to parse dirstat cut-off percentage")
+	formatErrorDirStatCutoff = func(param string) error {
+		return fmt.Errorf("%w '%v'", ErrorDirStatCutoff, param)
+	}
+)
+
+// What the changes of a file are measured by in the dirstat
+type DirStatBasis int
+
+const (
+	// bytes removed from and added to the file
+	DirStatChanges DirStatBasis = iota
+	// lines added and deleted, as in the diffstat
+	DirStatLines
+	// changed files, each counting the same
+	DirStatFiles
+)
+
+// How --dirstat shares the changes among directories
+type DirStat struct {
+	By DirStatBasis
+	// least share of the changes of directories shown, in tenths of a percent
+	Permille int
+	// changes in subdirectories also count for their parents even if shown
+	Cumulative bool
+}
+
+// Directories with less than 3% of the changes are not shown by default
+var DefaultDirStat = DirStat{Permille: 30}
+
+// Parses the comma-separated parameters of --dirstat on top of the given
+// ones: changes, lines or files, cumulative or noncumulative, and a
+// cut-off percentage of at most one decimal
+func ParseDirStat(params string, base DirStat) (DirStat, error) {
+	res := base
+	for _, param := range strings.Split(params, ",") {
+		switch param {
+		case "":
+		case "changes":
+			res.By = DirStatChanges
+		case "lines":
+			res.By = DirStatLines
+		case "files":
+			res.By = DirStatFiles
+		case "cumulative":
+			res.Cumulative = true
+		case "noncumulative":
+			res.Cumulative = false
+		default:
+			if param[0] < '0' || param[0] > '9' {
+				return DirStat{}, formatErrorDirStatParam(param)
+			}
+			whole, fraction, _ := strings.Cut(param, ".")
+			n, err := strconv.Atoi(whole)
+			if err != nil {
+				return DirStat{}, formatErrorDirStatCutoff(param)
+			}
+			permille := n * 10
+			// only the first decimal counts
+			if fraction != "" {
+				if strings.Trim(fraction, "0123456789") != "" {
+					return DirStat{}, formatErrorDirStatCutoff(param)
+				}
+				permille += int(fraction[0] - '0')
+			}
+			res.Permille = permille
+		}
+	}
+	return res, nil
+}
+
+type dirStatFile struct {
+	path    string
+	changed int
+}
+
+// Writes the share of the changes of each directory with at least the
+// cut-off of them (--dirstat); directories whose changes all come from a
+// single subdirectory are left out. Stats are those of the changes, for
+// measuring by lines
+func writeDirStat(w io.Writer, db *fs.ObjectDB, changes []Change, stats []fileStat, opts Options) error {
+	var files []dirStatFile
+	total := 0
+	if opts.DirStat.By == DirStatLines {
+		for _, stat := range stats {
+			damage := stat.added + stat.deleted
+			// bytes of binary files count as lines of 64 bytes
+			if stat.binary {
+				damage = (damage + 63) / 64
+			}
+			files = append(files, dirStatFile{path: stat.path, changed: damage})
+			total += damage
+		}
+	} else {
+		for _, change := range changes {
+			if change.Status == Unmerged {
+				continue
+			}
+			damage, err := changeDamage(db, &change, opts.DirStat.By)
+			if err != nil {
+				return err
+			}
+			files = append(files, dirStatFile{path: change.Path(), changed: damage})
+			total += damage
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].path < files[j].path
+	})
+	g := dirStatGatherer{w: w, files: files, total: total, opts: opts.DirStat}
+	_, err := g.gather("")
+	return err
+}
+
+// How much of a file changed: the bytes of the old content that were not
+// kept plus those added, and at least 1 for changed files
+func changeDamage(db *fs.ObjectDB, change *Change, by DirStatBasis) (int, error) {
+	if change.Old.Exists() && change.New.Exists() && change.Old.Digest == change.New.Digest && !change.New.worktree {
+		return 0, nil
+	}
+	if by == DirStatFiles {
+		return 1, nil
+	}
+	oldContent, err := readContent(db, change.Old)
+	if err != nil {
+		return 0, err
+	}
+	newContent, err := readContent(db, change.New)
+	if err != nil {
+		return 0, err
+	}
+	if !change.Old.Exists() || !change.New.Exists() {
+		return max(len(oldContent)+len(newContent), 1), nil
+	}
+	oldChunks, newChunks := chunkSizes(oldContent), chunkSizes(newContent)
+	copied, added := 0, 0
+	for hash, newCount := range newChunks {
+		oldCount := oldChunks[hash]
+		copied += min(oldCount, newCount)
+		added += max(newCount-oldCount, 0)
+	}
+	return max(len(oldContent)-copied+added, 1), nil
+}
+
+type dirStatGatherer struct {
+	w     io.Writer
+	files []dirStatFile
+	total int
+	opts  DirStat
+}
+
+// Consumes the files under base, writing the share of the directory after
+// those of its subdirectories, and returns the changes not yet shown
+func (g *dirStatGatherer) gather(base string) (int, error) {
+	sum, sources := 0, 0
+	for len(g.files) > 0 && strings.HasPrefix(g.files[0].path, base) {
+		f := g.files[0]
+		var changed int
+		if i := strings.IndexByte(f.path[len(base):], '/'); i != -1 {
+			var err error
+			if changed, err = g.gather(f.path[:len(base)+i+1]); err != nil {
+				return 0, err
+			}
+			sources += 1
+		} else {
+			changed = f.changed
+			g.files = g.files[1:]
+			sources += 2
+		}
+		sum += changed
+	}
+	// neither the top level nor directories with changes only from a
+	// single subdirectory are shown
+	if base == "" || sources == 1 || sum == 0 {
+		return sum, nil
+	}
+	permille := sum * 1000 / g.total
+	if permille < g.opts.Permille {
+		return sum, nil
+	}
+	if _, err := fmt.Fprintf(g.w, "%4d.%01d%% %v\n", permille/10, permille%10, base); err != nil {
+		return 0, err
+	}
+	if !g.opts.Cumulative {
+		return 0, nil
+	}
+	return sum, nil
+}
@@ -0,0 +1,78 @@
+package diff_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/magnickolas/gitok/diff"
+	"github.com/magnickolas/gitok/fs"
+)
+
+func TestParseDirStat(t *testing.T) {
+	tests := []struct {
+		params string
+		want   diff.DirStat
+	}{
+		{params: "", want: diff.DefaultDirStat},
+		{params: "lines", want: diff.DirStat{By: diff.DirStatLines, Permille: 30}},
+		{params: "files,cumulative", want: diff.DirStat{By: diff.DirStatFiles, Permille: 30, Cumulative: true}},
+		{params: "10", want: diff.DirStat{Permille: 100}},
+		{params: "2.57", want: diff.DirStat{Permille: 25}},
+		{params: "1.,changes", want: diff.DirStat{Permille: 10}},
+	}
+	for _, test := range tests {
+		got, err := diff.ParseDirStat(test.params, diff.DefaultDirStat)
+		if err != nil {
+			t.Errorf("failed to parse %#v: %v", test.params, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("incorrect result for %#v: wanted %#v, got %#v", test.params, test.want, got)
+		}
+	}
+	for _, params := range []string{"bytes", "5%", "1.x"} {
+		if _, err := diff.ParseDirStat(params, diff.DefaultDirStat); err == nil {
+			t.Errorf("expected an error for %#v", params)
+		}
+	}
+}
+
+func TestWriteDirStat(t *testing.T) {
+	changes, olds := setupChanges(t, renameOldFiles, renameNewFiles)
+	renamed, _, err := diff.FindRenames(fs.Default, changes, olds, diff.Options{Detect: diff.DetectRenames})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		params string
+		// whether renames are detected first
+		renames bool
+		want    string
+	}{
+		{params: "", want: "   9.5% dir/sub/\n  10.9% dir/\n  10.0% other/\n"},
+		{params: "files", want: "  16.6% dir/sub/\n   8.3% dir/\n   8.3% other/\n"},
+		{params: "lines", want: "  11.3% dir/sub/\n  10.8% dir/\n  10.8% other/\n"},
+		{params: "0,cumulative", want: "   9.5% dir/sub/\n  20.5% dir/\n  10.0% other/\n"},
+		{params: "changes,0", renames: true, want: "  23.3% dir/sub/\n  11.2% other/\n"},
+		{params: "changes,12.5", want: "  20.5% dir/\n"},
+		{params: "files,cumulative,0", want: "  16.6% dir/sub/\n  25.0% dir/\n   8.3% other/\n"},
+	}
+	for _, test := range tests {
+		dirStat, err := diff.ParseDirStat(test.params, diff.DefaultDirStat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		input := changes
+		if test.renames {
+			input = renamed
+		}
+		var b strings.Builder
+		if err := diff.Write(&b, fs.Default, input, diff.Options{Format: diff.FormatDirStat, DirStat: dirStat}); err != nil {
+			t.Errorf("failed to write the dirstat of %#v: %v", test.params, err)
+			continue
+		}
+		if got := b.String(); got != test.want {
+			t.Errorf("incorrect result for %#v: wanted %#v, got %#v", test.params, test.want, got)
+		}
+	}
+}
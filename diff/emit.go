@@ -0,0 +1,373 @@
+package diff
+
+import (
+	"io"
+	"strings"
+
+	"github.com/magnickolas/gitok/color"
+)
+
+// Colors of the parts of patches as ANSI escape sequences, empty for the
+// terminal's own
+type Palette struct {
+	Context    string
+	Meta       string
+	Frag       string
+	Func       string
+	Old        string
+	New        string
+	Whitespace string
+	// removed and added lines moved elsewhere, the alternative colors
+	// telling apart adjacent blocks, and the dimmed ones the lines inside
+	// blocks with dimmed-zebra
+	OldMoved          string
+	OldMovedAlt       string
+	OldMovedDimmed    string
+	OldMovedAltDimmed string
+	NewMoved          string
+	NewMovedAlt       string
+	NewMovedDimmed    string
+	NewMovedAltDimmed string
+}
+
+var DefaultPalette = Palette{
+	Meta:              "\x1b[1m",
+	Frag:              "\x1b[36m",
+	Old:               "\x1b[31m",
+	New:               "\x1b[32m",
+	Whitespace:        "\x1b[41m",
+	OldMoved:          "\x1b[1;35m",
+	OldMovedAlt:       "\x1b[1;34m",
+	OldMovedDimmed:    "\x1b[2m",
+	OldMovedAltDimmed: "\x1b[2;3m",
+	NewMoved:          "\x1b[1;36m",
+	NewMovedAlt:       "\x1b[1;33m",
+	NewMovedDimmed:    "\x1b[2m",
+	NewMovedAltDimmed: "\x1b[2;3m",
+}
+
+// Names of the slots of the palette as in the color.diff.<slot> config keys
+var PaletteSlots = []string{
+	"context", "plain", "meta", "frag", "func", "old", "new", "whitespace",
+	"oldMoved", "oldMovedAlternative", "oldMovedDimmed", "oldMovedAlternativeDimmed",
+	"newMoved", "newMovedAlternative", "newMovedDimmed", "newMovedAlternativeDimmed",
+}
+
+// Color of the slot by its name regardless of case, nil if there is none
+func (p *Palette) Slot(name string) *string {
+	switch strings.ToLower(name) {
+	case "context", "plain":
+		return &p.Context
+	case "meta":
+		return &p.Meta
+	case "frag":
+		return &p.Frag
+	case "func":
+		return &p.Func
+	case "old":
+		return &p.Old
+	case "new":
+		return &p.New
+	case "whitespace":
+		return &p.Whitespace
+	case "oldmoved":
+		return &p.OldMoved
+	case "oldmovedalternative":
+		return &p.OldMovedAlt
+	case "oldmoveddimmed":
+		return &p.OldMovedDimmed
+	case "oldmovedalternativedimmed":
+		return &p.OldMovedAltDimmed
+	case "newmoved":
+		return &p.NewMoved
+	case "newmovedalternative":
+		return &p.NewMovedAlt
+	case "newmoveddimmed":
+		return &p.NewMovedDimmed
+	case "newmovedalternativedimmed":
+		return &p.NewMovedAltDimmed
+	}
+	return nil
+}
+
+// Kinds of the lines of patches, shown in different colors
+type symbolKind int
+
+const (
+	// lines of file headers
+	symbolMeta symbolKind = iota
+	// hunk headers
+	symbolFrag
+	symbolContext
+	symbolPlus
+	symbolMinus
+	// "\ No newline at end of file"
+	symbolIncomplete
+	// context lines of word diffs
+	symbolWords
+	// text written as it is
+	symbolPlain
+)
+
+const incompleteLine = "\\ No newline at end of file\n"
+
+// A line of a patch; the text of meta lines and hunk headers has no line
+// break, that of context, added and removed lines has one and no sign
+type symbol struct {
+	kind symbolKind
+	text string
+	// written after the reset of meta lines
+	suffix string
+	// function name of hunk headers
+	funcName string
+	// added blank line at the end of the file
+	blankAtEOF bool
+	// movedLine and friends
+	moved int
+	// lines equal for moved line detection have the same id
+	id int
+	// indentation of the line when moved lines may change it
+	indentOff   int
+	indentWidth int
+}
+
+// Collects the lines of patches and writes them, colored if the options
+// say so; all lines are kept until the end when looking for moved lines
+type emitter struct {
+	w       io.Writer
+	opts    Options
+	colors  Palette
+	reset   string
+	symbols []symbol
+	// first lines of the blank lines added at the end of the current file
+	// in its old and new versions, 0 if none were added
+	blankAtEOF [2]int
+	// numbers of the last lines of the hunk in the old and new version
+	lno [2]int
+}
+
+func newEmitter(w io.Writer, opts Options) *emitter {
+	e := &emitter{w: w, opts: opts}
+	if opts.Colors != nil {
+		e.colors = *opts.Colors
+		e.reset = color.Reset
+	}
+	return e
+}
+
+func (e *emitter) emit(s symbol) {
+	e.symbols = append(e.symbols, s)
+}
+
+// Emits a context, removed or added line of a hunk, followed by a marker
+// if it has no line break
+func (e *emitter) line(kind symbolKind, text string) {
+	s := symbol{kind: kind, text: text}
+	if !strings.HasSuffix(text, "\n") {
+		s.text += "\n"
+	}
+	switch kind {
+	case symbolContext:
+		e.lno[0] += 1
+		e.lno[1] += 1
+		if e.opts.WordDiff != WordDiffNone {
+			s.kind = symbolWords
+		}
+	case symbolMinus:
+		e.lno[0] += 1
+	case symbolPlus:
+		e.lno[1] += 1
+		s.blankAtEOF = e.blankAtEOF[0] != 0 && e.blankAtEOF[1] != 0 &&
+			e.blankAtEOF[0] <= e.lno[0] && e.blankAtEOF[1] <= e.lno[1] &&
+			strings.TrimLeft(text, " \t\n\v\f\r") == ""
+	}
+	e.emit(s)
+	if !strings.HasSuffix(text, "\n") && e.opts.WordDiff == WordDiffNone {
+		e.emit(symbol{kind: symbolIncomplete, text: incompleteLine})
+	}
+}
+
+// Whether moved lines are looked for, which needs all lines first
+func (e *emitter) findsMoved() bool {
+	return e.opts.Colors != nil && e.opts.ColorMoved != MovedNo && e.opts.WordDiff == WordDiffNone
+}
+
+// Writes the lines collected so far, unless the moved lines are yet to be
+// found among all of them
+func (e *emitter) flush(final bool) error {
+	if e.findsMoved() {
+		if !final {
+			return nil
+		}
+		markMoved(e.symbols, e.opts.ColorMoved, e.opts.ColorMovedWS)
+		if e.opts.ColorMoved == MovedDimmedZebra {
+			dimMoved(e.symbols)
+		}
+	}
+	var b strings.Builder
+	for i := range e.symbols {
+		e.render(&b, &e.symbols[i])
+	}
+	e.symbols = e.symbols[:0]
+	_, err := io.WriteString(e.w, b.String())
+	return err
+}
+
+func (e *emitter) render(b *strings.Builder, s *symbol) {
+	switch s.kind {
+	case symbolMeta:
+		b.WriteString(e.colors.Meta + s.text + e.reset + s.suffix + "\n")
+	case symbolFrag:
+		b.WriteString(e.colors.Frag + s.text + e.reset)
+		if s.funcName != "" {
+			b.WriteString(e.colors.Context + " " + e.reset + e.colors.Func + s.funcName + e.reset)
+		}
+		b.WriteString("\n")
+	case symbolContext:
+		e.renderLine(b, e.colors.Context, ' ', s.text)
+	case symbolMinus:
+		set := e.colors.Old
+		switch s.moved {
+		case movedLine | movedAlt | movedDimmed:
+			set = e.colors.OldMovedAltDimmed
+		case movedLine | movedAlt:
+			set = e.colors.OldMovedAlt
+		case movedLine | movedDimmed:
+			set = e.colors.OldMovedDimmed
+		case movedLine:
+			set = e.colors.OldMoved
+		}
+		e.renderLine(b, set, '-', s.text)
+	case symbolPlus:
+		set := e.colors.New
+		switch s.moved {
+		case movedLine | movedAlt | movedDimmed:
+			set = e.colors.NewMovedAltDimmed
+		case movedLine | movedAlt:
+			set = e.colors.NewMovedAlt
+		case movedLine | movedDimmed:
+			set = e.colors.NewMovedDimmed
+		case movedLine:
+			set = e.colors.NewMoved
+		}
+		switch {
+		case e.colors.Whitespace == "":
+			e.renderLine(b, set, '+', s.text)
+		case s.blankAtEOF:
+			e.renderLine(b, e.colors.Whitespace, '+', s.text)
+		default:
+			// whitespace errors of added lines are highlighted
+			b.WriteString(set + "+" + e.reset)
+			e.renderWhitespace(b, set, s.text)
+		}
+	case symbolIncomplete:
+		e.renderLine(b, e.colors.Context, 0, s.text)
+	case symbolWords:
+		if e.opts.WordDiff == WordDiffPorcelain {
+			e.renderLine(b, e.colors.Context, ' ', s.text)
+			b.WriteString("~\n")
+		} else {
+			e.renderLine(b, e.colors.Context, 0, s.text)
+		}
+	case symbolPlain:
+		b.WriteString(s.text)
+	}
+}
+
+// Writes the line in the color after its sign, if any, with the reset
+// before its line break
+func (e *emitter) renderLine(b *strings.Builder, set string, sign byte, line string) {
+	line, newline := strings.CutSuffix(line, "\n")
+	line, cr := strings.CutSuffix(line, "\r")
+	if line != "" || sign != 0 {
+		b.WriteString(set)
+		if sign != 0 {
+			b.WriteByte(sign)
+		}
+		b.WriteString(line)
+		b.WriteString(e.reset)
+	}
+	if cr {
+		b.WriteString("\r")
+	}
+	if newline {
+		b.WriteString("\n")
+	}
+}
+
+// Writes the line in the color with its whitespace errors highlighted:
+// whitespace at its end and spaces before tabs in its indentation. Tabs of
+// the indentation are left uncolored
+func (e *emitter) renderWhitespace(b *strings.Builder, set string, line string) {
+	line, newline := strings.CutSuffix(line, "\n")
+	trailing := len(strings.TrimRight(line, " \t\n\v\f\r"))
+	written := 0
+	for i := 0; i < trailing; i += 1 {
+		if line[i] == ' ' {
+			continue
+		}
+		if line[i] != '\t' {
+			break
+		}
+		if written < i {
+			b.WriteString(e.colors.Whitespace + line[written:i] + e.reset + "\t")
+		} else {
+			b.WriteString("\t")
+		}
+		written = i + 1
+	}
+	if written < trailing {
+		b.WriteString(set + line[written:trailing] + e.reset)
+	}
+	if trailing < len(line) {
+		b.WriteString(e.colors.Whitespace + line[trailing:] + e.reset)
+	}
+	if newline {
+		b.WriteString("\n")
+	}
+}
+
+// Starts of the blank lines added at the end of a file, one-based, in
+// its old and new content; zeros if no more blank lines end the new one
+func blankAtEOF(a []byte, b []byte) [2]int {
+	blanksA, blanksB := trailingBlankLines(a), trailingBlankLines(b)
+	if blanksB <= blanksA {
+		return [2]int{}
+	}
+	return [2]int{numLines(a) - blanksA + 1, numLines(b) - blanksB + 1}
+}
+
+// Number of lines of only whitespace at the end of the content; as in git,
+// the first line is never counted
+func trailingBlankLines(content []byte) int {
+	if len(content) == 0 {
+		return 0
+	}
+	end := len(content) - 1
+	if content[end] == '\n' {
+		end -= 1
+	}
+	res := 0
+	for 0 < end {
+		start := end
+		for start >= 0 && content[start] != '\n' {
+			start -= 1
+		}
+		if strings.TrimLeft(string(content[start+1:end+1]), " \t\n\v\f\r") != "" {
+			break
+		}
+		res += 1
+		end = start - 1
+	}
+	return res
+}
+
+// Number of lines of the content, the last one counting without a line
+// break
+func numLines(content []byte) int {
+	res := strings.Count(string(content), "\n")
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		res += 1
+	}
+	return res
+}
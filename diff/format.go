@@ -0,0 +1,192 @@
+package diff
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/quote"
+	"github.com/magnickolas/gitok/repr"
+)
+
+// Ways of showing changes, which can be combined
+type Format int
+
+const (
+	// modes, digests, status and paths (--raw)
+	FormatRaw Format = 1 << iota
+	// paths only (--name-only)
+	FormatNameOnly
+	// status and paths (--name-status)
+	FormatNameStatus
+	// added and deleted lines per file (--numstat)
+	FormatNumStat
+	// diffstat (--stat)
+	FormatStat
+	// the summary line of the diffstat (--shortstat)
+	FormatShortStat
+	// share of the changes per directory (--dirstat)
+	FormatDirStat
+	// creations, deletions, renames, copies and mode changes (--summary)
+	FormatSummary
+	// git patch (-p)
+	FormatPatch
+)
+
+// Writes the changes in the formats of the options in git's order: a line
+// per change, the numstat, diffstat, shortstat, dirstat and summary, then
+// the patch set off by a blank line unless only a dirstat came before.
+// Names of files are shown alone
+func Write(w io.Writer, db *fs.ObjectDB, changes []Change, opts Options) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	if opts.Format&(FormatNameOnly|FormatNameStatus) != 0 {
+		opts.Format &= FormatNameOnly | FormatNameStatus
+	}
+	separate := false
+	if opts.Format&(FormatRaw|FormatNameOnly|FormatNameStatus) != 0 {
+		for _, change := range changes {
+			if err := writeChangeLine(w, db, &change, opts); err != nil {
+				return err
+			}
+		}
+		separate = true
+	}
+	var stats []fileStat
+	if opts.Format&(FormatNumStat|FormatStat|FormatShortStat) != 0 || (opts.Format&FormatDirStat != 0 && opts.DirStat.By == DirStatLines) {
+		var err error
+		if stats, err = computeStats(db, changes, opts); err != nil {
+			return err
+		}
+	}
+	if opts.Format&FormatNumStat != 0 {
+		if err := writeNumStat(w, stats); err != nil {
+			return err
+		}
+		separate = true
+	}
+	if opts.Format&FormatStat != 0 {
+		if err := writeStat(w, stats, opts); err != nil {
+			return err
+		}
+		separate = true
+	}
+	if opts.Format&FormatShortStat != 0 {
+		if _, err := fmt.Fprintln(w, summaryLine(stats)); err != nil {
+			return err
+		}
+		separate = true
+	}
+	if opts.Format&FormatDirStat != 0 {
+		if err := writeDirStat(w, db, changes, stats, opts); err != nil {
+			return err
+		}
+	}
+	if opts.Format&FormatSummary != 0 {
+		written, err := writeSummary(w, changes)
+		if err != nil {
+			return err
+		}
+		separate = separate || written
+	}
+	if opts.Format&FormatPatch != 0 {
+		if separate {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+		return WritePatch(w, db, changes, opts)
+	}
+	return nil
+}
+
+// Raw line of the change, or its paths with or without the status
+func writeChangeLine(w io.Writer, db *fs.ObjectDB, change *Change, opts Options) error {
+	paths := quote.CQuote(change.Path())
+	if change.Status == Renamed || change.Status == Copied {
+		paths = quote.CQuote(change.Old.Path) + "\t" + paths
+	}
+	status := string(change.Status)
+	if change.Status == Renamed || change.Status == Copied {
+		status = fmt.Sprintf("%c%03d", change.Status, change.Score)
+	}
+	var err error
+	switch {
+	case opts.Format&FormatNameStatus != 0:
+		_, err = fmt.Fprintf(w, "%v\t%v\n", status, paths)
+	case opts.Format&FormatRaw != 0:
+		_, err = fmt.Fprintf(w, ":%v %v %v %v %v\t%v\n", rawMode(change.Old), rawMode(change.New),
+			rawDigest(db, change.Old, opts.Abbrev), rawDigest(db, change.New, opts.Abbrev), status, paths)
+	default:
+		_, err = fmt.Fprintln(w, quote.CQuote(change.Path()))
+	}
+	return err
+}
+
+// Writes a line per created, deleted, renamed or copied file and per mode
+// change, telling whether any was written
+func writeSummary(w io.Writer, changes []Change) (bool, error) {
+	written := false
+	for _, change := range changes {
+		var lines []string
+		switch change.Status {
+		case Added:
+			lines = append(lines, fmt.Sprintf(" create mode %06v %v", change.New.Mode, quote.CQuote(change.New.Path)))
+		case Deleted:
+			lines = append(lines, fmt.Sprintf(" delete mode %06v %v", change.Old.Mode, quote.CQuote(change.Old.Path)))
+		case Renamed, Copied:
+			verb := "rename"
+			if change.Status == Copied {
+				verb = "copy"
+			}
+			lines = append(lines, fmt.Sprintf(" %v %v (%d%%)", verb, renameName(change.Old.Path, change.New.Path), change.Score))
+			if line := modeChangeLine(&change); line != "" {
+				lines = append(lines, line)
+			}
+		default:
+			if line := modeChangeLine(&change); line != "" {
+				lines = append(lines, line+" "+quote.CQuote(change.New.Path))
+			}
+		}
+		for _, line := range lines {
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return false, err
+			}
+			written = true
+		}
+	}
+	return written, nil
+}
+
+// " mode change OLD => NEW", empty if the mode did not change
+func modeChangeLine(change *Change) string {
+	if !change.Old.Exists() || !change.New.Exists() || change.Old.Mode == change.New.Mode {
+		return ""
+	}
+	return fmt.Sprintf(" mode change %06v => %06v", change.Old.Mode, change.New.Mode)
+}
+
+func rawMode(f File) string {
+	if !f.Exists() {
+		return "000000"
+	}
+	return fmt.Sprintf("%06v", f.Mode)
+}
+
+// Digest of the file, all zeros if it is missing or not yet stored as for
+// worktree files
+func rawDigest(db *fs.ObjectDB, f File, length int) string {
+	digest := f.Digest
+	if digest == "" || f.worktree {
+		digest = repr.ZeroDigest()
+	}
+	if length == 0 {
+		return digest
+	}
+	if strings.Trim(digest, "0") == "" {
+		return digest[:length]
+	}
+	return db.Abbrev(digest, length)
+}
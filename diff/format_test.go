@@ -0,0 +1,39 @@
+package diff_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/magnickolas/gitok/diff"
+	"github.com/magnickolas/gitok/repr"
+)
+
+func TestWriteNames(t *testing.T) {
+	changes := []diff.Change{
+		{Status: diff.Added, New: diff.File{Path: "new", Mode: repr.ModeNormal, Digest: "1"}},
+		{
+			Status: diff.Renamed, Score: 86,
+			Old: diff.File{Path: "old name", Mode: repr.ModeNormal, Digest: "2"},
+			New: diff.File{Path: "dir/renamed", Mode: repr.ModeNormal, Digest: "3"},
+		},
+		{Status: diff.Unmerged, Old: diff.File{Path: "conflict"}, New: diff.File{Path: "conflict"}},
+	}
+	tests := []struct {
+		format diff.Format
+		want   string
+	}{
+		{format: diff.FormatNameOnly, want: "new\ndir/renamed\nconflict\n"},
+		{format: diff.FormatNameStatus, want: "A\tnew\nR086\told name\tdir/renamed\nU\tconflict\n"},
+		{format: diff.FormatNameStatus | diff.FormatPatch, want: "A\tnew\nR086\told name\tdir/renamed\nU\tconflict\n"},
+	}
+	for _, test := range tests {
+		var b strings.Builder
+		if err := diff.Write(&b, nil, changes, diff.Options{Format: test.format}); err != nil {
+			t.Errorf("failed to write format %#v: %v", test.format, err)
+			continue
+		}
+		if got := b.String(); got != test.want {
+			t.Errorf("incorrect result for %#v: wanted %#v, got %#v", test.format, test.want, got)
+		}
+	}
+}
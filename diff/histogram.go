@@ -0,0 +1,165 @@
+package diff
+
+// Lines occurring more often than this on the old side are not used to
+// split the ranges
+const maxChainLength = 64
+
+// Occurrences of a line on the old side
+type histogramRecord struct {
+	// first occurrence
+	ptr int
+	cnt int
+}
+
+// Common range of lines found by the histogram diff, 1-based and inclusive
+type region struct {
+	begin1, end1 int
+	begin2, end2 int
+}
+
+// Histogram diff of the 1-based line ranges: the longest common range
+// around the lines least frequent on the old side splits the ranges, which
+// are diffed recursively; with only frequent common lines, Myers'
+// algorithm takes over
+func (d *differ) histogram(line1, count1, line2, count2 int) {
+	for {
+		if count1 <= 0 && count2 <= 0 {
+			return
+		}
+		switch {
+		case count1 == 0:
+			for j := line2; j < line2+count2; j += 1 {
+				d.b.changed[j-1] = true
+			}
+			return
+		case count2 == 0:
+			for i := line1; i < line1+count1; i += 1 {
+				d.a.changed[i-1] = true
+			}
+			return
+		}
+		lcs, fallBack := d.findLCS(line1, count1, line2, count2)
+		if fallBack {
+			d.myers(line1-1, line1-1+count1, line2-1, line2-1+count2, false)
+			return
+		}
+		if lcs.begin1 == 0 && lcs.begin2 == 0 {
+			for i := line1; i < line1+count1; i += 1 {
+				d.a.changed[i-1] = true
+			}
+			for j := line2; j < line2+count2; j += 1 {
+				d.b.changed[j-1] = true
+			}
+			return
+		}
+		d.histogram(line1, lcs.begin1-line1, line2, lcs.begin2-line2)
+		count1 = line1 + count1 - 1 - lcs.end1
+		line1 = lcs.end1 + 1
+		count2 = line2 + count2 - 1 - lcs.end2
+		line2 = lcs.end2 + 1
+	}
+}
+
+type histogramIndex struct {
+	d             *differ
+	line1, count1 int
+	line2, count2 int
+	records       map[int]*histogramRecord
+	// by line: the next occurrence of the line (0 if none) and its record
+	nextPtrs []int
+	lineMap  []*histogramRecord
+	// occurrences of the lines of the best range found
+	cnt       int
+	hasCommon bool
+}
+
+// Longest common range around the least frequent lines, and whether there
+// were common lines but all too frequent to use
+func (d *differ) findLCS(line1, count1, line2, count2 int) (region, bool) {
+	index := &histogramIndex{
+		d:        d,
+		line1:    line1,
+		count1:   count1,
+		line2:    line2,
+		count2:   count2,
+		records:  make(map[int]*histogramRecord),
+		nextPtrs: make([]int, count1),
+		lineMap:  make([]*histogramRecord, count1),
+		cnt:      maxChainLength + 1,
+	}
+	for ptr := line1 + count1 - 1; ptr >= line1; ptr -= 1 {
+		id := d.a.ids[ptr-1]
+		if rec, ok := index.records[id]; ok {
+			index.nextPtrs[ptr-line1] = rec.ptr
+			rec.ptr = ptr
+			rec.cnt += 1
+			index.lineMap[ptr-line1] = rec
+			continue
+		}
+		rec := &histogramRecord{ptr: ptr, cnt: 1}
+		index.records[id] = rec
+		index.lineMap[ptr-line1] = rec
+	}
+	var lcs region
+	for bPtr := line2; bPtr <= line2+count2-1; {
+		bPtr = index.tryLCS(&lcs, bPtr)
+	}
+	return lcs, index.hasCommon && maxChainLength < index.cnt
+}
+
+func (index *histogramIndex) match(ptr1 int, ptr2 int) bool {
+	return index.d.a.ids[ptr1-1] == index.d.b.ids[ptr2-1]
+}
+
+// Extends the common ranges around the occurrences of line bPtr on the old
+// side, keeping the best; returns the next line to try
+func (index *histogramIndex) tryLCS(lcs *region, bPtr int) int {
+	bNext := bPtr + 1
+	rec, ok := index.records[index.d.b.ids[bPtr-1]]
+	if !ok {
+		return bNext
+	}
+	if rec.cnt > index.cnt {
+		index.hasCommon = true
+		return bNext
+	}
+	index.hasCommon = true
+	end1, end2 := index.line1+index.count1-1, index.line2+index.count2-1
+	as := rec.ptr
+	for {
+		np := index.nextPtrs[as-index.line1]
+		bs := bPtr
+		ae, be := as, bs
+		rc := rec.cnt
+		for index.line1 < as && index.line2 < bs && index.match(as-1, bs-1) {
+			as, bs = as-1, bs-1
+			if 1 < rc {
+				rc = min(rc, index.lineMap[as-index.line1].cnt)
+			}
+		}
+		for ae < end1 && be < end2 && index.match(ae+1, be+1) {
+			ae, be = ae+1, be+1
+			if 1 < rc {
+				rc = min(rc, index.lineMap[ae-index.line1].cnt)
+			}
+		}
+		if bNext <= be {
+			bNext = be + 1
+		}
+		if lcs.end1-lcs.begin1 < ae-as || rc < index.cnt {
+			*lcs = region{begin1: as, end1: ae, begin2: bs, end2: be}
+			index.cnt = rc
+		}
+		if np == 0 {
+			break
+		}
+		for np <= ae {
+			np = index.nextPtrs[np-index.line1]
+			if np == 0 {
+				return bNext
+			}
+		}
+		as = np
+	}
+	return bNext
+}
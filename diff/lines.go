@@ -0,0 +1,190 @@
+package diff
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrorUnknownAlgorithm       = errors.New("unknown diff algorithm")
+	formatErrorUnknownAlgorithm = func(name string) error {
+		return fmt.Errorf("%w: %v", ErrorUnknownAlgorithm, name)
+	}
+)
+
+type Op byte
+
+const (
+	Equal  Op = ' '
+	Delete Op = '-'
+	Insert Op = '+'
+)
+
+// One line of an edit script. Old and New are the line's positions in the
+// old and new texts; for a deleted line New is where it would be in the new
+// text, and the other way round for inserted lines
+type Edit struct {
+	Op  Op
+	Old int
+	New int
+}
+
+type Algorithm int
+
+const (
+	// Myers' algorithm, giving up on the shortest script for large
+	// differences
+	AlgorithmMyers Algorithm = iota
+	// Myers' algorithm, always finding the shortest script
+	AlgorithmMinimal
+	// matches lines occurring once on both sides first
+	AlgorithmPatience
+	// matches the least frequent lines first
+	AlgorithmHistogram
+)
+
+var algorithmNames = map[string]Algorithm{
+	"myers":     AlgorithmMyers,
+	"default":   AlgorithmMyers,
+	"minimal":   AlgorithmMinimal,
+	"patience":  AlgorithmPatience,
+	"histogram": AlgorithmHistogram,
+}
+
+func ParseAlgorithm(name string) (Algorithm, error) {
+	if algorithm, ok := algorithmNames[strings.ToLower(name)]; ok {
+		return algorithm, nil
+	}
+	return AlgorithmMyers, formatErrorUnknownAlgorithm(name)
+}
+
+// Splits content into lines, each keeping its "\n" except possibly the last
+func SplitLines(content []byte) []string {
+	var res []string
+	for len(content) > 0 {
+		i := bytes.IndexByte(content, '\n')
+		if i == -1 {
+			res = append(res, string(content))
+			break
+		}
+		res = append(res, string(content[:i+1]))
+		content = content[i+1:]
+	}
+	return res
+}
+
+// Edit script turning lines a into lines b, with deletions before
+// insertions inside each changed region. Lines equal but for the
+// whitespace the options ignore are unchanged, and changed regions are
+// slid to line up with the other side's or, with the indent heuristic, to
+// where they look best
+func Lines(a []string, b []string, opts Options) []Edit {
+	d := newDiffer(a, b, opts)
+	switch opts.Algorithm {
+	case AlgorithmPatience:
+		d.patience(0, len(a), 0, len(b))
+	case AlgorithmHistogram:
+		d.histogram(1, len(a), 1, len(b))
+	default:
+		d.myers(0, len(a), 0, len(b), opts.Algorithm == AlgorithmMinimal)
+	}
+	d.compact(d.a, d.b)
+	d.compact(d.b, d.a)
+	return d.edits()
+}
+
+// Lines of one side of a diff
+type side struct {
+	lines []string
+	// lines interned as integers, equal for lines that match
+	ids     []int
+	changed []bool
+}
+
+// Whether line i is changed; the positions before the first line and
+// after the last are not
+func (s *side) isChanged(i int) bool {
+	return i >= 0 && i < len(s.changed) && s.changed[i]
+}
+
+type differ struct {
+	a, b *side
+	// Options.IndentHeuristic
+	indentHeuristic bool
+}
+
+func newDiffer(a []string, b []string, opts Options) *differ {
+	ids := make(map[string]int)
+	newSide := func(lines []string) *side {
+		s := &side{lines: lines, ids: make([]int, len(lines)), changed: make([]bool, len(lines))}
+		for i, line := range lines {
+			key := matchKey(line, opts)
+			id, ok := ids[key]
+			if !ok {
+				id = len(ids)
+				ids[key] = id
+			}
+			s.ids[i] = id
+		}
+		return s
+	}
+	return &differ{a: newSide(a), b: newSide(b), indentHeuristic: opts.IndentHeuristic}
+}
+
+// Whitespace as git sees it
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// Form of the line that is the same for the lines that match under the
+// whitespace options: without any whitespace, or with its runs of
+// whitespace squeezed to a space and none at the end
+func matchKey(line string, opts Options) string {
+	if !opts.IgnoreAllSpace && !opts.IgnoreSpaceChange {
+		return line
+	}
+	var b strings.Builder
+	for i := 0; i < len(line); {
+		if !isSpace(line[i]) {
+			b.WriteByte(line[i])
+			i += 1
+			continue
+		}
+		for i < len(line) && isSpace(line[i]) {
+			i += 1
+		}
+		if !opts.IgnoreAllSpace && i < len(line) {
+			b.WriteByte(' ')
+		}
+	}
+	return b.String()
+}
+
+// Whether the line is empty, or only whitespace with the whitespace options
+func isBlankLine(line string, opts Options) bool {
+	if !opts.IgnoreAllSpace && !opts.IgnoreSpaceChange {
+		return len(line) <= 1
+	}
+	return strings.TrimLeft(line, " \t\n\r") == ""
+}
+
+func (d *differ) edits() []Edit {
+	var res []Edit
+	i, j := 0, 0
+	for i < len(d.a.ids) || j < len(d.b.ids) {
+		switch {
+		case d.a.isChanged(i):
+			res = append(res, Edit{Op: Delete, Old: i, New: j})
+			i += 1
+		case d.b.isChanged(j):
+			res = append(res, Edit{Op: Insert, Old: i, New: j})
+			j += 1
+		default:
+			res = append(res, Edit{Op: Equal, Old: i, New: j})
+			i, j = i+1, j+1
+		}
+	}
+	return res
+}
@@ -0,0 +1,57 @@
+package diff_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/magnickolas/gitok/diff"
+)
+
+func TestLines(t *testing.T) {
+	crossed := [2]string{"  y\nb\n{\na\n  x\n  x\n  y\n", "  y\n  x\n{\n}\n  y\nb\n"}
+	braces := [2]string{"c\nc\n{\n}\n{\n  y\n\n", "\n}\n{\n  x\n{\n  y\n"}
+	tests := []struct {
+		a, b string
+		opts diff.Options
+		want string
+	}{
+		{
+			a: crossed[0], b: crossed[1], opts: diff.Options{Algorithm: diff.AlgorithmMyers},
+			want: "@@ -1,7 +1,6 @@\n   y\n-b\n-{\n-a\n-  x\n   x\n+{\n+}\n   y\n+b\n",
+		},
+		{
+			a: crossed[0], b: crossed[1], opts: diff.Options{Algorithm: diff.AlgorithmPatience},
+			want: "@@ -1,7 +1,6 @@\n   y\n-b\n+  x\n {\n-a\n-  x\n-  x\n+}\n   y\n+b\n",
+		},
+		{
+			a: crossed[0], b: crossed[1], opts: diff.Options{Algorithm: diff.AlgorithmHistogram},
+			want: "@@ -1,7 +1,6 @@\n   y\n+  x\n+{\n+}\n+  y\n b\n-{\n-a\n-  x\n-  x\n-  y\n",
+		},
+		{
+			a: braces[0], b: braces[1], opts: diff.Options{IndentHeuristic: true},
+			want: "@@ -1,7 +1,6 @@\n-c\n-c\n-{\n+\n }\n+{\n+  x\n {\n   y\n-\n",
+		},
+		{
+			a: braces[0], b: braces[1], opts: diff.Options{},
+			want: "@@ -1,7 +1,6 @@\n-c\n-c\n-{\n+\n }\n {\n+  x\n+{\n   y\n-\n",
+		},
+		{
+			a: "a  b\nc\n", b: "a b\nc \nd\n", opts: diff.Options{IgnoreSpaceChange: true},
+			want: "@@ -1,2 +1,3 @@\n a b\n c \n+d\n",
+		},
+		{a: "x b\nc\n", b: "xb\nc\n", opts: diff.Options{IgnoreSpaceChange: true}, want: "@@ -1,2 +1,2 @@\n-x b\n+xb\n c\n"},
+		{a: "x b\nc\n", b: "xb\nc\n", opts: diff.Options{IgnoreAllSpace: true}, want: ""},
+		{a: "x\ny\n", b: "x\n\ny\n", opts: diff.Options{IgnoreBlankLines: true}, want: ""},
+	}
+	for _, test := range tests {
+		var b strings.Builder
+		test.opts.Context = diff.DefaultContext
+		if err := diff.WriteUnified(&b, diff.SplitLines([]byte(test.a)), diff.SplitLines([]byte(test.b)), test.opts); err != nil {
+			t.Errorf("failed to diff %#v and %#v: %v", test.a, test.b, err)
+			continue
+		}
+		if got := b.String(); got != test.want {
+			t.Errorf("incorrect result for %#v and %#v: wanted %#v, got %#v", test.a, test.b, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+var (
+	ErrorUnknownColorMoved       = errors.New("color moved setting must be one of 'no', 'default', 'blocks', 'zebra', 'dimmed-zebra', 'plain'")
+	formatErrorUnknownColorMoved = func(mode string) error {
+		return fmt.Errorf("%w, not '%v'", ErrorUnknownColorMoved, mode)
+	}
+	ErrorUnknownColorMovedWS       = errors.New("unknown color-moved-ws mode")
+	formatErrorUnknownColorMovedWS = func(mode string) error {
+		return fmt.Errorf("%w '%v', possible values are 'ignore-space-change', 'ignore-space-at-eol', 'ignore-all-space', 'allow-indentation-change'", ErrorUnknownColorMovedWS, mode)
+	}
+	ErrorIndentationChangeCombined = errors.New("color-moved-ws: allow-indentation-change cannot be combined with other whitespace modes")
+)
+
+// How lines removed in one place and added in another are colored
+type Moved int
+
+const (
+	MovedNo Moved = iota
+	// every moved line in the moved colors
+	MovedPlain
+	// blocks of moved lines with at least 20 letters and digits
+	MovedBlocks
+	// blocks, adjacent ones in alternating colors
+	MovedZebra
+	// zebra with the lines inside blocks dimmed
+	MovedDimmedZebra
+)
+
+func ParseColorMoved(mode string) (Moved, error) {
+	switch strings.ToLower(mode) {
+	case "no", "false", "off":
+		return MovedNo, nil
+	case "plain":
+		return MovedPlain, nil
+	case "blocks":
+		return MovedBlocks, nil
+	case "zebra", "default", "true", "yes", "on":
+		return MovedZebra, nil
+	case "dimmed-zebra", "dimmed_zebra":
+		return MovedDimmedZebra, nil
+	}
+	return MovedNo, formatErrorUnknownColorMoved(mode)
+}
+
+// Whitespace ignored when matching moved lines
+type MovedWS int
+
+const (
+	MovedIgnoreSpaceAtEOL MovedWS = 1 << iota
+	MovedIgnoreSpaceChange
+	MovedIgnoreAllSpace
+	// lines of a block may be indented differently by the same amount
+	MovedAllowIndentationChange
+)
+
+// Parses comma-separated whitespace modes, "no" dropping those before it
+func ParseColorMovedWS(modes string) (MovedWS, error) {
+	var res MovedWS
+	for _, mode := range strings.Split(modes, ",") {
+		switch strings.TrimSpace(mode) {
+		case "no":
+			res = 0
+		case "ignore-space-at-eol":
+			res |= MovedIgnoreSpaceAtEOL
+		case "ignore-space-change":
+			res |= MovedIgnoreSpaceChange
+		case "ignore-all-space":
+			res |= MovedIgnoreAllSpace
+		case "allow-indentation-change":
+			res |= MovedAllowIndentationChange
+		default:
+			return 0, formatErrorUnknownColorMovedWS(strings.TrimSpace(mode))
+		}
+	}
+	if res&MovedAllowIndentationChange != 0 && res != MovedAllowIndentationChange {
+		return 0, ErrorIndentationChangeCombined
+	}
+	return res, nil
+}
+
+// Flags of moved lines
+const (
+	movedLine = 1 << iota
+	// in the alternative color
+	movedAlt
+	// inside a block, with dimmed-zebra
+	movedDimmed
+)
+
+// Least number of letters and digits of a block of moved lines
+const movedMinAlnum = 20
+
+// Indentation width of blank lines
+const indentBlank = math.MinInt32
+
+// Width of tabs when comparing indentation
+const movedTabWidth = 8
+
+// Sets the width and end of the indentation of the line, which blank
+// lines have none of
+func (s *symbol) fillIndent() {
+	line := s.text
+	off, width := 0, 0
+	for off < len(line) && (line[off] == '\f' || line[off] == '\v' || (line[off] == '\r' && off < len(line)-1)) {
+		off += 1
+	}
+	for off < len(line) {
+		if line[off] == ' ' {
+			width += 1
+			off += 1
+		} else if line[off] == '\t' {
+			width += movedTabWidth - width%movedTabWidth
+			for off += 1; off < len(line) && line[off] == '\t'; off += 1 {
+				width += movedTabWidth
+			}
+		} else {
+			break
+		}
+	}
+	if strings.TrimLeft(line[off:], " \t\n\v\f\r") == "" {
+		s.indentWidth, s.indentOff = indentBlank, len(line)
+		return
+	}
+	s.indentWidth, s.indentOff = width, off
+}
+
+// Form of the line that is the same for lines matching as moved
+func movedKey(s *symbol, ws MovedWS) string {
+	line := s.text[s.indentOff:]
+	if ws&(MovedIgnoreAllSpace|MovedIgnoreSpaceChange) != 0 {
+		return matchKey(line, Options{IgnoreAllSpace: ws&MovedIgnoreAllSpace != 0, IgnoreSpaceChange: true})
+	}
+	if ws&MovedIgnoreSpaceAtEOL != 0 {
+		return strings.TrimRight(line, " \t\n\r")
+	}
+	return line
+}
+
+// A block of moved lines being matched: the line it matched last and the
+// change of indentation of its lines
+type movedBlock struct {
+	match int
+	wsd   int
+}
+
+// Marks the added lines that were removed elsewhere and the other way
+// round, as git does: blocks of moved lines are followed as long as they
+// match, the shortest are not counted, and adjacent blocks alternate
+func markMoved(symbols []symbol, mode Moved, ws MovedWS) {
+	// the lines matching each line's id on the other side, latest first,
+	// and the line following each in the same run of added or removed ones
+	ids := make(map[string]int)
+	var adds, dels [][]int
+	next := make([]int, len(symbols))
+	prev := -1
+	for n := range symbols {
+		s := &symbols[n]
+		next[n] = -1
+		if s.kind != symbolPlus && s.kind != symbolMinus {
+			prev = -1
+			continue
+		}
+		if ws&MovedAllowIndentationChange != 0 {
+			s.fillIndent()
+		}
+		key := movedKey(s, ws)
+		id, ok := ids[key]
+		if !ok {
+			id = len(ids)
+			ids[key] = id
+			adds, dels = append(adds, nil), append(dels, nil)
+		}
+		s.id = id
+		if prev != -1 && symbols[prev].kind == s.kind {
+			next[prev] = n
+		}
+		prev = n
+		if s.kind == symbolPlus {
+			adds[id] = append([]int{n}, adds[id]...)
+		} else {
+			dels[id] = append([]int{n}, dels[id]...)
+		}
+	}
+
+	var pmb []movedBlock
+	flipped, blockLength := false, 0
+	movedKind := symbolKind(-1)
+	n := 0
+	for ; n < len(symbols); n += 1 {
+		s := &symbols[n]
+		var match []int
+		switch s.kind {
+		case symbolPlus:
+			match = dels[s.id]
+		case symbolMinus:
+			match = adds[s.id]
+		default:
+			flipped = false
+		}
+		if len(pmb) > 0 && (len(match) == 0 || s.kind != movedKind) {
+			if !adjustLastBlock(symbols, n, blockLength) && blockLength > 1 {
+				// another block may start at the second line of this one
+				match = nil
+				n -= blockLength
+			}
+			pmb, blockLength, flipped = pmb[:0], 0, false
+		}
+		if len(match) == 0 {
+			movedKind = -1
+			continue
+		}
+		if mode == MovedPlain {
+			s.moved |= movedLine
+			continue
+		}
+		// the blocks continuing with this line
+		j := 0
+		for i := range pmb {
+			cur := next[pmb[i].match]
+			if cur == -1 {
+				continue
+			}
+			var ok bool
+			if ws&MovedAllowIndentationChange != 0 {
+				ok = sameIndentChange(&symbols[cur], s, &pmb[i])
+			} else {
+				ok = symbols[cur].id == s.id
+			}
+			if ok {
+				pmb[j] = movedBlock{match: cur, wsd: pmb[i].wsd}
+				j += 1
+			}
+		}
+		pmb = pmb[:j]
+		if len(pmb) == 0 {
+			contiguous := adjustLastBlock(symbols, n, blockLength)
+			if !contiguous && blockLength > 1 {
+				n -= blockLength
+			} else {
+				for _, m := range match {
+					wsd := 0
+					if ws&MovedAllowIndentationChange != 0 {
+						wsd = indentDelta(s, &symbols[m])
+					}
+					pmb = append(pmb, movedBlock{match: m, wsd: wsd})
+				}
+			}
+			flipped = contiguous && len(pmb) > 0 && movedKind == s.kind && !flipped
+			movedKind = -1
+			if len(pmb) > 0 {
+				movedKind = s.kind
+			}
+			blockLength = 0
+		}
+		if len(pmb) > 0 {
+			blockLength += 1
+			s.moved |= movedLine
+			if flipped && mode != MovedBlocks {
+				s.moved |= movedAlt
+			}
+		}
+	}
+	if mode != MovedPlain {
+		adjustLastBlock(symbols, n, blockLength)
+	}
+}
+
+// Whether the block of the length lines before n has enough letters and
+// digits to count as moved; if not its lines are unmarked
+func adjustLastBlock(symbols []symbol, n int, length int) bool {
+	alnum := 0
+	for i := 1; i <= length; i += 1 {
+		for _, c := range []byte(symbols[n-i].text) {
+			if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+				alnum += 1
+				if alnum >= movedMinAlnum {
+					return true
+				}
+			}
+		}
+	}
+	for i := 1; i <= length; i += 1 {
+		symbols[n-i].moved &^= movedLine | movedAlt
+	}
+	return false
+}
+
+// Difference of the indentation of two lines, indentBlank if both are
+// blank
+func indentDelta(a *symbol, b *symbol) int {
+	if a.indentWidth == indentBlank && b.indentWidth == indentBlank {
+		return indentBlank
+	}
+	return a.indentWidth - b.indentWidth
+}
+
+// Whether the line continues the block at the candidate line cur, its
+// indentation changed as much as that of the block's lines
+func sameIndentChange(cur *symbol, s *symbol, block *movedBlock) bool {
+	if cur.id != s.id {
+		return false
+	}
+	if cur.indentWidth == indentBlank {
+		return true
+	}
+	delta := s.indentWidth - cur.indentWidth
+	// blocks of only blank lines so far take the change of this line
+	if block.wsd == indentBlank {
+		block.wsd = delta
+	}
+	return delta == block.wsd
+}
+
+// Marks the moved lines inside blocks as dimmed, leaving those at the
+// bounds between blocks
+func dimMoved(symbols []symbol) {
+	isLine := func(n int) bool {
+		return 0 <= n && n < len(symbols) && (symbols[n].kind == symbolPlus || symbols[n].kind == symbolMinus)
+	}
+	zebra := func(n int) int {
+		return symbols[n].moved & (movedLine | movedAlt)
+	}
+	for n := range symbols {
+		s := &symbols[n]
+		if !isLine(n) || s.moved&movedLine == 0 {
+			continue
+		}
+		hasPrev, hasNext := isLine(n-1), isLine(n+1)
+		if hasPrev && hasNext && zebra(n-1) == zebra(n) && zebra(n+1) == zebra(n) {
+			s.moved |= movedDimmed
+			continue
+		}
+		if hasPrev && symbols[n-1].moved&movedLine != 0 && symbols[n-1].moved&movedAlt != s.moved&movedAlt {
+			continue
+		}
+		if hasNext && symbols[n+1].moved&movedLine != 0 && symbols[n+1].moved&movedAlt != s.moved&movedAlt {
+			continue
+		}
+		s.moved |= movedDimmed
+	}
+}
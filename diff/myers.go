@@ -0,0 +1,339 @@
+package diff
+
+import (
+	"math"
+)
+
+// Lines matching lines on the other side more than the square root of the
+// number of lines, up to this, count as matching many times
+const maxEqualLimit = 1024
+
+// How far around a line matching many others the lines are looked at
+const simScanWindow = 100
+
+// Lines matching many others are dropped when fewer than one in this many
+// lines around them match once
+const keepRunFactor = 4
+
+// Cost after which the search settles for a good enough path instead of
+// the shortest one, unless the square root of the size is larger
+const minMaxCost = 256
+
+// Cost after which the search looks for a long common run
+const heuristicMinCost = 256
+
+// Length of a common run long enough to split at
+const snakeCount = 20
+
+// How much further a path has to reach than its cost to be split at
+const heuristicFactor = 4
+
+// Power of two close to the square root of n
+func bogoSqrt(n int) int {
+	i := 1
+	for ; n > 0; n >>= 2 {
+		i <<= 1
+	}
+	return i
+}
+
+// Myers' O(ND) algorithm in linear space on the lines a[lo1:hi1] and
+// b[lo2:hi2], as xdiff does it: the lines matching nothing on the other
+// side are set aside first, and unless minimal, the search settles for a
+// good enough path through large differences
+func (d *differ) myers(lo1, hi1, lo2, hi2 int, minimal bool) {
+	a, b := d.a.ids[lo1:hi1], d.b.ids[lo2:hi2]
+	start, end := 0, 0
+	for start < min(len(a), len(b)) && a[start] == b[start] {
+		start += 1
+	}
+	for end < min(len(a), len(b))-start && a[len(a)-1-end] == b[len(b)-1-end] {
+		end += 1
+	}
+	counts := func(ids []int) map[int]int {
+		res := make(map[int]int)
+		for _, id := range ids {
+			res[id] += 1
+		}
+		return res
+	}
+	countsA, countsB := counts(a), counts(b)
+	// the lines kept for the search, by their positions
+	keep := func(s *side, ids []int, lo int, otherCounts map[int]int) ([]int, []int) {
+		last := len(ids) - end - 1
+		limit := min(bogoSqrt(len(ids)), maxEqualLimit)
+		matches := make([]byte, len(ids))
+		for i := start; i <= last; i += 1 {
+			switch n := otherCounts[ids[i]]; {
+			case n == 0:
+				matches[i] = 0
+			case n >= limit:
+				matches[i] = 2
+			default:
+				matches[i] = 1
+			}
+		}
+		var kept, positions []int
+		for i := start; i <= last; i += 1 {
+			if matches[i] == 1 || matches[i] == 2 && !discardMultiMatch(matches, i, start, last) {
+				kept = append(kept, ids[i])
+				positions = append(positions, lo+i)
+			} else {
+				s.changed[lo+i] = true
+			}
+		}
+		return kept, positions
+	}
+	ha1, index1 := keep(d.a, a, lo1, countsB)
+	ha2, index2 := keep(d.b, b, lo2, countsA)
+	s := &myersSearch{
+		d:       d,
+		ha1:     ha1,
+		ha2:     ha2,
+		index1:  index1,
+		index2:  index2,
+		offset:  len(ha2) + 1,
+		maxCost: max(bogoSqrt(len(ha1)+len(ha2)+3), minMaxCost),
+	}
+	s.forward = make([]int, len(ha1)+len(ha2)+3)
+	s.backward = make([]int, len(ha1)+len(ha2)+3)
+	s.compare(0, len(ha1), 0, len(ha2), minimal)
+}
+
+// Whether a line matching many others sits among lines matching nothing,
+// and so is better taken as changed
+func discardMultiMatch(matches []byte, i, start, end int) bool {
+	start = max(start, i-simScanWindow)
+	end = min(end, i+simScanWindow)
+	before, beforeMulti := 0, 1
+	for r := 1; i-r >= start; r += 1 {
+		if matches[i-r] == 0 {
+			before += 1
+		} else if matches[i-r] == 2 {
+			beforeMulti += 1
+		} else {
+			break
+		}
+	}
+	if before == 0 {
+		return false
+	}
+	after, afterMulti := 0, 1
+	for r := 1; i+r <= end; r += 1 {
+		if matches[i+r] == 0 {
+			after += 1
+		} else if matches[i+r] == 2 {
+			afterMulti += 1
+		} else {
+			break
+		}
+	}
+	if after == 0 {
+		return false
+	}
+	multi, none := beforeMulti+afterMulti, before+after
+	return multi*keepRunFactor < multi+none
+}
+
+type myersSearch struct {
+	d *differ
+	// lines kept for the search and their positions on their sides
+	ha1, ha2       []int
+	index1, index2 []int
+	// furthest reaching paths by diagonal, shifted by offset
+	forward, backward []int
+	offset            int
+	maxCost           int
+}
+
+func (s *myersSearch) compare(off1, lim1, off2, lim2 int, minimal bool) {
+	for off1 < lim1 && off2 < lim2 && s.ha1[off1] == s.ha2[off2] {
+		off1, off2 = off1+1, off2+1
+	}
+	for off1 < lim1 && off2 < lim2 && s.ha1[lim1-1] == s.ha2[lim2-1] {
+		lim1, lim2 = lim1-1, lim2-1
+	}
+	switch {
+	case off1 == lim1:
+		for ; off2 < lim2; off2 += 1 {
+			s.d.b.changed[s.index2[off2]] = true
+		}
+	case off2 == lim2:
+		for ; off1 < lim1; off1 += 1 {
+			s.d.a.changed[s.index1[off1]] = true
+		}
+	default:
+		spl := s.split(off1, lim1, off2, lim2, minimal)
+		s.compare(off1, spl.i1, off2, spl.i2, spl.minLo)
+		s.compare(spl.i1, lim1, spl.i2, lim2, spl.minHi)
+	}
+}
+
+// Point to divide the comparison at, and whether each half needs the
+// shortest path
+type split struct {
+	i1, i2       int
+	minLo, minHi bool
+}
+
+// Finds the middle of the shortest path by searching from both ends or,
+// once that gets costly and a minimal path is not needed, a point a good
+// path goes through
+func (s *myersSearch) split(off1, lim1, off2, lim2 int, minimal bool) split {
+	ha1, ha2 := s.ha1, s.ha2
+	kf := func(d int) *int { return &s.forward[d+s.offset] }
+	kb := func(d int) *int { return &s.backward[d+s.offset] }
+	dmin, dmax := off1-lim2, lim1-off2
+	fmid, bmid := off1-off2, lim1-lim2
+	odd := (fmid-bmid)&1 != 0
+	fmin, fmax := fmid, fmid
+	bmin, bmax := bmid, bmid
+	*kf(fmid) = off1
+	*kb(bmid) = lim1
+	for cost := 1; ; cost += 1 {
+		gotSnake := false
+		if fmin > dmin {
+			fmin -= 1
+			*kf(fmin - 1) = -1
+		} else {
+			fmin += 1
+		}
+		if fmax < dmax {
+			fmax += 1
+			*kf(fmax + 1) = -1
+		} else {
+			fmax -= 1
+		}
+		for d := fmax; d >= fmin; d -= 2 {
+			var i1 int
+			if *kf(d - 1) >= *kf(d + 1) {
+				i1 = *kf(d - 1) + 1
+			} else {
+				i1 = *kf(d + 1)
+			}
+			prev := i1
+			i2 := i1 - d
+			for i1 < lim1 && i2 < lim2 && ha1[i1] == ha2[i2] {
+				i1, i2 = i1+1, i2+1
+			}
+			if i1-prev > snakeCount {
+				gotSnake = true
+			}
+			*kf(d) = i1
+			if odd && bmin <= d && d <= bmax && *kb(d) <= i1 {
+				return split{i1: i1, i2: i2, minLo: true, minHi: true}
+			}
+		}
+		if bmin > dmin {
+			bmin -= 1
+			*kb(bmin - 1) = math.MaxInt
+		} else {
+			bmin += 1
+		}
+		if bmax < dmax {
+			bmax += 1
+			*kb(bmax + 1) = math.MaxInt
+		} else {
+			bmax -= 1
+		}
+		for d := bmax; d >= bmin; d -= 2 {
+			var i1 int
+			if *kb(d - 1) < *kb(d + 1) {
+				i1 = *kb(d - 1)
+			} else {
+				i1 = *kb(d + 1) - 1
+			}
+			prev := i1
+			i2 := i1 - d
+			for i1 > off1 && i2 > off2 && ha1[i1-1] == ha2[i2-1] {
+				i1, i2 = i1-1, i2-1
+			}
+			if prev-i1 > snakeCount {
+				gotSnake = true
+			}
+			*kb(d) = i1
+			if !odd && fmin <= d && d <= fmax && i1 <= *kf(d) {
+				return split{i1: i1, i2: i2, minLo: true, minHi: true}
+			}
+		}
+		if minimal {
+			continue
+		}
+		// a path reaching far past its cost along a long common run is
+		// good enough to split at
+		if gotSnake && cost > heuristicMinCost {
+			best, res := 0, split{}
+			for d := fmax; d >= fmin; d -= 2 {
+				dd := max(d-fmid, fmid-d)
+				i1 := *kf(d)
+				i2 := i1 - d
+				v := (i1 - off1) + (i2 - off2) - dd
+				if v > heuristicFactor*cost && v > best &&
+					off1+snakeCount <= i1 && i1 < lim1 &&
+					off2+snakeCount <= i2 && i2 < lim2 {
+					for k := 1; ha1[i1-k] == ha2[i2-k]; k += 1 {
+						if k == snakeCount {
+							best = v
+							res.i1, res.i2 = i1, i2
+							break
+						}
+					}
+				}
+			}
+			if best > 0 {
+				res.minLo = true
+				return res
+			}
+			for d := bmax; d >= bmin; d -= 2 {
+				dd := max(d-bmid, bmid-d)
+				i1 := *kb(d)
+				i2 := i1 - d
+				v := (lim1 - i1) + (lim2 - i2) - dd
+				if v > heuristicFactor*cost && v > best &&
+					off1 < i1 && i1 <= lim1-snakeCount &&
+					off2 < i2 && i2 <= lim2-snakeCount {
+					for k := 0; ha1[i1+k] == ha2[i2+k]; k += 1 {
+						if k == snakeCount-1 {
+							best = v
+							res.i1, res.i2 = i1, i2
+							break
+						}
+					}
+				}
+			}
+			if best > 0 {
+				res.minHi = true
+				return res
+			}
+		}
+		// too costly: settle for the path reaching furthest
+		if cost >= s.maxCost {
+			fbest, fbest1 := -1, -1
+			for d := fmax; d >= fmin; d -= 2 {
+				i1 := min(*kf(d), lim1)
+				i2 := i1 - d
+				if lim2 < i2 {
+					i1, i2 = lim2+d, lim2
+				}
+				if fbest < i1+i2 {
+					fbest, fbest1 = i1+i2, i1
+				}
+			}
+			bbest, bbest1 := math.MaxInt, math.MaxInt
+			for d := bmax; d >= bmin; d -= 2 {
+				i1 := max(off1, *kb(d))
+				i2 := i1 - d
+				if i2 < off2 {
+					i1, i2 = off2+d, off2
+				}
+				if i1+i2 < bbest {
+					bbest, bbest1 = i1+i2, i1
+				}
+			}
+			if (lim1+lim2)-bbest < fbest-(off1+off2) {
+				return split{i1: fbest1, i2: fbest - fbest1, minLo: true}
+			}
+			return split{i1: bbest1, i2: bbest - bbest1, minHi: true}
+		}
+	}
+}
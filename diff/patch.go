@@ -0,0 +1,250 @@
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/quote"
+	"github.com/magnickolas/gitok/repr"
+	"github.com/magnickolas/gitok/worktree"
+)
+
+// Length of the abbreviated digests of "index" lines
+const abbrevLen = 7
+
+// Prefix of a file git looks for NUL bytes in to tell binary files
+const binaryCheckLen = 8000
+
+type Options struct {
+	// lines of context around changes
+	Context int
+	// columns available to --stat output
+	StatWidth int
+	Algorithm Algorithm
+	// slide changes to where the indentation suggests they belong
+	IndentHeuristic bool
+	// lines are the same ignoring all whitespace (-w)
+	IgnoreAllSpace bool
+	// lines are the same ignoring changes in the amount of whitespace (-b)
+	IgnoreSpaceChange bool
+	// changes of only blank lines are not shown unless close to others
+	IgnoreBlankLines bool
+	// whether FindRenames looks for renames, and copies too
+	Detect Detection
+	// least similarity of renames and copies, DefaultRenameScore if 0
+	RenameScore int
+	// unchanged files are sources of copies too
+	FindCopiesHarder bool
+	// renames are looked for by similarity among at most this many added
+	// files times sources squared, any if 0
+	RenameLimit int
+	// what Write shows of the changes
+	Format Format
+	// how the dirstat shares the changes among directories
+	DirStat DirStat
+	// length the digests of raw output are abbreviated to, 0 for full
+	Abbrev int
+	// colors of patches, nil for none
+	Colors *Palette
+	// binary files are shown as patches that apply rather than only
+	// told to differ
+	Binary bool
+	// digests of "index" lines are not abbreviated
+	FullIndex bool
+	// whether and how changed lines are shown word by word
+	WordDiff WordDiff
+	// what words are for word diffs, runs of non-whitespace if nil
+	WordRegex *regexp.Regexp
+	// how lines moved elsewhere are colored
+	ColorMoved Moved
+	// whitespace ignored when matching moved lines
+	ColorMovedWS MovedWS
+}
+
+// Content of one side of a change; submodules are shown by their commit
+func readContent(db *fs.ObjectDB, f File) ([]byte, error) {
+	if !f.Exists() {
+		return nil, nil
+	}
+	if f.Mode == repr.ModeGitlink {
+		return []byte("Subproject commit " + f.Digest + "\n"), nil
+	}
+	if f.worktree {
+		path := filepath.FromSlash(f.Path)
+		info, err := os.Lstat(path)
+		if err != nil {
+			return nil, err
+		}
+		blob, err := worktree.ReadBlob(path, info, f.conv)
+		if err != nil {
+			return nil, err
+		}
+		return blob.Content(), nil
+	}
+	_, content, err := db.ReadRawObject(f.Digest)
+	return content, err
+}
+
+// Whether the content looks binary: it has a NUL byte near the start
+func IsBinary(content []byte) bool {
+	return bytes.IndexByte(content[:min(len(content), binaryCheckLen)], 0) != -1
+}
+
+func quotePath(prefix string, path string) string {
+	return quote.CQuote(prefix + path)
+}
+
+// Name of a side in the "---" and "+++" lines and what follows it: a tab
+// if it has spaces for the name to be told from anything following it
+func fileLabel(prefix string, path string) (string, string) {
+	label := quotePath(prefix, path)
+	if strings.Contains(label, " ") {
+		return label, "\t"
+	}
+	return label, ""
+}
+
+// Writes the changes as a git patch
+func WritePatch(w io.Writer, db *fs.ObjectDB, changes []Change, opts Options) error {
+	e := newEmitter(w, opts)
+	for _, change := range changes {
+		if change.Status == Unmerged {
+			e.emit(symbol{kind: symbolPlain, text: fmt.Sprintf("* Unmerged path %v\n", change.Path())})
+			continue
+		}
+		if change.Status == TypeChanged {
+			// shown as the removal of the old file and the creation of the new
+			removal := Change{Status: Deleted, Old: change.Old}
+			creation := Change{Status: Added, New: change.New}
+			if err := writeFilePatch(e, db, &removal); err != nil {
+				return err
+			}
+			if err := writeFilePatch(e, db, &creation); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := writeFilePatch(e, db, &change); err != nil {
+			return err
+		}
+		if err := e.flush(false); err != nil {
+			return err
+		}
+	}
+	return e.flush(true)
+}
+
+func writeFilePatch(e *emitter, db *fs.ObjectDB, change *Change) error {
+	oldPath, newPath := change.Old.Path, change.New.Path
+	if !change.Old.Exists() {
+		oldPath = newPath
+	}
+	if !change.New.Exists() {
+		newPath = oldPath
+	}
+	start := len(e.symbols)
+	meta := func(format string, args ...any) {
+		e.emit(symbol{kind: symbolMeta, text: fmt.Sprintf(format, args...)})
+	}
+	meta("diff --git %v %v", quotePath("a/", oldPath), quotePath("b/", newPath))
+	switch {
+	case !change.Old.Exists():
+		meta("new file mode %06v", change.New.Mode)
+	case !change.New.Exists():
+		meta("deleted file mode %06v", change.Old.Mode)
+	case change.Old.Mode != change.New.Mode:
+		meta("old mode %06v", change.Old.Mode)
+		meta("new mode %06v", change.New.Mode)
+	}
+	switch change.Status {
+	case Renamed:
+		meta("similarity index %d%%", change.Score)
+		meta("rename from %v", quote.CQuote(oldPath))
+		meta("rename to %v", quote.CQuote(newPath))
+	case Copied:
+		meta("similarity index %d%%", change.Score)
+		meta("copy from %v", quote.CQuote(oldPath))
+		meta("copy to %v", quote.CQuote(newPath))
+	}
+	if change.Old.Digest == change.New.Digest {
+		return nil
+	}
+	oldContent, err := readContent(db, change.Old)
+	if err != nil {
+		return err
+	}
+	newContent, err := readContent(db, change.New)
+	if err != nil {
+		return err
+	}
+	binary := IsBinary(oldContent) || IsBinary(newContent)
+	oldDigest, newDigest := abbrev(db, change.Old.Digest), abbrev(db, change.New.Digest)
+	// binary patches name the blobs in full to be applied with a 3-way
+	// merge
+	if e.opts.FullIndex || binary && e.opts.Binary {
+		oldDigest, newDigest = fullDigest(change.Old.Digest), fullDigest(change.New.Digest)
+	}
+	index := fmt.Sprintf("index %v..%v", oldDigest, newDigest)
+	if change.Old.Mode == change.New.Mode {
+		index += fmt.Sprintf(" %06v", change.Old.Mode)
+	}
+	meta("%v", index)
+	oldLabel, oldSuffix := fileLabel("a/", oldPath)
+	newLabel, newSuffix := fileLabel("b/", newPath)
+	if !change.Old.Exists() {
+		oldLabel, oldSuffix = "/dev/null", ""
+	}
+	if !change.New.Exists() {
+		newLabel, newSuffix = "/dev/null", ""
+	}
+	if binary && e.opts.Binary {
+		writeBinaryPatch(e, oldContent, newContent)
+		return nil
+	}
+	if binary {
+		e.emit(symbol{kind: symbolPlain, text: fmt.Sprintf("Binary files %v%v and %v%v differ\n", oldLabel, oldSuffix, newLabel, newSuffix)})
+		return nil
+	}
+	e.emit(symbol{kind: symbolMeta, text: "--- " + oldLabel, suffix: oldSuffix})
+	e.emit(symbol{kind: symbolMeta, text: "+++ " + newLabel, suffix: newSuffix})
+	labels := len(e.symbols)
+	e.blankAtEOF = [2]int{}
+	if e.opts.Colors != nil {
+		e.blankAtEOF = blankAtEOF(oldContent, newContent)
+	}
+	writeHunks(e, SplitLines(oldContent), SplitLines(newContent), e.opts)
+	if len(e.symbols) > labels {
+		return nil
+	}
+	// a modification whose changes are all ignored is not shown at all
+	mustShow := !change.Old.Exists() || !change.New.Exists() || change.Old.Mode != change.New.Mode ||
+		change.Status == Renamed || change.Status == Copied
+	if mustShow {
+		e.symbols = e.symbols[:labels-2]
+	} else {
+		e.symbols = e.symbols[:start]
+	}
+	return nil
+}
+
+// The digest, all zeros for a missing file
+func fullDigest(digest string) string {
+	if digest == "" {
+		return repr.ZeroDigest()
+	}
+	return digest
+}
+
+// Shortest unambiguous prefix of the digest, all zeros for a missing file
+func abbrev(db *fs.ObjectDB, digest string) string {
+	if digest == "" {
+		return strings.Repeat("0", abbrevLen)
+	}
+	return db.Abbrev(digest, abbrevLen)
+}
@@ -0,0 +1,99 @@
+package diff
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+
+	"github.com/magnickolas/gitok/fs"
+)
+
+// Stable patch ID of the changes, as "git patch-id --stable" computes it:
+// the sum of the digests of the patches of the files with whitespace and
+// line numbers left out, so that it depends neither on the order of the
+// files nor on where the changes are
+func PatchID(db *fs.ObjectDB, changes []Change) (string, error) {
+	var sum [sha1.Size]byte
+	h := sha1.New()
+	for _, change := range changes {
+		if change.Status == Unmerged || change.Old.Digest == change.New.Digest && change.Old.Mode == change.New.Mode {
+			continue
+		}
+		if err := hashFilePatch(h, db, &change); err != nil {
+			return "", err
+		}
+		// 20-byte sum with carry
+		carry := 0
+		for i, b := range h.Sum(nil) {
+			carry += int(sum[i]) + int(b)
+			sum[i] = byte(carry)
+			carry >>= 8
+		}
+		h.Reset()
+	}
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func hashFilePatch(h hash.Hash, db *fs.ObjectDB, change *Change) error {
+	oldPath, newPath := change.Old.Path, change.New.Path
+	if !change.Old.Exists() {
+		oldPath = newPath
+	}
+	if !change.New.Exists() {
+		newPath = oldPath
+	}
+	oldPath, newPath = removeSpace(oldPath), removeSpace(newPath)
+	h.Write([]byte("diff--gita/" + oldPath + "b/" + newPath))
+	switch {
+	case !change.Old.Exists():
+		fmt.Fprintf(h, "newfilemode%06v", change.New.Mode)
+	case !change.New.Exists():
+		fmt.Fprintf(h, "deletedfilemode%06v", change.Old.Mode)
+	case change.Old.Mode != change.New.Mode:
+		fmt.Fprintf(h, "oldmode%06vnewmode%06v", change.Old.Mode, change.New.Mode)
+	}
+	oldContent, err := readContent(db, change.Old)
+	if err != nil {
+		return err
+	}
+	newContent, err := readContent(db, change.New)
+	if err != nil {
+		return err
+	}
+	if IsBinary(oldContent) || IsBinary(newContent) {
+		h.Write([]byte(fullDigest(change.Old.Digest) + fullDigest(change.New.Digest)))
+		return nil
+	}
+	switch {
+	case !change.Old.Exists():
+		h.Write([]byte("---/dev/null+++b/" + newPath))
+	case !change.New.Exists():
+		h.Write([]byte("---a/" + oldPath + "+++/dev/null"))
+	default:
+		h.Write([]byte("---a/" + oldPath + "+++b/" + newPath))
+	}
+	var patch bytes.Buffer
+	if err := WriteUnified(&patch, SplitLines(oldContent), SplitLines(newContent), Options{Context: DefaultContext}); err != nil {
+		return err
+	}
+	for _, line := range strings.SplitAfter(patch.String(), "\n") {
+		// hunk headers and the markers of missing line breaks are left out
+		if strings.HasPrefix(line, "@@") || strings.HasPrefix(line, `\ `) && len(line) > 12 {
+			continue
+		}
+		h.Write([]byte(removeSpace(line)))
+	}
+	return nil
+}
+
+func removeSpace(s string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(" \t\n\v\f\r", r) {
+			return -1
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,137 @@
+package diff
+
+// Line2 of a patience entry whose line is not unique on both sides
+const nonUnique = -2
+
+// A line of a[lo1:hi1], by its first position
+type patienceEntry struct {
+	line1 int
+	// position of the only matching line of b, -1 if none
+	line2      int
+	next, prev *patienceEntry
+}
+
+// Patience diff of a[line1:line1+count1] and b[line2:line2+count2]: the
+// longest common sequence of lines unique on both sides splits the ranges,
+// which are diffed recursively; without unique lines, Myers' algorithm
+// takes over
+func (d *differ) patience(line1, count1, line2, count2 int) {
+	switch {
+	case count1 == 0:
+		for j := line2; j < line2+count2; j += 1 {
+			d.b.changed[j] = true
+		}
+		return
+	case count2 == 0:
+		for i := line1; i < line1+count1; i += 1 {
+			d.a.changed[i] = true
+		}
+		return
+	}
+	entries := make(map[int]*patienceEntry)
+	var ordered []*patienceEntry
+	for i := line1; i < line1+count1; i += 1 {
+		if e, ok := entries[d.a.ids[i]]; ok {
+			e.line2 = nonUnique
+			continue
+		}
+		e := &patienceEntry{line1: i, line2: -1}
+		entries[d.a.ids[i]] = e
+		ordered = append(ordered, e)
+	}
+	hasMatches := false
+	for j := line2; j < line2+count2; j += 1 {
+		e, ok := entries[d.b.ids[j]]
+		if !ok {
+			continue
+		}
+		hasMatches = true
+		if e.line2 != -1 {
+			e.line2 = nonUnique
+		} else {
+			e.line2 = j
+		}
+	}
+	if !hasMatches {
+		for i := line1; i < line1+count1; i += 1 {
+			d.a.changed[i] = true
+		}
+		for j := line2; j < line2+count2; j += 1 {
+			d.b.changed[j] = true
+		}
+		return
+	}
+	first := longestCommonSequence(ordered)
+	if first == nil {
+		d.myers(line1, line1+count1, line2, line2+count2, false)
+		return
+	}
+	d.walkCommonSequence(first, line1, count1, line2, count2)
+}
+
+// Longest sequence of the unique lines in the order of both sides, as a
+// list linked by next
+func longestCommonSequence(ordered []*patienceEntry) *patienceEntry {
+	// for each length, the sequence of it ending with the smallest line2
+	var sequence []*patienceEntry
+	for _, e := range ordered {
+		if e.line2 < 0 {
+			continue
+		}
+		left, right := -1, len(sequence)
+		for left+1 < right {
+			middle := left + (right-left)/2
+			if sequence[middle].line2 > e.line2 {
+				right = middle
+			} else {
+				left = middle
+			}
+		}
+		e.prev = nil
+		if left >= 0 {
+			e.prev = sequence[left]
+		}
+		if left+1 == len(sequence) {
+			sequence = append(sequence, e)
+		} else {
+			sequence[left+1] = e
+		}
+	}
+	if len(sequence) == 0 {
+		return nil
+	}
+	e := sequence[len(sequence)-1]
+	e.next = nil
+	for e.prev != nil {
+		e.prev.next = e
+		e = e.prev
+	}
+	return e
+}
+
+func (d *differ) walkCommonSequence(first *patienceEntry, line1, count1, line2, count2 int) {
+	end1, end2 := line1+count1, line2+count2
+	for {
+		next1, next2 := end1, end2
+		if first != nil {
+			next1, next2 = first.line1, first.line2
+			for next1 > line1 && next2 > line2 && d.a.ids[next1-1] == d.b.ids[next2-1] {
+				next1, next2 = next1-1, next2-1
+			}
+		}
+		for line1 < next1 && line2 < next2 && d.a.ids[line1] == d.b.ids[line2] {
+			line1, line2 = line1+1, line2+1
+		}
+		if next1 > line1 || next2 > line2 {
+			d.patience(line1, next1-line1, line2, next2-line2)
+		}
+		if first == nil {
+			return
+		}
+		for first.next != nil && first.next.line1 == first.line1+1 && first.next.line2 == first.line2+1 {
+			first = first.next
+		}
+		line1, line2 = first.line1+1, first.line2+1
+		first = first.next
+	}
+}
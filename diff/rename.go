@@ -0,0 +1,532 @@
+package diff
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/repr"
+)
+
+var (
+	ErrorInvalidScore       = errors.New("invalid similarity score")
+	formatErrorInvalidScore = func(s string) error {
+		return fmt.Errorf("%w: %v", ErrorInvalidScore, s)
+	}
+)
+
+// Score of identical files; similarity percentages are relative to it
+const MaxScore = 60000
+
+// Least score of a rename or copy unless told otherwise (50%)
+const DefaultRenameScore = MaxScore / 2
+
+// Modulus of the chunk hashes
+const hashBase = 107927
+
+// Longest chunk hashed at once
+const chunkLen = 64
+
+// Sizes in bytes of the chunks with each hash, where a chunk ends at a
+// line break or after 64 bytes; as in git, the bytes after the last chunk
+// are left out
+func chunkSizes(content []byte) map[uint32]int {
+	text := !IsBinary(content)
+	res := make(map[uint32]int)
+	var accum1, accum2 uint32
+	n := 0
+	for i, c := range content {
+		// CR of CRLF sequences is not counted in text
+		if text && c == '\r' && i+1 < len(content) && content[i+1] == '\n' {
+			continue
+		}
+		old1 := accum1
+		accum1 = (accum1 << 7) ^ (accum2 >> 25)
+		accum2 = (accum2 << 7) ^ (old1 >> 25)
+		accum1 += uint32(c)
+		n += 1
+		if n < chunkLen && c != '\n' {
+			continue
+		}
+		res[(accum1+accum2*0x61)%hashBase] += n
+		n, accum1, accum2 = 0, 0, 0
+	}
+	return res
+}
+
+// Size in bytes of the chunks with a hash
+type chunk struct {
+	hash uint32
+	size int
+}
+
+// Chunk sizes of the content sorted by hash, for files to be compared by
+// merging their chunks
+func sortedChunks(content []byte) []chunk {
+	sizes := chunkSizes(content)
+	res := make([]chunk, 0, len(sizes))
+	for hash, size := range sizes {
+		res = append(res, chunk{hash: hash, size: size})
+	}
+	slices.SortFunc(res, func(a, b chunk) int {
+		return cmp.Compare(a.hash, b.hash)
+	})
+	return res
+}
+
+// Estimates how much of the new file was copied from the old one, from 0
+// to MaxScore, the way git does when detecting renames. Files whose sizes
+// differ too much to reach the minimum score are only looked at for their
+// sizes and score 0
+func Similarity(db *fs.ObjectDB, old File, new File, minimum int) (int, error) {
+	return newSimilarityCache(db).similarity(old, new, minimum)
+}
+
+// Sizes and chunks of the files compared by similarity, each file being
+// read at most once however many times it is compared
+type similarityCache struct {
+	db     *fs.ObjectDB
+	sizes  map[string]int64
+	chunks map[string][]chunk
+}
+
+func newSimilarityCache(db *fs.ObjectDB) *similarityCache {
+	return &similarityCache{
+		db:     db,
+		sizes:  make(map[string]int64),
+		chunks: make(map[string][]chunk),
+	}
+}
+
+// Key of the file in the cache: worktree files are known by their path
+func cacheKey(f File) string {
+	if f.worktree {
+		return "worktree:" + f.Path
+	}
+	return f.Digest
+}
+
+// Size of the file, from the object header for stored files
+func (c *similarityCache) size(f File) (int64, error) {
+	key := cacheKey(f)
+	if size, ok := c.sizes[key]; ok {
+		return size, nil
+	}
+	var size int64
+	if f.worktree {
+		content, err := readContent(c.db, f)
+		if err != nil {
+			return 0, err
+		}
+		size = int64(len(content))
+		c.chunks[key] = sortedChunks(content)
+	} else {
+		info, err := c.db.ReadObjectInfo(f.Digest)
+		if err != nil {
+			return 0, err
+		}
+		size = info.Size
+	}
+	c.sizes[key] = size
+	return size, nil
+}
+
+func (c *similarityCache) sortedChunks(f File) ([]chunk, error) {
+	key := cacheKey(f)
+	if chunks, ok := c.chunks[key]; ok {
+		return chunks, nil
+	}
+	content, err := readContent(c.db, f)
+	if err != nil {
+		return nil, err
+	}
+	chunks := sortedChunks(content)
+	c.chunks[key] = chunks
+	return chunks, nil
+}
+
+func (c *similarityCache) similarity(old File, new File, minimum int) (int, error) {
+	if old.Digest == new.Digest {
+		return MaxScore, nil
+	}
+	// only regular files are renamed with edits
+	if fileType(old.Mode) != repr.ModeNormal || fileType(new.Mode) != repr.ModeNormal {
+		return 0, nil
+	}
+	oldSize, err := c.size(old)
+	if err != nil {
+		return 0, err
+	}
+	newSize, err := c.size(new)
+	if err != nil {
+		return 0, err
+	}
+	maxSize := max(oldSize, newSize)
+	delta := maxSize - min(oldSize, newSize)
+	if maxSize*int64(MaxScore-minimum) < delta*MaxScore || newSize == 0 {
+		return 0, nil
+	}
+	oldChunks, err := c.sortedChunks(old)
+	if err != nil {
+		return 0, err
+	}
+	newChunks, err := c.sortedChunks(new)
+	if err != nil {
+		return 0, err
+	}
+	copied := 0
+	for i, j := 0, 0; i < len(oldChunks) && j < len(newChunks); {
+		switch a, b := oldChunks[i], newChunks[j]; {
+		case a.hash < b.hash:
+			i += 1
+		case a.hash > b.hash:
+			j += 1
+		default:
+			copied += min(a.size, b.size)
+			i += 1
+			j += 1
+		}
+	}
+	return int(int64(copied) * MaxScore / maxSize), nil
+}
+
+// Parses a least similarity as given to -M and -C: a percentage such as
+// "50%", or the digits of a fraction, "5" and "0.5" both being a half
+func ParseScore(s string) (int, error) {
+	num, scale := 0, 1
+	dot := false
+	i := 0
+loop:
+	for ; i < len(s); i += 1 {
+		switch c := s[i]; {
+		case c == '.' && !dot:
+			scale, dot = 1, true
+		case c == '%':
+			if dot {
+				scale *= 100
+			} else {
+				scale = 100
+			}
+			i += 1
+			break loop
+		case '0' <= c && c <= '9':
+			// further digits are too precise to matter
+			if scale < 100000 {
+				scale *= 10
+				num = num*10 + int(c-'0')
+			}
+		default:
+			break loop
+		}
+	}
+	if i != len(s) {
+		return 0, formatErrorInvalidScore(s)
+	}
+	if num >= scale {
+		return MaxScore, nil
+	}
+	return MaxScore * num / scale, nil
+}
+
+// Candidate sources kept for each added file
+const candidatesPerFile = 4
+
+// Renames are looked for by similarity among this many added files times
+// sources squared unless told otherwise
+const DefaultRenameLimit = 1000
+
+// What FindRenames looks for
+type Detection int
+
+const (
+	DetectNone Detection = iota
+	// deleted files that were added under another name
+	DetectRenames
+	// also modified files that were copied to added ones
+	DetectCopies
+)
+
+// How a rename limit too low for the added files and the sources was dealt
+// with: renames and copies were only looked for among identical files, or
+// with Degraded, copies of unchanged files were not looked for
+type RenameLimitHit struct {
+	// the limit that would have been enough, 0 if it was not hit
+	Needed   int
+	Degraded bool
+}
+
+// The lines git warns with, empty if the limit was not hit
+func (hit RenameLimitHit) Warning() string {
+	if hit.Needed == 0 {
+		return ""
+	}
+	what := "warning: exhaustive rename detection was skipped due to too many files.\n"
+	if hit.Degraded {
+		what = "warning: only found copies from modified paths due to too many files.\n"
+	}
+	return what + fmt.Sprintf("warning: you may want to set your diff.renameLimit variable to at least %d and retry the command.\n", hit.Needed)
+}
+
+// A file that can be renamed or copied
+type renameSource struct {
+	file File
+	// index of its change, -1 for an unchanged file
+	change int
+	// renames and copies made of it, plus one if it remains
+	used int
+}
+
+type renameCandidate struct {
+	src, dst int
+	score    int
+	// whether the file names are the same
+	sameName bool
+}
+
+// Whether the candidate is worse than the other one, lower scoring or
+// only changing the file name
+func (c *renameCandidate) worse(other *renameCandidate) bool {
+	if c.score != other.score {
+		return c.score < other.score
+	}
+	return !c.sameName && other.sameName
+}
+
+// Pairs files added by the changes with deleted ones of the same or similar
+// content into renames, as git does: identical contents are paired first,
+// then files of the same unique name, then the most similar files. With
+// copies, modified files (and with FindCopiesHarder the unchanged files of
+// olds) are sources of copies too, as are deleted files after their first
+// use. A rename takes the place of the added file among the changes. Also
+// tells whether the rename limit of the options was hit
+func FindRenames(db *fs.ObjectDB, changes []Change, olds []File, opts Options) ([]Change, RenameLimitHit, error) {
+	if opts.Detect == DetectNone {
+		return changes, RenameLimitHit{}, nil
+	}
+	minScore := opts.RenameScore
+	if minScore == 0 {
+		minScore = DefaultRenameScore
+	}
+	copies := opts.Detect == DetectCopies
+	var srcs []*renameSource
+	var dsts []int
+	changed := make(map[string]bool)
+	for i, change := range changes {
+		changed[change.Old.Path] = true
+		switch {
+		case change.Status == Added:
+			dsts = append(dsts, i)
+		case change.Status == Deleted:
+			srcs = append(srcs, &renameSource{file: change.Old, change: i})
+		case copies && (change.Status == Modified || change.Status == TypeChanged):
+			srcs = append(srcs, &renameSource{file: change.Old, change: i, used: 1})
+		}
+	}
+	if copies && opts.FindCopiesHarder {
+		for _, f := range olds {
+			if !changed[f.Path] {
+				srcs = append(srcs, &renameSource{file: f, change: -1, used: 1})
+			}
+		}
+		sort.SliceStable(srcs, func(i, j int) bool {
+			return srcs[i].file.Path < srcs[j].file.Path
+		})
+	}
+	if len(srcs) == 0 || len(dsts) == 0 {
+		return changes, RenameLimitHit{}, nil
+	}
+	cache := newSimilarityCache(db)
+	// renames and copies by added file, with their sources
+	renames := make(map[int]Change)
+	renameSrcs := make(map[int]*renameSource)
+	pair := func(src *renameSource, dst int, score int) {
+		renames[dst] = Change{Old: src.file, New: changes[dst].New, Score: score * 100 / MaxScore}
+		renameSrcs[dst] = src
+		src.used += 1
+	}
+	for _, dst := range dsts {
+		new := changes[dst].New
+		var best *renameSource
+		bestScore := -1
+		for _, src := range srcs {
+			if src.file.Digest != new.Digest || fileType(src.file.Mode) != fileType(new.Mode) || (src.used > 0 && !copies) {
+				continue
+			}
+			// unused sources and ones of the same name are preferred
+			score := 0
+			if src.used == 0 {
+				score += 1
+			}
+			if sameName(src.file.Path, new.Path) {
+				score += 1
+			}
+			if score > bestScore {
+				best, bestScore = src, score
+				if score == 2 {
+					break
+				}
+			}
+		}
+		if best != nil {
+			pair(best, dst, MaxScore)
+		}
+	}
+	if !copies {
+		// sources and destinations are culled after each step when renaming
+		srcs = slices.DeleteFunc(srcs, func(src *renameSource) bool { return src.used > 0 })
+		if err := findBasenameRenames(cache, changes, srcs, dsts, renames, minScore+(MaxScore-minScore)/2, pair); err != nil {
+			return nil, RenameLimitHit{}, err
+		}
+		srcs = slices.DeleteFunc(srcs, func(src *renameSource) bool { return src.used > 0 })
+	}
+	dsts = slices.DeleteFunc(dsts, func(dst int) bool {
+		_, ok := renames[dst]
+		return ok
+	})
+	var hit RenameLimitHit
+	if limit := opts.RenameLimit; limit > 0 && len(dsts)*len(srcs) > limit*limit {
+		hit.Needed = max(len(srcs), len(dsts))
+		changedSrcs := 0
+		for _, src := range srcs {
+			if src.change != -1 {
+				changedSrcs += 1
+			}
+		}
+		// copies of unchanged files are given up first
+		if !opts.FindCopiesHarder || len(dsts)*changedSrcs > limit*limit {
+			return renamedChanges(changes, renames, renameSrcs), hit, nil
+		}
+		hit.Degraded = true
+	}
+	var candidates []renameCandidate
+	for _, dst := range dsts {
+		// the best few sources, replaced as git does for ties to be broken
+		// the same way
+		var mine []renameCandidate
+		for j, src := range srcs {
+			if hit.Degraded && src.change == -1 {
+				continue
+			}
+			score, err := cache.similarity(src.file, changes[dst].New, minScore)
+			if err != nil {
+				return nil, RenameLimitHit{}, err
+			}
+			c := renameCandidate{src: j, dst: dst, score: score, sameName: sameName(src.file.Path, changes[dst].New.Path)}
+			if len(mine) < candidatesPerFile {
+				mine = append(mine, c)
+				continue
+			}
+			worst := 0
+			for k := 1; k < len(mine); k += 1 {
+				if mine[k].worse(&mine[worst]) {
+					worst = k
+				}
+			}
+			if mine[worst].worse(&c) {
+				mine[worst] = c
+			}
+		}
+		candidates = append(candidates, mine...)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[j].worse(&candidates[i])
+	})
+	// renames first, then copies
+	for _, copying := range []bool{false, true} {
+		if copying && !copies {
+			break
+		}
+		for _, c := range candidates {
+			if c.score < minScore {
+				break
+			}
+			if _, ok := renames[c.dst]; ok || (!copying && srcs[c.src].used > 0) {
+				continue
+			}
+			pair(srcs[c.src], c.dst, c.score)
+		}
+	}
+	return renamedChanges(changes, renames, renameSrcs), hit, nil
+}
+
+// Pairs sources and added files whose names are unique among them and
+// alike, by content at least minScore similar
+func findBasenameRenames(cache *similarityCache, changes []Change, srcs []*renameSource, dsts []int, renames map[int]Change, minScore int, pair func(*renameSource, int, int)) error {
+	// by name, the index of the only file of it or -1
+	srcNames, dstNames := make(map[string]int), make(map[string]int)
+	for i, src := range srcs {
+		name := path.Base(src.file.Path)
+		if _, ok := srcNames[name]; ok {
+			srcNames[name] = -1
+		} else {
+			srcNames[name] = i
+		}
+	}
+	for _, dst := range dsts {
+		if _, ok := renames[dst]; ok {
+			continue
+		}
+		name := path.Base(changes[dst].New.Path)
+		if _, ok := dstNames[name]; ok {
+			dstNames[name] = -1
+		} else {
+			dstNames[name] = dst
+		}
+	}
+	for i, src := range srcs {
+		name := path.Base(src.file.Path)
+		dst, ok := dstNames[name]
+		if !ok || dst == -1 || srcNames[name] != i {
+			continue
+		}
+		if _, ok := renames[dst]; ok {
+			continue
+		}
+		score, err := cache.similarity(src.file, changes[dst].New, minScore)
+		if err != nil {
+			return err
+		}
+		if score >= minScore {
+			pair(src, dst, score)
+		}
+	}
+	return nil
+}
+
+// Changes with the renames and copies in place of the added files and the
+// deleted files renamed left out. Of the files made from a deleted file,
+// the last is its rename and the others are copies
+func renamedChanges(changes []Change, renames map[int]Change, renameSrcs map[int]*renameSource) []Change {
+	renamed := make(map[int]bool)
+	for _, src := range renameSrcs {
+		if src.change != -1 && changes[src.change].Status == Deleted {
+			renamed[src.change] = true
+		}
+	}
+	res := make([]Change, 0, len(changes))
+	for i, change := range changes {
+		if rename, ok := renames[i]; ok {
+			src := renameSrcs[i]
+			src.used -= 1
+			rename.Status = Renamed
+			if src.used > 0 {
+				rename.Status = Copied
+			}
+			res = append(res, rename)
+			continue
+		}
+		if renamed[i] {
+			continue
+		}
+		res = append(res, change)
+	}
+	return res
+}
+
+func sameName(a string, b string) bool {
+	return path.Base(a) == path.Base(b)
+}
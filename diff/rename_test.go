@@ -0,0 +1,193 @@
+package diff_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/magnickolas/gitok/diff"
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/index"
+	"github.com/magnickolas/gitok/index/parser"
+	"github.com/magnickolas/gitok/repr"
+)
+
+// Numbered lines of the prefix, with the lines matching the pattern
+// replaced
+func numberedLines(prefix string, replaced string, by string) string {
+	var b strings.Builder
+	for i := 1; i <= 20; i += 1 {
+		line := fmt.Sprintf("%v%v", prefix, i)
+		if replaced != "" && regexp.MustCompile("^"+replaced+"$").MatchString(line) {
+			line = by
+		}
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
+
+// Writes the trees of the files, by their slash-separated paths, to a
+// repository in a temporary directory, and returns the changes between
+// them with the files of the old tree
+func setupChanges(t *testing.T, oldFiles, newFiles map[string]string) ([]diff.Change, []diff.File) {
+	dir := t.TempDir()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+	if err := os.MkdirAll(filepath.Join(".git", "objects"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	var trees []string
+	for _, files := range []map[string]string{oldFiles, newFiles} {
+		var entries []parser.Entry
+		for p, content := range files {
+			blob, err := repr.NewBlob(strings.NewReader(content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := fs.WriteObject(blob); err != nil {
+				t.Fatal(err)
+			}
+			entries = append(entries, parser.Entry{Mode: 0100644, Digest: blob.Digest(), Name: p})
+		}
+		tree, err := index.WriteTree(entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trees = append(trees, tree)
+	}
+	changes, err := diff.Trees(fs.Default, trees[0], trees[1], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	olds, err := diff.TreeFiles(fs.Default, trees[0], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return changes, olds
+}
+
+// Files before and after renames, copies and changes of every kind
+var (
+	renameOldFiles = map[string]string{
+		"keep":       numberedLines("K", "", ""),
+		"mod":        numberedLines("M", "", ""),
+		"del":        numberedLines("D", "", ""),
+		"same":       numberedLines("S", "", ""),
+		"dir/base.c": numberedLines("E", "", ""),
+		"dir/sub/a":  numberedLines("A", "", ""),
+	}
+	renameNewFiles = map[string]string{
+		"keep":         numberedLines("K", "", ""),
+		"mod":          numberedLines("M", "M5", "changed"),
+		"ren":          numberedLines("D", "D20", "X"),
+		"same2":        numberedLines("S", "", ""),
+		"copy_of_keep": numberedLines("K", "", "") + "extra\n",
+		"copy_of_mod":  numberedLines("M", "M9", "other"),
+		"other/base.c": numberedLines("E", "E[1-6]", "x"),
+		"dir/sub/a":    numberedLines("A", "A1[0-9]", "y"),
+		"dir/sub/b":    "b\n",
+		"fresh":        "fresh\n",
+	}
+)
+
+func TestParseScore(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+	}{
+		{s: "50%", want: diff.MaxScore / 2},
+		{s: "5", want: diff.MaxScore / 2},
+		{s: "0.5", want: diff.MaxScore / 2},
+		{s: "75", want: diff.MaxScore * 3 / 4},
+		{s: "0.5%", want: diff.MaxScore / 200},
+		{s: "100%", want: diff.MaxScore},
+		{s: "120%", want: diff.MaxScore},
+		{s: "", want: 0},
+	}
+	for _, test := range tests {
+		got, err := diff.ParseScore(test.s)
+		if err != nil {
+			t.Errorf("failed to parse %#v: %v", test.s, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("incorrect result for %#v: wanted %#v, got %#v", test.s, test.want, got)
+		}
+	}
+	for _, s := range []string{"50%%", "x", "1.2.3"} {
+		if _, err := diff.ParseScore(s); err == nil {
+			t.Errorf("expected an error for %#v", s)
+		}
+	}
+}
+
+func TestFindRenames(t *testing.T) {
+	changes, olds := setupChanges(t, renameOldFiles, renameNewFiles)
+	tests := []struct {
+		name string
+		opts diff.Options
+		want string
+		// warning about the rename limit
+		wantWarning string
+	}{
+		{
+			name: "renames",
+			opts: diff.Options{Detect: diff.DetectRenames},
+			want: "A\tcopy_of_keep\nA\tcopy_of_mod\nM\tdir/sub/a\nA\tdir/sub/b\nA\tfresh\nM\tmod\n" +
+				"R074\tdir/base.c\tother/base.c\nR094\tdel\tren\nR100\tsame\tsame2\n",
+		},
+		{
+			name: "copies",
+			opts: diff.Options{Detect: diff.DetectCopies},
+			want: "A\tcopy_of_keep\nC091\tmod\tcopy_of_mod\nM\tdir/sub/a\nA\tdir/sub/b\nA\tfresh\nM\tmod\n" +
+				"R074\tdir/base.c\tother/base.c\nR094\tdel\tren\nR100\tsame\tsame2\n",
+		},
+		{
+			name: "copies of unchanged files",
+			opts: diff.Options{Detect: diff.DetectCopies, FindCopiesHarder: true},
+			want: "C092\tkeep\tcopy_of_keep\nC091\tmod\tcopy_of_mod\nM\tdir/sub/a\nA\tdir/sub/b\nA\tfresh\nM\tmod\n" +
+				"R074\tdir/base.c\tother/base.c\nR094\tdel\tren\nR100\tsame\tsame2\n",
+		},
+		{
+			name: "high score",
+			opts: diff.Options{Detect: diff.DetectRenames, RenameScore: diff.MaxScore * 9 / 10},
+			want: "A\tcopy_of_keep\nA\tcopy_of_mod\nD\tdir/base.c\nM\tdir/sub/a\nA\tdir/sub/b\nA\tfresh\nM\tmod\n" +
+				"A\tother/base.c\nR094\tdel\tren\nR100\tsame\tsame2\n",
+		},
+		{
+			name: "rename limit",
+			opts: diff.Options{Detect: diff.DetectRenames, RenameLimit: 1},
+			want: "A\tcopy_of_keep\nA\tcopy_of_mod\nD\tdel\nD\tdir/base.c\nM\tdir/sub/a\nA\tdir/sub/b\nA\tfresh\nM\tmod\n" +
+				"A\tother/base.c\nA\tren\nR100\tsame\tsame2\n",
+			wantWarning: "warning: exhaustive rename detection was skipped due to too many files.\n" +
+				"warning: you may want to set your diff.renameLimit variable to at least 6 and retry the command.\n",
+		},
+	}
+	for _, test := range tests {
+		res, hit, err := diff.FindRenames(fs.Default, changes, olds, test.opts)
+		if err != nil {
+			t.Errorf("failed to find the renames of %v: %v", test.name, err)
+			continue
+		}
+		var b strings.Builder
+		if err := diff.Write(&b, fs.Default, res, diff.Options{Format: diff.FormatNameStatus}); err != nil {
+			t.Errorf("failed to write the renames of %v: %v", test.name, err)
+			continue
+		}
+		if got := b.String(); got != test.want {
+			t.Errorf("incorrect result for %#v: wanted %#v, got %#v", test.name, test.want, got)
+		}
+		if got := hit.Warning(); got != test.wantWarning {
+			t.Errorf("incorrect result for %#v: wanted %#v, got %#v", test.name, test.wantWarning, got)
+		}
+	}
+}
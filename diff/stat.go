@@ -0,0 +1,318 @@
+package diff
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/magnickolas/gitok/color"
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/quote"
+)
+
+const DefaultStatWidth = 80
+
+type fileStat struct {
+	// path as shown, renames with both names
+	name string
+	// path of the new side, the old one if deleted
+	path           string
+	added, deleted int
+	// for binary files the counts are the sizes in bytes
+	binary bool
+	// conflicts are shown without counts and left out of the summary
+	unmerged bool
+}
+
+func computeStats(db *fs.ObjectDB, changes []Change, opts Options) ([]fileStat, error) {
+	res := make([]fileStat, 0, len(changes))
+	for _, change := range changes {
+		stat := fileStat{name: quote.CQuote(change.Path()), path: change.Path()}
+		if change.Status == Renamed || change.Status == Copied {
+			stat.name = renameName(change.Old.Path, change.New.Path)
+		}
+		if change.Status == Unmerged {
+			stat.unmerged = true
+		} else if change.Old.Digest != change.New.Digest {
+			oldContent, err := readContent(db, change.Old)
+			if err != nil {
+				return nil, err
+			}
+			newContent, err := readContent(db, change.New)
+			if err != nil {
+				return nil, err
+			}
+			if IsBinary(oldContent) || IsBinary(newContent) {
+				stat.binary = true
+				stat.added, stat.deleted = len(newContent), len(oldContent)
+			} else {
+				stat.added, stat.deleted = countLines(SplitLines(oldContent), SplitLines(newContent), opts)
+				// modifications whose changes are all ignored are left out
+				if stat.added+stat.deleted == 0 && change.Status == Modified && change.Old.Mode == change.New.Mode {
+					continue
+				}
+			}
+		} else {
+			// binary files with only their names or modes changed still
+			// show as binary
+			content, err := readContent(db, change.New)
+			if err != nil {
+				return nil, err
+			}
+			stat.binary = IsBinary(content)
+		}
+		res = append(res, stat)
+	}
+	return res, nil
+}
+
+// Lines added and deleted in the hunks of the diff between lines a and b
+func countLines(a []string, b []string, opts Options) (int, int) {
+	added, deleted := 0, 0
+	for _, h := range hunks(changes(a, b, opts), opts.Context) {
+		for _, c := range h {
+			added += c.count2
+			deleted += c.count1
+		}
+	}
+	return added, deleted
+}
+
+// Name of a renamed file with the common leading and trailing directories
+// factored out, as in "dir/{old => new}/file"
+func renameName(a string, b string) string {
+	qa, qb := quote.CQuote(a), quote.CQuote(b)
+	if qa != a || qb != b {
+		return qa + " => " + qb
+	}
+	prefix := 0
+	for i := 0; i < len(a) && i < len(b) && a[i] == b[i]; i += 1 {
+		if a[i] == '/' {
+			prefix = i + 1
+		}
+	}
+	// the suffix may reach back into the prefix by its slash; the strings'
+	// ends compare equal as the terminating NULs do in git
+	at := func(s string, i int) byte {
+		if i == len(s) {
+			return 0
+		}
+		return s[i]
+	}
+	adjust := 0
+	if prefix > 0 {
+		adjust = 1
+	}
+	suffix := 0
+	for i, j := len(a), len(b); prefix-adjust <= i && prefix-adjust <= j && at(a, i) == at(b, j); i, j = i-1, j-1 {
+		if at(a, i) == '/' {
+			suffix = len(a) - i
+		}
+	}
+	aMid := max(len(a)-prefix-suffix, 0)
+	bMid := max(len(b)-prefix-suffix, 0)
+	if prefix+suffix == 0 {
+		return a + " => " + b
+	}
+	return a[:prefix] + "{" + a[prefix:prefix+aMid] + " => " + b[prefix:prefix+bMid] + "}" + a[len(a)-suffix:]
+}
+
+// Writes a diffstat of the changes: a line per file with the number of
+// changed lines and a histogram scaled to the width, then a summary
+func WriteStat(w io.Writer, db *fs.ObjectDB, changes []Change, opts Options) error {
+	stats, err := computeStats(db, changes, opts)
+	if err != nil {
+		return err
+	}
+	return writeStat(w, stats, opts)
+}
+
+func writeStat(w io.Writer, stats []fileStat, opts Options) error {
+	if len(stats) == 0 {
+		return nil
+	}
+	width := opts.StatWidth
+	if width <= 0 {
+		width = DefaultStatWidth
+	}
+	maxLen, maxChange, binWidth, numberWidth := 0, 0, 0, 0
+	for _, stat := range stats {
+		maxLen = max(maxLen, utf8.RuneCountInString(stat.name))
+		if stat.unmerged {
+			continue
+		}
+		if stat.binary {
+			// "Bin XXX -> YYY bytes"
+			binWidth = max(binWidth, 14+decimalWidth(stat.added)+decimalWidth(stat.deleted))
+			numberWidth = 3
+			continue
+		}
+		maxChange = max(maxChange, stat.added+stat.deleted)
+	}
+	numberWidth = max(numberWidth, decimalWidth(maxChange))
+	width = max(width, 16+6+numberWidth)
+	graphWidth := maxChange
+	if maxChange+4 <= binWidth {
+		graphWidth = binWidth - 4
+	}
+	nameWidth := maxLen
+	if nameWidth+numberWidth+6+graphWidth > width {
+		if graphWidth > width*3/8-numberWidth-6 {
+			graphWidth = max(width*3/8-numberWidth-6, 6)
+		}
+		if nameWidth > width-numberWidth-6-graphWidth {
+			nameWidth = width - numberWidth - 6 - graphWidth
+		} else {
+			graphWidth = width - numberWidth - 6 - nameWidth
+		}
+	}
+	var colors Palette
+	reset := ""
+	if opts.Colors != nil {
+		colors, reset = *opts.Colors, color.Reset
+	}
+	graph := func(set string, c string, n int) string {
+		if n <= 0 {
+			return ""
+		}
+		return set + strings.Repeat(c, n) + reset
+	}
+	for _, stat := range stats {
+		prefix, name := "", stat.name
+		length := nameWidth
+		if nameLen := utf8.RuneCountInString(name); nameWidth < nameLen {
+			prefix = "..."
+			length = max(length-3, 0)
+			runes := []rune(name)
+			name = string(runes[nameLen-length:])
+			if i := strings.IndexByte(name, '/'); i != -1 {
+				name = name[i:]
+			}
+		}
+		padding := max(length-utf8.RuneCountInString(name), 0)
+		if stat.unmerged {
+			if _, err := fmt.Fprintf(w, " %v%v%*v | Unmerged\n", prefix, name, padding, ""); err != nil {
+				return err
+			}
+			continue
+		}
+		if stat.binary {
+			line := fmt.Sprintf(" %v%v%*v | %*v", prefix, name, padding, "", numberWidth, "Bin")
+			if stat.added != 0 || stat.deleted != 0 {
+				line += fmt.Sprintf(" %v%d%v -> %v%d%v bytes", colors.Old, stat.deleted, reset, colors.New, stat.added, reset)
+			}
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+			continue
+		}
+		add, del := stat.added, stat.deleted
+		if graphWidth <= maxChange {
+			total := scaleLinear(add+del, graphWidth, maxChange)
+			if total < 2 && add != 0 && del != 0 {
+				total = 2
+			}
+			if add < del {
+				add = scaleLinear(add, graphWidth, maxChange)
+				del = total - add
+			} else {
+				del = scaleLinear(del, graphWidth, maxChange)
+				add = total - del
+			}
+		}
+		sep := ""
+		if stat.added+stat.deleted != 0 {
+			sep = " "
+		}
+		_, err := fmt.Fprintf(w, " %v%v%*v | %*d%v%v%v\n", prefix, name, padding, "",
+			numberWidth, stat.added+stat.deleted, sep, graph(colors.New, "+", add), graph(colors.Old, "-", del))
+		if err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, summaryLine(stats))
+	return err
+}
+
+// Writes a line per file with the numbers of added and deleted lines, "-"
+// for both of binary files (--numstat)
+func WriteNumStat(w io.Writer, db *fs.ObjectDB, changes []Change, opts Options) error {
+	stats, err := computeStats(db, changes, opts)
+	if err != nil {
+		return err
+	}
+	return writeNumStat(w, stats)
+}
+
+func writeNumStat(w io.Writer, stats []fileStat) error {
+	for _, stat := range stats {
+		var err error
+		if stat.binary {
+			_, err = fmt.Fprintf(w, "-\t-\t%v\n", stat.name)
+		} else {
+			_, err = fmt.Fprintf(w, "%d\t%d\t%v\n", stat.added, stat.deleted, stat.name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes the summary line of the diffstat alone (--shortstat)
+func WriteShortStat(w io.Writer, db *fs.ObjectDB, changes []Change, opts Options) error {
+	stats, err := computeStats(db, changes, opts)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, summaryLine(stats))
+	return err
+}
+
+func scaleLinear(it int, width int, maxChange int) int {
+	if it == 0 {
+		return 0
+	}
+	return 1 + it*(width-1)/maxChange
+}
+
+func decimalWidth(n int) int {
+	return len(strconv.Itoa(n))
+}
+
+// The singular form for a count of one, the plural form otherwise
+func Plural(n int, singular string, plural string) string {
+	if n == 1 {
+		return singular
+	}
+	return plural
+}
+
+// " N files changed, N insertions(+), N deletions(-)", conflicts left out
+// and binary files counted without their sizes
+func summaryLine(stats []fileStat) string {
+	files, adds, dels := 0, 0, 0
+	for _, stat := range stats {
+		if stat.unmerged {
+			continue
+		}
+		files += 1
+		if !stat.binary {
+			adds += stat.added
+			dels += stat.deleted
+		}
+	}
+	if files == 0 {
+		return " 0 files changed"
+	}
+	res := fmt.Sprintf(" %d %v changed", files, Plural(files, "file", "files"))
+	if adds != 0 || dels == 0 {
+		res += fmt.Sprintf(", %d %v(+)", adds, Plural(adds, "insertion", "insertions"))
+	}
+	if dels != 0 || adds == 0 {
+		res += fmt.Sprintf(", %d %v(-)", dels, Plural(dels, "deletion", "deletions"))
+	}
+	return res
+}
@@ -0,0 +1,193 @@
+package diff
+
+import (
+	"strings"
+
+	"github.com/magnickolas/gitok/convert"
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/pathspec"
+	"github.com/magnickolas/gitok/repr"
+)
+
+type Status byte
+
+const (
+	Added       Status = 'A'
+	Deleted     Status = 'D'
+	Modified    Status = 'M'
+	Renamed     Status = 'R'
+	Copied      Status = 'C'
+	TypeChanged Status = 'T'
+	// a path with conflicts in the index, whose sides are not compared
+	Unmerged Status = 'U'
+)
+
+// One side of a change; a missing file has an empty mode and digest
+type File struct {
+	Path   string
+	Mode   repr.ObjectModeType
+	Digest string
+	// read from the worktree, converted by conv, rather than from the
+	// object database
+	worktree bool
+	conv     *convert.Converter
+}
+
+func (f File) Exists() bool {
+	return f.Mode != ""
+}
+
+type Change struct {
+	Status Status
+	Old    File
+	New    File
+	// similarity percentage of renames and copies
+	Score int
+}
+
+// Path the change is known by, the new one for renames and copies
+func (c *Change) Path() string {
+	if c.New.Exists() {
+		return c.New.Path
+	}
+	return c.Old.Path
+}
+
+// Changes of the files between two trees, in tree order and restricted to
+// the pathspec. An empty digest stands for the empty tree. Subtrees with
+// the same digest on both sides are not read
+func Trees(db *fs.ObjectDB, oldTree string, newTree string, ps *pathspec.Pathspec) ([]Change, error) {
+	var res []Change
+	err := diffTrees(db, "", oldTree, newTree, ps, true, &res)
+	return res, err
+}
+
+// Changes of the entries right in two trees, with subtrees compared as a
+// whole rather than recursed into
+func TopLevel(db *fs.ObjectDB, oldTree string, newTree string, ps *pathspec.Pathspec) ([]Change, error) {
+	var res []Change
+	err := diffTrees(db, "", oldTree, newTree, ps, false, &res)
+	return res, err
+}
+
+func readEntries(db *fs.ObjectDB, digest string) ([]repr.TreeEntry, error) {
+	if digest == "" {
+		return nil, nil
+	}
+	tree, err := db.ReadTree(digest)
+	if err != nil {
+		return nil, err
+	}
+	return tree.Entries(), nil
+}
+
+func sortName(e *repr.TreeEntry) string {
+	if e.Mode == repr.ModeTree {
+		return e.Name + "/"
+	}
+	return e.Name
+}
+
+func diffTrees(db *fs.ObjectDB, prefix string, oldTree string, newTree string, ps *pathspec.Pathspec, recursive bool, res *[]Change) error {
+	if oldTree == newTree {
+		return nil
+	}
+	olds, err := readEntries(db, oldTree)
+	if err != nil {
+		return err
+	}
+	news, err := readEntries(db, newTree)
+	if err != nil {
+		return err
+	}
+	i, j := 0, 0
+	for i < len(olds) || j < len(news) {
+		var o, n *repr.TreeEntry
+		switch {
+		case j == len(news):
+			o = &olds[i]
+		case i == len(olds):
+			n = &news[j]
+		default:
+			switch c := strings.Compare(sortName(&olds[i]), sortName(&news[j])); {
+			case c < 0:
+				o = &olds[i]
+			case c > 0:
+				n = &news[j]
+			default:
+				o, n = &olds[i], &news[j]
+			}
+		}
+		if o != nil {
+			i += 1
+		}
+		if n != nil {
+			j += 1
+		}
+		var name string
+		if o != nil {
+			name = o.Name
+		} else {
+			name = n.Name
+		}
+		path := prefix + name
+		isTree := (o != nil && o.Mode == repr.ModeTree) || (n != nil && n.Mode == repr.ModeTree)
+		if isTree && recursive {
+			if !ps.MatchesUnder(path) {
+				continue
+			}
+			var oldDigest, newDigest string
+			if o != nil {
+				oldDigest = o.Digest
+			}
+			if n != nil {
+				newDigest = n.Digest
+			}
+			if err := diffTrees(db, path+"/", oldDigest, newDigest, ps, recursive, res); err != nil {
+				return err
+			}
+			continue
+		}
+		if (isTree && !ps.MatchesUnder(path)) || (!isTree && !ps.Match(path)) {
+			continue
+		}
+		var old, new File
+		if o != nil {
+			old = File{Path: path, Mode: o.Mode, Digest: o.Digest}
+		}
+		if n != nil {
+			new = File{Path: path, Mode: n.Mode, Digest: n.Digest}
+		}
+		if change, ok := compareFiles(old, new); ok {
+			*res = append(*res, change)
+		}
+	}
+	return nil
+}
+
+// Change between the sides of a path, at least one of which exists; false
+// if they are the same
+func compareFiles(old File, new File) (Change, bool) {
+	change := Change{Old: old, New: new}
+	switch {
+	case !old.Exists():
+		change.Status = Added
+	case !new.Exists():
+		change.Status = Deleted
+	case old.Mode == new.Mode && old.Digest == new.Digest:
+		return change, false
+	case fileType(old.Mode) != fileType(new.Mode):
+		change.Status = TypeChanged
+	default:
+		change.Status = Modified
+	}
+	return change, true
+}
+
+// Regular files of either mode are of the same type
+func fileType(mode repr.ObjectModeType) repr.ObjectModeType {
+	if mode == repr.ModeExecutable {
+		return repr.ModeNormal
+	}
+	return mode
+}
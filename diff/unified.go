@@ -0,0 +1,178 @@
+package diff
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+const DefaultContext = 3
+
+// Longest function name shown in hunk headers
+const maxFuncNameLen = 80
+
+// Consecutive changed lines: count1 lines of the old text from line1
+// replaced by count2 lines of the new text from line2
+type change struct {
+	line1, count1 int
+	line2, count2 int
+	// only blank lines changed, with Options.IgnoreBlankLines
+	ignore bool
+}
+
+// Changes of the edit script between lines a and b
+func changes(a []string, b []string, opts Options) []change {
+	var res []change
+	edits := Lines(a, b, opts)
+	for i := 0; i < len(edits); {
+		if edits[i].Op == Equal {
+			i += 1
+			continue
+		}
+		c := change{line1: edits[i].Old, line2: edits[i].New, ignore: opts.IgnoreBlankLines}
+		for ; i < len(edits) && edits[i].Op != Equal; i += 1 {
+			var line string
+			if edits[i].Op == Delete {
+				line = a[edits[i].Old]
+				c.count1 += 1
+			} else {
+				line = b[edits[i].New]
+				c.count2 += 1
+			}
+			c.ignore = c.ignore && isBlankLine(line, opts)
+		}
+		res = append(res, c)
+	}
+	return res
+}
+
+// Groups the changes into hunks with the given context, merging hunks
+// whose contexts touch. Changes of only blank lines that are ignored are
+// left out unless they are close to other changes
+func hunks(changes []change, context int) [][]change {
+	var res [][]change
+	for len(changes) > 0 {
+		// ignored changes too far before the next change are dropped
+		for i := 0; i < len(changes) && changes[i].ignore; i += 1 {
+			if i+1 == len(changes) || changes[i+1].line1-(changes[i].line1+changes[i].count1) >= context {
+				changes = changes[i+1:]
+				i = -1
+			}
+		}
+		if len(changes) == 0 {
+			break
+		}
+		last, ignored := 0, 0
+	grow:
+		for i := 1; i < len(changes); i += 1 {
+			distance := changes[i].line1 - (changes[i-1].line1 + changes[i-1].count1)
+			if distance > 2*context {
+				break
+			}
+			switch {
+			case distance < context && (!changes[i].ignore || last == i-1):
+				last, ignored = i, 0
+			case distance < context && changes[i].ignore:
+				ignored += changes[i].count2
+			case last != i-1 && changes[i].line1+ignored-(changes[last].line1+changes[last].count1) > 2*context:
+				break grow
+			case !changes[i].ignore:
+				last, ignored = i, 0
+			default:
+				ignored += changes[i].count2
+			}
+		}
+		res = append(res, changes[:last+1])
+		changes = changes[last+1:]
+	}
+	return res
+}
+
+// Writes the hunks of a unified diff between lines a and b
+func WriteUnified(w io.Writer, a []string, b []string, opts Options) error {
+	e := newEmitter(w, opts)
+	writeHunks(e, a, b, opts)
+	return e.flush(true)
+}
+
+func writeHunks(e *emitter, a []string, b []string, opts Options) {
+	for _, h := range hunks(changes(a, b, opts), opts.Context) {
+		writeHunk(e, a, b, h, opts.Context)
+	}
+}
+
+// Emits the hunk of the changes with their context; the lines of the
+// context are taken from the new text
+func writeHunk(e *emitter, a []string, b []string, changes []change, context int) {
+	first, last := changes[0], changes[len(changes)-1]
+	s1, s2 := max(first.line1-context, 0), max(first.line2-context, 0)
+	post := min(context, len(a)-(last.line1+last.count1), len(b)-(last.line2+last.count2))
+	e1, e2 := last.line1+last.count1+post, last.line2+last.count2+post
+	e.emit(symbol{
+		kind:     symbolFrag,
+		text:     fmt.Sprintf("@@ -%v +%v @@", hunkRange(s1, e1-s1), hunkRange(s2, e2-s2)),
+		funcName: funcName(a, s1),
+	})
+	// counted from the starts shown in the header, as git does
+	e.lno = [2]int{hunkStart(s1, e1-s1), hunkStart(s2, e2-s2)}
+	line2 := s2
+	for _, c := range changes {
+		for ; line2 < c.line2; line2 += 1 {
+			e.line(symbolContext, b[line2])
+		}
+		if e.opts.WordDiff != WordDiffNone {
+			e.words(a[c.line1:c.line1+c.count1], b[c.line2:c.line2+c.count2])
+			line2 = c.line2 + c.count2
+			continue
+		}
+		for i := c.line1; i < c.line1+c.count1; i += 1 {
+			e.line(symbolMinus, a[i])
+		}
+		for ; line2 < c.line2+c.count2; line2 += 1 {
+			e.line(symbolPlus, b[line2])
+		}
+	}
+	for ; line2 < e2; line2 += 1 {
+		e.line(symbolContext, b[line2])
+	}
+}
+
+// Start of the range as shown in hunk headers
+func hunkStart(start int, count int) int {
+	if count == 0 {
+		return start
+	}
+	return start + 1
+}
+
+// "start,count" with one-based start, as in hunk headers; an empty range
+// starts at the line before it
+func hunkRange(start int, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// Closest line before the given one that starts with a letter, '_' or '$',
+// like git's default function name detection
+func funcName(lines []string, before int) string {
+	for i := min(before, len(lines)) - 1; i >= 0; i -= 1 {
+		line := lines[i]
+		if line == "" {
+			continue
+		}
+		c := line[0]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_' || c == '$') {
+			continue
+		}
+		if len(line) > maxFuncNameLen {
+			line = line[:maxFuncNameLen]
+		}
+		return strings.TrimRight(line, " \t\n\v\f\r")
+	}
+	return ""
+}
@@ -0,0 +1,46 @@
+package diff_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/magnickolas/gitok/diff"
+)
+
+func TestWriteUnified(t *testing.T) {
+	numbers := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"
+	changed := "1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\neleven"
+	tests := []struct {
+		a, b    string
+		context int
+		want    string
+	}{
+		{a: "x\n", b: "y\n", context: 3, want: "@@ -1 +1 @@\n-x\n+y\n"},
+		{a: "", b: "a\nb\n", context: 3, want: "@@ -0,0 +1,2 @@\n+a\n+b\n"},
+		{a: "same\n", b: "same\n", context: 3, want: ""},
+		{
+			a: numbers, b: changed, context: 3,
+			want: "@@ -1,6 +1,6 @@\n 1\n 2\n-3\n+three\n 4\n 5\n 6\n" +
+				"@@ -8,3 +8,4 @@\n 8\n 9\n 10\n+eleven\n\\ No newline at end of file\n",
+		},
+		{
+			a: numbers, b: changed, context: 1,
+			want: "@@ -2,3 +2,3 @@\n 2\n-3\n+three\n 4\n" +
+				"@@ -10 +10,2 @@\n 10\n+eleven\n\\ No newline at end of file\n",
+		},
+		{
+			a: "func f() {\n\ta\n\tb\n\tc\n\td\n\te\n}\n", b: "func f() {\n\ta\n\tb\n\tc\n\td\n\tE\n}\n", context: 1,
+			want: "@@ -5,3 +5,3 @@ func f() {\n \td\n-\te\n+\tE\n }\n",
+		},
+	}
+	for _, test := range tests {
+		var b strings.Builder
+		if err := diff.WriteUnified(&b, diff.SplitLines([]byte(test.a)), diff.SplitLines([]byte(test.b)), diff.Options{Context: test.context}); err != nil {
+			t.Errorf("failed to diff %#v and %#v: %v", test.a, test.b, err)
+			continue
+		}
+		if got := b.String(); got != test.want {
+			t.Errorf("incorrect result for %#v and %#v: wanted %#v, got %#v", test.a, test.b, test.want, got)
+		}
+	}
+}
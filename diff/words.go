@@ -0,0 +1,191 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/magnickolas/gitok/color"
+)
+
+var (
+	ErrorUnknownWordDiff       = errors.New("bad --word-diff argument")
+	formatErrorUnknownWordDiff = func(mode string) error {
+		return fmt.Errorf("%w: %v", ErrorUnknownWordDiff, mode)
+	}
+)
+
+// How changed lines are shown word by word instead of line by line
+type WordDiff int
+
+const (
+	WordDiffNone WordDiff = iota
+	// removed words in "[-...-]" and added ones in "{+...+}"
+	WordDiffPlain
+	// removed and added words only told apart by their colors
+	WordDiffColor
+	// a line per run of words starting with "-", "+" or " ", and "~" for
+	// line breaks
+	WordDiffPorcelain
+)
+
+var wordDiffNames = map[string]WordDiff{
+	"none":      WordDiffNone,
+	"plain":     WordDiffPlain,
+	"color":     WordDiffColor,
+	"porcelain": WordDiffPorcelain,
+}
+
+func ParseWordDiff(mode string) (WordDiff, error) {
+	if res, ok := wordDiffNames[mode]; ok {
+		return res, nil
+	}
+	return WordDiffNone, formatErrorUnknownWordDiff(mode)
+}
+
+// How one kind of text of a word diff is marked
+type wordStyle struct {
+	prefix, suffix string
+	color          string
+}
+
+// Writes the word diff of the removed and the added lines: the text of
+// the added lines with the removed and added words marked
+func (e *emitter) words(removed []string, added []string) {
+	var oldStyle, newStyle, ctxStyle wordStyle
+	newline := "\n"
+	switch e.opts.WordDiff {
+	case WordDiffPlain:
+		oldStyle, newStyle = wordStyle{prefix: "[-", suffix: "-]"}, wordStyle{prefix: "{+", suffix: "+}"}
+	case WordDiffPorcelain:
+		oldStyle, newStyle = wordStyle{prefix: "-", suffix: "\n"}, wordStyle{prefix: "+", suffix: "\n"}
+		ctxStyle = wordStyle{prefix: " ", suffix: "\n"}
+		newline = "~\n"
+	}
+	oldStyle.color, newStyle.color, ctxStyle.color = e.colors.Old, e.colors.New, e.colors.Context
+	minus, plus := joinLines(removed), joinLines(added)
+	var b strings.Builder
+	if plus == "" {
+		writeWords(&b, oldStyle, newline, minus)
+		e.emit(symbol{kind: symbolPlain, text: b.String()})
+		return
+	}
+	minusWords, plusWords := splitWords(minus, e.opts.WordRegex), splitWords(plus, e.opts.WordRegex)
+	wordLines := func(text string, words [][2]int) []string {
+		res := make([]string, len(words))
+		for i, word := range words {
+			res[i] = text[word[0]:word[1]] + "\n"
+		}
+		return res
+	}
+	// the text around the changed words is taken from the added lines
+	current := 0
+	for _, c := range changes(wordLines(minus, minusWords), wordLines(plus, plusWords), Options{}) {
+		minusBegin, minusEnd := wordSpan(minusWords, c.line1, c.count1)
+		plusBegin, plusEnd := wordSpan(plusWords, c.line2, c.count2)
+		writeWords(&b, ctxStyle, newline, plus[current:plusBegin])
+		writeWords(&b, oldStyle, newline, minus[minusBegin:minusEnd])
+		writeWords(&b, newStyle, newline, plus[plusBegin:plusEnd])
+		current = plusEnd
+	}
+	writeWords(&b, ctxStyle, newline, plus[current:])
+	e.emit(symbol{kind: symbolPlain, text: b.String()})
+}
+
+// Lines each ended by a line break
+func joinLines(lines []string) string {
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(line)
+		if !strings.HasSuffix(line, "\n") {
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// Offsets in the text from the first of the count words from i to the
+// last; an empty span is at the end of the word before
+func wordSpan(words [][2]int, i int, count int) (int, int) {
+	switch {
+	case count > 0:
+		return words[i][0], words[i+count-1][1]
+	case i > 0:
+		return words[i-1][1], words[i-1][1]
+	}
+	return 0, 0
+}
+
+// Writes the text in the style, with each of its lines marked apart
+func writeWords(b *strings.Builder, style wordStyle, newline string, text string) {
+	for text != "" {
+		line, rest, found := strings.Cut(text, "\n")
+		if line != "" {
+			if style.color != "" {
+				b.WriteString(style.color)
+			}
+			b.WriteString(style.prefix + line + style.suffix)
+			if style.color != "" {
+				b.WriteString(color.Reset)
+			}
+		}
+		if !found {
+			return
+		}
+		b.WriteString(newline)
+		text = rest
+	}
+}
+
+// Offsets of the words of the text: the matches of the regexp cut at line
+// breaks, or runs of anything but whitespace
+func splitWords(text string, re *regexp.Regexp) [][2]int {
+	var res [][2]int
+	for i := 0; i < len(text); i += 1 {
+		begin, end, ok := nextWord(text, re, i)
+		if !ok {
+			break
+		}
+		res = append(res, [2]int{begin, end})
+		i = end - 1
+	}
+	return res
+}
+
+func nextWord(text string, re *regexp.Regexp, begin int) (int, int, bool) {
+	if re != nil {
+		for begin < len(text) {
+			loc := re.FindStringIndex(text[begin:])
+			if loc == nil {
+				return 0, 0, false
+			}
+			end := begin + loc[1]
+			if i := strings.IndexByte(text[begin+loc[0]:end], '\n'); i != -1 {
+				end = begin + loc[0] + i
+			}
+			begin += loc[0]
+			if begin != end {
+				return begin, end, true
+			}
+			// empty matches are skipped
+			begin += 1
+		}
+		return 0, 0, false
+	}
+	for begin < len(text) && isWordSpace(text[begin]) {
+		begin += 1
+	}
+	if begin == len(text) {
+		return 0, 0, false
+	}
+	end := begin + 1
+	for end < len(text) && !isWordSpace(text[end]) {
+		end += 1
+	}
+	return begin, end, true
+}
+
+func isWordSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\v' || c == '\f' || c == '\r'
+}
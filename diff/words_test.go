@@ -0,0 +1,71 @@
+package diff_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/magnickolas/gitok/diff"
+)
+
+func TestWordDiff(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		mode  diff.WordDiff
+		regex string
+		want  string
+	}{
+		{
+			a: "the quick fox\n", b: "the slow fox\n", mode: diff.WordDiffPlain,
+			want: "@@ -1 +1 @@\nthe [-quick-]{+slow+} fox\n",
+		},
+		{
+			a: "a\nold line\nb\n", b: "a\nb\n", mode: diff.WordDiffPlain,
+			want: "@@ -1,3 +1,2 @@\na\n[-old line-]\nb\n",
+		},
+		{
+			a: "x = f(a)\n", b: "x = f(b)\n", mode: diff.WordDiffPorcelain, regex: "[a-z]+|[^[:space:]]",
+			want: "@@ -1 +1 @@\n x = f(\n-a\n+b\n )\n~\n",
+		},
+	}
+	for _, test := range tests {
+		opts := diff.Options{Context: diff.DefaultContext, WordDiff: test.mode}
+		if test.regex != "" {
+			opts.WordRegex = regexp.MustCompilePOSIX(test.regex)
+		}
+		var b strings.Builder
+		if err := diff.WriteUnified(&b, diff.SplitLines([]byte(test.a)), diff.SplitLines([]byte(test.b)), opts); err != nil {
+			t.Errorf("failed to diff %#v and %#v: %v", test.a, test.b, err)
+			continue
+		}
+		if got := b.String(); got != test.want {
+			t.Errorf("incorrect result for %#v and %#v: wanted %#v, got %#v", test.a, test.b, test.want, got)
+		}
+	}
+}
+
+func TestParseColorMovedWS(t *testing.T) {
+	tests := []struct {
+		modes string
+		want  diff.MovedWS
+	}{
+		{modes: "no", want: 0},
+		{modes: "ignore-space-at-eol, ignore-space-change", want: diff.MovedIgnoreSpaceAtEOL | diff.MovedIgnoreSpaceChange},
+		{modes: "ignore-all-space,no,allow-indentation-change", want: diff.MovedAllowIndentationChange},
+	}
+	for _, test := range tests {
+		got, err := diff.ParseColorMovedWS(test.modes)
+		if err != nil {
+			t.Errorf("failed to parse %#v: %v", test.modes, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("incorrect result for %#v: wanted %#v, got %#v", test.modes, test.want, got)
+		}
+	}
+	for _, modes := range []string{"ignore-tabs", "allow-indentation-change,ignore-all-space"} {
+		if _, err := diff.ParseColorMovedWS(modes); err == nil {
+			t.Errorf("expected an error for %#v", modes)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package diff
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/magnickolas/gitok/convert"
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/index/parser"
+	"github.com/magnickolas/gitok/pathspec"
+	"github.com/magnickolas/gitok/repr"
+	"github.com/magnickolas/gitok/submodule"
+	"github.com/magnickolas/gitok/worktree"
+)
+
+// How worktree files are compared with the index
+type WorktreeOptions struct {
+	// core.fileMode: whether the executable bits of files are trusted
+	TrustFileMode bool
+	// converts files to what they would be stored as
+	Converter *convert.Converter
+}
+
+func indexFile(entry *parser.Entry) File {
+	return File{Path: entry.Name, Mode: entry.ObjectMode(), Digest: entry.Digest}
+}
+
+// The worktree side of an index entry: the entry itself if the file did not
+// change, a missing file if it was deleted. Changed submodules are known by
+// their HEAD
+func worktreeFile(entry *parser.Entry, opts WorktreeOptions) (File, error) {
+	status, err := worktree.CheckEntry(entry, opts.TrustFileMode, opts.Converter)
+	if err != nil {
+		return File{}, err
+	}
+	switch status {
+	case worktree.Unchanged:
+		return indexFile(entry), nil
+	case worktree.Deleted:
+		return File{Path: entry.Name}, nil
+	}
+	path := filepath.FromSlash(entry.Name)
+	info, err := os.Lstat(path)
+	if err != nil {
+		return File{}, err
+	}
+	f := File{Path: entry.Name, Mode: worktree.FileMode(info), worktree: true, conv: opts.Converter}
+	if entry.ObjectMode() == repr.ModeGitlink && info.IsDir() {
+		f.Mode = repr.ModeGitlink
+		if gitDir, err := submodule.GitDir(path); err == nil {
+			f.Digest, _ = submodule.Head(gitDir)
+		}
+		return f, nil
+	}
+	if !opts.TrustFileMode && fileType(f.Mode) == repr.ModeNormal && fileType(entry.ObjectMode()) == repr.ModeNormal {
+		f.Mode = entry.ObjectMode()
+	}
+	if f.Digest, err = worktree.HashFile(path, info, opts.Converter); err != nil {
+		return File{}, err
+	}
+	return f, nil
+}
+
+// Index entries of a path: the merged one or the stages of a conflict
+type indexPath struct {
+	name    string
+	entries []parser.Entry
+}
+
+func (p *indexPath) unmerged() bool {
+	return p.entries[0].Stage() != 0
+}
+
+// The stage of a conflict the path has, nil if none
+func (p *indexPath) stage(stage int) *parser.Entry {
+	for i := range p.entries {
+		if p.entries[i].Stage() == stage {
+			return &p.entries[i]
+		}
+	}
+	return nil
+}
+
+// Index entries grouped by path, restricted to the pathspec
+func indexPaths(entries []parser.Entry, ps *pathspec.Pathspec) []indexPath {
+	var res []indexPath
+	for i := 0; i < len(entries); {
+		j := i + 1
+		for j < len(entries) && entries[j].Name == entries[i].Name {
+			j += 1
+		}
+		if ps.Match(entries[i].Name) {
+			res = append(res, indexPath{name: entries[i].Name, entries: entries[i:j]})
+		}
+		i = j
+	}
+	return res
+}
+
+// Files of a tree in index order, restricted to the pathspec
+func TreeFiles(db *fs.ObjectDB, tree string, ps *pathspec.Pathspec) ([]File, error) {
+	changes, err := Trees(db, "", tree, ps)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]File, len(changes))
+	for i, change := range changes {
+		res[i] = change.New
+	}
+	return res, nil
+}
+
+// Merged files of the index, restricted to the pathspec
+func IndexFiles(entries []parser.Entry, ps *pathspec.Pathspec) []File {
+	var res []File
+	for _, p := range indexPaths(entries, ps) {
+		if !p.unmerged() {
+			res = append(res, indexFile(&p.entries[0]))
+		}
+	}
+	return res
+}
+
+// Changes from the files of a tree to the sides of the index paths given
+// by side, in index order; an unmerged path is an Unmerged change if
+// side gives a missing file for it
+func compareTree(db *fs.ObjectDB, tree string, paths []indexPath, ps *pathspec.Pathspec, side func(p *indexPath) (File, error)) ([]Change, error) {
+	olds, err := TreeFiles(db, tree, ps)
+	if err != nil {
+		return nil, err
+	}
+	var res []Change
+	i, j := 0, 0
+	for i < len(olds) || j < len(paths) {
+		var old, new File
+		var p *indexPath
+		switch {
+		case j == len(paths) || (i < len(olds) && olds[i].Path < paths[j].name):
+			old = olds[i]
+			i += 1
+		default:
+			p = &paths[j]
+			j += 1
+			if i < len(olds) && olds[i].Path == p.name {
+				old = olds[i]
+				i += 1
+			}
+			if new, err = side(p); err != nil {
+				return nil, err
+			}
+		}
+		if p != nil && p.unmerged() && !new.Exists() {
+			res = append(res, Change{Status: Unmerged, Old: old, New: File{Path: p.name}})
+			continue
+		}
+		if !old.Exists() && !new.Exists() {
+			continue
+		}
+		if change, ok := compareFiles(old, new); ok {
+			res = append(res, change)
+		}
+	}
+	return res, nil
+}
+
+// Changes from a tree to the index, in index order and restricted to the
+// pathspec; paths with conflicts are Unmerged changes
+func TreeIndex(db *fs.ObjectDB, tree string, entries []parser.Entry, ps *pathspec.Pathspec) ([]Change, error) {
+	return compareTree(db, tree, indexPaths(entries, ps), ps, func(p *indexPath) (File, error) {
+		if p.unmerged() {
+			return File{Path: p.name}, nil
+		}
+		return indexFile(&p.entries[0]), nil
+	})
+}
+
+// Changes from a tree to the worktree files in the index, in index order
+// and restricted to the pathspec
+func TreeWorktree(db *fs.ObjectDB, tree string, entries []parser.Entry, ps *pathspec.Pathspec, opts WorktreeOptions) ([]Change, error) {
+	return compareTree(db, tree, indexPaths(entries, ps), ps, func(p *indexPath) (File, error) {
+		if p.unmerged() {
+			return unmergedWorktreeFile(p, opts)
+		}
+		return worktreeFile(&p.entries[0], opts)
+	})
+}
+
+// Changes from the index to the worktree files in it, in index order and
+// restricted to the pathspec; a path with conflicts is an Unmerged change
+// followed by the change from its "ours" stage, if any
+func IndexWorktree(entries []parser.Entry, ps *pathspec.Pathspec, opts WorktreeOptions) ([]Change, error) {
+	var res []Change
+	for _, p := range indexPaths(entries, ps) {
+		entry := &p.entries[0]
+		if p.unmerged() {
+			f, err := unmergedWorktreeFile(&p, opts)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, Change{Status: Unmerged, Old: File{Path: p.name}, New: File{Path: p.name, Mode: f.Mode}})
+			if entry = p.stage(2); entry == nil {
+				continue
+			}
+		}
+		old := indexFile(entry)
+		new, err := worktreeFile(entry, opts)
+		if err != nil {
+			return nil, err
+		}
+		if change, ok := compareFiles(old, new); ok {
+			res = append(res, change)
+		}
+	}
+	return res, nil
+}
+
+// The worktree file of a path with conflicts, missing if there is none
+func unmergedWorktreeFile(p *indexPath, opts WorktreeOptions) (File, error) {
+	path := filepath.FromSlash(p.name)
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && info.IsDir()) {
+		return File{Path: p.name}, nil
+	} else if err != nil {
+		return File{}, err
+	}
+	f := File{Path: p.name, Mode: worktree.FileMode(info), worktree: true, conv: opts.Converter}
+	if f.Digest, err = worktree.HashFile(path, info, opts.Converter); err != nil {
+		return File{}, err
+	}
+	return f, nil
+}
@@ -0,0 +1,198 @@
+package fs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/magnickolas/gitok/repr"
+)
+
+var (
+	ErrorObjectNotFound       = errors.New("object not found")
+	formatErrorObjectNotFound = func(digest string) error {
+		return fmt.Errorf("%w: %v", ErrorObjectNotFound, digest)
+	}
+)
+
+// Handle to an objects directory. It is meant to be opened once and shared
+// by all the reads and writes of a process
+type ObjectDB struct {
+	dir         string
+	packs       []*pack
+	packsLoaded bool
+}
+
+func OpenObjectDB(objectsDir string) *ObjectDB {
+	return &ObjectDB{dir: objectsDir}
+}
+
+func (db *ObjectDB) Dir() string {
+	return db.dir
+}
+
+func (db *ObjectDB) objectDirPath(digest string) string {
+	return filepath.Join(db.dir, digest[:2])
+}
+
+func (db *ObjectDB) objectFilePath(digest string) string {
+	return filepath.Join(db.objectDirPath(digest), digest[2:])
+}
+
+func (db *ObjectDB) ReadObject(digest string) (repr.Object, error) {
+	if !repr.IsValidDigest(digest) {
+		return nil, formatErrorObjectNotFound(digest)
+	}
+	compressed, err := os.ReadFile(db.objectFilePath(digest))
+	if errors.Is(err, os.ErrNotExist) {
+		p, offset, err := db.findPacked(digest)
+		if err != nil {
+			return nil, err
+		}
+		o, err := p.readAt(db, offset)
+		if err != nil {
+			return nil, err
+		}
+		return repr.NewObject(o.objType, bytes.NewReader(o.content))
+	} else if err != nil {
+		return nil, err
+	}
+	return repr.ParseObject(compressed)
+}
+
+func (db *ObjectDB) WriteObject(o repr.Object) error {
+	compressed, err := o.Compressed()
+	if err != nil {
+		return err
+	}
+	objDirPath := db.objectDirPath(o.Digest())
+	_, err = os.Stat(objDirPath)
+	if errors.Is(err, os.ErrNotExist) {
+		err := os.MkdirAll(objDirPath, os.ModePerm)
+		if err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	objPath := db.objectFilePath(o.Digest())
+	_, err = os.Stat(objPath)
+	if errors.Is(err, os.ErrNotExist) {
+		err = os.WriteFile(objPath, compressed, 0444)
+		if err != nil {
+			return err
+		}
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (db *ObjectDB) HasObject(digest string) bool {
+	if !repr.IsValidDigest(digest) {
+		return false
+	}
+	if _, err := os.Stat(db.objectFilePath(digest)); err == nil {
+		return true
+	}
+	_, _, err := db.findPacked(digest)
+	return err == nil
+}
+
+// Size of the object as stored on disk
+func (db *ObjectDB) DiskSize(digest string) (int64, error) {
+	info, err := db.ReadObjectInfo(digest)
+	if err != nil {
+		return 0, err
+	}
+	return info.DiskSize, nil
+}
+
+// Digests of objects starting with the given hex prefix (at least two
+// characters long)
+func (db *ObjectDB) FindObjectsByPrefix(prefix string) ([]string, error) {
+	res, err := db.findLooseByPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.loadPacks(); err != nil {
+		return nil, err
+	}
+	for _, p := range db.packs {
+		res = append(res, p.findByPrefix(prefix)...)
+	}
+	slices.Sort(res)
+	return slices.Compact(res), nil
+}
+
+// Shortest prefix of the digest, at least n characters long, that no other
+// object of the database starts with
+func (db *ObjectDB) Abbrev(digest string, n int) string {
+	if n >= len(digest) {
+		return digest
+	}
+	others, err := db.FindObjectsByPrefix(digest[:n])
+	if err != nil {
+		return digest[:n]
+	}
+	for _, other := range others {
+		common := 0
+		for common < len(digest) && other[common] == digest[common] {
+			common += 1
+		}
+		if common < len(digest) {
+			n = max(n, common+1)
+		}
+	}
+	return digest[:n]
+}
+
+func (db *ObjectDB) findLooseByPrefix(prefix string) ([]string, error) {
+	entries, err := os.ReadDir(db.objectDirPath(prefix))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, entry := range entries {
+		digest := prefix[:2] + entry.Name()
+		if strings.HasPrefix(digest, prefix) && repr.IsValidDigest(digest) {
+			res = append(res, digest)
+		}
+	}
+	return res, nil
+}
+
+// Digests of all the objects in the database, loose and packed, sorted
+func (db *ObjectDB) ListObjects() ([]string, error) {
+	dirs, err := os.ReadDir(db.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 {
+			continue
+		}
+		digests, err := db.findLooseByPrefix(dir.Name())
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, digests...)
+	}
+	if err := db.loadPacks(); err != nil {
+		return nil, err
+	}
+	for _, p := range db.packs {
+		res = append(res, p.digests...)
+	}
+	slices.Sort(res)
+	return slices.Compact(res), nil
+}
@@ -0,0 +1,457 @@
+package fs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/magnickolas/gitok/repr"
+)
+
+var ErrorCorruptedPack = errors.New("corrupted pack")
+
+const (
+	packObjCommit   = 1
+	packObjTree     = 2
+	packObjBlob     = 3
+	packObjTag      = 4
+	packObjOfsDelta = 6
+	packObjRefDelta = 7
+)
+
+var packTypeNames = map[int]string{
+	packObjCommit: "commit",
+	packObjTree:   "tree",
+	packObjBlob:   "blob",
+	packObjTag:    "tag",
+}
+
+// Maximum number of delta bases kept in memory
+const deltaBaseCacheSize = 256
+
+// A packfile together with its .idx
+type pack struct {
+	path    string
+	f       *os.File
+	size    int64
+	digests []string // sorted
+	offsets []int64  // offsets[i] is the offset of digests[i]
+	// entry offsets in pack order, used to find where an entry ends
+	sortedOffsets []int64
+	byOffset      map[int64]int
+	baseCache     map[int64]packedObject
+}
+
+type packedObject struct {
+	objType string
+	content []byte
+}
+
+// Header of a pack entry
+type packEntry struct {
+	offset int64
+	// offset of the compressed data
+	dataOffset int64
+	kind       int
+	size       int64
+	// for delta entries
+	baseOffset int64
+	baseDigest string
+}
+
+func openPack(idxPath string) (*pack, error) {
+	idx, err := os.ReadFile(idxPath)
+	if err != nil {
+		return nil, err
+	}
+	p := &pack{
+		path:      strings.TrimSuffix(idxPath, ".idx") + ".pack",
+		byOffset:  make(map[int64]int),
+		baseCache: make(map[int64]packedObject),
+	}
+	if err := p.parseIndex(idx); err != nil {
+		return nil, fmt.Errorf("%v: %w", idxPath, err)
+	}
+	p.f, err = os.Open(p.path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := p.f.Stat()
+	if err != nil {
+		p.f.Close()
+		return nil, err
+	}
+	p.size = info.Size()
+	header := make([]byte, 12)
+	if _, err := p.f.ReadAt(header, 0); err != nil || string(header[:4]) != "PACK" {
+		p.f.Close()
+		return nil, fmt.Errorf("%v: %w: bad header", p.path, ErrorCorruptedPack)
+	}
+	p.sortedOffsets = slices.Clone(p.offsets)
+	slices.Sort(p.sortedOffsets)
+	for i, offset := range p.offsets {
+		p.byOffset[offset] = i
+	}
+	return p, nil
+}
+
+func (p *pack) parseIndex(idx []byte) error {
+	hashSize := repr.HashSize()
+	if len(idx) >= 8 && bytes.Equal(idx[:4], []byte{0xff, 't', 'O', 'c'}) {
+		if binary.BigEndian.Uint32(idx[4:8]) != 2 {
+			return fmt.Errorf("%w: unsupported index version", ErrorCorruptedPack)
+		}
+		idx = idx[8:]
+		if len(idx) < 256*4 {
+			return ErrorCorruptedPack
+		}
+		n := int(binary.BigEndian.Uint32(idx[255*4:]))
+		idx = idx[256*4:]
+		if len(idx) < n*(hashSize+4+4) {
+			return ErrorCorruptedPack
+		}
+		names, rest := idx[:n*hashSize], idx[n*hashSize:]
+		// skip the CRC32 table
+		rest = rest[n*4:]
+		smallOffsets, largeOffsets := rest[:n*4], rest[n*4:]
+		for i := 0; i < n; i += 1 {
+			p.digests = append(p.digests, hex.EncodeToString(names[i*hashSize:(i+1)*hashSize]))
+			offset := int64(binary.BigEndian.Uint32(smallOffsets[i*4:]))
+			if offset&0x80000000 != 0 {
+				j := int(offset & 0x7fffffff)
+				if len(largeOffsets) < (j+1)*8 {
+					return ErrorCorruptedPack
+				}
+				offset = int64(binary.BigEndian.Uint64(largeOffsets[j*8:]))
+			}
+			p.offsets = append(p.offsets, offset)
+		}
+		return nil
+	}
+	// version 1: fan-out table followed by (offset, name) pairs
+	if len(idx) < 256*4 {
+		return ErrorCorruptedPack
+	}
+	n := int(binary.BigEndian.Uint32(idx[255*4:]))
+	idx = idx[256*4:]
+	if len(idx) < n*(4+hashSize) {
+		return ErrorCorruptedPack
+	}
+	for i := 0; i < n; i += 1 {
+		entry := idx[i*(4+hashSize):]
+		p.offsets = append(p.offsets, int64(binary.BigEndian.Uint32(entry)))
+		p.digests = append(p.digests, hex.EncodeToString(entry[4:4+hashSize]))
+	}
+	return nil
+}
+
+func (p *pack) find(digest string) (int64, bool) {
+	i, found := slices.BinarySearch(p.digests, digest)
+	if !found {
+		return 0, false
+	}
+	return p.offsets[i], true
+}
+
+func (p *pack) findByPrefix(prefix string) []string {
+	i := sort.SearchStrings(p.digests, prefix)
+	var res []string
+	for ; i < len(p.digests) && strings.HasPrefix(p.digests[i], prefix); i += 1 {
+		res = append(res, p.digests[i])
+	}
+	return res
+}
+
+// Reads the header of the entry at offset
+func (p *pack) readEntry(offset int64) (*packEntry, error) {
+	br := bufio.NewReaderSize(io.NewSectionReader(p.f, offset, p.size-offset), 64)
+	read := int64(0)
+	next := func() (byte, error) {
+		c, err := br.ReadByte()
+		read += 1
+		return c, err
+	}
+	c, err := next()
+	if err != nil {
+		return nil, err
+	}
+	entry := &packEntry{offset: offset, kind: int(c>>4) & 7, size: int64(c & 15)}
+	for shift := 4; c&0x80 != 0; shift += 7 {
+		if c, err = next(); err != nil {
+			return nil, err
+		}
+		entry.size |= int64(c&0x7f) << shift
+	}
+	switch entry.kind {
+	case packObjOfsDelta:
+		if c, err = next(); err != nil {
+			return nil, err
+		}
+		ofs := int64(c & 0x7f)
+		for c&0x80 != 0 {
+			if c, err = next(); err != nil {
+				return nil, err
+			}
+			ofs = ((ofs + 1) << 7) | int64(c&0x7f)
+		}
+		entry.baseOffset = offset - ofs
+		if entry.baseOffset <= 0 {
+			return nil, ErrorCorruptedPack
+		}
+	case packObjRefDelta:
+		base := make([]byte, repr.HashSize())
+		if _, err := io.ReadFull(br, base); err != nil {
+			return nil, err
+		}
+		read += int64(len(base))
+		entry.baseDigest = hex.EncodeToString(base)
+	case packObjCommit, packObjTree, packObjBlob, packObjTag:
+	default:
+		return nil, fmt.Errorf("%w: unknown entry type %d at offset %d", ErrorCorruptedPack, entry.kind, offset)
+	}
+	entry.dataOffset = offset + read
+	return entry, nil
+}
+
+// Inflates the entry data (the object itself or the delta)
+func (p *pack) inflate(entry *packEntry) (io.ReadCloser, error) {
+	r := io.NewSectionReader(p.f, entry.dataOffset, p.size-entry.dataOffset)
+	return zlib.NewReader(bufio.NewReader(r))
+}
+
+func (p *pack) readData(entry *packEntry) ([]byte, error) {
+	zr, err := p.inflate(entry)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	data := make([]byte, entry.size)
+	if _, err := io.ReadFull(zr, data); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrorCorruptedPack, err)
+	}
+	return data, nil
+}
+
+// Type and content of the object at offset, resolving deltas
+func (p *pack) readAt(db *ObjectDB, offset int64) (packedObject, error) {
+	if o, ok := p.baseCache[offset]; ok {
+		return o, nil
+	}
+	entry, err := p.readEntry(offset)
+	if err != nil {
+		return packedObject{}, err
+	}
+	data, err := p.readData(entry)
+	if err != nil {
+		return packedObject{}, err
+	}
+	var o packedObject
+	switch entry.kind {
+	case packObjOfsDelta, packObjRefDelta:
+		base, err := p.readBase(db, entry)
+		if err != nil {
+			return packedObject{}, err
+		}
+		content, err := ApplyDelta(base.content, data)
+		if err != nil {
+			return packedObject{}, err
+		}
+		o = packedObject{objType: base.objType, content: content}
+	default:
+		o = packedObject{objType: packTypeNames[entry.kind], content: data}
+	}
+	if len(p.baseCache) >= deltaBaseCacheSize {
+		clear(p.baseCache)
+	}
+	p.baseCache[offset] = o
+	return o, nil
+}
+
+func (p *pack) readBase(db *ObjectDB, entry *packEntry) (packedObject, error) {
+	if entry.kind == packObjOfsDelta {
+		return p.readAt(db, entry.baseOffset)
+	}
+	if offset, ok := p.find(entry.baseDigest); ok {
+		return p.readAt(db, offset)
+	}
+	// thin packs may refer to objects stored elsewhere
+	o, err := db.ReadObject(entry.baseDigest)
+	if err != nil {
+		return packedObject{}, err
+	}
+	return packedObject{objType: o.Type(), content: repr.StripObjectHeader(o)}, nil
+}
+
+// Type and size of the object at offset without inflating more than the
+// headers of the delta chain
+func (p *pack) infoAt(db *ObjectDB, offset int64) (*ObjectInfo, error) {
+	entry, err := p.readEntry(offset)
+	if err != nil {
+		return nil, err
+	}
+	info := &ObjectInfo{
+		Size:      entry.size,
+		DiskSize:  p.entryEnd(offset) - offset,
+		DeltaBase: repr.ZeroDigest(),
+	}
+	if entry.kind != packObjOfsDelta && entry.kind != packObjRefDelta {
+		info.Type = packTypeNames[entry.kind]
+		return info, nil
+	}
+	zr, err := p.inflate(entry)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReaderSize(zr, 32)
+	_, err1 := readDeltaSize(br)
+	resultSize, err2 := readDeltaSize(br)
+	zr.Close()
+	if err1 != nil || err2 != nil {
+		return nil, ErrorCorruptedPack
+	}
+	info.Size = resultSize
+	var baseInfo *ObjectInfo
+	if entry.kind == packObjOfsDelta {
+		i, ok := p.byOffset[entry.baseOffset]
+		if !ok {
+			return nil, ErrorCorruptedPack
+		}
+		info.DeltaBase = p.digests[i]
+		baseInfo, err = p.infoAt(db, entry.baseOffset)
+	} else {
+		info.DeltaBase = entry.baseDigest
+		baseInfo, err = db.ReadObjectInfo(entry.baseDigest)
+	}
+	if err != nil {
+		return nil, err
+	}
+	info.Type = baseInfo.Type
+	return info, nil
+}
+
+// Offset right after the entry at offset (the trailing checksum for the
+// last entry)
+func (p *pack) entryEnd(offset int64) int64 {
+	i, _ := slices.BinarySearch(p.sortedOffsets, offset)
+	if i+1 < len(p.sortedOffsets) {
+		return p.sortedOffsets[i+1]
+	}
+	return p.size - int64(repr.HashSize())
+}
+
+func readDeltaSize(br io.ByteReader) (int64, error) {
+	var size int64
+	for shift := 0; ; shift += 7 {
+		c, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		size |= int64(c&0x7f) << shift
+		if c&0x80 == 0 {
+			return size, nil
+		}
+	}
+}
+
+// Reconstructs an object from its base and a git delta
+func ApplyDelta(base []byte, delta []byte) ([]byte, error) {
+	r := bytes.NewReader(delta)
+	baseSize, err := readDeltaSize(r)
+	if err != nil || baseSize != int64(len(base)) {
+		return nil, fmt.Errorf("%w: delta base size mismatch", ErrorCorruptedPack)
+	}
+	resultSize, err := readDeltaSize(r)
+	if err != nil {
+		return nil, ErrorCorruptedPack
+	}
+	res := make([]byte, 0, resultSize)
+	for r.Len() > 0 {
+		op, _ := r.ReadByte()
+		if op&0x80 != 0 {
+			var offset, size int64
+			for i := 0; i < 4; i += 1 {
+				if op&(1<<i) != 0 {
+					c, err := r.ReadByte()
+					if err != nil {
+						return nil, ErrorCorruptedPack
+					}
+					offset |= int64(c) << (8 * i)
+				}
+			}
+			for i := 0; i < 3; i += 1 {
+				if op&(0x10<<i) != 0 {
+					c, err := r.ReadByte()
+					if err != nil {
+						return nil, ErrorCorruptedPack
+					}
+					size |= int64(c) << (8 * i)
+				}
+			}
+			if size == 0 {
+				size = 0x10000
+			}
+			if offset+size > int64(len(base)) {
+				return nil, fmt.Errorf("%w: delta copy out of bounds", ErrorCorruptedPack)
+			}
+			res = append(res, base[offset:offset+size]...)
+		} else if op != 0 {
+			insert := make([]byte, op)
+			if _, err := io.ReadFull(r, insert); err != nil {
+				return nil, ErrorCorruptedPack
+			}
+			res = append(res, insert...)
+		} else {
+			return nil, fmt.Errorf("%w: unexpected delta opcode 0", ErrorCorruptedPack)
+		}
+	}
+	if int64(len(res)) != resultSize {
+		return nil, fmt.Errorf("%w: delta result size mismatch", ErrorCorruptedPack)
+	}
+	return res, nil
+}
+
+// Opens all the packs of the database, once
+func (db *ObjectDB) loadPacks() error {
+	if db.packsLoaded {
+		return nil
+	}
+	idxPaths, err := filepath.Glob(filepath.Join(db.dir, "pack", "*.idx"))
+	if err != nil {
+		return err
+	}
+	for _, idxPath := range idxPaths {
+		p, err := openPack(idxPath)
+		if errors.Is(err, os.ErrNotExist) {
+			// .idx without its .pack (e.g. being written)
+			continue
+		} else if err != nil {
+			return err
+		}
+		db.packs = append(db.packs, p)
+	}
+	db.packsLoaded = true
+	return nil
+}
+
+// Pack containing the object and the offset of its entry
+func (db *ObjectDB) findPacked(digest string) (*pack, int64, error) {
+	if err := db.loadPacks(); err != nil {
+		return nil, 0, err
+	}
+	for _, p := range db.packs {
+		if offset, ok := p.find(digest); ok {
+			return p, offset, nil
+		}
+	}
+	return nil, 0, formatErrorObjectNotFound(digest)
+}
@@ -0,0 +1,245 @@
+package fs_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/repr"
+)
+
+const (
+	packObjBlob     = 3
+	packObjOfsDelta = 6
+	packObjRefDelta = 7
+)
+
+// Entry of a test pack: an object stored whole, or a delta against an
+// earlier entry or an object outside the pack
+type packSpec struct {
+	kind int
+	data []byte
+	// index of the earlier entry the offset delta applies to
+	base int
+	// object the reference delta applies to
+	baseDigest string
+	// digest of the object the entry stands for
+	digest string
+}
+
+func blobDigest(t *testing.T, content string) string {
+	blob, err := repr.NewBlob(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blob.Digest()
+}
+
+func deflate(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// Writes the entries as a version 2 pack with its index into the pack
+// directory of the database, and returns the offsets of the entries
+func writePack(t *testing.T, dir string, specs []packSpec) []int64 {
+	pack := []byte("PACK")
+	pack = binary.BigEndian.AppendUint32(pack, 2)
+	pack = binary.BigEndian.AppendUint32(pack, uint32(len(specs)))
+	offsets := make([]int64, len(specs))
+	crcs := make([]uint32, len(specs))
+	for i, spec := range specs {
+		offsets[i] = int64(len(pack))
+		size := len(spec.data)
+		c := byte(spec.kind<<4) | byte(size&15)
+		size >>= 4
+		var entry []byte
+		for size > 0 {
+			entry = append(entry, c|0x80)
+			c = byte(size & 0x7f)
+			size >>= 7
+		}
+		entry = append(entry, c)
+		switch spec.kind {
+		case packObjOfsDelta:
+			ofs := offsets[i] - offsets[spec.base]
+			encoded := []byte{byte(ofs & 0x7f)}
+			for ofs >>= 7; ofs > 0; ofs >>= 7 {
+				ofs -= 1
+				encoded = append([]byte{0x80 | byte(ofs&0x7f)}, encoded...)
+			}
+			entry = append(entry, encoded...)
+		case packObjRefDelta:
+			base, _ := hex.DecodeString(spec.baseDigest)
+			entry = append(entry, base...)
+		}
+		entry = append(entry, deflate(t, spec.data)...)
+		crcs[i] = crc32.ChecksumIEEE(entry)
+		pack = append(pack, entry...)
+	}
+	h := repr.NewHasher()
+	h.Write(pack)
+	pack = h.Sum(pack)
+
+	order := make([]int, len(specs))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(i, j int) int {
+		return strings.Compare(specs[i].digest, specs[j].digest)
+	})
+	idx := []byte{0xff, 't', 'O', 'c', 0, 0, 0, 2}
+	for b := 0; b < 256; b += 1 {
+		count := 0
+		for _, spec := range specs {
+			if first, _ := hex.DecodeString(spec.digest[:2]); int(first[0]) <= b {
+				count += 1
+			}
+		}
+		idx = binary.BigEndian.AppendUint32(idx, uint32(count))
+	}
+	for _, i := range order {
+		name, _ := hex.DecodeString(specs[i].digest)
+		idx = append(idx, name...)
+	}
+	for _, i := range order {
+		idx = binary.BigEndian.AppendUint32(idx, crcs[i])
+	}
+	for _, i := range order {
+		idx = binary.BigEndian.AppendUint32(idx, uint32(offsets[i]))
+	}
+	idx = append(idx, pack[len(pack)-repr.HashSize():]...)
+	h = repr.NewHasher()
+	h.Write(idx)
+	idx = h.Sum(idx)
+
+	packDir := filepath.Join(dir, "pack")
+	if err := os.MkdirAll(packDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(packDir, "pack-test.pack"), pack, 0444); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(packDir, "pack-test.idx"), idx, 0444); err != nil {
+		t.Fatal(err)
+	}
+	return offsets
+}
+
+func TestReadObjectInfo(t *testing.T) {
+	dir := t.TempDir()
+	db := fs.OpenObjectDB(dir)
+	loose, err := repr.NewBlob(strings.NewReader("base\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.WriteObject(loose); err != nil {
+		t.Fatal(err)
+	}
+	const (
+		whole   = "hello world\n"
+		ofsDiff = "hello world\nand more\n"
+		refDiff = "base\nplus\n"
+	)
+	specs := []packSpec{
+		{kind: packObjBlob, data: []byte(whole), digest: blobDigest(t, whole)},
+		// copies the 12 bytes of the base, then inserts 9
+		{
+			kind:   packObjOfsDelta,
+			data:   append([]byte{12, 21, 0x90, 12, 9}, "and more\n"...),
+			base:   0,
+			digest: blobDigest(t, ofsDiff),
+		},
+		// copies the 5 bytes of the loose base, then inserts 5
+		{
+			kind:       packObjRefDelta,
+			data:       append([]byte{5, 10, 0x90, 5, 5}, "plus\n"...),
+			baseDigest: loose.Digest(),
+			digest:     blobDigest(t, refDiff),
+		},
+	}
+	offsets := writePack(t, dir, specs)
+	tests := []struct {
+		name    string
+		digest  string
+		content string
+		want    fs.ObjectInfo
+	}{
+		{
+			name:    "loose",
+			digest:  loose.Digest(),
+			content: "base\n",
+			want:    fs.ObjectInfo{Type: "blob", Size: 5, DeltaBase: repr.ZeroDigest()},
+		},
+		{
+			name:    "packed whole",
+			digest:  specs[0].digest,
+			content: whole,
+			want: fs.ObjectInfo{
+				Type:      "blob",
+				Size:      int64(len(whole)),
+				DiskSize:  offsets[1] - offsets[0],
+				DeltaBase: repr.ZeroDigest(),
+			},
+		},
+		{
+			name:    "offset delta",
+			digest:  specs[1].digest,
+			content: ofsDiff,
+			want: fs.ObjectInfo{
+				Type:      "blob",
+				Size:      int64(len(ofsDiff)),
+				DiskSize:  offsets[2] - offsets[1],
+				DeltaBase: specs[0].digest,
+			},
+		},
+		{
+			name:    "reference delta to a loose object",
+			digest:  specs[2].digest,
+			content: refDiff,
+			want: fs.ObjectInfo{
+				Type:      "blob",
+				Size:      int64(len(refDiff)),
+				DeltaBase: loose.Digest(),
+			},
+		},
+	}
+	for _, test := range tests {
+		info, err := db.ReadObjectInfo(test.digest)
+		if err != nil {
+			t.Errorf("failed to read the info of %v: %v", test.name, err)
+			continue
+		}
+		// sizes on disk are only checked for entries followed by another
+		if test.want.DiskSize == 0 {
+			test.want.DiskSize = info.DiskSize
+		}
+		if *info != test.want {
+			t.Errorf("incorrect result for %#v: wanted %#v, got %#v", test.name, test.want, *info)
+		}
+		objType, content, err := db.ReadRawObject(test.digest)
+		if err != nil || objType != "blob" || string(content) != test.content {
+			t.Errorf("incorrect result for %#v: wanted a blob of %#v, got a %v of %#v (%v)", test.name, test.content, objType, string(content), err)
+		}
+	}
+	missing := blobDigest(t, "missing\n")
+	if _, err := db.ReadObjectInfo(missing); !errors.Is(err, fs.ErrorObjectNotFound) {
+		t.Errorf("incorrect result for %#v: wanted %#v, got %#v", "missing", fs.ErrorObjectNotFound, err)
+	}
+}
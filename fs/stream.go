@@ -0,0 +1,228 @@
+package fs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/magnickolas/gitok/repr"
+)
+
+// Streamed content of an object. Only the part of the object that is read
+// gets inflated
+type ObjectReader struct {
+	Type    string
+	Size    int64
+	r       io.Reader
+	closers []io.Closer
+}
+
+func (or *ObjectReader) Read(p []byte) (int, error) {
+	return or.r.Read(p)
+}
+
+func (or *ObjectReader) Close() error {
+	var err error
+	for _, c := range or.closers {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Opens the object for streaming its content, the type and size being
+// available right away
+func (db *ObjectDB) OpenObject(digest string) (*ObjectReader, error) {
+	if !repr.IsValidDigest(digest) {
+		return nil, formatErrorObjectNotFound(digest)
+	}
+	f, err := os.Open(db.objectFilePath(digest))
+	if errors.Is(err, os.ErrNotExist) {
+		return db.openPacked(digest)
+	} else if err != nil {
+		return nil, err
+	}
+	zr, err := zlib.NewReader(bufio.NewReader(f))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(zr)
+	objType, size, err := readObjectHeader(br)
+	if err != nil {
+		zr.Close()
+		f.Close()
+		return nil, err
+	}
+	return &ObjectReader{
+		Type:    objType,
+		Size:    size,
+		r:       io.LimitReader(br, size),
+		closers: []io.Closer{zr, f},
+	}, nil
+}
+
+// Packed objects are streamed unless they are deltified, in which case
+// they have to be reconstructed in memory
+func (db *ObjectDB) openPacked(digest string) (*ObjectReader, error) {
+	p, offset, err := db.findPacked(digest)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := p.readEntry(offset)
+	if err != nil {
+		return nil, err
+	}
+	if entry.kind == packObjOfsDelta || entry.kind == packObjRefDelta {
+		o, err := p.readAt(db, offset)
+		if err != nil {
+			return nil, err
+		}
+		return &ObjectReader{
+			Type: o.objType,
+			Size: int64(len(o.content)),
+			r:    bytes.NewReader(o.content),
+		}, nil
+	}
+	zr, err := p.inflate(entry)
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectReader{
+		Type:    packTypeNames[entry.kind],
+		Size:    entry.size,
+		r:       io.LimitReader(zr, entry.size),
+		closers: []io.Closer{zr},
+	}, nil
+}
+
+// Type and sizes of an object, as found in its header
+type ObjectInfo struct {
+	Type string
+	Size int64
+	// size taken by the object in the loose file or in the pack
+	DiskSize int64
+	// object the packed delta applies to, the zero digest otherwise
+	DeltaBase string
+}
+
+// Reads the object info inflating only the header of a loose object, or the
+// entry headers (and delta sizes) of a packed one
+func (db *ObjectDB) ReadObjectInfo(digest string) (*ObjectInfo, error) {
+	if !repr.IsValidDigest(digest) {
+		return nil, formatErrorObjectNotFound(digest)
+	}
+	f, err := os.Open(db.objectFilePath(digest))
+	if errors.Is(err, os.ErrNotExist) {
+		p, offset, err := db.findPacked(digest)
+		if err != nil {
+			return nil, err
+		}
+		return p.infoAt(db, offset)
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zlib.NewReader(bufio.NewReaderSize(f, 512))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	objType, size, err := readObjectHeader(bufio.NewReaderSize(zr, 64))
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{
+		Type:      objType,
+		Size:      size,
+		DiskSize:  stat.Size(),
+		DeltaBase: repr.ZeroDigest(),
+	}, nil
+}
+
+// Parses the "<type> <size>\0" prefix of an inflated object
+func readObjectHeader(br *bufio.Reader) (string, int64, error) {
+	header, err := br.ReadString(0)
+	if err != nil {
+		return "", 0, repr.ErrorCorruptedObjectHeader
+	}
+	objType, sizeStr, found := strings.Cut(header[:len(header)-1], " ")
+	if !found {
+		return "", 0, repr.ErrorCorruptedObjectHeader
+	}
+	size, err := strconv.ParseInt(sizeStr, 10, 64)
+	if err != nil || size < 0 {
+		return "", 0, repr.ErrorCorruptedObjectHeader
+	}
+	return objType, size, nil
+}
+
+// Writes an object of known size as a loose object while reading it from r,
+// so that the content never has to fit in memory. Returns the digest
+func (db *ObjectDB) WriteStream(objType string, size int64, r io.Reader) (string, error) {
+	if err := os.MkdirAll(db.dir, os.ModePerm); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(db.dir, "tmp_obj_")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	h := repr.NewHasher()
+	zw := zlib.NewWriter(tmp)
+	w := io.MultiWriter(h, zw)
+	fmt.Fprintf(w, "%s %d\x00", objType, size)
+	n, err := io.Copy(w, r)
+	if err == nil && n != size {
+		err = repr.ErrorSizeNotMatch
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	digest := hex.EncodeToString(h.Sum(nil))
+	if db.HasObject(digest) {
+		return digest, nil
+	}
+	if err := os.MkdirAll(db.objectDirPath(digest), os.ModePerm); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmp.Name(), 0444); err != nil {
+		return "", err
+	}
+	return digest, os.Rename(tmp.Name(), db.objectFilePath(digest))
+}
+
+// Type and content of the object, without parsing or validating the content
+func (db *ObjectDB) ReadRawObject(digest string) (string, []byte, error) {
+	or, err := db.OpenObject(digest)
+	if err != nil {
+		return "", nil, err
+	}
+	defer or.Close()
+	content, err := io.ReadAll(or)
+	if err != nil {
+		return "", nil, err
+	}
+	if int64(len(content)) != or.Size {
+		return "", nil, repr.ErrorSizeNotMatch
+	}
+	return or.Type, content, nil
+}
@@ -1,57 +1,73 @@
 package fs
 
 import (
-	"errors"
-	"os"
+	"fmt"
+	"io"
 	"path/filepath"
 
 	"github.com/magnickolas/gitok/constants"
 	"github.com/magnickolas/gitok/repr"
 )
 
-func getObjectDirPath(digest string) string {
-	dirName := digest[:2]
-	return filepath.Join(constants.Git, constants.Objects, dirName)
+// Object database of the current repository
+var Default = OpenObjectDB(filepath.Join(constants.Git, constants.Objects))
+
+func ReadObject(digest string) (repr.Object, error) {
+	return Default.ReadObject(digest)
 }
 
-func getObjectFilePath(digest string) string {
-	dirPath, fileName := getObjectDirPath(digest), digest[2:]
-	return filepath.Join(dirPath, fileName)
+func OpenObject(digest string) (*ObjectReader, error) {
+	return Default.OpenObject(digest)
 }
 
-func ReadObject(digest string) (repr.Object, error) {
-	path := getObjectFilePath(digest)
-	compressed, err := os.ReadFile(path)
+func ReadObjectInfo(digest string) (*ObjectInfo, error) {
+	return Default.ReadObjectInfo(digest)
+}
+
+func WriteObject(o repr.Object) error {
+	return Default.WriteObject(o)
+}
+
+func WriteStream(objType string, size int64, r io.Reader) (string, error) {
+	return Default.WriteStream(objType, size, r)
+}
+
+func HasObject(digest string) bool {
+	return Default.HasObject(digest)
+}
+
+func FindObjectsByPrefix(prefix string) ([]string, error) {
+	return Default.FindObjectsByPrefix(prefix)
+}
+
+func ReadTree(digest string) (*repr.Tree, error) {
+	return Default.ReadTree(digest)
+}
+
+func ReadCommit(digest string) (*repr.Commit, error) {
+	return Default.ReadCommit(digest)
+}
+
+func (db *ObjectDB) ReadTree(digest string) (*repr.Tree, error) {
+	o, err := db.ReadObject(digest)
 	if err != nil {
 		return nil, err
 	}
-	return repr.ParseObject(compressed)
+	tree, ok := o.(*repr.Tree)
+	if !ok {
+		return nil, fmt.Errorf("object %v is a %v, not a tree", digest, o.Type())
+	}
+	return tree, nil
 }
 
-func WriteObject(o repr.Object) error {
-	compressed, err := o.Compressed()
+func (db *ObjectDB) ReadCommit(digest string) (*repr.Commit, error) {
+	o, err := db.ReadObject(digest)
 	if err != nil {
-		return err
-	}
-	objDirPath := getObjectDirPath(o.Digest())
-	_, err = os.Stat(objDirPath)
-	if errors.Is(err, os.ErrNotExist) {
-		err := os.Mkdir(objDirPath, os.ModePerm)
-		if err != nil {
-			return err
-		}
-	} else if err != nil {
-		return err
+		return nil, err
 	}
-	objPath := getObjectFilePath(o.Digest())
-	_, err = os.Stat(objPath)
-	if errors.Is(err, os.ErrNotExist) {
-		err = os.WriteFile(objPath, compressed, 0444)
-		if err != nil {
-			return err
-		}
-		return nil
-	} else {
-		return err
+	commit, ok := o.(*repr.Commit)
+	if !ok {
+		return nil, fmt.Errorf("object %v is a %v, not a commit", digest, o.Type())
 	}
+	return commit, nil
 }
@@ -0,0 +1,623 @@
+package gitok_am
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/magnickolas/gitok/apply"
+	"github.com/magnickolas/gitok/config"
+	"github.com/magnickolas/gitok/constants"
+	"github.com/magnickolas/gitok/date"
+	"github.com/magnickolas/gitok/diff"
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/ident"
+	"github.com/magnickolas/gitok/index"
+	"github.com/magnickolas/gitok/index/parser"
+	"github.com/magnickolas/gitok/mailinfo"
+	"github.com/magnickolas/gitok/refs"
+	"github.com/magnickolas/gitok/repr"
+	"github.com/magnickolas/gitok/revparse"
+	"github.com/magnickolas/gitok/strerror"
+	"github.com/magnickolas/gitok/worktree"
+)
+
+var (
+	ErrorInProgress    = errors.New("previous rebase directory " + stateDir + " still exists but mbox given.")
+	ErrorNotInProgress = errors.New("Resolve operation not in progress, we are not resuming.")
+	ErrorDirtyIndex    = errors.New("Dirty index: cannot apply patches")
+	formatErrorDirty   = func(paths []string) error {
+		return fmt.Errorf("%w (dirty: %v)", ErrorDirtyIndex, strings.Join(paths, " "))
+	}
+	ErrorCannotOpenMbox       = errors.New("could not open")
+	formatErrorCannotOpenMbox = func(path string, err error) error {
+		return fmt.Errorf("%w '%v' for reading: %v", ErrorCannotOpenMbox, path, err)
+	}
+	ErrorInvalidPatchFormat = errors.New("invalid value for --show-current-patch")
+)
+
+// Directory of the state of a session, which remains while a patch waits
+// to be resolved
+var stateDir = filepath.Join(constants.Git, "rebase-apply")
+
+// Head before the session, to go back to
+const origHead = "ORIG_HEAD"
+
+// Name of the standard input in messages
+const stdinName = "<stdin>"
+
+type Options struct {
+	// fall back to a 3-way merge with the blobs the patches name
+	ThreeWay bool
+	Quiet    bool
+	// leave the subjects as they are
+	KeepSubject bool
+}
+
+type session struct {
+	w    io.Writer
+	errW io.Writer
+	db   *fs.ObjectDB
+	cfg  *config.Config
+	opts Options
+	// numbers of the patch being applied and of the last one
+	next int
+	last int
+}
+
+// Splits the mailboxes, or the standard input if there are none or for
+// "-", into patches and commits each on top of HEAD with the author, date
+// and message of its mail. Stops at a patch that does not apply, keeping
+// the session for it to be resolved and returning false
+func Am(stdin io.Reader, w io.Writer, errW io.Writer, paths []string, opts Options) (bool, error) {
+	if inProgress() {
+		if len(paths) > 0 {
+			return false, ErrorInProgress
+		}
+		s, err := load(w, errW)
+		if err != nil {
+			return false, err
+		}
+		if err := s.checkIndex(); err != nil {
+			return false, err
+		}
+		return s.run()
+	}
+	s := &session{w: w, errW: errW, db: fs.Default, opts: opts}
+	var err error
+	if s.cfg, err = config.Load(); err != nil {
+		return false, err
+	}
+	if err := s.checkIndex(); err != nil {
+		return false, err
+	}
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	var mails [][]byte
+	for _, path := range paths {
+		var content []byte
+		if path == "-" {
+			content, err = io.ReadAll(stdin)
+			path = stdinName
+		} else {
+			content, err = os.ReadFile(path)
+		}
+		if err != nil {
+			return false, formatErrorCannotOpenMbox(path, strerror.Describe(err))
+		}
+		mails = append(mails, mailinfo.Split(content)...)
+	}
+	if err := s.setup(mails); err != nil {
+		return false, err
+	}
+	return s.run()
+}
+
+// Commits the resolution of the patch the session stopped at, as the
+// index has it, and goes on with the rest
+func Continue(w io.Writer, errW io.Writer) (bool, error) {
+	s, err := load(w, errW)
+	if err != nil {
+		return false, err
+	}
+	idx, err := index.Read()
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range idx.Entries {
+		if entry.Stage() != 0 {
+			fmt.Fprintln(w, "You still have unmerged paths in your index.\n"+
+				"You should 'git add' each file with resolved conflicts to mark them as such.\n"+
+				"You might run `git rm` on a file to accept \"deleted by them\" for it.")
+			s.stop()
+			return false, nil
+		}
+	}
+	tree, err := index.WriteTree(idx.Entries)
+	if err != nil {
+		return false, err
+	}
+	head, err := s.headTree()
+	if err != nil {
+		return false, err
+	}
+	if tree == head {
+		fmt.Fprintln(w, "No changes - did you forget to use 'git add'?\n"+
+			"If there is nothing left to stage, chances are that something else\n"+
+			"already introduced the same changes; you might want to skip this patch.")
+		s.stop()
+		return false, nil
+	}
+	message, err := s.read("final-commit")
+	if err != nil {
+		return false, err
+	}
+	s.say("Applying: %v\n", subjectOf(message))
+	if err := s.commit(tree, message); err != nil {
+		return false, err
+	}
+	if err := s.advance(); err != nil {
+		return false, err
+	}
+	return s.run()
+}
+
+// Drops the patch the session stopped at, bringing the index and the
+// files back to HEAD, and goes on with the rest
+func Skip(w io.Writer, errW io.Writer) (bool, error) {
+	s, err := load(w, errW)
+	if err != nil {
+		return false, err
+	}
+	head, err := s.headTree()
+	if err != nil {
+		return false, err
+	}
+	if err := s.reset(head); err != nil {
+		return false, err
+	}
+	if err := s.advance(); err != nil {
+		return false, err
+	}
+	return s.run()
+}
+
+// Ends the session, bringing HEAD, the index and the files back to where
+// they were before it unless HEAD was moved since it stopped
+func Abort(w io.Writer, errW io.Writer) error {
+	s, err := load(w, errW)
+	if err != nil {
+		return err
+	}
+	head, err := refs.ResolveHead()
+	if err != nil {
+		return err
+	}
+	safety, err := s.read("abort-safety")
+	if err != nil {
+		return err
+	}
+	if head != strings.TrimSpace(safety) {
+		fmt.Fprintln(errW, "error: You seem to have moved HEAD since the last 'am' failure.\n"+
+			"Not rewinding to ORIG_HEAD")
+		return os.RemoveAll(stateDir)
+	}
+	orig := ""
+	if content, err := os.ReadFile(filepath.Join(constants.Git, origHead)); err == nil {
+		orig = strings.TrimSpace(string(content))
+	}
+	tree := ""
+	if orig != "" {
+		if tree, err = revparse.Peel(orig, "tree"); err != nil {
+			return err
+		}
+	}
+	if err := s.reset(tree); err != nil {
+		return err
+	}
+	if orig != head {
+		committer, err := ident.Committer(s.cfg)
+		if err != nil {
+			return err
+		}
+		tx := refs.NewTransaction(committer)
+		if orig == "" {
+			tx.Delete(constants.Head, head, "am --abort")
+		} else {
+			tx.Update(constants.Head, orig, refs.OrZero(head), "am --abort")
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(stateDir)
+}
+
+// Ends the session, leaving HEAD, the index and the files as they are
+func Quit() error {
+	if !inProgress() {
+		return ErrorNotInProgress
+	}
+	return os.RemoveAll(stateDir)
+}
+
+// Writes the patch the session stopped at: "raw" for its whole mail,
+// "diff" for the patch alone
+func ShowCurrentPatch(w io.Writer, format string) error {
+	if !inProgress() {
+		return ErrorNotInProgress
+	}
+	s, err := load(w, io.Discard)
+	if err != nil {
+		return err
+	}
+	var name string
+	switch format {
+	case "raw":
+		name = s.mailName(s.next)
+	case "diff":
+		name = "patch"
+	default:
+		return fmt.Errorf("%w: %v", ErrorInvalidPatchFormat, format)
+	}
+	content, err := s.read(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, content)
+	return err
+}
+
+func inProgress() bool {
+	info, err := os.Stat(stateDir)
+	return err == nil && info.IsDir()
+}
+
+// Picks up the session where it stopped
+func load(w io.Writer, errW io.Writer) (*session, error) {
+	if !inProgress() {
+		return nil, ErrorNotInProgress
+	}
+	s := &session{w: w, errW: errW, db: fs.Default}
+	var err error
+	if s.cfg, err = config.Load(); err != nil {
+		return nil, err
+	}
+	for _, n := range []struct {
+		name  string
+		value *int
+	}{{"next", &s.next}, {"last", &s.last}} {
+		content, err := s.read(n.name)
+		if err != nil {
+			return nil, err
+		}
+		if *n.value, err = strconv.Atoi(strings.TrimSpace(content)); err != nil {
+			return nil, err
+		}
+	}
+	for _, flag := range []struct {
+		name  string
+		value *bool
+	}{{"threeway", &s.opts.ThreeWay}, {"quiet", &s.opts.Quiet}, {"keep", &s.opts.KeepSubject}} {
+		content, err := s.read(flag.name)
+		if err != nil {
+			return nil, err
+		}
+		*flag.value = strings.TrimSpace(content) == "t"
+	}
+	return s, nil
+}
+
+// Starts a session with the mails, remembering HEAD to go back to
+func (s *session) setup(mails [][]byte) error {
+	if err := os.MkdirAll(stateDir, os.ModePerm); err != nil {
+		return err
+	}
+	s.next, s.last = 1, len(mails)
+	for i, mail := range mails {
+		if err := s.write(s.mailName(i+1), string(mail)); err != nil {
+			return err
+		}
+	}
+	for name, value := range map[string]bool{"threeway": s.opts.ThreeWay, "quiet": s.opts.Quiet, "keep": s.opts.KeepSubject} {
+		content := "f"
+		if value {
+			content = "t"
+		}
+		if err := s.write(name, content+"\n"); err != nil {
+			return err
+		}
+	}
+	if err := s.write("last", strconv.Itoa(s.last)+"\n"); err != nil {
+		return err
+	}
+	if err := s.write("next", strconv.Itoa(s.next)+"\n"); err != nil {
+		return err
+	}
+	if err := s.write("applying", ""); err != nil {
+		return err
+	}
+	head, err := refs.ResolveHead()
+	if err != nil {
+		return err
+	}
+	origPath := filepath.Join(constants.Git, origHead)
+	if head == "" {
+		if err := os.Remove(origPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else if err := os.WriteFile(origPath, []byte(head+"\n"), 0644); err != nil {
+		return err
+	}
+	return s.write("abort-safety", head+"\n")
+}
+
+// Applies and commits the patches from the next one on, ending the
+// session after the last one
+func (s *session) run() (bool, error) {
+	for ; s.next <= s.last; s.next += 1 {
+		if err := s.write("next", strconv.Itoa(s.next)+"\n"); err != nil {
+			return false, err
+		}
+		mail, err := s.read(s.mailName(s.next))
+		if err != nil {
+			return false, err
+		}
+		info := mailinfo.Parse([]byte(mail), mailinfo.Options{KeepSubject: s.opts.KeepSubject})
+		message := repr.CleanupMessage(info.Subject + "\n\n" + info.Message)
+		if err := s.save(info, message); err != nil {
+			return false, err
+		}
+		if strings.TrimSpace(info.Patch) == "" {
+			fmt.Fprintln(s.w, "Patch is empty.")
+			s.stop()
+			return false, nil
+		}
+		s.say("Applying: %v\n", subjectOf(message))
+		ok, err := s.apply(info)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			fmt.Fprintf(s.w, "Patch failed at %v %v\n", s.mailName(s.next), subjectOf(message))
+			fmt.Fprintln(s.errW, "hint: Use 'gitok am --show-current-patch=diff' to see the failed patch")
+			s.stop()
+			return false, nil
+		}
+		idx, err := index.Read()
+		if err != nil {
+			return false, err
+		}
+		tree, err := index.WriteTree(idx.Entries)
+		if err != nil {
+			return false, err
+		}
+		if err := s.commit(tree, message); err != nil {
+			return false, err
+		}
+	}
+	return true, os.RemoveAll(stateDir)
+}
+
+// Keeps what the mail gives for the commit, for it to be made once the
+// patch is resolved
+func (s *session) save(info *mailinfo.Info, message string) error {
+	files := []struct{ name, content string }{
+		{"info", info.String()},
+		{"msg", info.Message},
+		{"patch", info.Patch},
+		{"final-commit", message},
+		{"author-script", authorScript(info)},
+	}
+	for _, f := range files {
+		if err := s.write(f.name, f.content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Applies the patch to the index and the files. With 3-way merges, only
+// a patch that does not apply as is is merged, with the subject of the
+// mail labeling its side of conflicts
+func (s *session) apply(info *mailinfo.Info) (bool, error) {
+	opts := apply.Options{
+		Index:   true,
+		Ours:    constants.Head,
+		Theirs:  info.Subject,
+		Strip:   -1,
+		Context: -1,
+	}
+	if s.opts.Quiet {
+		opts.Verbosity = apply.VerbosityQuiet
+	}
+	if err := apply.Configure(s.errW, s.cfg, &opts, ""); err != nil {
+		return false, err
+	}
+	inputs := []apply.Input{{Name: filepath.Join(stateDir, "patch"), Content: []byte(info.Patch)}}
+	if !s.opts.ThreeWay {
+		return apply.Apply(s.errW, s.db, inputs, opts)
+	}
+	// why the patch does not apply as is does not matter then
+	ok, err := apply.Apply(io.Discard, s.db, inputs, opts)
+	if ok || err != nil {
+		return ok, err
+	}
+	opts.ThreeWay = true
+	return apply.Apply(s.errW, s.db, inputs, opts)
+}
+
+// Commits the tree on top of HEAD as the author of the patch being applied
+func (s *session) commit(tree string, message string) error {
+	script, err := s.read("author-script")
+	if err != nil {
+		return err
+	}
+	author, err := parseAuthorScript(script)
+	if err != nil {
+		return err
+	}
+	committer, err := ident.Committer(s.cfg)
+	if err != nil {
+		return err
+	}
+	head, err := refs.ResolveHead()
+	if err != nil {
+		return err
+	}
+	var parents []string
+	if head == "" {
+		fmt.Fprintln(s.errW, "applying to an empty history")
+	} else {
+		parents = append(parents, head)
+	}
+	commit := repr.NewCommitFromFields(tree, parents, author, committer, message)
+	if err := fs.WriteObject(commit); err != nil {
+		return err
+	}
+	tx := refs.NewTransaction(committer)
+	tx.Update(constants.Head, commit.Digest(), refs.OrZero(head), "am: "+commit.Subject())
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return s.write("abort-safety", commit.Digest()+"\n")
+}
+
+// Moves on past the patch the session stopped at
+func (s *session) advance() error {
+	s.next += 1
+	return s.write("next", strconv.Itoa(s.next)+"\n")
+}
+
+// Refuses to apply patches over changes in the index
+func (s *session) checkIndex() error {
+	idx, err := index.Read()
+	if err != nil {
+		return err
+	}
+	head, err := s.headTree()
+	if err != nil {
+		return err
+	}
+	changes, err := diff.TreeIndex(s.db, head, idx.Entries, nil)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	var paths []string
+	for _, change := range changes {
+		paths = append(paths, change.Path())
+	}
+	return formatErrorDirty(paths)
+}
+
+// Brings the index and the files to the tree, empty for none at all
+func (s *session) reset(tree string) error {
+	idx, err := index.Read()
+	if err != nil {
+		return err
+	}
+	if tree == "" {
+		empty := repr.NewTreeFromEntries(nil)
+		if err := fs.WriteObject(empty); err != nil {
+			return err
+		}
+		tree = empty.Digest()
+	}
+	entries, err := worktree.ResetTree(s.db, idx.Entries, tree, ".")
+	if err != nil {
+		return err
+	}
+	return index.Write(&parser.Index{Entries: entries})
+}
+
+// Tells how to go on with the session once the patch is resolved
+func (s *session) stop() {
+	fmt.Fprintln(s.w, "When you have resolved this problem, run \"gitok am --continue\".\n"+
+		"If you prefer to skip this patch, run \"gitok am --skip\" instead.\n"+
+		"To restore the original branch and stop patching, run \"gitok am --abort\".")
+}
+
+func (s *session) say(format string, args ...any) {
+	if !s.opts.Quiet {
+		fmt.Fprintf(s.w, format, args...)
+	}
+}
+
+// Tree of HEAD, empty on an unborn branch
+func (s *session) headTree() (string, error) {
+	head, err := refs.ResolveHead()
+	if err != nil || head == "" {
+		return "", err
+	}
+	return revparse.Peel(head, "tree")
+}
+
+func (s *session) mailName(n int) string {
+	return fmt.Sprintf("%04d", n)
+}
+
+func (s *session) read(name string) (string, error) {
+	content, err := os.ReadFile(filepath.Join(stateDir, name))
+	return string(content), err
+}
+
+func (s *session) write(name string, content string) error {
+	return os.WriteFile(filepath.Join(stateDir, name), []byte(content), 0644)
+}
+
+func subjectOf(message string) string {
+	subject, _, _ := strings.Cut(message, "\n")
+	return subject
+}
+
+// The author of the mail as shell assignments, the way git keeps it
+func authorScript(info *mailinfo.Info) string {
+	var b strings.Builder
+	b.WriteString("GIT_AUTHOR_NAME=" + quoteShell(info.Name) + "\n")
+	b.WriteString("GIT_AUTHOR_EMAIL=" + quoteShell(info.Email) + "\n")
+	b.WriteString("GIT_AUTHOR_DATE=" + quoteShell(info.Date) + "\n")
+	return b.String()
+}
+
+// The author in the assignments of authorScript, at the present time if
+// the mail gives no date
+func parseAuthorScript(script string) (repr.Signature, error) {
+	values := map[string]string{}
+	for _, line := range strings.Split(script, "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		if ok {
+			values[key] = unquoteShell(value)
+		}
+	}
+	author := repr.Signature{
+		Name:  values["GIT_AUTHOR_NAME"],
+		Email: values["GIT_AUTHOR_EMAIL"],
+		When:  time.Now(),
+	}
+	if s := values["GIT_AUTHOR_DATE"]; s != "" {
+		var err error
+		if author.When, err = date.Parse(s); err != nil {
+			return repr.Signature{}, err
+		}
+	}
+	return author, nil
+}
+
+// Single-quotes the text for a shell, closing the quotes around its own
+func quoteShell(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func unquoteShell(s string) string {
+	s = strings.ReplaceAll(s, `'\''`, "'")
+	return strings.TrimSuffix(strings.TrimPrefix(s, "'"), "'")
+}
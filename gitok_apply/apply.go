@@ -0,0 +1,60 @@
+package gitok_apply
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/magnickolas/gitok/apply"
+	"github.com/magnickolas/gitok/config"
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/strerror"
+)
+
+var (
+	ErrorCannotOpenPatch       = errors.New("can't open patch")
+	formatErrorCannotOpenPatch = func(path string, err error) error {
+		return fmt.Errorf("%w '%v': %v", ErrorCannotOpenPatch, path, err)
+	}
+)
+
+// Name of the standard input in messages
+const stdinName = "<stdin>"
+
+type Options struct {
+	Apply apply.Options
+	// the --whitespace action, apply.whitespace if empty
+	Whitespace string
+}
+
+// Applies the patches in the files, or the standard input if there are
+// none or for "-", as configured. Problems go to errW; returns whether
+// all the patches applied
+func Apply(stdin io.Reader, errW io.Writer, paths []string, opts Options) (bool, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return false, err
+	}
+	if err := apply.Configure(errW, cfg, &opts.Apply, opts.Whitespace); err != nil {
+		return false, err
+	}
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	var inputs []apply.Input
+	for _, path := range paths {
+		var content []byte
+		if path == "-" {
+			content, err = io.ReadAll(stdin)
+			path = stdinName
+		} else {
+			content, err = os.ReadFile(path)
+		}
+		if err != nil {
+			return false, formatErrorCannotOpenPatch(path, strerror.Describe(err))
+		}
+		inputs = append(inputs, apply.Input{Name: path, Content: content})
+	}
+	return apply.Apply(errW, fs.Default, inputs, opts.Apply)
+}
@@ -0,0 +1,206 @@
+package gitok_cat
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/revparse"
+)
+
+const DefaultBatchFormat = "%(objectname) %(objecttype) %(objectsize)"
+
+type BatchMode int
+
+const (
+	// print the formatted info line for each object
+	BatchCheck BatchMode = iota
+	// print the info line followed by the object contents
+	BatchContents
+	// read "info <object>", "contents <object>" and "flush" commands
+	BatchCommand
+)
+
+type BatchOptions struct {
+	Mode   BatchMode
+	Format string
+	// flush the output only on "flush" commands or at the end of input
+	Buffer bool
+	// ignore the input and report every object in the repository
+	AllObjects bool
+}
+
+type batcher struct {
+	opts    BatchOptions
+	db      *fs.ObjectDB
+	w       *bufio.Writer
+	hasRest bool
+}
+
+// Answers object requests read line by line from r, writing responses to w
+func Batch(r io.Reader, w io.Writer, opts BatchOptions) error {
+	if opts.Format == "" {
+		opts.Format = DefaultBatchFormat
+	}
+	b := &batcher{
+		opts:    opts,
+		db:      fs.Default,
+		w:       bufio.NewWriter(w),
+		hasRest: strings.Contains(opts.Format, "%(rest)"),
+	}
+	if err := b.validateFormat(); err != nil {
+		return err
+	}
+	if opts.AllObjects {
+		digests, err := b.db.ListObjects()
+		if err != nil {
+			return err
+		}
+		for _, digest := range digests {
+			if err := b.answer(digest, digest, "", opts.Mode == BatchContents); err != nil {
+				return err
+			}
+		}
+		return b.w.Flush()
+	}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		contents := b.opts.Mode == BatchContents
+		if b.opts.Mode == BatchCommand {
+			command, arg, _ := strings.Cut(line, " ")
+			switch command {
+			case "flush":
+				if !b.opts.Buffer {
+					return errors.New("flush is only for --buffer mode")
+				}
+				if err := b.w.Flush(); err != nil {
+					return err
+				}
+				continue
+			case "contents":
+				contents = true
+			case "info":
+			default:
+				return fmt.Errorf("unknown command: '%v'", line)
+			}
+			if arg == "" {
+				return fmt.Errorf("%v requires arguments", command)
+			}
+			line = arg
+		}
+		name, rest := line, ""
+		if b.hasRest {
+			name, rest, _ = strings.Cut(line, " ")
+		}
+		digest, err := revparse.Resolve(name)
+		if errors.Is(err, revparse.ErrorAmbiguousRevision) {
+			if err := b.reply(name + " ambiguous"); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			if err := b.reply(name + " missing"); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := b.answer(name, digest, rest, contents); err != nil {
+			return err
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return b.w.Flush()
+}
+
+func (b *batcher) answer(name string, digest string, rest string, contents bool) error {
+	info, err := b.db.ReadObjectInfo(digest)
+	if errors.Is(err, fs.ErrorObjectNotFound) {
+		return b.reply(name + " missing")
+	} else if err != nil {
+		return err
+	}
+	line := b.expand(digest, info, rest)
+	if !contents {
+		return b.reply(line)
+	}
+	or, err := b.db.OpenObject(digest)
+	if err != nil {
+		return err
+	}
+	defer or.Close()
+	b.w.WriteString(line)
+	b.w.WriteByte('\n')
+	if _, err := io.Copy(b.w, or); err != nil {
+		return err
+	}
+	return b.reply("")
+}
+
+// Writes the line and flushes unless buffering was requested
+func (b *batcher) reply(line string) error {
+	b.w.WriteString(line)
+	if err := b.w.WriteByte('\n'); err != nil {
+		return err
+	}
+	if b.opts.Buffer {
+		return nil
+	}
+	return b.w.Flush()
+}
+
+func (b *batcher) validateFormat() error {
+	format := b.opts.Format
+	for {
+		i := strings.Index(format, "%(")
+		if i == -1 {
+			return nil
+		}
+		end := strings.IndexByte(format[i:], ')')
+		if end == -1 {
+			return fmt.Errorf("format element '%v' does not end in ')'", format[i:])
+		}
+		switch name := format[i+2 : i+end]; name {
+		case "objectname", "objecttype", "objectsize", "objectsize:disk", "deltabase", "rest":
+		default:
+			return fmt.Errorf("unknown format element: %%(%v)", name)
+		}
+		format = format[i+end+1:]
+	}
+}
+
+func (b *batcher) expand(digest string, info *fs.ObjectInfo, rest string) string {
+	var res strings.Builder
+	format := b.opts.Format
+	for {
+		i := strings.Index(format, "%(")
+		if i == -1 {
+			res.WriteString(format)
+			return res.String()
+		}
+		res.WriteString(format[:i])
+		end := strings.IndexByte(format[i:], ')') + i
+		switch format[i+2 : end] {
+		case "objectname":
+			res.WriteString(digest)
+		case "objecttype":
+			res.WriteString(info.Type)
+		case "objectsize":
+			res.WriteString(strconv.FormatInt(info.Size, 10))
+		case "objectsize:disk":
+			res.WriteString(strconv.FormatInt(info.DiskSize, 10))
+		case "deltabase":
+			res.WriteString(info.DeltaBase)
+		case "rest":
+			res.WriteString(rest)
+		}
+		format = format[end+1:]
+	}
+}
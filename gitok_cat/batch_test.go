@@ -0,0 +1,131 @@
+package gitok_cat_test
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/gitok_cat"
+	"github.com/magnickolas/gitok/gitok_init"
+	"github.com/magnickolas/gitok/repr"
+)
+
+// Makes a repository in a temporary directory holding blobs of the
+// contents and returns their digests
+func setupBlobs(t *testing.T, contents ...string) []string {
+	dir := t.TempDir()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	if err := gitok_init.InitRepo("main"); err != nil {
+		t.Fatal(err)
+	}
+	var digests []string
+	for _, content := range contents {
+		blob, err := repr.NewBlob(strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fs.WriteObject(blob); err != nil {
+			t.Fatal(err)
+		}
+		digests = append(digests, blob.Digest())
+	}
+	return digests
+}
+
+func TestBatch(t *testing.T) {
+	digests := setupBlobs(t, "a\n", "bb\n")
+	a, b := digests[0], digests[1]
+	missing := strings.Repeat("0", len(a))
+	sorted := slices.Clone(digests)
+	slices.Sort(sorted)
+	tests := []struct {
+		name  string
+		input string
+		opts  gitok_cat.BatchOptions
+		want  string
+		// whether the input is refused
+		wantErr bool
+	}{
+		{
+			name:  "check",
+			input: a + "\n" + missing + "\n" + b + "\n",
+			opts:  gitok_cat.BatchOptions{Mode: gitok_cat.BatchCheck},
+			want:  a + " blob 2\n" + missing + " missing\n" + b + " blob 3\n",
+		},
+		{
+			name:  "contents",
+			input: a + "\n" + b + "\n",
+			opts:  gitok_cat.BatchOptions{Mode: gitok_cat.BatchContents},
+			want:  a + " blob 2\na\n\n" + b + " blob 3\nbb\n\n",
+		},
+		{
+			name:  "format with the rest of the line",
+			input: a + " some  text\n",
+			opts:  gitok_cat.BatchOptions{Format: "%(objecttype) %(objectsize) [%(rest)]"},
+			want:  "blob 2 [some  text]\n",
+		},
+		{
+			name:  "commands",
+			input: "info " + a + "\ncontents " + b + "\ninfo " + missing + "\n",
+			opts:  gitok_cat.BatchOptions{Mode: gitok_cat.BatchCommand},
+			want:  a + " blob 2\n" + b + " blob 3\nbb\n\n" + missing + " missing\n",
+		},
+		{
+			name:  "buffered commands",
+			input: "info " + a + "\nflush\ninfo " + b + "\n",
+			opts:  gitok_cat.BatchOptions{Mode: gitok_cat.BatchCommand, Buffer: true},
+			want:  a + " blob 2\n" + b + " blob 3\n",
+		},
+		{
+			name:    "flush without buffering",
+			input:   "flush\n",
+			opts:    gitok_cat.BatchOptions{Mode: gitok_cat.BatchCommand},
+			wantErr: true,
+		},
+		{
+			name:    "unknown command",
+			input:   "show " + a + "\n",
+			opts:    gitok_cat.BatchOptions{Mode: gitok_cat.BatchCommand},
+			wantErr: true,
+		},
+		{
+			name:    "unknown format element",
+			input:   a + "\n",
+			opts:    gitok_cat.BatchOptions{Format: "%(objectkind)"},
+			wantErr: true,
+		},
+		{
+			name: "all objects",
+			opts: gitok_cat.BatchOptions{Format: "%(objectname)", AllObjects: true},
+			want: sorted[0] + "\n" + sorted[1] + "\n",
+		},
+	}
+	for _, test := range tests {
+		var out strings.Builder
+		err := gitok_cat.Batch(strings.NewReader(test.input), &out, test.opts)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("incorrect result for %#v: wanted an error, got %#v", test.name, out.String())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("failed to answer %v: %v", test.name, err)
+			continue
+		}
+		if out.String() != test.want {
+			t.Errorf("incorrect result for %#v: wanted %#v, got %#v", test.name, test.want, out.String())
+		}
+	}
+}
@@ -1,24 +1,42 @@
 package gitok_cat
 
 import (
+	"io"
+
 	"github.com/magnickolas/gitok/fs"
-	"github.com/magnickolas/gitok/repr"
 )
 
 func GetObjectType(digest string) (string, error) {
-	o, err := fs.ReadObject(digest)
+	info, err := fs.ReadObjectInfo(digest)
 	if err != nil {
 		return "", err
 	}
-	return o.Type(), nil
+	return info.Type, nil
 }
 
-func CatObject(digest string) (string, error) {
-	o, err := fs.ReadObject(digest)
+func GetObjectSize(digest string) (int64, error) {
+	info, err := fs.ReadObjectInfo(digest)
 	if err != nil {
-		return "", err
+		return 0, err
+	}
+	return info.Size, nil
+}
+
+// Whether the object exists and has a readable header
+func ObjectExists(digest string) bool {
+	_, err := fs.ReadObjectInfo(digest)
+	return err == nil
+}
+
+// Copies the object content to w without holding it in memory
+func CatObject(w io.Writer, digest string) error {
+	or, err := fs.OpenObject(digest)
+	if err != nil {
+		return err
 	}
-	return string(repr.StripObjectHeader(o)), nil
+	defer or.Close()
+	_, err = io.Copy(w, or)
+	return err
 }
 
 func PrettyCatObject(digest string) (string, error) {
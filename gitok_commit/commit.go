@@ -0,0 +1,174 @@
+package gitok_commit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/magnickolas/gitok/config"
+	"github.com/magnickolas/gitok/constants"
+	"github.com/magnickolas/gitok/ident"
+	"github.com/magnickolas/gitok/index"
+	"github.com/magnickolas/gitok/refs"
+	"github.com/magnickolas/gitok/repr"
+	"github.com/magnickolas/gitok/revparse"
+)
+
+var (
+	ErrorEmptyMessage    = errors.New("aborting commit due to empty commit message")
+	ErrorNothingToCommit = errors.New("nothing to commit")
+)
+
+// Creates a commit object for the tree-ish with the given parents and
+// returns its digest
+func CommitTree(tree string, parents []string, message string) (string, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return "", err
+	}
+	tree, err = resolveAs(tree, "tree")
+	if err != nil {
+		return "", err
+	}
+	var parentDigests []string
+	for _, parent := range parents {
+		digest, err := resolveAs(parent, "commit")
+		if err != nil {
+			return "", err
+		}
+		parentDigests = append(parentDigests, digest)
+	}
+	commit, err := ident.NewCommit(cfg, tree, parentDigests, message)
+	if err != nil {
+		return "", err
+	}
+	return commit.Digest(), nil
+}
+
+func resolveAs(rev string, objType string) (string, error) {
+	digest, err := revparse.Resolve(rev)
+	if err != nil {
+		return "", err
+	}
+	return revparse.Peel(digest, objType)
+}
+
+type CommitResult struct {
+	Digest string
+	// short branch name, empty on a detached HEAD
+	Branch string
+	Root   bool
+	Commit *repr.Commit
+}
+
+// Records the index as a new commit on top of HEAD and advances the branch
+// HEAD points to
+func Commit(message string, allowEmpty bool) (*CommitResult, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	message = repr.CleanupMessage(message)
+	if message == "" {
+		return nil, ErrorEmptyMessage
+	}
+	idx, err := index.Read()
+	if err != nil {
+		return nil, err
+	}
+	tree, err := index.WriteTree(idx.Entries)
+	if err != nil {
+		return nil, err
+	}
+	var parents []string
+	head, err := refs.ResolveHead()
+	if err != nil {
+		return nil, err
+	}
+	if head != "" {
+		parents = append(parents, head)
+	}
+	mergeHeads, err := readMergeHeads()
+	if err != nil {
+		return nil, err
+	}
+	parents = append(parents, mergeHeads...)
+	if !allowEmpty && len(parents) <= 1 {
+		// a root commit is compared with the empty tree
+		parentTree := repr.NewTreeFromEntries(nil).Digest()
+		if head != "" {
+			if parentTree, err = revparse.Peel(head, "tree"); err != nil {
+				return nil, err
+			}
+		}
+		if parentTree == tree {
+			return nil, ErrorNothingToCommit
+		}
+	}
+	commit, err := ident.NewCommit(cfg, tree, parents, message)
+	if err != nil {
+		return nil, err
+	}
+	reflogMessage := "commit: " + commit.Subject()
+	oldHead := head
+	if len(parents) == 0 {
+		reflogMessage = "commit (initial): " + commit.Subject()
+		oldHead = repr.ZeroDigest()
+	} else if len(mergeHeads) > 0 {
+		reflogMessage = "commit (merge): " + commit.Subject()
+	}
+	tx := refs.NewTransaction(commit.Committer())
+	tx.Update(constants.Head, commit.Digest(), oldHead, reflogMessage)
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	if err := removeMergeState(); err != nil {
+		return nil, err
+	}
+	target, err := refs.ResolveName(constants.Head)
+	if err != nil {
+		return nil, err
+	}
+	res := &CommitResult{
+		Digest: commit.Digest(),
+		Root:   len(parents) == 0,
+		Commit: commit,
+	}
+	if target != constants.Head {
+		res.Branch = strings.TrimPrefix(target, "refs/heads/")
+	}
+	return res, nil
+}
+
+// Summary line printed after a commit, e.g. "[master (root-commit) 1a2b3c4] subject"
+func (r *CommitResult) String() string {
+	branch := r.Branch
+	if branch == "" {
+		branch = "detached HEAD"
+	}
+	if r.Root {
+		branch += " (root-commit)"
+	}
+	return fmt.Sprintf("[%s %s] %s", branch, r.Digest[:7], r.Commit.Subject())
+}
+
+// Commits of the merge waiting to be committed, none if there is none
+func readMergeHeads() ([]string, error) {
+	content, err := os.ReadFile(filepath.Join(constants.Git, constants.MergeHead))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return strings.Fields(string(content)), err
+}
+
+// Removes what was left for the merge or squash the commit concludes
+func removeMergeState() error {
+	for _, name := range []string{constants.MergeHead, constants.MergeMsg, constants.MergeMode, constants.SquashMsg} {
+		if err := os.Remove(filepath.Join(constants.Git, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
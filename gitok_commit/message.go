@@ -0,0 +1,18 @@
+package gitok_commit
+
+import (
+	"strings"
+)
+
+// Joins -m paragraphs the way git commit-tree does
+func JoinParagraphs(paragraphs []string) string {
+	var b strings.Builder
+	for i, p := range paragraphs {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(p)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
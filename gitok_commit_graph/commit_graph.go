@@ -0,0 +1,84 @@
+package gitok_commit_graph
+
+import (
+	"errors"
+
+	"github.com/magnickolas/gitok/commitgraph"
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/refs"
+	"github.com/magnickolas/gitok/repr"
+)
+
+type WriteOptions struct {
+	Graph commitgraph.WriteOptions
+	// start from the commits of the refs rather than from every commit in
+	// the object database
+	Reachable bool
+}
+
+// Writes the commit graph of the repository
+func Write(opts WriteOptions) error {
+	db := fs.Default
+	var commits []string
+	var err error
+	if opts.Reachable {
+		commits, err = refCommits(db)
+	} else {
+		commits, err = allCommits(db)
+	}
+	if err != nil {
+		return err
+	}
+	return commitgraph.Write(db, commits, opts.Graph)
+}
+
+// Commits the refs point to, following tags
+func refCommits(db *fs.ObjectDB) ([]string, error) {
+	all, err := refs.List("refs/")
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, ref := range all {
+		digest, err := refs.Resolve(ref.Name)
+		if errors.Is(err, refs.ErrorRefNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for {
+			o, err := db.ReadObject(digest)
+			if err != nil {
+				return nil, err
+			}
+			if tag, ok := o.(*repr.Tag); ok {
+				digest = tag.Object()
+				continue
+			}
+			if _, ok := o.(*repr.Commit); ok {
+				res = append(res, digest)
+			}
+			break
+		}
+	}
+	return res, nil
+}
+
+// Every commit of the object database, loose or packed
+func allCommits(db *fs.ObjectDB) ([]string, error) {
+	digests, err := db.ListObjects()
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, digest := range digests {
+		info, err := db.ReadObjectInfo(digest)
+		if err != nil {
+			return nil, err
+		}
+		if info.Type == "commit" {
+			res = append(res, digest)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,243 @@
+package gitok_diff
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/magnickolas/gitok/config"
+	"github.com/magnickolas/gitok/constants"
+	"github.com/magnickolas/gitok/convert"
+	"github.com/magnickolas/gitok/diff"
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/index"
+	"github.com/magnickolas/gitok/pathspec"
+	"github.com/magnickolas/gitok/refs"
+	"github.com/magnickolas/gitok/revparse"
+	"github.com/magnickolas/gitok/revwalk"
+)
+
+var (
+	ErrorTooManyRevisions  = errors.New("too many revisions")
+	ErrorCachedWithTwoRevs = errors.New("--cached compares the index with a single revision")
+	ErrorNoMergeBase       = errors.New("no merge base")
+	formatErrorNoMergeBase = func(a string, b string) error {
+		return fmt.Errorf("%w: %v and %v", ErrorNoMergeBase, a, b)
+	}
+)
+
+// Length of the digests of the raw output of diff
+const porcelainAbbrev = 7
+
+type Options struct {
+	Diff diff.Options
+	// compare the index rather than the worktree (--cached)
+	Cached bool
+}
+
+// Shows the changes from the index to the worktree, from a revision (HEAD
+// with --cached) to the index or the worktree, or between two revisions;
+// "A..B" is the same as "A B" and "A...B" compares B with the merge base.
+// Warnings go to errW
+func Diff(w io.Writer, errW io.Writer, revs []string, paths []string, opts Options) error {
+	revs, err := expandRanges(revs)
+	if err != nil {
+		return err
+	}
+	db := fs.Default
+	ps := pathspec.New(paths)
+	var changes []diff.Change
+	var olds []diff.File
+	switch {
+	case len(revs) > 2:
+		return ErrorTooManyRevisions
+	case len(revs) == 2:
+		if opts.Cached {
+			return ErrorCachedWithTwoRevs
+		}
+		if changes, olds, err = diffTrees(db, revs[0], revs[1], ps, opts.Diff); err != nil {
+			return err
+		}
+	case len(revs) == 1 || opts.Cached:
+		tree, err := headTree()
+		if len(revs) == 1 {
+			tree, err = revparse.Peel(revs[0], "tree")
+		}
+		if err != nil {
+			return err
+		}
+		if changes, olds, err = diffIndex(db, tree, ps, opts); err != nil {
+			return err
+		}
+	default:
+		if changes, olds, err = diffFiles(ps, opts.Diff); err != nil {
+			return err
+		}
+	}
+	if changes, err = findRenames(errW, db, changes, olds, opts.Diff); err != nil {
+		return err
+	}
+	if opts.Diff.Format == 0 {
+		opts.Diff.Format = diff.FormatPatch
+	}
+	opts.Diff.Abbrev = porcelainAbbrev
+	return diff.Write(w, db, changes, opts.Diff)
+}
+
+// Detects the renames the options ask for, warning to errW if there were
+// too many files to look for them by similarity
+func findRenames(errW io.Writer, db *fs.ObjectDB, changes []diff.Change, olds []diff.File, opts diff.Options) ([]diff.Change, error) {
+	changes, hit, err := diff.FindRenames(db, changes, olds, opts)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(errW, hit.Warning()); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// Revisions with ranges replaced by their ends: the missing end of "A.."
+// or "..B" is HEAD and the start of "A...B" is the merge base
+func expandRanges(revs []string) ([]string, error) {
+	var res []string
+	for _, rev := range revs {
+		sep := "..."
+		from, to, found := strings.Cut(rev, sep)
+		if !found {
+			sep = ".."
+			from, to, found = strings.Cut(rev, sep)
+		}
+		if !found {
+			digest, err := revparse.Resolve(rev)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, digest)
+			continue
+		}
+		if from == "" {
+			from = constants.Head
+		}
+		if to == "" {
+			to = constants.Head
+		}
+		fromDigest, err := resolveCommit(from)
+		if err != nil {
+			return nil, err
+		}
+		toDigest, err := resolveCommit(to)
+		if err != nil {
+			return nil, err
+		}
+		if sep == "..." {
+			bases, err := revwalk.MergeBases(fs.Default, fromDigest, toDigest)
+			if err != nil {
+				return nil, err
+			}
+			if len(bases) == 0 {
+				return nil, formatErrorNoMergeBase(from, to)
+			}
+			fromDigest = bases[0]
+		}
+		res = append(res, fromDigest, toDigest)
+	}
+	return res, nil
+}
+
+func resolveCommit(rev string) (string, error) {
+	digest, err := revparse.Resolve(rev)
+	if err != nil {
+		return "", err
+	}
+	return revparse.Peel(digest, "commit")
+}
+
+// Tree of HEAD, the empty tree on an unborn branch
+func headTree() (string, error) {
+	head, err := refs.Resolve(constants.Head)
+	if errors.Is(err, refs.ErrorRefNotFound) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return revparse.Peel(head, "tree")
+}
+
+func worktreeOptions() (diff.WorktreeOptions, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return diff.WorktreeOptions{}, err
+	}
+	trustFileMode, err := cfg.GetBool("core.fileMode", true)
+	if err != nil {
+		return diff.WorktreeOptions{}, err
+	}
+	conv, err := convert.NewConverter(cfg)
+	if err != nil {
+		return diff.WorktreeOptions{}, err
+	}
+	return diff.WorktreeOptions{TrustFileMode: trustFileMode, Converter: conv}, nil
+}
+
+// Changes between two tree-ishes, with the files of the old one if they
+// are sources of copies
+func diffTrees(db *fs.ObjectDB, oldRev string, newRev string, ps *pathspec.Pathspec, opts diff.Options) ([]diff.Change, []diff.File, error) {
+	oldTree, err := revparse.Peel(oldRev, "tree")
+	if err != nil {
+		return nil, nil, err
+	}
+	newTree, err := revparse.Peel(newRev, "tree")
+	if err != nil {
+		return nil, nil, err
+	}
+	changes, err := diff.Trees(db, oldTree, newTree, ps)
+	if err != nil || !opts.FindCopiesHarder {
+		return changes, nil, err
+	}
+	olds, err := diff.TreeFiles(db, oldTree, ps)
+	return changes, olds, err
+}
+
+// Changes from the tree to the index, or to the worktree unless cached,
+// with the files of the tree if they are sources of copies
+func diffIndex(db *fs.ObjectDB, tree string, ps *pathspec.Pathspec, opts Options) ([]diff.Change, []diff.File, error) {
+	idx, err := index.Read()
+	if err != nil {
+		return nil, nil, err
+	}
+	var changes []diff.Change
+	if opts.Cached {
+		changes, err = diff.TreeIndex(db, tree, idx.Entries, ps)
+	} else {
+		var wopts diff.WorktreeOptions
+		if wopts, err = worktreeOptions(); err != nil {
+			return nil, nil, err
+		}
+		changes, err = diff.TreeWorktree(db, tree, idx.Entries, ps, wopts)
+	}
+	if err != nil || !opts.Diff.FindCopiesHarder {
+		return changes, nil, err
+	}
+	olds, err := diff.TreeFiles(db, tree, ps)
+	return changes, olds, err
+}
+
+// Changes from the index to the worktree, with the files of the index if
+// they are sources of copies
+func diffFiles(ps *pathspec.Pathspec, opts diff.Options) ([]diff.Change, []diff.File, error) {
+	idx, err := index.Read()
+	if err != nil {
+		return nil, nil, err
+	}
+	wopts, err := worktreeOptions()
+	if err != nil {
+		return nil, nil, err
+	}
+	changes, err := diff.IndexWorktree(idx.Entries, ps, wopts)
+	if err != nil || !opts.FindCopiesHarder {
+		return changes, nil, err
+	}
+	return changes, diff.IndexFiles(idx.Entries, ps), nil
+}
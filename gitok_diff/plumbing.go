@@ -0,0 +1,140 @@
+package gitok_diff
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/magnickolas/gitok/diff"
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/pathspec"
+	"github.com/magnickolas/gitok/revparse"
+)
+
+var (
+	ErrorTreeArgs = errors.New("diff-tree takes one commit or two trees")
+)
+
+type TreeOptions struct {
+	Diff diff.Options
+	// recurse into subtrees (-r), implied by all but the raw and name
+	// formats
+	Recursive bool
+	// show the files of root commits as added (--root)
+	Root bool
+}
+
+// Compares two trees, or a commit with its parent after a line with the
+// commit's digest; merges are not shown, nor root commits unless asked.
+// Warnings go to errW
+func DiffTree(w io.Writer, errW io.Writer, revs []string, paths []string, opts TreeOptions) error {
+	db := fs.Default
+	ps := pathspec.New(paths)
+	if opts.Diff.Format == 0 {
+		opts.Diff.Format = diff.FormatRaw
+	}
+	recursive := opts.Recursive || opts.Diff.Format&^(diff.FormatRaw|diff.FormatNameOnly|diff.FormatNameStatus) != 0
+	var trees [2]string
+	switch len(revs) {
+	case 1:
+		digest, err := resolveCommit(revs[0])
+		if err != nil {
+			return err
+		}
+		commit, err := db.ReadCommit(digest)
+		if err != nil {
+			return err
+		}
+		switch parents := commit.Parents(); {
+		case len(parents) > 1 || (len(parents) == 0 && !opts.Root):
+			return nil
+		case len(parents) == 1:
+			if trees[0], err = revparse.Peel(parents[0], "tree"); err != nil {
+				return err
+			}
+		}
+		trees[1] = commit.TreeDigest()
+		changes, err := treeChanges(errW, db, trees, ps, recursive, opts.Diff)
+		if err != nil || len(changes) == 0 {
+			return err
+		}
+		if _, err := fmt.Fprintln(w, digest); err != nil {
+			return err
+		}
+		return diff.Write(w, db, changes, opts.Diff)
+	case 2:
+		for i, rev := range revs {
+			digest, err := revparse.Resolve(rev)
+			if err != nil {
+				return err
+			}
+			if trees[i], err = revparse.Peel(digest, "tree"); err != nil {
+				return err
+			}
+		}
+		changes, err := treeChanges(errW, db, trees, ps, recursive, opts.Diff)
+		if err != nil {
+			return err
+		}
+		return diff.Write(w, db, changes, opts.Diff)
+	}
+	return ErrorTreeArgs
+}
+
+// Changes between the trees, with the renames the options ask for
+func treeChanges(errW io.Writer, db *fs.ObjectDB, trees [2]string, ps *pathspec.Pathspec, recursive bool, opts diff.Options) ([]diff.Change, error) {
+	treeDiff := diff.TopLevel
+	if recursive {
+		treeDiff = diff.Trees
+	}
+	changes, err := treeDiff(db, trees[0], trees[1], ps)
+	if err != nil {
+		return nil, err
+	}
+	var olds []diff.File
+	if opts.FindCopiesHarder {
+		if olds, err = diff.TreeFiles(db, trees[0], ps); err != nil {
+			return nil, err
+		}
+	}
+	return findRenames(errW, db, changes, olds, opts)
+}
+
+// Compares a tree with the worktree files in the index, or with the index
+// itself if cached; warnings go to errW
+func DiffIndex(w io.Writer, errW io.Writer, rev string, paths []string, opts Options) error {
+	digest, err := revparse.Resolve(rev)
+	if err != nil {
+		return err
+	}
+	tree, err := revparse.Peel(digest, "tree")
+	if err != nil {
+		return err
+	}
+	changes, olds, err := diffIndex(fs.Default, tree, pathspec.New(paths), opts)
+	if err != nil {
+		return err
+	}
+	if changes, err = findRenames(errW, fs.Default, changes, olds, opts.Diff); err != nil {
+		return err
+	}
+	if opts.Diff.Format == 0 {
+		opts.Diff.Format = diff.FormatRaw
+	}
+	return diff.Write(w, fs.Default, changes, opts.Diff)
+}
+
+// Compares the index with the worktree; warnings go to errW
+func DiffFiles(w io.Writer, errW io.Writer, paths []string, opts diff.Options) error {
+	changes, olds, err := diffFiles(pathspec.New(paths), opts)
+	if err != nil {
+		return err
+	}
+	if changes, err = findRenames(errW, fs.Default, changes, olds, opts); err != nil {
+		return err
+	}
+	if opts.Format == 0 {
+		opts.Format = diff.FormatRaw
+	}
+	return diff.Write(w, fs.Default, changes, opts)
+}
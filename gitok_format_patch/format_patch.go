@@ -0,0 +1,613 @@
+package gitok_format_patch
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/magnickolas/gitok/config"
+	"github.com/magnickolas/gitok/constants"
+	"github.com/magnickolas/gitok/diff"
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/ident"
+	"github.com/magnickolas/gitok/pretty"
+	"github.com/magnickolas/gitok/refs"
+	"github.com/magnickolas/gitok/repr"
+	"github.com/magnickolas/gitok/revparse"
+	"github.com/magnickolas/gitok/revwalk"
+)
+
+var (
+	ErrorKeepSubjectWithPrefix = errors.New("--subject-prefix/--rfc and -k are mutually exclusive")
+	ErrorNumberedWithKeep      = errors.New("-n and -k are mutually exclusive")
+	ErrorBaseInList            = errors.New("base commit shouldn't be in revision list")
+	ErrorBaseNotAncestor       = errors.New("base commit should be the ancestor of revision list")
+	ErrorNoUpstream            = errors.New("failed to get upstream, if you want to record base commit automatically,\n" +
+		"please use git branch --set-upstream-to to track a remote branch.\n" +
+		"Or you could specify base commit by --base=<base-commit-id> manually")
+)
+
+// Columns of the diffstats of mails
+const mailStatWidth = 72
+
+// Longest file name of a patch, its suffix included
+const patchNameMax = 64
+
+// Subject and text of cover letters, to be filled in
+const (
+	coverSubject = "*** SUBJECT HERE ***"
+	coverBlurb   = "*** BLURB HERE ***"
+)
+
+// Signature of the mails unless format.signature gives one
+const defaultSignature = "gitok"
+
+// Columns the subjects of the shortlog of cover letters are wrapped at,
+// and their indentation
+const (
+	shortlogWrap    = 72
+	shortlogIndent1 = 2
+	shortlogIndent2 = 4
+)
+
+type Options struct {
+	Diff diff.Options
+	// the number of commits from the revision, -1 to take the revision
+	// for where the patches start from
+	MaxCount int
+	// all the history of the revision, with the root commit
+	Root bool
+	// write the mails to the writer rather than to files in the directory
+	Stdout    bool
+	OutputDir string
+	// number the subjects even of a single patch, or of none at all
+	Numbered   bool
+	NoNumbered bool
+	// number of the first patch
+	StartNumber int
+	// "PATCH" if nil
+	SubjectPrefix *string
+	RFC           bool
+	// version of the series, put before numbers and in file names
+	RerollCount string
+	CoverLetter bool
+	// commit the series applies to, "auto" for the fork point from the
+	// upstream branch
+	Base string
+	// leave the subjects as they are
+	KeepSubject bool
+	// name the files by number alone
+	NumberedFiles bool
+	// of the file names, ".patch" if empty
+	Suffix string
+	// zeros for the digest on the From lines
+	ZeroCommit bool
+	// the end of the mails; format.signature or gitok's if nil
+	Signature *string
+	NoStat    bool
+}
+
+type formatter struct {
+	w    io.Writer
+	db   *fs.ObjectDB
+	cfg  *config.Config
+	opts Options
+	// expands the placeholders giving subjects
+	fields *pretty.Formatter
+	total  int
+	// "PATCH" and what follows it before the numbers of the patches
+	prefix string
+	// the base commit and the patch ids of the commits between it and the
+	// series, shown once
+	baseInfo string
+}
+
+// Writes each commit of the revisions that is not a merge as a mail with
+// its patch, oldest first, to a file named after its subject, printing
+// the name, or to w. A single revision stands for the commits since it
+func FormatPatch(w io.Writer, revs []string, opts Options) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	if opts.KeepSubject && (opts.SubjectPrefix != nil || opts.RFC) {
+		return ErrorKeepSubjectWithPrefix
+	}
+	if opts.KeepSubject && opts.Numbered {
+		return ErrorNumberedWithKeep
+	}
+	f := &formatter{w: w, db: fs.Default, cfg: cfg, opts: opts}
+	f.fields = pretty.NewFormatter(f.db, pretty.Options{Format: pretty.User})
+	commits, err := f.list(revs)
+	if err != nil {
+		return err
+	}
+	if len(commits) == 0 {
+		return nil
+	}
+	if f.opts.Signature == nil {
+		signature, ok := cfg.Get("format.signature")
+		if !ok {
+			signature = defaultSignature
+		}
+		f.opts.Signature = &signature
+	}
+	if f.opts.Suffix == "" {
+		f.opts.Suffix = ".patch"
+	}
+	if f.opts.StartNumber <= 0 {
+		f.opts.StartNumber = 1
+	}
+	f.setPrefix(len(commits))
+	if opts.Base != "" {
+		if f.baseInfo, err = f.bases(commits); err != nil {
+			return err
+		}
+	}
+	if !opts.Stdout {
+		if err := os.MkdirAll(f.dir(), os.ModePerm); err != nil {
+			return err
+		}
+	}
+	if opts.CoverLetter {
+		if err := f.coverLetter(commits); err != nil {
+			return err
+		}
+	}
+	for i, commit := range commits {
+		if err := f.patch(commit, f.opts.StartNumber+i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Commits of the series, oldest first
+func (f *formatter) list(revs []string) ([]*pretty.Commit, error) {
+	walker := revwalk.NewWalker(f.db, revwalk.Options{MaxCount: -1})
+	if len(revs) == 0 {
+		revs = []string{constants.Head}
+	}
+	// a lone revision is where the series starts, unless counting
+	// commits from it or going back to the root
+	if len(revs) == 1 && f.opts.MaxCount < 0 && !f.opts.Root && !isRange(revs[0]) {
+		revs = []string{revs[0] + ".." + constants.Head}
+	}
+	for _, rev := range revs {
+		if err := walker.AddRevision(rev); err != nil {
+			return nil, err
+		}
+	}
+	var commits []*pretty.Commit
+	for f.opts.MaxCount < 0 || len(commits) < f.opts.MaxCount {
+		digest, err := walker.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		commit, err := f.db.ReadCommit(digest)
+		if err != nil {
+			return nil, err
+		}
+		if len(commit.Parents()) > 1 {
+			continue
+		}
+		commits = append(commits, &pretty.Commit{Digest: digest, Parents: commit.Parents(), Commit: commit})
+	}
+	slices.Reverse(commits)
+	return commits, nil
+}
+
+func isRange(rev string) bool {
+	return strings.Contains(rev, "..") || strings.HasPrefix(rev, "^") || strings.HasSuffix(rev, "^!") || strings.HasSuffix(rev, "^@")
+}
+
+// Numbers the patches if there are several or a cover letter, unless
+// told otherwise, and sets the subject prefix
+func (f *formatter) setPrefix(n int) {
+	numbered := f.opts.Numbered || f.opts.CoverLetter || (n > 1 && !f.opts.KeepSubject)
+	if f.opts.NoNumbered && !f.opts.CoverLetter {
+		numbered = false
+	}
+	prefix := "PATCH"
+	if f.opts.SubjectPrefix != nil {
+		prefix = *f.opts.SubjectPrefix
+	} else if f.opts.KeepSubject {
+		prefix = ""
+	}
+	if f.opts.RFC {
+		prefix = "RFC " + prefix
+	}
+	if f.opts.RerollCount != "" {
+		prefix += " v" + f.opts.RerollCount
+	}
+	f.prefix = strings.TrimSpace(prefix)
+	if numbered {
+		f.total = f.opts.StartNumber - 1 + n
+	}
+}
+
+// What goes before the subject of the nth mail
+func (f *formatter) subjectPrefix(nr int) string {
+	if f.total > 0 {
+		sep := ""
+		if f.prefix != "" {
+			sep = " "
+		}
+		return fmt.Sprintf("[%v%v%0*d/%d] ", f.prefix, sep, len(strconv.Itoa(f.total)), nr, f.total)
+	}
+	if f.prefix != "" {
+		return "[" + f.prefix + "] "
+	}
+	return ""
+}
+
+func (f *formatter) dir() string {
+	if f.opts.OutputDir == "" {
+		return "."
+	}
+	return f.opts.OutputDir
+}
+
+// Opens the file of the nth patch, whose name is made from the subject,
+// and prints its name; the writer of the options with --stdout
+func (f *formatter) open(nr int, subject string) (io.Writer, func() error, error) {
+	if f.opts.Stdout {
+		return f.w, func() error { return nil }, nil
+	}
+	var name string
+	if f.opts.NumberedFiles {
+		name = strconv.Itoa(nr)
+	} else {
+		if f.opts.RerollCount != "" {
+			name = "v" + f.opts.RerollCount + "-"
+		}
+		name += fmt.Sprintf("%04d-%v", nr, subject)
+		if limit := patchNameMax - len(f.opts.Suffix) - 1; len(name) > limit {
+			name = name[:limit]
+		}
+		name += f.opts.Suffix
+	}
+	if f.opts.OutputDir != "" {
+		name = strings.TrimRight(f.opts.OutputDir, "/") + "/" + name
+	}
+	file, err := os.Create(filepath.FromSlash(name))
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := fmt.Fprintln(f.w, name); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, file.Close, nil
+}
+
+// Writes the commit as a mail with its changes
+func (f *formatter) patch(commit *pretty.Commit, nr int) (err error) {
+	w, closeFile, err := f.open(nr, f.fields.Expand("%f", commit))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := closeFile(); err == nil {
+			err = closeErr
+		}
+	}()
+	// patches after the first are set off by a blank line in an mbox
+	if f.opts.Stdout && nr != f.opts.StartNumber {
+		if _, err := io.WriteString(w, "\n"); err != nil {
+			return err
+		}
+	}
+	mail := *commit
+	if f.opts.ZeroCommit {
+		mail.Digest = repr.ZeroDigest()
+	}
+	formatter := pretty.NewFormatter(f.db, pretty.Options{Format: pretty.Email, SubjectPrefix: f.subjectPrefix(nr)})
+	if _, err := io.WriteString(w, formatter.Format(&mail)); err != nil {
+		return err
+	}
+	opts := f.opts.Diff
+	opts.Format = diff.FormatPatch
+	opts.Binary = true
+	separator := "\n"
+	if !f.opts.NoStat {
+		opts.Format |= diff.FormatStat | diff.FormatSummary
+		opts.StatWidth = mailStatWidth
+		separator = "---\n"
+	}
+	if _, err := io.WriteString(w, separator); err != nil {
+		return err
+	}
+	changes, err := f.changes(commit, opts)
+	if err != nil {
+		return err
+	}
+	if err := diff.Write(w, f.db, changes, opts); err != nil {
+		return err
+	}
+	if !f.opts.CoverLetter && nr == f.opts.StartNumber {
+		if _, err := io.WriteString(w, f.baseInfo); err != nil {
+			return err
+		}
+	}
+	return f.finish(w)
+}
+
+// Writes the signature ending the mail
+func (f *formatter) finish(w io.Writer) error {
+	if *f.opts.Signature == "" {
+		return nil
+	}
+	_, err := fmt.Fprintf(w, "-- \n%v\n\n", *f.opts.Signature)
+	return err
+}
+
+// Changes of the commit from its parent, with renames found as the
+// options say
+func (f *formatter) changes(commit *pretty.Commit, opts diff.Options) ([]diff.Change, error) {
+	parentTree, err := f.parentTree(commit.Commit)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := diff.Trees(f.db, parentTree, commit.TreeDigest(), nil)
+	if err != nil {
+		return nil, err
+	}
+	changes, _, err = diff.FindRenames(f.db, changes, nil, opts)
+	return changes, err
+}
+
+func (f *formatter) parentTree(commit *repr.Commit) (string, error) {
+	if len(commit.Parents()) == 0 {
+		return "", nil
+	}
+	parent, err := f.db.ReadCommit(commit.Parents()[0])
+	if err != nil {
+		return "", err
+	}
+	return parent.TreeDigest(), nil
+}
+
+// Writes the cover letter of the series: a mail from the committer to be
+// filled in, with the shortlog and the diffstat of the series
+func (f *formatter) coverLetter(commits []*pretty.Commit) (err error) {
+	committer, err := ident.Committer(f.cfg)
+	if err != nil {
+		return err
+	}
+	w, closeFile, err := f.open(0, "cover-letter")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := closeFile(); err == nil {
+			err = closeErr
+		}
+	}()
+	tip := commits[len(commits)-1]
+	digest := tip.Digest
+	if f.opts.ZeroCommit {
+		digest = repr.ZeroDigest()
+	}
+	eightBit := false
+	for _, commit := range commits {
+		eightBit = eightBit || pretty.HasNonASCII(commit.String())
+	}
+	var b strings.Builder
+	b.WriteString(pretty.MailFromLine(digest))
+	b.WriteString(pretty.MailHeaders(committer, f.subjectPrefix(0), coverSubject, eightBit))
+	b.WriteString("\n" + coverBlurb + "\n\n")
+	b.WriteString(f.shortlog(commits))
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return err
+	}
+	if boundary := f.boundary(commits); boundary != nil {
+		if err := f.coverStat(w, boundary, tip); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, "\n"); err != nil {
+			return err
+		}
+	}
+	if _, err := io.WriteString(w, f.baseInfo); err != nil {
+		return err
+	}
+	return f.finish(w)
+}
+
+// The subjects of the commits by author, oldest first
+func (f *formatter) shortlog(commits []*pretty.Commit) string {
+	var authors []string
+	subjects := make(map[string][]string)
+	for _, commit := range commits {
+		author := commit.Author().Name
+		if _, ok := subjects[author]; !ok {
+			authors = append(authors, author)
+		}
+		subjects[author] = append(subjects[author], f.fields.Expand("%s", commit))
+	}
+	slices.Sort(authors)
+	var b strings.Builder
+	for _, author := range authors {
+		fmt.Fprintf(&b, "%v (%d):\n", author, len(subjects[author]))
+		for _, subject := range subjects[author] {
+			b.WriteString(pretty.Wrap(subject, shortlogIndent1, shortlogIndent2, shortlogWrap))
+			b.WriteString("\n")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// The single parent the series starts from, nil if it starts from a root
+// commit or from several parents
+func (f *formatter) boundary(commits []*pretty.Commit) *repr.Commit {
+	inSeries := make(map[string]bool)
+	for _, commit := range commits {
+		inSeries[commit.Digest] = true
+	}
+	var boundary string
+	for _, commit := range commits {
+		for _, parent := range commit.Parents {
+			if inSeries[parent] {
+				continue
+			}
+			if boundary != "" && boundary != parent {
+				return nil
+			}
+			boundary = parent
+		}
+	}
+	if boundary == "" {
+		return nil
+	}
+	commit, err := f.db.ReadCommit(boundary)
+	if err != nil {
+		return nil
+	}
+	return commit
+}
+
+// Writes the diffstat and summary of the changes of the whole series
+func (f *formatter) coverStat(w io.Writer, boundary *repr.Commit, tip *pretty.Commit) error {
+	opts := f.opts.Diff
+	opts.Format = diff.FormatStat | diff.FormatSummary
+	opts.StatWidth = mailStatWidth
+	changes, err := diff.Trees(f.db, boundary.TreeDigest(), tip.TreeDigest(), nil)
+	if err != nil {
+		return err
+	}
+	if changes, _, err = diff.FindRenames(f.db, changes, nil, opts); err != nil {
+		return err
+	}
+	return diff.Write(w, f.db, changes, opts)
+}
+
+// The base commit of the series and the patch ids of the commits between
+// it and the series, which must be descended from it
+func (f *formatter) bases(commits []*pretty.Commit) (string, error) {
+	base, err := f.baseCommit(commits)
+	if err != nil {
+		return "", err
+	}
+	inSeries := make(map[string]bool)
+	for _, commit := range commits {
+		if commit.Digest == base {
+			return "", ErrorBaseInList
+		}
+		inSeries[commit.Digest] = true
+	}
+	ok, err := revwalk.IsAncestor(f.db, base, commits[0].Digest)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrorBaseNotAncestor
+	}
+	walker := revwalk.NewWalker(f.db, revwalk.Options{MaxCount: -1, Sort: revwalk.SortTopo})
+	if err := walker.Hide(base); err != nil {
+		return "", err
+	}
+	for _, commit := range commits {
+		if err := walker.Push(commit.Digest); err != nil {
+			return "", err
+		}
+	}
+	var ids []string
+	for {
+		digest, err := walker.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		if inSeries[digest] {
+			continue
+		}
+		commit, err := f.db.ReadCommit(digest)
+		if err != nil {
+			return "", err
+		}
+		if len(commit.Parents()) > 1 {
+			continue
+		}
+		parentTree, err := f.parentTree(commit)
+		if err != nil {
+			return "", err
+		}
+		changes, err := diff.Trees(f.db, parentTree, commit.TreeDigest(), nil)
+		if err != nil {
+			return "", err
+		}
+		id, err := diff.PatchID(f.db, changes)
+		if err != nil {
+			return "", err
+		}
+		ids = append(ids, id)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "\nbase-commit: %v\n", base)
+	for i := len(ids) - 1; i >= 0; i -= 1 {
+		fmt.Fprintf(&b, "prerequisite-patch-id: %v\n", ids[i])
+	}
+	return b.String(), nil
+}
+
+// The commit --base names, or for "auto" the fork point of the series
+// from the upstream of the current branch
+func (f *formatter) baseCommit(commits []*pretty.Commit) (string, error) {
+	if f.opts.Base != "auto" {
+		digest, err := revparse.Resolve(f.opts.Base)
+		if err != nil {
+			return "", err
+		}
+		return revparse.Peel(digest, "commit")
+	}
+	upstream, err := f.upstream()
+	if err != nil {
+		return "", err
+	}
+	bases, err := revwalk.MergeBases(f.db, upstream, commits[0].Digest)
+	if err != nil {
+		return "", err
+	}
+	if len(bases) == 0 {
+		return "", ErrorNoUpstream
+	}
+	return bases[0], nil
+}
+
+// Commit of the branch the current one tracks, by branch.<name>.remote
+// and branch.<name>.merge
+func (f *formatter) upstream() (string, error) {
+	target, err := refs.ResolveName(constants.Head)
+	if err != nil {
+		return "", err
+	}
+	branch, found := strings.CutPrefix(target, "refs/heads/")
+	if !found {
+		return "", ErrorNoUpstream
+	}
+	remote, _ := f.cfg.Get("branch." + branch + ".remote")
+	merge, _ := f.cfg.Get("branch." + branch + ".merge")
+	name, found := strings.CutPrefix(merge, "refs/heads/")
+	if remote == "" || !found {
+		return "", ErrorNoUpstream
+	}
+	ref := merge
+	if remote != "." {
+		ref = "refs/remotes/" + remote + "/" + name
+	}
+	digest, err := refs.Resolve(ref)
+	if err != nil {
+		return "", ErrorNoUpstream
+	}
+	return digest, nil
+}
@@ -0,0 +1,222 @@
+package gitok_fsck
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/magnickolas/gitok/refs"
+	"github.com/magnickolas/gitok/repr"
+)
+
+type Severity int
+
+const (
+	// reported as a warning even in strict mode
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+// Problem found in an object, identified like git's fsck message ids
+type Problem struct {
+	ID       string
+	Severity Severity
+	Message  string
+}
+
+// Reference from an object to another one
+type link struct {
+	digest  string
+	objType string
+}
+
+func problem(sev Severity, id string, format string, args ...any) Problem {
+	return Problem{ID: id, Severity: sev, Message: fmt.Sprintf(format, args...)}
+}
+
+// Validates the content of an object, returning the problems found and the
+// objects it refers to
+func checkObject(objType string, content []byte, strict bool) ([]Problem, []link) {
+	switch objType {
+	case "tree":
+		return checkTree(content, strict)
+	case "commit":
+		return checkCommit(content)
+	case "tag":
+		return checkTag(content)
+	}
+	return nil, nil
+}
+
+// Fsck ids of the tree problems found by repr.CheckTree
+var treeProblems = map[error]Problem{
+	repr.ErrorNullSha1:           {"nullSha1", SeverityWarning, "contains entries pointing to null sha1"},
+	repr.ErrorFullPathname:       {"fullPathname", SeverityWarning, "contains full pathnames"},
+	repr.ErrorEmptyName:          {"emptyName", SeverityWarning, "contains empty pathname"},
+	repr.ErrorHasDot:             {"hasDot", SeverityWarning, "contains '.'"},
+	repr.ErrorHasDotdot:          {"hasDotdot", SeverityWarning, "contains '..'"},
+	repr.ErrorHasDotgit:          {"hasDotgit", SeverityWarning, "contains '.git'"},
+	repr.ErrorZeroPaddedFilemode: {"zeroPaddedFilemode", SeverityWarning, "contains zero-padded file modes"},
+	repr.ErrorBadFilemode:        {"badFilemode", SeverityInfo, "contains bad file modes"},
+	repr.ErrorDuplicateEntries:   {"duplicateEntries", SeverityError, "contains duplicate file entries"},
+	repr.ErrorTreeNotSorted:      {"treeNotSorted", SeverityError, "not properly sorted"},
+	repr.ErrorBadTree:            {"badTree", SeverityError, "cannot be parsed as a tree"},
+}
+
+func checkTree(content []byte, strict bool) ([]Problem, []link) {
+	var problems []Problem
+	for _, err := range repr.CheckTree(content, strict) {
+		problems = append(problems, treeProblems[err])
+	}
+	tree, err := repr.NewTree(bytes.NewReader(content))
+	if err != nil {
+		if len(problems) == 0 {
+			problems = append(problems, treeProblems[repr.ErrorBadTree])
+		}
+		return problems, nil
+	}
+	var links []link
+	for _, entry := range tree.Entries() {
+		if entry.Mode != repr.ModeGitlink {
+			// gitlinks point into other repositories
+			links = append(links, link{entry.Digest, entry.Type()})
+		}
+	}
+	return problems, links
+}
+
+// Consumes "key value" header lines up to the blank line preceding the
+// message
+type headerReader struct {
+	lines []string
+}
+
+func newHeaderReader(content []byte) *headerReader {
+	header, _, _ := bytes.Cut(content, []byte("\n\n"))
+	return &headerReader{lines: strings.Split(string(header), "\n")}
+}
+
+// Value of the next line if it has the given key
+func (h *headerReader) next(key string) (string, bool) {
+	if len(h.lines) == 0 {
+		return "", false
+	}
+	value, found := strings.CutPrefix(h.lines[0], key+" ")
+	if !found {
+		return "", false
+	}
+	h.lines = h.lines[1:]
+	return value, true
+}
+
+func checkIdent(line string) *Problem {
+	lt := strings.IndexByte(line, '<')
+	gt := strings.IndexByte(line, '>')
+	switch {
+	case lt == -1:
+		p := problem(SeverityError, "missingEmail", "invalid author/committer line - missing email")
+		return &p
+	case gt < lt || strings.ContainsAny(line[lt+1:gt], "<"):
+		p := problem(SeverityError, "badEmail", "invalid author/committer line - bad email")
+		return &p
+	case lt == 0 || line[lt-1] != ' ':
+		p := problem(SeverityError, "missingSpaceBeforeEmail", "invalid author/committer line - missing space before email")
+		return &p
+	}
+	fields := strings.Split(strings.TrimPrefix(line[gt+1:], " "), " ")
+	if len(fields) != 2 {
+		p := problem(SeverityError, "badDate", "invalid author/committer line - bad date")
+		return &p
+	}
+	if _, err := strconv.ParseUint(fields[0], 10, 64); err != nil {
+		p := problem(SeverityError, "badDate", "invalid author/committer line - bad date")
+		return &p
+	}
+	if _, err := repr.ParseTimezone(fields[1]); err != nil {
+		p := problem(SeverityError, "badTimezone", "invalid author/committer line - bad time zone")
+		return &p
+	}
+	return nil
+}
+
+func checkCommit(content []byte) ([]Problem, []link) {
+	if !bytes.Contains(content, []byte("\n\n")) && !bytes.HasSuffix(content, []byte("\n")) {
+		return []Problem{problem(SeverityError, "unterminatedHeader", "unterminated header")}, nil
+	}
+	h := newHeaderReader(content)
+	var links []link
+	tree, ok := h.next("tree")
+	if !ok {
+		return []Problem{problem(SeverityError, "missingTree", "invalid format - expected 'tree' line")}, nil
+	}
+	if !repr.IsValidDigest(tree) {
+		return []Problem{problem(SeverityError, "badTreeSha1", "invalid 'tree' line format - bad sha1")}, nil
+	}
+	links = append(links, link{tree, "tree"})
+	for {
+		parent, ok := h.next("parent")
+		if !ok {
+			break
+		}
+		if !repr.IsValidDigest(parent) {
+			return []Problem{problem(SeverityError, "badParentSha1", "invalid 'parent' line format - bad sha1")}, links
+		}
+		links = append(links, link{parent, "commit"})
+	}
+	author, ok := h.next("author")
+	if !ok {
+		return []Problem{problem(SeverityError, "missingAuthor", "invalid format - expected 'author' line")}, links
+	}
+	if p := checkIdent(author); p != nil {
+		return []Problem{*p}, links
+	}
+	committer, ok := h.next("committer")
+	if !ok {
+		return []Problem{problem(SeverityError, "missingCommitter", "invalid format - expected 'committer' line")}, links
+	}
+	if p := checkIdent(committer); p != nil {
+		return []Problem{*p}, links
+	}
+	return nil, links
+}
+
+func checkTag(content []byte) ([]Problem, []link) {
+	if !bytes.Contains(content, []byte("\n\n")) && !bytes.HasSuffix(content, []byte("\n")) {
+		return []Problem{problem(SeverityError, "unterminatedHeader", "unterminated header")}, nil
+	}
+	h := newHeaderReader(content)
+	object, ok := h.next("object")
+	if !ok {
+		return []Problem{problem(SeverityError, "missingObject", "invalid format - expected 'object' line")}, nil
+	}
+	if !repr.IsValidDigest(object) {
+		return []Problem{problem(SeverityError, "badObjectSha1", "invalid 'object' line format - bad sha1")}, nil
+	}
+	objType, ok := h.next("type")
+	if !ok {
+		return []Problem{problem(SeverityError, "missingTypeEntry", "invalid format - expected 'type' line")}, nil
+	}
+	if !slices.Contains([]string{"blob", "tree", "commit", "tag"}, objType) {
+		return []Problem{problem(SeverityError, "badType", "invalid 'type' value")}, nil
+	}
+	links := []link{{object, objType}}
+	name, ok := h.next("tag")
+	if !ok {
+		return []Problem{problem(SeverityError, "missingTagEntry", "invalid format - expected 'tag' line")}, links
+	}
+	var problems []Problem
+	if !refs.IsValidName("refs/tags/" + name) {
+		problems = append(problems, problem(SeverityInfo, "badTagName", "invalid 'tag' name: %v", name))
+	}
+	if tagger, ok := h.next("tagger"); ok {
+		if p := checkIdent(tagger); p != nil {
+			problems = append(problems, *p)
+		}
+	} else {
+		problems = append(problems, problem(SeverityInfo, "missingTaggerEntry", "invalid format - expected 'tagger' line"))
+	}
+	return problems, links
+}
@@ -0,0 +1,234 @@
+package gitok_fsck
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/magnickolas/gitok/constants"
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/index"
+	"github.com/magnickolas/gitok/refs"
+	"github.com/magnickolas/gitok/repr"
+)
+
+type Options struct {
+	// report all unreachable objects, not only the dangling ones
+	Unreachable bool
+	NoDangling  bool
+	// don't consider reflog entries as roots
+	NoReflogs bool
+	// only check that referenced objects exist, without validating content
+	ConnectivityOnly bool
+	// treat warnings as errors
+	Strict bool
+}
+
+type checker struct {
+	opts Options
+	db   *fs.ObjectDB
+	out  io.Writer
+	errW io.Writer
+	// type of every object found in the database
+	types map[string]string
+	links map[string][]link
+	// objects referred to by some other object
+	referenced map[string]bool
+	failed     bool
+}
+
+// Verifies the objects of the repository and their connectivity. Missing
+// and dangling objects are reported to out, corruption to errW. Returns
+// whether no error was found
+func Fsck(out io.Writer, errW io.Writer, opts Options) (bool, error) {
+	c := &checker{
+		opts:       opts,
+		db:         fs.Default,
+		out:        out,
+		errW:       errW,
+		types:      make(map[string]string),
+		links:      make(map[string][]link),
+		referenced: make(map[string]bool),
+	}
+	digests, err := c.db.ListObjects()
+	if err != nil {
+		return false, err
+	}
+	for _, digest := range digests {
+		c.checkObject(digest)
+	}
+	for _, digest := range digests {
+		for _, l := range c.links[digest] {
+			if actualType, found := c.types[l.digest]; found && actualType != l.objType {
+				c.errorf("object %v is a %v, not a %v", l.digest, actualType, l.objType)
+			}
+		}
+	}
+	roots, err := c.roots()
+	if err != nil {
+		return false, err
+	}
+	reachable := c.walk(roots)
+	for _, digest := range digests {
+		if reachable[digest] || c.types[digest] == "" {
+			continue
+		}
+		switch {
+		case c.opts.Unreachable:
+			fmt.Fprintf(c.out, "unreachable %v %v\n", c.types[digest], digest)
+		case !c.opts.NoDangling && !c.referenced[digest]:
+			fmt.Fprintf(c.out, "dangling %v %v\n", c.types[digest], digest)
+		}
+	}
+	return !c.failed, nil
+}
+
+func (c *checker) errorf(format string, args ...any) {
+	c.failed = true
+	fmt.Fprintf(c.errW, "error: "+format+"\n", args...)
+}
+
+func (c *checker) checkObject(digest string) {
+	if c.opts.ConnectivityOnly {
+		info, err := c.db.ReadObjectInfo(digest)
+		if err != nil {
+			c.errorf("%v: object corrupt or missing", digest)
+			return
+		}
+		c.types[digest] = info.Type
+		if info.Type == "blob" {
+			// blobs refer to nothing, don't bother inflating them
+			return
+		}
+	}
+	objType, content, err := c.db.ReadRawObject(digest)
+	if err != nil {
+		c.errorf("%v: object corrupt or missing", digest)
+		return
+	}
+	actual, err := repr.HashStream(objType, int64(len(content)), bytes.NewReader(content))
+	if err != nil {
+		c.errorf("%v: %v", digest, err)
+		return
+	}
+	if actual != digest {
+		c.errorf("hash mismatch for %v (got %v)", digest, actual)
+		return
+	}
+	c.types[digest] = objType
+	problems, links := checkObject(objType, content, c.opts.Strict)
+	if !c.opts.ConnectivityOnly {
+		for _, p := range problems {
+			if p.Severity == SeverityError || (p.Severity == SeverityWarning && c.opts.Strict) {
+				c.failed = true
+				fmt.Fprintf(c.errW, "error in %v %v: %v: %v\n", objType, digest, p.ID, p.Message)
+			} else {
+				fmt.Fprintf(c.errW, "warning in %v %v: %v: %v\n", objType, digest, p.ID, p.Message)
+			}
+		}
+	}
+	c.links[digest] = links
+	for _, l := range links {
+		c.referenced[l.digest] = true
+	}
+}
+
+// Object pointed to by a ref, a reflog entry or the index
+type root struct {
+	digest string
+	source string
+}
+
+func (c *checker) roots() ([]root, error) {
+	var res []root
+	names := []string{constants.Head}
+	allRefs, err := refs.List(constants.Refs + "/")
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range allRefs {
+		if ref.IsSymbolic() {
+			continue
+		}
+		names = append(names, ref.Name)
+		res = append(res, root{ref.Digest, ref.Name})
+	}
+	head, err := refs.Resolve(constants.Head)
+	if err == nil {
+		res = append(res, root{head, constants.Head})
+	} else if !errors.Is(err, refs.ErrorRefNotFound) {
+		return nil, err
+	}
+	if !c.opts.NoReflogs {
+		for _, name := range names {
+			entries, err := refs.ReadReflog(name)
+			if err != nil {
+				return nil, err
+			}
+			for _, entry := range entries {
+				for _, digest := range []string{entry.OldDigest, entry.NewDigest} {
+					if digest != repr.ZeroDigest() {
+						res = append(res, root{digest, name + "@{reflog}"})
+					}
+				}
+			}
+		}
+	}
+	idx, err := index.Read()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range idx.Entries {
+		if entry.ObjectMode() != repr.ModeGitlink {
+			res = append(res, root{entry.Digest, "index"})
+		}
+	}
+	return res, nil
+}
+
+// Marks everything reachable from the roots, reporting missing objects
+func (c *checker) walk(roots []root) map[string]bool {
+	reachable := make(map[string]bool)
+	var stack []string
+	for _, r := range roots {
+		if reachable[r.digest] {
+			continue
+		}
+		if c.types[r.digest] == "" && !c.db.HasObject(r.digest) {
+			c.errorf("%v: invalid sha1 pointer %v", r.source, r.digest)
+			continue
+		}
+		reachable[r.digest] = true
+		stack = append(stack, r.digest)
+	}
+	var missing []link
+	for len(stack) > 0 {
+		digest := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, l := range c.links[digest] {
+			if reachable[l.digest] {
+				continue
+			}
+			// a missing object is reported once, for the first link to it
+			reachable[l.digest] = true
+			if _, found := c.types[l.digest]; !found && !c.db.HasObject(l.digest) {
+				c.failed = true
+				fmt.Fprintf(c.out, "broken link from %7s %v\n", c.types[digest], digest)
+				fmt.Fprintf(c.out, "              to %7s %v\n", l.objType, l.digest)
+				missing = append(missing, l)
+				continue
+			}
+			stack = append(stack, l.digest)
+		}
+	}
+	slices.SortFunc(missing, func(a, b link) int {
+		return strings.Compare(a.digest, b.digest)
+	})
+	for _, l := range missing {
+		fmt.Fprintf(c.out, "missing %v %v\n", l.objType, l.digest)
+	}
+	return reachable
+}
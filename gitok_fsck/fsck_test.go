@@ -0,0 +1,209 @@
+package gitok_fsck_test
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/gitok_fsck"
+	"github.com/magnickolas/gitok/gitok_init"
+	"github.com/magnickolas/gitok/refs"
+	"github.com/magnickolas/gitok/repr"
+)
+
+var signature = repr.Signature{Name: "a", Email: "a@b", When: time.Unix(1700000000, 0).UTC()}
+
+func writeObject(t *testing.T, o repr.Object) string {
+	if err := fs.WriteObject(o); err != nil {
+		t.Fatal(err)
+	}
+	return o.Digest()
+}
+
+func newBlob(t *testing.T, content string) *repr.Blob {
+	blob, err := repr.NewBlob(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blob
+}
+
+// Content of a tree of regular files with the names and digests, in the
+// order given
+func treeContent(entries ...string) []byte {
+	var res []byte
+	for i := 0; i < len(entries); i += 2 {
+		digest, _ := hex.DecodeString(entries[i+1])
+		res = append(res, "100644 "+entries[i]+"\x00"...)
+		res = append(res, digest...)
+	}
+	return res
+}
+
+// Makes a repository in a temporary directory with main at a commit of a
+// single file, and returns the digests of the blob, tree and commit
+func setupRepo(t *testing.T) (string, string, string) {
+	dir := t.TempDir()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	if err := gitok_init.InitRepo("main"); err != nil {
+		t.Fatal(err)
+	}
+	blob := writeObject(t, newBlob(t, "a\n"))
+	tree := writeObject(t, repr.NewTreeFromEntries([]repr.TreeEntry{{Name: "a", Mode: repr.ModeNormal, Digest: blob}}))
+	commit := writeObject(t, repr.NewCommitFromFields(tree, nil, signature, signature, "base\n"))
+	updateRef(t, "refs/heads/main", commit)
+	return blob, tree, commit
+}
+
+func updateRef(t *testing.T, name string, digest string) {
+	tx := refs.NewTransaction(signature)
+	tx.Update(name, digest, repr.ZeroDigest(), "test")
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFsck(t *testing.T) {
+	tests := []struct {
+		name string
+		opts gitok_fsck.Options
+		// changes the repository set up with the blob, tree and commit
+		// of main, returning the output wanted
+		change func(t *testing.T, blob, tree, commit string) string
+		wantOK bool
+		// part of the errors wanted, none if empty
+		wantErr string
+	}{
+		{
+			name: "intact",
+			change: func(t *testing.T, blob, tree, commit string) string {
+				return ""
+			},
+			wantOK: true,
+		},
+		{
+			name: "dangling blob",
+			change: func(t *testing.T, blob, tree, commit string) string {
+				return "dangling blob " + writeObject(t, newBlob(t, "d\n")) + "\n"
+			},
+			wantOK: true,
+		},
+		{
+			name: "dangling tree of an unreachable blob",
+			change: func(t *testing.T, blob, tree, commit string) string {
+				unreachable := writeObject(t, newBlob(t, "u\n"))
+				other := writeObject(t, repr.NewTreeFromEntries([]repr.TreeEntry{{Name: "u", Mode: repr.ModeNormal, Digest: unreachable}}))
+				return "dangling tree " + other + "\n"
+			},
+			wantOK: true,
+		},
+		{
+			name: "unreachable objects",
+			opts: gitok_fsck.Options{Unreachable: true},
+			change: func(t *testing.T, blob, tree, commit string) string {
+				unreachable := writeObject(t, newBlob(t, "u\n"))
+				other := writeObject(t, repr.NewTreeFromEntries([]repr.TreeEntry{{Name: "u", Mode: repr.ModeNormal, Digest: unreachable}}))
+				lines := []string{"unreachable blob " + unreachable, "unreachable tree " + other}
+				if other < unreachable {
+					lines[0], lines[1] = lines[1], lines[0]
+				}
+				return strings.Join(lines, "\n") + "\n"
+			},
+			wantOK: true,
+		},
+		{
+			name: "missing blob",
+			change: func(t *testing.T, blob, tree, commit string) string {
+				missing := newBlob(t, "m\n").Digest()
+				other := writeObject(t, repr.NewTreeFromEntries([]repr.TreeEntry{{Name: "m", Mode: repr.ModeNormal, Digest: missing}}))
+				head := writeObject(t, repr.NewCommitFromFields(other, []string{commit}, signature, signature, "next\n"))
+				tx := refs.NewTransaction(signature)
+				tx.Update("refs/heads/main", head, commit, "test")
+				if err := tx.Commit(); err != nil {
+					t.Fatal(err)
+				}
+				return "broken link from    tree " + other + "\n" +
+					"              to    blob " + missing + "\n" +
+					"missing blob " + missing + "\n"
+			},
+		},
+		{
+			name: "hash mismatch",
+			change: func(t *testing.T, blob, tree, commit string) string {
+				wrong := newBlob(t, "w\n").Digest()
+				content, err := os.ReadFile(filepath.Join(".git", "objects", blob[:2], blob[2:]))
+				if err != nil {
+					t.Fatal(err)
+				}
+				path := filepath.Join(".git", "objects", wrong[:2], wrong[2:])
+				if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, content, 0444); err != nil {
+					t.Fatal(err)
+				}
+				return ""
+			},
+			wantErr: "hash mismatch",
+		},
+		{
+			name: "unsorted tree",
+			change: func(t *testing.T, blob, tree, commit string) string {
+				content := treeContent("b", blob, "a", blob)
+				updateRef(t, "refs/tags/bad", writeObject(t, repr.NewLiteralObject("tree", content)))
+				return ""
+			},
+			wantErr: "treeNotSorted",
+		},
+		{
+			name: "commit without an email",
+			change: func(t *testing.T, blob, tree, commit string) string {
+				content := "tree " + tree + "\nauthor a 1700000000 +0000\ncommitter a <a@b> 1700000000 +0000\n\nm\n"
+				updateRef(t, "refs/tags/bad", writeObject(t, repr.NewLiteralObject("commit", []byte(content))))
+				return ""
+			},
+			wantErr: "missingEmail",
+		},
+		{
+			name: "tag of a blob typed as a commit",
+			change: func(t *testing.T, blob, tree, commit string) string {
+				content := "object " + blob + "\ntype commit\ntag v1\ntagger a <a@b> 1700000000 +0000\n\nm\n"
+				updateRef(t, "refs/tags/v1", writeObject(t, repr.NewLiteralObject("tag", []byte(content))))
+				return ""
+			},
+			wantErr: "is a blob, not a commit",
+		},
+	}
+	for _, test := range tests {
+		blob, tree, commit := setupRepo(t)
+		want := test.change(t, blob, tree, commit)
+		var out, errW strings.Builder
+		ok, err := gitok_fsck.Fsck(&out, &errW, test.opts)
+		if err != nil {
+			t.Errorf("failed to check %v: %v", test.name, err)
+			continue
+		}
+		if out.String() != want {
+			t.Errorf("incorrect result for %#v: wanted %#v, got %#v", test.name, want, out.String())
+		}
+		if ok != test.wantOK {
+			t.Errorf("incorrect result for %#v: wanted %#v, got %#v", test.name, test.wantOK, ok)
+		}
+		if test.wantErr == "" && errW.Len() != 0 || !strings.Contains(errW.String(), test.wantErr) {
+			t.Errorf("incorrect result for %#v: wanted an error about %#v, got %#v", test.name, test.wantErr, errW.String())
+		}
+	}
+}
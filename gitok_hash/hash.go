@@ -1,23 +1,112 @@
 package gitok_hash
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io"
+	"os"
+	"strings"
 
+	"github.com/magnickolas/gitok/convert"
 	"github.com/magnickolas/gitok/fs"
 	"github.com/magnickolas/gitok/repr"
 )
 
+var ErrorInvalidObjectType = errors.New("invalid object type")
+
+// Default core.bigFileThreshold
+const DefaultBigFileThreshold = 512 << 20
+
+type Options struct {
+	// object type, blob by default
+	Type string
+	// store the object in the database
+	Write bool
+	// skip the validation of the content, allowing any type name
+	Literally bool
+	// path whose attributes select the filters applied to a blob, no
+	// filters are applied if it is empty
+	Path string
+	// converter used for filtering, required if Path is set
+	Converter *convert.Converter
+	// files larger than this are streamed rather than read into memory
+	// when no filter applies to them, 0 disables streaming
+	BigFileThreshold int64
+}
+
 // Optionally saves the blob and return its key
 func ProcessBlob(r io.Reader, save bool) (string, error) {
-	blob, err := repr.NewBlob(r)
+	return HashObject(r, Options{Write: save})
+}
+
+// Hashes the file as an object of the given type. Large blobs that need no
+// filtering are streamed so that their size is not limited by memory
+func HashFile(path string, opts Options) (string, error) {
+	f, err := os.Open(path)
 	if err != nil {
 		return "", err
 	}
-	if save {
-		err = fs.WriteObject(blob)
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if (opts.Type == "" || opts.Type == "blob") && opts.BigFileThreshold > 0 &&
+		info.Mode().IsRegular() && info.Size() > opts.BigFileThreshold {
+		filtered := false
+		if opts.Path != "" {
+			filtered, err = opts.Converter.WouldConvertToGit(opts.Path)
+			if err != nil {
+				return "", err
+			}
+		}
+		if !filtered {
+			if opts.Write {
+				return fs.WriteStream("blob", info.Size(), f)
+			}
+			return repr.HashStream("blob", info.Size(), f)
+		}
+	}
+	return HashObject(f, opts)
+}
+
+// Optionally saves the object and returns its key
+func HashObject(r io.Reader, opts Options) (string, error) {
+	if opts.Type == "" {
+		opts.Type = "blob"
+	}
+	buf := new(bytes.Buffer)
+	if _, err := buf.ReadFrom(r); err != nil {
+		return "", err
+	}
+	content := buf.Bytes()
+	if opts.Type == "blob" && opts.Path != "" {
+		var err error
+		content, err = opts.Converter.ToGit(opts.Path, content)
 		if err != nil {
 			return "", err
 		}
 	}
-	return blob.Digest(), nil
+	var o repr.Object
+	if opts.Literally {
+		if strings.ContainsAny(opts.Type, " \x00") {
+			return "", fmt.Errorf("%w: %q", ErrorInvalidObjectType, opts.Type)
+		}
+		o = repr.NewLiteralObject(opts.Type, content)
+	} else {
+		var err error
+		o, err = repr.NewObject(opts.Type, bytes.NewReader(content))
+		if errors.Is(err, repr.ErrorUnknownObjectType) {
+			return "", fmt.Errorf("%w: %q", ErrorInvalidObjectType, opts.Type)
+		} else if err != nil {
+			return "", fmt.Errorf("corrupt %v: %w", opts.Type, err)
+		}
+	}
+	if opts.Write {
+		if err := fs.WriteObject(o); err != nil {
+			return "", err
+		}
+	}
+	return o.Digest(), nil
 }
@@ -0,0 +1,192 @@
+package gitok_hash_test
+
+import (
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/magnickolas/gitok/config"
+	"github.com/magnickolas/gitok/convert"
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/gitok_hash"
+	"github.com/magnickolas/gitok/gitok_init"
+	"github.com/magnickolas/gitok/repr"
+)
+
+// Makes a repository in a temporary directory with the files in its
+// worktree
+func setupRepo(t *testing.T, files map[string]string) {
+	dir := t.TempDir()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	if err := gitok_init.InitRepo("main"); err != nil {
+		t.Fatal(err)
+	}
+	for p, content := range files {
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHashObject(t *testing.T) {
+	setupRepo(t, map[string]string{".gitattributes": "*.txt text\n"})
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	converter, err := convert.NewConverter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobDigest, _ := hex.DecodeString("78981922613b2afb6025042ff6bd878ac1994e85")
+	tests := []struct {
+		name  string
+		input string
+		opts  gitok_hash.Options
+		want  string
+		// part of the error the input is refused with
+		wantErr string
+	}{
+		{
+			name:  "blob",
+			input: "a\n",
+			want:  "78981922613b2afb6025042ff6bd878ac1994e85",
+		},
+		{
+			name:  "tree",
+			input: "100644 a\x00" + string(blobDigest),
+			opts:  gitok_hash.Options{Type: "tree"},
+			want:  "aaff74984cccd156a469afa7d9ab10e4777beb24",
+		},
+		{
+			name: "commit",
+			input: "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+				"author a <a@b> 1700000000 +0000\ncommitter a <a@b> 1700000000 +0000\n\nm\n",
+			opts: gitok_hash.Options{Type: "commit"},
+			want: "9953c41a10c6d1cefbee6d7774bfadc5fddf07f6",
+		},
+		{
+			name: "tag",
+			input: "object 78981922613b2afb6025042ff6bd878ac1994e85\ntype blob\ntag v1\n" +
+				"tagger a <a@b> 1700000000 +0000\n\nm\n",
+			opts: gitok_hash.Options{Type: "tag"},
+			want: "975e3459514f854aceba089173d60349b9837973",
+		},
+		{
+			name:    "corrupt commit",
+			input:   "tree xyz\n",
+			opts:    gitok_hash.Options{Type: "commit"},
+			wantErr: "corrupt commit",
+		},
+		{
+			name:    "unknown type",
+			input:   "a\n",
+			opts:    gitok_hash.Options{Type: "bogus"},
+			wantErr: gitok_hash.ErrorInvalidObjectType.Error(),
+		},
+		{
+			name:  "unknown type literally",
+			input: "a\n",
+			opts:  gitok_hash.Options{Type: "bogus", Literally: true},
+			want:  "ecbffa18eeacd1c8c433325bae562049e6e95c57",
+		},
+		{
+			name:  "text path",
+			input: "a\r\n",
+			opts:  gitok_hash.Options{Path: "x.txt", Converter: converter},
+			want:  "78981922613b2afb6025042ff6bd878ac1994e85",
+		},
+		{
+			name:  "path without attributes",
+			input: "a\r\n",
+			opts:  gitok_hash.Options{Path: "x.bin", Converter: converter},
+			want:  "533790e525dfeb785a02edfceeb1c7d120972c0d",
+		},
+	}
+	for _, test := range tests {
+		res, err := gitok_hash.HashObject(strings.NewReader(test.input), test.opts)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("incorrect result for %#v: wanted an error about %#v, got %v", test.name, test.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("failed to hash %v: %v", test.name, err)
+			continue
+		}
+		if res != test.want {
+			t.Errorf("incorrect result for %#v: wanted %#v, got %#v", test.name, test.want, res)
+		}
+	}
+}
+
+func TestHashFile(t *testing.T) {
+	big := strings.Repeat("line\r\n", 1000)
+	setupRepo(t, map[string]string{
+		".gitattributes": "*.txt text\n",
+		"big":            big,
+		"big.txt":        big,
+	})
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	converter, err := convert.NewConverter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path      string
+		threshold int64
+		want      string
+	}{
+		{path: "big", threshold: 0, want: big},
+		// streamed from the file
+		{path: "big", threshold: 100, want: big},
+		// filtered, so read into memory
+		{path: "big.txt", threshold: 100, want: strings.ReplaceAll(big, "\r\n", "\n")},
+	}
+	for _, test := range tests {
+		opts := gitok_hash.Options{
+			Write:            true,
+			Path:             test.path,
+			Converter:        converter,
+			BigFileThreshold: test.threshold,
+		}
+		res, err := gitok_hash.HashFile(test.path, opts)
+		if err != nil {
+			t.Errorf("failed to hash %v: %v", test.path, err)
+			continue
+		}
+		blob, err := repr.NewBlob(strings.NewReader(test.want))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res != blob.Digest() {
+			t.Errorf("incorrect result for %#v: wanted %#v, got %#v", test.path, blob.Digest(), res)
+			continue
+		}
+		or, err := fs.OpenObject(res)
+		if err != nil {
+			t.Errorf("failed to open %v: %v", test.path, err)
+			continue
+		}
+		content, err := io.ReadAll(or)
+		or.Close()
+		if err != nil || or.Type != "blob" || string(content) != test.want {
+			t.Errorf("incorrect result for %#v: wanted a blob of %#v, got a %v of %#v (%v)", test.path, test.want, or.Type, string(content), err)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package gitok_log
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/magnickolas/gitok/constants"
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/refs"
+	"github.com/magnickolas/gitok/repr"
+)
+
+var (
+	ErrorInvalidDecorate       = errors.New("invalid --decorate option")
+	formatErrorInvalidDecorate = func(value string) error {
+		return fmt.Errorf("%w: %v", ErrorInvalidDecorate, value)
+	}
+)
+
+type Decorate int
+
+const (
+	DecorateNo Decorate = iota
+	// ref names without refs/heads/, refs/tags/ and refs/remotes/
+	DecorateShort
+	DecorateFull
+)
+
+var decorateNames = map[string]Decorate{
+	"no":    DecorateNo,
+	"short": DecorateShort,
+	"full":  DecorateFull,
+}
+
+func ParseDecorate(s string) (Decorate, error) {
+	if mode, ok := decorateNames[s]; ok {
+		return mode, nil
+	}
+	return DecorateNo, formatErrorInvalidDecorate(s)
+}
+
+// Refs shown next to the commits they point to
+var decoratedPrefixes = []string{"refs/heads/", "refs/remotes/", "refs/tags/", "refs/stash"}
+
+// Label of the ref next to the commits, tags starting with "tag: "
+func refLabel(name string, mode Decorate) string {
+	label := name
+	if mode == DecorateShort {
+		for _, prefix := range []string{"refs/heads/", "refs/tags/", "refs/remotes/"} {
+			if short, found := strings.CutPrefix(name, prefix); found {
+				label = short
+				break
+			}
+		}
+	}
+	if strings.HasPrefix(name, "refs/tags/") {
+		label = "tag: " + label
+	}
+	return label
+}
+
+// The commit a ref's object peels to, following annotated tags
+func peel(db *fs.ObjectDB, digest string) (string, error) {
+	for {
+		o, err := db.ReadObject(digest)
+		if err != nil {
+			return "", err
+		}
+		tag, ok := o.(*repr.Tag)
+		if !ok {
+			return digest, nil
+		}
+		digest = tag.Object()
+	}
+}
+
+// Labels of the refs pointing to each commit: HEAD first, then the other
+// refs in reverse order of their names. The branch HEAD points to shows as
+// "HEAD -> branch" when both are on the same commit
+func loadDecorations(db *fs.ObjectDB, mode Decorate) (map[string][]string, error) {
+	res := make(map[string][]string)
+	all, err := refs.List("refs/")
+	if err != nil {
+		return nil, err
+	}
+	digests := make(map[string]string)
+	for _, ref := range all {
+		if !hasAnyPrefix(ref.Name, decoratedPrefixes) {
+			continue
+		}
+		digest, err := refs.Resolve(ref.Name)
+		if errors.Is(err, refs.ErrorRefNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if digests[ref.Name], err = peel(db, digest); err != nil {
+			return nil, err
+		}
+	}
+	current := ""
+	head, err := refs.Resolve(constants.Head)
+	if err != nil && !errors.Is(err, refs.ErrorRefNotFound) {
+		return nil, err
+	}
+	if err == nil {
+		ref, err := refs.Read(constants.Head)
+		if err != nil {
+			return nil, err
+		}
+		label := constants.Head
+		if strings.HasPrefix(ref.Target, "refs/heads/") && digests[ref.Target] == head {
+			current = ref.Target
+			label += " -> " + refLabel(current, mode)
+		}
+		res[head] = append(res[head], label)
+	}
+	for i := len(all) - 1; i >= 0; i -= 1 {
+		name := all[i].Name
+		digest, ok := digests[name]
+		if !ok || name == current {
+			continue
+		}
+		res[digest] = append(res[digest], refLabel(name, mode))
+	}
+	return res, nil
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,464 @@
+package gitok_log
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/magnickolas/gitok/constants"
+	"github.com/magnickolas/gitok/diff"
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/graph"
+	"github.com/magnickolas/gitok/pathspec"
+	"github.com/magnickolas/gitok/pretty"
+	"github.com/magnickolas/gitok/revparse"
+	"github.com/magnickolas/gitok/revwalk"
+)
+
+var (
+	ErrorFollowNeedsOnePath = errors.New("--follow requires exactly one pathspec")
+	ErrorGraphWithReverse   = errors.New("--reverse and --graph are incompatible")
+)
+
+// Length of the digests of the raw output of the changes
+const rawAbbrev = 7
+
+type Options struct {
+	Walk   revwalk.Options
+	Pretty pretty.Options
+	// start from all refs and HEAD
+	All bool
+	// follow the history of the single path across renames
+	Follow bool
+	// what is shown of the changes of each commit, nothing if no format
+	Diff diff.Options
+	// draw the history to the left of the commits
+	Graph bool
+	// labels of the refs next to the commit digests
+	Decorate Decorate
+}
+
+type logger struct {
+	w         io.Writer
+	db        *fs.ObjectDB
+	walker    *revwalk.Walker
+	formatter *pretty.Formatter
+	opts      Options
+	// restricts the shown changes to the paths
+	ps    *pathspec.Pathspec
+	shown bool
+	// path followed when following renames
+	path  string
+	graph *graph.Graph
+	// whether the text of the last commit shown did not end with a line
+	// break
+	missingNewline bool
+	// labels of the refs pointing to each commit
+	decorations map[string][]string
+}
+
+// A commit to show with the changes to show with it
+type entry struct {
+	commit  *pretty.Commit
+	changes []diff.Change
+}
+
+// Shows the commits reachable from the revisions (HEAD if none), newest
+// first unless ordered otherwise
+func Log(w io.Writer, revs []string, paths []string, opts Options) error {
+	if opts.Follow && len(paths) != 1 {
+		return ErrorFollowNeedsOnePath
+	}
+	if opts.Graph && opts.Walk.Reverse {
+		return ErrorGraphWithReverse
+	}
+	opts.Diff.Abbrev = rawAbbrev
+	l := &logger{w: w, db: fs.Default, opts: opts}
+	if opts.Graph {
+		l.graph = graph.New()
+		// parents left out by the paths are replaced with their ancestors
+		// to draw the lines between commits
+		opts.Walk.RewriteParents = true
+		if opts.Walk.Sort == revwalk.SortNone {
+			opts.Walk.Sort = revwalk.SortTopo
+		}
+	}
+	// %d and %D show the refs even when not decorating
+	if mode := opts.Decorate; mode != DecorateNo || opts.Pretty.Format == pretty.User {
+		var err error
+		if l.decorations, err = loadDecorations(l.db, max(mode, DecorateShort)); err != nil {
+			return err
+		}
+	}
+	opts.Pretty.Decorate = opts.Decorate != DecorateNo
+	// mails of log are titled like those of format-patch
+	if opts.Pretty.Format == pretty.Email && opts.Pretty.SubjectPrefix == "" {
+		opts.Pretty.SubjectPrefix = "[PATCH] "
+	}
+	l.opts.Pretty = opts.Pretty
+	if opts.Follow {
+		// which commits are shown depends on the path at each of them, so
+		// commits are not pruned by the walk, nor counted before being shown
+		l.path = paths[0]
+		opts.Walk.MaxCount, opts.Walk.Reverse = -1, false
+	} else {
+		opts.Walk.Paths = paths
+		if len(paths) > 0 {
+			l.ps = pathspec.New(paths)
+		}
+	}
+	l.walker = revwalk.NewWalker(l.db, opts.Walk)
+	l.formatter = pretty.NewFormatter(l.db, opts.Pretty)
+	if opts.All {
+		if err := l.walker.PushAll(); err != nil {
+			return err
+		}
+	}
+	for _, rev := range revs {
+		if err := l.walker.AddRevision(rev); err != nil {
+			return err
+		}
+	}
+	if len(revs) == 0 && !opts.All {
+		head, err := revparse.Resolve(constants.Head)
+		if err != nil {
+			return err
+		}
+		if err := l.walker.Push(head); err != nil {
+			return err
+		}
+	}
+	if opts.Follow {
+		return l.follow()
+	}
+	for {
+		digest, err := l.walker.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		e, err := l.entry(digest)
+		if err != nil {
+			return err
+		}
+		if err := l.show(e); err != nil {
+			return err
+		}
+	}
+}
+
+func (l *logger) entry(digest string) (*entry, error) {
+	commit, err := l.db.ReadCommit(digest)
+	if err != nil {
+		return nil, err
+	}
+	parents, err := l.walker.Parents(digest)
+	if err != nil {
+		return nil, err
+	}
+	e := &entry{commit: &pretty.Commit{Digest: digest, Parents: parents, Refs: l.decorations[digest], Commit: commit}}
+	if l.graph != nil {
+		var interesting []string
+		for _, parent := range parents {
+			ok, err := l.walker.Interesting(parent)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				interesting = append(interesting, parent)
+			}
+		}
+		l.graph.Update(digest, interesting)
+	}
+	// merges are shown without changes
+	if l.opts.Diff.Format != 0 && len(commit.Parents()) <= 1 {
+		parentTree := l.parentTree(e)
+		e.changes, err = diff.Trees(l.db, parentTree, commit.TreeDigest(), l.ps)
+		if err != nil {
+			return nil, err
+		}
+		var olds []diff.File
+		if l.opts.Diff.FindCopiesHarder {
+			if olds, err = diff.TreeFiles(l.db, parentTree, l.ps); err != nil {
+				return nil, err
+			}
+		}
+		e.changes, _, err = diff.FindRenames(l.db, e.changes, olds, l.opts.Diff)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// Tree the changes of the commit are relative to, empty for root commits
+func (l *logger) parentTree(e *entry) string {
+	parents := e.commit.Commit.Parents()
+	if len(parents) == 0 {
+		return ""
+	}
+	parent, err := l.db.ReadCommit(parents[0])
+	if err != nil {
+		return ""
+	}
+	return parent.TreeDigest()
+}
+
+// Walks the whole history, showing the commits that change the followed
+// path, which becomes the source of the rename or copy that created it
+func (l *logger) follow() error {
+	var entries []*entry
+	for {
+		digest, err := l.walker.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+		commit, err := l.db.ReadCommit(digest)
+		if err != nil {
+			return err
+		}
+		if len(commit.Parents()) > 1 {
+			continue
+		}
+		e := &entry{commit: &pretty.Commit{Digest: digest, Parents: commit.Parents(), Refs: l.decorations[digest], Commit: commit}}
+		oldTree := l.parentTree(e)
+		// the changes tell whether the commit is shown, but are only shown
+		// themselves in a diff format
+		changes, err := diff.Trees(l.db, oldTree, commit.TreeDigest(), pathspec.New([]string{l.path}))
+		if err != nil {
+			return err
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		if len(changes) == 1 && changes[0].Status == diff.Added && changes[0].New.Path == l.path {
+			source, err := l.findSource(oldTree, commit.TreeDigest(), changes[0].New)
+			if err != nil {
+				return err
+			}
+			if source != nil {
+				changes[0] = *source
+				l.path = source.Old.Path
+			}
+		}
+		if l.opts.Diff.Format != 0 {
+			e.changes = changes
+		}
+		entries = append(entries, e)
+	}
+	if limit := l.opts.Walk.MaxCount; limit >= 0 && limit < len(entries) {
+		entries = entries[:limit]
+	}
+	for i := range entries {
+		e := entries[i]
+		if l.opts.Walk.Reverse {
+			e = entries[len(entries)-1-i]
+		}
+		if err := l.show(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The rename or copy the added file came from: the most similar file of
+// the old tree, preferring files deleted by the commit
+func (l *logger) findSource(oldTree string, newTree string, added diff.File) (*diff.Change, error) {
+	all, err := diff.Trees(l.db, "", oldTree, nil)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := diff.Trees(l.db, oldTree, newTree, nil)
+	if err != nil {
+		return nil, err
+	}
+	deleted := make(map[string]bool)
+	for _, change := range changes {
+		if change.Status == diff.Deleted {
+			deleted[change.Old.Path] = true
+		}
+	}
+	var best *diff.Change
+	bestDeleted := false
+	for _, candidate := range all {
+		score, err := diff.Similarity(l.db, candidate.New, added, diff.DefaultRenameScore)
+		if err != nil {
+			return nil, err
+		}
+		if score < diff.DefaultRenameScore {
+			continue
+		}
+		isDeleted := deleted[candidate.New.Path]
+		if best != nil && (bestDeleted && !isDeleted || bestDeleted == isDeleted && score <= best.Score) {
+			continue
+		}
+		best = &diff.Change{Status: diff.Copied, Old: candidate.New, New: added, Score: score}
+		if isDeleted {
+			best.Status = diff.Renamed
+		}
+		bestDeleted = isDeleted
+	}
+	if best != nil {
+		best.Score = best.Score * 100 / diff.MaxScore
+	}
+	return best, nil
+}
+
+// Writes the commit and its changes; commits of the oneline and
+// terminated user formats end with a line break, others are separated by
+// one, and a blank line (or "---" line, with both a diffstat and a patch)
+// sets off the changes. With the graph, its lines go before the commit
+// and to the left of every line after it
+func (l *logger) show(e *entry) error {
+	format := &l.opts.Pretty
+	if l.shown && !format.UsesTerminator() {
+		// a blank line would be a gap in the graph
+		if err := l.writePadding(); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(l.w, "\n"); err != nil {
+			return err
+		}
+	}
+	l.shown = true
+	if err := l.writeGraphToCommit(); err != nil {
+		return err
+	}
+	out := l.formatter.Format(e.commit)
+	l.missingNewline = !strings.HasSuffix(out, "\n")
+	if err := l.writeMessage(out); err != nil {
+		return err
+	}
+	if format.UsesTerminator() && !format.IsEmpty() {
+		if err := l.writePadding(); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(l.w, "\n"); err != nil {
+			return err
+		}
+	}
+	if len(e.changes) == 0 {
+		return nil
+	}
+	w := l.w
+	if l.graph != nil {
+		w = &graphWriter{w: l.w, g: l.graph, lineStart: true}
+	}
+	if format.Format != pretty.Oneline && !format.IsEmpty() {
+		sep := "\n"
+		if l.opts.Diff.Format&(diff.FormatStat|diff.FormatPatch) == diff.FormatStat|diff.FormatPatch {
+			sep = "---\n"
+		}
+		if _, err := io.WriteString(w, sep); err != nil {
+			return err
+		}
+	}
+	return diff.Write(w, l.db, e.changes, l.opts.Diff)
+}
+
+// Writes the graph's padding before the line break ending the last
+// commit's text, unless the text did not end with a line break
+func (l *logger) writePadding() error {
+	if l.graph == nil || l.missingNewline {
+		return nil
+	}
+	_, err := io.WriteString(l.w, l.graph.PaddingLine())
+	return err
+}
+
+// Writes the lines of the graph up to and including the start of the
+// commit line
+func (l *logger) writeGraphToCommit() error {
+	if l.graph == nil {
+		return nil
+	}
+	// no graph lines are left when the commit was not added to the graph
+	if l.graph.IsCommitFinished() {
+		_, err := io.WriteString(l.w, l.graph.PaddingLine())
+		return err
+	}
+	for !l.graph.IsCommitFinished() {
+		line, isCommit := l.graph.NextLine()
+		if isCommit {
+			_, err := io.WriteString(l.w, line)
+			return err
+		}
+		if _, err := io.WriteString(l.w, line+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes the text of the commit with the graph's lines before its lines
+// but the first, then the rest of the graph's lines for the commit
+func (l *logger) writeMessage(text string) error {
+	if l.graph == nil {
+		_, err := io.WriteString(l.w, text)
+		return err
+	}
+	var b strings.Builder
+	for rest := text; rest != ""; {
+		line, next, found := strings.Cut(rest, "\n")
+		b.WriteString(line)
+		if found {
+			b.WriteString("\n")
+		}
+		if next != "" {
+			line, _ := l.graph.NextLine()
+			b.WriteString(line)
+		}
+		rest = next
+	}
+	if !l.graph.IsCommitFinished() {
+		if l.missingNewline {
+			b.WriteString("\n")
+		}
+		var lines []string
+		for !l.graph.IsCommitFinished() {
+			line, _ := l.graph.NextLine()
+			lines = append(lines, line)
+		}
+		b.WriteString(strings.Join(lines, "\n"))
+		if !l.missingNewline {
+			b.WriteString("\n")
+		}
+	}
+	_, err := io.WriteString(l.w, b.String())
+	return err
+}
+
+// Writes the changes of a commit with the graph's padding before each line
+type graphWriter struct {
+	w io.Writer
+	g *graph.Graph
+	// whether the next byte written starts a line
+	lineStart bool
+}
+
+func (gw *graphWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		if gw.lineStart {
+			if _, err := io.WriteString(gw.w, gw.g.PaddingLine()); err != nil {
+				return n, err
+			}
+			gw.lineStart = false
+		}
+		chunk := p
+		if i := bytes.IndexByte(p, '\n'); i != -1 {
+			chunk, gw.lineStart = p[:i+1], true
+		}
+		m, err := gw.w.Write(chunk)
+		n += m
+		if err != nil {
+			return n, err
+		}
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
@@ -0,0 +1,160 @@
+package gitok_ls_files
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/magnickolas/gitok/config"
+	"github.com/magnickolas/gitok/convert"
+	"github.com/magnickolas/gitok/ignore"
+	"github.com/magnickolas/gitok/index"
+	"github.com/magnickolas/gitok/index/parser"
+	"github.com/magnickolas/gitok/pathspec"
+	"github.com/magnickolas/gitok/quote"
+	"github.com/magnickolas/gitok/worktree"
+)
+
+// Flag bits that are only stored on disk and not shown by --debug
+const onDiskFlagsMask = 0x0FFF | 0x4000
+
+type Options struct {
+	Cached   bool
+	Deleted  bool
+	Modified bool
+	Others   bool
+	Unmerged bool
+	// show mode, object name and stage
+	Stage bool
+	// show stat data after each entry
+	Debug           bool
+	ExcludeStandard bool
+	// terminate lines with NUL and do not quote paths
+	NulTerminated bool
+}
+
+type lister struct {
+	opts Options
+	w    io.Writer
+}
+
+func LsFiles(w io.Writer, paths []string, opts Options) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	idx, err := index.Read()
+	if err != nil {
+		return err
+	}
+	if opts.Unmerged {
+		opts.Stage = true
+	}
+	if !opts.Deleted && !opts.Modified && !opts.Others && !opts.Unmerged {
+		opts.Cached = true
+	}
+	ps := pathspec.New(paths)
+	l := &lister{opts: opts, w: w}
+	if opts.Others {
+		var matcher *ignore.Matcher
+		if opts.ExcludeStandard {
+			matcher, err = ignore.NewStandard(cfg)
+			if err != nil {
+				return err
+			}
+		}
+		untracked, err := worktree.Untracked(idx.Entries, matcher)
+		if err != nil {
+			return err
+		}
+		for _, path := range untracked {
+			if ps.Match(path) {
+				if err := l.writeLine(l.quote(path)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if opts.Cached || opts.Unmerged {
+		for i := range idx.Entries {
+			entry := &idx.Entries[i]
+			if !ps.Match(entry.Name) || (opts.Unmerged && entry.Stage() == 0) {
+				continue
+			}
+			if err := l.show(entry); err != nil {
+				return err
+			}
+		}
+	}
+	if opts.Deleted || opts.Modified {
+		trustFileMode, err := cfg.GetBool("core.fileMode", true)
+		if err != nil {
+			return err
+		}
+		conv, err := convert.NewConverter(cfg)
+		if err != nil {
+			return err
+		}
+		for i := range idx.Entries {
+			entry := &idx.Entries[i]
+			if !ps.Match(entry.Name) {
+				continue
+			}
+			status, err := worktree.CheckEntry(entry, trustFileMode, conv)
+			if err != nil {
+				return err
+			}
+			// unmerged entries never match the worktree
+			if entry.Stage() != 0 && status == worktree.Unchanged {
+				status = worktree.Modified
+			}
+			if opts.Deleted && status == worktree.Deleted {
+				if err := l.show(entry); err != nil {
+					return err
+				}
+			}
+			if opts.Modified && status != worktree.Unchanged {
+				if err := l.show(entry); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (l *lister) show(entry *parser.Entry) error {
+	line := l.quote(entry.Name)
+	if l.opts.Stage {
+		line = fmt.Sprintf("%06o %s %d\t%s", uint32(entry.Mode), entry.Digest, entry.Stage(), line)
+	}
+	if err := l.writeLine(line); err != nil {
+		return err
+	}
+	if l.opts.Debug {
+		_, err := fmt.Fprintf(l.w,
+			"  ctime: %d:%d\n  mtime: %d:%d\n  dev: %d\tino: %d\n  uid: %d\tgid: %d\n  size: %d\tflags: %x\n",
+			uint32(entry.CTime), uint32(entry.CTimeNS),
+			uint32(entry.MTime), uint32(entry.MTimeNS),
+			uint32(entry.Dev), uint32(entry.Ino),
+			uint32(entry.Uid), uint32(entry.Gid),
+			uint32(entry.Size), entry.Flags&^onDiskFlagsMask)
+		return err
+	}
+	return nil
+}
+
+func (l *lister) quote(path string) string {
+	if l.opts.NulTerminated {
+		return path
+	}
+	return quote.CQuote(path)
+}
+
+func (l *lister) writeLine(line string) error {
+	terminator := "\n"
+	if l.opts.NulTerminated {
+		terminator = "\x00"
+	}
+	_, err := io.WriteString(l.w, line+terminator)
+	return err
+}
@@ -0,0 +1,206 @@
+package gitok_ls_tree
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/quote"
+	"github.com/magnickolas/gitok/repr"
+	"github.com/magnickolas/gitok/revparse"
+)
+
+const (
+	DefaultFormat  = "%(objectmode) %(objecttype) %(objectname)%x09%(path)"
+	LongFormat     = "%(objectmode) %(objecttype) %(objectname) %(objectsize:padded)%x09%(path)"
+	NameOnlyFormat = "%(path)"
+	ObjectFormat   = "%(objectname)"
+)
+
+type Options struct {
+	// descend into subtrees
+	Recursive bool
+	// show tree entries even when descending into them
+	ShowTrees bool
+	// show only tree entries
+	TreesOnly bool
+	Format    string
+	// terminate lines with NUL and do not quote paths
+	NulTerminated bool
+}
+
+type lister struct {
+	opts  Options
+	paths []string
+	w     io.Writer
+}
+
+// Lists the contents of the tree-ish, restricted to the given paths
+func LsTree(w io.Writer, treeish string, paths []string, opts Options) error {
+	digest, err := revparse.Resolve(treeish)
+	if err != nil {
+		return err
+	}
+	digest, err = revparse.Peel(digest, "tree")
+	if err != nil {
+		return err
+	}
+	if opts.Format == "" {
+		opts.Format = DefaultFormat
+	}
+	if opts.TreesOnly && opts.Recursive {
+		opts.ShowTrees = true
+	}
+	l := &lister{opts: opts, paths: paths, w: w}
+	return l.walk(digest, "")
+}
+
+func (l *lister) walk(digest string, base string) error {
+	tree, err := fs.ReadTree(digest)
+	if err != nil {
+		return err
+	}
+	for _, entry := range tree.Entries() {
+		path := base + entry.Name
+		if !l.interesting(path) {
+			continue
+		}
+		if entry.Mode == repr.ModeTree {
+			if l.shouldRecurse(path) {
+				if l.opts.ShowTrees {
+					if err := l.show(entry, path); err != nil {
+						return err
+					}
+				}
+				if err := l.walk(entry.Digest, path+"/"); err != nil {
+					return err
+				}
+				continue
+			}
+		} else if l.opts.TreesOnly {
+			continue
+		}
+		if err := l.show(entry, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Whether the path is matched by a pathspec or leads to one
+func (l *lister) interesting(path string) bool {
+	if len(l.paths) == 0 {
+		return true
+	}
+	for _, spec := range l.paths {
+		if isPrefixDir(spec, path) || isPrefixDir(path, strings.TrimSuffix(spec, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Trees are descended into with -r or when a pathspec lies below them
+func (l *lister) shouldRecurse(path string) bool {
+	if l.opts.Recursive {
+		return true
+	}
+	for _, spec := range l.paths {
+		if len(spec) > len(path) && strings.HasPrefix(spec, path) && spec[len(path)] == '/' {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether dir equals path or is one of its parent directories
+func isPrefixDir(dir string, path string) bool {
+	dir = strings.TrimSuffix(dir, "/")
+	return path == dir || strings.HasPrefix(path, dir+"/") || dir == ""
+}
+
+func (l *lister) show(entry repr.TreeEntry, path string) error {
+	line, err := l.expand(entry, path)
+	if err != nil {
+		return err
+	}
+	terminator := "\n"
+	if l.opts.NulTerminated {
+		terminator = "\x00"
+	}
+	_, err = io.WriteString(l.w, line+terminator)
+	return err
+}
+
+func (l *lister) expand(entry repr.TreeEntry, path string) (string, error) {
+	var b strings.Builder
+	format := l.opts.Format
+	for len(format) > 0 {
+		i := strings.IndexByte(format, '%')
+		if i == -1 {
+			b.WriteString(format)
+			break
+		}
+		b.WriteString(format[:i])
+		format = format[i:]
+		if strings.HasPrefix(format, "%%") {
+			b.WriteByte('%')
+			format = format[2:]
+			continue
+		}
+		if strings.HasPrefix(format, "%x") && len(format) >= 4 {
+			if c, err := strconv.ParseUint(format[2:4], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				format = format[4:]
+				continue
+			}
+		}
+		if strings.HasPrefix(format, "%(") {
+			end := strings.IndexByte(format, ')')
+			if end == -1 {
+				return "", fmt.Errorf("bad ls-tree format: unterminated '%v'", format)
+			}
+			value, err := l.placeholder(format[2:end], entry, path)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(value)
+			format = format[end+1:]
+			continue
+		}
+		return "", fmt.Errorf("bad ls-tree format: '%v'", format)
+	}
+	return b.String(), nil
+}
+
+func (l *lister) placeholder(name string, entry repr.TreeEntry, path string) (string, error) {
+	switch name {
+	case "objectmode":
+		return fmt.Sprintf("%06s", entry.Mode), nil
+	case "objecttype":
+		return entry.Type(), nil
+	case "objectname":
+		return entry.Digest, nil
+	case "objectsize", "objectsize:padded":
+		size := "-"
+		if entry.Type() == "blob" {
+			o, err := fs.ReadObject(entry.Digest)
+			if err != nil {
+				return "", err
+			}
+			size = strconv.Itoa(len(repr.StripObjectHeader(o)))
+		}
+		if name == "objectsize:padded" {
+			size = fmt.Sprintf("%7s", size)
+		}
+		return size, nil
+	case "path":
+		if l.opts.NulTerminated {
+			return path, nil
+		}
+		return quote.CQuote(path), nil
+	}
+	return "", fmt.Errorf("bad ls-tree format: %%(%v)", name)
+}
@@ -0,0 +1,155 @@
+package gitok_ls_tree_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/gitok_init"
+	"github.com/magnickolas/gitok/gitok_ls_tree"
+	"github.com/magnickolas/gitok/index"
+	"github.com/magnickolas/gitok/index/parser"
+	"github.com/magnickolas/gitok/repr"
+)
+
+// Makes a repository in a temporary directory and writes the tree of the
+// files, by their slash-separated paths
+func setupTree(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	if err := gitok_init.InitRepo("main"); err != nil {
+		t.Fatal(err)
+	}
+	var entries []parser.Entry
+	for p, content := range files {
+		blob, err := repr.NewBlob(strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fs.WriteObject(blob); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, parser.Entry{Mode: 0100644, Digest: blob.Digest(), Name: p})
+	}
+	tree, err := index.WriteTree(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+func TestLsTree(t *testing.T) {
+	tree := setupTree(t, map[string]string{
+		"a":         "a\n",
+		"dir/b":     "bb\n",
+		"dir/sub/c": "ccc\n",
+		"t\tab":     "t\n",
+	})
+	const (
+		a    = "100644 blob 78981922613b2afb6025042ff6bd878ac1994e85"
+		b    = "100644 blob e0b3f1b09bd1819ed1f7ce2e75fc7400809f5350"
+		c    = "100644 blob b2a7546679fdf79ca0eb7bfbee1e1bb342487380"
+		tab  = "100644 blob 718f4d2ff533cf8ead8d3556cf43912bd245fbc4"
+		dir  = "040000 tree 408ca1c3b9aa6388bfabb461471c9cbaf4aed522"
+		sub  = "040000 tree 99e886058eb640f519d453b6704a5f2257ac44ca"
+		name = "%(path)"
+	)
+	tests := []struct {
+		name  string
+		paths []string
+		opts  gitok_ls_tree.Options
+		want  string
+	}{
+		{
+			name: "top level",
+			want: a + "\ta\n" + dir + "\tdir\n" + tab + "\t\"t\\tab\"\n",
+		},
+		{
+			name: "recursive",
+			opts: gitok_ls_tree.Options{Recursive: true},
+			want: a + "\ta\n" + b + "\tdir/b\n" + c + "\tdir/sub/c\n" + tab + "\t\"t\\tab\"\n",
+		},
+		{
+			name: "recursive with trees",
+			opts: gitok_ls_tree.Options{Recursive: true, ShowTrees: true},
+			want: a + "\ta\n" + dir + "\tdir\n" + b + "\tdir/b\n" + sub + "\tdir/sub\n" + c + "\tdir/sub/c\n" + tab + "\t\"t\\tab\"\n",
+		},
+		{
+			name: "trees only",
+			opts: gitok_ls_tree.Options{TreesOnly: true},
+			want: dir + "\tdir\n",
+		},
+		{
+			name: "recursive trees only",
+			opts: gitok_ls_tree.Options{Recursive: true, TreesOnly: true},
+			want: dir + "\tdir\n" + sub + "\tdir/sub\n",
+		},
+		{
+			name: "long",
+			opts: gitok_ls_tree.Options{Recursive: true, Format: gitok_ls_tree.LongFormat},
+			want: a + "       2\ta\n" + b + "       3\tdir/b\n" + c + "       4\tdir/sub/c\n" + tab + "       2\t\"t\\tab\"\n",
+		},
+		{
+			name: "NUL-terminated names",
+			opts: gitok_ls_tree.Options{Recursive: true, Format: name, NulTerminated: true},
+			want: "a\x00dir/b\x00dir/sub/c\x00t\tab\x00",
+		},
+		{
+			name: "format",
+			opts: gitok_ls_tree.Options{Recursive: true, Format: "%(objecttype)_%(objectsize)_%(path)"},
+			want: "blob_2_a\nblob_3_dir/b\nblob_4_dir/sub/c\nblob_2_\"t\\tab\"\n",
+		},
+		{
+			name:  "recursive in a directory",
+			paths: []string{"dir"},
+			opts:  gitok_ls_tree.Options{Recursive: true, Format: name},
+			want:  "dir/b\ndir/sub/c\n",
+		},
+		{
+			name:  "directory",
+			paths: []string{"dir"},
+			want:  dir + "\tdir\n",
+		},
+		{
+			name:  "contents of a directory",
+			paths: []string{"dir/"},
+			want:  b + "\tdir/b\n" + sub + "\tdir/sub\n",
+		},
+		{
+			name:  "file in a subdirectory",
+			paths: []string{"dir/sub/c"},
+			want:  c + "\tdir/sub/c\n",
+		},
+		{
+			name:  "file in a subdirectory with trees",
+			paths: []string{"dir/sub/c"},
+			opts:  gitok_ls_tree.Options{ShowTrees: true},
+			want:  dir + "\tdir\n" + sub + "\tdir/sub\n" + c + "\tdir/sub/c\n",
+		},
+		{
+			name:  "prefix of a name",
+			paths: []string{"di"},
+			want:  "",
+		},
+	}
+	for _, test := range tests {
+		var out strings.Builder
+		if err := gitok_ls_tree.LsTree(&out, tree, test.paths, test.opts); err != nil {
+			t.Errorf("failed to list %v: %v", test.name, err)
+			continue
+		}
+		if out.String() != test.want {
+			t.Errorf("incorrect result for %#v: wanted %#v, got %#v", test.name, test.want, out.String())
+		}
+	}
+}
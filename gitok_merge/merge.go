@@ -0,0 +1,634 @@
+package gitok_merge
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/magnickolas/gitok/attr"
+	"github.com/magnickolas/gitok/config"
+	"github.com/magnickolas/gitok/constants"
+	"github.com/magnickolas/gitok/convert"
+	"github.com/magnickolas/gitok/diff"
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/ident"
+	"github.com/magnickolas/gitok/index"
+	"github.com/magnickolas/gitok/index/parser"
+	"github.com/magnickolas/gitok/merge"
+	"github.com/magnickolas/gitok/pretty"
+	"github.com/magnickolas/gitok/refs"
+	"github.com/magnickolas/gitok/repr"
+	"github.com/magnickolas/gitok/revparse"
+	"github.com/magnickolas/gitok/revwalk"
+	"github.com/magnickolas/gitok/worktree"
+)
+
+var (
+	ErrorNotSomethingWeCanMerge       = errors.New("not something we can merge")
+	formatErrorNotSomethingWeCanMerge = func(rev string) error {
+		return fmt.Errorf("merge: %v - %w", rev, ErrorNotSomethingWeCanMerge)
+	}
+	ErrorMergeInProgress    = errors.New("You have not concluded your merge (MERGE_HEAD exists).\nPlease, commit your changes before you merge.")
+	ErrorNoMergeToAbort     = errors.New("There is no merge to abort (MERGE_HEAD missing).")
+	ErrorNoMergeInProgress  = errors.New("There is no merge in progress (MERGE_HEAD missing).")
+	ErrorUnresolvedConflict = errors.New("Exiting because of an unresolved conflict.")
+	ErrorEmptyMessage       = errors.New("Aborting commit due to empty commit message.")
+	ErrorLocalChanges       = errors.New("Your local changes to the following files would be overwritten by merge:")
+	formatErrorLocalChanges = func(paths []string) error {
+		return fmt.Errorf("%w\n\t%v\nPlease commit your changes or stash them before you merge.\nAborting",
+			ErrorLocalChanges, strings.Join(paths, "\n\t"))
+	}
+	ErrorUntrackedFiles       = errors.New("The following untracked working tree files would be overwritten by merge:")
+	formatErrorUntrackedFiles = func(paths []string) error {
+		return fmt.Errorf("%w\n\t%v\nPlease move or remove them before you merge.\nAborting",
+			ErrorUntrackedFiles, strings.Join(paths, "\n\t"))
+	}
+)
+
+// Head before the merge, to go back to
+const origHead = "ORIG_HEAD"
+
+// Name of the strategy in messages, the only one there is
+const strategy = "ort"
+
+// Branches merges into which do not say so in their messages
+var suppressedDestinations = []string{"main", "master"}
+
+type Options struct {
+	// make a merge commit even when HEAD can be fast-forwarded
+	NoFF bool
+	// bring the changes to the index and the files without committing them
+	// nor recording the merge
+	Squash bool
+	// of the merge commit, one naming the merged commit if empty
+	Message string
+}
+
+type merger struct {
+	w    io.Writer
+	errW io.Writer
+	db   *fs.ObjectDB
+	cfg  *config.Config
+	opts Options
+	idx  *parser.Index
+	// commit HEAD points to, empty on an unborn branch
+	head string
+}
+
+// Merges the commit into HEAD: fast-forwards HEAD to it if it descends
+// from HEAD, and otherwise merges their trees and commits the result.
+// Conflicts are left in the index and the files for the merge to be
+// concluded, returning false
+func Merge(w io.Writer, errW io.Writer, rev string, opts Options) (bool, error) {
+	m := &merger{w: w, errW: errW, db: fs.Default, opts: opts}
+	var err error
+	if m.cfg, err = config.Load(); err != nil {
+		return false, err
+	}
+	if m.idx, err = index.Read(); err != nil {
+		return false, err
+	}
+	if hasUnmerged(m.idx.Entries) {
+		fmt.Fprintln(errW, "error: Merging is not possible because you have unmerged files.")
+		printUnmergedHint(errW)
+		return false, ErrorUnresolvedConflict
+	}
+	if inProgress() {
+		return false, ErrorMergeInProgress
+	}
+	theirs, err := revparse.Resolve(rev)
+	if err == nil {
+		theirs, err = revparse.Peel(theirs, "commit")
+	}
+	if err != nil {
+		return false, formatErrorNotSomethingWeCanMerge(rev)
+	}
+	if m.head, err = refs.ResolveHead(); err != nil {
+		return false, err
+	}
+	if m.head == "" {
+		return true, m.fastForward(rev, theirs)
+	}
+	if upToDate, err := revwalk.IsAncestor(m.db, theirs, m.head); err != nil {
+		return false, err
+	} else if upToDate {
+		fmt.Fprintln(w, "Already up to date.")
+		return true, nil
+	}
+	ff, err := revwalk.IsAncestor(m.db, m.head, theirs)
+	if err != nil {
+		return false, err
+	}
+	if ff && !opts.NoFF {
+		return true, m.fastForward(rev, theirs)
+	}
+	return m.merge(rev, theirs)
+}
+
+// Ends the merge waiting to be committed, bringing the index and the
+// files back to HEAD
+func Abort() error {
+	if !inProgress() {
+		return ErrorNoMergeToAbort
+	}
+	idx, err := index.Read()
+	if err != nil {
+		return err
+	}
+	head, err := refs.ResolveHead()
+	if err != nil {
+		return err
+	}
+	tree, err := treeOf(head)
+	if err != nil {
+		return err
+	}
+	entries, err := worktree.ResetTree(fs.Default, idx.Entries, tree, ".")
+	if err != nil {
+		return err
+	}
+	if err := index.Write(&parser.Index{Entries: entries}); err != nil {
+		return err
+	}
+	return removeState()
+}
+
+// Commits the index as the merge waiting to be committed, with the
+// message it was left with
+func Continue(w io.Writer, errW io.Writer) error {
+	if !inProgress() {
+		return ErrorNoMergeInProgress
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	idx, err := index.Read()
+	if err != nil {
+		return err
+	}
+	if hasUnmerged(idx.Entries) {
+		fmt.Fprintln(errW, "error: Committing is not possible because you have unmerged files.")
+		printUnmergedHint(errW)
+		return ErrorUnresolvedConflict
+	}
+	tree, err := index.WriteTree(idx.Entries)
+	if err != nil {
+		return err
+	}
+	head, err := refs.ResolveHead()
+	if err != nil {
+		return err
+	}
+	var parents []string
+	if head != "" {
+		parents = append(parents, head)
+	}
+	mergeHeads, err := os.ReadFile(filepath.Join(constants.Git, constants.MergeHead))
+	if err != nil {
+		return err
+	}
+	parents = append(parents, strings.Fields(string(mergeHeads))...)
+	message, err := os.ReadFile(filepath.Join(constants.Git, constants.MergeMsg))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	cleaned := repr.CleanupMessage(stripComments(string(message)))
+	if cleaned == "" {
+		return ErrorEmptyMessage
+	}
+	commit, err := ident.NewCommit(cfg, tree, parents, cleaned)
+	if err != nil {
+		return err
+	}
+	if err := updateHead(commit, head, "commit (merge): "+commit.Subject()); err != nil {
+		return err
+	}
+	branch, err := currentBranch()
+	if err != nil {
+		return err
+	}
+	if branch == "" {
+		branch = "detached HEAD"
+	}
+	fmt.Fprintf(w, "[%v %v] %v\n", branch, abbrev(commit.Digest()), commit.Subject())
+	return removeState()
+}
+
+// Moves HEAD, the index and the files to the commit, which descends from
+// HEAD. A squash leaves HEAD where it is
+func (m *merger) fastForward(rev string, theirs string) error {
+	headTree, err := treeOf(m.head)
+	if err != nil {
+		return err
+	}
+	tree, err := treeOf(theirs)
+	if err != nil {
+		return err
+	}
+	if err := m.checkLocalChanges(headTree, tree, nil); err != nil {
+		return err
+	}
+	if m.head != "" {
+		fmt.Fprintf(m.w, "Updating %v..%v\nFast-forward\n", abbrev(m.head), abbrev(theirs))
+	}
+	if err := m.update(tree, nil); err != nil {
+		return err
+	}
+	if m.opts.Squash {
+		fmt.Fprintln(m.w, "Squash commit -- not updating HEAD")
+		if err := m.writeSquashMessage(theirs); err != nil {
+			return err
+		}
+		return m.printStat(headTree, tree)
+	}
+	if err := m.writeOrigHead(); err != nil {
+		return err
+	}
+	committer, err := ident.Committer(m.cfg)
+	if err != nil {
+		return err
+	}
+	message := fmt.Sprintf("merge %v: Fast-forward", rev)
+	if m.head == "" {
+		message = "initial pull"
+	}
+	tx := refs.NewTransaction(committer)
+	tx.Update(constants.Head, theirs, refs.OrZero(m.head), message)
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return m.printStat(headTree, tree)
+}
+
+// Merges the trees of HEAD and the commit, and commits the result unless
+// it has conflicts or is a squash
+func (m *merger) merge(rev string, theirs string) (bool, error) {
+	attrs, err := attr.NewMatcher(m.cfg)
+	if err != nil {
+		return false, err
+	}
+	mergeOpts := merge.TreeOptions{
+		Ours:    constants.Head,
+		Theirs:  rev,
+		Renames: diff.Options{Detect: diff.DetectRenames},
+		Attrs:   attrs,
+	}
+	if name, ok := m.cfg.Get("merge.conflictStyle"); ok {
+		if mergeOpts.Style, err = merge.ParseStyle(name); err != nil {
+			return false, err
+		}
+	}
+	res, err := merge.MergeCommits(m.db, m.head, theirs, mergeOpts)
+	if err != nil {
+		return false, err
+	}
+	headTree, err := treeOf(m.head)
+	if err != nil {
+		return false, err
+	}
+	if err := m.checkLocalChanges(headTree, res.Tree, res.Conflicts); err != nil {
+		return false, err
+	}
+	if err := m.update(res.Tree, res.Conflicts); err != nil {
+		return false, err
+	}
+	for _, msg := range res.Messages {
+		fmt.Fprintln(m.w, msg.Text)
+	}
+	if m.opts.Squash {
+		if res.Clean() {
+			fmt.Fprintln(m.w, "Automatic merge went well; stopped before committing as requested")
+		}
+		fmt.Fprintln(m.w, "Squash commit -- not updating HEAD")
+		if err := m.writeSquashMessage(theirs); err != nil {
+			return false, err
+		}
+		if !res.Clean() {
+			// the conflicts alone, for the message of the squash commit
+			if err := os.WriteFile(filepath.Join(constants.Git, constants.MergeMsg), []byte(conflictsComment(res.Conflicts)), 0644); err != nil {
+				return false, err
+			}
+			fmt.Fprintln(m.w, "Automatic merge failed; fix conflicts and then commit the result.")
+		}
+		return res.Clean(), nil
+	}
+	if err := m.writeOrigHead(); err != nil {
+		return false, err
+	}
+	message := m.opts.Message
+	if message == "" {
+		if message, err = mergeMessage(rev); err != nil {
+			return false, err
+		}
+	}
+	message = repr.CleanupMessage(message)
+	if !res.Clean() {
+		if err := m.writeMergeState(theirs, message, res.Conflicts); err != nil {
+			return false, err
+		}
+		fmt.Fprintln(m.w, "Automatic merge failed; fix conflicts and then commit the result.")
+		return false, nil
+	}
+	commit, err := ident.NewCommit(m.cfg, res.Tree, []string{m.head, theirs}, message)
+	if err != nil {
+		return false, err
+	}
+	made := fmt.Sprintf("Merge made by the '%v' strategy.", strategy)
+	if err := updateHead(commit, m.head, fmt.Sprintf("merge %v: %v", rev, made)); err != nil {
+		return false, err
+	}
+	fmt.Fprintln(m.w, made)
+	return true, m.printStat(headTree, res.Tree)
+}
+
+// Refuses to merge over changes in the index, or over changes in the
+// files and untracked files the merge would write
+func (m *merger) checkLocalChanges(headTree string, tree string, conflicts []merge.Stage) error {
+	staged, err := diff.TreeIndex(m.db, headTree, m.idx.Entries, nil)
+	if err != nil {
+		return err
+	}
+	if len(staged) > 0 {
+		var paths []string
+		for _, change := range staged {
+			paths = append(paths, change.Path())
+		}
+		return formatErrorLocalChanges(paths)
+	}
+	changes, err := diff.Trees(m.db, headTree, tree, nil)
+	if err != nil {
+		return err
+	}
+	var touched []string
+	for _, change := range changes {
+		touched = append(touched, change.Path())
+	}
+	for _, stage := range conflicts {
+		touched = append(touched, stage.Path)
+	}
+	slices.Sort(touched)
+	touched = slices.Compact(touched)
+	trustFileMode, err := m.cfg.GetBool("core.fileMode", true)
+	if err != nil {
+		return err
+	}
+	conv, err := convert.NewConverter(m.cfg)
+	if err != nil {
+		return err
+	}
+	tracked := make(map[string]int)
+	for i, entry := range m.idx.Entries {
+		tracked[entry.Name] = i
+	}
+	var modified, untracked []string
+	for _, p := range touched {
+		i, ok := tracked[p]
+		if !ok {
+			found, err := untrackedAt(p, tracked)
+			if err != nil {
+				return err
+			}
+			untracked = append(untracked, found...)
+			continue
+		}
+		status, err := worktree.CheckEntry(&m.idx.Entries[i], trustFileMode, conv)
+		if err != nil {
+			return err
+		}
+		if status != worktree.Unchanged {
+			modified = append(modified, p)
+		}
+	}
+	if len(modified) > 0 {
+		return formatErrorLocalChanges(modified)
+	}
+	if len(untracked) > 0 {
+		return formatErrorUntrackedFiles(untracked)
+	}
+	return nil
+}
+
+// Untracked files at the path: the file there, or those in the directory
+// there. A directory of tracked files has none
+func untrackedAt(p string, tracked map[string]int) ([]string, error) {
+	info, err := os.Lstat(filepath.FromSlash(p))
+	if err != nil {
+		return nil, nil
+	}
+	if !info.IsDir() {
+		return []string{p}, nil
+	}
+	var res []string
+	err = filepath.WalkDir(filepath.FromSlash(p), func(name string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		if _, ok := tracked[name]; !ok {
+			res = append(res, name)
+		}
+		return nil
+	})
+	return res, err
+}
+
+// Brings the index and the files to the tree, with the stages of the
+// conflicts in the index in place of the files the tree has for them
+func (m *merger) update(tree string, conflicts []merge.Stage) error {
+	entries, err := worktree.ResetTree(m.db, m.idx.Entries, tree, ".")
+	if err != nil {
+		return err
+	}
+	conflicted := make(map[string]bool)
+	for _, stage := range conflicts {
+		conflicted[stage.Path] = true
+	}
+	entries = slices.DeleteFunc(entries, func(entry parser.Entry) bool {
+		return conflicted[entry.Name]
+	})
+	for _, stage := range conflicts {
+		entry := index.NewEntry(stage.Path, nil, stage.Mode, stage.Digest)
+		entry.Flags = uint32(stage.Stage) << 12
+		entries = append(entries, entry)
+	}
+	return index.Write(&parser.Index{Entries: entries})
+}
+
+// Records the merge for it to be committed once the conflicts are resolved
+func (m *merger) writeMergeState(theirs string, message string, conflicts []merge.Stage) error {
+	mode := ""
+	if m.opts.NoFF {
+		mode = "no-ff"
+	}
+	files := []struct{ name, content string }{
+		{constants.MergeHead, theirs + "\n"},
+		{constants.MergeMsg, message + conflictsComment(conflicts)},
+		{constants.MergeMode, mode},
+	}
+	for _, f := range files {
+		if err := os.WriteFile(filepath.Join(constants.Git, f.name), []byte(f.content), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Lines of a commit message listing the conflicted paths as comments
+func conflictsComment(conflicts []merge.Stage) string {
+	var b strings.Builder
+	b.WriteString("\n# Conflicts:\n")
+	var last string
+	for _, stage := range conflicts {
+		if stage.Path != last {
+			b.WriteString("#\t" + stage.Path + "\n")
+			last = stage.Path
+		}
+	}
+	return b.String()
+}
+
+// Writes the message of a squash: the commits it brings in as log shows
+// them
+func (m *merger) writeSquashMessage(theirs string) error {
+	walker := revwalk.NewWalker(m.db, revwalk.Options{MaxCount: -1})
+	if err := walker.Push(theirs); err != nil {
+		return err
+	}
+	if m.head != "" {
+		if err := walker.Hide(m.head); err != nil {
+			return err
+		}
+	}
+	digests, err := walker.All()
+	if err != nil {
+		return err
+	}
+	formatter := pretty.NewFormatter(m.db, pretty.Options{Format: pretty.Medium})
+	var b strings.Builder
+	b.WriteString("Squashed commit of the following:\n")
+	for _, digest := range digests {
+		commit, err := m.db.ReadCommit(digest)
+		if err != nil {
+			return err
+		}
+		b.WriteString("\n")
+		b.WriteString(formatter.Format(&pretty.Commit{Digest: digest, Parents: commit.Parents(), Commit: commit}))
+	}
+	return os.WriteFile(filepath.Join(constants.Git, constants.SquashMsg), []byte(b.String()), 0644)
+}
+
+func (m *merger) writeOrigHead() error {
+	return os.WriteFile(filepath.Join(constants.Git, origHead), []byte(m.head+"\n"), 0644)
+}
+
+// Shows what the merge changed from HEAD as a diffstat and summary
+func (m *merger) printStat(headTree string, tree string) error {
+	opts := diff.Options{Detect: diff.DetectRenames, Format: diff.FormatStat | diff.FormatSummary}
+	changes, err := diff.Trees(m.db, headTree, tree, nil)
+	if err != nil {
+		return err
+	}
+	if changes, _, err = diff.FindRenames(m.db, changes, nil, opts); err != nil {
+		return err
+	}
+	return diff.Write(m.w, m.db, changes, opts)
+}
+
+// The default message of the merge of the revision, telling what kind of
+// ref it names and into which branch unless it is a main one
+func mergeMessage(rev string) (string, error) {
+	message := fmt.Sprintf("Merge commit '%v'", rev)
+	if name, err := revparse.ExpandRef(rev); err == nil {
+		kinds := []struct{ prefix, kind string }{
+			{"refs/heads/", "branch"},
+			{"refs/tags/", "tag"},
+			{"refs/remotes/", "remote-tracking branch"},
+		}
+		for _, k := range kinds {
+			if short, ok := strings.CutPrefix(name, k.prefix); ok {
+				message = fmt.Sprintf("Merge %v '%v'", k.kind, short)
+				break
+			}
+		}
+	}
+	branch, err := currentBranch()
+	if err != nil {
+		return "", err
+	}
+	if branch != "" && !slices.Contains(suppressedDestinations, branch) {
+		message += " into " + branch
+	}
+	return message + "\n", nil
+}
+
+func updateHead(commit *repr.Commit, head string, message string) error {
+	tx := refs.NewTransaction(commit.Committer())
+	tx.Update(constants.Head, commit.Digest(), refs.OrZero(head), message)
+	return tx.Commit()
+}
+
+func inProgress() bool {
+	_, err := os.Stat(filepath.Join(constants.Git, constants.MergeHead))
+	return err == nil
+}
+
+func removeState() error {
+	for _, name := range []string{constants.MergeHead, constants.MergeMsg, constants.MergeMode} {
+		if err := os.Remove(filepath.Join(constants.Git, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func hasUnmerged(entries []parser.Entry) bool {
+	return slices.ContainsFunc(entries, func(entry parser.Entry) bool {
+		return entry.Stage() != 0
+	})
+}
+
+func printUnmergedHint(errW io.Writer) {
+	fmt.Fprintln(errW, "hint: Fix them up in the work tree, and then use 'git add/rm <file>'\n"+
+		"hint: as appropriate to mark resolution and make a commit.")
+}
+
+// Drops the lines of comments from a message
+func stripComments(message string) string {
+	var lines []string
+	for _, line := range strings.Split(message, "\n") {
+		if !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Short name of the branch HEAD points to, empty on a detached HEAD
+func currentBranch() (string, error) {
+	target, err := refs.ResolveName(constants.Head)
+	if err != nil || target == constants.Head {
+		return "", err
+	}
+	return strings.TrimPrefix(target, "refs/heads/"), nil
+}
+
+// Tree of the commit, the empty tree for none
+func treeOf(commit string) (string, error) {
+	if commit != "" {
+		return revparse.Peel(commit, "tree")
+	}
+	empty := repr.NewTreeFromEntries(nil)
+	if err := fs.WriteObject(empty); err != nil {
+		return "", err
+	}
+	return empty.Digest(), nil
+}
+
+func abbrev(digest string) string {
+	return fs.Default.Abbrev(digest, 7)
+}
@@ -0,0 +1,154 @@
+package gitok_merge_test
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/magnickolas/gitok/constants"
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/gitok_init"
+	"github.com/magnickolas/gitok/gitok_merge"
+	"github.com/magnickolas/gitok/index"
+	"github.com/magnickolas/gitok/index/parser"
+	"github.com/magnickolas/gitok/refs"
+	"github.com/magnickolas/gitok/repr"
+	"github.com/magnickolas/gitok/worktree"
+)
+
+var signature = repr.Signature{Name: "a", Email: "a@b", When: time.Unix(1700000000, 0).UTC()}
+
+// Writes the commit of the files, by their slash-separated paths
+func writeCommit(t *testing.T, files map[string]string, parents ...string) string {
+	var entries []parser.Entry
+	for p, content := range files {
+		blob, err := repr.NewBlob(strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fs.WriteObject(blob); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, parser.Entry{Mode: 0100644, Digest: blob.Digest(), Name: p})
+	}
+	tree, err := index.WriteTree(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := repr.NewCommitFromFields(tree, parents, signature, signature, "commit\n")
+	if err := fs.WriteObject(commit); err != nil {
+		t.Fatal(err)
+	}
+	return commit.Digest()
+}
+
+func updateRef(t *testing.T, name string, digest string) {
+	tx := refs.NewTransaction(signature)
+	tx.Update(name, digest, repr.ZeroDigest(), "test")
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Makes a repository in a temporary directory with HEAD on main at the
+// commit of the files, checked out
+func setupRepo(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	for _, role := range []string{"AUTHOR", "COMMITTER"} {
+		t.Setenv("GIT_"+role+"_NAME", signature.Name)
+		t.Setenv("GIT_"+role+"_EMAIL", signature.Email)
+	}
+	if err := gitok_init.InitRepo("main"); err != nil {
+		t.Fatal(err)
+	}
+	base := writeCommit(t, files)
+	updateRef(t, "refs/heads/main", base)
+	commit, err := fs.ReadCommit(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := worktree.CheckoutTree(fs.Default, commit.TreeDigest(), ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Write(&parser.Index{Entries: entries}); err != nil {
+		t.Fatal(err)
+	}
+	return base
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name         string
+		base, theirs map[string]string
+		// files written after checking out the base
+		untracked map[string]string
+		// files of the worktree after the merge
+		want map[string]string
+		// part of the error the merge is refused with
+		wantErr string
+	}{
+		{
+			name:   "fast-forward over a directory",
+			base:   map[string]string{"dir/x": "x\n", "y": "y\n"},
+			theirs: map[string]string{"dir": "file\n", "y": "y\n"},
+			want:   map[string]string{"dir": "file\n", "y": "y\n"},
+		},
+		{
+			name:      "untracked file out of the way",
+			base:      map[string]string{"dir/x": "x\n"},
+			theirs:    map[string]string{"dir/x": "x\n", "new": "n\n"},
+			untracked: map[string]string{"dir/u": "u\n"},
+			want:      map[string]string{"dir/x": "x\n", "dir/u": "u\n", "new": "n\n"},
+		},
+		{
+			name:      "untracked file in a replaced directory",
+			base:      map[string]string{"dir/x": "x\n"},
+			theirs:    map[string]string{"dir": "file\n"},
+			untracked: map[string]string{"dir/u": "u\n"},
+			wantErr:   "dir/u",
+		},
+	}
+	for _, test := range tests {
+		base := setupRepo(t, test.base)
+		theirs := writeCommit(t, test.theirs, base)
+		updateRef(t, "refs/heads/a", theirs)
+		for p, content := range test.untracked {
+			if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ok, err := gitok_merge.Merge(io.Discard, io.Discard, "a", gitok_merge.Options{})
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("incorrect result for %#v: wanted an error about %#v, got %v", test.name, test.wantErr, err)
+			}
+			continue
+		}
+		if err != nil || !ok {
+			t.Errorf("failed to merge %v: %v, %v", test.name, ok, err)
+			continue
+		}
+		for p, want := range test.want {
+			content, err := os.ReadFile(p)
+			if err != nil || string(content) != want {
+				t.Errorf("incorrect result for %#v: wanted %#v, got %#v (%v)", test.name+": "+p, want, string(content), err)
+			}
+		}
+		if head, _ := refs.Resolve(constants.Head); head != theirs {
+			t.Errorf("incorrect result for %#v: wanted %#v, got %#v", test.name+": HEAD", theirs, head)
+		}
+	}
+}
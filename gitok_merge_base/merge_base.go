@@ -0,0 +1,121 @@
+package gitok_merge_base
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/magnickolas/gitok/constants"
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/revparse"
+	"github.com/magnickolas/gitok/revwalk"
+)
+
+var (
+	ErrorTooFewCommits  = errors.New("not enough commits given")
+	ErrorIsAncestorArgs = errors.New("--is-ancestor takes exactly two commits")
+	ErrorForkPointArgs  = errors.New("--fork-point takes a ref and at most one commit")
+)
+
+type Options struct {
+	// print all the merge bases rather than one
+	All bool
+}
+
+func resolveCommits(revs []string) ([]string, error) {
+	res := make([]string, len(revs))
+	for i, rev := range revs {
+		digest, err := revparse.Resolve(rev)
+		if err != nil {
+			return nil, err
+		}
+		if res[i], err = revparse.Peel(digest, "commit"); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func printBases(w io.Writer, bases []string, opts Options) (bool, error) {
+	for _, base := range bases {
+		if _, err := fmt.Fprintln(w, base); err != nil {
+			return false, err
+		}
+		if !opts.All {
+			break
+		}
+	}
+	return len(bases) > 0, nil
+}
+
+// Prints the best common ancestor of the first commit and any of the
+// others, or all of them; false if there are none
+func MergeBase(w io.Writer, revs []string, opts Options) (bool, error) {
+	if len(revs) < 2 {
+		return false, ErrorTooFewCommits
+	}
+	commits, err := resolveCommits(revs)
+	if err != nil {
+		return false, err
+	}
+	bases, err := revwalk.MergeBases(fs.Default, commits[0], commits[1:]...)
+	if err != nil {
+		return false, err
+	}
+	return printBases(w, bases, opts)
+}
+
+// Prints the best common ancestor of all the commits, as for an octopus
+// merge, or all of them; false if there are none
+func Octopus(w io.Writer, revs []string, opts Options) (bool, error) {
+	if len(revs) < 1 {
+		return false, ErrorTooFewCommits
+	}
+	commits, err := resolveCommits(revs)
+	if err != nil {
+		return false, err
+	}
+	bases, err := revwalk.OctopusMergeBases(fs.Default, commits)
+	if err != nil {
+		return false, err
+	}
+	return printBases(w, bases, opts)
+}
+
+// Whether the first commit is an ancestor of the second
+func IsAncestor(revs []string) (bool, error) {
+	if len(revs) != 2 {
+		return false, ErrorIsAncestorArgs
+	}
+	commits, err := resolveCommits(revs)
+	if err != nil {
+		return false, err
+	}
+	return revwalk.IsAncestor(fs.Default, commits[0], commits[1])
+}
+
+// Prints where the commit (HEAD if not given) forked from the history of
+// the ref; false if it cannot be found
+func ForkPoint(w io.Writer, args []string) (bool, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return false, ErrorForkPointArgs
+	}
+	ref, rev := args[0], constants.Head
+	if len(args) == 2 {
+		rev = args[1]
+	}
+	commits, err := resolveCommits([]string{rev})
+	if err != nil {
+		return false, err
+	}
+	name, err := revparse.ExpandRef(ref)
+	if err != nil {
+		return false, err
+	}
+	base, ok, err := revwalk.ForkPoint(fs.Default, name, commits[0])
+	if err != nil || !ok {
+		return false, err
+	}
+	_, err = fmt.Fprintln(w, base)
+	return err == nil, err
+}
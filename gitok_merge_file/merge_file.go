@@ -0,0 +1,94 @@
+package gitok_merge_file
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/magnickolas/gitok/attr"
+	"github.com/magnickolas/gitok/config"
+	"github.com/magnickolas/gitok/merge"
+	"github.com/magnickolas/gitok/strerror"
+)
+
+var (
+	ErrorTooManyLabels    = errors.New("too many labels on the command line")
+	ErrorCannotStat       = errors.New("Could not stat")
+	formatErrorCannotStat = func(path string, err error) error {
+		return fmt.Errorf("%w %v: %v", ErrorCannotStat, path, err)
+	}
+)
+
+type Options struct {
+	// of the current file, the base and the other file in this order, the
+	// names of the files for those not given
+	Labels []string
+	// write the result to the writer rather than to the current file
+	Stdout bool
+	// merge.conflictStyle or the merge style if nil
+	Style *merge.Style
+	Favor merge.Favor
+	// the conflict-marker-size attribute of the current file or the
+	// default if 0
+	MarkerSize int
+}
+
+// Merges the changes from the base to the other file into the current
+// one and returns the number of conflicts left in the result
+func MergeFile(w io.Writer, current string, base string, other string, opts Options) (int, error) {
+	if len(opts.Labels) > 3 {
+		return 0, ErrorTooManyLabels
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return 0, err
+	}
+	paths := []string{current, base, other}
+	labels := append([]string{}, opts.Labels...)
+	var contents [3][]byte
+	for i, path := range paths {
+		if i >= len(labels) {
+			labels = append(labels, path)
+		}
+		if contents[i], err = os.ReadFile(path); err != nil {
+			return 0, formatErrorCannotStat(path, strerror.Describe(err))
+		}
+	}
+	mergeOpts := merge.Options{
+		Ours:       labels[0],
+		Base:       labels[1],
+		Theirs:     labels[2],
+		MarkerSize: opts.MarkerSize,
+		Favor:      opts.Favor,
+		Level:      merge.LevelZealousAlnum,
+	}
+	if opts.Style != nil {
+		mergeOpts.Style = *opts.Style
+	} else if name, ok := cfg.Get("merge.conflictStyle"); ok {
+		if mergeOpts.Style, err = merge.ParseStyle(name); err != nil {
+			return 0, err
+		}
+	}
+	if mergeOpts.MarkerSize == 0 {
+		attrs, err := attr.NewMatcher(cfg)
+		if err != nil {
+			return 0, err
+		}
+		if mergeOpts.MarkerSize, err = merge.MarkerSize(attrs, current); err != nil {
+			return 0, err
+		}
+	}
+	res, conflicts, err := merge.Merge(contents[1], contents[0], contents[2], mergeOpts)
+	if errors.Is(err, merge.ErrorBinary) {
+		return 0, fmt.Errorf("%w: %v", err, current)
+	} else if err != nil {
+		return 0, err
+	}
+	if opts.Stdout {
+		_, err = w.Write(res)
+	} else {
+		err = os.WriteFile(current, res, 0644)
+	}
+	return conflicts, err
+}
@@ -0,0 +1,132 @@
+package gitok_merge_tree
+
+import (
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/magnickolas/gitok/attr"
+	"github.com/magnickolas/gitok/config"
+	"github.com/magnickolas/gitok/diff"
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/merge"
+	"github.com/magnickolas/gitok/quote"
+	"github.com/magnickolas/gitok/revparse"
+)
+
+type Options struct {
+	// list the conflicted paths rather than their stages
+	NameOnly bool
+	// print the messages, or not; only when there are conflicts if nil
+	Messages *bool
+	// terminate lines with NUL, do not quote paths and give the messages
+	// with their paths and kinds
+	NulTerminated           bool
+	AllowUnrelatedHistories bool
+}
+
+// Merges the two commits without touching the index or the work tree and
+// prints the result tree, the conflicted paths and the messages. False if
+// there are conflicts
+func MergeTree(w io.Writer, ours string, theirs string, opts Options) (bool, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return false, err
+	}
+	commits := make([]string, 2)
+	for i, rev := range []string{ours, theirs} {
+		digest, err := revparse.Resolve(rev)
+		if err != nil {
+			return false, err
+		}
+		if commits[i], err = revparse.Peel(digest, "commit"); err != nil {
+			return false, err
+		}
+	}
+	attrs, err := attr.NewMatcher(cfg)
+	if err != nil {
+		return false, err
+	}
+	mergeOpts := merge.TreeOptions{
+		Ours:                    ours,
+		Theirs:                  theirs,
+		Renames:                 diff.Options{Detect: diff.DetectRenames},
+		Attrs:                   attrs,
+		AllowUnrelatedHistories: opts.AllowUnrelatedHistories,
+	}
+	if name, ok := cfg.Get("merge.conflictStyle"); ok {
+		if mergeOpts.Style, err = merge.ParseStyle(name); err != nil {
+			return false, err
+		}
+	}
+	res, err := merge.MergeCommits(fs.Default, commits[0], commits[1], mergeOpts)
+	if err != nil {
+		return false, err
+	}
+	p := &printer{w: w, opts: opts}
+	p.line(res.Tree)
+	if !res.Clean() {
+		var printed []string
+		for _, stage := range res.Conflicts {
+			if !opts.NameOnly {
+				p.line(fmt.Sprintf("%06s %s %d\t%s", stage.Mode, stage.Digest, stage.Stage, p.quote(stage.Path)))
+			} else if !slices.Contains(printed, stage.Path) {
+				printed = append(printed, stage.Path)
+				p.line(p.quote(stage.Path))
+			}
+		}
+	}
+	showMessages := !res.Clean()
+	if opts.Messages != nil {
+		showMessages = *opts.Messages
+	}
+	if showMessages {
+		p.line("")
+		for _, msg := range res.Messages {
+			p.message(msg)
+		}
+	}
+	return res.Clean(), p.err
+}
+
+// Writes the output, keeping the first error
+type printer struct {
+	w    io.Writer
+	opts Options
+	err  error
+}
+
+func (p *printer) line(line string) {
+	terminator := "\n"
+	if p.opts.NulTerminated {
+		terminator = "\x00"
+	}
+	p.write(line + terminator)
+}
+
+// Writes the text of the message, after the number of its paths, the
+// paths and its kind if terminated with NUL
+func (p *printer) message(msg merge.Message) {
+	if !p.opts.NulTerminated {
+		p.write(msg.Text + "\n")
+		return
+	}
+	p.write(fmt.Sprintf("%d\x00", len(msg.Paths)))
+	for _, path := range msg.Paths {
+		p.write(path + "\x00")
+	}
+	p.write(msg.Kind + "\x00" + msg.Text + "\n\x00")
+}
+
+func (p *printer) write(s string) {
+	if p.err == nil {
+		_, p.err = io.WriteString(p.w, s)
+	}
+}
+
+func (p *printer) quote(path string) string {
+	if p.opts.NulTerminated {
+		return path
+	}
+	return quote.CQuote(path)
+}
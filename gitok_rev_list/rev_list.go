@@ -0,0 +1,63 @@
+package gitok_rev_list
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/revwalk"
+)
+
+type Options struct {
+	Walk revwalk.Options
+	// start from all refs and HEAD
+	All bool
+	// print the number of commits instead of listing them
+	Count bool
+	// print the parents after each commit
+	Parents bool
+}
+
+// Lists the commits reachable from the revisions, newest first unless
+// ordered otherwise
+func RevList(w io.Writer, revs []string, opts Options) error {
+	walker := revwalk.NewWalker(fs.Default, opts.Walk)
+	if opts.All {
+		if err := walker.PushAll(); err != nil {
+			return err
+		}
+	}
+	for _, rev := range revs {
+		if err := walker.AddRevision(rev); err != nil {
+			return err
+		}
+	}
+	count := 0
+	for {
+		digest, err := walker.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+		count += 1
+		if opts.Count {
+			continue
+		}
+		line := digest
+		if opts.Parents {
+			parents, err := walker.Parents(digest)
+			if err != nil {
+				return err
+			}
+			line = strings.Join(append([]string{digest}, parents...), " ")
+		}
+		fmt.Fprintln(w, line)
+	}
+	if opts.Count {
+		fmt.Fprintln(w, count)
+	}
+	return nil
+}
@@ -0,0 +1,329 @@
+package gitok_submodule
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/magnickolas/gitok/config"
+	"github.com/magnickolas/gitok/constants"
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/index"
+	"github.com/magnickolas/gitok/index/parser"
+	"github.com/magnickolas/gitok/pathspec"
+	"github.com/magnickolas/gitok/repr"
+	"github.com/magnickolas/gitok/submodule"
+	"github.com/magnickolas/gitok/worktree"
+)
+
+var (
+	ErrorNoMapping       = errors.New("no submodule mapping found in .gitmodules")
+	formatErrorNoMapping = func(path string) error {
+		return fmt.Errorf("%w for path '%v'", ErrorNoMapping, path)
+	}
+	ErrorNoURL       = errors.New("no url found for submodule")
+	formatErrorNoURL = func(path string) error {
+		return fmt.Errorf("%w path '%v' in .gitmodules", ErrorNoURL, path)
+	}
+	ErrorUnsupportedUpdate       = errors.New("unsupported submodule update mode")
+	formatErrorUnsupportedUpdate = func(mode string, path string) error {
+		return fmt.Errorf("%w '%v' for submodule path '%v'", ErrorUnsupportedUpdate, mode, path)
+	}
+)
+
+// Submodule together with the commit recorded in the index
+type gitlink struct {
+	submodule.Submodule
+	digest string
+	// non-zero for a conflicted gitlink
+	stage int
+}
+
+// Gitlinks of the index matching the paths, with their .gitmodules entry
+func gitlinks(paths []string) ([]gitlink, error) {
+	submodules, err := submodule.Read()
+	if err != nil {
+		return nil, err
+	}
+	byPath := make(map[string]submodule.Submodule)
+	for _, sm := range submodules {
+		byPath[sm.Path] = sm
+	}
+	idx, err := index.Read()
+	if err != nil {
+		return nil, err
+	}
+	ps := pathspec.New(paths)
+	var res []gitlink
+	for _, entry := range idx.Entries {
+		if entry.ObjectMode() != repr.ModeGitlink || !ps.Match(entry.Name) {
+			continue
+		}
+		if len(res) > 0 && res[len(res)-1].Path == entry.Name {
+			// other stages of a conflicted gitlink
+			continue
+		}
+		sm, ok := byPath[entry.Name]
+		if !ok {
+			return nil, formatErrorNoMapping(entry.Name)
+		}
+		res = append(res, gitlink{Submodule: sm, digest: entry.Digest, stage: entry.Stage()})
+	}
+	return res, nil
+}
+
+// Prints the state of each submodule: "-" when not checked out, "+" when its
+// HEAD differs from the recorded commit and "U" on conflicts
+func Status(w io.Writer, paths []string) error {
+	links, err := gitlinks(paths)
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		if link.stage != 0 {
+			fmt.Fprintf(w, "U%v %v\n", repr.ZeroDigest(), link.Path)
+			continue
+		}
+		gitDir, err := submodule.GitDir(link.Path)
+		if errors.Is(err, submodule.ErrorNotPopulated) {
+			fmt.Fprintf(w, "-%v %v\n", link.digest, link.Path)
+			continue
+		} else if err != nil {
+			return err
+		}
+		head, err := submodule.Head(gitDir)
+		if err != nil {
+			return err
+		}
+		prefix := ' '
+		if head != link.digest {
+			prefix = '+'
+		}
+		name, err := submodule.Describe(gitDir, head)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%c%v %v (%v)\n", prefix, head, link.Path, name)
+	}
+	return nil
+}
+
+// Registers the submodules' URLs in the repository config
+func Init(w io.Writer, paths []string) error {
+	links, err := gitlinks(paths)
+	if err != nil {
+		return err
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		if err := initSubmodule(w, cfg, link.Submodule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func initSubmodule(w io.Writer, cfg *config.Config, sm submodule.Submodule) error {
+	key := "submodule." + sm.Name
+	if _, ok := cfg.Get(key + ".url"); ok {
+		return nil
+	}
+	if sm.URL == "" {
+		return formatErrorNoURL(sm.Path)
+	}
+	url, err := resolveURL(cfg, sm.URL)
+	if err != nil {
+		return err
+	}
+	if err := config.Set(key+".active", "true"); err != nil {
+		return err
+	}
+	if err := config.Set(key+".url", url); err != nil {
+		return err
+	}
+	if sm.Update != "" && !strings.HasPrefix(sm.Update, "!") {
+		if err := config.Set(key+".update", sm.Update); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(w, "Submodule '%v' (%v) registered for path '%v'\n", sm.Name, url, sm.Path)
+	return nil
+}
+
+// Resolves "./" and "../" URLs against the superproject's origin, or its
+// directory when it has none
+func resolveURL(cfg *config.Config, url string) (string, error) {
+	if !strings.HasPrefix(url, "./") && !strings.HasPrefix(url, "../") {
+		return url, nil
+	}
+	base, ok := cfg.Get("remote.origin.url")
+	if !ok {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.ToSlash(cwd)
+	}
+	base = strings.TrimSuffix(strings.TrimSuffix(base, "/"), "/.git")
+	for {
+		if rest, found := strings.CutPrefix(url, "./"); found {
+			url = rest
+		} else if rest, found := strings.CutPrefix(url, "../"); found {
+			i := strings.LastIndex(base, "/")
+			if i == -1 {
+				return "", fmt.Errorf("cannot strip one component off url '%v'", base)
+			}
+			base, url = base[:i], rest
+		} else {
+			break
+		}
+	}
+	return base + "/" + url, nil
+}
+
+type UpdateOptions struct {
+	// initialize the submodules that are not yet
+	Init bool
+}
+
+// Checks out the recorded commit in each initialized submodule, cloning it
+// from its local source repository first if needed
+func Update(w io.Writer, errW io.Writer, paths []string, opts UpdateOptions) error {
+	links, err := gitlinks(paths)
+	if err != nil {
+		return err
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		if link.stage != 0 {
+			return fmt.Errorf("skipping unmerged submodule %v", link.Path)
+		}
+		key := "submodule." + link.Name
+		if _, ok := cfg.Get(key + ".url"); !ok {
+			if !opts.Init {
+				continue
+			}
+			if err := initSubmodule(w, cfg, link.Submodule); err != nil {
+				return err
+			}
+			if cfg, err = config.Load(); err != nil {
+				return err
+			}
+		}
+		mode, ok := cfg.Get(key + ".update")
+		if !ok {
+			mode = "checkout"
+		}
+		switch mode {
+		case "none":
+			continue
+		case "checkout":
+		default:
+			return formatErrorUnsupportedUpdate(mode, link.Path)
+		}
+		url, _ := cfg.Get(key + ".url")
+		if err := update(w, errW, link, url); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func update(w io.Writer, errW io.Writer, link gitlink, url string) error {
+	gitDir := filepath.Join(constants.Git, "modules", filepath.FromSlash(link.Name))
+	_, err := submodule.GitDir(link.Path)
+	populated := err == nil
+	if err != nil && !errors.Is(err, submodule.ErrorNotPopulated) {
+		return err
+	}
+	if !populated {
+		if _, err := os.Stat(gitDir); errors.Is(err, os.ErrNotExist) {
+			abs, err := filepath.Abs(link.Path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(errW, "Cloning into '%v'...\n", abs)
+			if err := submodule.Clone(url, gitDir, link.Path); err != nil {
+				return fmt.Errorf("clone of '%v' into submodule path '%v' failed: %w", url, abs, err)
+			}
+			fmt.Fprintln(errW, "done.")
+		} else if err := submodule.Link(gitDir, link.Path); err != nil {
+			return err
+		}
+	} else {
+		gitDir, _ = submodule.GitDir(link.Path)
+		if head, err := submodule.Head(gitDir); err == nil && head == link.digest {
+			return nil
+		}
+	}
+	db := fs.OpenObjectDB(filepath.Join(gitDir, constants.Objects))
+	if !db.HasObject(link.digest) {
+		if _, err := submodule.Fetch(url, gitDir); err != nil {
+			return err
+		}
+		db = fs.OpenObjectDB(filepath.Join(gitDir, constants.Objects))
+		if !db.HasObject(link.digest) {
+			return fmt.Errorf("fetched in submodule path '%v', but it did not contain %v. "+
+				"Direct fetching of that commit failed.", link.Path, link.digest)
+		}
+	}
+	if err := checkout(db, gitDir, link); err != nil {
+		return fmt.Errorf("unable to checkout '%v' in submodule path '%v': %w", link.digest, link.Path, err)
+	}
+	fmt.Fprintf(w, "Submodule path '%v': checked out '%v'\n", link.Path, link.digest)
+	return nil
+}
+
+// Replaces the files of the submodule's index with the recorded commit's
+// tree and detaches its HEAD there, refusing to overwrite local changes
+func checkout(db *fs.ObjectDB, gitDir string, link gitlink) error {
+	commit, err := db.ReadCommit(link.digest)
+	if err != nil {
+		return err
+	}
+	indexPath := filepath.Join(gitDir, constants.Index)
+	old := &parser.Index{}
+	if f, err := os.Open(indexPath); err == nil {
+		p, err := parser.NewParser(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		if old, err = p.Parse(); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, entry := range old.Entries {
+		// entries are relative to the submodule, checks to the superproject
+		entry.Name = link.Path + "/" + entry.Name
+		status, err := worktree.CheckEntry(&entry, true, nil)
+		if err != nil {
+			return err
+		}
+		if status == worktree.Modified {
+			return fmt.Errorf("local changes to '%v' would be overwritten", entry.Name)
+		}
+	}
+	if err := worktree.RemoveEntries(link.Path, old.Entries); err != nil {
+		return err
+	}
+	entries, err := worktree.CheckoutTree(db, commit.TreeDigest(), link.Path)
+	if err != nil {
+		return err
+	}
+	if err := index.WriteFile(indexPath, &parser.Index{Entries: entries}); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(gitDir, constants.Head), []byte(link.digest+"\n"), 0644)
+}
@@ -0,0 +1,483 @@
+package graph
+
+import (
+	"strings"
+)
+
+type state int
+
+const (
+	statePadding state = iota
+	stateSkip
+	statePreCommit
+	stateCommit
+	statePostMerge
+	stateCollapsing
+)
+
+// Characters of the edges from a merge to its parents, by merge layout
+var mergeChars = []byte{'/', '|', '\\'}
+
+// Draws the history as columns of branch lines to the left of the
+// commits, the way git's --graph does. Commits are given newest first, each
+// with its parents that are shown too; every commit takes at least one line
+// and lines between commits expand, merge and collapse the branch lines
+type Graph struct {
+	commit string
+	// shown parents of the commit
+	parents []string
+	// width of the lines, in characters
+	width int
+	// pre-commit line being shown for octopus merges
+	expansionRow int
+	state        state
+	prevState    state
+	// column of the commit and of the previous one
+	commitIndex     int
+	prevCommitIndex int
+	// position of the first parent's edge relative to a merge: 0 left, 1 below
+	mergeLayout int
+	// columns added by the merge to the right of it
+	edgesAdded     int
+	prevEdgesAdded int
+	// commits expected in each column before and after the current commit
+	columns    []string
+	newColumns []string
+	// for each character of the line, the column the branch line there
+	// goes to, or -1; the arrays only grow, old entries of oldMapping
+	// outlive the lines they were made for
+	mapping     []int
+	oldMapping  []int
+	mappingSize int
+}
+
+func New() *Graph {
+	return &Graph{}
+}
+
+func (g *Graph) setState(s state) {
+	g.prevState = g.state
+	g.state = s
+}
+
+// Moves on to the next commit to show
+func (g *Graph) Update(commit string, parents []string) {
+	g.commit = commit
+	g.parents = parents
+	g.prevCommitIndex = g.commitIndex
+	g.updateColumns()
+	g.expansionRow = 0
+	// the previous commit's lines were not all shown: mark the gap
+	switch {
+	case g.state != statePadding:
+		g.state = stateSkip
+	case g.needsPreCommitLine():
+		g.state = statePreCommit
+	default:
+		g.state = stateCommit
+	}
+}
+
+func (g *Graph) findNewColumn(commit string) int {
+	for i, c := range g.newColumns {
+		if c == commit {
+			return i
+		}
+	}
+	return -1
+}
+
+// Puts the commit into the columns after the current commit, idx being the
+// column of the current commit when the commit is its parent, and -1
+// otherwise
+func (g *Graph) insertIntoNewColumns(commit string, idx int) {
+	i := g.findNewColumn(commit)
+	if i < 0 {
+		i = len(g.newColumns)
+		g.newColumns = append(g.newColumns, commit)
+	}
+	var mappingIdx int
+	switch {
+	case len(g.parents) > 1 && idx > -1 && g.mergeLayout == -1:
+		// the first parent of a merge decides whether its edges start to
+		// the left of it or right below it
+		dist := idx - i
+		shift := 1
+		if dist > 1 {
+			shift = 2*dist - 3
+		}
+		g.mergeLayout = 1
+		if dist > 0 {
+			g.mergeLayout = 0
+		}
+		g.edgesAdded = len(g.parents) + g.mergeLayout - 2
+		mappingIdx = g.width + (g.mergeLayout-1)*shift
+		g.width += 2 * g.mergeLayout
+	case g.edgesAdded > 0 && i == g.mapping[g.width-2]:
+		// a column added by the merge joins the last existing one at once
+		mappingIdx = g.width - 2
+		g.edgesAdded = -1
+	default:
+		mappingIdx = g.width
+		g.width += 2
+	}
+	g.mapping[mappingIdx] = i
+}
+
+func (g *Graph) updateColumns() {
+	g.columns, g.newColumns = g.newColumns, g.columns[:0]
+	g.mappingSize = 2 * (len(g.columns) + len(g.parents))
+	for len(g.mapping) < g.mappingSize {
+		g.mapping = append(g.mapping, -1)
+		g.oldMapping = append(g.oldMapping, -1)
+	}
+	for i := 0; i < g.mappingSize; i += 1 {
+		g.mapping[i] = -1
+	}
+	g.width = 0
+	g.prevEdgesAdded = g.edgesAdded
+	g.edgesAdded = 0
+	seenThis := false
+	for i := 0; i <= len(g.columns); i += 1 {
+		var commit string
+		if i == len(g.columns) {
+			if seenThis {
+				break
+			}
+			commit = g.commit
+		} else {
+			commit = g.columns[i]
+		}
+		if commit != g.commit {
+			g.insertIntoNewColumns(commit, -1)
+			continue
+		}
+		seenThis = true
+		g.commitIndex = i
+		g.mergeLayout = -1
+		for _, parent := range g.parents {
+			g.insertIntoNewColumns(parent, i)
+		}
+		// the commit takes up a column even without parents
+		if len(g.parents) == 0 {
+			g.width += 2
+		}
+	}
+	for g.mappingSize > 1 && g.mapping[g.mappingSize-1] < 0 {
+		g.mappingSize -= 1
+	}
+}
+
+func (g *Graph) numDashedParents() int {
+	return len(g.parents) + g.mergeLayout - 3
+}
+
+// Two rows make room for each dashed edge of an octopus merge
+func (g *Graph) numExpansionRows() int {
+	return g.numDashedParents() * 2
+}
+
+func (g *Graph) needsPreCommitLine() bool {
+	return len(g.parents) >= 3 &&
+		g.commitIndex < len(g.columns)-1 &&
+		g.expansionRow < g.numExpansionRows()
+}
+
+// Whether every branch line is at its column or will be with the next '/'
+func (g *Graph) isMappingCorrect() bool {
+	for i, target := range g.mapping[:g.mappingSize] {
+		if target >= 0 && target != i/2 {
+			return false
+		}
+	}
+	return true
+}
+
+// Whether all the lines of the current commit were shown
+func (g *Graph) IsCommitFinished() bool {
+	return g.state == statePadding
+}
+
+// The next line of the graph and whether it is the line of the commit
+func (g *Graph) NextLine() (string, bool) {
+	if g.commit == "" {
+		return "", false
+	}
+	var b strings.Builder
+	shownCommit := false
+	switch g.state {
+	case statePadding:
+		g.paddingLine(&b)
+	case stateSkip:
+		g.skipLine(&b)
+	case statePreCommit:
+		g.preCommitLine(&b)
+	case stateCommit:
+		g.commitLine(&b)
+		shownCommit = true
+	case statePostMerge:
+		g.postMergeLine(&b)
+	case stateCollapsing:
+		g.collapsingLine(&b)
+	}
+	return g.pad(b.String()), shownCommit
+}
+
+// Pads the line to the width of the graph, so that what follows lines up
+func (g *Graph) pad(line string) string {
+	if len(line) < g.width {
+		line += strings.Repeat(" ", g.width-len(line))
+	}
+	return line
+}
+
+// A line leaving the branch lines unchanged, to go before the lines of
+// text after the commit line; before it, the next line of the graph
+func (g *Graph) PaddingLine() string {
+	if g.state != stateCommit {
+		line, _ := g.NextLine()
+		return line
+	}
+	var b strings.Builder
+	for _, commit := range g.columns {
+		b.WriteByte('|')
+		if commit == g.commit && len(g.parents) > 2 {
+			b.WriteString(strings.Repeat(" ", (len(g.parents)-2)*2))
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	g.prevState = statePadding
+	return g.pad(b.String())
+}
+
+func (g *Graph) paddingLine(b *strings.Builder) {
+	for range g.newColumns {
+		b.WriteString("| ")
+	}
+}
+
+func (g *Graph) skipLine(b *strings.Builder) {
+	b.WriteString("...")
+	if g.needsPreCommitLine() {
+		g.setState(statePreCommit)
+	} else {
+		g.setState(stateCommit)
+	}
+}
+
+// Widens the space around an octopus merge before its line
+func (g *Graph) preCommitLine(b *strings.Builder) {
+	seenThis := false
+	for i, commit := range g.columns {
+		switch {
+		case commit == g.commit:
+			seenThis = true
+			b.WriteByte('|')
+			b.WriteString(strings.Repeat(" ", g.expansionRow))
+		case seenThis && g.expansionRow == 0:
+			// lines after a merge shown as '\' continue that way
+			if g.prevState == statePostMerge && g.prevCommitIndex < i {
+				b.WriteByte('\\')
+			} else {
+				b.WriteByte('|')
+			}
+		case seenThis:
+			b.WriteByte('\\')
+		default:
+			b.WriteByte('|')
+		}
+		b.WriteByte(' ')
+	}
+	g.expansionRow += 1
+	if !g.needsPreCommitLine() {
+		g.setState(stateCommit)
+	}
+}
+
+// Dashes leading to the parents of an octopus merge after the first two
+func (g *Graph) drawOctopusMerge(b *strings.Builder) {
+	dashed := g.numDashedParents()
+	for i := 0; i < dashed; i += 1 {
+		b.WriteByte('-')
+		if i == dashed-1 {
+			b.WriteByte('.')
+		} else {
+			b.WriteByte('-')
+		}
+	}
+}
+
+func (g *Graph) commitLine(b *strings.Builder) {
+	seenThis := false
+	for i := 0; i <= len(g.columns); i += 1 {
+		var commit string
+		if i == len(g.columns) {
+			if seenThis {
+				break
+			}
+			commit = g.commit
+		} else {
+			commit = g.columns[i]
+		}
+		switch {
+		case commit == g.commit:
+			seenThis = true
+			b.WriteByte('*')
+			if len(g.parents) > 2 {
+				g.drawOctopusMerge(b)
+			}
+		case seenThis && g.edgesAdded > 1:
+			b.WriteByte('\\')
+		case seenThis && g.edgesAdded == 1:
+			// the first line of a merge without pre-commit lines continues
+			// a '\' of the previous merge
+			if g.prevState == statePostMerge && g.prevEdgesAdded > 0 && g.prevCommitIndex < i {
+				b.WriteByte('\\')
+			} else {
+				b.WriteByte('|')
+			}
+		case g.prevState == stateCollapsing && g.oldMapping[2*i+1] == i && g.mapping[2*i] < i:
+			b.WriteByte('/')
+		default:
+			b.WriteByte('|')
+		}
+		b.WriteByte(' ')
+	}
+	switch {
+	case len(g.parents) > 1:
+		g.setState(statePostMerge)
+	case g.isMappingCorrect():
+		g.setState(statePadding)
+	default:
+		g.setState(stateCollapsing)
+	}
+}
+
+// Edges from a merge to its parents
+func (g *Graph) postMergeLine(b *strings.Builder) {
+	seenThis := false
+	parentColumn := false
+	for i := 0; i <= len(g.columns); i += 1 {
+		var commit string
+		if i == len(g.columns) {
+			if seenThis {
+				break
+			}
+			commit = g.commit
+		} else {
+			commit = g.columns[i]
+		}
+		switch {
+		case commit == g.commit:
+			seenThis = true
+			idx := g.mergeLayout
+			for j := range g.parents {
+				b.WriteByte(mergeChars[idx])
+				if idx == 2 {
+					if g.edgesAdded > 0 || j < len(g.parents)-1 {
+						b.WriteByte(' ')
+					}
+				} else {
+					idx += 1
+				}
+			}
+			if g.edgesAdded == 0 {
+				b.WriteByte(' ')
+			}
+		case seenThis:
+			if g.edgesAdded > 0 {
+				b.WriteByte('\\')
+			} else {
+				b.WriteByte('|')
+			}
+			b.WriteByte(' ')
+		default:
+			b.WriteByte('|')
+			if g.mergeLayout != 0 || i != g.commitIndex-1 {
+				if parentColumn {
+					b.WriteByte('_')
+				} else {
+					b.WriteByte(' ')
+				}
+			}
+		}
+		if len(g.parents) > 0 && commit == g.parents[0] {
+			parentColumn = true
+		}
+	}
+	if g.isMappingCorrect() {
+		g.setState(statePadding)
+	} else {
+		g.setState(stateCollapsing)
+	}
+}
+
+// Moves branch lines one step to the left towards their columns, joining
+// lines going to the same commit
+func (g *Graph) collapsingLine(b *strings.Builder) {
+	g.mapping, g.oldMapping = g.oldMapping, g.mapping
+	for i := 0; i < g.mappingSize; i += 1 {
+		g.mapping[i] = -1
+	}
+	usedHorizontal := false
+	horizontalEdge, horizontalEdgeTarget := -1, -1
+	for i, target := range g.oldMapping[:g.mappingSize] {
+		if target < 0 {
+			continue
+		}
+		// lines only ever move to the left
+		switch {
+		case target*2 == i:
+			g.mapping[i] = target
+		case g.mapping[i-1] < 0:
+			g.mapping[i-1] = target
+			if horizontalEdge == -1 {
+				horizontalEdge, horizontalEdgeTarget = i, target
+				for j := target*2 + 3; j < i-2; j += 2 {
+					g.mapping[j] = target
+				}
+			}
+		case g.mapping[i-1] == target:
+			// joins the line to the left going to the same commit
+		default:
+			// crosses the line to the left
+			g.mapping[i-2] = target
+			if horizontalEdge == -1 {
+				horizontalEdge, horizontalEdgeTarget = i-1, target
+				for j := target*2 + 3; j < i-2; j += 2 {
+					g.mapping[j] = target
+				}
+			}
+		}
+	}
+	copy(g.oldMapping, g.mapping[:g.mappingSize])
+	// the line may have become one character shorter
+	if g.mapping[g.mappingSize-1] < 0 {
+		g.mappingSize -= 1
+	}
+	for i, target := range g.mapping[:g.mappingSize] {
+		switch {
+		case target < 0:
+			b.WriteByte(' ')
+		case target*2 == i:
+			b.WriteByte('|')
+		case target == horizontalEdgeTarget && i != horizontalEdge-1:
+			// only the first segment of a horizontal edge continues on the
+			// next line
+			if i != target*2+3 {
+				g.mapping[i] = -1
+			}
+			usedHorizontal = true
+			b.WriteByte('_')
+		default:
+			if usedHorizontal && i < horizontalEdge {
+				g.mapping[i] = -1
+			}
+			b.WriteByte('/')
+		}
+	}
+	if g.isMappingCorrect() {
+		g.setState(statePadding)
+	}
+}
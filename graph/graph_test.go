@@ -0,0 +1,64 @@
+package graph_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/magnickolas/gitok/graph"
+)
+
+type commit struct {
+	name    string
+	parents []string
+}
+
+func render(commits []commit) string {
+	g := graph.New()
+	var b strings.Builder
+	for _, c := range commits {
+		g.Update(c.name, c.parents)
+		for !g.IsCommitFinished() {
+			line, isCommit := g.NextLine()
+			b.WriteString(line)
+			if isCommit {
+				b.WriteString(c.name)
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+func TestGraph(t *testing.T) {
+	tests := []struct {
+		commits []commit
+		want    string
+	}{
+		{
+			commits: []commit{{"b", []string{"a"}}, {"a", nil}},
+			want:    "* b\n* a\n",
+		},
+		{
+			commits: []commit{
+				{"m", []string{"a", "b"}}, {"b", []string{"base"}}, {"a", []string{"base"}}, {"base", nil},
+			},
+			want: "*   m\n|\\  \n| * b\n* | a\n|/  \n* base\n",
+		},
+		{
+			commits: []commit{
+				{"m", []string{"a", "b", "c"}}, {"c", []string{"base"}}, {"b", []string{"base"}},
+				{"a", []string{"base"}}, {"base", nil},
+			},
+			want: "*-.   m\n|\\ \\  \n| | * c\n| * | b\n| |/  \n* / a\n|/  \n* base\n",
+		},
+		{
+			commits: []commit{{"b", nil}, {"a", nil}},
+			want:    "* b\n* a\n",
+		},
+	}
+	for _, test := range tests {
+		if got := render(test.commits); got != test.want {
+			t.Errorf("incorrect result for %#v: wanted %#v, got %#v", test.commits, test.want, got)
+		}
+	}
+}
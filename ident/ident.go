@@ -0,0 +1,79 @@
+package ident
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/magnickolas/gitok/config"
+	"github.com/magnickolas/gitok/date"
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/repr"
+)
+
+var ErrorUnknownIdentity = errors.New("identity unknown")
+
+// Author identity from GIT_AUTHOR_{NAME,EMAIL,DATE}, falling back to the
+// author.* and user.* config keys and the current time
+func Author(cfg *config.Config) (repr.Signature, error) {
+	return get(cfg, "AUTHOR", "author")
+}
+
+// Committer identity from GIT_COMMITTER_{NAME,EMAIL,DATE}, falling back to
+// the committer.* and user.* config keys and the current time
+func Committer(cfg *config.Config) (repr.Signature, error) {
+	return get(cfg, "COMMITTER", "committer")
+}
+
+// Writes the commit of the tree by the author and committer of the config
+func NewCommit(cfg *config.Config, tree string, parents []string, message string) (*repr.Commit, error) {
+	author, err := Author(cfg)
+	if err != nil {
+		return nil, err
+	}
+	committer, err := Committer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	commit := repr.NewCommitFromFields(tree, parents, author, committer, message)
+	if err := fs.WriteObject(commit); err != nil {
+		return nil, err
+	}
+	return commit, nil
+}
+
+func get(cfg *config.Config, envRole string, configRole string) (repr.Signature, error) {
+	name := lookupPart(cfg, "GIT_"+envRole+"_NAME", configRole+".name", "user.name")
+	email := lookupPart(cfg, "GIT_"+envRole+"_EMAIL", configRole+".email", "user.email")
+	if email == "" {
+		email = os.Getenv("EMAIL")
+	}
+	if name == "" || email == "" {
+		return repr.Signature{}, fmt.Errorf(
+			"%w: %v name and email must be set with user.name and user.email config "+
+				"or the GIT_%v_NAME and GIT_%v_EMAIL environment variables",
+			ErrorUnknownIdentity, configRole, envRole, envRole)
+	}
+	when := time.Now()
+	if s := os.Getenv("GIT_" + envRole + "_DATE"); s != "" {
+		var err error
+		when, err = date.Parse(s)
+		if err != nil {
+			return repr.Signature{}, fmt.Errorf("GIT_%v_DATE: %w", envRole, err)
+		}
+	}
+	return repr.Signature{Name: name, Email: email, When: when}, nil
+}
+
+func lookupPart(cfg *config.Config, env string, keys ...string) string {
+	if value := os.Getenv(env); value != "" {
+		return value
+	}
+	for _, key := range keys {
+		if value, ok := cfg.Get(key); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,200 @@
+package ignore
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/magnickolas/gitok/config"
+	"github.com/magnickolas/gitok/constants"
+	"github.com/magnickolas/gitok/wildmatch"
+)
+
+const GitIgnore = ".gitignore"
+
+type pattern struct {
+	pattern string
+	// directory of the .gitignore the pattern comes from, "" for the root
+	base     string
+	negated  bool
+	dirOnly  bool
+	basename bool
+}
+
+// Decides whether worktree paths are ignored following gitignore rules:
+// patterns from deeper .gitignore files take precedence over shallower
+// ones, which take precedence over info/exclude and core.excludesFile
+type Matcher struct {
+	global   []pattern
+	perDir   map[string][]pattern
+	caseFold bool
+}
+
+// Matcher for the standard exclusion sources (git's --exclude-standard)
+func NewStandard(cfg *config.Config) (*Matcher, error) {
+	m := &Matcher{perDir: make(map[string][]pattern)}
+	ignoreCase, err := cfg.GetBool("core.ignoreCase", false)
+	if err != nil {
+		return nil, err
+	}
+	m.caseFold = ignoreCase
+	excludesFile, ok := cfg.Get("core.excludesFile")
+	if !ok {
+		if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+			excludesFile = filepath.Join(xdg, "git", "ignore")
+		} else if home, err := os.UserHomeDir(); err == nil {
+			excludesFile = filepath.Join(home, ".config", "git", "ignore")
+		}
+	} else if rest, found := strings.CutPrefix(excludesFile, "~/"); found {
+		if home, err := os.UserHomeDir(); err == nil {
+			excludesFile = filepath.Join(home, rest)
+		}
+	}
+	for _, file := range []string{excludesFile, filepath.Join(constants.Git, "info", "exclude")} {
+		if file == "" {
+			continue
+		}
+		patterns, err := readPatterns(file, "")
+		if err != nil {
+			return nil, err
+		}
+		m.global = append(m.global, patterns...)
+	}
+	return m, nil
+}
+
+// Reports whether the slash-separated worktree path is ignored, which is
+// also the case when one of its parent directories is
+func (m *Matcher) IsIgnored(p string, isDir bool) (bool, error) {
+	components := strings.Split(p, "/")
+	for i := 1; i <= len(components); i += 1 {
+		sub := strings.Join(components[:i], "/")
+		ignored, err := m.isIgnoredShallow(sub, isDir || i < len(components))
+		if err != nil || ignored {
+			return ignored, err
+		}
+	}
+	return false, nil
+}
+
+// Checks the path itself assuming that its parent directories are not
+// ignored
+func (m *Matcher) isIgnoredShallow(p string, isDir bool) (bool, error) {
+	dir := path.Dir(p)
+	if dir == "." {
+		dir = ""
+	}
+	for {
+		patterns, err := m.loadDir(dir)
+		if err != nil {
+			return false, err
+		}
+		if matched, negated := m.lastMatch(patterns, p, isDir); matched {
+			return !negated, nil
+		}
+		if dir == "" {
+			break
+		}
+		dir = path.Dir(dir)
+		if dir == "." {
+			dir = ""
+		}
+	}
+	matched, negated := m.lastMatch(m.global, p, isDir)
+	return matched && !negated, nil
+}
+
+func (m *Matcher) lastMatch(patterns []pattern, p string, isDir bool) (matched bool, negated bool) {
+	for i := len(patterns) - 1; i >= 0; i -= 1 {
+		if m.matches(patterns[i], p, isDir) {
+			return true, patterns[i].negated
+		}
+	}
+	return false, false
+}
+
+func (m *Matcher) matches(pat pattern, p string, isDir bool) bool {
+	if pat.dirOnly && !isDir {
+		return false
+	}
+	flags := wildmatch.Pathname
+	if m.caseFold {
+		flags |= wildmatch.CaseFold
+	}
+	if pat.basename {
+		return wildmatch.Match(pat.pattern, path.Base(p), flags)
+	}
+	rel := p
+	if pat.base != "" {
+		var found bool
+		rel, found = strings.CutPrefix(p, pat.base+"/")
+		if !found {
+			return false
+		}
+	}
+	return wildmatch.Match(pat.pattern, rel, flags)
+}
+
+// Patterns of the .gitignore in the directory, read once
+func (m *Matcher) loadDir(dir string) ([]pattern, error) {
+	if patterns, ok := m.perDir[dir]; ok {
+		return patterns, nil
+	}
+	file := GitIgnore
+	if dir != "" {
+		file = filepath.Join(filepath.FromSlash(dir), GitIgnore)
+	}
+	patterns, err := readPatterns(file, dir)
+	if err != nil {
+		return nil, err
+	}
+	m.perDir[dir] = patterns
+	return patterns, nil
+}
+
+func readPatterns(file string, base string) ([]pattern, error) {
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var patterns []pattern
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if pat, ok := parsePattern(sc.Text(), base); ok {
+			patterns = append(patterns, pat)
+		}
+	}
+	return patterns, sc.Err()
+}
+
+func parsePattern(line string, base string) (pattern, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	// trailing spaces are ignored unless escaped with a backslash
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return pattern{}, false
+	}
+	pat := pattern{base: base}
+	if strings.HasPrefix(line, "!") {
+		pat.negated = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		pat.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	pat.basename = !strings.Contains(line, "/")
+	pat.pattern = strings.TrimPrefix(line, "/")
+	return pat, pat.pattern != ""
+}
@@ -0,0 +1,26 @@
+package index
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/magnickolas/gitok/constants"
+	"github.com/magnickolas/gitok/index/parser"
+)
+
+// Reads the repository index, an absent index file being an empty index
+func Read() (*parser.Index, error) {
+	r, err := os.Open(filepath.Join(constants.Git, constants.Index))
+	if errors.Is(err, os.ErrNotExist) {
+		return &parser.Index{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	p, err := parser.NewParser(r)
+	if err != nil {
+		return nil, err
+	}
+	return p.Parse()
+}
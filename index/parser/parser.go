@@ -2,12 +2,33 @@ package parser
 
 import (
 	"bytes"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 
 	"github.com/magnickolas/gitok/repr"
 )
 
+var (
+	ErrorCorrupt       = errors.New("index file corrupt")
+	formatErrorCorrupt = func(reason string) error {
+		return fmt.Errorf("%w: %v", ErrorCorrupt, reason)
+	}
+	ErrorUnknownExtension       = errors.New("index uses an extension which we do not understand")
+	formatErrorUnknownExtension = func(signature string) error {
+		return fmt.Errorf("%w: %v", ErrorUnknownExtension, signature)
+	}
+)
+
+const (
+	ceNameMask      uint32 = 0x0FFF
+	ceExtended      uint32 = 0x4000
+	ceIntentToAdd   uint32 = (1 << 29)
+	ceSkipWorktree  uint32 = (1 << 30)
+	ceExtendedFlags uint32 = ceIntentToAdd | ceSkipWorktree
+)
+
 type Index struct {
 	Magic      int32
 	Version    int32
@@ -26,9 +47,26 @@ type Entry struct {
 	Uid     int32
 	Gid     int32
 	Size    int32
+	Digest  string
+	Flags   uint32
 	Name    string
 }
 
+func (e *Entry) ObjectMode() repr.ObjectModeType {
+	return repr.ObjectModeType(fmt.Sprintf("%o", uint32(e.Mode)))
+}
+
+// Merge stage of the entry: 0 for a normal entry, 1-3 for conflicts
+func (e *Entry) Stage() int {
+	return int(e.Flags>>12) & 0x3
+}
+
+// Whether the entry only records that the path will be added, as
+// "git add -N" leaves it
+func (e *Entry) IntentToAdd() bool {
+	return e.Flags&ceIntentToAdd != 0
+}
+
 type Parser struct {
 	b []byte
 }
@@ -48,20 +86,26 @@ func NewParser(r io.Reader) (*Parser, error) {
 }
 
 func (p *Parser) Parse() (*Index, error) {
-	const CE_NAMEMASK uint32 = 0x0FFF
-	const CE_EXTENDED uint32 = 0x4000
-	const CE_INTENT_TO_ADD uint32 = (1 << 29)
-	const CE_SKIP_WORKTREE uint32 = (1 << 30)
-	const CE_EXTENDED_FLAGS uint32 = CE_INTENT_TO_ADD | CE_SKIP_WORKTREE
-
+	hashSize := repr.HashSize()
+	if len(p.b) < 12 {
+		return nil, formatErrorCorrupt("truncated header")
+	}
 	index := Index{}
 	index.Magic = p.parseInt32()
 	if index.Magic != int32('D')<<24|int32('I')<<16|int32('R')<<8|int32('C') {
 		return nil, fmt.Errorf("wrong magic")
 	}
 	index.Version = p.parseInt32()
+	if index.Version < 2 || index.Version > 4 {
+		return nil, fmt.Errorf("unsupported index version %d", index.Version)
+	}
 	index.NumEntries = p.parseInt32()
+	var prevName string
 	for i := int32(0); i < index.NumEntries; i += 1 {
+		if len(p.b) < 42+hashSize {
+			return nil, formatErrorCorrupt("truncated index entry")
+		}
+		entryLen := len(p.b)
 		entry := Entry{}
 		entry.CTime = p.parseInt32()
 		entry.CTimeNS = p.parseInt32()
@@ -73,23 +117,71 @@ func (p *Parser) Parse() (*Index, error) {
 		entry.Uid = p.parseInt32()
 		entry.Gid = p.parseInt32()
 		entry.Size = p.parseInt32()
-		p.shift(repr.HashSize())
-		flags := uint32(p.parseInt16())
-		length := uint32(flags & CE_NAMEMASK)
-		if flags&CE_EXTENDED != 0 {
-			extended_flags := uint32(p.parseInt16()) << 16
-			if extended_flags&(^CE_EXTENDED_FLAGS) != 0 {
+		entry.Digest = hex.EncodeToString(p.b[:hashSize])
+		p.shift(hashSize)
+		flags := uint32(uint16(p.parseInt16()))
+		length := uint32(flags & ceNameMask)
+		if flags&ceExtended != 0 {
+			if len(p.b) < 2 {
+				return nil, formatErrorCorrupt("truncated index entry")
+			}
+			extended_flags := uint32(uint16(p.parseInt16())) << 16
+			if extended_flags&(^ceExtendedFlags) != 0 {
 				return nil, fmt.Errorf("unknown index entry format 0x%08x", extended_flags)
 			}
 			flags |= extended_flags
 		}
-		if length == CE_NAMEMASK {
-			length = uint32(bytes.Index(p.b, []byte{0}))
+		entry.Flags = flags
+		if index.Version == 4 {
+			// the name is stored as the number of bytes to strip from the
+			// previous name followed by the NUL-terminated suffix
+			strip, ok := p.parseVarint()
+			if !ok || strip > len(prevName) {
+				return nil, formatErrorCorrupt("malformed name field")
+			}
+			end := bytes.IndexByte(p.b, 0)
+			if end == -1 {
+				return nil, formatErrorCorrupt("malformed name field")
+			}
+			entry.Name = prevName[:len(prevName)-strip] + string(p.b[:end])
+			p.shift(end + 1)
+		} else {
+			if length == ceNameMask {
+				end := bytes.IndexByte(p.b, 0)
+				if end == -1 {
+					return nil, formatErrorCorrupt("malformed name field")
+				}
+				length = uint32(end)
+			}
+			// entries are padded with 1-8 NULs to a multiple of 8 bytes
+			entryLen = entryLen - len(p.b) + int(length)
+			padding := 8 - entryLen%8
+			if int(length)+padding > len(p.b) {
+				return nil, formatErrorCorrupt("truncated index entry")
+			}
+			entry.Name = string(p.b[:length])
+			p.shift(int(length) + padding)
 		}
-		entry.Name = string(p.b[:length])
-		p.shift(int(length) + 1)
+		prevName = entry.Name
 		index.Entries = append(index.Entries, entry)
 	}
+	// extensions up to the trailing checksum; those whose signature starts
+	// with a capital letter are optional and may be skipped
+	for len(p.b) > hashSize {
+		if len(p.b) < 8+hashSize {
+			return nil, formatErrorCorrupt("truncated extension")
+		}
+		signature := string(p.b[:4])
+		p.shift(4)
+		size := uint32(p.parseInt32())
+		if signature[0] < 'A' || signature[0] > 'Z' {
+			return nil, formatErrorUnknownExtension(signature)
+		}
+		if uint64(size) > uint64(len(p.b)-hashSize) {
+			return nil, formatErrorCorrupt("truncated extension")
+		}
+		p.shift(int(size))
+	}
 	return &index, nil
 }
 
@@ -105,6 +197,26 @@ func (p *Parser) parseInt32() (res int32) {
 	return
 }
 
+// Offset-encoded varint used by the index v4 path compression, false if
+// the input ends within it
+func (p *Parser) parseVarint() (int, bool) {
+	if len(p.b) == 0 {
+		return 0, false
+	}
+	c := p.b[0]
+	p.shift(1)
+	res := int(c & 0x7f)
+	for c&0x80 != 0 {
+		if len(p.b) == 0 {
+			return 0, false
+		}
+		c = p.b[0]
+		p.shift(1)
+		res = ((res + 1) << 7) | int(c&0x7f)
+	}
+	return res, true
+}
+
 func (p *Parser) shift(n int) {
 	p.b = p.b[n:]
 }
@@ -0,0 +1,112 @@
+package parser_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/magnickolas/gitok/index"
+	"github.com/magnickolas/gitok/index/parser"
+	"github.com/magnickolas/gitok/repr"
+)
+
+// Encoded index of entries with the names, followed by the extensions
+// before the checksum
+func encode(names []string, extensions ...string) []byte {
+	idx := &parser.Index{}
+	for _, name := range names {
+		idx.Entries = append(idx.Entries, parser.Entry{
+			Mode:   0100644,
+			Digest: strings.Repeat("e6", repr.HashSize()),
+			Name:   name,
+		})
+	}
+	b := index.Encode(idx)
+	checksum := b[len(b)-repr.HashSize():]
+	b = slices.Clone(b[:len(b)-repr.HashSize()])
+	for _, ext := range extensions {
+		b = append(b, ext...)
+	}
+	return append(b, checksum...)
+}
+
+// Extension with the signature and the content
+func extension(signature string, content string) string {
+	size := binary.BigEndian.AppendUint32(nil, uint32(len(content)))
+	return signature + string(size) + content
+}
+
+func TestParse(t *testing.T) {
+	valid := encode([]string{"a", "dir/file"})
+	tests := []struct {
+		input   []byte
+		want    []string
+		wantErr error
+	}{
+		{
+			input: valid,
+			want:  []string{"a", "dir/file"},
+		},
+		{
+			input: encode([]string{strings.Repeat("x", 5000)}),
+			want:  []string{strings.Repeat("x", 5000)},
+		},
+		{
+			input: encode([]string{"a"}, extension("TREE", "ignored"), extension("REUC", "")),
+			want:  []string{"a"},
+		},
+		{
+			input:   encode(nil, extension("link", strings.Repeat("0", 20))),
+			wantErr: parser.ErrorUnknownExtension,
+		},
+		{
+			input:   encode([]string{"a"}, "TREE\x00\x00\x01\x00"),
+			wantErr: parser.ErrorCorrupt,
+		},
+		{
+			input:   valid[:8],
+			wantErr: parser.ErrorCorrupt,
+		},
+		{
+			input:   valid[:12+40],
+			wantErr: parser.ErrorCorrupt,
+		},
+		{
+			// cut within the padding of the first entry
+			input:   valid[:12+62+1],
+			wantErr: parser.ErrorCorrupt,
+		},
+		{
+			// cut within the long name of the only entry
+			input:   encode([]string{strings.Repeat("x", 5000)})[:12+62+100],
+			wantErr: parser.ErrorCorrupt,
+		},
+	}
+	for _, test := range tests {
+		p, err := parser.NewParser(bytes.NewReader(test.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		idx, err := p.Parse()
+		if test.wantErr != nil {
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("incorrect result for %#v: wanted %#v, got %#v", test.input, test.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("failed to parse %#v: %v", test.input, err)
+			continue
+		}
+		var names []string
+		for _, entry := range idx.Entries {
+			names = append(names, entry.Name)
+		}
+		if !slices.Equal(names, test.want) {
+			t.Errorf("incorrect result for %#v: wanted %#v, got %#v", test.input, test.want, names)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package index
+
+import (
+	"io/fs"
+	"syscall"
+
+	"github.com/magnickolas/gitok/index/parser"
+)
+
+// Fills the change time, device, inode and owner of the entry
+func setStat(entry *parser.Entry, info fs.FileInfo) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		entry.CTime, entry.CTimeNS = int32(st.Ctim.Sec), int32(st.Ctim.Nsec)
+		entry.Dev, entry.Ino = int32(st.Dev), int32(st.Ino)
+		entry.Uid, entry.Gid = int32(st.Uid), int32(st.Gid)
+	}
+}
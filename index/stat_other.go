@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+
+package index
+
+import (
+	"io/fs"
+
+	"github.com/magnickolas/gitok/index/parser"
+)
+
+// Leaves the stat data the platform does not give zero
+func setStat(entry *parser.Entry, info fs.FileInfo) {}
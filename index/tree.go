@@ -0,0 +1,79 @@
+package index
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/index/parser"
+	"github.com/magnickolas/gitok/repr"
+)
+
+var (
+	ErrorUnmergedIndex     = errors.New("index contains unmerged entries")
+	ErrorInvalidPath       = errors.New("invalid path in the index")
+	formatErrorInvalidPath = func(name string) error {
+		return fmt.Errorf("%w '%v'", ErrorInvalidPath, name)
+	}
+)
+
+// Writes tree objects for the index entries and returns the root tree
+// digest. Entries only intended to be added are left out
+func WriteTree(entries []parser.Entry) (string, error) {
+	var added []parser.Entry
+	for _, entry := range entries {
+		if entry.Stage() != 0 {
+			return "", fmt.Errorf("%w: %v", ErrorUnmergedIndex, entry.Name)
+		}
+		// a tree cannot have an entry with an empty name
+		if slices.Contains(strings.Split(entry.Name, "/"), "") {
+			return "", formatErrorInvalidPath(entry.Name)
+		}
+		if !entry.IntentToAdd() {
+			added = append(added, entry)
+		}
+	}
+	return writeSubtree(added, "")
+}
+
+// Writes the tree for index entries under prefix (which is either empty or
+// ends with a slash)
+func writeSubtree(entries []parser.Entry, prefix string) (string, error) {
+	var treeEntries []repr.TreeEntry
+	subdirs := make(map[string][]parser.Entry)
+	var subdirOrder []string
+	for _, entry := range entries {
+		rel := strings.TrimPrefix(entry.Name, prefix)
+		dir, _, found := strings.Cut(rel, "/")
+		if !found {
+			treeEntries = append(treeEntries, repr.TreeEntry{
+				Name:   rel,
+				Mode:   entry.ObjectMode(),
+				Digest: entry.Digest,
+			})
+			continue
+		}
+		if _, ok := subdirs[dir]; !ok {
+			subdirOrder = append(subdirOrder, dir)
+		}
+		subdirs[dir] = append(subdirs[dir], entry)
+	}
+	for _, dir := range subdirOrder {
+		digest, err := writeSubtree(subdirs[dir], prefix+dir+"/")
+		if err != nil {
+			return "", err
+		}
+		treeEntries = append(treeEntries, repr.TreeEntry{
+			Name:   dir,
+			Mode:   repr.ModeTree,
+			Digest: digest,
+		})
+	}
+	tree := repr.NewTreeFromEntries(treeEntries)
+	if err := fs.WriteObject(tree); err != nil {
+		return "", err
+	}
+	return tree.Digest(), nil
+}
@@ -0,0 +1,85 @@
+package index_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/index"
+	"github.com/magnickolas/gitok/index/parser"
+	"github.com/magnickolas/gitok/repr"
+)
+
+const intentToAdd = 1 << 29
+
+func TestWriteTree(t *testing.T) {
+	dir := t.TempDir()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+	if err := os.MkdirAll(filepath.Join(".git", "objects"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	blob, err := repr.NewBlob(strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := func(name string, flags uint32) parser.Entry {
+		return parser.Entry{Mode: 0100644, Digest: blob.Digest(), Name: name, Flags: flags}
+	}
+	tests := []struct {
+		entries []parser.Entry
+		want    []string
+		wantErr error
+	}{
+		{
+			entries: []parser.Entry{entry("a", 0), entry("d/b", 0)},
+			want:    []string{"a", "d"},
+		},
+		{
+			entries: []parser.Entry{entry("a", 0), entry("ita", intentToAdd), entry("d/ita", intentToAdd)},
+			want:    []string{"a"},
+		},
+		{
+			entries: []parser.Entry{entry("a", 0), entry("d/ita", intentToAdd), entry("d/b", 0)},
+			want:    []string{"a", "d"},
+		},
+		{
+			entries: []parser.Entry{entry("d//a", 0)},
+			wantErr: index.ErrorInvalidPath,
+		},
+		{
+			entries: []parser.Entry{entry("", 0)},
+			wantErr: index.ErrorInvalidPath,
+		},
+	}
+	for _, test := range tests {
+		digest, err := index.WriteTree(test.entries)
+		if test.wantErr != nil || err != nil {
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("incorrect result for %#v: wanted %#v, got %#v", test.entries, test.wantErr, err)
+			}
+			continue
+		}
+		tree, err := fs.ReadTree(digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, e := range tree.Entries() {
+			names = append(names, e.Name)
+		}
+		if !slices.Equal(names, test.want) {
+			t.Errorf("incorrect result for %#v: wanted %#v, got %#v", test.entries, test.want, names)
+		}
+	}
+}
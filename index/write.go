@@ -0,0 +1,114 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/magnickolas/gitok/constants"
+	"github.com/magnickolas/gitok/index/parser"
+	"github.com/magnickolas/gitok/repr"
+)
+
+const (
+	nameMask     = 0x0fff
+	extendedFlag = 0x4000
+)
+
+// Index entry for a file of the worktree with its stat data
+func NewEntry(name string, info fs.FileInfo, mode repr.ObjectModeType, digest string) parser.Entry {
+	m, _ := strconv.ParseUint(string(mode), 8, 32)
+	entry := parser.Entry{
+		Mode:   int32(m),
+		Digest: digest,
+		Name:   name,
+	}
+	if info == nil {
+		return entry
+	}
+	mtime := info.ModTime()
+	entry.MTime, entry.MTimeNS = int32(mtime.Unix()), int32(mtime.Nanosecond())
+	entry.Size = int32(info.Size())
+	setStat(&entry, info)
+	return entry
+}
+
+// Serializes the index with its trailing checksum. Entries are sorted by
+// name and stage; extensions are not written
+func Encode(idx *parser.Index) []byte {
+	entries := slices.Clone(idx.Entries)
+	slices.SortStableFunc(entries, func(a, b parser.Entry) int {
+		if c := strings.Compare(a.Name, b.Name); c != 0 {
+			return c
+		}
+		return a.Stage() - b.Stage()
+	})
+	version := uint32(2)
+	for _, entry := range entries {
+		if entry.Flags>>16 != 0 {
+			version = 3
+		}
+	}
+	buf := new(bytes.Buffer)
+	buf.WriteString("DIRC")
+	binary.Write(buf, binary.BigEndian, version)
+	binary.Write(buf, binary.BigEndian, uint32(len(entries)))
+	for _, entry := range entries {
+		start := buf.Len()
+		for _, field := range []int32{
+			entry.CTime, entry.CTimeNS, entry.MTime, entry.MTimeNS, entry.Dev, entry.Ino,
+			entry.Mode, entry.Uid, entry.Gid, entry.Size,
+		} {
+			binary.Write(buf, binary.BigEndian, field)
+		}
+		digest, _ := hex.DecodeString(entry.Digest)
+		buf.Write(digest)
+		flags := uint16(entry.Flags&0xf000) | uint16(min(len(entry.Name), nameMask))
+		extended := uint16(entry.Flags >> 16)
+		if extended != 0 {
+			flags |= extendedFlag
+		} else {
+			flags &^= extendedFlag
+		}
+		binary.Write(buf, binary.BigEndian, flags)
+		if extended != 0 {
+			binary.Write(buf, binary.BigEndian, extended)
+		}
+		buf.WriteString(entry.Name)
+		// 1-8 NULs padding to a multiple of 8 bytes
+		n := buf.Len() - start
+		buf.Write(make([]byte, 8-n%8))
+	}
+	h := repr.NewHasher()
+	h.Write(buf.Bytes())
+	return h.Sum(buf.Bytes())
+}
+
+// Atomically replaces the index file at path
+func WriteFile(path string, idx *parser.Index) error {
+	tmp := path + ".lock"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(Encode(idx))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Replaces the repository index
+func Write(idx *parser.Index) error {
+	return WriteFile(filepath.Join(constants.Git, constants.Index), idx)
+}
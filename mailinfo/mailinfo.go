@@ -0,0 +1,382 @@
+package mailinfo
+
+import (
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"strings"
+)
+
+// What a mail with a patch gives for a commit, as git mailinfo finds it
+type Info struct {
+	Name  string
+	Email string
+	// as the mail gives it
+	Date    string
+	Subject string
+	// the message after the subject
+	Message string
+	// the rest from where the patch starts
+	Patch string
+}
+
+type Options struct {
+	// keep the subject as is rather than removing what a mailing list or
+	// format-patch put before it
+	KeepSubject bool
+}
+
+// Headers taken from the top of the message over those of the mail
+var inBodyHeaders = []string{"From", "Subject", "Date"}
+
+type parser struct {
+	info Info
+	opts Options
+	// the value of a From header was taken for the address, so that more
+	// confusing ones are not
+	haveEmail bool
+}
+
+// Reads the author, date and subject from the headers of the mail and
+// splits its body into the message and the patch
+func Parse(mail []byte, opts Options) *Info {
+	p := &parser{opts: opts}
+	headers, body := readHeaders(mail)
+	encoding := ""
+	for _, h := range headers {
+		switch strings.ToLower(h.key) {
+		case "from":
+			p.handleFrom(decodeHeader(h.value))
+		case "subject":
+			p.info.Subject = decodeHeader(h.value)
+		case "date":
+			p.info.Date = h.value
+		case "content-transfer-encoding":
+			encoding = strings.ToLower(strings.TrimSpace(h.value))
+		}
+	}
+	body = decodeBody(body, encoding)
+	var msg strings.Builder
+	// in-body headers come first if at all, possibly after blank lines
+	headerStage, sawInBodyHeader := true, false
+	for body != "" {
+		line := body
+		if i := strings.IndexByte(body, '\n'); i != -1 {
+			line = body[:i+1]
+		}
+		if headerStage {
+			if strings.TrimRight(line, "\n") == "" {
+				if sawInBodyHeader {
+					headerStage = false
+				}
+				body = body[len(line):]
+				continue
+			}
+			if p.inBodyHeader(line) {
+				sawInBodyHeader = true
+				body = body[len(line):]
+				continue
+			}
+			headerStage = false
+		}
+		if isPatchBreak(line) {
+			break
+		}
+		msg.WriteString(line)
+		body = body[len(line):]
+	}
+	p.info.Message = msg.String()
+	p.info.Patch = body
+	if !opts.KeepSubject {
+		p.info.Subject = strings.Join(strings.Fields(cleanupSubject(p.info.Subject)), " ")
+	}
+	p.info.Subject = strings.TrimSpace(p.info.Subject)
+	return &p.info
+}
+
+type header struct {
+	key   string
+	value string
+}
+
+// Reads the headers up to the first blank line or line that is not one,
+// joining folded ones, and returns them with the body after them
+func readHeaders(mail []byte) ([]header, string) {
+	s := string(mail)
+	var headers []header
+	for s != "" {
+		line, rest, _ := strings.Cut(s, "\n")
+		line = strings.TrimRight(line, " \t\n\v\f\r")
+		if line == "" {
+			return headers, rest
+		}
+		if !isHeader(line) {
+			return headers, s
+		}
+		s = rest
+		for s != "" && (s[0] == ' ' || s[0] == '\t') {
+			continuation, rest, _ := strings.Cut(s, "\n")
+			line += " " + strings.TrimRight(continuation[1:], " \t\n\v\f\r")
+			s = rest
+		}
+		// the "From " lines of mboxes count as headers to skip
+		if strings.HasPrefix(line, "From ") || strings.HasPrefix(line, ">From ") {
+			continue
+		}
+		key, value, _ := strings.Cut(line, ":")
+		headers = append(headers, header{key: key, value: strings.TrimSpace(value)})
+	}
+	return headers, ""
+}
+
+// Whether the line is a "name: value" header, with the loosest names
+// RFC 2822 allows
+func isHeader(line string) bool {
+	if strings.HasPrefix(line, "From ") || strings.HasPrefix(line, ">From ") {
+		return true
+	}
+	for i := 0; i < len(line); i += 1 {
+		c := line[i]
+		if c == ':' {
+			return i > 0
+		}
+		if c < 33 || c > 126 {
+			return false
+		}
+	}
+	return false
+}
+
+// Decodes the encoded words of the value, leaving it as is if they are
+// malformed or in an unknown charset
+func decodeHeader(value string) string {
+	decoded, err := new(mime.WordDecoder).DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// The body decoded from the transfer encoding, as is if it is malformed
+func decodeBody(body string, encoding string) string {
+	var r io.Reader
+	switch encoding {
+	case "quoted-printable":
+		r = quotedprintable.NewReader(strings.NewReader(body))
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, strings.NewReader(strings.Join(strings.Fields(body), "")))
+	default:
+		return body
+	}
+	decoded, err := io.ReadAll(r)
+	if err != nil {
+		return body
+	}
+	return string(decoded)
+}
+
+// Takes a From, Subject or Date header at the top of the message
+func (p *parser) inBodyHeader(line string) bool {
+	for _, name := range inBodyHeaders {
+		if len(line) <= len(name) || !strings.EqualFold(line[:len(name)], name) || line[len(name)] != ':' {
+			continue
+		}
+		value := strings.TrimSpace(line[len(name)+1:])
+		switch name {
+		case "From":
+			p.haveEmail = false
+			p.handleFrom(decodeHeader(value))
+		case "Subject":
+			p.info.Subject = decodeHeader(value)
+		case "Date":
+			p.info.Date = value
+		}
+		return true
+	}
+	return false
+}
+
+// Whether the patch starts at the line: a diff header, a CVS "Index: "
+// line, "--- <file>" of a patch without headers, or "---" alone
+func isPatchBreak(line string) bool {
+	if strings.HasPrefix(line, "diff -") || strings.HasPrefix(line, "Index: ") {
+		return true
+	}
+	if len(line) < 4 || !strings.HasPrefix(line, "---") {
+		return false
+	}
+	if line[3] == ' ' && !isSpace(line[4]) {
+		return true
+	}
+	for i := 3; i < len(line); i += 1 {
+		if line[i] == '\n' {
+			return true
+		}
+		if !isSpace(line[i]) {
+			break
+		}
+	}
+	return false
+}
+
+func isSpace(c byte) bool {
+	return strings.IndexByte(" \t\n\v\f\r", c) != -1
+}
+
+// Takes the address from the value of a From header and the name from
+// around it, the address standing for the name if that is not sane
+func (p *parser) handleFrom(value string) {
+	f := unquotePairs(value)
+	at := strings.IndexByte(f, '@')
+	if at == -1 {
+		p.handleBogusFrom(value)
+		return
+	}
+	// the first address wins over later confusing ones
+	if p.haveEmail && strings.Contains(f[at+1:], "@") {
+		return
+	}
+	// the address is around the '@', possibly in angle brackets
+	start := at
+	for start > 0 {
+		c := f[start-1]
+		if isSpace(c) {
+			break
+		}
+		if c == '<' {
+			f = f[:start-1] + " " + f[start:]
+			break
+		}
+		start -= 1
+	}
+	end := start
+	for end < len(f) && !strings.ContainsRune(" \n\t\r\v\f>", rune(f[end])) {
+		end += 1
+	}
+	p.info.Email, p.haveEmail = f[start:end], true
+	if end < len(f) {
+		end += 1
+	}
+	name := strings.TrimSpace(strings.Join(strings.Fields(f[:start]+f[end:]), " "))
+	if strings.HasPrefix(name, "(") && strings.HasSuffix(name, ")") {
+		name = name[1 : len(name)-1]
+	}
+	p.info.Name = saneName(name, p.info.Email)
+}
+
+// "Name <address>" with no '@' in the address
+func (p *parser) handleBogusFrom(value string) {
+	bra := strings.IndexByte(value, '<')
+	if bra == -1 {
+		return
+	}
+	ket := strings.IndexByte(value[bra:], '>')
+	if ket == -1 {
+		return
+	}
+	p.info.Email = value[bra+1 : bra+ket]
+	name := strings.TrimSpace(value[:bra])
+	name = strings.TrimSuffix(strings.TrimPrefix(name, `"`), `"`)
+	p.info.Name = saneName(name, p.info.Email)
+}
+
+// The name unless empty, too long or looking like an address, in which
+// case the address
+func saneName(name string, email string) string {
+	if name == "" || len(name) > 60 || strings.ContainsAny(name, "@<>") {
+		return email
+	}
+	return name
+}
+
+// Removes the quotes of quoted strings and the backslashes escaping
+// characters in them and in comments
+func unquotePairs(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i += 1 {
+		switch s[i] {
+		case '"':
+			i = unquoteString(&b, s, i+1)
+		case '(':
+			i = unquoteComment(&b, s, i+1)
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// Unquotes the string up to its closing quote, returning where that is
+func unquoteString(b *strings.Builder, s string, i int) int {
+	for ; i < len(s); i += 1 {
+		switch s[i] {
+		case '\\':
+			i += 1
+			if i == len(s) {
+				return i
+			}
+		case '"':
+			return i
+		}
+		b.WriteByte(s[i])
+	}
+	return i
+}
+
+// Copies the comment up to its closing parenthesis without the escaping
+// backslashes, returning where the parenthesis is
+func unquoteComment(b *strings.Builder, s string, i int) int {
+	b.WriteByte('(')
+	for ; i < len(s); i += 1 {
+		switch s[i] {
+		case '\\':
+			i += 1
+			if i == len(s) {
+				return i
+			}
+		case '(':
+			i = unquoteComment(b, s, i+1)
+			continue
+		case ')':
+			b.WriteByte(')')
+			return i
+		}
+		b.WriteByte(s[i])
+	}
+	return i
+}
+
+// Removes what mailing lists and format-patch put before the subject:
+// "Re:", "[PATCH ...]" and the like, whitespace and colons
+func cleanupSubject(subject string) string {
+	for subject != "" {
+		switch subject[0] {
+		case 'r', 'R':
+			if len(subject) > 3 && (subject[1] == 'e' || subject[1] == 'E') && subject[2] == ':' {
+				subject = subject[3:]
+				continue
+			}
+		case ' ', '\t', ':':
+			subject = subject[1:]
+			continue
+		case '[':
+			if ket := strings.IndexByte(subject, ']'); ket != -1 {
+				subject = subject[ket+1:]
+				continue
+			}
+		}
+		break
+	}
+	return subject
+}
+
+// The author, subject and date as git mailinfo writes them out
+func (info *Info) String() string {
+	var b strings.Builder
+	b.WriteString("Author: " + info.Name + "\n")
+	b.WriteString("Email: " + info.Email + "\n")
+	b.WriteString("Subject: " + info.Subject + "\n")
+	b.WriteString("Date: " + info.Date + "\n\n")
+	return b.String()
+}
@@ -0,0 +1,72 @@
+package mailinfo_test
+
+import (
+	"testing"
+
+	"github.com/magnickolas/gitok/mailinfo"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		mail string
+		want mailinfo.Info
+	}{
+		{
+			mail: "From: A U Thor <a@b>\n" +
+				"Date: Fri, 2 Jan 2026 03:04:05 +0100\n" +
+				"Subject: [PATCH 4/5] =?UTF-8?q?Remove=20n;=20add=20bin=20(=C3=BCn=C3=AFcod?=\n" +
+				" =?UTF-8?q?e)?=\n" +
+				"\n" +
+				"Body.\n" +
+				"---\n" +
+				" n | 1 -\n",
+			want: mailinfo.Info{
+				Name: "A U Thor", Email: "a@b", Date: "Fri, 2 Jan 2026 03:04:05 +0100",
+				Subject: "Remove n; add bin (ünïcode)", Message: "Body.\n", Patch: "---\n n | 1 -\n",
+			},
+		},
+		{
+			mail: "From: x@y (Ed)\n" +
+				"Subject: Re: [RFC] long\n" +
+				"  subject\n" +
+				"\n" +
+				"\n" +
+				"From: \"Other, Person\" <o@p>\n" +
+				"\n" +
+				"diff --git a/f b/f\n",
+			want: mailinfo.Info{
+				Name: "Other, Person", Email: "o@p", Subject: "long subject", Patch: "diff --git a/f b/f\n",
+			},
+		},
+		{
+			mail: "From: A <a@x>\n" +
+				"Subject: short\n" +
+				"\n" +
+				"diff --git a/f b/f\n",
+			want: mailinfo.Info{
+				Name: "A", Email: "a@x", Subject: "short", Patch: "diff --git a/f b/f\n",
+			},
+		},
+	}
+	for _, test := range tests {
+		got := mailinfo.Parse([]byte(test.mail), mailinfo.Options{})
+		if *got != test.want {
+			t.Errorf("incorrect result for %#v: wanted %#v, got %#v", test.mail, test.want, *got)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	mbox := "From 1234567890abcdef Mon Sep 17 00:00:00 2001\nSubject: a\n\nFrom me\n" +
+		"From 1234567890abcdef Mon Sep 17 00:00:00 2001\nSubject: b\n"
+	got := mailinfo.Split([]byte(mbox))
+	want := []string{"Subject: a\n\nFrom me\n", "Subject: b\n"}
+	if len(got) != len(want) {
+		t.Fatalf("incorrect result for %#v: wanted %#v mails, got %#v", mbox, len(want), len(got))
+	}
+	for i := range want {
+		if string(got[i]) != want[i] {
+			t.Errorf("incorrect result for %#v: wanted %#v, got %#v", mbox, want[i], string(got[i]))
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package mailinfo
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// Splits an mbox into its mails, without their "From " lines. Input not
+// starting with one is taken for a single bare mail
+func Split(content []byte) [][]byte {
+	// leading blank lines are not part of any mail
+	content = bytes.TrimLeft(content, " \t\n\v\f\r")
+	var mails [][]byte
+	var mail []byte
+	bare := !isFromLine(firstLine(content))
+	for len(content) > 0 {
+		line := firstLine(content)
+		content = content[len(line):]
+		if !bare && isFromLine(line) {
+			if mail != nil {
+				mails = append(mails, mail)
+			}
+			mail = []byte{}
+			continue
+		}
+		// lines ending in CRLF are taken as ending in LF
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			line = append(line[:len(line)-2:len(line)-2], '\n')
+		}
+		mail = append(mail, line...)
+	}
+	if mail != nil {
+		mails = append(mails, mail)
+	}
+	return mails
+}
+
+func firstLine(content []byte) []byte {
+	if i := bytes.IndexByte(content, '\n'); i != -1 {
+		return content[:i+1]
+	}
+	return content
+}
+
+// Whether the line starts a mail of an mbox: "From " and something that
+// looks like a date, whose time has a colon and whose year follows it
+func isFromLine(line []byte) bool {
+	if len(line) < 20 || !bytes.HasPrefix(line, []byte("From ")) {
+		return false
+	}
+	colon := bytes.LastIndexByte(line[5:len(line)-2], ':')
+	if colon == -1 {
+		return false
+	}
+	colon += 5
+	if colon < 4 || colon+3 > len(line) {
+		return false
+	}
+	for _, i := range []int{colon - 4, colon - 2, colon - 1, colon + 1, colon + 2} {
+		if line[i] < '0' || line[i] > '9' {
+			return false
+		}
+	}
+	year := bytes.TrimLeft(line[colon+3:], " \t")
+	end := 0
+	for end < len(year) && '0' <= year[end] && year[end] <= '9' {
+		end += 1
+	}
+	n, _ := strconv.Atoi(string(year[:end]))
+	return n > 90
+}
@@ -0,0 +1,484 @@
+package merge
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/magnickolas/gitok/diff"
+)
+
+var (
+	ErrorBinary             = errors.New("cannot merge binary files")
+	ErrorUnknownStyle       = errors.New("unknown conflict style")
+	formatErrorUnknownStyle = func(name string) error {
+		return fmt.Errorf("%w '%v'", ErrorUnknownStyle, name)
+	}
+)
+
+// Length of conflict markers unless given
+const DefaultMarkerSize = 7
+
+// How conflicts are shown
+type Style int
+
+const (
+	// both sides between markers
+	StyleMerge Style = iota
+	// the base too, between the sides
+	StyleDiff3
+	// the base too, but with the lines both sides begin and end with
+	// left out of the conflict
+	StyleZealousDiff3
+)
+
+// Parses the conflict style as merge.conflictStyle gives it
+func ParseStyle(name string) (Style, error) {
+	switch name {
+	case "merge":
+		return StyleMerge, nil
+	case "diff3":
+		return StyleDiff3, nil
+	case "zdiff3":
+		return StyleZealousDiff3, nil
+	}
+	return 0, formatErrorUnknownStyle(name)
+}
+
+// Side conflicts are resolved to instead of being shown
+type Favor int
+
+const (
+	FavorNone Favor = iota
+	FavorOurs
+	FavorTheirs
+	// the lines of both sides, ours first
+	FavorUnion
+)
+
+// How hard conflicts are narrowed down
+type Level int
+
+const (
+	// to the lines where the sides differ, joining those with at most 3
+	// lines between them
+	LevelZealous Level = iota
+	// and also joining those with only lines without letters and digits
+	// between them
+	LevelZealousAlnum
+)
+
+type Options struct {
+	// labels after the markers of our side, the base and their side, none
+	// if empty
+	Ours   string
+	Base   string
+	Theirs string
+	// length of the conflict markers, DefaultMarkerSize if 0
+	MarkerSize int
+	Style      Style
+	Favor      Favor
+	Level      Level
+}
+
+// A region of the merge: how each side changed count0 lines of the base
+// from line0 into count1 lines of ours from line1 and count2 lines of
+// theirs from line2
+type region struct {
+	mode          mode
+	line0, count0 int
+	line1, count1 int
+	line2, count2 int
+}
+
+type mode int
+
+const (
+	modeConflict mode = iota
+	// only our side changed the region
+	modeOurs
+	// only their side did
+	modeTheirs
+	// the lines of both sides, for conflicts resolved to their union
+	modeUnion
+	// both sides made the same change
+	modeBoth
+)
+
+// Changed range of an edit script: count1 lines of a from line1 replaced
+// by count2 lines of b from line2
+type hunk struct {
+	line1, count1 int
+	line2, count2 int
+}
+
+// Merges the changes from base to ours and from base to theirs line by
+// line, as git's default merge driver does, and returns the result with
+// the overlapping changes that differ between conflict markers, unless
+// resolved in favor of a side, and the number of conflicts left. Binary
+// content is not merged
+func Merge(base []byte, ours []byte, theirs []byte, opts Options) ([]byte, int, error) {
+	if diff.IsBinary(base) || diff.IsBinary(ours) || diff.IsBinary(theirs) {
+		return nil, 0, ErrorBinary
+	}
+	lines0, lines1, lines2 := diff.SplitLines(base), diff.SplitLines(ours), diff.SplitLines(theirs)
+	hunks1, hunks2 := hunks(lines0, lines1), hunks(lines0, lines2)
+	if len(hunks1) == 0 {
+		return theirs, 0, nil
+	}
+	if len(hunks2) == 0 {
+		return ours, 0, nil
+	}
+	m := merger{base: lines0, ours: lines1, theirs: lines2, opts: opts}
+	if m.opts.MarkerSize <= 0 {
+		m.opts.MarkerSize = DefaultMarkerSize
+	}
+	m.collect(hunks1, hunks2)
+	switch m.opts.Style {
+	case StyleMerge:
+		m.refineConflicts()
+		m.simplifyNonConflicts()
+	case StyleZealousDiff3:
+		m.trimConflicts()
+	}
+	conflicts := 0
+	for i := range m.regions {
+		r := &m.regions[i]
+		if r.mode != modeConflict {
+			continue
+		}
+		switch m.opts.Favor {
+		case FavorOurs:
+			r.mode = modeOurs
+		case FavorTheirs:
+			r.mode = modeTheirs
+		case FavorUnion:
+			r.mode = modeUnion
+		default:
+			conflicts += 1
+		}
+	}
+	return m.fill(), conflicts, nil
+}
+
+// Changed ranges of the diff from lines a to lines b
+func hunks(a []string, b []string) []hunk {
+	var res []hunk
+	edits := diff.Lines(a, b, diff.Options{})
+	for i := 0; i < len(edits); {
+		if edits[i].Op == diff.Equal {
+			i += 1
+			continue
+		}
+		h := hunk{line1: edits[i].Old, line2: edits[i].New}
+		for ; i < len(edits) && edits[i].Op != diff.Equal; i += 1 {
+			if edits[i].Op == diff.Delete {
+				h.count1 += 1
+			} else {
+				h.count2 += 1
+			}
+		}
+		res = append(res, h)
+	}
+	return res
+}
+
+type merger struct {
+	base, ours, theirs []string
+	opts               Options
+	regions            []region
+}
+
+// Adds a region, joining it into the last one if they overlap on either
+// side; overlapping regions of different modes conflict
+func (m *merger) append(r region) {
+	if n := len(m.regions); n > 0 {
+		last := &m.regions[n-1]
+		if r.line1 <= last.line1+last.count1 || r.line2 <= last.line2+last.count2 {
+			if r.mode != last.mode {
+				last.mode = modeConflict
+			}
+			last.count0 = r.line0 + r.count0 - last.line0
+			last.count1 = r.line1 + r.count1 - last.line1
+			last.count2 = r.line2 + r.count2 - last.line2
+			return
+		}
+	}
+	m.regions = append(m.regions, r)
+}
+
+// Walks the changes of both sides along the base, marking those only one
+// side made and those both made the same way, and the others as conflicts
+func (m *merger) collect(hunks1 []hunk, hunks2 []hunk) {
+	for len(hunks1) > 0 && len(hunks2) > 0 {
+		h1, h2 := hunks1[0], hunks2[0]
+		if h1.line1+h1.count1 < h2.line1 {
+			m.append(region{
+				mode:  modeOurs,
+				line0: h1.line1, count0: h1.count1,
+				line1: h1.line2, count1: h1.count2,
+				line2: h2.line2 - h2.line1 + h1.line1, count2: h1.count1,
+			})
+			hunks1 = hunks1[1:]
+			continue
+		}
+		if h2.line1+h2.count1 < h1.line1 {
+			m.append(region{
+				mode:  modeTheirs,
+				line0: h2.line1, count0: h2.count1,
+				line1: h1.line2 - h1.line1 + h2.line1, count1: h2.count1,
+				line2: h2.line2, count2: h2.count2,
+			})
+			hunks2 = hunks2[1:]
+			continue
+		}
+		if h1.line1 != h2.line1 || h1.count1 != h2.count1 || h1.count2 != h2.count2 ||
+			!equalLines(m.ours[h1.line2:h1.line2+h1.count2], m.theirs[h2.line2:h2.line2+h2.count2]) {
+			off := h1.line1 - h2.line1
+			ffo := off + h1.count1 - h2.count1
+			line0, line1, line2 := h1.line1, h1.line2, h2.line2
+			if off > 0 {
+				line0 -= off
+				line1 -= off
+			} else {
+				line2 += off
+			}
+			count0 := h1.line1 + h1.count1 - line0
+			count1 := h1.line2 + h1.count2 - line1
+			count2 := h2.line2 + h2.count2 - line2
+			if ffo < 0 {
+				count0 -= ffo
+				count1 -= ffo
+			} else {
+				count2 += ffo
+			}
+			m.append(region{
+				mode:  modeConflict,
+				line0: line0, count0: count0,
+				line1: line1, count1: count1,
+				line2: line2, count2: count2,
+			})
+		}
+		end1, end2 := h1.line1+h1.count1, h2.line1+h2.count1
+		if end1 >= end2 {
+			hunks2 = hunks2[1:]
+		}
+		if end2 >= end1 {
+			hunks1 = hunks1[1:]
+		}
+	}
+	for _, h1 := range hunks1 {
+		m.append(region{
+			mode:  modeOurs,
+			line0: h1.line1, count0: h1.count1,
+			line1: h1.line2, count1: h1.count2,
+			line2: h1.line1 + len(m.theirs) - len(m.base), count2: h1.count1,
+		})
+	}
+	for _, h2 := range hunks2 {
+		m.append(region{
+			mode:  modeTheirs,
+			line0: h2.line1, count0: h2.count1,
+			line1: h2.line1 + len(m.ours) - len(m.base), count1: h2.count1,
+			line2: h2.line2, count2: h2.count2,
+		})
+	}
+}
+
+func equalLines(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Narrows conflicts down to the lines where the two sides differ by
+// diffing them with each other, splitting them where they agree
+func (m *merger) refineConflicts() {
+	var res []region
+	for _, r := range m.regions {
+		if r.mode != modeConflict || r.count1 == 0 || r.count2 == 0 {
+			res = append(res, r)
+			continue
+		}
+		hs := hunks(m.ours[r.line1:r.line1+r.count1], m.theirs[r.line2:r.line2+r.count2])
+		if len(hs) == 0 {
+			r.mode = modeBoth
+			res = append(res, r)
+			continue
+		}
+		for _, h := range hs {
+			res = append(res, region{
+				mode:  modeConflict,
+				line1: r.line1 + h.line1, count1: h.count1,
+				line2: r.line2 + h.line2, count2: h.count2,
+			})
+		}
+		// the base of the first part stands for the whole
+		res[len(res)-len(hs)].line0, res[len(res)-len(hs)].count0 = r.line0, r.count0
+	}
+	m.regions = res
+}
+
+// Leaves the lines both sides of conflicts begin and end with out of them,
+// the base being kept whole
+func (m *merger) trimConflicts() {
+	for i := range m.regions {
+		r := &m.regions[i]
+		if r.mode != modeConflict {
+			continue
+		}
+		for r.count1 > 0 && r.count2 > 0 && m.ours[r.line1] == m.theirs[r.line2] {
+			r.line1, r.count1 = r.line1+1, r.count1-1
+			r.line2, r.count2 = r.line2+1, r.count2-1
+		}
+		for r.count1 > 0 && r.count2 > 0 && m.ours[r.line1+r.count1-1] == m.theirs[r.line2+r.count2-1] {
+			r.count1 -= 1
+			r.count2 -= 1
+		}
+	}
+}
+
+// Joins conflicts with at most 3 lines between them, which take no more
+// room as part of the conflict, or at the alnum level with only lines
+// without letters and digits between them
+func (m *merger) simplifyNonConflicts() {
+	if len(m.regions) == 0 {
+		return
+	}
+	res := m.regions[:1]
+	for _, r := range m.regions[1:] {
+		last := &res[len(res)-1]
+		begin := last.line1 + last.count1
+		if last.mode != modeConflict || r.mode != modeConflict ||
+			r.line1-begin > 3 && (m.opts.Level < LevelZealousAlnum || containsAlnum(m.ours[begin:r.line1])) {
+			res = append(res, r)
+			continue
+		}
+		last.count1 = r.line1 + r.count1 - last.line1
+		last.count2 = r.line2 + r.count2 - last.line2
+	}
+	m.regions = res
+}
+
+func containsAlnum(lines []string) bool {
+	for _, line := range lines {
+		for i := 0; i < len(line); i += 1 {
+			c := line[i]
+			if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Result of the merge: our side with the regions changed by their side
+// taken from it, both sides of those resolved to their union and conflicts
+// between markers
+func (m *merger) fill() []byte {
+	var b strings.Builder
+	i := 0
+	for _, r := range m.regions {
+		switch r.mode {
+		case modeConflict:
+			writeLines(&b, m.ours[i:r.line1], "", false)
+			m.fillConflict(&b, r)
+		case modeOurs, modeTheirs, modeUnion:
+			writeLines(&b, m.ours[i:r.line1], "", false)
+			switch r.mode {
+			case modeOurs:
+				writeLines(&b, m.ours[r.line1:r.line1+r.count1], "", false)
+			case modeTheirs:
+				writeLines(&b, m.theirs[r.line2:r.line2+r.count2], "", false)
+			default:
+				eol := "\n"
+				if m.needsCR(r) {
+					eol = "\r\n"
+				}
+				writeLines(&b, m.ours[r.line1:r.line1+r.count1], eol, true)
+				writeLines(&b, m.theirs[r.line2:r.line2+r.count2], "", false)
+			}
+		default:
+			continue
+		}
+		i = r.line1 + r.count1
+	}
+	writeLines(&b, m.ours[i:], "", false)
+	return []byte(b.String())
+}
+
+func (m *merger) fillConflict(b *strings.Builder, r region) {
+	eol := "\n"
+	if m.needsCR(r) {
+		eol = "\r\n"
+	}
+	marker := func(c string, label string) {
+		b.WriteString(strings.Repeat(c, m.opts.MarkerSize))
+		if label != "" {
+			b.WriteString(" " + label)
+		}
+		b.WriteString(eol)
+	}
+	marker("<", m.opts.Ours)
+	writeLines(b, m.ours[r.line1:r.line1+r.count1], eol, true)
+	if m.opts.Style != StyleMerge {
+		marker("|", m.opts.Base)
+		writeLines(b, m.base[r.line0:r.line0+r.count0], eol, true)
+	}
+	marker("=", "")
+	writeLines(b, m.theirs[r.line2:r.line2+r.count2], eol, true)
+	marker(">", m.opts.Theirs)
+}
+
+// Writes the lines, ending the last with eol if it has no line break
+// and one is asked for
+func writeLines(b *strings.Builder, lines []string, eol string, addEOL bool) {
+	for _, line := range lines {
+		b.WriteString(line)
+	}
+	if addEOL && len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		b.WriteString(eol)
+	}
+}
+
+// Whether markers of the conflict end in CRLF: unless the line before it
+// on either side or the first line of the base ends in LF only, and the
+// lines tell at all
+func (m *merger) needsCR(r region) bool {
+	needsCR := isEOLCRLF(m.ours, max(r.line1-1, 0))
+	if needsCR != 0 {
+		needsCR = isEOLCRLF(m.theirs, max(r.line2-1, 0))
+	}
+	if needsCR != 0 {
+		needsCR = isEOLCRLF(m.base, 0)
+	}
+	return needsCR == 1
+}
+
+// 1 if line i ends in CRLF (the line before for a last line without a
+// line break), 0 if in LF only and -1 if it cannot be told
+func isEOLCRLF(lines []string, i int) int {
+	crlf := func(line string) int {
+		if strings.HasSuffix(line, "\r\n") {
+			return 1
+		}
+		return 0
+	}
+	switch {
+	case i < len(lines)-1:
+		return crlf(lines[i])
+	case len(lines) == 0:
+		return -1
+	case strings.HasSuffix(lines[i], "\n"):
+		return crlf(lines[i])
+	case i == 0:
+		return -1
+	}
+	return crlf(lines[i-1])
+}
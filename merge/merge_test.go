@@ -0,0 +1,69 @@
+package merge_test
+
+import (
+	"testing"
+
+	"github.com/magnickolas/gitok/merge"
+)
+
+func TestMerge(t *testing.T) {
+	numbers := "1\n2\n3\n4\n5\n6\n7\n8\n9\n"
+	base, ours, theirs := "1\n2\n3\n4\n5\n", "1\nx\ny\nz\n5\n", "1\nx\nq\nz\n5\n"
+	labels := merge.Options{Ours: "o", Base: "b", Theirs: "t"}
+	with := func(f func(opts *merge.Options)) merge.Options {
+		opts := labels
+		f(&opts)
+		return opts
+	}
+	tests := []struct {
+		base, ours, theirs string
+		opts               merge.Options
+		want               string
+		conflicts          int
+	}{
+		{
+			base: numbers, ours: "1\nA\n3\n4\n5\n6\n7\n8\n9\n", theirs: "1\n2\n3\n4\n5\n6\n7\nZ\n9\n",
+			opts: labels, want: "1\nA\n3\n4\n5\n6\n7\nZ\n9\n",
+		},
+		{
+			base: base, ours: ours, theirs: theirs, opts: labels,
+			want: "1\nx\n<<<<<<< o\ny\n=======\nq\n>>>>>>> t\nz\n5\n", conflicts: 1,
+		},
+		{
+			base: base, ours: ours, theirs: theirs,
+			opts: with(func(opts *merge.Options) { opts.Style = merge.StyleDiff3 }),
+			want: "1\n<<<<<<< o\nx\ny\nz\n||||||| b\n2\n3\n4\n=======\nx\nq\nz\n>>>>>>> t\n5\n", conflicts: 1,
+		},
+		{
+			base: base, ours: ours, theirs: theirs,
+			opts: with(func(opts *merge.Options) { opts.Style = merge.StyleZealousDiff3 }),
+			want: "1\nx\n<<<<<<< o\ny\n||||||| b\n2\n3\n4\n=======\nq\n>>>>>>> t\nz\n5\n", conflicts: 1,
+		},
+		{
+			base: base, ours: ours, theirs: theirs,
+			opts: with(func(opts *merge.Options) { opts.Favor = merge.FavorUnion }),
+			want: "1\nx\ny\nq\nz\n5\n",
+		},
+		{
+			base: base, ours: ours, theirs: theirs,
+			opts: with(func(opts *merge.Options) { opts.Favor = merge.FavorTheirs }),
+			want: "1\nx\nq\nz\n5\n",
+		},
+		{
+			base: base, ours: ours, theirs: theirs,
+			opts: with(func(opts *merge.Options) { opts.MarkerSize = 3 }),
+			want: "1\nx\n<<< o\ny\n===\nq\n>>> t\nz\n5\n", conflicts: 1,
+		},
+	}
+	for _, test := range tests {
+		got, conflicts, err := merge.Merge([]byte(test.base), []byte(test.ours), []byte(test.theirs), test.opts)
+		if err != nil {
+			t.Errorf("failed to merge %#v and %#v: %v", test.ours, test.theirs, err)
+			continue
+		}
+		if string(got) != test.want || conflicts != test.conflicts {
+			t.Errorf("incorrect result for %#v and %#v with %#v: wanted %#v (%v), got %#v (%v)",
+				test.ours, test.theirs, test.opts, test.want, test.conflicts, string(got), conflicts)
+		}
+	}
+}
@@ -0,0 +1,667 @@
+package merge
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/magnickolas/gitok/attr"
+	"github.com/magnickolas/gitok/diff"
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/repr"
+	"github.com/magnickolas/gitok/revwalk"
+)
+
+var ErrorUnrelatedHistories = errors.New("refusing to merge unrelated histories")
+
+// Kinds of the messages of tree merges, as git merge-tree -z names them
+const (
+	KindAutoMerging   = "Auto-merging"
+	KindContents      = "CONFLICT (contents)"
+	KindBinary        = "CONFLICT (binary)"
+	KindModifyDelete  = "CONFLICT (modify/delete)"
+	KindRenameDelete  = "CONFLICT (rename/delete)"
+	KindRenameRename  = "CONFLICT (rename/rename)"
+	KindFileDirectory = "CONFLICT (file/directory)"
+)
+
+// Attribute giving the length of the conflict markers of a path
+const markerSizeAttr = "conflict-marker-size"
+
+// Labels of the sides of merges of merge bases
+const (
+	virtualOurs   = "Temporary merge branch 1"
+	virtualTheirs = "Temporary merge branch 2"
+	virtualBase   = "merged common ancestors"
+)
+
+type TreeOptions struct {
+	// names of our and their side in messages and conflict markers, and
+	// of the base in diff3-style conflicts
+	Ours   string
+	Theirs string
+	Base   string
+	Style  Style
+	// how renames are found on either side; none are with DetectNone
+	Renames diff.Options
+	// give the conflict-marker-size of paths if not nil
+	Attrs *attr.Matcher
+	// merge commits without a common ancestor on the empty tree
+	AllowUnrelatedHistories bool
+	// depth of the merges of merge bases this is one of, whose conflict
+	// markers are longer not to be mistaken for those of the merge
+	depth int
+}
+
+// A stage of a path left in conflict: 1 for the base, 2 for our side and
+// 3 for theirs
+type Stage struct {
+	Path   string
+	Stage  int
+	Mode   repr.ObjectModeType
+	Digest string
+}
+
+// What was done about some paths or why they conflict
+type Message struct {
+	// the path the message is about, then the others it tells of
+	Paths []string
+	Kind  string
+	Text  string
+}
+
+type TreeResult struct {
+	Tree string
+	// stages of the paths left in conflict, by path and stage. The tree
+	// has these paths with conflict markers or one side
+	Conflicts []Stage
+	// by the path they are about
+	Messages []Message
+}
+
+func (r *TreeResult) Clean() bool {
+	return len(r.Conflicts) == 0
+}
+
+// Sides of a merge, indexing the files of entries
+const (
+	sideBase = iota
+	sideOurs
+	sideTheirs
+)
+
+// A path of the result with the files of the base and the sides merged
+// into it, which renames may have brought from other paths
+type entry struct {
+	path  string
+	files [3]diff.File
+	// the path was renamed on a side from the base and deleted on the other
+	renameDeleted bool
+}
+
+// A file of the result and the side it is from, that of ours if both
+type result struct {
+	file diff.File
+	side int
+}
+
+type treeMerger struct {
+	db        *fs.ObjectDB
+	opts      TreeOptions
+	entries   map[string]*entry
+	results   map[string]result
+	conflicts []Stage
+	messages  []Message
+}
+
+// Merges the trees of the commits on the tree of their merge base, a merge
+// of their merge bases if they have several
+func MergeCommits(db *fs.ObjectDB, ours string, theirs string, opts TreeOptions) (*TreeResult, error) {
+	bases, err := revwalk.MergeBases(db, ours, theirs)
+	if err != nil {
+		return nil, err
+	}
+	if len(bases) == 0 && !opts.AllowUnrelatedHistories {
+		return nil, ErrorUnrelatedHistories
+	}
+	if opts.Base == "" {
+		opts.Base = baseLabel(db, bases)
+	}
+	base, err := mergeBases(db, bases, opts)
+	if err != nil {
+		return nil, err
+	}
+	trees := make([]string, 2)
+	for i, digest := range []string{ours, theirs} {
+		commit, err := db.ReadCommit(digest)
+		if err != nil {
+			return nil, err
+		}
+		trees[i] = commit.TreeDigest()
+	}
+	return MergeTrees(db, base, trees[0], trees[1], opts)
+}
+
+// Tree of the merge bases: that of the only one, or the merge of all of
+// them one after another, oldest first, conflicts included
+func mergeBases(db *fs.ObjectDB, bases []string, opts TreeOptions) (string, error) {
+	if len(bases) == 0 {
+		return "", nil
+	}
+	merged := bases[len(bases)-1]
+	commit, err := db.ReadCommit(merged)
+	if err != nil {
+		return "", err
+	}
+	tree := commit.TreeDigest()
+	for i := len(bases) - 2; i >= 0; i -= 1 {
+		innerBases, err := revwalk.MergeBases(db, merged, bases[i])
+		if err != nil {
+			return "", err
+		}
+		inner := TreeOptions{
+			Ours:                    virtualOurs,
+			Theirs:                  virtualTheirs,
+			Base:                    baseLabel(db, innerBases),
+			Style:                   opts.Style,
+			Renames:                 opts.Renames,
+			Attrs:                   opts.Attrs,
+			AllowUnrelatedHistories: true,
+			depth:                   opts.depth + 1,
+		}
+		base, err := mergeBases(db, innerBases, inner)
+		if err != nil {
+			return "", err
+		}
+		next, err := db.ReadCommit(bases[i])
+		if err != nil {
+			return "", err
+		}
+		res, err := MergeTrees(db, base, tree, next.TreeDigest(), inner)
+		if err != nil {
+			return "", err
+		}
+		tree = res.Tree
+	}
+	return tree, nil
+}
+
+// Name of the merge base of conflicts: the abbreviated merge base if
+// there is only one
+func baseLabel(db *fs.ObjectDB, bases []string) string {
+	switch len(bases) {
+	case 0:
+		return "empty tree"
+	case 1:
+		return db.Abbrev(bases[0], 7)
+	}
+	return virtualBase
+}
+
+// Merges the changes from the base tree to our tree and to theirs, taking
+// the renames either side made into account, and writes the result tree
+// with the conflicts that are left in it. The empty digest stands for the
+// empty tree
+func MergeTrees(db *fs.ObjectDB, base string, ours string, theirs string, opts TreeOptions) (*TreeResult, error) {
+	m := &treeMerger{
+		db:      db,
+		opts:    opts,
+		entries: make(map[string]*entry),
+		results: make(map[string]result),
+	}
+	trees := [3]string{base, ours, theirs}
+	for side, tree := range trees {
+		files, err := diff.TreeFiles(db, tree, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			m.entry(file.Path).files[side] = file
+		}
+	}
+	var renames [3]map[string]string
+	for _, side := range []int{sideOurs, sideTheirs} {
+		var err error
+		if renames[side], err = m.findRenames(base, trees[side]); err != nil {
+			return nil, err
+		}
+	}
+	var renamed []string
+	for _, side := range []int{sideOurs, sideTheirs} {
+		for old := range renames[side] {
+			if !slices.Contains(renamed, old) {
+				renamed = append(renamed, old)
+			}
+		}
+	}
+	slices.Sort(renamed)
+	for _, old := range renamed {
+		var err error
+		new1, ok1 := renames[sideOurs][old]
+		new2, ok2 := renames[sideTheirs][old]
+		switch {
+		case ok1 && ok2 && new1 == new2:
+			m.move(old, new1, sideBase)
+		case ok1 && ok2:
+			err = m.renameRename(old, new1, new2)
+		case ok1:
+			err = m.rename(old, new1, sideOurs)
+		default:
+			err = m.rename(old, new2, sideTheirs)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	paths := make([]string, 0, len(m.entries))
+	for p := range m.entries {
+		paths = append(paths, p)
+	}
+	slices.Sort(paths)
+	for _, p := range paths {
+		if err := m.mergeEntry(m.entries[p]); err != nil {
+			return nil, err
+		}
+	}
+	m.resolveDirectories()
+	tree, err := m.writeTree()
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(m.conflicts, func(i, j int) bool {
+		a, b := m.conflicts[i], m.conflicts[j]
+		return a.Path < b.Path || a.Path == b.Path && a.Stage < b.Stage
+	})
+	sort.SliceStable(m.messages, func(i, j int) bool {
+		return m.messages[i].Paths[0] < m.messages[j].Paths[0]
+	})
+	return &TreeResult{Tree: tree, Conflicts: m.conflicts, Messages: m.messages}, nil
+}
+
+func (m *treeMerger) entry(p string) *entry {
+	e, ok := m.entries[p]
+	if !ok {
+		e = &entry{path: p}
+		m.entries[p] = e
+	}
+	return e
+}
+
+// Files of the base renamed on the side, new path by old
+func (m *treeMerger) findRenames(base string, tree string) (map[string]string, error) {
+	res := make(map[string]string)
+	if m.opts.Renames.Detect == diff.DetectNone {
+		return res, nil
+	}
+	changes, err := diff.Trees(m.db, base, tree, nil)
+	if err != nil {
+		return nil, err
+	}
+	opts := m.opts.Renames
+	opts.Detect = diff.DetectRenames
+	changes, _, err = diff.FindRenames(m.db, changes, nil, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, change := range changes {
+		if change.Status == diff.Renamed {
+			res[change.Old.Path] = change.New.Path
+		}
+	}
+	return res, nil
+}
+
+// Moves the files of the sides from the old path to the new one
+func (m *treeMerger) move(old string, new string, sides ...int) {
+	from, to := m.entry(old), m.entry(new)
+	for _, side := range sides {
+		to.files[side], from.files[side] = from.files[side], diff.File{}
+	}
+}
+
+// Follows the rename of a side, merging the other side's file at the old
+// path into the new one. If the other side has a file at the new path too,
+// renamed or added there, the renamed file is merged with the other side's
+// changes at the old path first and the two are then merged as added
+func (m *treeMerger) rename(old string, new string, side int) error {
+	other := sideOurs + sideTheirs - side
+	from, to := m.entry(old), m.entry(new)
+	if to.files[other].Exists() {
+		renamed := to.files[side]
+		if changed := from.files[other]; changed.Exists() {
+			var files [3]diff.File
+			files[sideBase], files[side], files[other] = from.files[sideBase], renamed, changed
+			var err error
+			if renamed, _, err = m.mergeFiles(old, files[sideBase], files[sideOurs], files[sideTheirs]); err != nil {
+				return err
+			}
+		}
+		renamed.Path = new
+		to.files[side] = renamed
+		from.files[sideBase], from.files[other] = diff.File{}, diff.File{}
+		return nil
+	}
+	if !from.files[other].Exists() {
+		to.renameDeleted = true
+		m.move(old, new, sideBase)
+		return nil
+	}
+	m.move(old, new, sideBase, other)
+	return nil
+}
+
+// Both sides renamed the file differently: the paths of both get the merge
+// of the two, conflicting, and where the other side has a file of its own
+// that is merged with it as added
+func (m *treeMerger) renameRename(old string, new1 string, new2 string) error {
+	b := m.entry(old).files[sideBase]
+	o, t := m.entry(new1).files[sideOurs], m.entry(new2).files[sideTheirs]
+	m.entry(old).files[sideBase] = diff.File{}
+	m.entry(new1).files[sideOurs] = diff.File{}
+	m.entry(new2).files[sideTheirs] = diff.File{}
+	m.message(KindRenameRename, fmt.Sprintf("CONFLICT (rename/rename): %v renamed to %v in %v and to %v in %v.",
+		old, new1, m.opts.Ours, new2, m.opts.Theirs), old, new1, new2)
+	merged, _, err := m.mergeFiles(new1, b, o, t)
+	if err != nil {
+		return err
+	}
+	m.conflicts = append(m.conflicts, stageOf(old, sideBase, b))
+	for _, dest := range []struct {
+		path string
+		side int
+	}{{new1, sideOurs}, {new2, sideTheirs}} {
+		file := merged
+		file.Path = dest.path
+		e := m.entry(dest.path)
+		if e.files[sideOurs+sideTheirs-dest.side].Exists() {
+			e.files[dest.side] = file
+			continue
+		}
+		m.take(dest.path, file, dest.side)
+		m.conflicts = append(m.conflicts, stageOf(dest.path, dest.side, file))
+	}
+	return nil
+}
+
+func (m *treeMerger) mergeEntry(e *entry) error {
+	b, o, t := e.files[sideBase], e.files[sideOurs], e.files[sideTheirs]
+	switch {
+	case e.renameDeleted:
+		m.renameDelete(e)
+	case sameFile(o, t):
+		m.take(e.path, o, sideOurs)
+	case sameFile(o, b):
+		m.take(e.path, t, sideTheirs)
+	case sameFile(t, b):
+		m.take(e.path, o, sideOurs)
+	case !o.Exists():
+		m.modifyDelete(e, sideTheirs)
+	case !t.Exists():
+		m.modifyDelete(e, sideOurs)
+	default:
+		return m.mergeContents(e)
+	}
+	return nil
+}
+
+func (m *treeMerger) take(p string, file diff.File, side int) {
+	if file.Exists() {
+		file.Path = p
+		m.results[p] = result{file: file, side: side}
+	}
+}
+
+// The side kept the file it changed, which the other deleted
+func (m *treeMerger) modifyDelete(e *entry, side int) {
+	kept, deleted := m.label(side), m.label(sideOurs+sideTheirs-side)
+	m.message(KindModifyDelete, fmt.Sprintf("CONFLICT (modify/delete): %v deleted in %v and modified in %v.  Version %v of %v left in tree.",
+		e.path, deleted, kept, kept, e.path), e.path)
+	m.take(e.path, e.files[side], side)
+	m.addStages(e)
+}
+
+// The side renamed the file the other deleted, keeping it, changed or not,
+// under its new name
+func (m *treeMerger) renameDelete(e *entry) {
+	side := sideOurs
+	if !e.files[side].Exists() {
+		side = sideTheirs
+	}
+	kept, deleted := m.label(side), m.label(sideOurs+sideTheirs-side)
+	b, file := e.files[sideBase], e.files[side]
+	m.message(KindRenameDelete, fmt.Sprintf("CONFLICT (rename/delete): %v renamed to %v in %v, but deleted in %v.",
+		b.Path, e.path, kept, deleted), e.path, b.Path)
+	if b.Digest != file.Digest {
+		m.message(KindModifyDelete, fmt.Sprintf("CONFLICT (modify/delete): %v deleted in %v and modified in %v.  Version %v of %v left in tree.",
+			e.path, deleted, kept, kept, e.path), e.path)
+	}
+	m.take(e.path, file, side)
+	m.addStages(e)
+}
+
+// Both sides changed the file, or added it differently: merges the lines
+// of their contents and the modes
+func (m *treeMerger) mergeContents(e *entry) error {
+	b, o, t := e.files[sideBase], e.files[sideOurs], e.files[sideTheirs]
+	merged, clean, err := m.mergeFiles(e.path, b, o, t)
+	if err != nil {
+		return err
+	}
+	m.results[e.path] = result{file: merged, side: sideOurs}
+	if !clean {
+		text := "CONFLICT (content): Merge conflict in " + e.path
+		if !b.Exists() {
+			text = "CONFLICT (add/add): Merge conflict in " + e.path
+		}
+		m.message(KindContents, text, e.path)
+		m.addStages(e)
+	}
+	return nil
+}
+
+// Merges the files into one at the path, telling whether without conflicts
+func (m *treeMerger) mergeFiles(p string, b diff.File, o diff.File, t diff.File) (diff.File, bool, error) {
+	mode, clean := mergeModes(b.Mode, o.Mode, t.Mode)
+	if !isRegular(o.Mode) || !isRegular(t.Mode) {
+		// symlinks and submodules cannot be merged line by line
+		return diff.File{Path: p, Mode: o.Mode, Digest: o.Digest}, false, nil
+	}
+	switch {
+	case o.Digest == t.Digest || b.Digest == t.Digest:
+		return diff.File{Path: p, Mode: mode, Digest: o.Digest}, clean, nil
+	case b.Digest == o.Digest:
+		return diff.File{Path: p, Mode: mode, Digest: t.Digest}, clean, nil
+	}
+	var contents [3][]byte
+	for side, file := range []diff.File{b, o, t} {
+		if !file.Exists() {
+			continue
+		}
+		_, content, err := m.db.ReadRawObject(file.Digest)
+		if err != nil {
+			return diff.File{}, false, err
+		}
+		contents[side] = content
+	}
+	opts := Options{
+		Ours:   m.label(sideOurs),
+		Base:   m.opts.Base,
+		Theirs: m.label(sideTheirs),
+		Style:  m.opts.Style,
+	}
+	if b.Path != p && b.Exists() || o.Path != p || t.Path != p {
+		opts.Ours += ":" + o.Path
+		opts.Theirs += ":" + t.Path
+		if b.Exists() {
+			opts.Base += ":" + b.Path
+		}
+	}
+	size, err := MarkerSize(m.opts.Attrs, p)
+	if err != nil {
+		return diff.File{}, false, err
+	}
+	opts.MarkerSize = size + 2*m.opts.depth
+	res, conflicts, err := Merge(contents[sideBase], contents[sideOurs], contents[sideTheirs], opts)
+	if errors.Is(err, ErrorBinary) {
+		m.message(KindBinary, fmt.Sprintf("warning: Cannot merge binary files: %v (%v vs. %v)", p, m.label(sideOurs), m.label(sideTheirs)), p)
+		m.message(KindAutoMerging, "Auto-merging "+p, p)
+		return diff.File{Path: p, Mode: mode, Digest: o.Digest}, false, nil
+	} else if err != nil {
+		return diff.File{}, false, err
+	}
+	m.message(KindAutoMerging, "Auto-merging "+p, p)
+	blob, err := repr.NewBlob(bytes.NewReader(res))
+	if err != nil {
+		return diff.File{}, false, err
+	}
+	if err := m.db.WriteObject(blob); err != nil {
+		return diff.File{}, false, err
+	}
+	return diff.File{Path: p, Mode: mode, Digest: blob.Digest()}, clean && conflicts == 0, nil
+}
+
+// Length of the conflict markers the attributes give the path, the default
+// one if they give none or attrs is nil
+func MarkerSize(attrs *attr.Matcher, p string) (int, error) {
+	if attrs == nil {
+		return DefaultMarkerSize, nil
+	}
+	value, err := attrs.Get(p, markerSizeAttr)
+	if err != nil {
+		return 0, err
+	}
+	if value.State == attr.Valued {
+		if size, err := strconv.Atoi(value.Value); err == nil && size > 0 {
+			return size, nil
+		}
+	}
+	return DefaultMarkerSize, nil
+}
+
+// Moves files that are in the way of directories of the result next to
+// them, to the path with "~" and the name of the side they are from
+func (m *treeMerger) resolveDirectories() {
+	dirs := make(map[string]bool)
+	for p := range m.results {
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	var paths []string
+	for p := range m.results {
+		if dirs[p] {
+			paths = append(paths, p)
+		}
+	}
+	slices.Sort(paths)
+	for _, p := range paths {
+		res := m.results[p]
+		label := m.label(res.side)
+		moved := p + "~" + strings.ReplaceAll(label, "/", "_")
+		delete(m.results, p)
+		res.file.Path = moved
+		m.results[moved] = res
+		staged := false
+		for i := range m.conflicts {
+			if m.conflicts[i].Path == p {
+				m.conflicts[i].Path, staged = moved, true
+			}
+		}
+		if !staged {
+			m.conflicts = append(m.conflicts, stageOf(moved, res.side, res.file))
+		}
+		m.message(KindFileDirectory, fmt.Sprintf("CONFLICT (file/directory): directory in the way of %v from %v; moving it to %v instead.",
+			p, label, moved), moved, p)
+	}
+}
+
+// Writes the tree of the results
+func (m *treeMerger) writeTree() (string, error) {
+	var files []diff.File
+	for _, res := range m.results {
+		files = append(files, res.file)
+	}
+	slices.SortFunc(files, func(a, b diff.File) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return writeTree(m.db, files, "")
+}
+
+// Writes the tree of the files under the prefix, which is empty or ends
+// with a slash
+func writeTree(db *fs.ObjectDB, files []diff.File, prefix string) (string, error) {
+	var entries []repr.TreeEntry
+	for i := 0; i < len(files); {
+		rel := strings.TrimPrefix(files[i].Path, prefix)
+		dir, _, found := strings.Cut(rel, "/")
+		if !found {
+			entries = append(entries, repr.TreeEntry{Name: rel, Mode: files[i].Mode, Digest: files[i].Digest})
+			i += 1
+			continue
+		}
+		j := i
+		for j < len(files) && strings.HasPrefix(files[j].Path, prefix+dir+"/") {
+			j += 1
+		}
+		digest, err := writeTree(db, files[i:j], prefix+dir+"/")
+		if err != nil {
+			return "", err
+		}
+		entries = append(entries, repr.TreeEntry{Name: dir, Mode: repr.ModeTree, Digest: digest})
+		i = j
+	}
+	tree := repr.NewTreeFromEntries(entries)
+	if err := db.WriteObject(tree); err != nil {
+		return "", err
+	}
+	return tree.Digest(), nil
+}
+
+func (m *treeMerger) label(side int) string {
+	if side == sideTheirs {
+		return m.opts.Theirs
+	}
+	return m.opts.Ours
+}
+
+func (m *treeMerger) message(kind string, text string, paths ...string) {
+	m.messages = append(m.messages, Message{Paths: paths, Kind: kind, Text: text})
+}
+
+// Records the files of the entry as the stages of its path
+func (m *treeMerger) addStages(e *entry) {
+	for side, file := range e.files {
+		if file.Exists() {
+			m.conflicts = append(m.conflicts, stageOf(e.path, side, file))
+		}
+	}
+}
+
+func stageOf(p string, side int, file diff.File) Stage {
+	return Stage{Path: p, Stage: side + 1, Mode: file.Mode, Digest: file.Digest}
+}
+
+func sameFile(a diff.File, b diff.File) bool {
+	return a.Mode == b.Mode && a.Digest == b.Digest
+}
+
+func isRegular(mode repr.ObjectModeType) bool {
+	return mode == repr.ModeNormal || mode == repr.ModeExecutable
+}
+
+// The mode a side changed to from the base, and whether the sides did not
+// change it differently
+func mergeModes(base repr.ObjectModeType, ours repr.ObjectModeType, theirs repr.ObjectModeType) (repr.ObjectModeType, bool) {
+	switch {
+	case ours == theirs || base == theirs:
+		return ours, true
+	case base == ours:
+		return theirs, true
+	}
+	return ours, false
+}
@@ -0,0 +1,177 @@
+package merge_test
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/magnickolas/gitok/diff"
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/merge"
+	"github.com/magnickolas/gitok/repr"
+)
+
+// Writes the tree of the files, by their slash-separated paths
+func writeTree(t *testing.T, db *fs.ObjectDB, files map[string]string) string {
+	var entries []repr.TreeEntry
+	subdirs := map[string]map[string]string{}
+	for p, content := range files {
+		if dir, rest, found := strings.Cut(p, "/"); found {
+			if subdirs[dir] == nil {
+				subdirs[dir] = map[string]string{}
+			}
+			subdirs[dir][rest] = content
+			continue
+		}
+		blob, err := repr.NewBlob(strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.WriteObject(blob); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, repr.TreeEntry{Name: p, Mode: repr.ModeNormal, Digest: blob.Digest()})
+	}
+	for dir, files := range subdirs {
+		entries = append(entries, repr.TreeEntry{Name: dir, Mode: repr.ModeTree, Digest: writeTree(t, db, files)})
+	}
+	tree := repr.NewTreeFromEntries(entries)
+	if err := db.WriteObject(tree); err != nil {
+		t.Fatal(err)
+	}
+	return tree.Digest()
+}
+
+// Contents of the files of the tree by their paths
+func readTree(t *testing.T, db *fs.ObjectDB, tree string) map[string]string {
+	files, err := diff.TreeFiles(db, tree, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := map[string]string{}
+	for _, file := range files {
+		_, content, err := db.ReadRawObject(file.Digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res[file.Path] = string(content)
+	}
+	return res
+}
+
+func TestMergeTrees(t *testing.T) {
+	db := fs.OpenObjectDB(t.TempDir())
+	numbers := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"
+	letters := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n"
+	tests := []struct {
+		name               string
+		base, ours, theirs map[string]string
+		files              map[string]string
+		stages             []string
+		messages           []string
+	}{
+		{
+			name:     "clean",
+			base:     map[string]string{"f": numbers, "same": "s\n"},
+			ours:     map[string]string{"f": strings.Replace(numbers, "2\n", "O\n", 1), "same": "s\n"},
+			theirs:   map[string]string{"f": strings.Replace(numbers, "9\n", "T\n", 1), "same": "s\n", "new": "n\n"},
+			files:    map[string]string{"f": strings.Replace(strings.Replace(numbers, "2\n", "O\n", 1), "9\n", "T\n", 1), "same": "s\n", "new": "n\n"},
+			messages: []string{"Auto-merging f"},
+		},
+		{
+			name:   "add/add",
+			base:   map[string]string{},
+			ours:   map[string]string{"n": "o\n"},
+			theirs: map[string]string{"n": "t\n"},
+			files:  map[string]string{"n": "<<<<<<< ours\no\n=======\nt\n>>>>>>> theirs\n"},
+			stages: []string{"n:2", "n:3"},
+			messages: []string{
+				"Auto-merging n",
+				"CONFLICT (add/add): Merge conflict in n",
+			},
+		},
+		{
+			name:   "modify/delete",
+			base:   map[string]string{"m": "1\n"},
+			ours:   map[string]string{},
+			theirs: map[string]string{"m": "2\n"},
+			files:  map[string]string{"m": "2\n"},
+			stages: []string{"m:1", "m:3"},
+			messages: []string{
+				"CONFLICT (modify/delete): m deleted in ours and modified in theirs.  Version theirs of m left in tree.",
+			},
+		},
+		{
+			name:   "rename/delete",
+			base:   map[string]string{"r": numbers},
+			ours:   map[string]string{"r2": numbers},
+			theirs: map[string]string{},
+			files:  map[string]string{"r2": numbers},
+			stages: []string{"r2:1", "r2:2"},
+			messages: []string{
+				"CONFLICT (rename/delete): r renamed to r2 in ours, but deleted in theirs.",
+			},
+		},
+		{
+			name:   "rename/rename",
+			base:   map[string]string{"p": numbers},
+			ours:   map[string]string{"a": numbers},
+			theirs: map[string]string{"b": numbers},
+			files:  map[string]string{"a": numbers, "b": numbers},
+			stages: []string{"a:2", "b:3", "p:1"},
+			messages: []string{
+				"CONFLICT (rename/rename): p renamed to a in ours and to b in theirs.",
+			},
+		},
+		{
+			name:   "rename/rename to one path",
+			base:   map[string]string{"p": numbers, "q": letters},
+			ours:   map[string]string{"target": numbers, "q": strings.Replace(letters, "e\n", "E\n", 1)},
+			theirs: map[string]string{"p": strings.Replace(numbers, "3\n", "T\n", 1), "target": letters},
+			files: map[string]string{"target": "<<<<<<< ours\n" + strings.Replace(numbers, "3\n", "T\n", 1) +
+				"=======\n" + strings.Replace(letters, "e\n", "E\n", 1) + ">>>>>>> theirs\n"},
+			stages: []string{"target:2", "target:3"},
+			messages: []string{
+				"Auto-merging target",
+				"CONFLICT (add/add): Merge conflict in target",
+			},
+		},
+		{
+			name:   "directory/file",
+			base:   map[string]string{},
+			ours:   map[string]string{"d": "f\n"},
+			theirs: map[string]string{"d/x": "x\n"},
+			files:  map[string]string{"d~ours": "f\n", "d/x": "x\n"},
+			stages: []string{"d~ours:2"},
+			messages: []string{
+				"CONFLICT (file/directory): directory in the way of d from ours; moving it to d~ours instead.",
+			},
+		},
+	}
+	opts := merge.TreeOptions{
+		Ours:    "ours",
+		Theirs:  "theirs",
+		Base:    "base",
+		Renames: diff.Options{Detect: diff.DetectRenames},
+	}
+	for _, test := range tests {
+		res, err := merge.MergeTrees(db, writeTree(t, db, test.base), writeTree(t, db, test.ours), writeTree(t, db, test.theirs), opts)
+		if err != nil {
+			t.Errorf("failed to merge %v: %v", test.name, err)
+			continue
+		}
+		var stages, messages []string
+		for _, stage := range res.Conflicts {
+			stages = append(stages, fmt.Sprintf("%v:%v", stage.Path, stage.Stage))
+		}
+		for _, msg := range res.Messages {
+			messages = append(messages, msg.Text)
+		}
+		files := readTree(t, db, res.Tree)
+		if !slices.Equal(stages, test.stages) || !slices.Equal(messages, test.messages) || fmt.Sprint(files) != fmt.Sprint(test.files) {
+			t.Errorf("incorrect result for %v: wanted %#v, %#v, %#v, got %#v, %#v, %#v",
+				test.name, test.files, test.stages, test.messages, files, stages, messages)
+		}
+	}
+}
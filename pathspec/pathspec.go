@@ -0,0 +1,82 @@
+package pathspec
+
+import (
+	"strings"
+
+	"github.com/magnickolas/gitok/wildmatch"
+)
+
+// Set of paths given on the command line; an item matches the path itself,
+// everything below it if it names a directory, and, if it contains
+// wildcards, every path it matches as a glob (where '*' also matches '/')
+type Pathspec struct {
+	items []string
+}
+
+func New(items []string) *Pathspec {
+	ps := new(Pathspec)
+	for _, item := range items {
+		item = strings.TrimPrefix(item, "./")
+		if item == "." {
+			item = ""
+		}
+		ps.items = append(ps.items, item)
+	}
+	return ps
+}
+
+func (ps *Pathspec) IsEmpty() bool {
+	return ps == nil || len(ps.items) == 0
+}
+
+func (ps *Pathspec) Match(path string) bool {
+	if ps.IsEmpty() {
+		return true
+	}
+	for _, item := range ps.items {
+		if matchItem(item, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether anything below the directory can be matched, used to skip
+// descending into uninteresting trees
+func (ps *Pathspec) MatchesUnder(dir string) bool {
+	if ps.IsEmpty() {
+		return true
+	}
+	dir = strings.TrimSuffix(dir, "/")
+	for _, item := range ps.items {
+		if matchItem(item, dir) {
+			return true
+		}
+		prefix := literalPrefix(item)
+		if strings.HasPrefix(prefix, dir+"/") || (hasWildcards(item) && strings.HasPrefix(dir+"/", prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchItem(item string, path string) bool {
+	dir := strings.TrimSuffix(item, "/")
+	if dir == "" || path == dir || strings.HasPrefix(path, dir+"/") {
+		return true
+	}
+	return hasWildcards(item) && wildmatch.Match(item, path, 0)
+}
+
+func hasWildcards(s string) bool {
+	return strings.ContainsAny(s, "*?[\\")
+}
+
+// Part of the item before the first wildcard
+func literalPrefix(item string) string {
+	i := strings.IndexAny(item, "*?[\\")
+	if i == -1 {
+		return item
+	}
+	return item[:i]
+}
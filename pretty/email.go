@@ -0,0 +1,250 @@
+package pretty
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/magnickolas/gitok/date"
+	"github.com/magnickolas/gitok/repr"
+)
+
+// Columns header lines are wrapped at
+const mailWrap = 78
+
+// Columns of the encoded words of headers, by RFC 2047
+const encodedWordWrap = 76
+
+// Charset of the messages shown in mails
+const mailCharset = "UTF-8"
+
+// Headers telling that the mail has 8-bit text
+const mimeHeaders = "MIME-Version: 1.0\n" +
+	"Content-Type: text/plain; charset=" + mailCharset + "\n" +
+	"Content-Transfer-Encoding: 8bit\n"
+
+// The line starting a mail in an mbox, with the date git puts on all of
+// them for the line to be told apart from others
+func MailFromLine(digest string) string {
+	return "From " + digest + " Mon Sep 17 00:00:00 2001\n"
+}
+
+// Headers of a mail sent by the person at the time of the signature with
+// the subject after the prefix, and the MIME headers for 8-bit text if
+// needed
+func MailHeaders(from repr.Signature, subjectPrefix string, subject string, eightBit bool) string {
+	var b strings.Builder
+	b.WriteString("From: ")
+	width := mailWrap
+	if needsRFC2047(from.Name) {
+		b.WriteString(encodeRFC2047(from.Name, len("From: "), true))
+		width = encodedWordWrap
+	} else {
+		name := from.Name
+		if needsRFC822Quoting(name) {
+			name = quoteRFC822(name)
+		}
+		b.WriteString(Wrap(name, -len("From: "), 1, width))
+	}
+	if width < lastLineLen(b.String())+len(" <")+len(from.Email)+len(">") {
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, " <%v>\n", from.Email)
+	fmt.Fprintf(&b, "Date: %v\n", dateIn(date.ModeRFC, from.When))
+	b.WriteString("Subject: ")
+	b.WriteString(subjectPrefix)
+	if needsRFC2047(subject) {
+		b.WriteString(encodeRFC2047(subject, lastLineLen(b.String()), false))
+	} else {
+		b.WriteString(Wrap(subject, -lastLineLen(b.String()), 1, mailWrap))
+	}
+	b.WriteString("\n")
+	if eightBit {
+		b.WriteString(mimeHeaders)
+	}
+	return b.String()
+}
+
+// The commit as a mail by its author: headers, then the message after the
+// subject with trailing whitespace removed
+func (f *Formatter) mail(c *Commit) string {
+	title, body := splitMessage(c.Message())
+	var b strings.Builder
+	b.WriteString(MailFromLine(c.Digest))
+	b.WriteString(MailHeaders(c.Author(), f.opts.SubjectPrefix, strings.Join(title, " "), HasNonASCII(c.Message())))
+	b.WriteString("\n")
+	lines := strings.Split(body, "\n")
+	for len(lines) > 0 && isBlank(lines[len(lines)-1]) {
+		lines = lines[:len(lines)-1]
+	}
+	for _, line := range lines {
+		b.WriteString(strings.TrimRight(line, " \t\n\v\f\r"))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func HasNonASCII(s string) bool {
+	for i := 0; i < len(s); i += 1 {
+		if s[i] >= utf8.RuneSelf {
+			return true
+		}
+	}
+	return false
+}
+
+// Columns of the last line of the text
+func lastLineLen(s string) int {
+	return len(s) - strings.LastIndexByte(s, '\n') - 1
+}
+
+func needsRFC2047(s string) bool {
+	return HasNonASCII(s) || strings.Contains(s, "\n") || strings.Contains(s, "=?")
+}
+
+// Characters that cannot be in encoded words as themselves; in phrases
+// like the names of addresses even fewer can
+func isRFC2047Special(c byte, phrase bool) bool {
+	if c >= utf8.RuneSelf || c < ' ' || c > '~' || c == ' ' || c == '=' || c == '?' || c == '_' {
+		return true
+	}
+	if !phrase {
+		return false
+	}
+	return !isAlnum(c) && !strings.ContainsRune("!*+-/", rune(c))
+}
+
+// The text as "Q"-encoded words, split into lines of at most 76 columns
+// counting the column the text starts at; characters are never split
+// across words
+func encodeRFC2047(s string, column int, phrase bool) string {
+	var b strings.Builder
+	start := "=?" + mailCharset + "?q?"
+	b.WriteString(start)
+	column += len(start)
+	for s != "" {
+		_, n := utf8.DecodeRuneInString(s)
+		chr := s[:n]
+		s = s[n:]
+		special := n > 1 || isRFC2047Special(chr[0], phrase)
+		encodedLen := 1
+		if special {
+			encodedLen = 3 * n
+		}
+		// it must fit with the trailing "?="
+		if column+encodedLen+2 > encodedWordWrap {
+			b.WriteString("?=\n " + start)
+			column = len(start) + 1
+		}
+		for i := 0; i < n; i += 1 {
+			if special {
+				fmt.Fprintf(&b, "=%02X", chr[i])
+			} else {
+				b.WriteByte(chr[i])
+			}
+		}
+		column += encodedLen
+	}
+	b.WriteString("?=")
+	return b.String()
+}
+
+func needsRFC822Quoting(s string) bool {
+	return strings.ContainsAny(s, `()<>[]:;@,."\`)
+}
+
+func quoteRFC822(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i += 1 {
+		if s[i] == '"' || s[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func isSpace(c byte) bool {
+	return strings.IndexByte(" \t\n\v\f\r", c) != -1
+}
+
+// Wraps the text at the width, indenting the first line by indent1 and
+// the others by indent2. A negative indent1 is the column the text starts
+// at on a line already begun. Line breaks in the text become spaces,
+// unless doubled or followed by something other than a letter or digit
+func Wrap(text string, indent1 int, indent2 int, width int) string {
+	var b strings.Builder
+	bol := 0
+	w, indent := indent1, indent1
+	// where the last word ended, -1 if none did on the line
+	space := -1
+	if indent < 0 {
+		w, space = -indent, 0
+	}
+	for i := 0; ; {
+		end := i == len(text)
+		var c byte
+		if !end {
+			c = text[i]
+		}
+		if !end && !isSpace(c) {
+			_, n := utf8.DecodeRuneInString(text[i:])
+			w += 1
+			i += n
+			continue
+		}
+		if w <= width || space < 0 {
+			start := bol
+			if end && i == start {
+				return b.String()
+			}
+			if space >= 0 {
+				start = space
+			} else {
+				b.WriteString(strings.Repeat(" ", max(indent, 0)))
+			}
+			b.WriteString(text[start:i])
+			if end {
+				return b.String()
+			}
+			space = i
+			newLine := false
+			switch c {
+			case '\t':
+				w |= 0x07
+			case '\n':
+				space += 1
+				next := byte(0)
+				if space < len(text) {
+					next = text[space]
+				}
+				if next == '\n' {
+					b.WriteString("\n")
+					newLine = true
+				} else if !isAlnum(next) {
+					newLine = true
+				} else {
+					b.WriteString(" ")
+				}
+			}
+			if !newLine {
+				w += 1
+				i += 1
+				continue
+			}
+		}
+		b.WriteString("\n")
+		i = space
+		if i < len(text) && isSpace(text[i]) {
+			i += 1
+		}
+		bol, space = i, -1
+		w, indent = indent2, indent2
+	}
+}
+
+func isAlnum(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
@@ -0,0 +1,235 @@
+package pretty
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/magnickolas/gitok/color"
+	"github.com/magnickolas/gitok/date"
+	"github.com/magnickolas/gitok/repr"
+)
+
+var shortColors = map[string]string{
+	"red":   "\x1b[31m",
+	"green": "\x1b[32m",
+	"blue":  "\x1b[34m",
+	"reset": color.Reset,
+}
+
+// Expands the placeholders of a user format for the commit. Placeholders
+// that are not known are kept as they are
+func (f *Formatter) Expand(format string, c *Commit) string {
+	var b strings.Builder
+	for i := 0; i < len(format); i += 1 {
+		if format[i] != '%' || i+1 == len(format) {
+			b.WriteByte(format[i])
+			continue
+		}
+		rest := format[i+1:]
+		// "%+x" adds a line break before a non-empty expansion, "% x" a
+		// space, and "%-x" removes the line breaks before an empty one
+		var modifier byte
+		if rest[0] == '+' || rest[0] == '-' || rest[0] == ' ' {
+			modifier, rest = rest[0], rest[1:]
+		}
+		value, n := f.placeholder(rest, c)
+		if n == 0 {
+			b.WriteByte('%')
+			continue
+		}
+		switch {
+		case modifier == '+' && value != "":
+			value = "\n" + value
+		case modifier == ' ' && value != "":
+			value = " " + value
+		case modifier == '-' && value == "":
+			trimmed := strings.TrimRight(b.String(), "\n")
+			b.Reset()
+			b.WriteString(trimmed)
+		}
+		b.WriteString(value)
+		i += n
+		if modifier != 0 {
+			i += 1
+		}
+	}
+	return b.String()
+}
+
+// Expansion of the placeholder at the start of s, which is the format
+// after '%', and its length; zero if s starts with no known placeholder
+func (f *Formatter) placeholder(s string, c *Commit) (string, int) {
+	switch s[0] {
+	case '%':
+		return "%", 1
+	case 'n':
+		return "\n", 1
+	case 'H':
+		return c.Digest, 1
+	case 'h':
+		return f.abbrev(c.Digest), 1
+	case 'T':
+		return c.TreeDigest(), 1
+	case 't':
+		return f.abbrev(c.TreeDigest()), 1
+	case 'P':
+		return strings.Join(c.Parents, " "), 1
+	case 'p':
+		parents := make([]string, len(c.Parents))
+		for i, parent := range c.Parents {
+			parents[i] = f.abbrev(parent)
+		}
+		return strings.Join(parents, " "), 1
+	case 'm':
+		return ">", 1
+	case 'd':
+		return refList(c), 1
+	case 'D':
+		return strings.Join(c.Refs, ", "), 1
+	case 'e', 'N':
+		// no encodings or notes
+		return "", 1
+	case 's':
+		return subject(c.Message()), 1
+	case 'f':
+		return sanitize(c.Message()), 1
+	case 'b':
+		_, body := splitMessage(c.Message())
+		return body, 1
+	case 'B':
+		return c.Message(), 1
+	case 'a', 'c':
+		if len(s) < 2 {
+			return "", 0
+		}
+		sig := c.Author()
+		if s[0] == 'c' {
+			sig = c.Committer()
+		}
+		value, ok := f.signature(s[1], sig)
+		if !ok {
+			return "", 0
+		}
+		return value, 2
+	case 'x':
+		if len(s) < 3 {
+			return "", 0
+		}
+		b, err := strconv.ParseUint(s[1:3], 16, 8)
+		if err != nil {
+			return "", 0
+		}
+		return string([]byte{byte(b)}), 3
+	case 'C':
+		return f.color(s)
+	case 'w':
+		// line wrapping is not done, only its parameters are consumed
+		if strings.HasPrefix(s, "w(") {
+			if end := strings.IndexByte(s, ')'); end != -1 {
+				return "", end + 1
+			}
+		}
+	}
+	return "", 0
+}
+
+// Part of an author or committer selected by the letter after %a or %c
+func (f *Formatter) signature(field byte, sig repr.Signature) (string, bool) {
+	switch field {
+	case 'n', 'N':
+		return sig.Name, true
+	case 'e', 'E':
+		return sig.Email, true
+	case 'l', 'L':
+		local, _, _ := strings.Cut(sig.Email, "@")
+		return local, true
+	case 'd':
+		return f.date(sig.When), true
+	case 'D':
+		return dateIn(date.ModeRFC, sig.When), true
+	case 'r':
+		return date.Format{Mode: date.ModeRelative}.Format(sig.When, f.now), true
+	case 't':
+		return dateIn(date.ModeUnix, sig.When), true
+	case 'i':
+		return dateIn(date.ModeISO, sig.When), true
+	case 'I':
+		return dateIn(date.ModeISOStrict, sig.When), true
+	case 's':
+		return dateIn(date.ModeShort, sig.When), true
+	}
+	return "", false
+}
+
+func dateIn(mode date.Mode, t time.Time) string {
+	return date.Format{Mode: mode}.Format(t, time.Time{})
+}
+
+// "%Cred", "%Cgreen", "%Cblue", "%Creset" and "%C(<color>)", where the
+// color may be prefixed with "auto," to show it only when coloring or with
+// "always," to show it regardless
+func (f *Formatter) color(s string) (string, int) {
+	for name, code := range shortColors {
+		if strings.HasPrefix(s[1:], name) {
+			if !f.opts.Color {
+				return "", len(name) + 1
+			}
+			return code, len(name) + 1
+		}
+	}
+	if !strings.HasPrefix(s, "C(") {
+		return "", 0
+	}
+	end := strings.IndexByte(s, ')')
+	if end == -1 {
+		return "", 0
+	}
+	spec := s[2:end]
+	show := f.opts.Color
+	if rest, found := strings.CutPrefix(spec, "always,"); found {
+		spec, show = rest, true
+	} else if rest, found := strings.CutPrefix(spec, "auto,"); found {
+		spec = rest
+	}
+	if spec == "auto" || !show {
+		return "", end + 1
+	}
+	code, err := color.Parse(spec)
+	if err != nil {
+		return "", 0
+	}
+	return code, end + 1
+}
+
+func isTitleChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '.' || c == '_'
+}
+
+// The first paragraph of the message made usable as a file name: runs of
+// other characters than letters, digits, '.' and '_' become a single '-'
+func sanitize(msg string) string {
+	title, _ := splitMessage(msg)
+	// the line breaks of the first paragraph separate words too
+	line := strings.Join(title, " ")
+	var b strings.Builder
+	// no dash before the first title character
+	space := 2
+	for i := 0; i < len(line); i += 1 {
+		if !isTitleChar(line[i]) {
+			space |= 1
+			continue
+		}
+		if space == 1 {
+			b.WriteByte('-')
+		}
+		space = 0
+		b.WriteByte(line[i])
+		if line[i] == '.' {
+			for i+1 < len(line) && line[i+1] == '.' {
+				i += 1
+			}
+		}
+	}
+	return strings.TrimRight(b.String(), ".-")
+}
@@ -0,0 +1,284 @@
+package pretty
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/magnickolas/gitok/date"
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/repr"
+)
+
+var (
+	ErrorInvalidFormat       = errors.New("invalid --pretty format")
+	formatErrorInvalidFormat = func(name string) error {
+		return fmt.Errorf("%w: %v", ErrorInvalidFormat, name)
+	}
+)
+
+type Format int
+
+const (
+	Medium Format = iota
+	Oneline
+	Short
+	Full
+	Fuller
+	Raw
+	// a mail in an mbox, as format-patch writes it
+	Email
+	// placeholders of Options.UserFormat
+	User
+)
+
+// Length of abbreviated digests
+const abbrevLen = 7
+
+// Indentation of message lines
+const indent = "    "
+
+// Columns between tab stops in messages
+const tabWidth = 8
+
+var formatNames = map[string]Format{
+	"medium":  Medium,
+	"oneline": Oneline,
+	"short":   Short,
+	"full":    Full,
+	"fuller":  Fuller,
+	"raw":     Raw,
+	"email":   Email,
+}
+
+type Options struct {
+	Format Format
+	// for the User format
+	UserFormat string
+	// the user format goes between commits instead of after each, as with
+	// "format:" rather than "tformat:"
+	Separator bool
+	Date      date.Format
+	// abbreviated digest on the commit line
+	AbbrevCommit bool
+	// emit the %C colors not given as "always"
+	Color bool
+	// show the refs pointing to the commit after its digest
+	Decorate bool
+	// put before the subject of mails, like "[PATCH 1/2] "
+	SubjectPrefix string
+}
+
+// Sets the format from the value of --pretty: a format name,
+// "format:<placeholders>", "tformat:<placeholders>" or placeholders alone
+func (o *Options) SetFormat(s string) error {
+	if format, ok := formatNames[s]; ok {
+		o.Format, o.UserFormat, o.Separator = format, "", false
+		return nil
+	}
+	if placeholders, found := strings.CutPrefix(s, "format:"); found {
+		o.Format, o.UserFormat, o.Separator = User, placeholders, true
+		return nil
+	}
+	if placeholders, found := strings.CutPrefix(s, "tformat:"); found {
+		o.Format, o.UserFormat, o.Separator = User, placeholders, false
+		return nil
+	}
+	if s == "" || strings.Contains(s, "%") {
+		o.Format, o.UserFormat, o.Separator = User, s, false
+		return nil
+	}
+	return formatErrorInvalidFormat(s)
+}
+
+// Whether commits are followed by a newline rather than separated by one
+func (o *Options) UsesTerminator() bool {
+	return o.Format == Oneline || (o.Format == User && !o.Separator)
+}
+
+// Whether the format shows nothing at all
+func (o *Options) IsEmpty() bool {
+	return o.Format == User && o.UserFormat == ""
+}
+
+// A commit to show, with the parents the walk followed
+type Commit struct {
+	Digest  string
+	Parents []string
+	// labels of the refs pointing to the commit
+	Refs []string
+	*repr.Commit
+}
+
+type Formatter struct {
+	db   *fs.ObjectDB
+	opts Options
+	now  time.Time
+}
+
+func NewFormatter(db *fs.ObjectDB, opts Options) *Formatter {
+	return &Formatter{db: db, opts: opts, now: time.Now()}
+}
+
+func (f *Formatter) abbrev(digest string) string {
+	return f.db.Abbrev(digest, abbrevLen)
+}
+
+// The commit as shown by the format, without the line break between
+// commits for the oneline and user formats
+func (f *Formatter) Format(c *Commit) string {
+	switch f.opts.Format {
+	case Oneline:
+		return f.oneline(c)
+	case Email:
+		return f.mail(c)
+	case User:
+		return f.Expand(f.opts.UserFormat, c)
+	}
+	var b strings.Builder
+	b.WriteString("commit ")
+	if f.opts.AbbrevCommit {
+		b.WriteString(f.abbrev(c.Digest))
+	} else {
+		b.WriteString(c.Digest)
+	}
+	b.WriteString(f.decoration(c))
+	b.WriteString("\n")
+	if f.opts.Format == Raw {
+		header, _, _ := strings.Cut(c.String(), "\n\n")
+		b.WriteString(header)
+		b.WriteString("\n")
+	} else {
+		f.writeHeader(&b, c)
+	}
+	b.WriteString("\n")
+	lines := strings.Split(c.Message(), "\n")
+	for len(lines) > 0 && isBlank(lines[0]) {
+		lines = lines[1:]
+	}
+	for _, line := range lines {
+		if isBlank(line) && f.opts.Format == Short {
+			break
+		}
+		b.WriteString(indent)
+		if f.opts.Format == Raw {
+			b.WriteString(line)
+		} else {
+			b.WriteString(expandTabs(line))
+		}
+		b.WriteString("\n")
+	}
+	return strings.TrimRight(b.String(), " \t\n\v\f\r") + "\n"
+}
+
+func (f *Formatter) writeHeader(b *strings.Builder, c *Commit) {
+	if len(c.Parents) > 1 {
+		b.WriteString("Merge:")
+		for _, parent := range c.Parents {
+			b.WriteString(" ")
+			b.WriteString(f.abbrev(parent))
+		}
+		b.WriteString("\n")
+	}
+	author, committer := c.Author(), c.Committer()
+	switch f.opts.Format {
+	case Medium:
+		fmt.Fprintf(b, "Author: %v\nDate:   %v\n", identity(author), f.date(author.When))
+	case Short:
+		fmt.Fprintf(b, "Author: %v\n", identity(author))
+	case Full:
+		fmt.Fprintf(b, "Author: %v\nCommit: %v\n", identity(author), identity(committer))
+	case Fuller:
+		fmt.Fprintf(b, "Author:     %v\nAuthorDate: %v\nCommit:     %v\nCommitDate: %v\n",
+			identity(author), f.date(author.When), identity(committer), f.date(committer.When))
+	}
+}
+
+func (f *Formatter) oneline(c *Commit) string {
+	digest := c.Digest
+	if f.opts.AbbrevCommit {
+		digest = f.abbrev(digest)
+	}
+	// the space after the refs stays when there is no subject
+	return digest + f.decoration(c) + " " + strings.TrimRight(subject(c.Message()), " \t\n\v\f\r")
+}
+
+// The refs of the commit in parentheses after a space, if decorating
+func (f *Formatter) decoration(c *Commit) string {
+	if !f.opts.Decorate {
+		return ""
+	}
+	return refList(c)
+}
+
+func refList(c *Commit) string {
+	if len(c.Refs) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(c.Refs, ", ") + ")"
+}
+
+func (f *Formatter) date(t time.Time) string {
+	return f.opts.Date.Format(t, f.now)
+}
+
+func identity(s repr.Signature) string {
+	return s.Name + " <" + s.Email + ">"
+}
+
+// Replaces tabs with spaces up to the next multiple of 8 columns, counted
+// from the start of the line before indenting
+func expandTabs(line string) string {
+	if !strings.Contains(line, "\t") {
+		return line
+	}
+	var b strings.Builder
+	column := 0
+	for _, r := range line {
+		if r == '\t' {
+			n := tabWidth - column%tabWidth
+			b.WriteString(strings.Repeat(" ", n))
+			column += n
+			continue
+		}
+		b.WriteRune(r)
+		column += 1
+	}
+	return b.String()
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// Splits the message, with leading blank lines dropped, into the first
+// paragraph's lines and the rest after the blank lines following it
+func splitMessage(msg string) ([]string, string) {
+	var title []string
+	for msg != "" {
+		line, rest, _ := strings.Cut(msg, "\n")
+		if isBlank(line) {
+			if len(title) > 0 {
+				break
+			}
+		} else {
+			title = append(title, strings.TrimRight(line, " \t\n\v\f\r"))
+		}
+		msg = rest
+	}
+	for msg != "" {
+		line, rest, _ := strings.Cut(msg, "\n")
+		if !isBlank(line) {
+			break
+		}
+		msg = rest
+	}
+	return title, msg
+}
+
+// The first paragraph of the message on a single line
+func subject(msg string) string {
+	title, _ := splitMessage(msg)
+	return strings.Join(title, " ")
+}
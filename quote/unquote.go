@@ -0,0 +1,65 @@
+package quote
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrorBadQuoting = errors.New("bad C-style quoting")
+)
+
+// Undoes CQuote on the quoted name s starts with, returning the name and
+// what follows the closing quote
+func CUnquote(s string) (string, string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		return "", "", ErrorBadQuoting
+	}
+	var b strings.Builder
+	for i := 1; i < len(s); i += 1 {
+		c := s[i]
+		if c == '"' {
+			return b.String(), s[i+1:], nil
+		}
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		i += 1
+		if i == len(s) {
+			break
+		}
+		switch c = s[i]; c {
+		case 'a':
+			b.WriteByte('\a')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		case '\\', '"':
+			b.WriteByte(c)
+		case '0', '1', '2', '3':
+			// three octal digits, the first at most 3 not to overflow
+			if i+2 >= len(s) || !isOctal(s[i+1]) || !isOctal(s[i+2]) {
+				return "", "", ErrorBadQuoting
+			}
+			b.WriteByte((c-'0')<<6 | (s[i+1]-'0')<<3 | (s[i+2] - '0'))
+			i += 2
+		default:
+			return "", "", ErrorBadQuoting
+		}
+	}
+	return "", "", ErrorBadQuoting
+}
+
+func isOctal(c byte) bool {
+	return '0' <= c && c <= '7'
+}
@@ -0,0 +1,50 @@
+package refs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var ErrorLocked = errors.New("unable to create lock file")
+
+// A "<path>.lock" file held while the content of path is being replaced
+type lockFile struct {
+	path string
+	f    *os.File
+}
+
+func acquireLock(path string) (*lockFile, error) {
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("%w '%v.lock': File exists", ErrorLocked, path)
+	} else if err != nil {
+		return nil, err
+	}
+	return &lockFile{path: path, f: f}, nil
+}
+
+// Replaces the locked file with content and releases the lock
+func (l *lockFile) commit(content []byte) error {
+	_, err := l.f.Write(content)
+	if err == nil {
+		err = l.f.Close()
+	} else {
+		l.f.Close()
+	}
+	if err != nil {
+		os.Remove(l.f.Name())
+		return err
+	}
+	return os.Rename(l.f.Name(), l.path)
+}
+
+func (l *lockFile) rollback() {
+	l.f.Close()
+	os.Remove(l.f.Name())
+}
@@ -0,0 +1,103 @@
+package refs
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/magnickolas/gitok/config"
+	"github.com/magnickolas/gitok/constants"
+	"github.com/magnickolas/gitok/repr"
+)
+
+type ReflogEntry struct {
+	OldDigest string
+	NewDigest string
+	Ident     repr.Signature
+	Message   string
+}
+
+func reflogPath(name string) string {
+	return filepath.Join(constants.Git, constants.Logs, filepath.FromSlash(name))
+}
+
+// Entries of the ref's reflog, oldest first
+func ReadReflog(name string) ([]ReflogEntry, error) {
+	f, err := os.Open(reflogPath(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var res []ReflogEntry
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if len(line) == 0 {
+			continue
+		}
+		fields, message, _ := strings.Cut(line, "\t")
+		parts := strings.SplitN(fields, " ", 3)
+		if len(parts) != 3 || !repr.IsValidDigest(parts[0]) || !repr.IsValidDigest(parts[1]) {
+			return nil, fmt.Errorf("%v: malformed reflog line %q", name, line)
+		}
+		ident, err := repr.ParseSignature(parts[2])
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, ReflogEntry{
+			OldDigest: parts[0],
+			NewDigest: parts[1],
+			Ident:     ident,
+			Message:   message,
+		})
+	}
+	return res, sc.Err()
+}
+
+// Whether updates of the ref are recorded: an existing log is always
+// appended to, otherwise core.logAllRefUpdates decides for branches
+func shouldLog(name string, cfg *config.Config) bool {
+	if _, err := os.Stat(reflogPath(name)); err == nil {
+		return true
+	}
+	logAll, err := cfg.GetBool("core.logAllRefUpdates", true)
+	if err != nil || !logAll {
+		return false
+	}
+	return name == constants.Head ||
+		strings.HasPrefix(name, "refs/heads/") ||
+		strings.HasPrefix(name, "refs/remotes/") ||
+		strings.HasPrefix(name, "refs/notes/")
+}
+
+func appendReflog(name string, entry ReflogEntry) error {
+	path := reflogPath(name)
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	message := strings.ReplaceAll(strings.TrimRight(entry.Message, "\n"), "\n", " ")
+	_, err = fmt.Fprintf(f, "%s %s %s\t%s\n", entry.OldDigest, entry.NewDigest, entry.Ident, message)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func deleteReflog(name string) error {
+	err := os.Remove(reflogPath(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,319 @@
+package refs
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/magnickolas/gitok/constants"
+	"github.com/magnickolas/gitok/repr"
+)
+
+var (
+	ErrorRefNotFound       = errors.New("ref not found")
+	formatErrorRefNotFound = func(name string) error {
+		return fmt.Errorf("%w: %v", ErrorRefNotFound, name)
+	}
+	ErrorInvalidRefName       = errors.New("invalid ref name")
+	formatErrorInvalidRefName = func(name string) error {
+		return fmt.Errorf("%w: %v", ErrorInvalidRefName, name)
+	}
+	ErrorSymrefLoop = errors.New("too many levels of symbolic refs")
+)
+
+const symrefPrefix = "ref: "
+const maxSymrefDepth = 5
+
+type Ref struct {
+	Name string
+	// object the ref points to, empty for symbolic refs
+	Digest string
+	// name of the referenced ref for symbolic refs
+	Target string
+	// object an annotated tag peels to, if known from packed-refs
+	Peeled string
+}
+
+func (r *Ref) IsSymbolic() bool {
+	return r.Target != ""
+}
+
+// Refs of the repository at a git directory
+type Store struct {
+	gitDir string
+}
+
+// Refs of the current repository
+var Default = OpenStore(constants.Git)
+
+func OpenStore(gitDir string) *Store {
+	return &Store{gitDir: gitDir}
+}
+
+func (s *Store) path(name string) string {
+	return filepath.Join(s.gitDir, filepath.FromSlash(name))
+}
+
+func refPath(name string) string {
+	return Default.path(name)
+}
+
+// Reads a single ref without following symbolic refs
+func Read(name string) (*Ref, error) {
+	return Default.Read(name)
+}
+
+func (s *Store) Read(name string) (*Ref, error) {
+	b, err := os.ReadFile(s.path(name))
+	if err == nil {
+		return parseLooseRef(name, string(b))
+	}
+	if !errors.Is(err, os.ErrNotExist) && !isDirError(err) {
+		return nil, err
+	}
+	packed, err := s.readPackedRefs()
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range packed {
+		if ref.Name == name {
+			return &ref, nil
+		}
+	}
+	return nil, formatErrorRefNotFound(name)
+}
+
+func parseLooseRef(name string, content string) (*Ref, error) {
+	content = strings.TrimRight(content, "\n")
+	if target, found := strings.CutPrefix(content, symrefPrefix); found {
+		return &Ref{Name: name, Target: strings.TrimSpace(target)}, nil
+	}
+	if !repr.IsValidDigest(content) {
+		return nil, fmt.Errorf("%v: invalid ref content", name)
+	}
+	return &Ref{Name: name, Digest: content}, nil
+}
+
+// Follows symbolic refs starting from name and returns the name of the
+// final ref, which does not have to exist (e.g. HEAD on an unborn branch)
+func ResolveName(name string) (string, error) {
+	return Default.ResolveName(name)
+}
+
+func (s *Store) ResolveName(name string) (string, error) {
+	chain, err := s.symrefChain(name)
+	if err != nil {
+		return "", err
+	}
+	return chain[len(chain)-1], nil
+}
+
+// Follows symbolic refs starting from name and returns the object digest
+func Resolve(name string) (string, error) {
+	return Default.Resolve(name)
+}
+
+func (s *Store) Resolve(name string) (string, error) {
+	final, err := s.ResolveName(name)
+	if err != nil {
+		return "", err
+	}
+	ref, err := s.Read(final)
+	if err != nil {
+		return "", err
+	}
+	return ref.Digest, nil
+}
+
+// Commit HEAD points to, empty on an unborn branch
+func ResolveHead() (string, error) {
+	head, err := Resolve(constants.Head)
+	if errors.Is(err, ErrorRefNotFound) {
+		return "", nil
+	}
+	return head, err
+}
+
+// Names visited while following symbolic refs, ending with a non-symbolic one
+func symrefChain(name string) ([]string, error) {
+	return Default.symrefChain(name)
+}
+
+func (s *Store) symrefChain(name string) ([]string, error) {
+	chain := []string{name}
+	for i := 0; i < maxSymrefDepth; i += 1 {
+		ref, err := s.Read(name)
+		if errors.Is(err, ErrorRefNotFound) {
+			return chain, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if !ref.IsSymbolic() {
+			return chain, nil
+		}
+		name = ref.Target
+		chain = append(chain, name)
+	}
+	return nil, ErrorSymrefLoop
+}
+
+// Makes name a symbolic ref pointing to target
+func WriteSymbolic(name string, target string) error {
+	if !IsValidName(target) {
+		return formatErrorInvalidRefName(target)
+	}
+	return writeFileAtomically(refPath(name), []byte(symrefPrefix+target+"\n"))
+}
+
+// All refs under refs/ starting with prefix, sorted by name; loose refs
+// shadow packed ones
+func List(prefix string) ([]Ref, error) {
+	return Default.List(prefix)
+}
+
+func (s *Store) List(prefix string) ([]Ref, error) {
+	byName := make(map[string]Ref)
+	packed, err := s.readPackedRefs()
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range packed {
+		byName[ref.Name] = ref
+	}
+	root := filepath.Join(s.gitDir, constants.Refs)
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(path, ".lock") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.gitDir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		ref, err := parseLooseRef(name, string(b))
+		if err != nil {
+			return err
+		}
+		byName[name] = *ref
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var res []Ref
+	for name, ref := range byName {
+		if strings.HasPrefix(name, prefix) {
+			res = append(res, ref)
+		}
+	}
+	slices.SortFunc(res, func(a, b Ref) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return res, nil
+}
+
+func readPackedRefs() ([]Ref, error) {
+	return Default.readPackedRefs()
+}
+
+func (s *Store) readPackedRefs() ([]Ref, error) {
+	f, err := os.Open(filepath.Join(s.gitDir, constants.PackedRefs))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var res []Ref
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		if line[0] == '^' {
+			if len(res) == 0 {
+				return nil, fmt.Errorf("%v: unexpected peeled line", constants.PackedRefs)
+			}
+			res[len(res)-1].Peeled = line[1:]
+			continue
+		}
+		digest, name, found := strings.Cut(line, " ")
+		if !found || !repr.IsValidDigest(digest) {
+			return nil, fmt.Errorf("%v: malformed line %q", constants.PackedRefs, line)
+		}
+		res = append(res, Ref{Name: name, Digest: digest})
+	}
+	return res, sc.Err()
+}
+
+func writePackedRefs(refs []Ref) error {
+	var b strings.Builder
+	b.WriteString("# pack-refs with: peeled fully-peeled sorted \n")
+	for _, ref := range refs {
+		fmt.Fprintf(&b, "%s %s\n", ref.Digest, ref.Name)
+		if ref.Peeled != "" {
+			fmt.Fprintf(&b, "^%s\n", ref.Peeled)
+		}
+	}
+	return writeFileAtomically(filepath.Join(constants.Git, constants.PackedRefs), []byte(b.String()))
+}
+
+// Checks the rules of git check-ref-format for a full ref name
+func IsValidName(name string) bool {
+	if name == constants.Head {
+		return true
+	}
+	if !strings.HasPrefix(name, constants.Refs+"/") {
+		return false
+	}
+	if strings.HasSuffix(name, "/") || strings.HasSuffix(name, ".") ||
+		strings.Contains(name, "..") || strings.Contains(name, "@{") ||
+		strings.Contains(name, "//") || name == "@" {
+		return false
+	}
+	for _, component := range strings.Split(name, "/") {
+		if strings.HasPrefix(component, ".") || strings.HasSuffix(component, ".lock") {
+			return false
+		}
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(" ~^:?*[\\", r) {
+			return false
+		}
+	}
+	return true
+}
+
+func isDirError(err error) bool {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		info, statErr := os.Stat(pathErr.Path)
+		return statErr == nil && info.IsDir()
+	}
+	return false
+}
+
+// Writes through a lock file so that readers never observe partial content
+func writeFileAtomically(path string, content []byte) error {
+	lock, err := acquireLock(path)
+	if err != nil {
+		return err
+	}
+	return lock.commit(content)
+}
@@ -0,0 +1,189 @@
+package refs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/magnickolas/gitok/config"
+	"github.com/magnickolas/gitok/constants"
+	"github.com/magnickolas/gitok/repr"
+)
+
+var ErrorStaleRef = errors.New("ref changed concurrently")
+
+// Set of ref updates applied together: every ref is locked and its
+// expected old value verified before any of them is written, so a failed
+// check changes nothing. A failure while writing leaves the refs written
+// before it updated and the rest untouched
+type Transaction struct {
+	ident   repr.Signature
+	updates []refUpdate
+}
+
+type refUpdate struct {
+	name      string
+	newDigest string // empty to delete the ref
+	oldDigest string // empty to skip the check, zero digest if must not exist
+	message   string
+}
+
+// ident is recorded in the reflogs of the updated refs
+func NewTransaction(ident repr.Signature) *Transaction {
+	return &Transaction{ident: ident}
+}
+
+// Points name (after following symbolic refs) to newDigest. If oldDigest is
+// not empty the ref must currently have this value, the zero digest meaning
+// that the ref must not exist
+func (t *Transaction) Update(name string, newDigest string, oldDigest string, message string) {
+	t.updates = append(t.updates, refUpdate{
+		name:      name,
+		newDigest: newDigest,
+		oldDigest: oldDigest,
+		message:   message,
+	})
+}
+
+func (t *Transaction) Delete(name string, oldDigest string, message string) {
+	t.Update(name, "", oldDigest, message)
+}
+
+func (t *Transaction) Commit() error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	headTarget, err := ResolveName(constants.Head)
+	if err != nil {
+		return err
+	}
+	type lockedUpdate struct {
+		refUpdate
+		chain   []string
+		lock    *lockFile
+		current string
+	}
+	var locked []*lockedUpdate
+	rollbackFrom := func(updates []*lockedUpdate) {
+		for _, u := range updates {
+			u.lock.rollback()
+		}
+	}
+	rollback := func() {
+		rollbackFrom(locked)
+	}
+	for _, u := range t.updates {
+		chain, err := symrefChain(u.name)
+		if err != nil {
+			rollback()
+			return err
+		}
+		final := chain[len(chain)-1]
+		if !IsValidName(final) {
+			rollback()
+			return formatErrorInvalidRefName(final)
+		}
+		lock, err := acquireLock(refPath(final))
+		if err != nil {
+			rollback()
+			return err
+		}
+		lu := &lockedUpdate{refUpdate: u, chain: chain, lock: lock}
+		locked = append(locked, lu)
+		ref, err := Read(final)
+		if err == nil {
+			lu.current = ref.Digest
+		} else if !errors.Is(err, ErrorRefNotFound) {
+			rollback()
+			return err
+		}
+		if u.oldDigest != "" {
+			expected := u.oldDigest
+			if expected == repr.ZeroDigest() {
+				expected = ""
+			}
+			if lu.current != expected {
+				rollback()
+				return fmt.Errorf("%w: cannot lock ref '%v': expected %v, but is %v",
+					ErrorStaleRef, u.name, OrZero(expected), OrZero(lu.current))
+			}
+		}
+	}
+	// locks not yet released are rolled back on errors, so the refs
+	// after the failed one stay untouched
+	for i, u := range locked {
+		final := u.chain[len(u.chain)-1]
+		if u.newDigest == "" {
+			// the lock is held until the ref is gone from the packed refs too
+			err := deleteRef(final)
+			u.lock.rollback()
+			if err != nil {
+				rollbackFrom(locked[i+1:])
+				return err
+			}
+			for _, name := range u.chain {
+				if name != constants.Head {
+					if err := deleteReflog(name); err != nil {
+						rollbackFrom(locked[i+1:])
+						return err
+					}
+				}
+			}
+			continue
+		}
+		if err := u.lock.commit([]byte(u.newDigest + "\n")); err != nil {
+			rollbackFrom(locked[i:])
+			return err
+		}
+		logged := u.chain
+		// HEAD's log follows the branch it points to
+		if final == headTarget && !slices.Contains(logged, constants.Head) {
+			logged = append(logged, constants.Head)
+		}
+		for _, name := range logged {
+			if !shouldLog(name, cfg) {
+				continue
+			}
+			err := appendReflog(name, ReflogEntry{
+				OldDigest: OrZero(u.current),
+				NewDigest: u.newDigest,
+				Ident:     t.ident,
+				Message:   u.message,
+			})
+			if err != nil {
+				rollbackFrom(locked[i+1:])
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Removes both the loose and the packed copy of the ref
+func deleteRef(name string) error {
+	err := os.Remove(refPath(name))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	packed, err := readPackedRefs()
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(packed, func(ref Ref) bool {
+		return ref.Name == name
+	})
+	if i == -1 {
+		return nil
+	}
+	return writePackedRefs(slices.Delete(packed, i, i+1))
+}
+
+// The digest, or the zero digest for an empty one as of a missing ref
+func OrZero(digest string) string {
+	if digest == "" {
+		return repr.ZeroDigest()
+	}
+	return digest
+}
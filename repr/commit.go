@@ -0,0 +1,192 @@
+package repr
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type Commit struct {
+	LazyObject
+	tree      string
+	parents   []string
+	author    Signature
+	committer Signature
+	// headers other than tree/parent/author/committer (e.g. encoding, gpgsig)
+	extraHeaders []CommitHeader
+	message      string
+}
+
+type CommitHeader struct {
+	Key   string
+	Value string
+}
+
+func NewCommit(r io.Reader) (*Commit, error) {
+	return new(Commit).Init(r)
+}
+
+func NewCommitFromFields(
+	tree string,
+	parents []string,
+	author Signature,
+	committer Signature,
+	message string,
+) *Commit {
+	c := &Commit{
+		tree:      tree,
+		parents:   parents,
+		author:    author,
+		committer: committer,
+		message:   message,
+	}
+	c.raw = c.Raw()
+	return c
+}
+
+func (c *Commit) Init(r io.Reader) (*Commit, error) {
+	buf := new(bytes.Buffer)
+	_, err := buf.ReadFrom(r)
+	if err != nil {
+		return nil, err
+	}
+	content := buf.Bytes()
+	headers, message, err := parseHeaders(content)
+	if err != nil {
+		return nil, err
+	}
+	seenTree, seenAuthor, seenCommitter := false, false, false
+	for _, h := range headers {
+		switch h.Key {
+		case "tree":
+			if seenTree || !IsValidDigest(h.Value) {
+				return nil, ErrorCorruptedObject
+			}
+			c.tree, seenTree = h.Value, true
+		case "parent":
+			if !IsValidDigest(h.Value) {
+				return nil, ErrorCorruptedObject
+			}
+			c.parents = append(c.parents, h.Value)
+		case "author":
+			if c.author, err = ParseSignature(h.Value); err != nil {
+				return nil, err
+			}
+			seenAuthor = true
+		case "committer":
+			if c.committer, err = ParseSignature(h.Value); err != nil {
+				return nil, err
+			}
+			seenCommitter = true
+		default:
+			c.extraHeaders = append(c.extraHeaders, h)
+		}
+	}
+	if !seenTree || !seenAuthor || !seenCommitter {
+		return nil, ErrorCorruptedObject
+	}
+	c.message = message
+	// keep the original bytes so that the digest survives non-canonical input
+	c.raw = append(c.raw, fmt.Sprintf("commit %d", len(content))...)
+	c.raw = append(c.raw, 0)
+	c.raw = append(c.raw, content...)
+	return c, nil
+}
+
+func (c *Commit) Raw() []byte {
+	if c.raw == nil {
+		var content []byte
+		content = append(content, fmt.Sprintf("tree %s\n", c.tree)...)
+		for _, parent := range c.parents {
+			content = append(content, fmt.Sprintf("parent %s\n", parent)...)
+		}
+		content = append(content, fmt.Sprintf("author %s\n", c.author)...)
+		content = append(content, fmt.Sprintf("committer %s\n", c.committer)...)
+		content = appendHeaders(content, c.extraHeaders)
+		content = append(content, '\n')
+		content = append(content, c.message...)
+		c.raw = append(c.raw, fmt.Sprintf("commit %d", len(content))...)
+		c.raw = append(c.raw, 0)
+		c.raw = append(c.raw, content...)
+	}
+	return c.raw
+}
+
+func (c *Commit) String() string {
+	return string(StripObjectHeader(c))
+}
+
+func (c *Commit) Type() string {
+	return "commit"
+}
+
+func (c *Commit) TreeDigest() string {
+	return c.tree
+}
+
+func (c *Commit) Parents() []string {
+	return c.parents
+}
+
+func (c *Commit) Author() Signature {
+	return c.author
+}
+
+func (c *Commit) Committer() Signature {
+	return c.committer
+}
+
+func (c *Commit) ExtraHeaders() []CommitHeader {
+	return c.extraHeaders
+}
+
+func (c *Commit) Message() string {
+	return c.message
+}
+
+// First line of the message
+func (c *Commit) Subject() string {
+	subject, _, _ := strings.Cut(strings.TrimLeft(c.message, "\n"), "\n")
+	return subject
+}
+
+// Splits "key value\n" headers (values may continue on lines starting with
+// a space) from the message that follows an empty line
+func parseHeaders(content []byte) ([]CommitHeader, string, error) {
+	var headers []CommitHeader
+	for len(content) > 0 {
+		if content[0] == '\n' {
+			return headers, string(content[1:]), nil
+		}
+		line, rest, found := bytes.Cut(content, []byte{'\n'})
+		if !found {
+			return nil, "", ErrorCorruptedObject
+		}
+		content = rest
+		if line[0] == ' ' {
+			if len(headers) == 0 {
+				return nil, "", ErrorCorruptedObject
+			}
+			last := &headers[len(headers)-1]
+			last.Value += "\n" + string(line[1:])
+			continue
+		}
+		key, value, found := bytes.Cut(line, []byte{' '})
+		if !found {
+			return nil, "", ErrorCorruptedObject
+		}
+		headers = append(headers, CommitHeader{Key: string(key), Value: string(value)})
+	}
+	return headers, "", nil
+}
+
+func appendHeaders(b []byte, headers []CommitHeader) []byte {
+	for _, h := range headers {
+		b = append(b, h.Key...)
+		b = append(b, ' ')
+		b = append(b, strings.ReplaceAll(h.Value, "\n", "\n ")...)
+		b = append(b, '\n')
+	}
+	return b
+}
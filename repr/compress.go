@@ -5,15 +5,17 @@ import (
 	"compress/zlib"
 )
 
-func compress(raw []byte) ([]byte, error) {
+// Compresses the parts of an object one after the other
+func compress(parts ...[]byte) ([]byte, error) {
 	buf := new(bytes.Buffer)
 	zw := zlib.NewWriter(buf)
-	_, err := zw.Write(raw)
-	if err != nil {
-		return nil, err
+	for _, part := range parts {
+		if _, err := zw.Write(part); err != nil {
+			return nil, err
+		}
 	}
 	// close so that the checksum is written to the buffer
-	err = zw.Close()
+	err := zw.Close()
 	if err != nil {
 		return nil, err
 	}
@@ -17,4 +17,20 @@ var (
 	formatErrorUnknownFileMode = func(mode string) error {
 		return fmt.Errorf("%w: %v", ErrorUnknownFileMode, mode)
 	}
+	// tree problems, in the order git's fsck reports them
+	ErrorNullSha1           = errors.New("contains entries pointing to null sha1")
+	ErrorFullPathname       = errors.New("contains full pathnames")
+	ErrorEmptyName          = errors.New("contains empty pathname")
+	ErrorHasDot             = errors.New("contains '.'")
+	ErrorHasDotdot          = errors.New("contains '..'")
+	ErrorHasDotgit          = errors.New("contains '.git'")
+	ErrorZeroPaddedFilemode = errors.New("contains zero-padded file modes")
+	ErrorBadFilemode        = errors.New("contains bad file modes")
+	ErrorDuplicateEntries   = fmt.Errorf("%w: contains duplicate file entries", ErrorCorruptedObject)
+	ErrorTreeNotSorted      = fmt.Errorf("%w: not properly sorted", ErrorCorruptedObject)
+	ErrorBadTree            = fmt.Errorf("%w: cannot be parsed as a tree", ErrorCorruptedObject)
+	ErrorBadSignature       = errors.New("malformed identity")
+	formatErrorBadSignature = func(line string) error {
+		return fmt.Errorf("%w: %q", ErrorBadSignature, line)
+	}
 )
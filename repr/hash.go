@@ -4,6 +4,10 @@ import (
 	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
 )
 
 func HashSize() int {
@@ -14,6 +18,29 @@ func HashSize() int {
 	}
 }
 
+func NewHasher() hash.Hash {
+	if true { // TODO: parse hash type from config
+		return sha1.New()
+	} else {
+		return sha256.New()
+	}
+}
+
+// Digest of an object of known size whose content is read from r, without
+// holding the content in memory
+func HashStream(objType string, size int64, r io.Reader) (string, error) {
+	h := NewHasher()
+	fmt.Fprintf(h, "%s %d\x00", objType, size)
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return "", err
+	}
+	if n != size {
+		return "", ErrorSizeNotMatch
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
 func hasherHex(data []byte) string {
 	if true { // TODO: parse hash type from config
 		digest := sha1.Sum(data)
@@ -23,3 +50,20 @@ func hasherHex(data []byte) string {
 		return hex.EncodeToString(digest[:])
 	}
 }
+
+func IsValidDigest(s string) bool {
+	if len(s) != 2*HashSize() {
+		return false
+	}
+	for _, r := range s {
+		if !('0' <= r && r <= '9') && !('a' <= r && r <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Digest of all zeros, used for "no object" in reflogs and ref updates
+func ZeroDigest() string {
+	return strings.Repeat("0", 2*HashSize())
+}
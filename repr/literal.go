@@ -0,0 +1,36 @@
+package repr
+
+import (
+	"fmt"
+)
+
+// Object of an arbitrary type whose content is not validated, as created by
+// hash-object --literally
+type LiteralObject struct {
+	LazyObject
+	objectType string
+	content    []byte
+}
+
+func NewLiteralObject(objectType string, content []byte) *LiteralObject {
+	o := &LiteralObject{objectType: objectType, content: content}
+	o.raw = o.Raw()
+	return o
+}
+
+func (o *LiteralObject) Raw() []byte {
+	if o.raw == nil {
+		o.raw = append(o.raw, fmt.Sprintf("%s %d", o.objectType, len(o.content))...)
+		o.raw = append(o.raw, 0)
+		o.raw = append(o.raw, o.content...)
+	}
+	return o.raw
+}
+
+func (o *LiteralObject) String() string {
+	return string(o.content)
+}
+
+func (o *LiteralObject) Type() string {
+	return o.objectType
+}
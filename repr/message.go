@@ -0,0 +1,28 @@
+package repr
+
+import (
+	"strings"
+)
+
+// Strips trailing whitespace from lines, leading and trailing empty lines
+// and collapses runs of empty lines (git's "whitespace" cleanup mode)
+func CleanupMessage(message string) string {
+	var lines []string
+	emptyRun := false
+	for _, line := range strings.Split(message, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" {
+			emptyRun = len(lines) > 0
+			continue
+		}
+		if emptyRun {
+			lines = append(lines, "")
+			emptyRun = false
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
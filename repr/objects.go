@@ -3,9 +3,11 @@ package repr
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"slices"
+	"strings"
 )
 
 type Object interface {
@@ -51,6 +53,9 @@ func (lo *LazyObject) Digest() string {
 // verify interface compliance
 var _ Object = (*Blob)(nil)
 var _ Object = (*Tree)(nil)
+var _ Object = (*Commit)(nil)
+var _ Object = (*Tag)(nil)
+var _ Object = (*LiteralObject)(nil)
 
 type Blob struct {
 	LazyObject
@@ -61,6 +66,8 @@ func NewBlob(r io.Reader) (*Blob, error) {
 	return new(Blob).Init(r)
 }
 
+// Reads the content, keeping it as the only copy: the raw form is built
+// only if asked for, the digest and compressed form are computed without it
 func (b *Blob) Init(r io.Reader) (*Blob, error) {
 	buf := new(bytes.Buffer)
 	_, err := buf.ReadFrom(r)
@@ -68,45 +75,83 @@ func (b *Blob) Init(r io.Reader) (*Blob, error) {
 		return nil, err
 	}
 	b.content = buf.Bytes()
-	b.raw = b.Raw()
 	return b, nil
 }
 
+func (b *Blob) header() []byte {
+	return fmt.Appendf(nil, "blob %d\x00", len(b.content))
+}
+
 func (b *Blob) Raw() []byte {
 	if b.raw == nil {
-		b.raw = append(b.raw, fmt.Sprintf("b %d", len(b.content))...)
-		b.raw = append(b.raw, 0)
-		b.raw = append(b.raw, b.content...)
+		b.raw = append(b.header(), b.content...)
 	}
 	return b.raw
 }
 
+func (b *Blob) Digest() string {
+	if b.lazyDigest == "" {
+		h := NewHasher()
+		h.Write(b.header())
+		h.Write(b.content)
+		b.lazyDigest = hex.EncodeToString(h.Sum(nil))
+	}
+	return b.lazyDigest
+}
+
+func (b *Blob) Compressed() ([]byte, error) {
+	if b.lazyCompressed == nil {
+		var err error
+		b.lazyCompressed, err = compress(b.header(), b.content)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b.lazyCompressed, nil
+}
+
 func (b *Blob) String() string {
 	return string(b.content)
 }
 
+func (b *Blob) Content() []byte {
+	return b.content
+}
+
 func (b *Blob) Type() string {
 	return "blob"
 }
 
 type Tree struct {
 	LazyObject
-	children []nodeType
+	children []TreeEntry
 }
 
-type nodeType struct {
-	name   string
-	mode   ObjectModeType
-	digest string
+type TreeEntry struct {
+	Name   string
+	Mode   ObjectModeType
+	Digest string
 }
 
-func (n *nodeType) getType() string {
-	if n.mode == ModeTree {
+func (n *TreeEntry) Type() string {
+	switch n.Mode {
+	case ModeTree:
 		return "tree"
+	case ModeGitlink:
+		return "commit"
 	}
 	return "blob"
 }
 
+// Name used for ordering entries inside a tree: git compares directories
+// as if their names had a trailing slash
+func (n *TreeEntry) sortName() string {
+	if n.Mode == ModeTree {
+		return n.Name + "/"
+	}
+	return n.Name
+}
+
 type ObjectModeType string
 
 const (
@@ -114,46 +159,64 @@ const (
 	ModeExecutable   ObjectModeType = "100755"
 	ModeSymbolicLink ObjectModeType = "120000"
 	ModeTree         ObjectModeType = "40000"
+	// commit of a submodule
+	ModeGitlink ObjectModeType = "160000"
 )
 
-var modes = []ObjectModeType{ModeNormal, ModeExecutable, ModeSymbolicLink, ModeTree}
-
 func NewTree(r io.Reader) (*Tree, error) {
 	return new(Tree).Init(r)
 }
 
+// Builds a tree out of the given entries, sorting them in git order
+func NewTreeFromEntries(entries []TreeEntry) *Tree {
+	t := new(Tree)
+	t.children = slices.Clone(entries)
+	slices.SortFunc(t.children, func(a, b TreeEntry) int {
+		return strings.Compare(a.sortName(), b.sortName())
+	})
+	t.raw = t.Raw()
+	return t
+}
+
 func (t *Tree) Init(r io.Reader) (*Tree, error) {
 	buf := new(bytes.Buffer)
 	_, err := buf.ReadFrom(r)
 	if err != nil {
 		return nil, err
 	}
-	b := buf.Bytes()
-	for len(b) > 0 {
-		parts := bytes.SplitN(b, []byte{' '}, 2)
-		if len(parts) != 2 {
-			return nil, ErrorCorruptedObject
-		}
-		var mode ObjectModeType
-		mode, b = ObjectModeType(parts[0]), parts[1]
-		if !slices.Contains[[]ObjectModeType](modes, mode) {
-			return nil, formatErrorUnknownFileMode(string(mode))
-		}
-		parts = bytes.SplitN(b, []byte{0}, 2)
-		if len(parts) != 2 {
-			return nil, ErrorCorruptedObject
+	content := buf.Bytes()
+	entries, err := parseTreeEntries(content)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		mode, err := canonicalMode(entry.mode)
+		if err != nil {
+			return nil, err
 		}
-		var name string
-		name, b = string(parts[0]), parts[1]
-		var digest string
-		digest, b = hex.EncodeToString(b[:HashSize()]), b[HashSize():]
-		t.children = append(t.children, nodeType{
-			name:   name,
-			mode:   mode,
-			digest: digest,
+		t.children = append(t.children, TreeEntry{
+			Name:   entry.name,
+			Mode:   mode,
+			Digest: entry.digest,
 		})
 	}
-	t.raw = t.Raw()
+	// keep the original bytes so that the digest survives non-canonical modes
+	t.raw = append(t.raw, fmt.Sprintf("tree %d", len(content))...)
+	t.raw = append(t.raw, 0)
+	t.raw = append(t.raw, content...)
+	return t, nil
+}
+
+// Like NewTree, but also rejects everything git's fsck complains about in
+// strict mode
+func NewTreeStrict(r io.Reader) (*Tree, error) {
+	t, err := NewTree(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := errors.Join(CheckTree(StripObjectHeader(t), true)...); err != nil {
+		return nil, err
+	}
 	return t, nil
 }
 
@@ -162,14 +225,14 @@ func (t *Tree) Raw() []byte {
 		var entries []byte
 		for _, child := range t.children {
 			var rawDigest []byte
-			rawDigest, _ = hex.DecodeString(child.digest)
-			entries = append(entries, child.mode...)
+			rawDigest, _ = hex.DecodeString(child.Digest)
+			entries = append(entries, child.Mode...)
 			entries = append(entries, ' ')
-			entries = append(entries, child.name...)
+			entries = append(entries, child.Name...)
 			entries = append(entries, 0)
 			entries = append(entries, rawDigest...)
 		}
-		t.raw = append(t.raw, fmt.Sprintf("t %d", len(entries))...)
+		t.raw = append(t.raw, fmt.Sprintf("tree %d", len(entries))...)
 		t.raw = append(t.raw, 0)
 		t.raw = append(t.raw, entries...)
 	}
@@ -180,10 +243,10 @@ func (t *Tree) String() (res string) {
 	for _, child := range t.children {
 		res += fmt.Sprintf(
 			"%06s %s %s\t%s\n",
-			child.mode,
-			child.getType(),
-			child.digest,
-			child.name,
+			child.Mode,
+			child.Type(),
+			child.Digest,
+			child.Name,
 		)
 	}
 	return
@@ -192,3 +255,7 @@ func (t *Tree) String() (res string) {
 func (t *Tree) Type() string {
 	return "tree"
 }
+
+func (t *Tree) Entries() []TreeEntry {
+	return t.children
+}
@@ -2,6 +2,7 @@ package repr
 
 import (
 	"bytes"
+	"io"
 	"strconv"
 )
 
@@ -32,13 +33,20 @@ func ParseObject(compressed []byte) (Object, error) {
 		return nil, ErrorSizeNotMatch
 	}
 
-	r := bytes.NewReader(content)
+	return NewObject(objType, bytes.NewReader(content))
+}
 
+// Parses and validates the content of an object of the given type
+func NewObject(objType string, r io.Reader) (Object, error) {
 	switch objType {
 	case "blob":
 		return NewBlob(r)
 	case "tree":
 		return NewTree(r)
+	case "commit":
+		return NewCommit(r)
+	case "tag":
+		return NewTag(r)
 	}
 	return nil, formatErrorUnknownObjectType(objType)
 }
@@ -0,0 +1,69 @@
+package repr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Identity line used in commits and tags ("Name <email> 1700000000 +0100")
+type Signature struct {
+	Name  string
+	Email string
+	When  time.Time
+}
+
+func (s Signature) String() string {
+	return fmt.Sprintf("%s <%s> %d %s", s.Name, s.Email, s.When.Unix(), s.When.Format("-0700"))
+}
+
+func ParseSignature(line string) (Signature, error) {
+	lt := strings.IndexByte(line, '<')
+	if lt == -1 {
+		return Signature{}, formatErrorBadSignature(line)
+	}
+	gt := strings.IndexByte(line[lt:], '>')
+	if gt == -1 {
+		return Signature{}, formatErrorBadSignature(line)
+	}
+	gt += lt
+	sig := Signature{
+		Name:  strings.TrimSpace(line[:lt]),
+		Email: line[lt+1 : gt],
+	}
+	fields := strings.Fields(line[gt+1:])
+	if len(fields) == 0 {
+		sig.When = time.Unix(0, 0).UTC()
+		return sig, nil
+	}
+	ts, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return Signature{}, formatErrorBadSignature(line)
+	}
+	loc := time.UTC
+	if len(fields) > 1 {
+		loc, err = ParseTimezone(fields[1])
+		if err != nil {
+			return Signature{}, formatErrorBadSignature(line)
+		}
+	}
+	sig.When = time.Unix(ts, 0).In(loc)
+	return sig, nil
+}
+
+// Parses a "+hhmm" / "-hhmm" timezone offset
+func ParseTimezone(tz string) (*time.Location, error) {
+	if len(tz) != 5 || (tz[0] != '+' && tz[0] != '-') {
+		return nil, fmt.Errorf("invalid timezone %q", tz)
+	}
+	hhmm, err := strconv.Atoi(tz[1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q", tz)
+	}
+	offset := (hhmm/100)*3600 + (hhmm%100)*60
+	if tz[0] == '-' {
+		offset = -offset
+	}
+	return time.FixedZone("", offset), nil
+}
@@ -0,0 +1,122 @@
+package repr
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+)
+
+var objectTypes = []string{"blob", "tree", "commit", "tag"}
+
+type Tag struct {
+	LazyObject
+	object     string
+	objectType string
+	name       string
+	// tags created by old git versions may have no tagger
+	tagger       *Signature
+	extraHeaders []CommitHeader
+	message      string
+}
+
+func NewTag(r io.Reader) (*Tag, error) {
+	return new(Tag).Init(r)
+}
+
+func NewTagFromFields(object string, objectType string, name string, tagger Signature, message string) *Tag {
+	t := &Tag{
+		object:     object,
+		objectType: objectType,
+		name:       name,
+		tagger:     &tagger,
+		message:    message,
+	}
+	t.raw = t.Raw()
+	return t
+}
+
+func (t *Tag) Init(r io.Reader) (*Tag, error) {
+	buf := new(bytes.Buffer)
+	_, err := buf.ReadFrom(r)
+	if err != nil {
+		return nil, err
+	}
+	content := buf.Bytes()
+	headers, message, err := parseHeaders(content)
+	if err != nil {
+		return nil, err
+	}
+	// object, type and tag are mandatory and must come first in this order
+	if len(headers) < 3 || headers[0].Key != "object" || headers[1].Key != "type" || headers[2].Key != "tag" {
+		return nil, ErrorCorruptedObject
+	}
+	t.object, t.objectType, t.name = headers[0].Value, headers[1].Value, headers[2].Value
+	if !IsValidDigest(t.object) || !slices.Contains(objectTypes, t.objectType) || t.name == "" {
+		return nil, ErrorCorruptedObject
+	}
+	for _, h := range headers[3:] {
+		if h.Key == "tagger" && t.tagger == nil {
+			tagger, err := ParseSignature(h.Value)
+			if err != nil {
+				return nil, err
+			}
+			t.tagger = &tagger
+			continue
+		}
+		t.extraHeaders = append(t.extraHeaders, h)
+	}
+	t.message = message
+	t.raw = append(t.raw, fmt.Sprintf("tag %d", len(content))...)
+	t.raw = append(t.raw, 0)
+	t.raw = append(t.raw, content...)
+	return t, nil
+}
+
+func (t *Tag) Raw() []byte {
+	if t.raw == nil {
+		var content []byte
+		content = append(content, fmt.Sprintf("object %s\n", t.object)...)
+		content = append(content, fmt.Sprintf("type %s\n", t.objectType)...)
+		content = append(content, fmt.Sprintf("tag %s\n", t.name)...)
+		if t.tagger != nil {
+			content = append(content, fmt.Sprintf("tagger %s\n", t.tagger)...)
+		}
+		content = appendHeaders(content, t.extraHeaders)
+		content = append(content, '\n')
+		content = append(content, t.message...)
+		t.raw = append(t.raw, fmt.Sprintf("tag %d", len(content))...)
+		t.raw = append(t.raw, 0)
+		t.raw = append(t.raw, content...)
+	}
+	return t.raw
+}
+
+func (t *Tag) String() string {
+	return string(StripObjectHeader(t))
+}
+
+func (t *Tag) Type() string {
+	return "tag"
+}
+
+// Digest of the tagged object
+func (t *Tag) Object() string {
+	return t.object
+}
+
+func (t *Tag) ObjectType() string {
+	return t.objectType
+}
+
+func (t *Tag) Name() string {
+	return t.name
+}
+
+func (t *Tag) Tagger() *Signature {
+	return t.tagger
+}
+
+func (t *Tag) Message() string {
+	return t.message
+}
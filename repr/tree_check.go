@@ -0,0 +1,104 @@
+package repr
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// Tree entry as stored, before any normalization
+type rawTreeEntry struct {
+	mode   string
+	name   string
+	digest string
+}
+
+func parseTreeEntries(content []byte) ([]rawTreeEntry, error) {
+	var res []rawTreeEntry
+	for b := content; len(b) > 0; {
+		sp := bytes.IndexByte(b, ' ')
+		nul := bytes.IndexByte(b, 0)
+		if sp <= 0 || nul < sp || len(b) < nul+1+HashSize() {
+			return nil, ErrorBadTree
+		}
+		res = append(res, rawTreeEntry{
+			mode:   string(b[:sp]),
+			name:   string(b[sp+1 : nul]),
+			digest: hex.EncodeToString(b[nul+1 : nul+1+HashSize()]),
+		})
+		b = b[nul+1+HashSize():]
+	}
+	return res, nil
+}
+
+// Mode git reads a stored mode as: permissions other than the executable
+// bit are dropped and unknown file types are taken for gitlinks
+func canonicalMode(mode string) (ObjectModeType, error) {
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return "", formatErrorUnknownFileMode(mode)
+	}
+	switch m & 0o170000 {
+	case 0o100000:
+		if m&0o100 != 0 {
+			return ModeExecutable, nil
+		}
+		return ModeNormal, nil
+	case 0o120000:
+		return ModeSymbolicLink, nil
+	case 0o040000:
+		return ModeTree, nil
+	}
+	return ModeGitlink, nil
+}
+
+// Problems of the tree content the way git's fsck finds them, each kind
+// reported once. Outside of strict mode the 100664 mode written by ancient
+// git versions is tolerated
+func CheckTree(content []byte, strict bool) []error {
+	entries, err := parseTreeEntries(content)
+	if err != nil {
+		return []error{ErrorBadTree}
+	}
+	found := make(map[error]bool)
+	seen := make(map[string]bool)
+	prev := ""
+	for _, entry := range entries {
+		mode, name := ObjectModeType(entry.mode), entry.name
+		switch {
+		case mode == ModeNormal, mode == ModeExecutable, mode == ModeSymbolicLink,
+			mode == ModeTree, mode == ModeGitlink:
+		case mode == "100664" && !strict:
+		case strings.HasPrefix(entry.mode, "0"):
+			found[ErrorZeroPaddedFilemode] = true
+		default:
+			found[ErrorBadFilemode] = true
+		}
+		found[ErrorNullSha1] = found[ErrorNullSha1] || entry.digest == ZeroDigest()
+		found[ErrorFullPathname] = found[ErrorFullPathname] || strings.Contains(name, "/")
+		found[ErrorEmptyName] = found[ErrorEmptyName] || name == ""
+		found[ErrorHasDot] = found[ErrorHasDot] || name == "."
+		found[ErrorHasDotdot] = found[ErrorHasDotdot] || name == ".."
+		found[ErrorHasDotgit] = found[ErrorHasDotgit] || strings.EqualFold(name, ".git")
+		found[ErrorDuplicateEntries] = found[ErrorDuplicateEntries] || seen[name]
+		seen[name] = true
+		sortName := name
+		if canonical, err := canonicalMode(entry.mode); err == nil && canonical == ModeTree {
+			sortName += "/"
+		}
+		found[ErrorTreeNotSorted] = found[ErrorTreeNotSorted] || prev > sortName
+		prev = sortName
+	}
+	var res []error
+	for _, err := range []error{
+		ErrorNullSha1, ErrorFullPathname, ErrorEmptyName, ErrorHasDot, ErrorHasDotdot,
+		ErrorHasDotgit, ErrorZeroPaddedFilemode, ErrorBadFilemode,
+		ErrorDuplicateEntries, ErrorTreeNotSorted,
+	} {
+		if found[err] {
+			res = append(res, err)
+		}
+	}
+	return res
+}
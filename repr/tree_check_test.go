@@ -0,0 +1,65 @@
+package repr_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/magnickolas/gitok/repr"
+)
+
+// Raw tree content out of "mode name" entries, all pointing to the same blob
+func rawTree(entries ...string) []byte {
+	digest := bytes.Repeat([]byte{0xab}, repr.HashSize())
+	var res []byte
+	for _, entry := range entries {
+		res = append(res, entry...)
+		res = append(res, 0)
+		res = append(res, digest...)
+	}
+	return res
+}
+
+func TestCheckTree(t *testing.T) {
+	tests := []struct {
+		content []byte
+		strict  bool
+		want    []error
+	}{
+		{content: rawTree("100644 a", "40000 b", "160000 c")},
+		{content: rawTree("100644 a", "100644 a"), want: []error{repr.ErrorDuplicateEntries}},
+		{content: rawTree("100644 b", "100644 a"), want: []error{repr.ErrorTreeNotSorted}},
+		// directories sort as if they had a trailing slash
+		{content: rawTree("100644 a.c", "40000 a")},
+		{content: rawTree("40000 a", "100644 a.c"), want: []error{repr.ErrorTreeNotSorted}},
+		{content: rawTree("100644 .git"), want: []error{repr.ErrorHasDotgit}},
+		{content: rawTree("100644 a/b"), want: []error{repr.ErrorFullPathname}},
+		{content: rawTree("100644 "), want: []error{repr.ErrorEmptyName}},
+		{content: rawTree("040000 a"), want: []error{repr.ErrorZeroPaddedFilemode}},
+		{content: rawTree("100664 a")},
+		{content: rawTree("100664 a"), strict: true, want: []error{repr.ErrorBadFilemode}},
+		{content: rawTree("100644 a")[:20], want: []error{repr.ErrorBadTree}},
+	}
+	for _, test := range tests {
+		got := repr.CheckTree(test.content, test.strict)
+		if len(got) != len(test.want) {
+			t.Errorf("incorrect result for %#v: wanted %v, got %v", string(test.content), test.want, got)
+			continue
+		}
+		for i := range got {
+			if !errors.Is(got[i], test.want[i]) {
+				t.Errorf("incorrect result for %#v: wanted %v, got %v", string(test.content), test.want, got)
+			}
+		}
+	}
+}
+
+func TestNewTreeTruncated(t *testing.T) {
+	content := rawTree("100644 a")
+	for n := 1; n < len(content); n += 1 {
+		_, err := repr.NewTree(bytes.NewReader(content[:n]))
+		if !errors.Is(err, repr.ErrorBadTree) {
+			t.Errorf("incorrect result for %#v: wanted %v, got %v", string(content[:n]), repr.ErrorBadTree, err)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package revparse
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/magnickolas/gitok/constants"
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/refs"
+	"github.com/magnickolas/gitok/repr"
+)
+
+var (
+	ErrorUnknownRevision       = errors.New("unknown revision")
+	formatErrorUnknownRevision = func(rev string) error {
+		return fmt.Errorf("%w: %v", ErrorUnknownRevision, rev)
+	}
+	ErrorAmbiguousRevision       = errors.New("ambiguous revision")
+	formatErrorAmbiguousRevision = func(rev string) error {
+		return fmt.Errorf("%w: %v", ErrorAmbiguousRevision, rev)
+	}
+)
+
+const minAbbrev = 4
+
+// Order in which a short ref name is looked up, as in git rev-parse
+var refRules = []string{
+	"%s",
+	"refs/%s",
+	"refs/tags/%s",
+	"refs/heads/%s",
+	"refs/remotes/%s",
+	"refs/remotes/%s/HEAD",
+}
+
+// Resolves a revision such as "HEAD~2", "master^2", "v1.0^{tree}",
+// "1a2b3c" or "HEAD:path/to/file" to an object digest
+func Resolve(rev string) (string, error) {
+	if base, path, found := strings.Cut(rev, ":"); found {
+		treeish, err := Resolve(base)
+		if err != nil {
+			return "", err
+		}
+		return lookupPath(treeish, path)
+	}
+	i := strings.IndexAny(rev, "^~")
+	base, suffixes := rev, ""
+	if i != -1 {
+		base, suffixes = rev[:i], rev[i:]
+	}
+	digest, err := resolveBase(base)
+	if err != nil {
+		return "", err
+	}
+	for len(suffixes) > 0 {
+		op := suffixes[0]
+		suffixes = suffixes[1:]
+		if op == '^' && strings.HasPrefix(suffixes, "{") {
+			end := strings.IndexByte(suffixes, '}')
+			if end == -1 {
+				return "", formatErrorUnknownRevision(rev)
+			}
+			objType := suffixes[1:end]
+			suffixes = suffixes[end+1:]
+			if digest, err = Peel(digest, objType); err != nil {
+				return "", err
+			}
+			continue
+		}
+		j := 0
+		for j < len(suffixes) && '0' <= suffixes[j] && suffixes[j] <= '9' {
+			j += 1
+		}
+		n := 1
+		if j > 0 {
+			n, _ = strconv.Atoi(suffixes[:j])
+		}
+		suffixes = suffixes[j:]
+		if op == '^' {
+			digest, err = nthParent(digest, n)
+		} else {
+			for k := 0; k < n && err == nil; k += 1 {
+				digest, err = nthParent(digest, 1)
+			}
+		}
+		if err != nil {
+			return "", fmt.Errorf("%v: %w", rev, err)
+		}
+	}
+	return digest, nil
+}
+
+func resolveBase(base string) (string, error) {
+	if base == "" || base == "@" {
+		base = constants.Head
+	}
+	if repr.IsValidDigest(base) {
+		return base, nil
+	}
+	for _, rule := range refRules {
+		digest, err := refs.Resolve(fmt.Sprintf(rule, base))
+		if err == nil {
+			return digest, nil
+		} else if !errors.Is(err, refs.ErrorRefNotFound) {
+			return "", err
+		}
+	}
+	if len(base) >= minAbbrev && isHex(base) {
+		matches, err := fs.FindObjectsByPrefix(base)
+		if err != nil {
+			return "", err
+		}
+		if len(matches) == 1 {
+			return matches[0], nil
+		} else if len(matches) > 1 {
+			return "", formatErrorAmbiguousRevision(base)
+		}
+	}
+	return "", formatErrorUnknownRevision(base)
+}
+
+// Full name of the ref that a short name stands for, looked up in the
+// same order as revisions
+func ExpandRef(name string) (string, error) {
+	var found []string
+	for _, rule := range refRules {
+		full := fmt.Sprintf(rule, name)
+		_, err := refs.Resolve(full)
+		if err == nil {
+			found = append(found, full)
+		} else if !errors.Is(err, refs.ErrorRefNotFound) {
+			return "", err
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", formatErrorUnknownRevision(name)
+	case 1:
+		return found[0], nil
+	}
+	return "", formatErrorAmbiguousRevision(name)
+}
+
+// Dereferences the object until it is of the requested type; an empty type
+// peels tags to whatever they point to
+func Peel(digest string, objType string) (string, error) {
+	for {
+		o, err := fs.ReadObject(digest)
+		if err != nil {
+			return "", err
+		}
+		if o.Type() == objType || (objType == "" && o.Type() != "tag") {
+			return digest, nil
+		}
+		switch v := o.(type) {
+		case *repr.Tag:
+			digest = v.Object()
+		case *repr.Commit:
+			if objType != "tree" {
+				return "", fmt.Errorf("%v: cannot peel commit to %v", digest, objType)
+			}
+			digest = v.TreeDigest()
+		default:
+			return "", fmt.Errorf("%v: cannot peel %v to %v", digest, o.Type(), objType)
+		}
+	}
+}
+
+func nthParent(digest string, n int) (string, error) {
+	digest, err := Peel(digest, "commit")
+	if err != nil {
+		return "", err
+	}
+	if n == 0 {
+		return digest, nil
+	}
+	o, err := fs.ReadObject(digest)
+	if err != nil {
+		return "", err
+	}
+	parents := o.(*repr.Commit).Parents()
+	if n > len(parents) {
+		return "", fmt.Errorf("commit %v has no parent #%d", digest, n)
+	}
+	return parents[n-1], nil
+}
+
+// Digest of the entry at path inside the tree-ish
+func lookupPath(treeish string, path string) (string, error) {
+	digest, err := Peel(treeish, "tree")
+	if err != nil {
+		return "", err
+	}
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return digest, nil
+	}
+	for _, component := range strings.Split(path, "/") {
+		o, err := fs.ReadObject(digest)
+		if err != nil {
+			return "", err
+		}
+		tree, ok := o.(*repr.Tree)
+		if !ok {
+			return "", fmt.Errorf("path '%v' does not exist in '%v'", path, treeish)
+		}
+		found := false
+		for _, entry := range tree.Entries() {
+			if entry.Name == component {
+				digest, found = entry.Digest, true
+				break
+			}
+		}
+		if !found {
+			return "", fmt.Errorf("path '%v' does not exist in '%v'", path, treeish)
+		}
+	}
+	return digest, nil
+}
+
+func isHex(s string) bool {
+	for _, r := range s {
+		if !('0' <= r && r <= '9') && !('a' <= r && r <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,219 @@
+package revwalk
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/magnickolas/gitok/diff"
+	"github.com/magnickolas/gitok/repr"
+)
+
+// Commits that are uninteresting but were hidden explicitly still count
+// when simplifying merges
+func relevant(n *node) bool {
+	return n.flags&uninteresting == 0 || n.flags&bottom != 0
+}
+
+func (w *Walker) sameTrees(oldTree string, newTree string) (bool, error) {
+	changes, err := diff.Trees(w.g.db, oldTree, newTree, w.ps)
+	if err != nil {
+		return false, err
+	}
+	return len(changes) == 0, nil
+}
+
+// Marks the commit TREESAME when it does not change the paths; a merge
+// whose paths are the same as in a relevant parent is rewritten to have
+// only that parent, so that the history of the other sides is not walked
+func (w *Walker) simplify(n *node) error {
+	if w.ps == nil {
+		return nil
+	}
+	if err := w.g.parse(n); err != nil {
+		return err
+	}
+	if len(n.parents) == 0 {
+		same, err := w.sameTrees("", n.tree)
+		if err != nil {
+			return err
+		}
+		if same {
+			n.flags |= treesame
+		}
+		return nil
+	}
+	relevantParents, relevantChange, irrelevantChange := 0, false, false
+	for i, p := range n.parents {
+		if i > 0 && w.opts.FirstParent {
+			break
+		}
+		if err := w.g.parse(p); err != nil {
+			return err
+		}
+		if relevant(p) {
+			relevantParents += 1
+		}
+		same, err := w.sameTrees(p.tree, n.tree)
+		if err != nil {
+			return err
+		}
+		switch {
+		case same && relevant(p):
+			n.parents = []*node{p}
+			n.flags |= treesame
+			return nil
+		case same:
+			// the other sides of a merge with an uninteresting branch that
+			// brought the whole change are still walked
+		case relevant(p):
+			relevantChange = true
+		default:
+			irrelevantChange = true
+		}
+	}
+	// irrelevant parents cannot make a merge with relevant ones changed
+	changed := irrelevantChange
+	if relevantParents > 0 {
+		changed = relevantChange
+	}
+	if changed {
+		n.flags &^= treesame
+	} else {
+		n.flags |= treesame
+	}
+	return nil
+}
+
+// Whether the commit passes the path and grep filters
+func (w *Walker) shows(n *node) (bool, error) {
+	if w.ps != nil && n.flags&treesame != 0 {
+		return false, nil
+	}
+	if len(w.opts.Authors) == 0 && len(w.opts.Committers) == 0 && len(w.opts.Greps) == 0 {
+		return true, nil
+	}
+	if err := w.g.load(n); err != nil {
+		return false, err
+	}
+	return w.matches(n.commit) != w.opts.InvertGrep, nil
+}
+
+// Whether the commit is shown if the walk reaches it, regardless of the
+// number of commits shown
+func (w *Walker) Interesting(digest string) (bool, error) {
+	n := w.g.get(digest)
+	if err := w.g.parse(n); err != nil {
+		return false, err
+	}
+	if n.flags&uninteresting != 0 || w.tooOld(n) || w.tooNew(n) {
+		return false, nil
+	}
+	return w.shows(n)
+}
+
+// The only relevant parent of a merge, or the only parent otherwise; nil
+// for merges with several or no relevant parents
+func (w *Walker) relevantParent(n *node) *node {
+	if len(n.parents) == 0 {
+		return nil
+	}
+	if w.opts.FirstParent || len(n.parents) == 1 {
+		return n.parents[0]
+	}
+	var res *node
+	for _, p := range n.parents {
+		if !relevant(p) {
+			continue
+		}
+		if res != nil {
+			return nil
+		}
+		res = p
+	}
+	return res
+}
+
+// Replaces the parents of the commit left out by the paths with their
+// nearest ancestors that are not; parents without such ancestors are
+// dropped
+func (w *Walker) rewriteParents(n *node) error {
+	if !w.opts.RewriteParents || w.ps == nil {
+		return nil
+	}
+	var res []*node
+	for _, p := range n.parents {
+		for p != nil {
+			if !w.limited {
+				if err := w.g.parse(p); err != nil {
+					return err
+				}
+				if err := w.processParents(p); err != nil {
+					return err
+				}
+			}
+			if p.flags&uninteresting != 0 || p.flags&treesame == 0 {
+				break
+			}
+			if len(p.parents) == 0 {
+				p = nil
+				break
+			}
+			next := w.relevantParent(p)
+			if next == nil {
+				break
+			}
+			p = next
+		}
+		if p != nil && !slices.Contains(res, p) {
+			res = append(res, p)
+		}
+	}
+	n.parents = res
+	return nil
+}
+
+func identity(s repr.Signature) string {
+	return s.Name + " <" + s.Email + ">"
+}
+
+func matchAny(patterns []*regexp.Regexp, line string) bool {
+	for _, re := range patterns {
+		if re.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authors and committers match if any of their patterns does, the message
+// if any of its lines matches any pattern (or each pattern some line with
+// AllMatch); all three have to match
+func (w *Walker) matches(c *repr.Commit) bool {
+	if len(w.opts.Authors) > 0 && !matchAny(w.opts.Authors, identity(c.Author())) {
+		return false
+	}
+	if len(w.opts.Committers) > 0 && !matchAny(w.opts.Committers, identity(c.Committer())) {
+		return false
+	}
+	if len(w.opts.Greps) == 0 {
+		return true
+	}
+	lines := strings.Split(c.Message(), "\n")
+	for _, re := range w.opts.Greps {
+		found := false
+		for _, line := range lines {
+			if re.MatchString(line) {
+				found = true
+				break
+			}
+		}
+		if found && !w.opts.AllMatch {
+			return true
+		}
+		if !found && w.opts.AllMatch {
+			return false
+		}
+	}
+	return w.opts.AllMatch
+}
@@ -0,0 +1,278 @@
+package revwalk
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+
+	"github.com/magnickolas/gitok/commitgraph"
+	"github.com/magnickolas/gitok/config"
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/repr"
+)
+
+var (
+	ErrorNotCommit       = errors.New("not a commit")
+	formatErrorNotCommit = func(digest string, objType string) error {
+		return fmt.Errorf("%w: object %v is a %v", ErrorNotCommit, digest, objType)
+	}
+)
+
+type flag uint
+
+const (
+	seen flag = 1 << iota
+	uninteresting
+	// parents were queued
+	added
+	// on the path between a bottom and a tip
+	ancestry
+	// no change to the limiting paths relative to the parents
+	treesame
+	// hidden on the command line
+	bottom
+	// merge base computation
+	parent1
+	parent2
+	stale
+	result
+)
+
+type node struct {
+	digest string
+	// nil until the commit object is read, which the commit graph spares
+	// when only the parents, tree and date are needed
+	commit *repr.Commit
+	// whether the parents, tree, date and generation are known
+	parsed     bool
+	tree       string
+	commitDate int64
+	// from the commit graph, commitgraph.Infinity for commits not in it
+	generation uint64
+	// parents followed by the walk, possibly simplified to a single one
+	parents []*node
+	flags   flag
+	// children in the list being topologically sorted, plus one
+	indegree int
+}
+
+func (n *node) date() int64 {
+	return n.commitDate
+}
+
+// Needs the commit object loaded
+func (n *node) authorDate() int64 {
+	return n.commit.Author().When.Unix()
+}
+
+// Commits of an object database, parsed on demand
+type graph struct {
+	db *fs.ObjectDB
+	// nil if the repository has no usable commit graph
+	cg    *commitgraph.Graph
+	nodes map[string]*node
+}
+
+func newGraph(db *fs.ObjectDB) *graph {
+	g := &graph{db: db, nodes: make(map[string]*node)}
+	// a commit graph that cannot be read is only a missed speedup
+	cfg, err := config.Load()
+	if err != nil {
+		return g
+	}
+	if enabled, err := cfg.GetBool("core.commitGraph", true); err == nil && enabled {
+		g.cg, _ = commitgraph.Open(db.Dir())
+	}
+	return g
+}
+
+func (g *graph) get(digest string) *node {
+	n, ok := g.nodes[digest]
+	if !ok {
+		n = &node{digest: digest}
+		g.nodes[digest] = n
+	}
+	return n
+}
+
+// Finds the parents, tree and date of the commit, from the commit graph
+// if it has the commit
+func (g *graph) parse(n *node) error {
+	if n.parsed {
+		return nil
+	}
+	if g.cg != nil {
+		c, ok, err := g.cg.Lookup(n.digest)
+		if err != nil {
+			return err
+		}
+		if ok {
+			g.setParents(n, c.Parents)
+			n.tree, n.commitDate, n.generation = c.Tree, c.Date, c.Generation
+			n.parsed = true
+			return nil
+		}
+	}
+	commit, err := g.db.ReadCommit(n.digest)
+	if err != nil {
+		return fmt.Errorf("could not parse commit %v: %w", n.digest, err)
+	}
+	g.setCommit(n, commit)
+	return nil
+}
+
+// Parses the commit and reads its object, for what the commit graph does
+// not have
+func (g *graph) load(n *node) error {
+	if err := g.parse(n); err != nil {
+		return err
+	}
+	if n.commit != nil {
+		return nil
+	}
+	commit, err := g.db.ReadCommit(n.digest)
+	if err != nil {
+		return fmt.Errorf("could not parse commit %v: %w", n.digest, err)
+	}
+	n.commit = commit
+	return nil
+}
+
+func (g *graph) setCommit(n *node, commit *repr.Commit) {
+	n.commit = commit
+	g.setParents(n, commit.Parents())
+	n.tree, n.commitDate = commit.TreeDigest(), commit.Committer().When.Unix()
+	n.generation = commitgraph.Infinity
+	n.parsed = true
+}
+
+func (g *graph) setParents(n *node, parents []string) {
+	n.parents = make([]*node, len(parents))
+	for i, digest := range parents {
+		n.parents[i] = g.get(digest)
+	}
+}
+
+// Parsed node of the commit, following tags
+func (g *graph) peel(digest string) (*node, error) {
+	for {
+		o, err := g.db.ReadObject(digest)
+		if err != nil {
+			return nil, err
+		}
+		switch v := o.(type) {
+		case *repr.Commit:
+			n := g.get(digest)
+			// the commit graph has the generation
+			if !n.parsed && g.cg != nil && g.cg.Contains(digest) {
+				if err := g.parse(n); err != nil {
+					return nil, err
+				}
+			}
+			if !n.parsed {
+				g.setCommit(n, v)
+			} else if n.commit == nil {
+				n.commit = v
+			}
+			return n, nil
+		case *repr.Tag:
+			digest = v.Object()
+		default:
+			return nil, formatErrorNotCommit(digest, o.Type())
+		}
+	}
+}
+
+func (g *graph) parents(n *node) ([]*node, error) {
+	if err := g.parse(n); err != nil {
+		return nil, err
+	}
+	return n.parents, nil
+}
+
+// Priority queue of parsed commits; without a comparison function it is a
+// stack, otherwise ties are broken by insertion order
+type queue struct {
+	less  func(a, b *node) bool
+	items []queueItem
+	ctr   int
+}
+
+type queueItem struct {
+	n   *node
+	ctr int
+}
+
+func newDateQueue() *queue {
+	return &queue{less: func(a, b *node) bool { return a.date() > b.date() }}
+}
+
+// Queue of the commits of the highest generation first, then the newest
+func newGenerationQueue() *queue {
+	return &queue{less: func(a, b *node) bool {
+		if a.generation != b.generation {
+			return a.generation > b.generation
+		}
+		return a.date() > b.date()
+	}}
+}
+
+func (q *queue) Len() int { return len(q.items) }
+
+func (q *queue) Less(i, j int) bool {
+	a, b := q.items[i], q.items[j]
+	if q.less(a.n, b.n) {
+		return true
+	}
+	if q.less(b.n, a.n) {
+		return false
+	}
+	return a.ctr < b.ctr
+}
+
+func (q *queue) Swap(i, j int) { q.items[i], q.items[j] = q.items[j], q.items[i] }
+
+func (q *queue) Push(x any) { q.items = append(q.items, x.(queueItem)) }
+
+func (q *queue) Pop() any {
+	last := q.items[len(q.items)-1]
+	q.items = q.items[:len(q.items)-1]
+	return last
+}
+
+func (q *queue) put(n *node) {
+	item := queueItem{n: n, ctr: q.ctr}
+	q.ctr += 1
+	if q.less == nil {
+		q.items = append(q.items, item)
+		return
+	}
+	heap.Push(q, item)
+}
+
+func (q *queue) get() *node {
+	if len(q.items) == 0 {
+		return nil
+	}
+	if q.less == nil {
+		return q.Pop().(queueItem).n
+	}
+	return heap.Pop(q).(queueItem).n
+}
+
+func (q *queue) peek() *node {
+	if len(q.items) == 0 {
+		return nil
+	}
+	if q.less == nil {
+		return q.items[len(q.items)-1].n
+	}
+	return q.items[0].n
+}
+
+// Reverses a stack so that items come out in insertion order
+func (q *queue) reverse() {
+	for i, j := 0, len(q.items)-1; i < j; i, j = i+1, j-1 {
+		q.items[i], q.items[j] = q.items[j], q.items[i]
+	}
+}
@@ -0,0 +1,316 @@
+package revwalk
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/refs"
+	"github.com/magnickolas/gitok/repr"
+)
+
+// Best common ancestors of one and any of the others: common ancestors not
+// reachable from another common ancestor, newest first
+func MergeBases(db *fs.ObjectDB, one string, others ...string) ([]string, error) {
+	g := newGraph(db)
+	bases, err := g.mergeBases(one, others)
+	if err != nil {
+		return nil, err
+	}
+	return digests(bases), nil
+}
+
+// Best common ancestors of all the commits together, as needed to merge
+// them at once: the merge bases of each commit with those of the ones
+// before it, without duplicates and ones reachable from others
+func OctopusMergeBases(db *fs.ObjectDB, commits []string) ([]string, error) {
+	if len(commits) == 0 {
+		return nil, nil
+	}
+	g := newGraph(db)
+	res := commits[:1]
+	for _, commit := range commits[1:] {
+		var next []string
+		for _, base := range res {
+			bases, err := g.mergeBases(commit, []string{base})
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, digests(bases)...)
+		}
+		res = next
+	}
+	var nodes []*node
+	for _, digest := range res {
+		n, err := g.peel(digest)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(nodes, n) {
+			nodes = append(nodes, n)
+		}
+	}
+	nodes, err := g.removeRedundant(nodes)
+	if err != nil {
+		return nil, err
+	}
+	return digests(nodes), nil
+}
+
+// Whether ancestor is reachable from any of the commits (or is one of
+// them). The walk stops at the generation of the ancestor
+func IsAncestor(db *fs.ObjectDB, ancestor string, commits ...string) (bool, error) {
+	g := newGraph(db)
+	n, err := g.peel(ancestor)
+	if err != nil {
+		return false, err
+	}
+	twos := make([]*node, len(commits))
+	maxGeneration := uint64(0)
+	for i, commit := range commits {
+		if twos[i], err = g.peel(commit); err != nil {
+			return false, err
+		}
+		maxGeneration = max(maxGeneration, twos[i].generation)
+	}
+	if n.generation > maxGeneration {
+		return false, nil
+	}
+	if _, err := g.paintDownToCommon(n, twos, n.generation); err != nil {
+		return false, err
+	}
+	return n.flags&parent2 != 0, nil
+}
+
+// Where the commit forked from the history of the ref, as recorded in its
+// reflog (or the ref itself without one): the merge base of the commit and
+// the past values of the ref, if it is one of them. The name has to be
+// the full name of the ref
+func ForkPoint(db *fs.ObjectDB, refname string, commit string) (string, bool, error) {
+	entries, err := refs.ReadReflog(refname)
+	if err != nil {
+		return "", false, err
+	}
+	var candidates []string
+	if len(entries) > 0 {
+		candidates = append(candidates, entries[0].OldDigest)
+	}
+	for _, entry := range entries {
+		candidates = append(candidates, entry.NewDigest)
+	}
+	g := newGraph(db)
+	var past []string
+	add := func(digest string) error {
+		if digest == repr.ZeroDigest() || slices.Contains(past, digest) {
+			return nil
+		}
+		// values that are gone or are not commits are skipped
+		if _, err := g.peel(digest); err != nil {
+			if errors.Is(err, ErrorNotCommit) || !db.HasObject(digest) {
+				return nil
+			}
+			return err
+		}
+		past = append(past, digest)
+		return nil
+	}
+	for _, digest := range candidates {
+		if err := add(digest); err != nil {
+			return "", false, err
+		}
+	}
+	if len(past) == 0 {
+		digest, err := refs.Resolve(refname)
+		if err != nil {
+			return "", false, err
+		}
+		if err := add(digest); err != nil {
+			return "", false, err
+		}
+	}
+	bases, err := g.mergeBases(commit, past)
+	if err != nil {
+		return "", false, err
+	}
+	if len(bases) != 1 || !slices.Contains(past, bases[0].digest) {
+		return "", false, nil
+	}
+	return bases[0].digest, true, nil
+}
+
+func digests(nodes []*node) []string {
+	res := make([]string, len(nodes))
+	for i, n := range nodes {
+		res[i] = n.digest
+	}
+	return res
+}
+
+func (g *graph) mergeBases(one string, others []string) ([]*node, error) {
+	n, err := g.peel(one)
+	if err != nil {
+		return nil, err
+	}
+	twos := make([]*node, len(others))
+	for i, other := range others {
+		if twos[i], err = g.peel(other); err != nil {
+			return nil, err
+		}
+		if twos[i] == n {
+			return []*node{n}, nil
+		}
+	}
+	common, err := g.paintDownToCommon(n, twos, 0)
+	if err != nil {
+		return nil, err
+	}
+	var res []*node
+	for _, c := range common {
+		if c.flags&stale == 0 {
+			res = append(res, c)
+		}
+	}
+	g.clearFlags(parent1 | parent2 | stale | result)
+	if len(res) > 1 {
+		if res, err = g.removeRedundant(res); err != nil {
+			return nil, err
+		}
+	}
+	slices.SortStableFunc(res, func(a, b *node) int {
+		switch {
+		case a.date() > b.date():
+			return -1
+		case a.date() < b.date():
+			return 1
+		}
+		return 0
+	})
+	return res, nil
+}
+
+// Walks down from one and twos, newest first, until only commits known to
+// be reachable from a common ancestor remain, returning the common
+// ancestors found. Commits of a lower generation than minGeneration are
+// not walked; with it or with corrected commit dates in the commit graph,
+// the commits are walked by generation, then by date
+func (g *graph) paintDownToCommon(one *node, twos []*node, minGeneration uint64) ([]*node, error) {
+	q := newDateQueue()
+	if minGeneration != 0 || g.cg != nil && g.cg.CorrectedDates() {
+		q = newGenerationQueue()
+	}
+	one.flags |= parent1
+	q.put(one)
+	for _, two := range twos {
+		two.flags |= parent2
+		q.put(two)
+	}
+	var res []*node
+	for hasNonStale(q) {
+		n := q.get()
+		if n.generation < minGeneration {
+			break
+		}
+		flags := n.flags & (parent1 | parent2 | stale)
+		if flags == parent1|parent2 {
+			if n.flags&result == 0 {
+				n.flags |= result
+				res = append(res, n)
+			}
+			flags |= stale
+		}
+		parents, err := g.parents(n)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range parents {
+			if p.flags&flags == flags {
+				continue
+			}
+			if err := g.parse(p); err != nil {
+				return nil, err
+			}
+			p.flags |= flags
+			q.put(p)
+		}
+	}
+	return res, nil
+}
+
+func hasNonStale(q *queue) bool {
+	for _, item := range q.items {
+		if item.n.flags&stale == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Drops the candidates that are ancestors of other candidates
+func (g *graph) removeRedundant(candidates []*node) ([]*node, error) {
+	redundant := make([]bool, len(candidates))
+	for i, n := range candidates {
+		if redundant[i] {
+			continue
+		}
+		for j, other := range candidates {
+			if i == j || redundant[j] {
+				continue
+			}
+			reachable, err := g.isAncestor(other, n)
+			if err != nil {
+				return nil, err
+			}
+			if reachable {
+				redundant[j] = true
+			}
+		}
+	}
+	var res []*node
+	for i, n := range candidates {
+		if !redundant[i] {
+			res = append(res, n)
+		}
+	}
+	return res, nil
+}
+
+// Whether ancestor is reachable from n; commits of a lower generation than
+// the ancestor cannot reach it and are not walked
+func (g *graph) isAncestor(ancestor *node, n *node) (bool, error) {
+	if err := g.parse(ancestor); err != nil {
+		return false, err
+	}
+	visited := map[*node]bool{n: true}
+	stack := []*node{n}
+	for len(stack) > 0 {
+		cur := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if cur == ancestor {
+			return true, nil
+		}
+		parents, err := g.parents(cur)
+		if err != nil {
+			return false, err
+		}
+		for _, p := range parents {
+			if visited[p] {
+				continue
+			}
+			visited[p] = true
+			if err := g.parse(p); err != nil {
+				return false, err
+			}
+			if p.generation < ancestor.generation {
+				continue
+			}
+			stack = append(stack, p)
+		}
+	}
+	return false, nil
+}
+
+func (g *graph) clearFlags(flags flag) {
+	for _, n := range g.nodes {
+		n.flags &^= flags
+	}
+}
@@ -0,0 +1,162 @@
+package revwalk_test
+
+import (
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/gitok_init"
+	"github.com/magnickolas/gitok/refs"
+	"github.com/magnickolas/gitok/repr"
+	"github.com/magnickolas/gitok/revwalk"
+)
+
+// c and d branch off b and are merged both ways by e and f; g branches off
+// a and h merges it into e
+var mergeHistory = []commitSpec{
+	{name: "a", date: 100, authorDate: 100},
+	{name: "b", date: 200, authorDate: 200, parents: []string{"a"}},
+	{name: "c", date: 300, authorDate: 300, parents: []string{"b"}},
+	{name: "d", date: 310, authorDate: 310, parents: []string{"b"}},
+	{name: "e", date: 400, authorDate: 400, parents: []string{"c", "d"}},
+	{name: "f", date: 410, authorDate: 410, parents: []string{"d", "c"}},
+	{name: "g", date: 320, authorDate: 320, parents: []string{"a"}},
+	{name: "h", date: 500, authorDate: 500, parents: []string{"e", "g"}},
+}
+
+// Digests of the commits of the history by their names
+func commitDigests(digests map[string]string, names []string) []string {
+	var res []string
+	for _, name := range names {
+		res = append(res, digests[name])
+	}
+	return res
+}
+
+func TestMergeBases(t *testing.T) {
+	db := fs.OpenObjectDB(t.TempDir())
+	digests := writeHistory(t, db, mergeHistory)
+	tests := []struct {
+		commits []string
+		octopus bool
+		want    []string
+	}{
+		{commits: []string{"e", "f"}, want: []string{"d", "c"}},
+		{commits: []string{"c", "d", "g"}, want: []string{"b"}},
+		{commits: []string{"h", "f"}, want: []string{"d", "c"}},
+		{commits: []string{"g", "c"}, want: []string{"a"}},
+		{commits: []string{"c", "d", "g"}, octopus: true, want: []string{"a"}},
+		{commits: []string{"e", "f"}, octopus: true, want: []string{"d", "c"}},
+		{commits: []string{"e", "f", "h"}, octopus: true, want: []string{"d", "c"}},
+		{commits: []string{"c"}, octopus: true, want: []string{"c"}},
+	}
+	for _, test := range tests {
+		commits := commitDigests(digests, test.commits)
+		var res []string
+		var err error
+		if test.octopus {
+			res, err = revwalk.OctopusMergeBases(db, commits)
+		} else {
+			res, err = revwalk.MergeBases(db, commits[0], commits[1:]...)
+		}
+		if err != nil {
+			t.Errorf("failed to find the merge bases of %#v: %v", test.commits, err)
+			continue
+		}
+		if got := commitNames(digests, res); !slices.Equal(got, test.want) {
+			t.Errorf("incorrect result for %#v: wanted %#v, got %#v", test.commits, test.want, got)
+		}
+	}
+}
+
+func TestIsAncestor(t *testing.T) {
+	db := fs.OpenObjectDB(t.TempDir())
+	digests := writeHistory(t, db, mergeHistory)
+	tests := []struct {
+		ancestor, commit string
+		want             bool
+	}{
+		{ancestor: "b", commit: "e", want: true},
+		{ancestor: "e", commit: "f", want: false},
+		{ancestor: "c", commit: "c", want: true},
+		{ancestor: "g", commit: "h", want: true},
+		{ancestor: "g", commit: "f", want: false},
+		{ancestor: "a", commit: "h", want: true},
+	}
+	for _, test := range tests {
+		res, err := revwalk.IsAncestor(db, digests[test.ancestor], digests[test.commit])
+		if err != nil {
+			t.Errorf("failed to check %v against %v: %v", test.ancestor, test.commit, err)
+			continue
+		}
+		if res != test.want {
+			t.Errorf("incorrect result for %#v: wanted %#v, got %#v", test.ancestor+" "+test.commit, test.want, res)
+		}
+	}
+}
+
+func TestForkPoint(t *testing.T) {
+	dir := t.TempDir()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	if err := gitok_init.InitRepo("main"); err != nil {
+		t.Fatal(err)
+	}
+	// c was made on top of b before main was rewound to a and moved on to
+	// d; e is unrelated
+	digests := writeHistory(t, fs.Default, []commitSpec{
+		{name: "a", date: 100, authorDate: 100},
+		{name: "b", date: 200, authorDate: 200, parents: []string{"a"}},
+		{name: "c", date: 300, authorDate: 300, parents: []string{"b"}},
+		{name: "d", date: 400, authorDate: 400, parents: []string{"a"}},
+		{name: "e", date: 500, authorDate: 500},
+	})
+	ident := repr.Signature{Name: "a", Email: "a@b", When: time.Unix(1700000000, 0).UTC()}
+	old := repr.ZeroDigest()
+	for _, name := range []string{"a", "b", "d"} {
+		tx := refs.NewTransaction(ident)
+		tx.Update("refs/heads/main", digests[name], old, "test")
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		old = digests[name]
+	}
+	// tags have no reflog, so only their value counts
+	tx := refs.NewTransaction(ident)
+	tx.Update("refs/tags/other", digests["b"], repr.ZeroDigest(), "test")
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ref, commit string
+		want        string
+	}{
+		{ref: "refs/heads/main", commit: "c", want: "b"},
+		{ref: "refs/heads/main", commit: "e", want: ""},
+		{ref: "refs/tags/other", commit: "c", want: "b"},
+	}
+	for _, test := range tests {
+		res, ok, err := revwalk.ForkPoint(fs.Default, test.ref, digests[test.commit])
+		if err != nil {
+			t.Errorf("failed to find the fork point of %v from %v: %v", test.commit, test.ref, err)
+			continue
+		}
+		got := ""
+		if ok {
+			got = commitNames(digests, []string{res})[0]
+		}
+		if got != test.want {
+			t.Errorf("incorrect result for %#v: wanted %#v, got %#v", test.ref+" "+test.commit, test.want, got)
+		}
+	}
+}
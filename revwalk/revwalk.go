@@ -0,0 +1,567 @@
+package revwalk
+
+import (
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/magnickolas/gitok/constants"
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/pathspec"
+	"github.com/magnickolas/gitok/refs"
+	"github.com/magnickolas/gitok/revparse"
+)
+
+var ErrorNoBottoms = errors.New("--ancestry-path given but there are no bottom commits")
+
+type Sort int
+
+const (
+	// commits are shown as they are reached, newest first
+	SortNone Sort = iota
+	// no parent before all of its children, keeping lines of history together
+	SortTopo
+	// no parent before all of its children, otherwise by commit date
+	SortDate
+	// no parent before all of its children, otherwise by author date
+	SortAuthorDate
+)
+
+type Options struct {
+	Sort        Sort
+	Reverse     bool
+	FirstParent bool
+	// negative for no limit
+	MaxCount int
+	Skip     int
+	// zero for no limit
+	Since time.Time
+	Until time.Time
+	// only the commits that are descendants of an excluded commit
+	AncestryPath bool
+	// only the commits changing these paths, following a parent the result
+	// of a merge came from unchanged
+	Paths []string
+	// only the commits whose author, committer and message lines match
+	Authors    []*regexp.Regexp
+	Committers []*regexp.Regexp
+	Greps      []*regexp.Regexp
+	// every message pattern has to match, not any
+	AllMatch bool
+	// only the commits that do not match
+	InvertGrep bool
+	// parents of the commits shown skip the ones left out by the paths, as
+	// needed to draw the history
+	RewriteParents bool
+}
+
+// Extra commits looked at once only uninteresting ones remain, against
+// clock skew
+const slop = 5
+
+// Walks the history from the pushed commits, leaving out the commits
+// reachable from the hidden ones
+type Walker struct {
+	g    *graph
+	opts Options
+	// nil when not limited to paths
+	ps       *pathspec.Pathspec
+	tips     []*node
+	bottoms  []*node
+	limited  bool
+	prepared bool
+	q        *queue
+	// commits left to show once prepared, when not walking incrementally
+	list     []*node
+	fromList bool
+	shown    int
+}
+
+func NewWalker(db *fs.ObjectDB, opts Options) *Walker {
+	w := &Walker{
+		g:       newGraph(db),
+		opts:    opts,
+		limited: opts.Sort != SortNone || opts.AncestryPath,
+	}
+	if len(opts.Paths) > 0 {
+		w.ps = pathspec.New(opts.Paths)
+	}
+	return w
+}
+
+// Starts the walk from the commit (or tag pointing to one)
+func (w *Walker) Push(digest string) error {
+	n, err := w.g.peel(digest)
+	if err != nil {
+		return err
+	}
+	w.tips = append(w.tips, n)
+	return nil
+}
+
+// Leaves out the commit and all of its ancestors
+func (w *Walker) Hide(digest string) error {
+	n, err := w.g.peel(digest)
+	if err != nil {
+		return err
+	}
+	n.flags |= uninteresting | bottom
+	w.tips = append(w.tips, n)
+	w.bottoms = append(w.bottoms, n)
+	w.limited = true
+	return nil
+}
+
+// Adds a revision as given on the command line: "rev", "^rev", "a..b",
+// "a...b" (either side defaulting to HEAD), "rev^@" for the parents of rev
+// and "rev^!" for rev without its parents
+func (w *Walker) AddRevision(arg string) error {
+	if a, b, found := strings.Cut(arg, "..."); found {
+		a, b = orHead(a), orHead(b)
+		da, err := revparse.Resolve(a)
+		if err != nil {
+			return err
+		}
+		db, err := revparse.Resolve(b)
+		if err != nil {
+			return err
+		}
+		bases, err := MergeBases(w.g.db, da, db)
+		if err != nil {
+			return err
+		}
+		for _, base := range bases {
+			if err := w.Hide(base); err != nil {
+				return err
+			}
+		}
+		if err := w.Push(da); err != nil {
+			return err
+		}
+		return w.Push(db)
+	}
+	if a, b, found := strings.Cut(arg, ".."); found {
+		da, err := revparse.Resolve(orHead(a))
+		if err != nil {
+			return err
+		}
+		db, err := revparse.Resolve(orHead(b))
+		if err != nil {
+			return err
+		}
+		if err := w.Hide(da); err != nil {
+			return err
+		}
+		return w.Push(db)
+	}
+	if rest, found := strings.CutPrefix(arg, "^"); found {
+		digest, err := revparse.Resolve(rest)
+		if err != nil {
+			return err
+		}
+		return w.Hide(digest)
+	}
+	if rest, found := strings.CutSuffix(arg, "^@"); found {
+		return w.addParents(rest, w.Push)
+	}
+	if rest, found := strings.CutSuffix(arg, "^!"); found {
+		digest, err := revparse.Resolve(rest)
+		if err != nil {
+			return err
+		}
+		if err := w.Push(digest); err != nil {
+			return err
+		}
+		return w.addParents(rest, w.Hide)
+	}
+	digest, err := revparse.Resolve(arg)
+	if err != nil {
+		return err
+	}
+	return w.Push(digest)
+}
+
+func orHead(rev string) string {
+	if rev == "" {
+		return constants.Head
+	}
+	return rev
+}
+
+func (w *Walker) addParents(rev string, add func(string) error) error {
+	digest, err := revparse.Resolve(rev)
+	if err != nil {
+		return err
+	}
+	n, err := w.g.peel(digest)
+	if err != nil {
+		return err
+	}
+	for _, parent := range n.commit.Parents() {
+		if err := add(parent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Pushes every ref and HEAD, skipping those not pointing to commits
+func (w *Walker) PushAll() error {
+	all, err := refs.List("refs/")
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(all)+1)
+	for _, ref := range all {
+		names = append(names, ref.Name)
+	}
+	names = append(names, constants.Head)
+	for _, name := range names {
+		digest, err := refs.Resolve(name)
+		if errors.Is(err, refs.ErrorRefNotFound) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := w.Push(digest); err != nil && !errors.Is(err, ErrorNotCommit) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parents of the commit that the walk follows
+func (w *Walker) Parents(digest string) ([]string, error) {
+	n := w.g.get(digest)
+	if err := w.g.parse(n); err != nil {
+		return nil, err
+	}
+	var res []string
+	for i, p := range n.parents {
+		if i > 0 && w.opts.FirstParent {
+			break
+		}
+		res = append(res, p.digest)
+	}
+	return res, nil
+}
+
+// Digest of the next commit of the walk, or io.EOF when done
+func (w *Walker) Next() (string, error) {
+	if !w.prepared {
+		if err := w.prepare(); err != nil {
+			return "", err
+		}
+	}
+	if w.opts.MaxCount >= 0 && w.shown >= w.opts.MaxCount {
+		return "", io.EOF
+	}
+	n, err := w.next()
+	if err != nil {
+		return "", err
+	}
+	w.shown += 1
+	return n.digest, nil
+}
+
+// All the remaining commits of the walk
+func (w *Walker) All() ([]string, error) {
+	var res []string
+	for {
+		digest, err := w.Next()
+		if errors.Is(err, io.EOF) {
+			return res, nil
+		} else if err != nil {
+			return nil, err
+		}
+		res = append(res, digest)
+	}
+}
+
+func (w *Walker) prepare() error {
+	w.prepared = true
+	w.q = newDateQueue()
+	for _, n := range w.tips {
+		if n.flags&seen == 0 {
+			n.flags |= seen
+			w.q.put(n)
+		}
+	}
+	if w.limited {
+		if err := w.limitList(); err != nil {
+			return err
+		}
+		if w.opts.Sort != SortNone {
+			if err := w.sortTopologically(); err != nil {
+				return err
+			}
+		}
+		w.fromList = true
+	}
+	for i := 0; i < w.opts.Skip; i += 1 {
+		if _, err := w.next(); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	if w.opts.Reverse {
+		var reversed []*node
+		for w.opts.MaxCount < 0 || len(reversed) < w.opts.MaxCount {
+			n, err := w.next()
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return err
+			}
+			reversed = append([]*node{n}, reversed...)
+		}
+		w.list, w.fromList = reversed, true
+	}
+	return nil
+}
+
+func (w *Walker) next() (*node, error) {
+	if w.fromList {
+		for len(w.list) > 0 {
+			n := w.list[0]
+			w.list = w.list[1:]
+			if n.flags&uninteresting != 0 {
+				continue
+			}
+			if ok, err := w.shows(n); err != nil {
+				return nil, err
+			} else if ok {
+				return n, w.rewriteParents(n)
+			}
+		}
+		return nil, io.EOF
+	}
+	for {
+		n := w.q.get()
+		if n == nil {
+			return nil, io.EOF
+		}
+		if w.tooOld(n) {
+			continue
+		}
+		if err := w.processParents(n); err != nil {
+			return nil, err
+		}
+		if n.flags&uninteresting != 0 || w.tooNew(n) {
+			continue
+		}
+		if ok, err := w.shows(n); err != nil {
+			return nil, err
+		} else if ok {
+			return n, w.rewriteParents(n)
+		}
+	}
+}
+
+func (w *Walker) tooOld(n *node) bool {
+	return !w.opts.Since.IsZero() && n.date() < w.opts.Since.Unix()
+}
+
+func (w *Walker) tooNew(n *node) bool {
+	return !w.opts.Until.IsZero() && n.date() > w.opts.Until.Unix()
+}
+
+// Queues the parents of the commit, passing uninteresting on to all of
+// them and, transitively, to their already parsed ancestors
+func (w *Walker) processParents(n *node) error {
+	if n.flags&added != 0 {
+		return nil
+	}
+	n.flags |= added
+	if n.flags&uninteresting == 0 {
+		if err := w.simplify(n); err != nil {
+			return err
+		}
+	}
+	parents, err := w.g.parents(n)
+	if err != nil {
+		return err
+	}
+	for i, p := range parents {
+		if n.flags&uninteresting != 0 {
+			p.flags |= uninteresting
+			if err := w.g.parse(p); err != nil {
+				return err
+			}
+			markParentsUninteresting(p)
+		} else if i > 0 && w.opts.FirstParent {
+			break
+		}
+		if p.flags&seen != 0 {
+			continue
+		}
+		if err := w.g.parse(p); err != nil {
+			return err
+		}
+		p.flags |= seen
+		w.q.put(p)
+	}
+	return nil
+}
+
+func markParentsUninteresting(n *node) {
+	stack := append([]*node(nil), n.parents...)
+	for len(stack) > 0 {
+		cur := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for cur.flags&uninteresting == 0 {
+			cur.flags |= uninteresting
+			// commits not parsed yet get their parents marked when queued
+			if len(cur.parents) == 0 {
+				break
+			}
+			stack = append(stack, cur.parents[1:]...)
+			cur = cur.parents[0]
+		}
+	}
+}
+
+// Walks the whole range up front, so that commits found to be reachable
+// from a hidden commit late can still be left out
+func (w *Walker) limitList() error {
+	left := slop
+	date := int64(1<<63 - 1)
+	for w.q.Len() > 0 {
+		n := w.q.get()
+		if w.tooOld(n) {
+			n.flags |= uninteresting
+		}
+		if err := w.processParents(n); err != nil {
+			return err
+		}
+		if n.flags&uninteresting != 0 {
+			markParentsUninteresting(n)
+			if left = w.stillInteresting(date, left); left > 0 {
+				continue
+			}
+			break
+		}
+		if w.tooNew(n) {
+			continue
+		}
+		date = n.date()
+		w.list = append(w.list, n)
+	}
+	if w.opts.AncestryPath {
+		if len(w.bottoms) == 0 {
+			return ErrorNoBottoms
+		}
+		w.limitToAncestry()
+	}
+	return nil
+}
+
+func (w *Walker) stillInteresting(date int64, left int) int {
+	newest := w.q.peek()
+	if newest == nil {
+		return 0
+	}
+	if date <= newest.date() {
+		return slop
+	}
+	for _, item := range w.q.items {
+		if item.n.flags&uninteresting == 0 {
+			return slop
+		}
+	}
+	return left - 1
+}
+
+// Leaves out the commits from which no bottom commit can be reached
+func (w *Walker) limitToAncestry() {
+	for _, n := range w.bottoms {
+		n.flags |= ancestry
+	}
+	for progress := true; progress; {
+		progress = false
+		// oldest first, so that parents are mostly marked before children
+		for i := len(w.list) - 1; i >= 0; i -= 1 {
+			n := w.list[i]
+			if n.flags&(ancestry|uninteresting) != 0 {
+				continue
+			}
+			for _, p := range n.parents {
+				if p.flags&ancestry != 0 {
+					n.flags |= ancestry
+					progress = true
+					break
+				}
+			}
+		}
+	}
+	for _, n := range w.list {
+		if n.flags&ancestry == 0 {
+			n.flags |= uninteresting
+		}
+	}
+}
+
+// Reorders the list so that no commit comes before all of its children,
+// starting from the tips in list order
+func (w *Walker) sortTopologically() error {
+	for _, n := range w.list {
+		n.indegree = 1
+		// author dates are not in the commit graph
+		if w.opts.Sort == SortAuthorDate {
+			if err := w.g.load(n); err != nil {
+				return err
+			}
+		}
+	}
+	for _, n := range w.list {
+		for _, p := range w.sortedParents(n) {
+			if p.indegree != 0 {
+				p.indegree += 1
+			}
+		}
+	}
+	q := &queue{}
+	switch w.opts.Sort {
+	case SortDate:
+		q.less = func(a, b *node) bool { return a.date() > b.date() }
+	case SortAuthorDate:
+		q.less = func(a, b *node) bool { return a.authorDate() > b.authorDate() }
+	}
+	for _, n := range w.list {
+		if n.indegree == 1 {
+			q.put(n)
+		}
+	}
+	if q.less == nil {
+		q.reverse()
+	}
+	sorted := make([]*node, 0, len(w.list))
+	for n := q.get(); n != nil; n = q.get() {
+		for _, p := range w.sortedParents(n) {
+			if p.indegree == 0 {
+				continue
+			}
+			p.indegree -= 1
+			if p.indegree == 1 {
+				q.put(p)
+			}
+		}
+		n.indegree = 0
+		sorted = append(sorted, n)
+	}
+	w.list = sorted
+	return nil
+}
+
+// Parents a commit has to come before when sorting. Like git, which sorts
+// while walking when there is a commit graph, only the first parents count
+// then if the walk follows only them
+func (w *Walker) sortedParents(n *node) []*node {
+	if w.g.cg != nil && w.opts.FirstParent && len(n.parents) > 1 {
+		return n.parents[:1]
+	}
+	return n.parents
+}
@@ -0,0 +1,174 @@
+package revwalk_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/repr"
+	"github.com/magnickolas/gitok/revwalk"
+)
+
+// Commit of a test history: its dates are seconds after a fixed time
+type commitSpec struct {
+	name       string
+	date       int64
+	authorDate int64
+	parents    []string
+}
+
+// Writes the commits, with empty trees, and returns their digests by name
+func writeHistory(t *testing.T, db *fs.ObjectDB, specs []commitSpec) map[string]string {
+	tree := repr.NewTreeFromEntries(nil)
+	if err := db.WriteObject(tree); err != nil {
+		t.Fatal(err)
+	}
+	digests := map[string]string{}
+	for _, spec := range specs {
+		var parents []string
+		for _, p := range spec.parents {
+			parents = append(parents, digests[p])
+		}
+		author := repr.Signature{Name: "a", Email: "a@b", When: time.Unix(1700000000+spec.authorDate, 0).UTC()}
+		committer := repr.Signature{Name: "a", Email: "a@b", When: time.Unix(1700000000+spec.date, 0).UTC()}
+		commit := repr.NewCommitFromFields(tree.Digest(), parents, author, committer, spec.name+"\n")
+		if err := db.WriteObject(commit); err != nil {
+			t.Fatal(err)
+		}
+		digests[spec.name] = commit.Digest()
+	}
+	return digests
+}
+
+// Names of the digests of the history
+func commitNames(digests map[string]string, res []string) []string {
+	names := []string{}
+	for _, digest := range res {
+		for name, d := range digests {
+			if d == digest {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// c and d branch off b, e follows d and f merges e into c; c is
+// authored after e but committed before it
+var walkHistory = []commitSpec{
+	{name: "a", date: 100, authorDate: 100},
+	{name: "b", date: 200, authorDate: 200, parents: []string{"a"}},
+	{name: "c", date: 300, authorDate: 450, parents: []string{"b"}},
+	{name: "d", date: 250, authorDate: 250, parents: []string{"b"}},
+	{name: "e", date: 400, authorDate: 260, parents: []string{"d"}},
+	{name: "f", date: 500, authorDate: 500, parents: []string{"c", "e"}},
+}
+
+func TestWalk(t *testing.T) {
+	db := fs.OpenObjectDB(t.TempDir())
+	digests := writeHistory(t, db, walkHistory)
+	tests := []struct {
+		name string
+		push []string
+		hide []string
+		opts revwalk.Options
+		want []string
+	}{
+		{
+			name: "default",
+			push: []string{"f"},
+			opts: revwalk.Options{MaxCount: -1},
+			want: []string{"f", "e", "c", "d", "b", "a"},
+		},
+		{
+			name: "topo",
+			push: []string{"f"},
+			opts: revwalk.Options{Sort: revwalk.SortTopo, MaxCount: -1},
+			want: []string{"f", "e", "d", "c", "b", "a"},
+		},
+		{
+			name: "date",
+			push: []string{"f"},
+			opts: revwalk.Options{Sort: revwalk.SortDate, MaxCount: -1},
+			want: []string{"f", "e", "c", "d", "b", "a"},
+		},
+		{
+			name: "author date",
+			push: []string{"f"},
+			opts: revwalk.Options{Sort: revwalk.SortAuthorDate, MaxCount: -1},
+			want: []string{"f", "c", "e", "d", "b", "a"},
+		},
+		{
+			name: "reverse topo",
+			push: []string{"f"},
+			opts: revwalk.Options{Sort: revwalk.SortTopo, Reverse: true, MaxCount: -1},
+			want: []string{"a", "b", "c", "d", "e", "f"},
+		},
+		{
+			name: "first parent",
+			push: []string{"f"},
+			opts: revwalk.Options{FirstParent: true, MaxCount: -1},
+			want: []string{"f", "c", "b", "a"},
+		},
+		{
+			name: "skip and max count",
+			push: []string{"f"},
+			opts: revwalk.Options{MaxCount: 2, Skip: 1},
+			want: []string{"e", "c"},
+		},
+		{
+			name: "date with max count",
+			push: []string{"f"},
+			opts: revwalk.Options{Sort: revwalk.SortDate, MaxCount: 3},
+			want: []string{"f", "e", "c"},
+		},
+		{
+			name: "hidden side branch",
+			push: []string{"f"},
+			hide: []string{"c"},
+			opts: revwalk.Options{MaxCount: -1},
+			want: []string{"f", "e", "d"},
+		},
+		{
+			name: "hidden merged branch",
+			push: []string{"f"},
+			hide: []string{"e"},
+			opts: revwalk.Options{Sort: revwalk.SortTopo, MaxCount: -1},
+			want: []string{"f", "c"},
+		},
+		{
+			name: "several tips",
+			push: []string{"c", "e"},
+			opts: revwalk.Options{MaxCount: -1},
+			want: []string{"e", "c", "d", "b", "a"},
+		},
+		{
+			name: "several tips topo",
+			push: []string{"c", "e"},
+			opts: revwalk.Options{Sort: revwalk.SortTopo, MaxCount: -1},
+			want: []string{"e", "d", "c", "b", "a"},
+		},
+	}
+	for _, test := range tests {
+		w := revwalk.NewWalker(db, test.opts)
+		for _, name := range test.push {
+			if err := w.Push(digests[name]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, name := range test.hide {
+			if err := w.Hide(digests[name]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		res, err := w.All()
+		if err != nil {
+			t.Errorf("failed to walk %v: %v", test.name, err)
+			continue
+		}
+		if got := commitNames(digests, res); !slices.Equal(got, test.want) {
+			t.Errorf("incorrect result for %#v: wanted %#v, got %#v", test.name, test.want, got)
+		}
+	}
+}
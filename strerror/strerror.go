@@ -0,0 +1,18 @@
+package strerror
+
+import (
+	"errors"
+	"strings"
+	"syscall"
+)
+
+// The reason of a failed system call as C's strerror gives it, without the
+// operation and path Go adds to it. Other errors are returned unchanged
+func Describe(err error) error {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return err
+	}
+	msg := errno.Error()
+	return errors.New(strings.ToUpper(msg[:1]) + msg[1:])
+}
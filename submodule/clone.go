@@ -0,0 +1,202 @@
+package submodule
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/magnickolas/gitok/config"
+	"github.com/magnickolas/gitok/constants"
+	"github.com/magnickolas/gitok/refs"
+)
+
+var (
+	ErrorRepositoryNotFound       = errors.New("repository does not exist")
+	formatErrorRepositoryNotFound = func(url string) error {
+		return fmt.Errorf("%w: '%v'", ErrorRepositoryNotFound, url)
+	}
+)
+
+// Git directory of the local repository at url, bare or not
+func sourceGitDir(url string) (string, error) {
+	dir := strings.TrimPrefix(url, "file://")
+	if gitDir, err := GitDir(dir); err == nil {
+		return gitDir, nil
+	}
+	if _, err := os.Stat(filepath.Join(dir, constants.Objects)); err == nil {
+		return dir, nil
+	}
+	return "", formatErrorRepositoryNotFound(url)
+}
+
+// Clones the local repository at url into gitDir, with its worktree at dir
+// linked through a gitfile. Only the repository is set up, nothing is
+// checked out
+func Clone(url string, gitDir string, dir string) error {
+	for _, sub := range []string{constants.Objects, "refs/heads", "refs/tags"} {
+		if err := os.MkdirAll(filepath.Join(gitDir, filepath.FromSlash(sub)), os.ModePerm); err != nil {
+			return err
+		}
+	}
+	branch, err := Fetch(url, gitDir)
+	if err != nil {
+		return err
+	}
+	worktreeRel, err := relPath(gitDir, dir)
+	if err != nil {
+		return err
+	}
+	configPath := filepath.Join(gitDir, constants.Config)
+	settings := [][2]string{
+		{"core.repositoryformatversion", "0"},
+		{"core.filemode", "true"},
+		{"core.bare", "false"},
+		{"core.logallrefupdates", "true"},
+		{"core.worktree", worktreeRel},
+		{"remote.origin.url", url},
+		{"remote.origin.fetch", "+refs/heads/*:refs/remotes/origin/*"},
+	}
+	head := "ref: refs/heads/master\n"
+	if branch != "" {
+		settings = append(settings,
+			[2]string{"branch." + branch + ".remote", "origin"},
+			[2]string{"branch." + branch + ".merge", "refs/heads/" + branch},
+		)
+		digest, err := refs.OpenStore(gitDir).Resolve("refs/remotes/origin/" + branch)
+		if err != nil {
+			return err
+		}
+		if err := writeRef(gitDir, "refs/heads/"+branch, digest+"\n"); err != nil {
+			return err
+		}
+		head = "ref: refs/heads/" + branch + "\n"
+	}
+	for _, kv := range settings {
+		if err := config.SetInFile(configPath, kv[0], kv[1]); err != nil {
+			return err
+		}
+	}
+	if err := writeRef(gitDir, constants.Head, head); err != nil {
+		return err
+	}
+	return Link(gitDir, dir)
+}
+
+// Writes the gitfile making dir the worktree of the repository at gitDir
+func Link(gitDir string, dir string) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	rel, err := relPath(dir, gitDir)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, constants.Git), []byte("gitdir: "+rel+"\n"), 0644)
+}
+
+// Copies the objects of the local repository at url into gitDir, points
+// the origin remote-tracking branches at its branches and copies its tags.
+// Returns the branch the source HEAD is on, if any
+func Fetch(url string, gitDir string) (string, error) {
+	src, err := sourceGitDir(url)
+	if err != nil {
+		return "", err
+	}
+	if err := copyObjects(filepath.Join(src, constants.Objects), filepath.Join(gitDir, constants.Objects)); err != nil {
+		return "", err
+	}
+	store := refs.OpenStore(src)
+	// branches become remote-tracking branches, tags are kept as they are
+	for _, mapping := range [][2]string{{"refs/heads/", "refs/remotes/origin/"}, {"refs/tags/", "refs/tags/"}} {
+		list, err := store.List(mapping[0])
+		if err != nil {
+			return "", err
+		}
+		for _, ref := range list {
+			digest, err := store.Resolve(ref.Name)
+			if err != nil {
+				return "", err
+			}
+			name := mapping[1] + strings.TrimPrefix(ref.Name, mapping[0])
+			if err := writeRef(gitDir, name, digest+"\n"); err != nil {
+				return "", err
+			}
+		}
+	}
+	b, err := os.ReadFile(filepath.Join(src, constants.Head))
+	if err != nil {
+		return "", err
+	}
+	target, found := strings.CutPrefix(strings.TrimSpace(string(b)), "ref: refs/heads/")
+	if !found {
+		return "", nil
+	}
+	err = writeRef(gitDir, "refs/remotes/origin/HEAD", "ref: refs/remotes/origin/"+target+"\n")
+	return target, err
+}
+
+func writeRef(gitDir string, name string, content string) error {
+	p := filepath.Join(gitDir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(p, []byte(content), 0644)
+}
+
+// Copies the loose objects and packs missing from dst
+func copyObjects(src string, dst string) error {
+	return filepath.WalkDir(src, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, os.ModePerm)
+		}
+		if strings.HasPrefix(d.Name(), "tmp_") || filepath.Dir(rel) == "info" {
+			return nil
+		}
+		if _, err := os.Stat(target); err == nil {
+			return nil
+		}
+		return copyFile(p, target)
+	})
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0444)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Path of target relative to base, both being made absolute first
+func relPath(base string, target string) (string, error) {
+	absBase, err := filepath.Abs(base)
+	if err != nil {
+		return "", err
+	}
+	absTarget, err := filepath.Abs(target)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(absBase, absTarget)
+	return filepath.ToSlash(rel), err
+}
@@ -0,0 +1,82 @@
+package submodule
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/magnickolas/gitok/constants"
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/refs"
+	"github.com/magnickolas/gitok/repr"
+)
+
+var ErrorNotPopulated = errors.New("submodule not populated")
+
+// Git directory of the repository checked out at dir: either dir/.git or
+// the directory a "gitdir: <path>" file there points to
+func GitDir(dir string) (string, error) {
+	p := filepath.Join(dir, constants.Git)
+	info, err := os.Stat(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", ErrorNotPopulated
+	} else if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return p, nil
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+	gitDir, found := strings.CutPrefix(strings.TrimSpace(string(b)), "gitdir: ")
+	if !found {
+		return "", fmt.Errorf("invalid gitfile format: %v", p)
+	}
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(dir, gitDir)
+	}
+	return gitDir, nil
+}
+
+// Digest HEAD of the repository at gitDir points to
+func Head(gitDir string) (string, error) {
+	return refs.OpenStore(gitDir).Resolve(constants.Head)
+}
+
+// Human readable name of the commit in the repository at gitDir: a tag,
+// then a branch or remote-tracking branch pointing at it, falling back to
+// the abbreviated digest
+func Describe(gitDir string, digest string) (string, error) {
+	store := refs.OpenStore(gitDir)
+	db := fs.OpenObjectDB(filepath.Join(gitDir, constants.Objects))
+	for _, prefix := range []string{"refs/tags/", "refs/heads/", "refs/remotes/"} {
+		list, err := store.List(prefix)
+		if err != nil {
+			return "", err
+		}
+		for _, ref := range list {
+			name := ref.Name
+			target, err := store.Resolve(name)
+			if err != nil {
+				continue
+			}
+			if o, err := db.ReadObject(target); err == nil {
+				if tag, ok := o.(*repr.Tag); ok {
+					target = tag.Object()
+				}
+			}
+			if target != digest {
+				continue
+			}
+			if prefix == "refs/tags/" {
+				return strings.TrimPrefix(name, prefix), nil
+			}
+			return strings.TrimPrefix(name, "refs/"), nil
+		}
+	}
+	return digest[:7], nil
+}
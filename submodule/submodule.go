@@ -0,0 +1,116 @@
+package submodule
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/magnickolas/gitok/config/parser"
+)
+
+const Gitmodules = ".gitmodules"
+
+var (
+	ErrorInvalidGitmodules       = errors.New("invalid .gitmodules")
+	formatErrorInvalidGitmodules = func(format string, a ...any) error {
+		return fmt.Errorf("%w: %v", ErrorInvalidGitmodules, fmt.Sprintf(format, a...))
+	}
+)
+
+// Submodule as declared in .gitmodules
+type Submodule struct {
+	Name   string
+	Path   string
+	URL    string
+	Branch string
+	Update string
+}
+
+// Parses the submodules declared in a .gitmodules file, in the order of
+// their first appearance
+func Parse(r io.Reader) ([]Submodule, error) {
+	p, err := parser.NewParser(r)
+	if err != nil {
+		return nil, err
+	}
+	kvs, err := p.Parse()
+	if err != nil {
+		return nil, err
+	}
+	var res []*Submodule
+	byName := make(map[string]*Submodule)
+	for _, kv := range kvs {
+		rest, found := strings.CutPrefix(kv.Key, "submodule.")
+		if !found {
+			continue
+		}
+		i := strings.LastIndex(rest, ".")
+		if i == -1 {
+			continue
+		}
+		name, key := rest[:i], strings.ToLower(rest[i+1:])
+		if !isValidName(name) {
+			return nil, formatErrorInvalidGitmodules("ignoring suspicious submodule name: %v", name)
+		}
+		sm, ok := byName[name]
+		if !ok {
+			sm = &Submodule{Name: name}
+			byName[name] = sm
+			res = append(res, sm)
+		}
+		switch key {
+		case "path":
+			sm.Path = path.Clean(kv.Value)
+		case "url":
+			sm.URL = kv.Value
+		case "branch":
+			sm.Branch = kv.Value
+		case "update":
+			sm.Update = kv.Value
+		}
+	}
+	var submodules []Submodule
+	seenPaths := make(map[string]bool)
+	for _, sm := range res {
+		if sm.Path == "" {
+			continue
+		}
+		if strings.HasPrefix(sm.Path, "../") || sm.Path == ".." || path.IsAbs(sm.Path) {
+			return nil, formatErrorInvalidGitmodules("submodule path outside of the worktree: %v", sm.Path)
+		}
+		if seenPaths[sm.Path] {
+			return nil, formatErrorInvalidGitmodules("duplicate submodule path: %v", sm.Path)
+		}
+		seenPaths[sm.Path] = true
+		submodules = append(submodules, *sm)
+	}
+	return submodules, nil
+}
+
+// Submodule names become directory names under .git/modules
+func isValidName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, component := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if component == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// Submodules declared in the worktree's .gitmodules, if any
+func Read() ([]Submodule, error) {
+	f, err := os.Open(Gitmodules)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
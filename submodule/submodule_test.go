@@ -0,0 +1,66 @@
+package submodule_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/magnickolas/gitok/submodule"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    []submodule.Submodule
+		wantErr bool
+	}{
+		{
+			input: `[submodule "lib"]
+				path = vendor/lib/
+				url = ../lib.git
+				branch = main`,
+			want: []submodule.Submodule{
+				{Name: "lib", Path: "vendor/lib", URL: "../lib.git", Branch: "main"},
+			},
+		},
+		{
+			input: `[submodule "a"]
+				path = a
+				[core]
+				bare = false
+				[submodule "b"]
+				url = /srv/b
+				[submodule "A"]
+				path = A
+				URL = /srv/A`,
+			want: []submodule.Submodule{
+				{Name: "a", Path: "a"},
+				{Name: "A", Path: "A", URL: "/srv/A"},
+			},
+		},
+		{
+			input:   "[submodule \"../evil\"]\npath = x",
+			wantErr: true,
+		},
+		{
+			input:   "[submodule \"x\"]\npath = ../x",
+			wantErr: true,
+		},
+		{
+			input:   "[submodule \"x\"]\npath = p\n[submodule \"y\"]\npath = p",
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		got, err := submodule.Parse(strings.NewReader(test.input))
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("incorrect result for %#v: wanted an error, got %v", test.input, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("incorrect result for %#v: wanted %v, got %v (%v)", test.input, test.want, got, err)
+		}
+	}
+}
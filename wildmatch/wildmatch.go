@@ -0,0 +1,217 @@
+package wildmatch
+
+const (
+	// '*', '?' and bracket expressions do not match '/', while "**"
+	// surrounded by slashes matches any number of directories
+	Pathname = 1 << iota
+	CaseFold
+)
+
+// Reports whether text matches the shell-style pattern with git's
+// wildmatch semantics
+func Match(pattern string, text string, flags int) bool {
+	m := matcher{pattern: pattern, flags: flags}
+	return m.match(0, text)
+}
+
+type matcher struct {
+	pattern string
+	flags   int
+}
+
+func (m *matcher) pathname() bool {
+	return m.flags&Pathname != 0
+}
+
+func (m *matcher) match(pi int, t string) bool {
+	p := m.pattern
+	for pi < len(p) {
+		switch p[pi] {
+		case '*':
+			start := pi
+			for pi < len(p) && p[pi] == '*' {
+				pi += 1
+			}
+			doubleStar := pi-start >= 2 &&
+				(start == 0 || p[start-1] == '/') &&
+				(pi == len(p) || p[pi] == '/')
+			if !m.pathname() || doubleStar {
+				if doubleStar && pi < len(p) {
+					// "**/" also matches zero directories
+					if m.match(pi+1, t) {
+						return true
+					}
+				}
+				if pi == len(p) {
+					return true
+				}
+				for i := 0; i <= len(t); i += 1 {
+					if m.match(pi, t[i:]) {
+						return true
+					}
+				}
+				return false
+			}
+			for i := 0; i <= len(t); i += 1 {
+				if m.match(pi, t[i:]) {
+					return true
+				}
+				if i < len(t) && t[i] == '/' {
+					return false
+				}
+			}
+			return false
+		case '?':
+			if len(t) == 0 || (m.pathname() && t[0] == '/') {
+				return false
+			}
+			pi, t = pi+1, t[1:]
+		case '[':
+			if len(t) == 0 || (m.pathname() && t[0] == '/') {
+				return false
+			}
+			next, ok := m.matchClass(pi, t[0])
+			if next == -1 {
+				// unterminated bracket is a literal '['
+				if t[0] != '[' {
+					return false
+				}
+				pi, t = pi+1, t[1:]
+				continue
+			}
+			if !ok {
+				return false
+			}
+			pi, t = next, t[1:]
+		case '\\':
+			if pi+1 < len(p) {
+				pi += 1
+			}
+			fallthrough
+		default:
+			if len(t) == 0 || !m.equal(p[pi], t[0]) {
+				return false
+			}
+			pi, t = pi+1, t[1:]
+		}
+	}
+	return len(t) == 0
+}
+
+// Matches c against the bracket expression starting at pi, returning the
+// index after the expression or -1 if it is not terminated
+func (m *matcher) matchClass(pi int, c byte) (int, bool) {
+	p := m.pattern
+	i := pi + 1
+	negated := false
+	if i < len(p) && (p[i] == '!' || p[i] == '^') {
+		negated = true
+		i += 1
+	}
+	matched := false
+	first := true
+	for i < len(p) && (p[i] != ']' || first) {
+		first = false
+		if p[i] == '[' && i+1 < len(p) && p[i+1] == ':' {
+			end := indexFrom(p, ":]", i+2)
+			if end != -1 {
+				if matchNamedClass(p[i+2:end], c) {
+					matched = true
+				}
+				i = end + 2
+				continue
+			}
+		}
+		lo := p[i]
+		if lo == '\\' && i+1 < len(p) {
+			i += 1
+			lo = p[i]
+		}
+		i += 1
+		hi := lo
+		if i+1 < len(p) && p[i] == '-' && p[i+1] != ']' {
+			hi = p[i+1]
+			if hi == '\\' && i+2 < len(p) {
+				i += 1
+				hi = p[i+1]
+			}
+			i += 2
+		}
+		if (lo <= c && c <= hi) || (m.flags&CaseFold != 0 && lo <= toLower(c) && toLower(c) <= hi) ||
+			(m.flags&CaseFold != 0 && lo <= toUpper(c) && toUpper(c) <= hi) {
+			matched = true
+		}
+	}
+	if i >= len(p) {
+		return -1, false
+	}
+	return i + 1, matched != negated
+}
+
+func matchNamedClass(name string, c byte) bool {
+	switch name {
+	case "alnum":
+		return isAlpha(c) || isDigit(c)
+	case "alpha":
+		return isAlpha(c)
+	case "blank":
+		return c == ' ' || c == '\t'
+	case "cntrl":
+		return c < 0x20 || c == 0x7f
+	case "digit":
+		return isDigit(c)
+	case "graph":
+		return c > 0x20 && c < 0x7f
+	case "lower":
+		return 'a' <= c && c <= 'z'
+	case "print":
+		return c >= 0x20 && c < 0x7f
+	case "punct":
+		return c > 0x20 && c < 0x7f && !isAlpha(c) && !isDigit(c)
+	case "space":
+		return c == ' ' || ('\t' <= c && c <= '\r')
+	case "upper":
+		return 'A' <= c && c <= 'Z'
+	case "xdigit":
+		return isDigit(c) || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+	}
+	return false
+}
+
+func (m *matcher) equal(a byte, b byte) bool {
+	if m.flags&CaseFold != 0 {
+		return toLower(a) == toLower(b)
+	}
+	return a == b
+}
+
+func indexFrom(s string, sub string, from int) int {
+	for i := from; i+len(sub) <= len(s); i += 1 {
+		if s[i:i+len(sub)] == sub {
+			return i
+		}
+	}
+	return -1
+}
+
+func isAlpha(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func toLower(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+func toUpper(c byte) byte {
+	if 'a' <= c && c <= 'z' {
+		return c - 'a' + 'A'
+	}
+	return c
+}
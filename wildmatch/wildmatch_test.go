@@ -0,0 +1,44 @@
+package wildmatch_test
+
+import (
+	"testing"
+
+	"github.com/magnickolas/gitok/wildmatch"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		text    string
+		flags   int
+		want    bool
+	}{
+		{pattern: "foo", text: "foo", want: true},
+		{pattern: "foo", text: "bar", want: false},
+		{pattern: "*.c", text: "a/b.c", want: true},
+		{pattern: "*.c", text: "a/b.c", flags: wildmatch.Pathname, want: false},
+		{pattern: "a/*", text: "a/b/c", flags: wildmatch.Pathname, want: false},
+		{pattern: "a/**", text: "a/b/c", flags: wildmatch.Pathname, want: true},
+		{pattern: "**/c", text: "c", flags: wildmatch.Pathname, want: true},
+		{pattern: "**/c", text: "a/b/c", flags: wildmatch.Pathname, want: true},
+		{pattern: "a/**/c", text: "a/c", flags: wildmatch.Pathname, want: true},
+		{pattern: "a/**/c", text: "a/x/y/c", flags: wildmatch.Pathname, want: true},
+		{pattern: "a**c", text: "a/x/c", flags: wildmatch.Pathname, want: false},
+		{pattern: "?", text: "/", flags: wildmatch.Pathname, want: false},
+		{pattern: "[a-c]x", text: "bx", want: true},
+		{pattern: "[!a-c]x", text: "bx", want: false},
+		{pattern: "[]]", text: "]", want: true},
+		{pattern: "[[:digit:]]*", text: "1abc", want: true},
+		{pattern: "\\*", text: "*", want: true},
+		{pattern: "\\*", text: "x", want: false},
+		{pattern: "[", text: "[", want: true},
+		{pattern: "FOO", text: "foo", flags: wildmatch.CaseFold, want: true},
+	}
+	for _, test := range tests {
+		got := wildmatch.Match(test.pattern, test.text, test.flags)
+		if got != test.want {
+			t.Errorf("incorrect result for %#v against %#v: wanted %v, got %v",
+				test.pattern, test.text, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package worktree
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/magnickolas/gitok/fs"
+	"github.com/magnickolas/gitok/index"
+	"github.com/magnickolas/gitok/index/parser"
+	"github.com/magnickolas/gitok/repr"
+)
+
+// Writes the files of the tree under dir and returns the index entries
+// describing them, named relative to dir. Gitlinks become empty directories
+func CheckoutTree(db *fs.ObjectDB, treeDigest string, dir string) ([]parser.Entry, error) {
+	return checkout(db, treeDigest, dir, nil)
+}
+
+// Brings the files under dir from the index entries to the tree: the files
+// of entries the tree lacks or has otherwise are removed, and those of the
+// tree written unless an entry already has them. Returns the index entries
+// of the tree
+func ResetTree(db *fs.ObjectDB, entries []parser.Entry, treeDigest string, dir string) ([]parser.Entry, error) {
+	files := map[string]repr.TreeEntry{}
+	if err := readFiles(db, treeDigest, "", files); err != nil {
+		return nil, err
+	}
+	kept := map[string]parser.Entry{}
+	var stale []parser.Entry
+	for _, entry := range entries {
+		file, ok := files[entry.Name]
+		if ok && entry.Stage() == 0 && file.Digest == entry.Digest && file.Mode == entry.ObjectMode() {
+			kept[entry.Name] = entry
+		} else {
+			stale = append(stale, entry)
+		}
+	}
+	if err := RemoveEntries(dir, stale); err != nil {
+		return nil, err
+	}
+	return checkout(db, treeDigest, dir, kept)
+}
+
+// Files of the tree by their paths
+func readFiles(db *fs.ObjectDB, digest string, prefix string, files map[string]repr.TreeEntry) error {
+	tree, err := db.ReadTree(digest)
+	if err != nil {
+		return err
+	}
+	for _, entry := range tree.Entries() {
+		name := path.Join(prefix, entry.Name)
+		if entry.Mode == repr.ModeTree {
+			if err := readFiles(db, entry.Digest, name, files); err != nil {
+				return err
+			}
+			continue
+		}
+		files[name] = entry
+	}
+	return nil
+}
+
+// Checks out the tree but for the files of the kept entries, which are
+// already there
+func checkout(db *fs.ObjectDB, treeDigest string, dir string, kept map[string]parser.Entry) ([]parser.Entry, error) {
+	var entries []parser.Entry
+	var checkout func(digest string, prefix string) error
+	checkout = func(digest string, prefix string) error {
+		tree, err := db.ReadTree(digest)
+		if err != nil {
+			return err
+		}
+		for _, entry := range tree.Entries() {
+			name := path.Join(prefix, entry.Name)
+			p := filepath.Join(dir, filepath.FromSlash(name))
+			if k, ok := kept[name]; ok {
+				entries = append(entries, k)
+				continue
+			}
+			switch entry.Mode {
+			case repr.ModeTree:
+				if err := os.MkdirAll(p, os.ModePerm); err != nil {
+					return err
+				}
+				if err := checkout(entry.Digest, name); err != nil {
+					return err
+				}
+				continue
+			case repr.ModeGitlink:
+				if err := os.MkdirAll(p, os.ModePerm); err != nil {
+					return err
+				}
+				entries = append(entries, index.NewEntry(name, nil, entry.Mode, entry.Digest))
+				continue
+			}
+			if err := checkoutBlob(db, entry, p); err != nil {
+				return err
+			}
+			info, err := os.Lstat(p)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, index.NewEntry(name, info, entry.Mode, entry.Digest))
+		}
+		return nil
+	}
+	if err := checkout(treeDigest, ""); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func checkoutBlob(db *fs.ObjectDB, entry repr.TreeEntry, p string) error {
+	_, content, err := db.ReadRawObject(entry.Digest)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if entry.Mode == repr.ModeSymbolicLink {
+		return os.Symlink(string(content), p)
+	}
+	perm := os.FileMode(0644)
+	if entry.Mode == repr.ModeExecutable {
+		perm = 0755
+	}
+	return os.WriteFile(p, content, perm)
+}
+
+// Removes the files of the entries under dir along with the directories
+// left empty
+func RemoveEntries(dir string, entries []parser.Entry) error {
+	for _, entry := range entries {
+		p := filepath.Join(dir, filepath.FromSlash(entry.Name))
+		if entry.ObjectMode() == repr.ModeGitlink {
+			// never delete a submodule's content
+			os.Remove(p)
+		} else if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for name := entry.Name; strings.Contains(name, "/"); {
+			name = name[:strings.LastIndex(name, "/")]
+			if os.Remove(filepath.Join(dir, filepath.FromSlash(name))) != nil {
+				break
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package worktree
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/magnickolas/gitok/constants"
+	"github.com/magnickolas/gitok/convert"
+	"github.com/magnickolas/gitok/ignore"
+	"github.com/magnickolas/gitok/index/parser"
+	"github.com/magnickolas/gitok/repr"
+	"github.com/magnickolas/gitok/submodule"
+)
+
+type Status int
+
+const (
+	Unchanged Status = iota
+	Modified
+	Deleted
+)
+
+// Mode the file would get in a tree or the index
+func FileMode(info fs.FileInfo) repr.ObjectModeType {
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		return repr.ModeSymbolicLink
+	case info.IsDir():
+		return repr.ModeTree
+	case info.Mode()&0111 != 0:
+		return repr.ModeExecutable
+	}
+	return repr.ModeNormal
+}
+
+// Blob for the worktree file: its content converted to the repository form
+// or, for symlinks, the link target. conv may be nil to skip filtering
+func ReadBlob(path string, info fs.FileInfo, conv *convert.Converter) (*repr.Blob, error) {
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, err
+		}
+		return repr.NewBlob(strings.NewReader(filepath.ToSlash(target)))
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if conv != nil {
+		content, err = conv.ToGit(filepath.ToSlash(path), content)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return repr.NewBlob(bytes.NewReader(content))
+}
+
+// Digest the worktree file would get as a blob. Files that need no
+// filtering are hashed without reading them into memory
+func HashFile(path string, info fs.FileInfo, conv *convert.Converter) (string, error) {
+	if info.Mode().IsRegular() {
+		filtered := false
+		if conv != nil {
+			var err error
+			filtered, err = conv.WouldConvertToGit(filepath.ToSlash(path))
+			if err != nil {
+				return "", err
+			}
+		}
+		if !filtered {
+			f, err := os.Open(path)
+			if err != nil {
+				return "", err
+			}
+			defer f.Close()
+			return repr.HashStream("blob", info.Size(), f)
+		}
+	}
+	blob, err := ReadBlob(path, info, conv)
+	if err != nil {
+		return "", err
+	}
+	return blob.Digest(), nil
+}
+
+// Compares the worktree file with its index entry. Cheap stat data is
+// checked first and the content is only hashed when it is inconclusive.
+// conv may be nil to compare unfiltered content
+func CheckEntry(entry *parser.Entry, trustFileMode bool, conv *convert.Converter) (Status, error) {
+	path := filepath.FromSlash(entry.Name)
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+		return Deleted, nil
+	} else if err != nil {
+		return Unchanged, err
+	}
+	entryMode := entry.ObjectMode()
+	if entryMode == repr.ModeGitlink {
+		return checkGitlink(path, info, entry.Digest)
+	}
+	mode := FileMode(info)
+	if mode == repr.ModeTree {
+		return Deleted, nil
+	}
+	if !trustFileMode && mode != repr.ModeSymbolicLink && entryMode != repr.ModeSymbolicLink {
+		mode = entryMode
+	}
+	if mode != entryMode {
+		return Modified, nil
+	}
+	mtime := info.ModTime()
+	if info.Size() == int64(uint32(entry.Size)) &&
+		mtime.Unix() == int64(uint32(entry.MTime)) && mtime.Nanosecond() == int(uint32(entry.MTimeNS)) {
+		return Unchanged, nil
+	}
+	digest, err := HashFile(path, info, conv)
+	if errors.Is(err, repr.ErrorSizeNotMatch) {
+		// the file changed while being read
+		return Modified, nil
+	} else if err != nil {
+		return Unchanged, err
+	}
+	if digest != entry.Digest {
+		return Modified, nil
+	}
+	return Unchanged, nil
+}
+
+// A submodule is modified when its HEAD moved away from the recorded commit;
+// an unpopulated one is left alone
+func checkGitlink(path string, info fs.FileInfo, digest string) (Status, error) {
+	if !info.IsDir() {
+		return Modified, nil
+	}
+	gitDir, err := submodule.GitDir(path)
+	if errors.Is(err, submodule.ErrorNotPopulated) {
+		return Unchanged, nil
+	} else if err != nil {
+		return Unchanged, err
+	}
+	head, err := submodule.Head(gitDir)
+	if err != nil || head != digest {
+		return Modified, nil
+	}
+	return Unchanged, nil
+}
+
+// Worktree files not present in the index, sorted; nested repositories are
+// reported as a single "dir/" entry. With a non-nil matcher ignored paths
+// are skipped
+func Untracked(entries []parser.Entry, matcher *ignore.Matcher) ([]string, error) {
+	tracked := make(map[string]bool)
+	trackedDirs := make(map[string]bool)
+	for _, entry := range entries {
+		tracked[entry.Name] = true
+		for dir := entry.Name; strings.Contains(dir, "/"); {
+			dir = dir[:strings.LastIndex(dir, "/")]
+			trackedDirs[dir] = true
+		}
+	}
+	var res []string
+	err := filepath.WalkDir(".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == "." {
+			return nil
+		}
+		name := filepath.ToSlash(path)
+		if d.IsDir() {
+			if d.Name() == constants.Git {
+				return filepath.SkipDir
+			}
+			if tracked[name] {
+				// gitlink
+				return filepath.SkipDir
+			}
+			if matcher != nil {
+				ignored, err := matcher.IsIgnored(name, true)
+				if err != nil {
+					return err
+				}
+				if ignored {
+					return filepath.SkipDir
+				}
+			}
+			if !trackedDirs[name] && isNestedRepo(path) {
+				res = append(res, name+"/")
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if tracked[name] {
+			return nil
+		}
+		if matcher != nil {
+			ignored, err := matcher.IsIgnored(name, false)
+			if err != nil {
+				return err
+			}
+			if ignored {
+				return nil
+			}
+		}
+		res = append(res, name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(res)
+	return res, nil
+}
+
+func isNestedRepo(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, constants.Git))
+	return err == nil
+}